@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"sandbox/pkg/sbxclient"
+
+	"github.com/gorilla/websocket"
+)
+
+// runAttach streams pod logs and exec events concurrently, printing each line with its
+// source. All output goes through a single printer so lines never interleave mid-line.
+// When execID is set it returns that exec's exit code once it exits; otherwise it runs
+// until interrupted. If the event stream fails or closes first it returns 1.
+func runAttach(client *sbxclient.Client, baseURL, id, execID string) int {
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(sigCtx)
+	defer cancel()
+
+	lines := make(chan string, 256)
+	exited := make(chan int, 1)
+	failed := make(chan error, 1)
+	emit := func(line string) bool {
+		select {
+		case lines <- line:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		attachLogs(ctx, client, id, emit)
+	}()
+	go func() {
+		defer wg.Done()
+		if err := attachEvents(ctx, baseURL, id, execID, emit, exited); err != nil && ctx.Err() == nil {
+			failed <- err
+		}
+	}()
+
+	exitCode := 0
+	done := false
+	for !done {
+		select {
+		case line := <-lines:
+			fmt.Println(line)
+		case code := <-exited:
+			exitCode = code
+			done = true
+		case err := <-failed:
+			fmt.Fprintln(os.Stderr, "attach: "+err.Error())
+			exitCode = 1
+			done = true
+		case <-ctx.Done():
+			exitCode = 130
+			done = true
+		}
+	}
+	cancel()
+	for drained := false; !drained; {
+		select {
+		case line := <-lines:
+			fmt.Println(line)
+		default:
+			drained = true
+		}
+	}
+	wg.Wait()
+	return exitCode
+}
+
+func attachLogs(ctx context.Context, client *sbxclient.Client, id string, emit func(string) bool) {
+	logs, err := client.Logs(ctx, id, true)
+	if err != nil {
+		if ctx.Err() == nil {
+			emit("[logs] error: " + err.Error())
+		}
+		return
+	}
+	defer logs.Close()
+	go func() {
+		<-ctx.Done()
+		logs.Close()
+	}()
+	sc := bufio.NewScanner(logs)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if !emit("[logs] " + sc.Text()) {
+			return
+		}
+	}
+}
+
+// attachEvents prints exec events until execID exits, when it sends the exit code
+// on exited and returns nil. It returns an error if the stream can't be opened or
+// ends before that, which without an execID is whenever it ends.
+func attachEvents(ctx context.Context, baseURL, id, execID string, emit func(string) bool, exited chan<- int) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, streamWSURL(baseURL, id, execID, false), nil)
+	if err != nil {
+		return fmt.Errorf("event stream: %w", err)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	// Output chunks are not line-aligned; hold partial lines per exec stream.
+	partial := map[string]string{}
+	flush := func(key string) {
+		if rest := partial[key]; rest != "" {
+			emit(fmt.Sprintf("[%s] %s", key, rest))
+			delete(partial, key)
+		}
+	}
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if execID != "" {
+				return fmt.Errorf("event stream closed before exec %s exited: %w", execID, err)
+			}
+			return fmt.Errorf("event stream closed: %w", err)
+		}
+		var evt struct {
+			Type     string `json:"type"`
+			ExecID   string `json:"exec_id"`
+			Stream   string `json:"stream"`
+			Data     string `json:"data"`
+			ExitCode int    `json:"exit_code"`
+			Dropped  int64  `json:"dropped"`
+		}
+		if err := json.Unmarshal(msg, &evt); err != nil {
+			continue
+		}
+		key := fmt.Sprintf("exec %s %s", evt.ExecID, evt.Stream)
+		switch evt.Type {
+		case "output":
+			data := partial[key] + evt.Data
+			for {
+				line, rest, ok := strings.Cut(data, "\n")
+				if !ok {
+					break
+				}
+				if !emit(fmt.Sprintf("[%s] %s", key, line)) {
+					return nil
+				}
+				data = rest
+			}
+			partial[key] = data
+		case "gap":
+			emit(fmt.Sprintf("[exec] %d events dropped", evt.Dropped))
+		case "exit":
+			flush(fmt.Sprintf("exec %s stdout", evt.ExecID))
+			flush(fmt.Sprintf("exec %s stderr", evt.ExecID))
+			emit(fmt.Sprintf("[exec %s] exited with code %d", evt.ExecID, evt.ExitCode))
+			if execID != "" && evt.ExecID == execID {
+				exited <- evt.ExitCode
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/pkg/sbxclient"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeControlPlane serves an empty log stream and an event stream that sends
+// events and then closes.
+func fakeControlPlane(t *testing.T, events ...string) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/logs") {
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, evt := range events {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(evt)); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAttachEventsClosedStream(t *testing.T) {
+	srv := fakeControlPlane(t, `{"type":"output","exec_id":"e1","stream":"stdout","data":"hello\n"}`)
+	var lines []string
+	emit := func(line string) bool { lines = append(lines, line); return true }
+	exited := make(chan int, 1)
+	err := attachEvents(context.Background(), srv.URL, "sbx-a", "e1", emit, exited)
+	if err == nil || !strings.Contains(err.Error(), "before exec e1 exited") {
+		t.Fatalf("err = %v, want the stream closing before the exit", err)
+	}
+	if len(lines) != 1 || lines[0] != "[exec e1 stdout] hello" {
+		t.Fatalf("lines = %q", lines)
+	}
+}
+
+func TestAttachEventsExit(t *testing.T) {
+	srv := fakeControlPlane(t, `{"type":"exit","exec_id":"e1","exit_code":3}`)
+	exited := make(chan int, 1)
+	if err := attachEvents(context.Background(), srv.URL, "sbx-a", "e1", func(string) bool { return true }, exited); err != nil {
+		t.Fatal(err)
+	}
+	if code := <-exited; code != 3 {
+		t.Fatalf("exit code = %d, want 3", code)
+	}
+}
+
+func TestRunAttachReturnsWhenStreamCloses(t *testing.T) {
+	srv := fakeControlPlane(t)
+	got := make(chan int, 1)
+	go func() { got <- runAttach(sbxclient.New(srv.URL), srv.URL, "sbx-a", "e1") }()
+	select {
+	case code := <-got:
+		if code != 1 {
+			t.Fatalf("exit code = %d, want 1", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("attach kept waiting after the event stream closed")
+	}
+}
+
+func TestAttachLogsPrefixesLines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sandboxes/sbx-a/logs" || r.URL.Query().Get("follow") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("booting\nready\n"))
+	}))
+	defer srv.Close()
+	var lines []string
+	attachLogs(context.Background(), sbxclient.New(srv.URL), "sbx-a", func(line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	if strings.Join(lines, "|") != "[logs] booting|[logs] ready" {
+		t.Fatalf("lines = %q", lines)
+	}
+}
@@ -5,8 +5,14 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -14,6 +20,7 @@ import (
 	"sandbox/pkg/sbxclient"
 
 	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
 )
 
 const defaultBaseURL = "http://localhost:8080"
@@ -25,35 +32,115 @@ func main() {
 	}
 
 	cmd := os.Args[1]
+	args := os.Args[2:]
+	if (cmd == "admin" || cmd == "warm-pool") && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = cmd+" "+args[0], args[1:]
+	}
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 	baseURL := fs.String("addr", defaultBaseURL, "control-plane base URL")
+	token := fs.String("token", os.Getenv("SBX_TOKEN"), "bearer token (default $SBX_TOKEN)")
 	id := fs.String("id", "", "sandbox id")
+	selector := fs.String("selector", "", "exec: run in every sandbox matching this label selector; status: list only matching sandboxes")
+	state := fs.String("state", "", "status: list only sandboxes in this namespace phase (Active|Terminating)")
 	image := fs.String("image", "", "sandbox image")
 	volumeMode := fs.String("volume", "", "volume mode: emptydir|pvc")
-	cacheMode := fs.String("cache-mode", "", "cache mode: emptydir|hostpath|pvc")
+	cacheMode := fs.String("cache-mode", "", "cache mode: emptydir|hostpath|pvc|shared-pvc")
 	cachePVCSize := fs.String("cache-pvc-size", "", "cache pvc size (e.g. 5Gi)")
 	cachePVCStorageClass := fs.String("cache-pvc-storage-class", "", "cache pvc storage class")
 	cachePVCAccessMode := fs.String("cache-pvc-access-mode", "", "cache pvc access mode (ReadWriteOnce/ReadWriteMany/ReadOnlyMany)")
+	workspacePath := fs.String("workspace-path", "", "workspace mount path (default /workspace)")
+	cachePath := fs.String("cache-path", "", "cache mount path (default /cache)")
+	hostname := fs.String("hostname", "", "sandbox pod hostname")
+	subdomain := fs.String("subdomain", "", "sandbox pod subdomain (creates a headless service)")
+	metricsPort := fs.Int("metrics-port", 0, "create: port the sandbox serves Prometheus metrics on (adds a scrape Service)")
+	metricsPath := fs.String("metrics-path", "", "create: metrics path with -metrics-port (default /metrics)")
+	fromFile := fs.String("from-file", "", "create: read the create request from a JSON or YAML file, e.g. from export (-id, -image and -env override it)")
+	shareProcessNamespace := fs.Bool("share-process-namespace", false, "create: share one PID namespace across the pod's containers")
+	annotate := fs.Bool("annotate", false, "label: set annotations instead of labels")
+	spread := fs.String("spread", "", "create: true|false to override the server's node spreading")
+	keep := fs.Bool("keep", false, "oneshot: keep the sandbox instead of deleting it")
+	wait := fs.Bool("wait", false, "create: block until the sandbox is ready; exec-status: block until the exec finishes")
+	waitTimeout := fs.Duration("wait-timeout", 60*time.Second, "create, exec-status: how long -wait blocks")
 	var envVars stringSlice
 	var allowHosts stringSlice
 	var denyHosts stringSlice
+	var envFromSecrets stringSlice
+	var envFromConfigMaps stringSlice
+	var mounts stringSlice
 	fs.Var(&envVars, "env", "environment variable (KEY=VALUE), repeatable")
 	fs.Var(&allowHosts, "allow-host", "allowed host (repeatable)")
+	fs.Var(&mounts, "mount", "extra volume NAME:PATH (emptyDir) or NAME:PATH:PVC (existing claim), repeatable")
 	fs.Var(&denyHosts, "deny-host", "disallowed host (repeatable)")
+	fs.Var(&envFromSecrets, "env-from-secret", "secret in the sandbox namespace to load env from (repeatable)")
+	fs.Var(&envFromConfigMaps, "env-from-configmap", "configmap in the sandbox namespace to load env from (repeatable)")
+	priorityClass := fs.String("priority-class", "", "sandbox pod priority class")
+	dnsPolicy := fs.String("dns-policy", "", "sandbox pod DNS policy: Default|ClusterFirst|None")
+	var dnsServers stringSlice
+	var readinessGates stringSlice
+	fs.Var(&readinessGates, "readiness-gate", "create: pod condition type that must be True before the sandbox is ready (repeatable)")
+	startupProbeCmd := fs.String("startup-probe-cmd", "", "create: command (space-separated) that succeeds once the image has booted")
+	gitURL := fs.String("git-url", "", "create: http(s) repository to clone into the workspace before the sandbox starts")
+	gitRef := fs.String("git-ref", "", "create: branch, tag or commit to check out (default: the remote HEAD)")
+	gitDepth := fs.Int("git-depth", 0, "create: shallow clone depth; 0 fetches full history")
+	gitSecret := fs.String("git-secret", "", "create: secret with password (or token) and optional username keys for the clone")
+	startupProbeFailures := fs.Int("startup-probe-failures", 0, "create: startup probe attempts, 5s apart, before the container is restarted (default 60)")
+	fs.Var(&dnsServers, "dns-server", "nameserver IP for the sandbox pod (repeatable)")
+	restartPolicy := fs.String("restart", "", "sandbox pod restart policy: Always|OnFailure|Never")
+	deadline := fs.Duration("deadline", 0, "create: kill the sandbox pod after this long (activeDeadlineSeconds)")
+	idleTTL := fs.String("idle-ttl", "", "create: reap the sandbox after this long without an exec instead of SANDBOX_IDLE_TTL; 0 never")
+	expiresAt := fs.String("expires-at", "", "create: delete the sandbox at this RFC 3339 time, in use or not")
 	command := fs.String("cmd", "", "command to exec (space-separated)")
+	shell := fs.String("sh", "", "shell command to exec via bash -lc (pipes, globs, etc.)")
+	scriptFile := fs.String("script", "", "script file to run in the sandbox (- for stdin)")
+	scriptShell := fs.String("script-shell", "", "interpreter for -script (default bash)")
 	syncMode := fs.Bool("sync", false, "run exec synchronously (block until completion)")
+	queue := fs.Bool("queue", false, "queue the exec until the sandbox is ready")
+	ordered := fs.Bool("ordered", false, "with -sync, interleave stdout and stderr in arrival order")
+	cached := fs.Bool("cache", false, "with -sync, reuse the result of an identical recent successful exec")
+	detachAfter := fs.Duration("detach-after", 0, "with -sync, return an exec id instead if the exec runs longer than this")
 	stream := fs.Bool("stream", false, "stream exec output after starting")
 	streamRaw := fs.Bool("stream-raw", false, "stream only stdout/stderr (no JSON)")
 	execID := fs.String("exec-id", "", "exec id")
+	signal := fs.String("signal", "SIGINT", "signal for exec-signal")
 	timeoutSeconds := fs.Int("timeout", 0, "exec timeout in seconds")
-	fs.Parse(os.Args[2:])
+	showOutput := fs.Bool("output", false, "exec-status: include the tail of stdout/stderr")
+	warmMin := fs.Int("min", -1, "warm-pool resize: autosize minimum")
+	warmMax := fs.Int("max", -1, "warm-pool resize: autosize maximum")
+	outputFormat := fs.String("o", "", "metrics, plan: output format, table|json")
+	every := fs.Duration("every", time.Minute, "keepalive: how often to touch the sandbox; 0 touches once")
+	dryRun := fs.Bool("dry-run", false, "admin reap: list what would be reaped without deleting it")
+	force := fs.Bool("force", false, "delete: remove namespace finalizers if the namespace is stuck terminating (admin token)")
+	keepNamespace := fs.Bool("keep-namespace", false, "delete: delete only the pod, keeping the namespace for a post-mortem")
+	fs.Parse(args)
 
-	client := sbxclient.New(*baseURL)
+	var opts []sbxclient.Option
+	if *token != "" {
+		opts = append(opts, sbxclient.WithToken(*token))
+	}
+	client := sbxclient.New(*baseURL, opts...)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	switch cmd {
-	case "create":
+	createRequest := func() api.CreateSandboxRequest {
+		if *fromFile != "" {
+			req, err := readCreateRequest(*fromFile)
+			fatalIf(err)
+			if *id != "" {
+				req.ID = *id
+			}
+			if *image != "" {
+				req.Image = *image
+			}
+			envMap, err := parseEnvPairs(envVars)
+			fatalIf(err)
+			for k, v := range envMap {
+				if req.Env == nil {
+					req.Env = map[string]string{}
+				}
+				req.Env[k] = v
+			}
+			return req
+		}
 		req := api.CreateSandboxRequest{
 			ID:                   *id,
 			Image:                *image,
@@ -62,36 +149,167 @@ func main() {
 			CachePVCSize:         *cachePVCSize,
 			CachePVCStorageClass: *cachePVCStorageClass,
 			CachePVCAccessMode:   *cachePVCAccessMode,
+			WorkspacePath:        *workspacePath,
+			CachePath:            *cachePath,
+			Hostname:             *hostname,
+			Subdomain:            *subdomain,
 			AllowedHosts:         allowHosts,
 			DisallowedHosts:      denyHosts,
+			EnvFromSecret:        envFromSecrets,
+			EnvFromConfigMap:     envFromConfigMaps,
+			RestartPolicy:        *restartPolicy,
+			PriorityClassName:    *priorityClass,
+			DNSPolicy:            *dnsPolicy,
+			DNSServers:           dnsServers,
+			ReadinessGates:       readinessGates,
 		}
 		envMap, err := parseEnvPairs(envVars)
 		fatalIf(err)
 		req.Env = envMap
-		resp, err := client.Create(ctx, req)
+		req.Volumes, err = parseMounts(mounts)
+		fatalIf(err)
+		if *shareProcessNamespace {
+			req.ShareProcessNamespace = shareProcessNamespace
+		}
+		if *metricsPort != 0 {
+			req.Metrics = &api.Metrics{Port: int32(*metricsPort), Path: *metricsPath}
+		}
+		if *gitURL != "" {
+			req.GitRepo = &api.GitRepo{URL: *gitURL, Ref: *gitRef, Depth: *gitDepth, Secret: *gitSecret}
+		}
+		if *startupProbeCmd != "" {
+			req.StartupProbe = &api.StartupProbe{Command: strings.Fields(*startupProbeCmd), FailureThreshold: int32(*startupProbeFailures)}
+		}
+		if *deadline > 0 {
+			secs := int64(deadline.Seconds())
+			if secs < 1 {
+				fatal("-deadline must be at least 1s")
+			}
+			req.ActiveDeadlineSeconds = &secs
+		}
+		req.IdleTTL = *idleTTL
+		req.ExpiresAt = *expiresAt
+		if *spread != "" {
+			v, err := strconv.ParseBool(*spread)
+			if err != nil {
+				fatal("-spread must be true or false")
+			}
+			req.Spread = &v
+		}
+		return req
+	}
+
+	switch cmd {
+	case "create":
+		req := createRequest()
+		var resp *api.CreateSandboxResponse
+		var err error
+		if *wait {
+			waitCtx, waitCancel := context.WithTimeout(context.Background(), *waitTimeout+30*time.Second)
+			defer waitCancel()
+			resp, err = client.CreateWait(waitCtx, req, *waitTimeout)
+		} else {
+			resp, err = client.Create(ctx, req)
+		}
 		fatalIf(err)
 		fmt.Printf("id=%s namespace=%s pod=%s\n", resp.ID, resp.Namespace, resp.PodName)
+	case "plan":
+		if *outputFormat != "" && *outputFormat != "table" && *outputFormat != "json" {
+			fatal("-o must be table or json")
+		}
+		plan, err := client.Plan(ctx, createRequest())
+		fatalIf(err)
+		if *outputFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			fatalIf(enc.Encode(plan))
+			return
+		}
+		printPlan(plan)
 	case "exec":
-		if *id == "" {
-			fatal("-id is required")
+		if *id == "" && *selector == "" {
+			fatal("-id or -selector is required")
 		}
 		args := fs.Args()
 		if len(args) == 0 && *command != "" {
 			args = strings.Fields(*command)
 		}
-		if len(args) == 0 {
-			fatal("-cmd is required")
+		if *shell != "" && len(args) > 0 {
+			fatal("-sh cannot be combined with -cmd or args")
+		}
+		var script string
+		if *scriptFile != "" {
+			if *shell != "" || len(args) > 0 {
+				fatal("-script cannot be combined with -sh, -cmd or args")
+			}
+			var data []byte
+			var err error
+			if *scriptFile == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(*scriptFile)
+			}
+			fatalIf(err)
+			script = string(data)
+		}
+		if len(args) == 0 && *shell == "" && script == "" {
+			fatal("-cmd, -sh or -script is required")
 		}
 		async := !*syncMode
 		if *stream || *streamRaw {
 			async = true
 		}
-		req := api.ExecRequest{Command: args, Async: &async}
+		req := api.ExecRequest{Command: args, Shell: *shell, Script: script, ScriptShell: *scriptShell, Async: &async}
 		if *timeoutSeconds > 0 {
 			req.TimeoutSeconds = timeoutSeconds
 		}
-		resp, err := client.Exec(ctx, *id, req)
+		if *selector != "" {
+			if *id != "" || *queue || *ordered || *stream || *streamRaw {
+				fatal("-selector cannot be combined with -id, -queue, -ordered or streaming")
+			}
+			resp, err := client.BulkExec(ctx, *selector, req)
+			fatalIf(err)
+			printBulkExec(resp)
+			return
+		}
+		if !async && *ordered {
+			resp, err := client.ExecOrdered(ctx, *id, req)
+			fatalIf(err)
+			for _, chunk := range resp.Chunks {
+				if chunk.Stream == "stderr" {
+					fmt.Fprint(os.Stderr, chunk.Data)
+				} else {
+					fmt.Print(chunk.Data)
+				}
+			}
+			return
+		}
+		execFn := client.Exec
+		if *queue {
+			if !async {
+				fatal("-queue cannot be combined with -sync")
+			}
+			execFn = client.ExecQueued
+		}
+		if *cached {
+			if async || *ordered {
+				fatal("-cache requires -sync and cannot be combined with -ordered")
+			}
+			execFn = client.ExecCached
+		}
+		if *detachAfter > 0 {
+			if async || *ordered || *cached {
+				fatal("-detach-after requires -sync and cannot be combined with -ordered or -cache")
+			}
+			execFn = func(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+				return client.ExecDetachable(ctx, id, req, *detachAfter)
+			}
+		}
+		resp, err := execFn(ctx, *id, req)
 		fatalIf(err)
+		if resp.Detached {
+			fmt.Fprintf(os.Stderr, "exec still running after %s; follow it with sbx exec-status -id %s -exec-id %s -wait\n", *detachAfter, *id, resp.ExecID)
+		}
 		if resp.ExecID != "" {
 			fmt.Printf("exec_id=%s status=%s\n", resp.ExecID, resp.Status)
 			if *stream || *streamRaw {
@@ -103,14 +321,30 @@ func main() {
 		if resp.Stderr != "" {
 			fmt.Fprint(os.Stderr, resp.Stderr)
 		}
+	case "oneshot":
+		args := fs.Args()
+		if len(args) == 0 && *command != "" {
+			args = strings.Fields(*command)
+		}
+		if len(args) == 0 && *shell == "" {
+			fatal("a command after --, -cmd or -sh is required")
+		}
+		if *shell != "" && len(args) > 0 {
+			fatal("-sh cannot be combined with -cmd or args")
+		}
+		req := api.ExecRequest{Command: args, Shell: *shell}
+		if *timeoutSeconds > 0 {
+			req.TimeoutSeconds = timeoutSeconds
+		}
+		os.Exit(runOneshot(client, *baseURL, createRequest(), req, *keep))
 	case "status":
 		if *id == "" {
-			resp, err := client.ListSandboxes(ctx)
+			resp, err := client.ListAllSandboxes(ctx, sbxclient.ListOptions{Limit: 500, Selector: *selector, State: *state})
 			fatalIf(err)
 			w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
-			fmt.Fprintln(w, "ID\tAGE\tSTATE\tALLOCATED\tLAST_EXEC_TIME")
+			fmt.Fprintln(w, "ID\tAGE\tSTATE\tALLOCATED\tREADY_AT\tLAST_EXEC_TIME")
 			for _, s := range resp {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.Age, s.State, s.Allocated, s.LastExecTime)
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Age, s.State, s.Allocated, s.ReadyAt, s.LastExecTime)
 			}
 			_ = w.Flush()
 			return
@@ -124,8 +358,188 @@ func main() {
 		if *id == "" {
 			fatal("-id is required")
 		}
-		fatalIf(client.Delete(ctx, *id))
+		if *force && *keepNamespace {
+			fatal("-force and -keep-namespace cannot be combined")
+		}
+		if *keepNamespace {
+			fatalIf(client.DeleteKeepNamespace(ctx, *id))
+		} else if *force {
+			// The server waits for the namespace before removing finalizers.
+			forceCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+			fatalIf(client.ForceDelete(forceCtx, *id))
+		} else {
+			fatalIf(client.Delete(ctx, *id))
+		}
 		fmt.Println("deleted")
+	case "keepalive":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		if *every <= 0 {
+			fatalIf(client.Touch(ctx, *id))
+			fmt.Println("touched")
+			return
+		}
+		fatalIf(runKeepalive(client, *id, *every))
+	case "archive":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		fatalIf(client.Archive(ctx, *id))
+		fmt.Println("archived")
+	case "unarchive":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		fatalIf(client.Unarchive(ctx, *id))
+		fmt.Println("unarchived")
+	case "label":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		if fs.NArg() == 0 {
+			fatal("usage: sbx label -id <id> [-annotate] key=value... key-...")
+		}
+		patch := map[string]*string{}
+		for _, arg := range fs.Args() {
+			if key, ok := strings.CutSuffix(arg, "-"); ok && !strings.Contains(arg, "=") {
+				patch[key] = nil
+				continue
+			}
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok || key == "" {
+				fatal("expected key=value or key-, got " + arg)
+			}
+			patch[key] = &value
+		}
+		var req api.UpdateSandboxRequest
+		if *annotate {
+			req.Annotations = patch
+		} else {
+			req.Labels = patch
+		}
+		resp, err := client.Update(ctx, *id, req)
+		fatalIf(err)
+		shown := resp.Labels
+		if *annotate {
+			shown = resp.Annotations
+		}
+		keys := make([]string, 0, len(shown))
+		for k := range shown {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, shown[k])
+		}
+	case "export":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		req, err := client.Manifest(ctx, *id)
+		fatalIf(err)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		fatalIf(enc.Encode(req))
+	case "env":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		resp, err := client.Env(ctx, *id)
+		fatalIf(err)
+		keys := make([]string, 0, len(resp.Env))
+		for k := range resp.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, resp.Env[k])
+		}
+		for _, src := range resp.EnvFrom {
+			fmt.Printf("# envFrom %s\n", src)
+		}
+	case "admin config":
+		resp, err := client.Config(ctx)
+		fatalIf(err)
+		if resp.ConfigPath != "" {
+			fmt.Printf("config file: %s\n", resp.ConfigPath)
+		}
+		if resp.ConfigError != "" {
+			fmt.Printf("config error: %s\n", resp.ConfigError)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, v := range resp.Settings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, v.Value, v.Source)
+		}
+		_ = w.Flush()
+	case "admin orphans":
+		resp, err := client.Orphans(ctx)
+		fatalIf(err)
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tCAPACITY\tREASON")
+		for _, o := range resp.Orphans {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", o.Kind, o.Namespace, o.Name, o.Capacity, o.Reason)
+		}
+		_ = w.Flush()
+		if !resp.ReapEnabled && len(resp.Orphans) > 0 {
+			fmt.Println("reaping is disabled; set SANDBOX_REAP_ORPHANS=true to delete these")
+		}
+	case "admin reap":
+		resp, err := client.Reap(ctx, *dryRun)
+		fatalIf(err)
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tREASON\tFOR")
+		for _, r := range resp.Reaped {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.ID, r.Reason, time.Duration(r.ForSeconds)*time.Second)
+		}
+		_ = w.Flush()
+		if resp.DryRun {
+			fmt.Println("dry run; nothing was deleted")
+		}
+	case "admin drain", "admin undrain":
+		resp, err := client.Drain(ctx, cmd == "admin drain")
+		fatalIf(err)
+		fmt.Printf("draining=%t\n", resp.Draining)
+	case "warm-pool status":
+		resp, err := client.WarmPool(ctx)
+		fatalIf(err)
+		fmt.Printf("enabled=%t autosize=%t desired=%d ready=%d healthy=%t recent_create_errors=%d\n",
+			resp.Enabled, resp.Autosize, resp.Desired, resp.Ready, resp.Healthy, resp.RecentCreateErrors)
+		if resp.Reason != "" {
+			fmt.Printf("reason: %s\n", resp.Reason)
+		}
+		if !resp.Healthy {
+			os.Exit(1)
+		}
+	case "warm-pool list":
+		items, err := client.WarmNamespaces(ctx)
+		fatalIf(err)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tSTATE\tPOD PHASE\tREADY\tAGE")
+		for _, ns := range items {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", ns.Name, ns.State, ns.PodPhase, ns.PodReady, time.Duration(ns.AgeSeconds)*time.Second)
+		}
+		_ = w.Flush()
+	case "warm-pool resize":
+		var req api.WarmPoolResizeRequest
+		if fs.NArg() > 0 {
+			size, err := strconv.Atoi(fs.Arg(0))
+			if err != nil {
+				fatal("size must be a number")
+			}
+			req.Size = &size
+		}
+		if *warmMin >= 0 {
+			req.Min = warmMin
+		}
+		if *warmMax >= 0 {
+			req.Max = warmMax
+		}
+		resp, err := client.ResizeWarmPool(ctx, req)
+		fatalIf(err)
+		fmt.Printf("desired=%d autosize=%t size=%d min=%d max=%d\n", resp.Desired, resp.Autosize, resp.Size, resp.Min, resp.Max)
 	case "exec-status":
 		if *id == "" {
 			fatal("-id is required")
@@ -133,9 +547,41 @@ func main() {
 		if *execID == "" {
 			fatal("-exec-id is required")
 		}
-		resp, err := client.ExecStatus(ctx, *id, *execID)
+		if *wait {
+			// Block server-side until the exec is done, then read it as usual.
+			waitCtx, cancel := context.WithTimeout(context.Background(), *waitTimeout+30*time.Second)
+			_, err := client.ExecStatusWait(waitCtx, *id, *execID, *waitTimeout)
+			cancel()
+			fatalIf(err)
+		}
+		statusFn := client.ExecStatus
+		if *showOutput {
+			statusFn = client.ExecOutput
+		}
+		resp, err := statusFn(ctx, *id, *execID)
 		fatalIf(err)
 		printExecStatus(resp)
+		if *showOutput {
+			if resp.OutputTruncated {
+				fmt.Println("output_truncated=true")
+			}
+			fmt.Print(resp.Stdout)
+			fmt.Fprint(os.Stderr, resp.Stderr)
+		}
+	case "exec-logs":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		if *execID == "" {
+			fatal("-exec-id is required")
+		}
+		resp, err := client.ExecLogs(ctx, *id, *execID)
+		fatalIf(err)
+		if resp.Truncated {
+			fmt.Fprintf(os.Stderr, "output truncated (source=%s)\n", resp.Source)
+		}
+		fmt.Print(resp.Stdout)
+		fmt.Fprint(os.Stderr, resp.Stderr)
 	case "exec-cancel":
 		if *id == "" {
 			fatal("-id is required")
@@ -146,6 +592,64 @@ func main() {
 		resp, err := client.CancelExec(ctx, *id, *execID)
 		fatalIf(err)
 		printExecStatus(resp)
+	case "exec-signal":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		if *execID == "" {
+			fatal("-exec-id is required")
+		}
+		resp, err := client.SignalExec(ctx, *id, *execID, *signal)
+		fatalIf(err)
+		printExecStatus(resp)
+	case "top":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		resp, err := client.Usage(ctx, *id)
+		fatalIf(err)
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CONTAINER\tCPU(m)\tMEMORY(Mi)")
+		for _, ctr := range resp.Containers {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", ctr.Name, ctr.CPUMillis, ctr.MemoryBytes/(1024*1024))
+		}
+		_ = w.Flush()
+	case "attach":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		attachID := *execID
+		args := fs.Args()
+		if len(args) == 0 && *command != "" {
+			args = strings.Fields(*command)
+		}
+		if len(args) > 0 {
+			async := true
+			req := api.ExecRequest{Command: args, Async: &async}
+			if *timeoutSeconds > 0 {
+				req.TimeoutSeconds = timeoutSeconds
+			}
+			resp, err := client.Exec(ctx, *id, req)
+			fatalIf(err)
+			attachID = resp.ExecID
+		}
+		os.Exit(runAttach(client, *baseURL, *id, attachID))
+	case "tail":
+		if *id == "" {
+			fatal("-id is required")
+		}
+		fatalIf(runTail(*baseURL, *id, *streamRaw))
+	case "stats":
+		resp, err := client.Stats(ctx)
+		fatalIf(err)
+		printStats(resp)
+	case "metrics":
+		if *outputFormat != "" && *outputFormat != "table" && *outputFormat != "json" {
+			fatal("-o must be table or json")
+		}
+		resp, err := client.Metrics(ctx)
+		fatalIf(err)
+		printMetrics(resp, *outputFormat)
 	default:
 		usage()
 		os.Exit(1)
@@ -153,26 +657,103 @@ func main() {
 }
 
 func usage() {
-	fmt.Println("usage: sbx <create|exec|status|delete|exec-status|exec-cancel> [flags]")
+	fmt.Println("usage: sbx <create|plan|exec|status|delete|keepalive|label|env|export|archive|unarchive|exec-status|exec-logs|exec-cancel|exec-signal|attach|tail|top|stats|metrics|oneshot|admin config|admin orphans|admin reap|admin drain|admin undrain|warm-pool status|warm-pool list|warm-pool resize> [flags]")
 	fmt.Println("  -addr http://localhost:8080")
+	fmt.Println("  -token <token> (default $SBX_TOKEN)")
 	fmt.Println("  -id demo")
+	fmt.Println("  -selector team=ci (exec; run in every matching sandbox. status; list matching sandboxes)")
+	fmt.Println("  -state Active|Terminating (status without -id)")
 	fmt.Println("  -image ubuntu:22.04")
 	fmt.Println("  -volume emptydir|pvc")
-	fmt.Println("  -cache-mode emptydir|hostpath|pvc")
+	fmt.Println("  -cache-mode emptydir|hostpath|pvc|shared-pvc")
 	fmt.Println("  -cache-pvc-size 5Gi")
 	fmt.Println("  -cache-pvc-storage-class standard")
 	fmt.Println("  -cache-pvc-access-mode ReadWriteOnce")
 	fmt.Println("  -env KEY=VALUE (repeatable)")
 	fmt.Println("  -allow-host example.com (repeatable)")
+	fmt.Println("  -mount data:/data[:pvc-name] (repeatable)")
 	fmt.Println("  -deny-host example.com (repeatable)")
+	fmt.Println("  -env-from-secret name / -env-from-configmap name (repeatable)")
+	fmt.Println("  -restart Always|OnFailure|Never")
+	fmt.Println("  -deadline 30m (create/oneshot; the kubelet kills the pod after this long)")
+	fmt.Println("  -idle-ttl 4h (create; reap after this long without an exec instead of SANDBOX_IDLE_TTL, 0 never)")
+	fmt.Println("  -expires-at 2026-01-02T17:00:00Z (create; delete at this time, in use or not)")
+	fmt.Println("  -priority-class sandbox-low")
+	fmt.Println("  -dns-policy Default|ClusterFirst|None [-dns-server 1.1.1.1 (repeatable)]")
+	fmt.Println("  -readiness-gate example.com/app-ready (create; repeatable)")
+	fmt.Println("  -git-url https://github.com/org/repo.git [-git-ref main] [-git-depth 1] [-git-secret git-creds] (create; clone into the workspace first)")
+	fmt.Println("  -startup-probe-cmd 'test -f /tmp/booted' (create; restart only after -startup-probe-failures attempts, default 60)")
 	fmt.Println("  -cmd 'bash -lc ls -la'")
+	fmt.Println("  -sh 'ls | grep foo' (runs via bash -lc)")
 	fmt.Println("  -timeout 30")
 	fmt.Println("  -exec-id <exec_id>")
+	fmt.Println("  -force (delete; remove finalizers from a namespace stuck terminating, needs the admin token)")
+	fmt.Println("  -keep-namespace (delete; delete only the pod, keeping the namespace, PVCs and events until the reaper removes it)")
+	fmt.Println("  -script setup.sh|- [-script-shell python3] (exec; runs the file inside the sandbox)")
+	fmt.Println("  -output (exec-status; print the last bytes of stdout/stderr)")
+	fmt.Println("  -signal SIGINT (exec-signal; one of HUP, INT, QUIT, KILL, USR1, USR2, TERM, CONT, STOP)")
+	fmt.Println("  -queue (start the exec once the sandbox is ready instead of failing)")
 	fmt.Println("  -sync (block until completion; disables streaming)")
+	fmt.Println("  -ordered (with -sync, replay stdout/stderr in the order they were written)")
+	fmt.Println("  -cache (with -sync, reuse the output of an identical exec that succeeded within SANDBOX_EXEC_CACHE_TTL)")
+	fmt.Println("  -detach-after 30s (with -sync, return an exec id instead of waiting if the exec runs longer)")
 	fmt.Println("  -stream (starts async exec and connects to stream)")
 	fmt.Println("  -stream-raw (starts async exec and prints only stdout/stderr)")
+	fmt.Println("  -wait [-wait-timeout 60s] (create; block until the sandbox is ready. exec-status; block until the exec finishes)")
+	fmt.Println("  -hostname db -subdomain svc (create; pod DNS identity)")
+	fmt.Println("  -metrics-port 9090 [-metrics-path /metrics] (create; label the pod and add a Service for Prometheus)")
+	fmt.Println("  -from-file sandbox.json|sandbox.yaml (create/plan/oneshot; start from an exported manifest, -id, -image and -env override it)")
+	fmt.Println("  -share-process-namespace (create; let debug containers see sandbox processes)")
+	fmt.Println("  -spread true|false (create; override soft anti-affinity across nodes)")
+	fmt.Println("  -keep (oneshot; don't delete the sandbox afterwards)")
 	fmt.Println("  status without -id lists all sandboxes")
+	fmt.Println("  metrics [-o json] prints the control plane's sandbox counters and gauges from /metrics")
+	fmt.Println("  keepalive -id <id> [-every 1m] touches the sandbox until interrupted so the idle reaper leaves it alone; -every 0 touches once")
+	fmt.Println("  tail -id <id> [-stream-raw] follows output from every exec in the sandbox; raw lines are prefixed with [exec_id]")
+	fmt.Println("  label -id <id> [-annotate] key=value key- sets or removes sandbox labels (or annotations)")
+	fmt.Println("  export -id <id> prints a create request that recreates the sandbox; secret env values come back as <redacted>")
+	fmt.Println("  plan [create flags] [-o json] shows the image, env keys, hosts, resources and warm claim a create would use, without creating anything")
+	fmt.Println("  admin reap [-dry-run] runs the idle reaper now and lists the sandboxes it deleted (admin)")
+	fmt.Println("  admin drain rejects new creates with 503 and fails /readyz, e.g. before an upgrade; admin undrain reverts it (admin)")
+	fmt.Println("  warm-pool status prints the warm pool size and health; exits 1 when unhealthy")
+	fmt.Println("  warm-pool list lists the unclaimed warm namespaces with their state, pod phase and age (admin)")
+	fmt.Println("  warm-pool resize <size> [-min N] [-max N] overrides the warm pool bounds until restart (admin)")
+	fmt.Println("  attach interleaves pod logs and exec output; with a command (or -exec-id) it exits when that exec exits")
 	fmt.Println("  exec supports args after --, e.g. sbx exec -id demo -- bash -lc 'uname -a'")
+	fmt.Println("  oneshot creates a sandbox, runs the command with output streamed, deletes it and exits with the command's code,")
+	fmt.Println("    e.g. sbx oneshot -image python:3.12 -- python -c 'print(1)'")
+}
+
+func printBulkExec(resp *api.BulkExecResponse) {
+	if len(resp.Results) == 0 {
+		fmt.Printf("no sandboxes match %s\n", resp.Selector)
+		return
+	}
+	for _, r := range resp.Results {
+		line := fmt.Sprintf("id=%s status=%s", r.ID, r.Status)
+		if r.ExecID != "" {
+			line += " exec_id=" + r.ExecID
+		}
+		if r.ExitCode != nil {
+			line += fmt.Sprintf(" exit_code=%d", *r.ExitCode)
+		}
+		if r.Error != "" {
+			line += " error=" + r.Error
+		}
+		fmt.Println(line)
+		if r.Stdout != "" {
+			fmt.Print(indent(r.Stdout))
+		}
+		if r.Stderr != "" {
+			fmt.Fprint(os.Stderr, indent(r.Stderr))
+		}
+	}
+}
+
+// indent prefixes every line of s for nesting output under a header line.
+func indent(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ") + "\n"
 }
 
 func printExecStatus(resp *api.ExecStatusResponse) {
@@ -185,6 +766,12 @@ func printExecStatus(resp *api.ExecStatusResponse) {
 	if resp.TimeoutSeconds != nil {
 		fmt.Printf("timeout_seconds=%d\n", *resp.TimeoutSeconds)
 	}
+	if resp.QueuedAt != "" {
+		fmt.Printf("queued_at=%s\n", resp.QueuedAt)
+	}
+	if resp.WaitMs != nil {
+		fmt.Printf("wait_ms=%d\n", *resp.WaitMs)
+	}
 	if resp.StartedAt != "" {
 		fmt.Printf("started_at=%s\n", resp.StartedAt)
 	}
@@ -196,6 +783,26 @@ func printExecStatus(resp *api.ExecStatusResponse) {
 	}
 }
 
+func printStats(resp *api.StatsResponse) {
+	fmt.Printf("sandboxes=%d\n", resp.Sandboxes)
+	states := make([]string, 0, len(resp.ByState))
+	for state := range resp.ByState {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Printf("state.%s=%d\n", strings.ToLower(state), resp.ByState[state])
+	}
+	fmt.Printf("execs_running=%d\n", resp.ExecsRunning)
+	fmt.Printf("warm_pool.enabled=%t\n", resp.WarmPool.Enabled)
+	fmt.Printf("warm_pool.desired=%d\n", resp.WarmPool.Desired)
+	fmt.Printf("warm_pool.ready=%d\n", resp.WarmPool.Ready)
+	fmt.Printf("creates_total=%d (%.2f/min)\n", resp.CreatesTotal, resp.CreatesPerMin)
+	fmt.Printf("execs_total=%d (%.2f/min)\n", resp.ExecsTotal, resp.ExecsPerMin)
+	fmt.Printf("deletes_total=%d (%.2f/min)\n", resp.DeletesTotal, resp.DeletesPerMin)
+	fmt.Printf("uptime_seconds=%d\n", resp.UptimeSeconds)
+}
+
 func fatal(msg string) {
 	fmt.Fprintln(os.Stderr, msg)
 	os.Exit(1)
@@ -208,19 +815,23 @@ func fatalIf(err error) {
 }
 
 func streamExecWS(baseURL, id, execID string, raw bool) {
-	wsURL := strings.TrimRight(baseURL, "/")
-	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
-	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
-	wsURL = fmt.Sprintf("%s/sandboxes/%s/stream?exec_id=%s", wsURL, id, execID)
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	fatalIf(streamExec(context.Background(), streamWSURL(baseURL, id, execID, false), execID, raw))
+}
+
+// streamExec prints the stream events at wsURL until execID exits, the connection
+// closes or ctx is done. With an empty execID it runs until the connection closes.
+func streamExec(ctx context.Context, wsURL, execID string, raw bool) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
 	if err != nil {
-		fatal(err.Error())
+		return err
 	}
 	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
-			return
+			return nil
 		}
 		var evt struct {
 			Type   string `json:"type"`
@@ -238,8 +849,8 @@ func streamExecWS(baseURL, id, execID string, raw bool) {
 			} else if !raw {
 				fmt.Fprintln(os.Stdout, string(msg))
 			}
-			if evt.ExecID == execID && evt.Type == "exit" {
-				return
+			if execID != "" && evt.ExecID == execID && evt.Type == "exit" {
+				return nil
 			}
 			continue
 		}
@@ -249,6 +860,123 @@ func streamExecWS(baseURL, id, execID string, raw bool) {
 	}
 }
 
+// runTail follows every exec in the sandbox until interrupted. Raw output is grouped
+// by the server; JSON events already carry exec_id.
+func runTail(baseURL, id string, raw bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return streamExec(ctx, streamWSURL(baseURL, id, "", raw), "", raw)
+}
+
+// runKeepalive touches the sandbox every interval until interrupted, so the idle
+// reaper leaves it alone while nothing is exec-ing.
+func runKeepalive(client *sbxclient.Client, id string, every time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		touchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := client.Touch(touchCtx, id)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOneshot creates a sandbox, runs req in it once it is ready with the output
+// streamed, and deletes the sandbox afterwards unless keep is set, including when
+// the exec fails or the user interrupts. It returns the exec's exit code.
+func runOneshot(client *sbxclient.Client, baseURL string, createReq api.CreateSandboxRequest, req api.ExecRequest, keep bool) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	created, err := client.Create(ctx, createReq)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	id := created.ID
+	if keep {
+		fmt.Fprintf(os.Stderr, "sandbox %s\n", id)
+	} else {
+		defer func() {
+			// ctx is already done after an interrupt.
+			delCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := client.Delete(delCtx, id); err != nil {
+				fmt.Fprintf(os.Stderr, "delete %s: %v\n", id, err)
+			}
+		}()
+	}
+
+	async := true
+	req.Async = &async
+	started, err := client.ExecQueued(ctx, id, req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := streamExec(ctx, streamWSURL(baseURL, id, started.ExecID, false), started.ExecID, true); err != nil {
+		fmt.Fprintf(os.Stderr, "stream: %v\n", err)
+	}
+	// The stream can close before the exit is recorded, and without it we still
+	// need the exit code.
+	for {
+		status, err := client.ExecStatus(ctx, id, started.ExecID)
+		if ctx.Err() != nil {
+			return 130
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		switch status.Status {
+		case "completed", "failed", "canceled", "timed_out":
+			if status.ExitCode != nil {
+				return *status.ExitCode
+			}
+			if status.Error != "" {
+				fmt.Fprintln(os.Stderr, status.Error)
+			}
+			return 1
+		}
+		select {
+		case <-ctx.Done():
+			return 130
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// streamWSURL is the stream endpoint for one exec, or for every exec in the sandbox
+// when execID is empty. group asks the server to prefix output lines with the exec id.
+func streamWSURL(baseURL, id, execID string, group bool) string {
+	wsURL := strings.TrimRight(baseURL, "/")
+	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
+	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
+	wsURL = fmt.Sprintf("%s/sandboxes/%s/stream", wsURL, id)
+	q := url.Values{}
+	if execID != "" {
+		q.Set("exec_id", execID)
+	}
+	if group {
+		q.Set("group", "true")
+	}
+	if len(q) > 0 {
+		wsURL += "?" + q.Encode()
+	}
+	return wsURL
+}
+
 type stringSlice []string
 
 func (s *stringSlice) String() string {
@@ -260,6 +988,46 @@ func (s *stringSlice) Set(val string) error {
 	return nil
 }
 
+// parseMounts turns NAME:PATH and NAME:PATH:PVC flags into volume specs.
+func parseMounts(specs []string) ([]api.VolumeSpec, error) {
+	var out []api.VolumeSpec
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid mount: %q (expected NAME:PATH or NAME:PATH:PVC)", spec)
+		}
+		vol := api.VolumeSpec{Name: parts[0], MountPath: parts[1], Type: "emptydir"}
+		if len(parts) == 3 {
+			vol.Type = "pvc"
+			vol.ClaimName = parts[2]
+		}
+		out = append(out, vol)
+	}
+	return out, nil
+}
+
+// readCreateRequest reads a create request from a JSON or YAML file, such as
+// the output of export. YAML uses the JSON field names.
+func readCreateRequest(path string) (api.CreateSandboxRequest, error) {
+	var req api.CreateSandboxRequest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return req, err
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return req, fmt.Errorf("%s: %v", path, err)
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return req, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return req, fmt.Errorf("%s: %v", path, err)
+	}
+	return req, nil
+}
+
 func parseEnvPairs(pairs []string) (map[string]string, error) {
 	if len(pairs) == 0 {
 		return nil, nil
@@ -274,3 +1042,36 @@ func parseEnvPairs(pairs []string) (map[string]string, error) {
 	}
 	return out, nil
 }
+
+func printPlan(plan *api.CreatePlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "id\t%s\n", plan.ID)
+	fmt.Fprintf(w, "namespace\t%s\n", plan.Namespace)
+	fmt.Fprintf(w, "image\t%s (%s)\n", plan.Image, plan.ImageSource)
+	fmt.Fprintf(w, "volume mode\t%s\n", plan.VolumeMode)
+	fmt.Fprintf(w, "cache mode\t%s\n", plan.CacheMode)
+	fmt.Fprintf(w, "env keys\t%s\n", strings.Join(plan.EnvKeys, ","))
+	fmt.Fprintf(w, "allowed hosts\t%s\n", strings.Join(plan.AllowedHosts, ","))
+	fmt.Fprintf(w, "disallowed hosts\t%s\n", strings.Join(plan.DisallowedHosts, ","))
+	fmt.Fprintf(w, "requests\t%s\n", formatResources(plan.Requests))
+	fmt.Fprintf(w, "limits\t%s\n", formatResources(plan.Limits))
+	if plan.WarmClaim {
+		fmt.Fprintln(w, "warm claim\tyes")
+	} else {
+		fmt.Fprintf(w, "warm claim\tno (%s)\n", plan.WarmReason)
+	}
+	_ = w.Flush()
+}
+
+func formatResources(list map[string]string) string {
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+list[name])
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// sandboxMetric reports whether an expvar name is one of the control plane's own
+// counters rather than a Go runtime variable like memstats or cmdline.
+func sandboxMetric(name string) bool {
+	return strings.HasPrefix(name, "sandbox_") || strings.HasPrefix(name, "warm_pool_")
+}
+
+// flattenMetrics turns the sandbox-related expvar variables into name/value pairs.
+// Map variables become one entry per key, named name.key.
+func flattenMetrics(vars map[string]json.RawMessage) map[string]any {
+	out := map[string]any{}
+	for name, raw := range vars {
+		if !sandboxMetric(name) {
+			continue
+		}
+		var value any
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&value); err != nil {
+			continue
+		}
+		if m, ok := value.(map[string]any); ok {
+			for k, v := range m {
+				out[name+"."+k] = v
+			}
+			continue
+		}
+		out[name] = value
+	}
+	return out
+}
+
+func printMetrics(vars map[string]json.RawMessage, format string) {
+	metrics := flattenMetrics(vars)
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		fatalIf(enc.Encode(metrics))
+		return
+	}
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%v\n", name, metrics[name])
+	}
+	_ = w.Flush()
+}
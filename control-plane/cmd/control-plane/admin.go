@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/subtle"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin guards operator-only endpoints with the bearer token in
+// SANDBOX_ADMIN_TOKEN. Without a token the endpoints are disabled entirely.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkAdmin(c) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkAdmin reports whether the request carries the admin token, writing the
+// 403 or 401 when it doesn't. Handlers use it for admin-only options on
+// otherwise public endpoints.
+func checkAdmin(c *gin.Context) bool {
+	token := os.Getenv("SANDBOX_ADMIN_TOKEN")
+	if token == "" {
+		writeError(c, 403, "admin endpoints are disabled; set SANDBOX_ADMIN_TOKEN to enable them")
+		return false
+	}
+	got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		writeError(c, 401, "invalid admin token")
+		return false
+	}
+	return true
+}
+
+type configSetting struct {
+	key  string
+	kind string // string, int, bool, duration or hosts selects the config file lookup; env has none
+	def  string
+}
+
+// configSettings lists every setting read through getenv*, with the fallback used
+// at the call site. Keep it in sync when adding settings.
+var configSettings = []configSetting{
+	{"SANDBOX_IMAGE", "string", defaultImage},
+	{"SANDBOX_REQUIRE_DIGEST", "bool", "false"},
+	{"SANDBOX_REJECT_LATEST", "bool", "false"},
+	{"SANDBOX_ALLOWED_REGISTRIES", "string", ""},
+	{"SANDBOX_VOLUME_MODE", "string", defaultVolumeMode},
+	{"SANDBOX_WORKSPACE_PATH", "string", defaultWorkspacePath},
+	{"SANDBOX_CACHE_PATH", "string", defaultCachePath},
+	{"SANDBOX_CACHE_MODE", "string", defaultCacheMode},
+	{"SANDBOX_CACHE_HOSTPATH", "string", "/var/lib/sbx-cache"},
+	{"SANDBOX_CACHE_PVC_SIZE", "string", "5Gi"},
+	{"SANDBOX_CACHE_PVC_STORAGE_CLASS", "string", ""},
+	{"SANDBOX_CACHE_PVC_ACCESS_MODE", "string", "ReadWriteOnce"},
+	{"SANDBOX_CACHE_SHARED_NAMESPACE", "string", defaultSharedCacheNamespace},
+	{"SANDBOX_CACHE_SHARED_PVC", "string", defaultSharedCacheClaim},
+	{"SANDBOX_CACHE_SHARED_READ_ONLY", "bool", "false"},
+	{"SANDBOX_WARM_POOL_SIZE", "int", "0"},
+	{"SANDBOX_WARM_POOL_AUTOSIZE", "bool", "false"},
+	{"SANDBOX_WARM_POOL_MIN", "int", "0"},
+	{"SANDBOX_WARM_POOL_MAX", "int", "0"},
+	{"SANDBOX_WARM_WINDOW", "duration", defaultWarmWindow.String()},
+	{"SANDBOX_WARM_LABELS", "string", ""},
+	{"SANDBOX_WARM_ANNOTATIONS", "string", ""},
+	{"SANDBOX_WARM_VERIFY", "bool", "false"},
+	{"SANDBOX_WARM_VERIFY_TIMEOUT", "duration", defaultWarmVerifyTimeout.String()},
+	{"SANDBOX_WARM_SETUP", "string", ""},
+	{"SANDBOX_WARM_SETUP_TIMEOUT", "duration", defaultWarmSetupTimeout.String()},
+	{"SANDBOX_WARM_UNHEALTHY_AFTER", "duration", defaultWarmUnhealthyAfter.String()},
+	{"SANDBOX_WARM_MAX_CREATE_ERRORS", "int", strconv.Itoa(defaultWarmMaxCreateErrors)},
+	{"SANDBOX_IDLE_TTL", "duration", defaultIdleTTL.String()},
+	{"SANDBOX_MIN_AGE_BEFORE_REAP", "duration", "0s"},
+	{"SANDBOX_ARCHIVE_TTL", "duration", defaultArchiveTTL.String()},
+	{"SANDBOX_KEPT_TTL", "duration", defaultKeptTTL.String()},
+	{"SANDBOX_REAP_ORPHANS", "bool", "false"},
+	{"SANDBOX_ORPHAN_GRACE", "duration", defaultOrphanGrace.String()},
+	{"SANDBOX_CREATE_READY_TIMEOUT", "duration", (60 * time.Second).String()},
+	{"SANDBOX_READY_POLL_INTERVAL", "duration", defaultReadyPollInterval.String()},
+	{"SANDBOX_TERMINATING_WAIT", "duration", defaultTerminatingWait.String()},
+	{"SANDBOX_FORCE_DELETE_WAIT", "duration", defaultForceDeleteWait.String()},
+	{"SANDBOX_IDEMPOTENCY_TTL", "duration", defaultIdempotencyTTL.String()},
+	{"SANDBOX_CPU_REQUEST", "env", ""},
+	{"SANDBOX_MEM_REQUEST", "env", ""},
+	{"SANDBOX_CPU_LIMIT", "env", ""},
+	{"SANDBOX_MEM_LIMIT", "env", ""},
+	{"SANDBOX_APPLY_RESOURCE_QUOTA", "bool", "false"},
+	{"SANDBOX_APPLY_LIMIT_RANGE", "bool", "false"},
+	{"SANDBOX_QUOTA_PODS", "string", "5"},
+	{"SANDBOX_QUOTA_CPU", "string", ""},
+	{"SANDBOX_QUOTA_MEMORY", "string", ""},
+	{"SANDBOX_ALLOWED_HOSTS", "hosts", ""},
+	{"SANDBOX_DISALLOWED_HOSTS", "hosts", ""},
+	{"SANDBOX_FORCE_DISALLOWED_HOSTS", "hosts", ""},
+	{"SANDBOX_HTTP_PROXY", "string", ""},
+	{"SANDBOX_HTTPS_PROXY", "string", ""},
+	{"SANDBOX_NO_PROXY", "string", ""},
+	{"SANDBOX_STREAM_SIDECAR_IMAGE", "string", ""},
+	{"SANDBOX_GIT_IMAGE", "string", defaultGitImage},
+	{"SANDBOX_STREAM_ENDPOINT", "string", ""},
+	{"SANDBOX_STREAM_EVENTS_DIR", "string", "/sbx-events"},
+	{"SANDBOX_STREAM_BUFFER", "int", "200"},
+	{"SANDBOX_STREAM_RATE_BYTES", "int", "0"},
+	{"SANDBOX_STREAM_STATS_INTERVAL", "duration", (10 * time.Second).String()},
+	{"SANDBOX_STREAM_RELIABLE_TIMEOUT", "duration", defaultReliableTimeout.String()},
+	{"SANDBOX_STREAM_RECORD_STDIN", "bool", "false"},
+	{"SANDBOX_STREAM_STDIN_REDACT", "string", ""},
+	{"SANDBOX_ASYNC_EXEC", "bool", "true"},
+	{"SANDBOX_EXEC_STATUS_RETENTION", "duration", (30 * time.Minute).String()},
+	{"SANDBOX_EXEC_CACHE_TTL", "duration", defaultExecCacheTTL.String()},
+	{"SANDBOX_EXEC_OUTPUT_TAIL_BYTES", "int", strconv.Itoa(defaultExecOutputTailBytes)},
+	{"SANDBOX_BULK_EXEC_CONCURRENCY", "int", strconv.Itoa(defaultBulkExecConcurrency)},
+	{"SANDBOX_BATCH_MAX_CONCURRENCY", "int", strconv.Itoa(defaultBatchMaxConcurrency)},
+	{"SANDBOX_EXEC_WRAPPER", "string", ""},
+	{"SANDBOX_EXEC_ALLOWLIST", "string", ""},
+	{"SANDBOX_EXEC_SHELL", "string", defaultExecShell},
+	{"SANDBOX_EXEC_PATH_PREPEND", "string", ""},
+	{"SANDBOX_EXEC_LOGIN_SHELL", "bool", "false"},
+	{"SANDBOX_EXEC_TIMEOUT", "duration", "0s"},
+	{"SANDBOX_EXEC_MAX_TIMEOUT", "duration", (6 * time.Hour).String()},
+	{"SANDBOX_EXEC_CANCEL_GRACE", "duration", (5 * time.Second).String()},
+	{"SANDBOX_EXEC_QUEUE_TIMEOUT", "duration", defaultExecQueueTimeout.String()},
+	{"SANDBOX_MAX_CONCURRENT_EXECS", "int", "0"},
+	{"SANDBOX_MAX_QUEUED_EXECS", "int", strconv.Itoa(defaultMaxQueuedExecs)},
+	{"SANDBOX_SERVICE_ACCOUNT", "string", ""},
+	{"SANDBOX_AUTOMOUNT_SERVICE_ACCOUNT_TOKEN", "bool", ""},
+	{"SANDBOX_HARDENED", "bool", "false"},
+	{"SANDBOX_SINGLE_NAMESPACE", "string", ""},
+	{"SANDBOX_PRIORITY_CLASS", "string", ""},
+	{"SANDBOX_METRICS_LABELS", "string", ""},
+	{"SANDBOX_SPREAD", "bool", "false"},
+	{"SANDBOX_USE_INIT", "bool", "false"},
+	{"SANDBOX_TOPOLOGY_SPREAD", "string", ""},
+	{"SANDBOX_DNS_POLICY", "string", ""},
+	{"SANDBOX_DNS_SERVERS", "string", ""},
+	{"SANDBOX_ALLOW_POD_OVERLAY", "bool", "false"},
+	{"SANDBOX_DEFAULT_TOLERATIONS", "bool", "true"},
+	{"SANDBOX_MAX_REQUEST_BYTES", "int", strconv.Itoa(defaultMaxRequestBytes)},
+	{"SANDBOX_MAX_ENV_VALUE_BYTES", "int", strconv.Itoa(defaultMaxEnvValueBytes)},
+	{"SANDBOX_MAX_ENV_BYTES", "int", strconv.Itoa(defaultMaxEnvBytes)},
+	{"SANDBOX_MAX_CONCURRENT_CREATES", "int", strconv.Itoa(defaultMaxConcurrentCreates)},
+	{"SANDBOX_K8S_QPS", "string", strconv.Itoa(defaultK8sQPS)},
+	{"SANDBOX_K8S_BURST", "int", strconv.Itoa(defaultK8sBurst)},
+	{"SANDBOX_AUDIT_LOG", "string", ""},
+	{"SANDBOX_AUDIT_REDACT_COMMANDS", "bool", "false"},
+	{"SANDBOX_TLS_CERT", "string", ""},
+	{"SANDBOX_TLS_KEY", "string", ""},
+	{"SANDBOX_TLS_CLIENT_CA", "string", ""},
+	{"SANDBOX_GRPC_ADDR", "string", ""},
+	{"SANDBOX_CONFIG_STRICT", "env", "false"},
+	{"SANDBOX_ADMIN_TOKEN", "env", ""},
+	{"SANDBOX_REDACT_ENV_KEYS", "string", defaultRedactEnvKeys},
+}
+
+// resolveSetting mirrors the getenv* precedence: config file, then env, then default.
+func resolveSetting(s configSetting) api.ConfigValue {
+	out := api.ConfigValue{Key: s.key, Value: s.def, Source: "default"}
+	fromConfig := ""
+	found := false
+	switch s.kind {
+	case "string":
+		fromConfig, found = configString(s.key)
+	case "int":
+		if v, ok := configInt(s.key); ok {
+			fromConfig, found = strconv.Itoa(v), true
+		}
+	case "bool":
+		if v, ok := configBool(s.key); ok {
+			fromConfig, found = strconv.FormatBool(v), true
+		}
+	case "duration":
+		if v, ok := configDuration(s.key); ok {
+			fromConfig, found = v.String(), true
+		}
+	case "hosts":
+		allowed, disallowed := configAllowedHosts()
+		hosts := allowed
+		switch s.key {
+		case "SANDBOX_DISALLOWED_HOSTS":
+			hosts = disallowed
+		case "SANDBOX_FORCE_DISALLOWED_HOSTS":
+			hosts = configForceDisallowedHosts()
+		}
+		if len(hosts) > 0 {
+			fromConfig, found = strings.Join(hosts, ","), true
+		}
+	}
+	switch {
+	case found:
+		out.Value, out.Source = fromConfig, "config"
+	case os.Getenv(s.key) != "":
+		out.Value, out.Source = os.Getenv(s.key), "env"
+	}
+	// Control plane settings always get the built-in patterns, so narrowing
+	// SANDBOX_REDACT_ENV_KEYS can't expose the admin token. The pattern list itself
+	// only looks like a secret.
+	secret := isSecretKey(s.key) || matchesAny(defaultRedactPatterns, s.key)
+	if secret && s.key != "SANDBOX_REDACT_ENV_KEYS" && out.Value != "" {
+		out.Value = redacted
+	}
+	return out
+}
+
+func effectiveConfig() api.ConfigResponse {
+	resp := api.ConfigResponse{ConfigPath: os.Getenv("SANDBOX_CONFIG")}
+	if _, err := getConfig(); err != nil {
+		resp.ConfigError = err.Error()
+	}
+	for _, s := range configSettings {
+		resp.Settings = append(resp.Settings, resolveSetting(s))
+	}
+	// Sandbox env defaults: config file env first, SANDBOX_ENV_* overrides.
+	env := map[string]api.ConfigValue{}
+	for k, v := range configEnv() {
+		if k != "" {
+			env[k] = api.ConfigValue{Key: "env." + k, Value: v, Source: "config"}
+		}
+	}
+	for _, pair := range os.Environ() {
+		key, val, ok := strings.Cut(pair, "=")
+		name := strings.TrimPrefix(key, "SANDBOX_ENV_")
+		if !ok || name == key || name == "" {
+			continue
+		}
+		env[name] = api.ConfigValue{Key: "env." + name, Value: val, Source: "env"}
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := env[name]
+		if isSecretKey(name) {
+			v.Value = redacted
+		}
+		resp.Settings = append(resp.Settings, v)
+	}
+	return resp
+}
+
+func (s *server) getEffectiveConfig(c *gin.Context) {
+	writeJSON(c, 200, effectiveConfig())
+}
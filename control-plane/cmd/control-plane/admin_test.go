@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// useConfigFile points SANDBOX_CONFIG at a file holding data and reloads the config,
+// restoring the previous one when the test ends.
+func useConfigFile(t *testing.T, data string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SANDBOX_CONFIG", path)
+	configOnce = sync.Once{}
+	t.Cleanup(func() { configOnce = sync.Once{} })
+	if _, err := getConfig(); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+}
+
+func TestResolveSettingPrecedence(t *testing.T) {
+	useConfigFile(t, "image: from-config\ncpu_request: 500m\n")
+	t.Setenv("SANDBOX_IMAGE", "from-env")
+	t.Setenv("SANDBOX_CACHE_MODE", "pvc")
+	t.Setenv("SANDBOX_CPU_REQUEST", "250m")
+	t.Setenv("SANDBOX_ADMIN_TOKEN", "hunter2")
+
+	want := map[string][2]string{
+		"SANDBOX_IMAGE":       {"from-config", "config"},
+		"SANDBOX_CACHE_MODE":  {"pvc", "env"},
+		"SANDBOX_VOLUME_MODE": {defaultVolumeMode, "default"},
+		// Resources are read from the environment only, so the config file value
+		// must not be reported as effective.
+		"SANDBOX_CPU_REQUEST": {"250m", "env"},
+		"SANDBOX_ADMIN_TOKEN": {redacted, "env"},
+	}
+	for _, s := range configSettings {
+		w, ok := want[s.key]
+		if !ok {
+			continue
+		}
+		got := resolveSetting(s)
+		if got.Value != w[0] || got.Source != w[1] {
+			t.Errorf("%s = %q from %s, want %q from %s", s.key, got.Value, got.Source, w[0], w[1])
+		}
+		delete(want, s.key)
+	}
+	for k := range want {
+		t.Errorf("%s missing from configSettings", k)
+	}
+}
+
+func TestEffectiveConfigRedactsSandboxEnv(t *testing.T) {
+	useConfigFile(t, "env:\n  LOG_LEVEL: debug\n  API_KEY: abc\n")
+	t.Setenv("SANDBOX_ENV_LOG_LEVEL", "info")
+
+	got := map[string][2]string{}
+	for _, v := range effectiveConfig().Settings {
+		got[v.Key] = [2]string{v.Value, v.Source}
+	}
+	if v := got["env.LOG_LEVEL"]; v != [2]string{"info", "env"} {
+		t.Errorf("env.LOG_LEVEL = %v, want info from env", v)
+	}
+	if v := got["env.API_KEY"]; v != [2]string{redacted, "config"} {
+		t.Errorf("env.API_KEY = %v, want redacted from config", v)
+	}
+}
+
+func TestResolveSettingHosts(t *testing.T) {
+	useConfigFile(t, "allowed_hosts: [github.com]\ndisallowed_hosts: [evil.com]\nforce_disallowed_hosts: [169.254.169.254, metadata.internal]\n")
+	want := map[string]string{
+		"SANDBOX_ALLOWED_HOSTS":          "github.com",
+		"SANDBOX_DISALLOWED_HOSTS":       "evil.com",
+		"SANDBOX_FORCE_DISALLOWED_HOSTS": "169.254.169.254,metadata.internal",
+	}
+	for _, s := range configSettings {
+		w, ok := want[s.key]
+		if !ok {
+			continue
+		}
+		if s.kind != "hosts" {
+			t.Errorf("%s kind = %q, want hosts", s.key, s.kind)
+		}
+		got := resolveSetting(s)
+		if got.Value != w || got.Source != "config" {
+			t.Errorf("%s = %q from %s, want %q from config", s.key, got.Value, got.Source, w)
+		}
+		delete(want, s.key)
+	}
+	for k := range want {
+		t.Errorf("%s missing from configSettings", k)
+	}
+}
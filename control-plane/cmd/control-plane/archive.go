@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const archivedPodAnnotation = "sbx.archived_pod"
+
+// archivedPod is the subset of the sandbox pod stored on the namespace so unarchive
+// can recreate it.
+type archivedPod struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Spec        corev1.PodSpec    `json:"spec"`
+}
+
+func isArchived(ns *corev1.Namespace) bool {
+	return ns.Labels["sbx.archived"] == "true"
+}
+
+// archiveSandbox deletes the sandbox pod but keeps the namespace and its PVCs. Only
+// PVC-backed workspaces survive; emptyDir volumes go away with the pod.
+func (s *server) archiveSandbox(c *gin.Context) {
+	id := c.Param("id")
+	ns := id
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
+	defer cancel()
+	pod, err := s.client.CoreV1().Pods(ns).Get(ctx, "sandbox", metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox pod not found (already archived?)")
+			return
+		}
+		writeError(c, 500, err.Error())
+		return
+	}
+	saved, err := json.Marshal(archivedPod{Labels: pod.Labels, Annotations: pod.Annotations, Spec: pod.Spec})
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	err = s.updateNamespace(ctx, ns, func(n *corev1.Namespace) {
+		n.Labels["sbx.archived"] = "true"
+		n.Annotations["sbx.archived_at"] = strconv.FormatInt(time.Now().Unix(), 10)
+		n.Annotations[archivedPodAnnotation] = string(saved)
+	})
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	if err := s.client.CoreV1().Pods(ns).Delete(ctx, "sandbox", metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		writeError(c, 500, err.Error())
+		return
+	}
+	s.execCache.forget(ns)
+	writeJSON(c, 200, map[string]string{"id": id, "status": "archived"})
+}
+
+func (s *server) unarchiveSandbox(c *gin.Context) {
+	id := c.Param("id")
+	ns := id
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
+	defer cancel()
+	n, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	if !isArchived(n) {
+		writeErrorCode(c, 409, errCodeSandboxNotArchived, "sandbox is not archived")
+		return
+	}
+	var saved archivedPod
+	if err := json.Unmarshal([]byte(n.Annotations[archivedPodAnnotation]), &saved); err != nil {
+		writeError(c, 500, fmt.Sprintf("archived pod spec is unreadable: %v", err))
+		return
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "sandbox",
+			Labels:      saved.Labels,
+			Annotations: saved.Annotations,
+		},
+		Spec: saved.Spec,
+	}
+	// The scheduler fills in nodeName; clear it so the pod can land anywhere.
+	pod.Spec.NodeName = ""
+	if _, err := s.client.CoreV1().Pods(ns).Create(ctx, pod, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		writeError(c, 500, err.Error())
+		return
+	}
+	err = s.updateNamespace(ctx, ns, func(n *corev1.Namespace) {
+		delete(n.Labels, "sbx.archived")
+		delete(n.Annotations, "sbx.archived_at")
+		delete(n.Annotations, archivedPodAnnotation)
+		// Restart the idle clock so the reaper doesn't collect it immediately.
+		n.Annotations["sbx.last_exec_at"] = strconv.FormatInt(time.Now().Unix(), 10)
+	})
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	s.trackReadyAsync(ns, "sandbox")
+	writeJSON(c, 200, map[string]string{"id": id, "status": "unarchived"})
+}
+
+// updateNamespace applies mutate to a fresh copy of ns, retrying on conflicts. Labels and
+// annotations are always non-nil inside mutate.
+func (s *server) updateNamespace(ctx context.Context, ns string, mutate func(*corev1.Namespace)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		n, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if n.Labels == nil {
+			n.Labels = map[string]string{}
+		}
+		if n.Annotations == nil {
+			n.Annotations = map[string]string{}
+		}
+		mutate(n)
+		_, err = s.client.CoreV1().Namespaces().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys handlers use to hand details to the audit middleware.
+const (
+	auditSandboxKey = "audit.sandbox_id"
+	auditExecKey    = "audit.exec_id"
+	auditCommandKey = "audit.command"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time      string   `json:"time"`
+	RequestID string   `json:"request_id"`
+	Principal string   `json:"principal"`
+	Remote    string   `json:"remote_addr"`
+	Action    string   `json:"action"`
+	SandboxID string   `json:"sandbox_id,omitempty"`
+	ExecID    string   `json:"exec_id,omitempty"`
+	Command   []string `json:"command,omitempty"`
+	Selector  string   `json:"selector,omitempty"`
+	Status    int      `json:"status"`
+	Result    string   `json:"result"`
+	Error     string   `json:"error,omitempty"`
+	ExitCode  *int     `json:"exit_code,omitempty"`
+}
+
+// auditLogger appends a JSON line per mutating request to SANDBOX_AUDIT_LOG. It is
+// separate from the request log and records the command each exec ran. A nil
+// logger records nothing.
+type auditLogger struct {
+	mu            sync.Mutex
+	w             io.Writer
+	redactCommand bool
+}
+
+// newAuditLoggerFromEnv opens SANDBOX_AUDIT_LOG ("stdout" or a file path, opened
+// for append). It returns nil when auditing is off.
+func newAuditLoggerFromEnv() (*auditLogger, error) {
+	target := getenv("SANDBOX_AUDIT_LOG", "")
+	if target == "" {
+		return nil, nil
+	}
+	a := &auditLogger{redactCommand: getenvBool("SANDBOX_AUDIT_REDACT_COMMANDS", false)}
+	if target == "stdout" || target == "-" {
+		a.w = os.Stdout
+		return a, nil
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a.w = f
+	return a, nil
+}
+
+// middleware records action once the handler has finished. Handlers add the
+// sandbox id, exec id and command through the audit* context keys; the route's :id
+// is used when no sandbox id was set.
+func (a *auditLogger) middleware(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if a == nil {
+			return
+		}
+		rec := auditRecord{
+			Time:      time.Now().UTC().Format(time.RFC3339Nano),
+			RequestID: c.GetHeader("X-Request-Id"),
+			Principal: auditPrincipal(c),
+			Remote:    c.ClientIP(),
+			Action:    action,
+			SandboxID: c.GetString(auditSandboxKey),
+			ExecID:    c.GetString(auditExecKey),
+			Selector:  c.Query("selector"),
+			Status:    c.Writer.Status(),
+			Result:    "ok",
+		}
+		if rec.SandboxID == "" {
+			rec.SandboxID = c.Param("id")
+		}
+		if rec.ExecID == "" {
+			rec.ExecID = c.Param("exec_id")
+		}
+		if cmd, ok := c.Get(auditCommandKey); ok {
+			rec.Command, _ = cmd.([]string)
+			if a.redactCommand && len(rec.Command) > 0 {
+				rec.Command = []string{redacted}
+			} else {
+				rec.Command = redactEnvAssignments(rec.Command)
+			}
+		}
+		if rec.Status >= 400 {
+			rec.Result = "error"
+			if last := c.Errors.Last(); last != nil {
+				rec.Error = last.Error()
+			}
+		}
+		a.write(rec)
+	}
+}
+
+func (a *auditLogger) write(rec auditRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// auditPrincipal identifies the caller: the client certificate CN under mutual TLS,
+// "admin" for the admin token, a short SHA-256 fingerprint for any other bearer
+// token, and "anonymous" otherwise. Raw tokens are never logged.
+func auditPrincipal(c *gin.Context) string {
+	return principal(c.Request.TLS, c.GetHeader("Authorization"))
+}
+
+// principal is auditPrincipal for a connection's TLS state and Authorization
+// header, wherever they came from.
+func principal(tls *tls.ConnectionState, authorization string) string {
+	if tls != nil && len(tls.PeerCertificates) > 0 && tls.PeerCertificates[0].Subject.CommonName != "" {
+		return "cert:" + tls.PeerCertificates[0].Subject.CommonName
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return "anonymous"
+	}
+	if admin := os.Getenv("SANDBOX_ADMIN_TOKEN"); admin != "" && subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1 {
+		return "admin"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestExecWritesAuditRecord(t *testing.T) {
+	t.Setenv("SANDBOX_ASYNC_EXEC", "false")
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		return nil
+	}
+	var out bytes.Buffer
+	s.audit = &auditLogger{w: &out}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/sandboxes/:id/exec", s.audit.middleware("exec"), s.execSandbox)
+	body, _ := json.Marshal(api.ExecRequest{Command: []string{"ls", "-la"}})
+	req := httptest.NewRequest("POST", "/sandboxes/sbx-a/exec", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+
+	var rec auditRecord
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatalf("audit log %q: %v", out.String(), err)
+	}
+	if rec.Action != "exec" || rec.SandboxID != "sbx-a" || rec.Result != "ok" || rec.Status != 200 {
+		t.Errorf("record = %+v, want an ok exec on sbx-a", rec)
+	}
+	if len(rec.Command) != 2 || rec.Command[0] != "ls" || rec.Command[1] != "-la" {
+		t.Errorf("command = %v, want [ls -la]", rec.Command)
+	}
+	if rec.Principal == "" || rec.Principal == "anonymous" || bytes.Contains(out.Bytes(), []byte("secret")) {
+		t.Errorf("principal = %q, want a token fingerprint without the raw token", rec.Principal)
+	}
+}
+
+func TestAuditRecordsErrorsAndRedacts(t *testing.T) {
+	s := newTestServer()
+	var out bytes.Buffer
+	s.audit = &auditLogger{w: &out, redactCommand: true}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/sandboxes/:id/exec", s.audit.middleware("exec"), s.execSandbox)
+	body, _ := json.Marshal(api.ExecRequest{Command: []string{"cat", "/etc/shadow"}})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/sandboxes/sbx-missing/exec", bytes.NewReader(body)))
+
+	var rec auditRecord
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatalf("audit log %q: %v", out.String(), err)
+	}
+	if rec.Result != "error" || rec.Error == "" || rec.Status != w.Code {
+		t.Errorf("record = %+v, want the failed exec with its error", rec)
+	}
+	if len(rec.Command) != 1 || rec.Command[0] != redacted {
+		t.Errorf("command = %v, want it redacted", rec.Command)
+	}
+}
+
+func TestTouchAuditedAsAnonymous(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}})
+	var out bytes.Buffer
+	s.audit = &auditLogger{w: &out}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/sandboxes/:id/touch", s.audit.middleware("touch"), s.touchSandbox)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/sandboxes/sbx-a/touch", nil))
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var rec auditRecord
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatalf("audit log %q: %v", out.String(), err)
+	}
+	if rec.Action != "touch" || rec.SandboxID != "sbx-a" || rec.Principal != "anonymous" {
+		t.Errorf("record = %+v, want a touch of sbx-a by anonymous", rec)
+	}
+}
+
+func TestWaitAuditsProbe(t *testing.T) {
+	s := newTestServer(readyPod("sbx-a"))
+	var out bytes.Buffer
+	s.audit = &auditLogger{w: &out}
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error { return nil }
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/sandboxes/:id/wait", s.audit.middleware("wait"), s.waitSandbox)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/sandboxes/sbx-a/wait", strings.NewReader(`{"command":["curl","-sf","localhost:8080"]}`)))
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var rec auditRecord
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatalf("audit log %q: %v", out.String(), err)
+	}
+	if rec.Action != "wait" || rec.SandboxID != "sbx-a" || strings.Join(rec.Command, " ") != "curl -sf localhost:8080" {
+		t.Errorf("record = %+v, want a wait on sbx-a with its probe", rec)
+	}
+}
+
+func TestPrincipalWithoutCredentials(t *testing.T) {
+	emptyCN := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+	for _, tc := range []struct {
+		tls  *tls.ConnectionState
+		auth string
+	}{{nil, ""}, {nil, "Bearer "}, {nil, "Basic abc"}, {emptyCN, ""}} {
+		if got := principal(tc.tls, tc.auth); got != "anonymous" {
+			t.Errorf("principal(%v, %q) = %q, want anonymous", tc.tls != nil, tc.auth, got)
+		}
+	}
+}
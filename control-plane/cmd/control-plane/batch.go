@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxBatchOps = 100
+	// defaultBatchConcurrency is how many ops ?parallel=true runs at once when the
+	// request doesn't set ?concurrency.
+	defaultBatchConcurrency    = 4
+	defaultBatchMaxConcurrency = 16
+)
+
+// batch runs a list of create, exec, delete and status ops in one request, in order
+// or concurrently with ?parallel=true or ?concurrency=N. Each op goes through the
+// same handler as its own endpoint, audit record included, and reports that
+// endpoint's status and body, so one failing op doesn't fail the batch. With
+// ?stream=true each result is sent as a server-sent event as soon as its op ends.
+func (s *server) batch(c *gin.Context) {
+	var ops []api.BatchOp
+	if !bindJSON(c, &ops) {
+		return
+	}
+	if len(ops) == 0 || len(ops) > maxBatchOps {
+		writeErrorCode(c, 400, errCodeInvalidRequest, fmt.Sprintf("a batch holds 1 to %d ops", maxBatchOps))
+		return
+	}
+	concurrency, err := batchConcurrency(c)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	s.batchOnce.Do(func() { s.batchRoutes = s.newBatchRoutes() })
+	results := make([]api.BatchResult, len(ops))
+	// finished receives each op's index once its result is in results.
+	finished := make(chan int, len(ops))
+	go func() {
+		slots := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for i, op := range ops {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(i int, op api.BatchOp) {
+				defer wg.Done()
+				results[i] = s.runBatchOp(c.Request, op)
+				<-slots
+				finished <- i
+			}(i, op)
+		}
+		wg.Wait()
+		close(finished)
+	}()
+
+	if c.Query("stream") == "true" {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(200)
+		for i := range finished {
+			c.SSEvent("result", api.BatchStreamResult{Index: i, BatchResult: results[i]})
+			c.Writer.Flush()
+		}
+		c.SSEvent("done", map[string]int{"count": len(ops)})
+		c.Writer.Flush()
+		return
+	}
+	for range finished {
+	}
+	writeJSON(c, 200, api.BatchResponse{Results: results})
+}
+
+// batchConcurrency returns how many ops of a batch may run at once: 1 unless
+// ?parallel=true or ?concurrency=N asks for more, never above
+// SANDBOX_BATCH_MAX_CONCURRENCY.
+func batchConcurrency(c *gin.Context) (int, error) {
+	limit := getenvInt("SANDBOX_BATCH_MAX_CONCURRENCY", defaultBatchMaxConcurrency)
+	if limit <= 0 {
+		limit = defaultBatchMaxConcurrency
+	}
+	n := 1
+	if c.Query("parallel") == "true" {
+		n = min(defaultBatchConcurrency, limit)
+	}
+	if v := c.Query("concurrency"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			return 0, fmt.Errorf("concurrency must be a positive integer")
+		}
+		if parsed > limit {
+			return 0, fmt.Errorf("concurrency may be at most %d (SANDBOX_BATCH_MAX_CONCURRENCY)", limit)
+		}
+		n = parsed
+	}
+	return n, nil
+}
+
+// newBatchRoutes mounts the handlers batch ops dispatch to, at their usual paths.
+func (s *server) newBatchRoutes() http.Handler {
+	r := gin.New()
+	r.POST("/sandboxes", s.audit.middleware("create"), s.handleSandboxes)
+	r.POST("/sandboxes/:id/exec", s.audit.middleware("exec"), s.execSandbox)
+	r.GET("/sandboxes/:id", s.getSandbox)
+	r.DELETE("/sandboxes/:id", s.audit.middleware("delete"), s.deleteSandbox)
+	return r
+}
+
+// runBatchOp replays op as a request to its endpoint, carrying over parent's
+// headers and client identity so request ids, tokens and audit principals match.
+func (s *server) runBatchOp(parent *http.Request, op api.BatchOp) api.BatchResult {
+	res := api.BatchResult{Op: op.Op}
+	params := op.Params
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	var target struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &target); err != nil {
+		return batchError(res, "params must be a JSON object: "+err.Error())
+	}
+	var method, path string
+	var body []byte
+	switch op.Op {
+	case "create":
+		method, path, body = http.MethodPost, "/sandboxes", params
+	case "exec":
+		method, path, body = http.MethodPost, "/sandboxes/"+url.PathEscape(target.ID)+"/exec", params
+	case "status":
+		method, path = http.MethodGet, "/sandboxes/"+url.PathEscape(target.ID)
+	case "delete":
+		method, path = http.MethodDelete, "/sandboxes/"+url.PathEscape(target.ID)
+	default:
+		return batchError(res, fmt.Sprintf("unknown op %q (want create, exec, delete or status)", op.Op))
+	}
+	if op.Op != "create" && target.ID == "" {
+		return batchError(res, "params.id is required")
+	}
+	req, err := http.NewRequestWithContext(parent.Context(), method, path, bytes.NewReader(body))
+	if err != nil {
+		return batchError(res, err.Error())
+	}
+	req.Header = parent.Header.Clone()
+	// The key identifies the batch request, not each create in it.
+	req.Header.Del(idempotencyKeyHeader)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = parent.RemoteAddr
+	req.TLS = parent.TLS
+	rec := httptest.NewRecorder()
+	s.batchRoutes.ServeHTTP(rec, req)
+	res.Status = rec.Code
+	res.Body = json.RawMessage(rec.Body.Bytes())
+	return res
+}
+
+func batchError(res api.BatchResult, msg string) api.BatchResult {
+	res.Status = 400
+	res.Body, _ = json.Marshal(map[string]string{"error": msg, "code": errCodeInvalidRequest})
+	return res
+}
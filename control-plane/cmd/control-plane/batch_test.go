@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestBatchMixedOps(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}}, readyPod("sbx-a"))
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+		fmt.Fprint(opts.Stdout, strings.Join(cmd, " "))
+		return nil
+	}
+	op := func(name, params string) api.BatchOp {
+		return api.BatchOp{Op: name, Params: json.RawMessage(params)}
+	}
+	ops := []api.BatchOp{
+		op("create", `{"id":"b"}`),
+		op("exec", `{"id":"sbx-a","command":["echo","hi"],"async":false}`),
+		op("status", `{"id":"sbx-a"}`),
+		op("delete", `{"id":"sbx-a"}`),
+		op("status", `{"id":"sbx-missing"}`),
+		op("exec", `{"command":["true"]}`),
+		op("restart", `{"id":"sbx-a"}`),
+	}
+	w := serve(s.batch, http.MethodPost, "/batch", "/batch", ops)
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var resp api.BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := []int{200, 200, 200, 200, 404, 400, 400}
+	if len(resp.Results) != len(wantStatus) {
+		t.Fatalf("%d results, want %d", len(resp.Results), len(wantStatus))
+	}
+	for i, res := range resp.Results {
+		if res.Op != ops[i].Op || res.Status != wantStatus[i] {
+			t.Errorf("result %d = %s %d %s, want %s %d", i, res.Op, res.Status, res.Body, ops[i].Op, wantStatus[i])
+		}
+	}
+
+	var created api.CreateSandboxResponse
+	if err := json.Unmarshal(resp.Results[0].Body, &created); err != nil || created.ID != "sbx-b" {
+		t.Errorf("create body = %s, want sandbox sbx-b", resp.Results[0].Body)
+	}
+	var execResp api.ExecResponse
+	if err := json.Unmarshal(resp.Results[1].Body, &execResp); err != nil || !strings.Contains(execResp.Stdout, "echo hi") {
+		t.Errorf("exec body = %s, want the command's output", resp.Results[1].Body)
+	}
+}
+
+func TestBatchParallel(t *testing.T) {
+	s := newTestServer()
+	ops := make([]api.BatchOp, 5)
+	for i := range ops {
+		ops[i] = api.BatchOp{Op: "create", Params: json.RawMessage(fmt.Sprintf(`{"id":"p%d"}`, i))}
+	}
+	w := serve(s.batch, http.MethodPost, "/batch", "/batch?parallel=true", ops)
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var resp api.BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range resp.Results {
+		var created api.CreateSandboxResponse
+		_ = json.Unmarshal(res.Body, &created)
+		if res.Status != 200 || created.ID != fmt.Sprintf("sbx-p%d", i) {
+			t.Errorf("result %d = %d %s, want sandbox sbx-p%d in request order", i, res.Status, res.Body, i)
+		}
+	}
+
+	if w := serve(s.batch, http.MethodPost, "/batch", "/batch", []api.BatchOp{}); w.Code != 400 {
+		t.Errorf("empty batch: status = %d, want 400", w.Code)
+	}
+}
+
+func TestBatchConcurrencyLimit(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}}, readyPod("sbx-a"))
+	var mu sync.Mutex
+	running, peak := 0, 0
+	s.podExec = func(_ context.Context, _, _, _ string, _ []string, _ remotecommand.StreamOptions) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+	ops := make([]api.BatchOp, 8)
+	for i := range ops {
+		ops[i] = api.BatchOp{Op: "exec", Params: json.RawMessage(`{"id":"sbx-a","command":["true"],"async":false}`)}
+	}
+	w := serve(s.batch, http.MethodPost, "/batch", "/batch?concurrency=3", ops)
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	if peak != 3 {
+		t.Errorf("peak concurrent ops = %d, want 3", peak)
+	}
+
+	t.Setenv("SANDBOX_BATCH_MAX_CONCURRENCY", "4")
+	for _, q := range []string{"concurrency=5", "concurrency=0", "concurrency=x"} {
+		if w := serve(s.batch, http.MethodPost, "/batch", "/batch?"+q, ops); w.Code != 400 {
+			t.Errorf("%s: status = %d, want 400", q, w.Code)
+		}
+	}
+}
+
+func TestBatchStreamsResults(t *testing.T) {
+	s := newTestServer(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-slow"}}, readyPod("sbx-slow"),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-fast"}}, readyPod("sbx-fast"),
+	)
+	release := make(chan struct{})
+	s.podExec = func(_ context.Context, ns, _, _ string, _ []string, opts remotecommand.StreamOptions) error {
+		if ns == "sbx-slow" {
+			<-release
+		}
+		fmt.Fprint(opts.Stdout, ns)
+		return nil
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/batch", s.batch)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	body := `[{"op":"exec","params":{"id":"sbx-slow","command":["x"],"async":false}},` +
+		`{"op":"exec","params":{"id":"sbx-fast","command":["x"],"async":false}}]`
+	resp, err := http.Post(srv.URL+"/batch?concurrency=2&stream=true", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("content type %q, want text/event-stream", ct)
+	}
+	events := bufio.NewScanner(resp.Body)
+	next := func() (string, string) {
+		t.Helper()
+		var event string
+		for events.Scan() {
+			line := events.Text()
+			if v, ok := strings.CutPrefix(line, "event:"); ok {
+				event = v
+			} else if v, ok := strings.CutPrefix(line, "data:"); ok {
+				return event, v
+			}
+		}
+		t.Fatalf("stream ended early: %v", events.Err())
+		return "", ""
+	}
+
+	// The fast op's result arrives while the slow one is still running.
+	event, data := next()
+	var first api.BatchStreamResult
+	if err := json.Unmarshal([]byte(data), &first); err != nil || event != "result" || first.Index != 1 || first.Status != 200 {
+		t.Fatalf("first event = %s %s, want the result of op 1", event, data)
+	}
+	close(release)
+	event, data = next()
+	var second api.BatchStreamResult
+	if err := json.Unmarshal([]byte(data), &second); err != nil || event != "result" || second.Index != 0 || !strings.Contains(string(second.Body), "sbx-slow") {
+		t.Fatalf("second event = %s %s, want the result of op 0", event, data)
+	}
+	if event, _ := next(); event != "done" {
+		t.Errorf("last event = %s, want done", event)
+	}
+}
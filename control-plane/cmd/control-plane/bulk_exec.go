@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const defaultBulkExecConcurrency = 10
+
+// bulkExec runs the same command in every sandbox whose namespace matches the
+// ?selector= label selector. Async execs return per-sandbox exec ids; sync execs
+// return each sandbox's output. At most SANDBOX_BULK_EXEC_CONCURRENCY sandboxes are
+// handled at once.
+func (s *server) bulkExec(c *gin.Context) {
+	selector, err := labels.Parse(c.Query("selector"))
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "invalid selector: "+err.Error())
+		return
+	}
+	if selector.Empty() {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "selector is required")
+		return
+	}
+	var req api.ExecRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	command, err := execCommandFromRequest(req)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	timeoutSeconds, err := resolveExecTimeoutSeconds(req.TimeoutSeconds)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkExecAllowlist(req, command); err != nil {
+		writeErrorCode(c, 403, errCodeCommandNotAllowed, err.Error())
+		return
+	}
+	command = wrapExecCommand(command, timeoutSeconds)
+	c.Set(auditCommandKey, command)
+	useAsync := getenvBool("SANDBOX_ASYNC_EXEC", true)
+	if req.Async != nil {
+		useAsync = *req.Async
+	}
+
+	namespaces, err := s.sandboxNamespaces(c.Request.Context(), selector)
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	var targets []string
+	for _, ns := range namespaces {
+		if !strings.HasPrefix(ns.Name, "sbx-") || ns.DeletionTimestamp != nil || isArchived(&ns) {
+			continue
+		}
+		if ns.Labels["sbx.allocated"] == "false" {
+			// Unclaimed warm pool slots aren't anyone's sandbox yet.
+			continue
+		}
+		targets = append(targets, ns.Name)
+	}
+
+	concurrency := getenvInt("SANDBOX_BULK_EXEC_CONCURRENCY", defaultBulkExecConcurrency)
+	if concurrency <= 0 {
+		concurrency = defaultBulkExecConcurrency
+	}
+	results := make([]api.BulkExecResult, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, ns := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ns string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = s.bulkExecOne(c.Request.Context(), ns, command, timeoutSeconds, useAsync)
+		}(i, ns)
+	}
+	wg.Wait()
+	writeJSON(c, 200, api.BulkExecResponse{Selector: selector.String(), Results: results})
+}
+
+func (s *server) bulkExecOne(ctx context.Context, ns string, command []string, timeoutSeconds *int, async bool) api.BulkExecResult {
+	const podName = "sandbox"
+	acceptedAt := time.Now()
+	res := api.BulkExecResult{ID: ns}
+	readyCtx, cancel := context.WithTimeout(ctx, defaultWaitReady)
+	err := s.waitForPodReady(readyCtx, ns, podName)
+	cancel()
+	if err != nil {
+		res.Status = execStatusFailed
+		res.Error = "sandbox not ready: " + err.Error()
+		return res
+	}
+	release, ok := s.execSlots.tryAcquire(ns)
+	if !ok {
+		res.Status = execStatusFailed
+		res.Error = "the sandbox is running its limit of execs"
+		return res
+	}
+	if async {
+		res.ExecID = s.startAsyncExec(ns, podName, command, timeoutSeconds, acceptedAt, release)
+		res.Status = execStatusRunning
+		return res
+	}
+	execCtx := ctx
+	if timeoutSeconds != nil {
+		var execCancel context.CancelFunc
+		execCtx, execCancel = context.WithTimeout(ctx, time.Duration(*timeoutSeconds)*time.Second)
+		defer execCancel()
+	}
+	res.Stdout, res.Stderr, err = s.execCommand(execCtx, ns, podName, "sandbox", syncExecCommand(command))
+	release()
+	_ = s.updateLastExec(ctx, ns)
+	metricExecs.Add(1)
+	switch code, ok := exitCodeFromErr(err); {
+	case err == nil:
+		res.Status = execStatusCompleted
+		res.ExitCode = intPtr(0)
+	case ok:
+		res.Status = execStatusFailed
+		res.ExitCode = intPtr(code)
+	default:
+		res.Status = execStatusFailed
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/remotecommand"
+	utilsexec "k8s.io/client-go/util/exec"
+)
+
+func TestBulkExecTargetsSelectedSandboxes(t *testing.T) {
+	var objs []runtime.Object
+	for name, labels := range map[string]map[string]string{
+		"sbx-a":    {"team": "ci"},
+		"sbx-b":    {"team": "ci"},
+		"sbx-c":    {"team": "web"},
+		"sbx-warm": {"team": "ci", "sbx.allocated": "false"},
+	} {
+		objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}, readyPod(name))
+	}
+	s := newTestServer(objs...)
+	s.podExec = func(_ context.Context, ns, _, _ string, _ []string, opts remotecommand.StreamOptions) error {
+		if ns == "sbx-b" {
+			return utilsexec.CodeExitError{Err: errExit, Code: 3}
+		}
+		_, _ = io.WriteString(opts.Stdout, "pulled "+ns)
+		return nil
+	}
+
+	async := false
+	w := serve(s.bulkExec, "POST", "/sandboxes/exec", "/sandboxes/exec?selector=team%3Dci", api.ExecRequest{Command: []string{"git", "pull"}, Async: &async})
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var resp api.BulkExecResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]api.BulkExecResult{}
+	for _, r := range resp.Results {
+		got[r.ID] = r
+	}
+	if len(got) != 2 {
+		t.Fatalf("results = %+v, want sbx-a and sbx-b only", resp.Results)
+	}
+	if r := got["sbx-a"]; r.Status != execStatusCompleted || r.Stdout != "pulled sbx-a" {
+		t.Errorf("sbx-a = %+v, want completed with output", r)
+	}
+	if r := got["sbx-b"]; r.Status != execStatusFailed || r.ExitCode == nil || *r.ExitCode != 3 {
+		t.Errorf("sbx-b = %+v, want failed with exit code 3", r)
+	}
+}
+
+func TestBulkExecRequiresSelector(t *testing.T) {
+	s := newTestServer()
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		return errors.New("exec without a selector")
+	}
+	w := serve(s.bulkExec, "POST", "/sandboxes/exec", "/sandboxes/exec", api.ExecRequest{Command: []string{"true"}})
+	if w.Code != 400 {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
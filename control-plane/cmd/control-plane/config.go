@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,34 +14,114 @@ import (
 )
 
 type Config struct {
-	Image                string            `yaml:"image"`
-	VolumeMode           string            `yaml:"volume_mode"`
-	CacheMode            string            `yaml:"cache_mode"`
-	CacheHostPath        string            `yaml:"cache_hostpath"`
-	CachePVCSize         string            `yaml:"cache_pvc_size"`
-	CachePVCStorageClass string            `yaml:"cache_pvc_storage_class"`
-	CachePVCAccessMode   string            `yaml:"cache_pvc_access_mode"`
-	WarmPoolSize         int               `yaml:"warm_pool_size"`
-	WarmPoolAutosize     bool              `yaml:"warm_pool_autosize"`
-	WarmPoolMin          int               `yaml:"warm_pool_min"`
-	WarmPoolMax          int               `yaml:"warm_pool_max"`
-	IdleTTL              string            `yaml:"idle_ttl"`
-	CreateReadyTimeout   string            `yaml:"create_ready_timeout"`
-	CPURequest           string            `yaml:"cpu_request"`
-	MemRequest           string            `yaml:"mem_request"`
-	CPULimit             string            `yaml:"cpu_limit"`
-	MemLimit             string            `yaml:"mem_limit"`
-	AllowedHosts         []string          `yaml:"allowed_hosts"`
-	DisallowedHosts      []string          `yaml:"disallowed_hosts"`
-	Env                  map[string]string `yaml:"env"`
-	StreamSidecarImage   string            `yaml:"stream_sidecar_image"`
-	StreamEndpoint       string            `yaml:"stream_endpoint"`
-	StreamEventsDir      string            `yaml:"stream_events_dir"`
-	StreamBuffer         int               `yaml:"stream_buffer"`
-	AsyncExec            *bool             `yaml:"async_exec"`
-	ExecStatusRetention  string            `yaml:"exec_status_retention"`
-	ExecTimeout          string            `yaml:"exec_timeout"`
-	ExecMaxTimeout       string            `yaml:"exec_max_timeout"`
+	Image                 string            `yaml:"image"`
+	VolumeMode            string            `yaml:"volume_mode"`
+	WorkspacePath         string            `yaml:"workspace_path"`
+	CachePath             string            `yaml:"cache_path"`
+	CacheMode             string            `yaml:"cache_mode"`
+	CacheHostPath         string            `yaml:"cache_hostpath"`
+	CachePVCSize          string            `yaml:"cache_pvc_size"`
+	CachePVCStorageClass  string            `yaml:"cache_pvc_storage_class"`
+	CachePVCAccessMode    string            `yaml:"cache_pvc_access_mode"`
+	CacheSharedNamespace  string            `yaml:"cache_shared_namespace"`
+	CacheSharedPVC        string            `yaml:"cache_shared_pvc"`
+	CacheSharedReadOnly   bool              `yaml:"cache_shared_read_only"`
+	WarmPoolSize          int               `yaml:"warm_pool_size"`
+	WarmPoolAutosize      bool              `yaml:"warm_pool_autosize"`
+	WarmPoolMin           int               `yaml:"warm_pool_min"`
+	WarmPoolMax           int               `yaml:"warm_pool_max"`
+	WarmWindow            string            `yaml:"warm_window"`
+	WarmLabels            map[string]string `yaml:"warm_labels"`
+	WarmAnnotations       map[string]string `yaml:"warm_annotations"`
+	WarmVerify            bool              `yaml:"warm_verify"`
+	WarmVerifyTimeout     string            `yaml:"warm_verify_timeout"`
+	WarmSetup             string            `yaml:"warm_setup"`
+	WarmSetupTimeout      string            `yaml:"warm_setup_timeout"`
+	WarmUnhealthyAfter    string            `yaml:"warm_unhealthy_after"`
+	WarmMaxCreateErrors   int               `yaml:"warm_max_create_errors"`
+	IdleTTL               string            `yaml:"idle_ttl"`
+	MinAgeBeforeReap      string            `yaml:"min_age_before_reap"`
+	ArchiveTTL            string            `yaml:"archive_ttl"`
+	KeptTTL               string            `yaml:"kept_ttl"`
+	ReapOrphans           bool              `yaml:"reap_orphans"`
+	AuditLog              string            `yaml:"audit_log"`
+	RequireDigest         bool              `yaml:"require_digest"`
+	ExecWrapper           string            `yaml:"exec_wrapper"`
+	ExecAllowlist         string            `yaml:"exec_allowlist"`
+	ExecShell             string            `yaml:"exec_shell"`
+	ExecPathPrepend       string            `yaml:"exec_path_prepend"`
+	ExecLoginShell        bool              `yaml:"exec_login_shell"`
+	RedactEnvKeys         []string          `yaml:"redact_env_keys"`
+	RejectLatest          bool              `yaml:"reject_latest"`
+	AllowedRegistries     []string          `yaml:"allowed_registries"`
+	AuditRedactCommands   bool              `yaml:"audit_redact_commands"`
+	OrphanGrace           string            `yaml:"orphan_grace"`
+	CreateReadyTimeout    string            `yaml:"create_ready_timeout"`
+	ReadyPollInterval     string            `yaml:"ready_poll_interval"`
+	TerminatingWait       string            `yaml:"terminating_wait"`
+	ForceDeleteWait       string            `yaml:"force_delete_wait"`
+	IdempotencyTTL        string            `yaml:"idempotency_ttl"`
+	CPURequest            string            `yaml:"cpu_request"`
+	MemRequest            string            `yaml:"mem_request"`
+	CPULimit              string            `yaml:"cpu_limit"`
+	MemLimit              string            `yaml:"mem_limit"`
+	ApplyResourceQuota    *bool             `yaml:"apply_resource_quota"`
+	ApplyLimitRange       *bool             `yaml:"apply_limit_range"`
+	QuotaPods             string            `yaml:"quota_pods"`
+	QuotaCPU              string            `yaml:"quota_cpu"`
+	QuotaMemory           string            `yaml:"quota_memory"`
+	AllowedHosts          []string          `yaml:"allowed_hosts"`
+	DisallowedHosts       []string          `yaml:"disallowed_hosts"`
+	ForceDisallowedHosts  []string          `yaml:"force_disallowed_hosts"`
+	Env                   map[string]string `yaml:"env"`
+	HTTPProxy             string            `yaml:"http_proxy"`
+	HTTPSProxy            string            `yaml:"https_proxy"`
+	NoProxy               string            `yaml:"no_proxy"`
+	StreamSidecarImage    string            `yaml:"stream_sidecar_image"`
+	GitImage              string            `yaml:"git_image"`
+	StreamEndpoint        string            `yaml:"stream_endpoint"`
+	StreamEventsDir       string            `yaml:"stream_events_dir"`
+	StreamBuffer          int               `yaml:"stream_buffer"`
+	StreamRateBytes       int               `yaml:"stream_rate_bytes"`
+	StreamStatsInterval   string            `yaml:"stream_stats_interval"`
+	StreamReliableTimeout string            `yaml:"stream_reliable_timeout"`
+	StreamRecordStdin     bool              `yaml:"stream_record_stdin"`
+	StreamStdinRedact     []string          `yaml:"stream_stdin_redact"`
+	AsyncExec             *bool             `yaml:"async_exec"`
+	ExecStatusRetention   string            `yaml:"exec_status_retention"`
+	ExecCacheTTL          string            `yaml:"exec_cache_ttl"`
+	ExecOutputTailBytes   int               `yaml:"exec_output_tail_bytes"`
+	BulkExecConcurrency   int               `yaml:"bulk_exec_concurrency"`
+	BatchMaxConcurrency   int               `yaml:"batch_max_concurrency"`
+	ExecTimeout           string            `yaml:"exec_timeout"`
+	ExecMaxTimeout        string            `yaml:"exec_max_timeout"`
+	ExecCancelGrace       string            `yaml:"exec_cancel_grace"`
+	ExecQueueTimeout      string            `yaml:"exec_queue_timeout"`
+	MaxConcurrentExecs    int               `yaml:"max_concurrent_execs"`
+	MaxQueuedExecs        int               `yaml:"max_queued_execs"`
+	MaxRequestBytes       int               `yaml:"max_request_bytes"`
+	MaxEnvValueBytes      int               `yaml:"max_env_value_bytes"`
+	MaxEnvBytes           int               `yaml:"max_env_bytes"`
+	MaxConcurrentCreates  int               `yaml:"max_concurrent_creates"`
+	K8sQPS                string            `yaml:"k8s_qps"`
+	K8sBurst              int               `yaml:"k8s_burst"`
+	TLSCert               string            `yaml:"tls_cert"`
+	TLSKey                string            `yaml:"tls_key"`
+	TLSClientCA           string            `yaml:"tls_client_ca"`
+	GRPCAddr              string            `yaml:"grpc_addr"`
+	ServiceAccount        string            `yaml:"service_account"`
+	SingleNamespace       string            `yaml:"single_namespace"`
+	PriorityClass         string            `yaml:"priority_class"`
+	MetricsLabels         map[string]string `yaml:"metrics_labels"`
+	Spread                bool              `yaml:"spread"`
+	UseInit               bool              `yaml:"use_init"`
+	TopologySpread        string            `yaml:"topology_spread"`
+	DNSPolicy             string            `yaml:"dns_policy"`
+	DNSServers            []string          `yaml:"dns_servers"`
+	AllowPodOverlay       bool              `yaml:"allow_pod_overlay"`
+	AutomountSAToken      *bool             `yaml:"automount_service_account_token"`
+	Hardened              bool              `yaml:"hardened"`
+	DefaultTolerations    *bool             `yaml:"default_tolerations"`
 }
 
 var (
@@ -55,12 +140,31 @@ func loadConfig() (Config, error) {
 		return Config{}, err
 	}
 	var cfg Config
+	if configStrict() {
+		// Strict mode rejects unknown keys; yaml.v3 reports the line and field name.
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return cfg, nil
+	}
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return Config{}, err
 	}
 	return cfg, nil
 }
 
+// configStrict reads SANDBOX_CONFIG_STRICT from the process env only, since it
+// controls how the config file itself is parsed.
+func configStrict() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SANDBOX_CONFIG_STRICT"))) {
+	case "1", "true", "yes", "y", "on":
+		return true
+	}
+	return false
+}
+
 func getConfig() (Config, error) {
 	configOnce.Do(func() {
 		config, configErr = loadConfig()
@@ -82,6 +186,14 @@ func configString(key string) (string, bool) {
 		if cfg.VolumeMode != "" {
 			return cfg.VolumeMode, true
 		}
+	case "SANDBOX_WORKSPACE_PATH":
+		if cfg.WorkspacePath != "" {
+			return cfg.WorkspacePath, true
+		}
+	case "SANDBOX_CACHE_PATH":
+		if cfg.CachePath != "" {
+			return cfg.CachePath, true
+		}
 	case "SANDBOX_CACHE_MODE":
 		if cfg.CacheMode != "" {
 			return cfg.CacheMode, true
@@ -102,14 +214,106 @@ func configString(key string) (string, bool) {
 		if cfg.CachePVCAccessMode != "" {
 			return cfg.CachePVCAccessMode, true
 		}
+	case "SANDBOX_CACHE_SHARED_NAMESPACE":
+		if cfg.CacheSharedNamespace != "" {
+			return cfg.CacheSharedNamespace, true
+		}
+	case "SANDBOX_CACHE_SHARED_PVC":
+		if cfg.CacheSharedPVC != "" {
+			return cfg.CacheSharedPVC, true
+		}
 	case "SANDBOX_IDLE_TTL":
 		if cfg.IdleTTL != "" {
 			return cfg.IdleTTL, true
 		}
+	case "SANDBOX_MIN_AGE_BEFORE_REAP":
+		if cfg.MinAgeBeforeReap != "" {
+			return cfg.MinAgeBeforeReap, true
+		}
+	case "SANDBOX_WARM_WINDOW":
+		if cfg.WarmWindow != "" {
+			return cfg.WarmWindow, true
+		}
+	case "SANDBOX_WARM_VERIFY_TIMEOUT":
+		if cfg.WarmVerifyTimeout != "" {
+			return cfg.WarmVerifyTimeout, true
+		}
+	case "SANDBOX_WARM_SETUP":
+		if cfg.WarmSetup != "" {
+			return cfg.WarmSetup, true
+		}
+	case "SANDBOX_WARM_SETUP_TIMEOUT":
+		if cfg.WarmSetupTimeout != "" {
+			return cfg.WarmSetupTimeout, true
+		}
+	case "SANDBOX_WARM_UNHEALTHY_AFTER":
+		if cfg.WarmUnhealthyAfter != "" {
+			return cfg.WarmUnhealthyAfter, true
+		}
+	case "SANDBOX_WARM_LABELS":
+		if len(cfg.WarmLabels) > 0 {
+			return joinKV(cfg.WarmLabels), true
+		}
+	case "SANDBOX_WARM_ANNOTATIONS":
+		if len(cfg.WarmAnnotations) > 0 {
+			return joinKV(cfg.WarmAnnotations), true
+		}
+	case "SANDBOX_ARCHIVE_TTL":
+		if cfg.ArchiveTTL != "" {
+			return cfg.ArchiveTTL, true
+		}
+	case "SANDBOX_KEPT_TTL":
+		if cfg.KeptTTL != "" {
+			return cfg.KeptTTL, true
+		}
+	case "SANDBOX_ORPHAN_GRACE":
+		if cfg.OrphanGrace != "" {
+			return cfg.OrphanGrace, true
+		}
+	case "SANDBOX_AUDIT_LOG":
+		if cfg.AuditLog != "" {
+			return cfg.AuditLog, true
+		}
+	case "SANDBOX_EXEC_WRAPPER":
+		if cfg.ExecWrapper != "" {
+			return cfg.ExecWrapper, true
+		}
+	case "SANDBOX_EXEC_ALLOWLIST":
+		if cfg.ExecAllowlist != "" {
+			return cfg.ExecAllowlist, true
+		}
+	case "SANDBOX_EXEC_SHELL":
+		if cfg.ExecShell != "" {
+			return cfg.ExecShell, true
+		}
+	case "SANDBOX_EXEC_PATH_PREPEND":
+		if cfg.ExecPathPrepend != "" {
+			return cfg.ExecPathPrepend, true
+		}
+	case "SANDBOX_REDACT_ENV_KEYS":
+		if len(cfg.RedactEnvKeys) > 0 {
+			return joinCSV(cfg.RedactEnvKeys), true
+		}
 	case "SANDBOX_CREATE_READY_TIMEOUT":
 		if cfg.CreateReadyTimeout != "" {
 			return cfg.CreateReadyTimeout, true
 		}
+	case "SANDBOX_READY_POLL_INTERVAL":
+		if cfg.ReadyPollInterval != "" {
+			return cfg.ReadyPollInterval, true
+		}
+	case "SANDBOX_TERMINATING_WAIT":
+		if cfg.TerminatingWait != "" {
+			return cfg.TerminatingWait, true
+		}
+	case "SANDBOX_FORCE_DELETE_WAIT":
+		if cfg.ForceDeleteWait != "" {
+			return cfg.ForceDeleteWait, true
+		}
+	case "SANDBOX_IDEMPOTENCY_TTL":
+		if cfg.IdempotencyTTL != "" {
+			return cfg.IdempotencyTTL, true
+		}
 	case "SANDBOX_CPU_REQUEST":
 		if cfg.CPURequest != "" {
 			return cfg.CPURequest, true
@@ -130,6 +334,10 @@ func configString(key string) (string, bool) {
 		if cfg.StreamSidecarImage != "" {
 			return cfg.StreamSidecarImage, true
 		}
+	case "SANDBOX_GIT_IMAGE":
+		if cfg.GitImage != "" {
+			return cfg.GitImage, true
+		}
 	case "SANDBOX_STREAM_ENDPOINT":
 		if cfg.StreamEndpoint != "" {
 			return cfg.StreamEndpoint, true
@@ -142,6 +350,10 @@ func configString(key string) (string, bool) {
 		if cfg.ExecStatusRetention != "" {
 			return cfg.ExecStatusRetention, true
 		}
+	case "SANDBOX_EXEC_CACHE_TTL":
+		if cfg.ExecCacheTTL != "" {
+			return cfg.ExecCacheTTL, true
+		}
 	case "SANDBOX_EXEC_TIMEOUT":
 		if cfg.ExecTimeout != "" {
 			return cfg.ExecTimeout, true
@@ -150,6 +362,106 @@ func configString(key string) (string, bool) {
 		if cfg.ExecMaxTimeout != "" {
 			return cfg.ExecMaxTimeout, true
 		}
+	case "SANDBOX_EXEC_CANCEL_GRACE":
+		if cfg.ExecCancelGrace != "" {
+			return cfg.ExecCancelGrace, true
+		}
+	case "SANDBOX_EXEC_QUEUE_TIMEOUT":
+		if cfg.ExecQueueTimeout != "" {
+			return cfg.ExecQueueTimeout, true
+		}
+	case "SANDBOX_STREAM_STATS_INTERVAL":
+		if cfg.StreamStatsInterval != "" {
+			return cfg.StreamStatsInterval, true
+		}
+	case "SANDBOX_STREAM_RELIABLE_TIMEOUT":
+		if cfg.StreamReliableTimeout != "" {
+			return cfg.StreamReliableTimeout, true
+		}
+	case "SANDBOX_STREAM_STDIN_REDACT":
+		if len(cfg.StreamStdinRedact) > 0 {
+			return joinCSV(cfg.StreamStdinRedact), true
+		}
+	case "SANDBOX_K8S_QPS":
+		if cfg.K8sQPS != "" {
+			return cfg.K8sQPS, true
+		}
+	case "SANDBOX_TLS_CERT":
+		if cfg.TLSCert != "" {
+			return cfg.TLSCert, true
+		}
+	case "SANDBOX_TLS_KEY":
+		if cfg.TLSKey != "" {
+			return cfg.TLSKey, true
+		}
+	case "SANDBOX_TLS_CLIENT_CA":
+		if cfg.TLSClientCA != "" {
+			return cfg.TLSClientCA, true
+		}
+	case "SANDBOX_GRPC_ADDR":
+		if cfg.GRPCAddr != "" {
+			return cfg.GRPCAddr, true
+		}
+	case "SANDBOX_SERVICE_ACCOUNT":
+		if cfg.ServiceAccount != "" {
+			return cfg.ServiceAccount, true
+		}
+	case "SANDBOX_SINGLE_NAMESPACE":
+		if cfg.SingleNamespace != "" {
+			return cfg.SingleNamespace, true
+		}
+	case "SANDBOX_QUOTA_PODS":
+		if cfg.QuotaPods != "" {
+			return cfg.QuotaPods, true
+		}
+	case "SANDBOX_QUOTA_CPU":
+		if cfg.QuotaCPU != "" {
+			return cfg.QuotaCPU, true
+		}
+	case "SANDBOX_QUOTA_MEMORY":
+		if cfg.QuotaMemory != "" {
+			return cfg.QuotaMemory, true
+		}
+	case "SANDBOX_TOPOLOGY_SPREAD":
+		if cfg.TopologySpread != "" {
+			return cfg.TopologySpread, true
+		}
+	case "SANDBOX_DNS_POLICY":
+		if cfg.DNSPolicy != "" {
+			return cfg.DNSPolicy, true
+		}
+	case "SANDBOX_DNS_SERVERS":
+		if len(cfg.DNSServers) > 0 {
+			return joinCSV(cfg.DNSServers), true
+		}
+	case "SANDBOX_PRIORITY_CLASS":
+		if cfg.PriorityClass != "" {
+			return cfg.PriorityClass, true
+		}
+	case "SANDBOX_METRICS_LABELS":
+		if len(cfg.MetricsLabels) > 0 {
+			return joinKV(cfg.MetricsLabels), true
+		}
+	case "SANDBOX_ALLOWED_REGISTRIES":
+		if len(cfg.AllowedRegistries) > 0 {
+			return joinCSV(cfg.AllowedRegistries), true
+		}
+	case "SANDBOX_FORCE_DISALLOWED_HOSTS":
+		if len(cfg.ForceDisallowedHosts) > 0 {
+			return joinCSV(cfg.ForceDisallowedHosts), true
+		}
+	case "SANDBOX_HTTP_PROXY":
+		if cfg.HTTPProxy != "" {
+			return cfg.HTTPProxy, true
+		}
+	case "SANDBOX_HTTPS_PROXY":
+		if cfg.HTTPSProxy != "" {
+			return cfg.HTTPSProxy, true
+		}
+	case "SANDBOX_NO_PROXY":
+		if cfg.NoProxy != "" {
+			return cfg.NoProxy, true
+		}
 	}
 	return "", false
 }
@@ -164,6 +476,46 @@ func configInt(key string) (int, bool) {
 		if cfg.WarmPoolSize != 0 {
 			return cfg.WarmPoolSize, true
 		}
+	case "SANDBOX_MAX_REQUEST_BYTES":
+		if cfg.MaxRequestBytes != 0 {
+			return cfg.MaxRequestBytes, true
+		}
+	case "SANDBOX_MAX_ENV_VALUE_BYTES":
+		if cfg.MaxEnvValueBytes != 0 {
+			return cfg.MaxEnvValueBytes, true
+		}
+	case "SANDBOX_MAX_ENV_BYTES":
+		if cfg.MaxEnvBytes != 0 {
+			return cfg.MaxEnvBytes, true
+		}
+	case "SANDBOX_MAX_CONCURRENT_CREATES":
+		if cfg.MaxConcurrentCreates != 0 {
+			return cfg.MaxConcurrentCreates, true
+		}
+	case "SANDBOX_MAX_CONCURRENT_EXECS":
+		if cfg.MaxConcurrentExecs != 0 {
+			return cfg.MaxConcurrentExecs, true
+		}
+	case "SANDBOX_MAX_QUEUED_EXECS":
+		if cfg.MaxQueuedExecs != 0 {
+			return cfg.MaxQueuedExecs, true
+		}
+	case "SANDBOX_EXEC_OUTPUT_TAIL_BYTES":
+		if cfg.ExecOutputTailBytes != 0 {
+			return cfg.ExecOutputTailBytes, true
+		}
+	case "SANDBOX_BULK_EXEC_CONCURRENCY":
+		if cfg.BulkExecConcurrency != 0 {
+			return cfg.BulkExecConcurrency, true
+		}
+	case "SANDBOX_BATCH_MAX_CONCURRENCY":
+		if cfg.BatchMaxConcurrency != 0 {
+			return cfg.BatchMaxConcurrency, true
+		}
+	case "SANDBOX_K8S_BURST":
+		if cfg.K8sBurst != 0 {
+			return cfg.K8sBurst, true
+		}
 	case "SANDBOX_WARM_POOL_MIN":
 		if cfg.WarmPoolMin != 0 {
 			return cfg.WarmPoolMin, true
@@ -172,10 +524,18 @@ func configInt(key string) (int, bool) {
 		if cfg.WarmPoolMax != 0 {
 			return cfg.WarmPoolMax, true
 		}
+	case "SANDBOX_WARM_MAX_CREATE_ERRORS":
+		if cfg.WarmMaxCreateErrors != 0 {
+			return cfg.WarmMaxCreateErrors, true
+		}
 	case "SANDBOX_STREAM_BUFFER":
 		if cfg.StreamBuffer != 0 {
 			return cfg.StreamBuffer, true
 		}
+	case "SANDBOX_STREAM_RATE_BYTES":
+		if cfg.StreamRateBytes != 0 {
+			return cfg.StreamRateBytes, true
+		}
 	case "SANDBOX_ASYNC_EXEC":
 		if cfg.AsyncExec != nil {
 			if *cfg.AsyncExec {
@@ -197,10 +557,74 @@ func configBool(key string) (bool, bool) {
 		if cfg.WarmPoolAutosize {
 			return true, true
 		}
+	case "SANDBOX_WARM_VERIFY":
+		if cfg.WarmVerify {
+			return true, true
+		}
+	case "SANDBOX_REAP_ORPHANS":
+		if cfg.ReapOrphans {
+			return true, true
+		}
+	case "SANDBOX_CACHE_SHARED_READ_ONLY":
+		if cfg.CacheSharedReadOnly {
+			return true, true
+		}
+	case "SANDBOX_STREAM_RECORD_STDIN":
+		if cfg.StreamRecordStdin {
+			return true, true
+		}
+	case "SANDBOX_SPREAD":
+		if cfg.Spread {
+			return true, true
+		}
+	case "SANDBOX_USE_INIT":
+		if cfg.UseInit {
+			return true, true
+		}
+	case "SANDBOX_ALLOW_POD_OVERLAY":
+		if cfg.AllowPodOverlay {
+			return true, true
+		}
+	case "SANDBOX_AUDIT_REDACT_COMMANDS":
+		if cfg.AuditRedactCommands {
+			return true, true
+		}
+	case "SANDBOX_REQUIRE_DIGEST":
+		if cfg.RequireDigest {
+			return true, true
+		}
+	case "SANDBOX_REJECT_LATEST":
+		if cfg.RejectLatest {
+			return true, true
+		}
+	case "SANDBOX_EXEC_LOGIN_SHELL":
+		if cfg.ExecLoginShell {
+			return true, true
+		}
 	case "SANDBOX_ASYNC_EXEC":
 		if cfg.AsyncExec != nil {
 			return *cfg.AsyncExec, true
 		}
+	case "SANDBOX_AUTOMOUNT_SERVICE_ACCOUNT_TOKEN":
+		if cfg.AutomountSAToken != nil {
+			return *cfg.AutomountSAToken, true
+		}
+	case "SANDBOX_HARDENED":
+		if cfg.Hardened {
+			return true, true
+		}
+	case "SANDBOX_DEFAULT_TOLERATIONS":
+		if cfg.DefaultTolerations != nil {
+			return *cfg.DefaultTolerations, true
+		}
+	case "SANDBOX_APPLY_RESOURCE_QUOTA":
+		if cfg.ApplyResourceQuota != nil {
+			return *cfg.ApplyResourceQuota, true
+		}
+	case "SANDBOX_APPLY_LIMIT_RANGE":
+		if cfg.ApplyLimitRange != nil {
+			return *cfg.ApplyLimitRange, true
+		}
 	}
 	return false, false
 }
@@ -217,18 +641,96 @@ func configDuration(key string) (time.Duration, bool) {
 				return d, true
 			}
 		}
+	case "SANDBOX_MIN_AGE_BEFORE_REAP":
+		if cfg.MinAgeBeforeReap != "" {
+			if d, err := time.ParseDuration(cfg.MinAgeBeforeReap); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_WARM_WINDOW":
+		if cfg.WarmWindow != "" {
+			if d, err := time.ParseDuration(cfg.WarmWindow); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_WARM_VERIFY_TIMEOUT":
+		if cfg.WarmVerifyTimeout != "" {
+			if d, err := time.ParseDuration(cfg.WarmVerifyTimeout); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_WARM_SETUP_TIMEOUT":
+		if cfg.WarmSetupTimeout != "" {
+			if d, err := time.ParseDuration(cfg.WarmSetupTimeout); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_WARM_UNHEALTHY_AFTER":
+		if cfg.WarmUnhealthyAfter != "" {
+			if d, err := time.ParseDuration(cfg.WarmUnhealthyAfter); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_ARCHIVE_TTL":
+		if cfg.ArchiveTTL != "" {
+			if d, err := time.ParseDuration(cfg.ArchiveTTL); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_KEPT_TTL":
+		if cfg.KeptTTL != "" {
+			if d, err := time.ParseDuration(cfg.KeptTTL); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_ORPHAN_GRACE":
+		if cfg.OrphanGrace != "" {
+			if d, err := time.ParseDuration(cfg.OrphanGrace); err == nil {
+				return d, true
+			}
+		}
 	case "SANDBOX_CREATE_READY_TIMEOUT":
 		if cfg.CreateReadyTimeout != "" {
 			if d, err := time.ParseDuration(cfg.CreateReadyTimeout); err == nil {
 				return d, true
 			}
 		}
+	case "SANDBOX_READY_POLL_INTERVAL":
+		if cfg.ReadyPollInterval != "" {
+			if d, err := time.ParseDuration(cfg.ReadyPollInterval); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_TERMINATING_WAIT":
+		if cfg.TerminatingWait != "" {
+			if d, err := time.ParseDuration(cfg.TerminatingWait); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_FORCE_DELETE_WAIT":
+		if cfg.ForceDeleteWait != "" {
+			if d, err := time.ParseDuration(cfg.ForceDeleteWait); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_IDEMPOTENCY_TTL":
+		if cfg.IdempotencyTTL != "" {
+			if d, err := time.ParseDuration(cfg.IdempotencyTTL); err == nil {
+				return d, true
+			}
+		}
 	case "SANDBOX_EXEC_STATUS_RETENTION":
 		if cfg.ExecStatusRetention != "" {
 			if d, err := time.ParseDuration(cfg.ExecStatusRetention); err == nil {
 				return d, true
 			}
 		}
+	case "SANDBOX_EXEC_CACHE_TTL":
+		if cfg.ExecCacheTTL != "" {
+			if d, err := time.ParseDuration(cfg.ExecCacheTTL); err == nil {
+				return d, true
+			}
+		}
 	case "SANDBOX_EXEC_TIMEOUT":
 		if cfg.ExecTimeout != "" {
 			if d, err := time.ParseDuration(cfg.ExecTimeout); err == nil {
@@ -241,6 +743,30 @@ func configDuration(key string) (time.Duration, bool) {
 				return d, true
 			}
 		}
+	case "SANDBOX_EXEC_CANCEL_GRACE":
+		if cfg.ExecCancelGrace != "" {
+			if d, err := time.ParseDuration(cfg.ExecCancelGrace); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_EXEC_QUEUE_TIMEOUT":
+		if cfg.ExecQueueTimeout != "" {
+			if d, err := time.ParseDuration(cfg.ExecQueueTimeout); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_STREAM_STATS_INTERVAL":
+		if cfg.StreamStatsInterval != "" {
+			if d, err := time.ParseDuration(cfg.StreamStatsInterval); err == nil {
+				return d, true
+			}
+		}
+	case "SANDBOX_STREAM_RELIABLE_TIMEOUT":
+		if cfg.StreamReliableTimeout != "" {
+			if d, err := time.ParseDuration(cfg.StreamReliableTimeout); err == nil {
+				return d, true
+			}
+		}
 	}
 	return 0, false
 }
@@ -253,6 +779,14 @@ func configAllowedHosts() ([]string, []string) {
 	return cfg.AllowedHosts, cfg.DisallowedHosts
 }
 
+func configForceDisallowedHosts() []string {
+	cfg, err := getConfig()
+	if err != nil {
+		return nil
+	}
+	return cfg.ForceDisallowedHosts
+}
+
 func configEnv() map[string]string {
 	cfg, err := getConfig()
 	if err != nil {
@@ -260,3 +794,17 @@ func configEnv() map[string]string {
 	}
 	return cfg.Env
 }
+
+// joinKV renders a map as the "key=value,key=value" form used by env settings.
+func joinKV(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+m[k])
+	}
+	return strings.Join(parts, ",")
+}
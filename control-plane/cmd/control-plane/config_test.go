@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, data string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SANDBOX_CONFIG", path)
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	t.Setenv("SANDBOX_CONFIG_STRICT", "1")
+
+	writeConfigFile(t, "warm_pool_size: 3\nservice_account: agent\n")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("good config: %v", err)
+	}
+	if cfg.WarmPoolSize != 3 || cfg.ServiceAccount != "agent" {
+		t.Fatalf("good config decoded as %+v", cfg)
+	}
+
+	writeConfigFile(t, "service_account: agent\nwarm_pool_sz: 3\n")
+	_, err = loadConfig()
+	if err == nil {
+		t.Fatal("unknown key accepted in strict mode")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "line 2") || !strings.Contains(msg, "warm_pool_sz") {
+		t.Fatalf("error %q doesn't name the field and line", msg)
+	}
+}
+
+func TestLoadConfigLenientByDefault(t *testing.T) {
+	writeConfigFile(t, "warm_pool_sz: 3\n")
+	if _, err := loadConfig(); err != nil {
+		t.Fatalf("unknown key rejected without SANDBOX_CONFIG_STRICT: %v", err)
+	}
+}
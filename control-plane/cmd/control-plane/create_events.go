@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// createStages are the progress phases after NamespaceCreated, in the order a cold
+// create passes them. Pulling is only seen while the sandbox container is being
+// created, so a warm pod skips it.
+var createStages = []struct {
+	phase   string
+	reached func(*corev1.Pod) bool
+}{
+	{"PodScheduled", func(pod *corev1.Pod) bool { return podCondition(pod, corev1.PodScheduled) }},
+	{"Pulling", func(pod *corev1.Pod) bool {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name == "sandbox" && cs.State.Waiting != nil && cs.State.Waiting.Reason == "ContainerCreating" {
+				return true
+			}
+		}
+		return false
+	}},
+	{"ContainersReady", func(pod *corev1.Pod) bool { return podCondition(pod, corev1.ContainersReady) }},
+	{"Ready", podReady},
+}
+
+// podStartFailures are container waiting reasons a pod doesn't come back from on
+// its own.
+var podStartFailures = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"CrashLoopBackOff":           true,
+}
+
+// createEvents streams a sandbox's progress towards Ready as server-sent events,
+// one "progress" event per phase, so a UI can show how far a cold create has got.
+// A client usually connects straight after POST /sandboxes, before the pod exists,
+// so the stream waits for it after NamespaceCreated. The stream ends after Ready or
+// Failed, or once SANDBOX_CREATE_READY_TIMEOUT has passed.
+func (s *server) createEvents(c *gin.Context) {
+	ns := c.Param("id")
+	_, err := s.client.CoreV1().Namespaces().Get(c.Request.Context(), ns, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || !strings.HasPrefix(ns, "sbx-") {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox not found")
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	timeout := getenvDuration("SANDBOX_CREATE_READY_TIMEOUT", 60*time.Second)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(200)
+	send := func(phase, message string) {
+		c.SSEvent("progress", api.CreateEvent{Phase: phase, Time: nowTS(), Message: message})
+		c.Writer.Flush()
+	}
+	send("NamespaceCreated", "")
+
+	sent := map[string]bool{}
+	err = s.waitForPod(ctx, ns, "sandbox")
+	if err == nil {
+		err = s.watchPod(ctx, ns, "sandbox", func(pod *corev1.Pod) (bool, error) {
+			for _, st := range createStages {
+				if !sent[st.phase] && st.reached(pod) {
+					sent[st.phase] = true
+					send(st.phase, "")
+				}
+			}
+			for _, cs := range pod.Status.ContainerStatuses {
+				if w := cs.State.Waiting; w != nil && podStartFailures[w.Reason] {
+					return true, fmt.Errorf("container %s: %s: %s", cs.Name, w.Reason, w.Message)
+				}
+			}
+			return podReadyState(pod)
+		})
+	}
+	switch {
+	case err == nil:
+	case c.Request.Context().Err() != nil:
+		// The client went away.
+	case errors.Is(err, context.DeadlineExceeded):
+		send("Failed", fmt.Sprintf("not ready after %s", timeout))
+	default:
+		send("Failed", err.Error())
+	}
+}
+
+// waitForPod returns once the sandbox pod exists, checking every
+// SANDBOX_READY_POLL_INTERVAL.
+func (s *server) waitForPod(ctx context.Context, ns, name string) error {
+	ns, name = sandboxPod(ns, name)
+	ticker := time.NewTicker(readyPollInterval())
+	defer ticker.Stop()
+	for {
+		_, err := s.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func podCondition(pod *corev1.Pod, t corev1.PodConditionType) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == t {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
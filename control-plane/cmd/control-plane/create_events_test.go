@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// parseCreateEvents decodes the progress events in an SSE body.
+func parseCreateEvents(t *testing.T, body string) []api.CreateEvent {
+	t.Helper()
+	var out []api.CreateEvent
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var evt api.CreateEvent
+		if err := json.Unmarshal([]byte(data), &evt); err != nil {
+			t.Fatalf("event %q: %v", data, err)
+		}
+		out = append(out, evt)
+	}
+	return out
+}
+
+func phases(events []api.CreateEvent) string {
+	var names []string
+	for _, evt := range events {
+		names = append(names, evt.Phase)
+	}
+	return strings.Join(names, ",")
+}
+
+func createEventsServer(t *testing.T) (*server, *watch.FakeWatcher) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}}, pendingPod("sbx-a"))
+	fw := watch.NewFake()
+	s.client.(*fake.Clientset).PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(fw, nil))
+	t.Setenv("SANDBOX_READY_POLL_INTERVAL", "1h")
+	return s, fw
+}
+
+func TestCreateEventsFollowPod(t *testing.T) {
+	s, fw := createEventsServer(t)
+	done := make(chan string, 1)
+	go func() {
+		w := serve(s.createEvents, http.MethodGet, "/sandboxes/:id/create-events", "/sandboxes/sbx-a/create-events", nil)
+		done <- w.Body.String()
+	}()
+
+	pod := pendingPod("sbx-a")
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionTrue}}
+	fw.Modify(pod.DeepCopy())
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "sandbox",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+	}}
+	fw.Modify(pod.DeepCopy())
+	// A repeat of the same state doesn't repeat the event.
+	fw.Modify(pod.DeepCopy())
+	ready := readyPod("sbx-a")
+	ready.Status.Conditions = append(ready.Status.Conditions,
+		corev1.PodCondition{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+		corev1.PodCondition{Type: corev1.ContainersReady, Status: corev1.ConditionTrue})
+	fw.Modify(ready)
+
+	select {
+	case body := <-done:
+		got := phases(parseCreateEvents(t, body))
+		if want := "NamespaceCreated,PodScheduled,Pulling,ContainersReady,Ready"; got != want {
+			t.Fatalf("phases = %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not close after the pod became ready")
+	}
+}
+
+func TestCreateEventsBeforePodExists(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}})
+	t.Setenv("SANDBOX_READY_POLL_INTERVAL", "10ms")
+	done := make(chan string, 1)
+	go func() {
+		w := serve(s.createEvents, http.MethodGet, "/sandboxes/:id/create-events", "/sandboxes/sbx-a/create-events", nil)
+		done <- w.Body.String()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	ready := readyPod("sbx-a")
+	ready.Status.Conditions = append(ready.Status.Conditions,
+		corev1.PodCondition{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+		corev1.PodCondition{Type: corev1.ContainersReady, Status: corev1.ConditionTrue})
+	if _, err := s.client.CoreV1().Pods("sbx-a").Create(context.Background(), ready, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case body := <-done:
+		got := phases(parseCreateEvents(t, body))
+		if want := "NamespaceCreated,PodScheduled,ContainersReady,Ready"; got != want {
+			t.Fatalf("phases = %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not follow the pod once it was created")
+	}
+}
+
+func TestCreateEventsImagePullFailure(t *testing.T) {
+	s, fw := createEventsServer(t)
+	done := make(chan string, 1)
+	go func() {
+		w := serve(s.createEvents, http.MethodGet, "/sandboxes/:id/create-events", "/sandboxes/sbx-a/create-events", nil)
+		done <- w.Body.String()
+	}()
+
+	pod := pendingPod("sbx-a")
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "sandbox",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "not found"}},
+	}}
+	fw.Modify(pod)
+
+	select {
+	case body := <-done:
+		events := parseCreateEvents(t, body)
+		last := events[len(events)-1]
+		if last.Phase != "Failed" || !strings.Contains(last.Message, "ImagePullBackOff") {
+			t.Fatalf("last event = %+v, want Failed with the pull error", last)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not close after the image pull failed")
+	}
+
+	w := serve(s.createEvents, http.MethodGet, "/sandboxes/:id/create-events", "/sandboxes/sbx-missing/create-events", nil)
+	if w.Code != 404 {
+		t.Errorf("missing sandbox: status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "context"
+
+// createLimiter bounds how many creates talk to the API server at once. Excess
+// creates wait in line; metricCreateQueueDepth tracks how many are waiting.
+type createLimiter struct {
+	slots chan struct{}
+}
+
+// newCreateLimiter returns a limiter allowing max concurrent creates; max <= 0 means
+// unlimited.
+func newCreateLimiter(max int) *createLimiter {
+	if max <= 0 {
+		return &createLimiter{}
+	}
+	return &createLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a slot and returns its release, or ctx's error if the caller
+// gives up first.
+func (l *createLimiter) acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	if release, ok := l.tryAcquire(); ok {
+		return release, nil
+	}
+	metricCreateQueueDepth.Add(1)
+	defer metricCreateQueueDepth.Add(-1)
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tryAcquire takes a slot if one is free at once.
+func (l *createLimiter) tryAcquire() (func(), bool) {
+	if l.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, true
+	default:
+		return nil, false
+	}
+}
+
+func (l *createLimiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sandbox/pkg/api"
+)
+
+func TestCreateLimiterQueuesExcessCreates(t *testing.T) {
+	l := newCreateLimiter(2)
+	ctx := context.Background()
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := l.acquire(ctx)
+		if err != nil {
+			t.Fatalf("acquire %d: %v", i+1, err)
+		}
+		releases = append(releases, release)
+	}
+
+	// A third create waits, and gives up when its caller does.
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire past the limit = %v, want it to wait until the deadline", err)
+	}
+	if depth := metricCreateQueueDepth.Value(); depth != 0 {
+		t.Errorf("queue depth = %d after the waiter left, want 0", depth)
+	}
+
+	got := make(chan error, 1)
+	go func() {
+		release, err := l.acquire(ctx)
+		if err == nil {
+			release()
+		}
+		got <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if depth := metricCreateQueueDepth.Value(); depth != 1 {
+		t.Errorf("queue depth = %d while one create waits, want 1", depth)
+	}
+	releases[0]()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("queued acquire: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued create not admitted after a slot was released")
+	}
+	releases[1]()
+}
+
+func TestCreateLimiterUnlimited(t *testing.T) {
+	l := newCreateLimiter(0)
+	for i := 0; i < 100; i++ {
+		if _, err := l.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreatesUnderHighConcurrency(t *testing.T) {
+	s := newTestServer()
+	s.createSlots = newCreateLimiter(2)
+	// Hold both slots so every create has to wait for one.
+	var held []func()
+	for i := 0; i < 2; i++ {
+		release, err := s.createSlots.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, release)
+	}
+
+	const n = 50
+	waited := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			waited <- serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes", api.CreateSandboxRequest{}).Code
+		}()
+	}
+	// ?queue=true creates answer at once while the others wait.
+	var queued []api.CreateSandboxResponse
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		w := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes?queue=true", api.CreateSandboxRequest{})
+		var resp api.CreateSandboxResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 202 || resp.Status != "queued" {
+			t.Fatalf("queued create: status %d %s", w.Code, w.Body)
+		}
+		queued = append(queued, resp)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("queued creates took %s to answer", elapsed)
+	}
+	w := serve(s.getSandbox, "GET", "/sandboxes/:id", "/sandboxes/"+queued[0].ID, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"phase":"queued"`) {
+		t.Fatalf("status of a queued create = %d %s, want phase queued", w.Code, w.Body)
+	}
+	waitFor(t, func() bool { return metricCreateQueueDepth.Value() == 2*n })
+	nss, err := s.client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nss.Items) != 0 {
+		t.Fatalf("%d namespaces created without a create slot", len(nss.Items))
+	}
+
+	for _, release := range held {
+		release()
+	}
+	for i := 0; i < n; i++ {
+		if code := <-waited; code != 200 {
+			t.Fatalf("waiting create: status %d", code)
+		}
+	}
+	for _, resp := range queued {
+		waitFor(t, func() bool {
+			_, err := s.client.CoreV1().Pods(resp.Namespace).Get(context.Background(), resp.PodName, metav1.GetOptions{})
+			return err == nil
+		})
+	}
+	waitFor(t, func() bool { return metricCreateQueueDepth.Value() == 0 })
+	if _, ok := s.queuedCreates.get(queued[0].ID); ok {
+		t.Error("a finished queued create is still reported as queued")
+	}
+}
+
+func TestQueuedCreateRejectsWait(t *testing.T) {
+	s := newTestServer()
+	w := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes?queue=true&wait=true", api.CreateSandboxRequest{})
+	if w.Code != 400 {
+		t.Fatalf("status %d, want 400", w.Code)
+	}
+}
+
+// waitFor polls cond for up to five seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+
+	"sandbox/pkg/api"
+)
+
+// planCreate answers POST /sandboxes?dry_run=true: it resolves a validated create
+// request the way handleSandboxes would and returns the result without creating
+// anything. A warm namespace is only looked up, never claimed.
+func (s *server) planCreate(c *gin.Context, req api.CreateSandboxRequest, requestedID string) {
+	r, err := s.resolveCreate(c.Request.Context(), req)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	image, volumeMode, cacheCfg, envVars := r.image, r.volumeMode, r.cacheCfg, r.envVars
+	// In single-namespace mode the pod goes in the shared namespace.
+	podNS, _ := sandboxPod(sandboxNamespace(req.ID), "sandbox")
+
+	plan := api.CreatePlan{
+		ID:              req.ID,
+		Namespace:       podNS,
+		Image:           image,
+		ImageSource:     r.imageSource,
+		VolumeMode:      volumeMode,
+		CacheMode:       cacheCfg.mode,
+		EnvKeys:         make([]string, 0, len(envVars)),
+		AllowedHosts:    r.allowedHosts,
+		DisallowedHosts: r.disallowedHosts,
+	}
+	for k := range envVars {
+		plan.EnvKeys = append(plan.EnvKeys, k)
+	}
+	sort.Strings(plan.EnvKeys)
+	switch {
+	case !warmEligible(req, requestedID):
+		plan.WarmReason = "request sets options a warm pod can't take"
+	case s.warm == nil || !s.warm.enabled():
+		plan.WarmReason = "warm pool is disabled"
+	default:
+		warmNS, ok, err := s.warm.readyWarmNamespace(c.Request.Context())
+		if err != nil {
+			writeError(c, 500, err.Error())
+			return
+		}
+		if ok {
+			plan.ID, plan.Namespace, plan.WarmClaim = warmNS, warmNS, true
+		} else {
+			plan.WarmReason = "no ready warm namespace"
+		}
+	}
+
+	var pvcName string
+	if volumeMode == "pvc" {
+		pvcName = "workspace"
+	}
+	podCfg := podConfigFromRequest(req)
+	spec, err := applyPodSpecOverlay(sandboxPodSpec(image, req.Command, volumeMode, pvcName, cacheCfg, mapToEnvVars(envVars), podCfg), podCfg.overlay)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	for i := range spec.Containers {
+		ctr := &spec.Containers[i]
+		for j := range ctr.Env {
+			if isSecretKey(ctr.Env[j].Name) && ctr.Env[j].Value != "" {
+				ctr.Env[j].Value = redacted
+			}
+		}
+		if ctr.Name == "sandbox" {
+			plan.Requests = resourceStrings(ctr.Resources.Requests)
+			plan.Limits = resourceStrings(ctr.Resources.Limits)
+		}
+	}
+	plan.PodSpec, err = json.Marshal(spec)
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	writeJSON(c, 200, plan)
+}
+
+func resourceStrings(list corev1.ResourceList) map[string]string {
+	if len(list) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(list))
+	for name, q := range list {
+		out[string(name)] = q.String()
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sandbox/pkg/api"
+)
+
+func TestCreatePlanImagePrecedence(t *testing.T) {
+	cases := []struct {
+		name       string
+		config     string
+		env        string
+		req        string
+		wantImage  string
+		wantSource string
+	}{
+		{name: "default", wantImage: defaultImage, wantSource: "default"},
+		{name: "env", env: "from-env", wantImage: "from-env", wantSource: "env"},
+		{name: "config beats env", config: "image: from-config\n", env: "from-env", wantImage: "from-config", wantSource: "config"},
+		{name: "request beats config", config: "image: from-config\n", env: "from-env", req: "from-request", wantImage: "from-request", wantSource: "request"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfigFile(t, tc.config)
+			t.Setenv("SANDBOX_IMAGE", tc.env)
+			s := newTestServer()
+			rec := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes?dry_run=true", api.CreateSandboxRequest{ID: "plan", Image: tc.req})
+			if rec.Code != 200 {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			var plan api.CreatePlan
+			if err := json.Unmarshal(rec.Body.Bytes(), &plan); err != nil {
+				t.Fatal(err)
+			}
+			if plan.Image != tc.wantImage || plan.ImageSource != tc.wantSource {
+				t.Fatalf("image = %q from %q, want %q from %q", plan.Image, plan.ImageSource, tc.wantImage, tc.wantSource)
+			}
+		})
+	}
+}
+
+func TestCreatePlanCreatesNothing(t *testing.T) {
+	useConfigFile(t, "")
+	t.Setenv("SANDBOX_CPU_REQUEST", "250m")
+	s := newTestServer()
+	req := api.CreateSandboxRequest{
+		ID:           "plan",
+		Env:          map[string]string{"API_TOKEN": "hunter2", "FOO": "bar"},
+		AllowedHosts: []string{"example.com"},
+	}
+	rec := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes?dry_run=true", req)
+	if rec.Code != 200 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var plan api.CreatePlan
+	if err := json.Unmarshal(rec.Body.Bytes(), &plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Namespace != sandboxNamespace("plan") || plan.WarmClaim || plan.WarmReason == "" {
+		t.Fatalf("plan = %+v", plan)
+	}
+	if plan.Requests["cpu"] != "250m" {
+		t.Fatalf("requests = %v", plan.Requests)
+	}
+	keys := map[string]bool{}
+	for _, k := range plan.EnvKeys {
+		keys[k] = true
+	}
+	if !keys["FOO"] || !keys["API_TOKEN"] || !keys["SBX_ALLOWED_HOSTS"] {
+		t.Fatalf("env keys = %v", plan.EnvKeys)
+	}
+	if strings.Contains(string(plan.PodSpec), "hunter2") {
+		t.Fatal("pod spec leaks a secret env value")
+	}
+	nss, err := s.client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nss.Items) != 0 {
+		t.Fatalf("dry run created %d namespaces", len(nss.Items))
+	}
+}
+
+func TestCreatePlanSingleNamespace(t *testing.T) {
+	useConfigFile(t, "")
+	t.Setenv("SANDBOX_SINGLE_NAMESPACE", "sandboxes")
+	s := newTestServer()
+	rec := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes?dry_run=true", api.CreateSandboxRequest{ID: "plan"})
+	if rec.Code != 200 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var plan api.CreatePlan
+	if err := json.Unmarshal(rec.Body.Bytes(), &plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Namespace != "sandboxes" {
+		t.Fatalf("namespace = %q, want the shared namespace", plan.Namespace)
+	}
+	// The plan refuses what the create would.
+	rec = serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes?dry_run=true", api.CreateSandboxRequest{ID: "plan", VolumeMode: "pvc"})
+	if rec.Code != 400 {
+		t.Fatalf("volume_mode pvc: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"sandbox/pkg/api"
+)
+
+// queuedCreateFailureRetention is how long GET /sandboxes/:id keeps reporting a
+// queued create that failed.
+const queuedCreateFailureRetention = 10 * time.Minute
+
+// queuedCreateKey marks the background replay of a ?queue=true create.
+type queuedCreateKey struct{}
+
+// queuedCreates tracks ?queue=true creates that are waiting for a create slot or
+// failed after getting one, by sandbox namespace, so GET /sandboxes/:id can report
+// them before anything exists.
+type queuedCreates struct {
+	mu sync.Mutex
+	// errs holds "" while a create waits and its error once it failed.
+	errs map[string]string
+}
+
+func (q *queuedCreates) add(ns string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.errs == nil {
+		q.errs = map[string]string{}
+	}
+	q.errs[ns] = ""
+}
+
+func (q *queuedCreates) finish(ns, errMsg string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if errMsg == "" {
+		delete(q.errs, ns)
+		return
+	}
+	q.errs[ns] = errMsg
+	time.AfterFunc(queuedCreateFailureRetention, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.errs[ns] == errMsg {
+			delete(q.errs, ns)
+		}
+	})
+}
+
+// get returns the error of ns's queued create, and whether there is one.
+func (q *queuedCreates) get(ns string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	errMsg, ok := q.errs[ns]
+	return errMsg, ok
+}
+
+// queueCreate answers a ?queue=true create that found every create slot busy:
+// it returns the sandbox's id at once and replays the request in the background,
+// where it waits its turn for a slot like any other create.
+func (s *server) queueCreate(c *gin.Context, req api.CreateSandboxRequest) (api.CreateSandboxResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return api.CreateSandboxResponse{}, err
+	}
+	ns := sandboxNamespace(req.ID)
+	podNS, podName := sandboxPod(ns, "sandbox")
+	// The replay outlives this request, so it only keeps its identity.
+	ctx := context.WithValue(context.Background(), queuedCreateKey{}, true)
+	replay, err := http.NewRequestWithContext(ctx, http.MethodPost, "/sandboxes", bytes.NewReader(body))
+	if err != nil {
+		return api.CreateSandboxResponse{}, err
+	}
+	replay.Header = c.Request.Header.Clone()
+	// The key was recorded against the queued response.
+	replay.Header.Del(idempotencyKeyHeader)
+	replay.Header.Set("Content-Type", "application/json")
+	replay.RemoteAddr = c.Request.RemoteAddr
+	replay.TLS = c.Request.TLS
+	s.batchOnce.Do(func() { s.batchRoutes = s.newBatchRoutes() })
+	s.queuedCreates.add(ns)
+	go func() {
+		rec := httptest.NewRecorder()
+		s.batchRoutes.ServeHTTP(rec, replay)
+		errMsg := ""
+		if rec.Code >= 300 {
+			var failed struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(rec.Body.Bytes(), &failed) != nil || failed.Error == "" {
+				failed.Error = http.StatusText(rec.Code)
+			}
+			errMsg = failed.Error
+		}
+		s.queuedCreates.finish(ns, errMsg)
+	}()
+	return api.CreateSandboxResponse{ID: ns, Namespace: podNS, PodName: podName, Status: "queued"}, nil
+}
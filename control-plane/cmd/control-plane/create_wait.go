@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseCreateWait reads ?wait=true and ?wait_timeout= from a create request. The
+// timeout defaults to SANDBOX_CREATE_READY_TIMEOUT.
+func parseCreateWait(c *gin.Context) (bool, time.Duration, error) {
+	wait := c.Query("wait") == "true"
+	timeout := getenvDuration("SANDBOX_CREATE_READY_TIMEOUT", 60*time.Second)
+	if v := c.Query("wait_timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return false, 0, fmt.Errorf("wait_timeout: %v", err)
+		}
+		if d <= 0 {
+			return false, 0, fmt.Errorf("wait_timeout must be > 0")
+		}
+		timeout = d
+	}
+	return wait, timeout, nil
+}
+
+// respondCreated writes the create response. With wait set it first releases the
+// create slot and blocks until the pod is ready, answering 504 if timeout elapses;
+// the sandbox is left provisioning either way.
+func (s *server) respondCreated(c *gin.Context, resp api.CreateSandboxResponse, wait bool, timeout time.Duration, release func()) {
+	c.Set(auditSandboxKey, resp.ID)
+	if resp.Status == "queued" {
+		writeJSON(c, 202, resp)
+		return
+	}
+	if !wait {
+		writeJSON(c, 200, resp)
+		return
+	}
+	release()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	if err := s.waitForPodReady(ctx, resp.Namespace, resp.PodName); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			writeErrorCode(c, 504, errCodeSandboxNotReady, fmt.Sprintf("sandbox %s not ready after %s; it is still provisioning", resp.ID, timeout))
+			return
+		}
+		writeErrorCode(c, 409, errCodeSandboxNotReady, "sandbox not ready: "+err.Error())
+		return
+	}
+	resp.Ready = true
+	writeJSON(c, 200, resp)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseCreateWait(t *testing.T) {
+	t.Setenv("SANDBOX_CREATE_READY_TIMEOUT", "30s")
+	cases := []struct {
+		query   string
+		wait    bool
+		timeout time.Duration
+		wantErr bool
+	}{
+		{query: "", wait: false, timeout: 30 * time.Second},
+		{query: "wait=true", wait: true, timeout: 30 * time.Second},
+		{query: "wait=true&wait_timeout=5s", wait: true, timeout: 5 * time.Second},
+		{query: "wait_timeout=soon", wantErr: true},
+		{query: "wait_timeout=0s", wantErr: true},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/sandboxes?"+tc.query, nil)
+		wait, timeout, err := parseCreateWait(c)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%q: err = %v, wantErr %v", tc.query, err, tc.wantErr)
+		}
+		if err == nil && (wait != tc.wait || timeout != tc.timeout) {
+			t.Fatalf("%q: got (%v, %s), want (%v, %s)", tc.query, wait, timeout, tc.wait, tc.timeout)
+		}
+	}
+}
+
+func TestRespondCreatedWaitsForReady(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestServer(readyPod("sbx-a"))
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/sandboxes?wait=true", nil)
+	released := false
+	s.respondCreated(c, api.CreateSandboxResponse{ID: "sbx-a", Namespace: "sbx-a", PodName: "sandbox"}, true, 5*time.Second, func() { released = true })
+	if w.Code != 200 {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if !released {
+		t.Fatal("create slot was not released before waiting")
+	}
+	var resp api.CreateSandboxResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Ready {
+		t.Fatal("ready = false, want true")
+	}
+}
+
+func TestRespondCreatedTimesOut(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sandbox", Namespace: "sbx-a"},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	s := newTestServer(pending)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/sandboxes?wait=true", nil)
+	s.respondCreated(c, api.CreateSandboxResponse{ID: "sbx-a", Namespace: "sbx-a", PodName: "sandbox"}, true, time.Second, func() {})
+	if w.Code != 504 {
+		t.Fatalf("status = %d, want 504: %s", w.Code, w.Body.String())
+	}
+}
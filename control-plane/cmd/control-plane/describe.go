@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// describeSandbox returns the sandbox pod object, spec and status, for debugging
+// without cluster access. Env values of secret-looking keys are redacted unless
+// ?redact=false. It sits behind the admin token because the spec shows env, mounts
+// and service accounts.
+func (s *server) describeSandbox(c *gin.Context) {
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	podNS, podName := sandboxPod(id, "sandbox")
+	pod, err := s.client.CoreV1().Pods(podNS).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	// Server-side apply bookkeeping, not useful for debugging the sandbox.
+	pod.ManagedFields = nil
+	if c.Query("redact") != "false" {
+		redactPodEnv(pod)
+	}
+	writeJSON(c, 200, pod)
+}
+
+func redactPodEnv(pod *corev1.Pod) {
+	for _, ctrs := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range ctrs {
+			for j, ev := range ctrs[i].Env {
+				if isSecretKey(ev.Name) && ev.Value != "" {
+					ctrs[i].Env[j].Value = redacted
+				}
+			}
+		}
+	}
+}
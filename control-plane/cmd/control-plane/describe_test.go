@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDescribeSandbox(t *testing.T) {
+	t.Setenv("SANDBOX_ADMIN_TOKEN", "hunter2")
+	s := newTestServer()
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes",
+		api.CreateSandboxRequest{ID: "dbg", Env: map[string]string{"API_TOKEN": "s3cret", "LOG_LEVEL": "debug"}})
+	if w.Code != 200 {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	created, err := s.client.CoreV1().Pods("sbx-dbg").Get(context.Background(), "sandbox", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/sandboxes/:id/describe", requireAdmin(), s.describeSandbox)
+	describe := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	envOf := func(pod *corev1.Pod) map[string]string {
+		out := map[string]string{}
+		for _, ctr := range pod.Spec.Containers {
+			if ctr.Name == "sandbox" {
+				for _, ev := range ctr.Env {
+					out[ev.Name] = ev.Value
+				}
+			}
+		}
+		return out
+	}
+
+	if w := describe("/sandboxes/sbx-dbg/describe", ""); w.Code != 401 {
+		t.Fatalf("without a token: status %d, want 401", w.Code)
+	}
+
+	w = describe("/sandboxes/sbx-dbg/describe", "hunter2")
+	if w.Code != 200 {
+		t.Fatalf("describe: status %d: %s", w.Code, w.Body)
+	}
+	var got corev1.Pod
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if env := envOf(&got); env["API_TOKEN"] != redacted || env["LOG_LEVEL"] != "debug" {
+		t.Errorf("env = %v, want API_TOKEN redacted and LOG_LEVEL shown", env)
+	}
+	want := created.DeepCopy()
+	redactPodEnv(want)
+	want.ManagedFields = nil
+	if !sameJSON(t, &got, want) {
+		t.Errorf("described pod differs from the created one:\ngot  %s\nwant %s", mustJSON(t, &got), mustJSON(t, want))
+	}
+
+	w = describe("/sandboxes/sbx-dbg/describe?redact=false", "hunter2")
+	var raw corev1.Pod
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if !sameJSON(t, raw.Spec, created.Spec) {
+		t.Error("redact=false did not return the pod spec unchanged")
+	}
+
+	if w := describe("/sandboxes/sbx-missing/describe", "hunter2"); w.Code != 404 {
+		t.Errorf("missing sandbox: status %d, want 404", w.Code)
+	}
+}
+
+// sameJSON compares objects by their JSON encoding, which is what the API returns;
+// a decoded pod has empty slices where the original had nil.
+func sameJSON(t *testing.T, a, b any) bool {
+	return mustJSON(t, a) == mustJSON(t, b)
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+var allowedDNSPolicies = []string{"Default", "ClusterFirst", "None"}
+
+// maxDNSServers is the nameserver limit Kubernetes puts on a pod's dnsConfig.
+const maxDNSServers = 3
+
+// dnsFromEnv returns SANDBOX_DNS_POLICY and SANDBOX_DNS_SERVERS. An empty policy
+// leaves the pod at the Kubernetes default, ClusterFirst.
+func dnsFromEnv() (policy string, servers []string) {
+	return getenv("SANDBOX_DNS_POLICY", ""), splitCSV(getenv("SANDBOX_DNS_SERVERS", ""))
+}
+
+// dnsFromRequest returns the DNS policy and servers for req. Request servers
+// replace the configured ones rather than adding to them.
+func dnsFromRequest(policy string, servers []string) (string, []string) {
+	envPolicy, envServers := dnsFromEnv()
+	if policy == "" {
+		policy = envPolicy
+	}
+	if len(servers) == 0 {
+		servers = envServers
+	}
+	return policy, servers
+}
+
+// validateDNSOverride keeps a request from loosening the operator's DNS settings,
+// e.g. to get around a filtering resolver: with SANDBOX_DNS_POLICY set, a request
+// may only repeat it, and with SANDBOX_DNS_SERVERS set, request servers must be
+// some of those. Without operator settings any override is allowed.
+func validateDNSOverride(policy string, servers []string) error {
+	envPolicy, envServers := dnsFromEnv()
+	if policy != "" && envPolicy != "" && policy != envPolicy {
+		return fmt.Errorf("dns_policy %s would override SANDBOX_DNS_POLICY %s", policy, envPolicy)
+	}
+	if len(envServers) > 0 {
+		for _, s := range servers {
+			if !containsString(envServers, s) {
+				return fmt.Errorf("dns_servers entry %s is not one of SANDBOX_DNS_SERVERS", s)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDNS checks a DNS policy and nameserver list. None drops cluster DNS and
+// the node's resolv.conf, so the pod can't resolve anything without servers.
+func validateDNS(policy string, servers []string) error {
+	if policy != "" && !containsString(allowedDNSPolicies, policy) {
+		return fmt.Errorf("dns_policy must be one of: %s", strings.Join(allowedDNSPolicies, ", "))
+	}
+	if len(servers) > maxDNSServers {
+		return fmt.Errorf("dns_servers allows at most %d servers", maxDNSServers)
+	}
+	for _, s := range servers {
+		if net.ParseIP(s) == nil {
+			return fmt.Errorf("dns_servers entry %q is not an IP address", s)
+		}
+	}
+	if policy == "None" && len(servers) == 0 {
+		return fmt.Errorf("dns_policy None requires dns_servers (or SANDBOX_DNS_SERVERS)")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"sandbox/pkg/api"
+)
+
+// drain handles POST /admin/drain and /admin/undrain. While draining, creates
+// answer 503 and /readyz reports not ready, so a load balancer stops sending
+// traffic here ahead of an upgrade; exec, status and delete keep working for
+// sandboxes that already exist. The flag lives in this process only.
+func (s *server) drain(on bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.draining.Swap(on) != on {
+			log.Printf("draining=%t", on)
+		}
+		metricDraining.Set(boolInt(on))
+		writeJSON(c, 200, api.DrainResponse{Draining: on})
+	}
+}
+
+// handleReady is /readyz: 503 while draining, otherwise 200.
+func (s *server) handleReady(c *gin.Context) {
+	if s.draining.Load() {
+		c.String(503, "draining")
+		return
+	}
+	c.String(200, "ok")
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDrainRejectsCreates(t *testing.T) {
+	t.Setenv("SANDBOX_ADMIN_TOKEN", "hunter2")
+	s := newTestServer(readyPod("sbx-a"))
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/readyz", s.handleReady)
+	r.POST("/admin/drain", requireAdmin(), s.drain(true))
+	r.POST("/admin/undrain", requireAdmin(), s.drain(false))
+	r.POST("/sandboxes", s.handleSandboxes)
+	r.GET("/sandboxes/:id", s.getSandbox)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer hunter2")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPost, "/admin/drain", ""); w.Code != 200 || !strings.Contains(w.Body.String(), `"draining":true`) {
+		t.Fatalf("drain = %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/sandboxes", `{"id": "new"}`); w.Code != 503 || !strings.Contains(w.Body.String(), errCodeDraining) {
+		t.Fatalf("create while draining = %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/readyz", ""); w.Code != 503 {
+		t.Errorf("readyz while draining = %d", w.Code)
+	}
+	// Existing sandboxes keep working.
+	if w := do(http.MethodGet, "/sandboxes/sbx-a", ""); w.Code != 200 {
+		t.Errorf("status while draining = %d %s", w.Code, w.Body)
+	}
+	// Plans don't create anything, so they're still allowed.
+	if w := do(http.MethodPost, "/sandboxes?dry_run=true", `{"id": "new"}`); w.Code != 200 {
+		t.Errorf("dry run while draining = %d %s", w.Code, w.Body)
+	}
+
+	if w := do(http.MethodPost, "/admin/undrain", ""); w.Code != 200 || !strings.Contains(w.Body.String(), `"draining":false`) {
+		t.Fatalf("undrain = %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/readyz", ""); w.Code != 200 {
+		t.Errorf("readyz after undrain = %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/sandboxes", `{"id": "new"}`); w.Code != 200 {
+		t.Errorf("create after undrain = %d %s", w.Code, w.Body)
+	}
+}
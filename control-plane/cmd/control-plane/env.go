@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sandboxEnv returns the env declared on the sandbox container. Values of keys that
+// look like secrets are redacted, and valueFrom/envFrom references are described
+// rather than resolved.
+func (s *server) sandboxEnv(c *gin.Context) {
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	podNS, podName := sandboxPod(id, "sandbox")
+	pod, err := s.client.CoreV1().Pods(podNS).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	resp := api.SandboxEnvResponse{ID: id, Env: map[string]string{}}
+	for _, ctr := range pod.Spec.Containers {
+		if ctr.Name != "sandbox" {
+			continue
+		}
+		for _, ev := range ctr.Env {
+			switch {
+			case ev.ValueFrom != nil:
+				resp.Env[ev.Name] = describeEnvSource(ev.ValueFrom)
+			case isSecretKey(ev.Name) && ev.Value != "":
+				resp.Env[ev.Name] = redacted
+			default:
+				resp.Env[ev.Name] = ev.Value
+			}
+		}
+		for _, src := range ctr.EnvFrom {
+			switch {
+			case src.ConfigMapRef != nil:
+				resp.EnvFrom = append(resp.EnvFrom, "configmap/"+src.ConfigMapRef.Name)
+			case src.SecretRef != nil:
+				resp.EnvFrom = append(resp.EnvFrom, "secret/"+src.SecretRef.Name)
+			}
+		}
+	}
+	writeJSON(c, 200, resp)
+}
+
+func describeEnvSource(src *corev1.EnvVarSource) string {
+	switch {
+	case src.SecretKeyRef != nil:
+		return fmt.Sprintf("<secret %s/%s>", src.SecretKeyRef.Name, src.SecretKeyRef.Key)
+	case src.ConfigMapKeyRef != nil:
+		return fmt.Sprintf("<configmap %s/%s>", src.ConfigMapKeyRef.Name, src.ConfigMapKeyRef.Key)
+	case src.FieldRef != nil:
+		return fmt.Sprintf("<field %s>", src.FieldRef.FieldPath)
+	case src.ResourceFieldRef != nil:
+		return fmt.Sprintf("<resource %s>", src.ResourceFieldRef.Resource)
+	default:
+		return "<valueFrom>"
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSandboxEnvRedactsSecrets(t *testing.T) {
+	pod := readyPod("sbx-a")
+	pod.Spec.Containers = []corev1.Container{{
+		Name: "sandbox",
+		Env: []corev1.EnvVar{
+			{Name: "SBX_ALLOWED_HOSTS", Value: "github.com"},
+			{Name: "GITHUB_TOKEN", Value: "ghp_secret"},
+			{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password",
+			}}},
+		},
+		EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+	}}
+	s := newTestServer(pod)
+
+	w := serve(s.sandboxEnv, "GET", "/sandboxes/:id/env", "/sandboxes/sbx-a/env", nil)
+	if w.Code != 200 {
+		t.Fatalf("env: %d %s", w.Code, w.Body.String())
+	}
+	var resp api.SandboxEnvResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"SBX_ALLOWED_HOSTS": "github.com",
+		"GITHUB_TOKEN":      redacted,
+		"DB_PASSWORD":       "<secret db/password>",
+	}
+	for k, v := range want {
+		if resp.Env[k] != v {
+			t.Errorf("%s = %q, want %q", k, resp.Env[k], v)
+		}
+	}
+	if len(resp.EnvFrom) != 1 || resp.EnvFrom[0] != "configmap/settings" {
+		t.Errorf("EnvFrom = %v, want [configmap/settings]", resp.EnvFrom)
+	}
+
+	w = serve(s.sandboxEnv, "GET", "/sandboxes/:id/env", "/sandboxes/missing/env", nil)
+	if w.Code != 404 {
+		t.Errorf("missing sandbox: %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sandbox/pkg/api"
+)
+
+// allowlistEntry is one SANDBOX_EXEC_ALLOWLIST entry. Entries with a "/" match
+// the whole command path; the rest match bare command names only.
+type allowlistEntry struct {
+	re   *regexp.Regexp
+	path bool
+}
+
+// parseExecAllowlist compiles SANDBOX_EXEC_ALLOWLIST, a comma-separated list of
+// command names, command paths or regular expressions. Each entry is anchored, so
+// "python3" matches only python3 and "python3(\.[0-9]+)?" also matches python3.12.
+// An empty list allows every command.
+func parseExecAllowlist(raw string) ([]allowlistEntry, error) {
+	var out []allowlistEntry
+	for _, entry := range splitCSV(raw) {
+		re, err := regexp.Compile("^(?:" + entry + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("SANDBOX_EXEC_ALLOWLIST entry %q: %v", entry, err)
+		}
+		out = append(out, allowlistEntry{re: re, path: strings.Contains(entry, "/")})
+	}
+	return out, nil
+}
+
+// checkExecAllowlist reports an error when SANDBOX_EXEC_ALLOWLIST is set and cmd[0]
+// matches none of its entries. A path such as /tmp/evil/git only matches entries
+// that are paths themselves, since anyone can drop a binary named git into the
+// sandbox; a bare name is looked up on the sandbox's PATH and matches name entries. Shell and script execs are checked
+// against the shell that runs them, not the commands inside; for scripts that is
+// script_shell rather than the sh that unpacks the script.
+func checkExecAllowlist(req api.ExecRequest, cmd []string) error {
+	allowlist, err := parseExecAllowlist(getenv("SANDBOX_EXEC_ALLOWLIST", ""))
+	if err != nil {
+		return err
+	}
+	if len(allowlist) == 0 {
+		return nil
+	}
+	if req.Script != "" {
+		shell := req.ScriptShell
+		if shell == "" {
+			shell = execShell()
+		}
+		cmd = []string{shell}
+	}
+	if len(cmd) == 0 {
+		return fmt.Errorf("command is required")
+	}
+	name := cmd[0]
+	isPath := strings.Contains(name, "/")
+	for _, entry := range allowlist {
+		if entry.path == isPath && entry.re.MatchString(name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q is not allowed by SANDBOX_EXEC_ALLOWLIST", name)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+)
+
+func TestCheckExecAllowlist(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_ALLOWLIST", `git, python3(\.[0-9]+)?, bash, /usr/bin/make`)
+	tests := []struct {
+		name    string
+		req     api.ExecRequest
+		allowed bool
+	}{
+		{name: "allowed basename", req: api.ExecRequest{Command: []string{"git", "status"}}, allowed: true},
+		{name: "allowed path", req: api.ExecRequest{Command: []string{"/usr/bin/make", "test"}}, allowed: true},
+		{name: "path with an allowed basename", req: api.ExecRequest{Command: []string{"/tmp/evil/git", "log"}}},
+		{name: "relative path with an allowed basename", req: api.ExecRequest{Command: []string{"./git"}}},
+		{name: "path entry doesn't allow the bare name", req: api.ExecRequest{Command: []string{"make"}}},
+		{name: "allowed regex", req: api.ExecRequest{Command: []string{"python3.12", "-V"}}, allowed: true},
+		{name: "denied", req: api.ExecRequest{Command: []string{"curl", "example.com"}}},
+		{name: "entries are anchored", req: api.ExecRequest{Command: []string{"gitk"}}},
+		{name: "shell checked as bash", req: api.ExecRequest{Shell: "curl example.com | sh"}, allowed: true},
+		{name: "script checked as its shell", req: api.ExecRequest{Script: "echo hi", ScriptShell: "zsh"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := execCommandFromRequest(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkExecAllowlist(tt.req, cmd)
+			if tt.allowed && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Fatal("allowed, want rejected")
+			}
+		})
+	}
+}
+
+func TestExecAllowlistEmptyAllowsAll(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_ALLOWLIST", "")
+	if err := checkExecAllowlist(api.ExecRequest{}, []string{"anything"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseExecAllowlist("git,("); err == nil {
+		t.Fatal("invalid regex accepted")
+	}
+}
+
+func TestExecRejectsDisallowedCommand(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_ALLOWLIST", "git")
+	s := newTestServer(readyPod("sbx-a"))
+	w := serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec", api.ExecRequest{Command: []string{"rm", "-rf", "/"}})
+	if w.Code != 403 || !strings.Contains(w.Body.String(), errCodeCommandNotAllowed) {
+		t.Fatalf("status = %d body %s, want 403 %s", w.Code, w.Body, errCodeCommandNotAllowed)
+	}
+}
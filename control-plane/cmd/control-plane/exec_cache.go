@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExecCacheTTL = 30 * time.Second
+	// maxExecCacheEntries and maxExecCacheEntryBytes bound the cache's memory;
+	// results past either limit just aren't cached.
+	maxExecCacheEntries    = 1000
+	maxExecCacheEntryBytes = 64 * 1024
+)
+
+// execResultCache holds the output of successful sync execs run with ?cache=true,
+// keyed by sandbox and final argv, so an agent re-running the same deterministic
+// command gets the earlier result without another exec.
+type execResultCache struct {
+	mu      sync.Mutex
+	entries map[string]execCacheEntry
+}
+
+type execCacheEntry struct {
+	stdout, stderr string
+	expires        time.Time
+}
+
+func newExecResultCache() *execResultCache {
+	return &execResultCache{entries: map[string]execCacheEntry{}}
+}
+
+// execCacheKey keys on the argv after wrapping, so a different timeout or wrapper
+// is a different command. NUL can't appear in an argv element.
+func execCacheKey(ns string, command []string) string {
+	return ns + "\x00" + strings.Join(command, "\x00")
+}
+
+func (c *execResultCache) get(key string) (execCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return execCacheEntry{}, false
+	}
+	return e, true
+}
+
+// put caches a successful exec's output for SANDBOX_EXEC_CACHE_TTL.
+func (c *execResultCache) put(key, stdout, stderr string) {
+	ttl := getenvDuration("SANDBOX_EXEC_CACHE_TTL", defaultExecCacheTTL)
+	if ttl <= 0 || len(stdout)+len(stderr) > maxExecCacheEntryBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxExecCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxExecCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = execCacheEntry{stdout: stdout, stderr: stderr, expires: now.Add(ttl)}
+}
+
+// forget drops every cached result for ns, e.g. when its pod goes away.
+func (c *execResultCache) forget(ns string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := ns + "\x00"
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
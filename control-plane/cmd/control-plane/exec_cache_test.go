@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+	utilsexec "k8s.io/utils/exec"
+)
+
+func TestExecCacheServesRepeatedCommand(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}}, readyPod("sbx-a"))
+	runs := 0
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+		runs++
+		if cmd[0] == "false" {
+			return utilsexec.CodeExitError{Err: errors.New("exit 1"), Code: 1}
+		}
+		fmt.Fprintf(opts.Stdout, "Python 3.12.%d", runs)
+		return nil
+	}
+	async := false
+	run := func(path string, command ...string) (int, api.ExecResponse) {
+		w := serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", path, api.ExecRequest{Command: command, Async: &async})
+		var resp api.ExecResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	_, first := run("/sandboxes/sbx-a/exec?cache=true", "python", "--version")
+	code, second := run("/sandboxes/sbx-a/exec?cache=true", "python", "--version")
+	if code != 200 || !second.CacheHit || second.Stdout != first.Stdout || first.CacheHit {
+		t.Fatalf("second exec = %d %+v, want a cache hit with %q", code, second, first.Stdout)
+	}
+	if runs != 1 {
+		t.Fatalf("command ran %d times, want once", runs)
+	}
+
+	if _, resp := run("/sandboxes/sbx-a/exec", "python", "--version"); resp.CacheHit || runs != 2 {
+		t.Errorf("exec without cache=true: cache_hit=%t runs=%d, want a fresh run", resp.CacheHit, runs)
+	}
+	for i := 0; i < 2; i++ {
+		run("/sandboxes/sbx-a/exec?cache=true", "false")
+	}
+	if runs != 4 {
+		t.Errorf("failing command ran %d times in total, want every run executed", runs-2)
+	}
+
+	// An async exec has no result to cache.
+	w := serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec?cache=true", api.ExecRequest{Command: []string{"true"}})
+	if w.Code != 400 {
+		t.Errorf("async cache=true: status %d, want 400", w.Code)
+	}
+
+	s.execCache.forget("sbx-a")
+	if _, resp := run("/sandboxes/sbx-a/exec?cache=true", "python", "--version"); resp.CacheHit {
+		t.Error("cache hit after the sandbox's entries were dropped")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"sandbox/pkg/api"
+)
+
+// maxExecDetachAfter bounds ?detach_after; past it the client should just use an
+// async exec.
+const maxExecDetachAfter = 5 * time.Minute
+
+// parseExecDetachAfter reads ?detach_after= from an exec request. It returns 0 when
+// the request doesn't set it.
+func parseExecDetachAfter(c *gin.Context) (time.Duration, error) {
+	v := c.Query("detach_after")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("detach_after: %v", err)
+	}
+	if d <= 0 || d > maxExecDetachAfter {
+		return 0, fmt.Errorf("detach_after must be > 0 and at most %s", maxExecDetachAfter)
+	}
+	return d, nil
+}
+
+// detachableBuffer collects a sync exec's output until the exec is detached, after
+// which the output only goes to the stream and the status tail.
+type detachableBuffer struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	detached bool
+}
+
+func (b *detachableBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.detached {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (b *detachableBuffer) detach() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.detached = true
+	b.buf = bytes.Buffer{}
+}
+
+func (b *detachableBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// execDetachable runs a sync exec that is registered like an async one. If it
+// finishes within after, the response is the usual sync result; otherwise the
+// request returns the exec id with detached set, and the exec keeps running for
+// the client to follow through the stream or exec status. The exec isn't tied to
+// the request, so a client that disconnects doesn't stop it either.
+func (s *server) execDetachable(c *gin.Context, ns, podName string, command []string, timeoutSeconds *int, queuedAt time.Time, after time.Duration, release func()) {
+	execID := generateExecID()
+	c.Set(auditExecKey, execID)
+	execCtx, execCancel := execContext(timeoutSeconds)
+	s.execs.createRunning(ns, execID, queuedAt, timeoutSeconds, execCancel)
+	metricExecs.Add(1)
+
+	var stdout, stderr detachableBuffer
+	done := make(chan error, 1)
+	go func() {
+		defer release()
+		defer execCancel()
+		s.execs.markStarted(ns, execID)
+		err := s.streamPodExec(execCtx, ns, podName, "sandbox", command, remotecommand.StreamOptions{
+			Stdout: io.MultiWriter(&stdout, &streamEventWriter{server: s, sandboxID: ns, execID: execID, stream: "stdout"}),
+			Stderr: io.MultiWriter(&stderr, &streamEventWriter{server: s, sandboxID: ns, execID: execID, stream: "stderr"}),
+		})
+		s.execs.finish(ns, execID, err)
+		s.publishExecExit(ns, execID, err)
+		_ = s.updateLastExec(context.Background(), ns)
+		done <- err
+	}()
+
+	timer := time.NewTimer(after)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			writeError(c, 500, err.Error())
+			return
+		}
+		writeJSON(c, 200, api.ExecResponse{Stdout: stdout.String(), Stderr: stderr.String(), Status: "completed"})
+	case <-timer.C:
+		stdout.detach()
+		stderr.detach()
+		writeJSON(c, 200, api.ExecResponse{ExecID: execID, Status: "running", Detached: true})
+	case <-c.Request.Context().Done():
+		// Nobody is left to answer; the exec carries on detached.
+		stdout.detach()
+		stderr.detach()
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"sandbox/pkg/api"
+)
+
+func TestExecDetachesSlowSyncExec(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}}, readyPod("sbx-a"))
+	release := make(chan struct{})
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+		fmt.Fprintf(opts.Stdout, "%s started\n", cmd[0])
+		if cmd[0] == "slow" {
+			<-release
+		}
+		fmt.Fprintf(opts.Stdout, "%s done\n", cmd[0])
+		return nil
+	}
+	async := false
+	run := func(path string, command ...string) (int, api.ExecResponse) {
+		w := serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", path, api.ExecRequest{Command: command, Async: &async})
+		var resp api.ExecResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	// Under the threshold it is an ordinary sync exec.
+	code, resp := run("/sandboxes/sbx-a/exec?detach_after=10s", "fast")
+	if code != 200 || resp.Detached || resp.Status != "completed" || resp.Stdout != "fast started\nfast done\n" {
+		t.Fatalf("fast exec = %d %+v, want its output inline", code, resp)
+	}
+
+	start := time.Now()
+	code, resp = run("/sandboxes/sbx-a/exec?detach_after=50ms", "slow")
+	if code != 200 || !resp.Detached || resp.Status != "running" || resp.ExecID == "" || resp.Stdout != "" {
+		t.Fatalf("slow exec = %d %+v, want a detached exec id", code, resp)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("slow exec returned after %s, want shortly after the threshold", elapsed)
+	}
+	if status, ok := s.execs.get("sbx-a", resp.ExecID); !ok || status.Status != execStatusRunning {
+		t.Fatalf("detached exec status = %+v (found %t), want running", status, ok)
+	}
+
+	close(release)
+	s.waitExecDone(context.Background(), "sbx-a", resp.ExecID, 5*time.Second)
+	status, _ := s.execs.get("sbx-a", resp.ExecID)
+	if status.Status != execStatusCompleted {
+		t.Fatalf("detached exec finished as %q, want completed", status.Status)
+	}
+	stdout, _, _ := s.execs.output("sbx-a", resp.ExecID)
+	if !strings.Contains(stdout, "slow done") {
+		t.Errorf("status output = %q, want the output produced after detaching", stdout)
+	}
+}
+
+func TestExecDetachAfterValidation(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}}, readyPod("sbx-a"))
+	async := false
+	for _, path := range []string{
+		"/sandboxes/sbx-a/exec?detach_after=soon",
+		"/sandboxes/sbx-a/exec?detach_after=0s",
+		"/sandboxes/sbx-a/exec?detach_after=1h",
+		"/sandboxes/sbx-a/exec?detach_after=5s&ordered=true",
+		"/sandboxes/sbx-a/exec?detach_after=5s&cache=true",
+		"/sandboxes/sbx-a/exec?detach_after=5s&tail_bytes=10",
+	} {
+		w := serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", path, api.ExecRequest{Command: []string{"true"}, Async: &async})
+		if w.Code != 400 {
+			t.Errorf("%s: status %d, want 400", path, w.Code)
+		}
+	}
+	w := serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec?detach_after=5s", api.ExecRequest{Command: []string{"true"}})
+	if w.Code != 400 {
+		t.Errorf("async exec with detach_after: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+)
+
+const (
+	execDialAttempts    = 3
+	execDialBaseBackoff = 250 * time.Millisecond
+)
+
+// connectTracker records whether the SPDY upgrade succeeded and, if it failed,
+// the status the API server answered with. An error before the upgrade means the
+// command never started in the container, so the exec can be retried without
+// running it twice.
+type connectTracker struct {
+	spdy.Upgrader
+	connected atomic.Bool
+	status    atomic.Int32
+}
+
+func (t *connectTracker) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	t.status.Store(int32(resp.StatusCode))
+	conn, err := t.Upgrader.NewConnection(resp)
+	if err == nil {
+		t.connected.Store(true)
+	}
+	return conn, err
+}
+
+// retryable reports whether a failed dial may succeed if tried again: the
+// connection failed outright, or the API server answered 429 or 5xx. Anything
+// else, like a 403 or a 404 for a missing pod, fails the same way every time.
+func (t *connectTracker) retryable() bool {
+	status := t.status.Load()
+	return !t.connected.Load() && (status == 0 || status == http.StatusTooManyRequests || status >= 500)
+}
+
+// streamExec runs the exec at u, retrying with backoff when the connection to the
+// kubelet could not be established, or was refused with 429 or 5xx. Right after a
+// pod turns Ready the kubelet may not be serving exec yet. Errors once the stream
+// is up, including exit codes, are returned as is.
+func (s *server) streamExec(ctx context.Context, u *url.URL, opts remotecommand.StreamOptions) error {
+	backoff := execDialBaseBackoff
+	for attempt := 1; ; attempt++ {
+		transport, upgrader, err := spdy.RoundTripperFor(s.cfg)
+		if err != nil {
+			return err
+		}
+		tracker := &connectTracker{Upgrader: upgrader}
+		exec, err := remotecommand.NewSPDYExecutorForTransports(transport, tracker, http.MethodPost, u)
+		if err != nil {
+			return err
+		}
+		err = exec.StreamWithContext(ctx, opts)
+		if err == nil || !tracker.retryable() || attempt == execDialAttempts || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("exec dial failed attempt=%d retry_in=%s err=%v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestStreamExecRetriesFailedDial(t *testing.T) {
+	var hits atomic.Int32
+	kubelet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		http.Error(w, "container not ready", http.StatusBadGateway)
+	}))
+	defer kubelet.Close()
+
+	s := &server{cfg: &rest.Config{Host: kubelet.URL}}
+	u, _ := url.Parse(kubelet.URL + "/api/v1/namespaces/sbx-a/pods/sandbox/exec")
+	if err := s.streamExec(context.Background(), u, remotecommand.StreamOptions{Stdout: io.Discard}); err == nil {
+		t.Fatal("exec against a failing kubelet returned nil")
+	}
+	if got := hits.Load(); got != execDialAttempts {
+		t.Fatalf("dial attempts = %d, want %d", got, execDialAttempts)
+	}
+}
+
+func TestStreamExecStopsOnCancel(t *testing.T) {
+	var hits atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	kubelet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		cancel()
+		http.Error(w, "container not ready", http.StatusBadGateway)
+	}))
+	defer kubelet.Close()
+
+	s := &server{cfg: &rest.Config{Host: kubelet.URL}}
+	u, _ := url.Parse(kubelet.URL + "/api/v1/namespaces/sbx-a/pods/sandbox/exec")
+	_ = s.streamExec(ctx, u, remotecommand.StreamOptions{Stdout: io.Discard})
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("dial attempts = %d, want 1 once the context is canceled", got)
+	}
+}
+
+func TestStreamExecDoesNotRetryClientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound} {
+		var hits atomic.Int32
+		kubelet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			hits.Add(1)
+			http.Error(w, http.StatusText(status), status)
+		}))
+		s := &server{cfg: &rest.Config{Host: kubelet.URL}}
+		u, _ := url.Parse(kubelet.URL + "/api/v1/namespaces/sbx-a/pods/sandbox/exec")
+		if err := s.streamExec(context.Background(), u, remotecommand.StreamOptions{Stdout: io.Discard}); err == nil {
+			t.Fatalf("%d: exec returned nil", status)
+		}
+		kubelet.Close()
+		if got := hits.Load(); got != 1 {
+			t.Fatalf("%d: dial attempts = %d, want 1", status, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultMaxQueuedExecs = 32
+
+// execLimiter bounds how many execs run in one sandbox at once
+// (SANDBOX_MAX_CONCURRENT_EXECS). A caller either takes a free slot right away
+// or, for ?queue=true, joins a bounded first-come first-served line for the next
+// one. A nil limiter or max <= 0 means unlimited.
+type execLimiter struct {
+	max      int
+	maxQueue int
+
+	mu        sync.Mutex
+	sandboxes map[string]*execSlots
+}
+
+type execSlots struct {
+	running int
+	// waiting are the queued callers in order; closing one hands it a slot.
+	waiting []chan struct{}
+}
+
+func newExecLimiter(max, maxQueue int) *execLimiter {
+	return &execLimiter{max: max, maxQueue: maxQueue, sandboxes: map[string]*execSlots{}}
+}
+
+func (l *execLimiter) unlimited() bool {
+	return l == nil || l.max <= 0
+}
+
+func (l *execLimiter) slotsLocked(ns string) *execSlots {
+	st := l.sandboxes[ns]
+	if st == nil {
+		st = &execSlots{}
+		l.sandboxes[ns] = st
+	}
+	return st
+}
+
+// tryAcquire takes a slot in ns and returns its release, or false if all slots
+// are taken. Queued callers go first, so it also fails while anyone is waiting.
+func (l *execLimiter) tryAcquire(ns string) (func(), bool) {
+	if l.unlimited() {
+		return func() {}, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.slotsLocked(ns)
+	if st.running >= l.max || len(st.waiting) > 0 {
+		return nil, false
+	}
+	st.running++
+	return l.releaser(ns), true
+}
+
+// execTicket is a place in the line for a slot.
+type execTicket struct {
+	l     *execLimiter
+	ns    string
+	ready chan struct{}
+}
+
+// enqueue joins the line for a slot in ns. It reports false when the line is
+// full. A free slot is handed over straight away.
+func (l *execLimiter) enqueue(ns string) (*execTicket, bool) {
+	t := &execTicket{l: l, ns: ns, ready: make(chan struct{})}
+	if l.unlimited() {
+		close(t.ready)
+		return t, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.slotsLocked(ns)
+	if st.running < l.max && len(st.waiting) == 0 {
+		st.running++
+		close(t.ready)
+		return t, true
+	}
+	if len(st.waiting) >= l.maxQueue {
+		return nil, false
+	}
+	st.waiting = append(st.waiting, t.ready)
+	metricExecQueueDepth.Add(1)
+	return t, true
+}
+
+// wait blocks until the ticket's slot is free and returns its release, or gives
+// up the place in line when ctx ends first.
+func (t *execTicket) wait(ctx context.Context) (func(), error) {
+	select {
+	case <-t.ready:
+		return t.release(), nil
+	case <-ctx.Done():
+		t.leave()
+		return nil, ctx.Err()
+	}
+}
+
+// leave gives up the ticket, returning its slot if it had been handed one.
+func (t *execTicket) leave() {
+	if t.l.unlimited() {
+		return
+	}
+	t.l.mu.Lock()
+	st := t.l.slotsLocked(t.ns)
+	for i, ch := range st.waiting {
+		if ch == t.ready {
+			st.waiting = append(st.waiting[:i], st.waiting[i+1:]...)
+			metricExecQueueDepth.Add(-1)
+			t.l.mu.Unlock()
+			return
+		}
+	}
+	t.l.mu.Unlock()
+	t.l.release(t.ns)
+}
+
+func (t *execTicket) release() func() {
+	if t.l.unlimited() {
+		return func() {}
+	}
+	return t.l.releaser(t.ns)
+}
+
+// releaser returns a release for one slot in ns that is safe to call twice.
+func (l *execLimiter) releaser(ns string) func() {
+	var once sync.Once
+	return func() { once.Do(func() { l.release(ns) }) }
+}
+
+// release frees a slot in ns, handing it to the first caller in line if any.
+func (l *execLimiter) release(ns string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.slotsLocked(ns)
+	if len(st.waiting) > 0 {
+		close(st.waiting[0])
+		st.waiting = st.waiting[1:]
+		metricExecQueueDepth.Add(-1)
+		return
+	}
+	if st.running > 0 {
+		st.running--
+	}
+	if st.running == 0 {
+		delete(l.sandboxes, ns)
+	}
+}
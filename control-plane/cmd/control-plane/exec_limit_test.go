@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestExecLimitQueuesWhenFull(t *testing.T) {
+	s := newTestServer(readyPod("sbx-a"))
+	s.execSlots = newExecLimiter(1, 1)
+	unblock := make(chan struct{})
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, _ remotecommand.StreamOptions) error {
+		if strings.Contains(strings.Join(cmd, " "), "block") {
+			<-unblock
+		}
+		return nil
+	}
+	exec := func(query, command string) (int, api.ExecResponse, string) {
+		w := serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec"+query, map[string]any{"command": []string{command}})
+		var resp api.ExecResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp, w.Body.String()
+	}
+
+	code, first, body := exec("", "block")
+	if code != 200 {
+		t.Fatalf("first exec = %d %s", code, body)
+	}
+	if code, _, body := exec("", "true"); code != 429 || !strings.Contains(body, errCodeExecLimit) {
+		t.Fatalf("exec over the limit = %d %s, want 429", code, body)
+	}
+	code, queued, body := exec("?queue=true", "true")
+	if code != 200 || queued.Status != execStatusQueued {
+		t.Fatalf("queued exec = %d %s", code, body)
+	}
+	// The line holds one exec.
+	if code, _, body := exec("?queue=true", "true"); code != 429 || !strings.Contains(body, "queue") {
+		t.Fatalf("exec over the queue = %d %s, want 429", code, body)
+	}
+	if st, _ := s.execs.get("sbx-a", queued.ExecID); st.Status != execStatusQueued {
+		t.Fatalf("queued exec is %s while the slot is taken", st.Status)
+	}
+
+	close(unblock)
+	if st := waitExecStatus(t, s, "sbx-a", first.ExecID); st.Status != execStatusCompleted {
+		t.Fatalf("first exec = %+v", st)
+	}
+	st := waitExecStatus(t, s, "sbx-a", queued.ExecID)
+	if st.Status != execStatusCompleted || st.StartedAt == "" {
+		t.Fatalf("queued exec = %+v, want completed once the slot freed", st)
+	}
+	// Both slots are free again.
+	if code, _, body := exec("", "true"); code != 200 {
+		t.Fatalf("exec after the queue drained = %d %s", code, body)
+	}
+}
+
+func TestDeleteDropsQueuedExecs(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}}, readyPod("sbx-a"))
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		t.Error("a queued exec ran after its sandbox was deleted")
+		return nil
+	}
+	s.execSlots = newExecLimiter(1, 4)
+	release, _ := s.execSlots.tryAcquire("sbx-a")
+	defer release()
+	ticket, ok := s.execSlots.enqueue("sbx-a")
+	if !ok {
+		t.Fatal("queue full")
+	}
+	execID := s.queueExec("sbx-a", "sandbox", []string{"true"}, nil, ticket)
+	if err := s.deleteSandboxObjects(context.Background(), "sbx-a"); err != nil {
+		t.Fatal(err)
+	}
+	if st := waitExecStatus(t, s, "sbx-a", execID); st.Status != execStatusCanceled {
+		t.Fatalf("queued exec = %+v, want canceled", st)
+	}
+	// Its place in line is given up, so the next release doesn't hand it a slot.
+	s.execSlots.mu.Lock()
+	waiting := len(s.execSlots.sandboxes["sbx-a"].waiting)
+	s.execSlots.mu.Unlock()
+	if waiting != 0 {
+		t.Fatalf("%d execs still waiting", waiting)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxExecLogBytes caps how much of each captured stream is returned; longer output
+// is cut from the front so the end of the run is kept.
+const maxExecLogBytes = 8 << 20
+
+// execLogsScript prints the sizes of the captured stdout/stderr files on the first
+// line, then the tail of stdout on stdout and the tail of stderr on stderr. It
+// exits 3 when the exec left no files behind.
+const execLogsScript = `out=%s; err=%s
+[ -f "$out" ] || exit 3
+size() { if [ -f "$1" ]; then wc -c < "$1"; else echo 0; fi; }
+echo "$(size "$out") $(size "$err")"
+tail -c %d "$out"
+[ -f "$err" ] && tail -c %d "$err" >&2
+exit 0`
+
+// getExecLogs returns the full captured output of an exec. In sidecar mode the
+// wrapper tees every exec to files in the events dir, which outlive the stream
+// ring and the exec registry; otherwise, or when the files are gone, it falls back
+// to the output tail kept in the registry.
+func (s *server) getExecLogs(c *gin.Context) {
+	id := c.Param("id")
+	execID := c.Param("exec_id")
+	if !execIDPattern.MatchString(execID) {
+		writeErrorCode(c, 404, errCodeExecNotFound, "exec not found")
+		return
+	}
+	if streamCfg := streamConfigFromEnv(); streamCfg.sidecarImage != "" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		resp, found, err := s.readExecLogFiles(ctx, id, execID, streamCfg.eventsDir)
+		if err != nil {
+			writeError(c, 500, err.Error())
+			return
+		}
+		if found {
+			writeJSON(c, 200, resp)
+			return
+		}
+	}
+	if _, ok := s.execs.get(id, execID); !ok {
+		writeErrorCode(c, 404, errCodeExecNotFound, "exec not found")
+		return
+	}
+	stdout, stderr, truncated := s.execs.output(id, execID)
+	writeJSON(c, 200, api.ExecLogsResponse{
+		SandboxID: id,
+		ExecID:    execID,
+		Source:    "registry",
+		Stdout:    stdout,
+		Stderr:    stderr,
+		Truncated: truncated,
+	})
+}
+
+func (s *server) readExecLogFiles(ctx context.Context, ns, execID, eventsDir string) (api.ExecLogsResponse, bool, error) {
+	script := fmt.Sprintf(execLogsScript,
+		shellQuote(path.Join(eventsDir, execID+".stdout")),
+		shellQuote(path.Join(eventsDir, execID+".stderr")),
+		maxExecLogBytes, maxExecLogBytes,
+	)
+	stdout, stderr, err := s.execCommand(ctx, ns, "sandbox", "sandbox", []string{"sh", "-c", script})
+	if code, ok := exitCodeFromErr(err); ok && code == 3 {
+		return api.ExecLogsResponse{}, false, nil
+	}
+	if err != nil {
+		return api.ExecLogsResponse{}, false, err
+	}
+	header, body, _ := strings.Cut(stdout, "\n")
+	var sizes [2]int64
+	for i, f := range strings.Fields(header) {
+		if i < len(sizes) {
+			sizes[i], _ = strconv.ParseInt(f, 10, 64)
+		}
+	}
+	return api.ExecLogsResponse{
+		SandboxID: ns,
+		ExecID:    execID,
+		Source:    "pod",
+		Stdout:    body,
+		Stderr:    stderr,
+		Truncated: sizes[0] > maxExecLogBytes || sizes[1] > maxExecLogBytes,
+	}, true, nil
+}
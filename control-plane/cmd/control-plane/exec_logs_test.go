@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	"k8s.io/client-go/tools/remotecommand"
+	utilsexec "k8s.io/client-go/util/exec"
+)
+
+// localPodExec runs exec commands on the test host, standing in for the pod.
+func localPodExec(_ context.Context, _, _, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+	c := exec.Command(cmd[0], cmd[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = opts.Stdin, opts.Stdout, opts.Stderr
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return utilsexec.CodeExitError{Err: err, Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+func TestGetExecLogsSidecarFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SANDBOX_STREAM_SIDECAR_IMAGE", "sidecar")
+	t.Setenv("SANDBOX_STREAM_ENDPOINT", "http://control-plane:8080")
+	t.Setenv("SANDBOX_STREAM_EVENTS_DIR", dir)
+	if err := os.WriteFile(filepath.Join(dir, "0123456789abcdef.stdout"), []byte("line 1\nline 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "0123456789abcdef.stderr"), []byte("warning\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = localPodExec
+
+	w := serve(s.getExecLogs, "GET", "/sandboxes/:id/execs/:exec_id/logs", "/sandboxes/sbx-a/execs/0123456789abcdef/logs", nil)
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var resp api.ExecLogsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Source != "pod" || resp.Stdout != "line 1\nline 2\n" || resp.Stderr != "warning\n" || resp.Truncated {
+		t.Fatalf("resp = %+v, want both captured files from the pod", resp)
+	}
+
+	// An exec with no files and no registry entry is unknown.
+	w = serve(s.getExecLogs, "GET", "/sandboxes/:id/execs/:exec_id/logs", "/sandboxes/sbx-a/execs/fedcba9876543210/logs", nil)
+	if w.Code != 404 {
+		t.Fatalf("missing exec: status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultExecQueueTimeout = 2 * time.Minute
+
+// asyncExecCommand wraps command for an async exec and returns the PID file the
+// wrapper writes. With SANDBOX_EXEC_SHELL=none the command runs as is and there is
+// no PID file, so the exec can be canceled but not signaled.
+func asyncExecCommand(execID string, command []string) ([]string, string) {
+	if execShell() == "" {
+		return command, ""
+	}
+	streamCfg := streamConfigFromEnv()
+	if streamCfg.sidecarImage != "" {
+		return wrapCommandForSidecar(execID, command, streamCfg.eventsDir), execPIDPath(streamCfg.eventsDir, execID)
+	}
+	return wrapCommandWithPID(execID, command), execPIDPath(execPIDDir, execID)
+}
+
+// queueExec registers an exec that starts once the sandbox pod is Ready and ticket
+// has been handed an exec slot. The exec fails if it can't start within
+// SANDBOX_EXEC_QUEUE_TIMEOUT; its own timeout only starts counting once it runs.
+func (s *server) queueExec(ns, podName string, command []string, timeoutSeconds *int, ticket *execTicket) string {
+	execID := generateExecID()
+	queueCtx, queueCancel := context.WithCancel(context.Background())
+	s.execs.createQueued(ns, execID, timeoutSeconds, queueCancel)
+	go func() {
+		defer queueCancel()
+		waitCtx, cancel := context.WithTimeout(queueCtx, getenvDuration("SANDBOX_EXEC_QUEUE_TIMEOUT", defaultExecQueueTimeout))
+		err := s.waitForPodCreatedAndReady(waitCtx, ns, podName)
+		if err != nil {
+			ticket.leave()
+			err = fmt.Errorf("sandbox not ready: %v", err)
+		} else {
+			var release func()
+			if release, err = ticket.wait(waitCtx); err == nil {
+				defer release()
+			} else {
+				err = fmt.Errorf("no exec slot freed up: %v", err)
+			}
+		}
+		cancel()
+		if err == nil && !s.execs.markRunning(ns, execID) {
+			err = context.Canceled
+		}
+		if err != nil {
+			if queueCtx.Err() != nil {
+				// Canceled rather than timed out waiting.
+				err = context.Canceled
+			}
+			s.execs.finish(ns, execID, err)
+			s.publishExecExit(ns, execID, err)
+			return
+		}
+		execCtx := queueCtx
+		if timeoutSeconds != nil {
+			var execCancel context.CancelFunc
+			execCtx, execCancel = context.WithTimeout(queueCtx, time.Duration(*timeoutSeconds)*time.Second)
+			defer execCancel()
+		}
+		metricExecs.Add(1)
+		cmd, pidPath := asyncExecCommand(execID, command)
+		if pidPath != "" {
+			go s.trackExecPID(ns, execID, pidPath)
+		}
+		s.execCommandStream(execCtx, ns, podName, "sandbox", execID, cmd)
+	}()
+	return execID
+}
+
+// sandboxExists reports whether sandbox id exists, even if its pod doesn't yet: its
+// pod, its namespace, or a ?queue=true create still waiting for a slot.
+func (s *server) sandboxExists(ctx context.Context, id string) (bool, error) {
+	if errMsg, ok := s.queuedCreates.get(id); ok {
+		return errMsg == "", nil
+	}
+	podNS, podName := sandboxPod(id, "sandbox")
+	_, err := s.client.CoreV1().Pods(podNS).Get(ctx, podName, metav1.GetOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	if singleNamespace() != "" || !strings.HasPrefix(id, "sbx-") {
+		return false, nil
+	}
+	_, err = s.client.CoreV1().Namespaces().Get(ctx, id, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// waitForPodCreatedAndReady is waitForPodReady that also tolerates the pod not
+// existing yet.
+func (s *server) waitForPodCreatedAndReady(ctx context.Context, ns, name string) error {
+	for {
+		err := s.waitForPodReady(ctx, ns, name)
+		if err == nil || !apierrors.IsNotFound(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
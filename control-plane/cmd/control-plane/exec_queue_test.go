@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// waitExecStatus polls the registry until the exec reaches a terminal status.
+func waitExecStatus(t *testing.T, s *server, ns, execID string) api.ExecStatusResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st, ok := s.execs.get(ns, execID); ok && isTerminalExecStatus(st.Status) {
+			return st
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	st, _ := s.execs.get(ns, execID)
+	t.Fatalf("exec %s still %s", execID, st.Status)
+	return st
+}
+
+func TestQueuedExecStartsWhenPodBecomesReady(t *testing.T) {
+	pod := readyPod("sbx-a")
+	pod.Status = corev1.PodStatus{Phase: corev1.PodPending}
+	s := newTestServer(pod)
+	var ran atomic.Bool
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, _ remotecommand.StreamOptions) error {
+		if cmd[0] == "cat" {
+			return errors.New("no pid file")
+		}
+		ran.Store(true)
+		return nil
+	}
+
+	w := serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec?queue=true", api.ExecRequest{Command: []string{"true"}})
+	if w.Code != 200 {
+		t.Fatalf("exec: %d %s", w.Code, w.Body.String())
+	}
+	var resp api.ExecResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != execStatusQueued {
+		t.Fatalf("status = %q, want queued", resp.Status)
+	}
+
+	time.Sleep(700 * time.Millisecond)
+	if st, _ := s.execs.get("sbx-a", resp.ExecID); st.Status != execStatusQueued || ran.Load() {
+		t.Fatalf("exec %s (ran=%t) before the pod was ready", st.Status, ran.Load())
+	}
+	ctx := context.Background()
+	ready := readyPod("sbx-a")
+	if _, err := s.client.CoreV1().Pods("sbx-a").UpdateStatus(ctx, ready, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := waitExecStatus(t, s, "sbx-a", resp.ExecID); st.Status != execStatusCompleted || !ran.Load() {
+		t.Fatalf("exec = %+v (ran=%t), want completed once the pod is ready", st, ran.Load())
+	}
+}
+
+func TestQueuedExecTimesOutWhenPodNeverReady(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_QUEUE_TIMEOUT", "300ms")
+	s := newTestServer()
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		t.Error("exec ran without a ready pod")
+		return nil
+	}
+	ticket, _ := s.execSlots.enqueue("sbx-a")
+	execID := s.queueExec("sbx-a", "sandbox", []string{"true"}, nil, ticket)
+	st := waitExecStatus(t, s, "sbx-a", execID)
+	if st.Status != execStatusFailed || !strings.Contains(st.Error, "sandbox not ready") {
+		t.Fatalf("exec = %+v, want failed with sandbox not ready", st)
+	}
+}
+
+func TestQueuedExecUnknownSandbox(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-podless"}})
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		return errors.New("no pod")
+	}
+	w := serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-missing/exec?queue=true", api.ExecRequest{Command: []string{"true"}})
+	if w.Code != 404 || !strings.Contains(w.Body.String(), errCodeSandboxNotFound) {
+		t.Fatalf("exec: %d %s, want 404 %s", w.Code, w.Body.String(), errCodeSandboxNotFound)
+	}
+	// A namespace still waiting for its pod is a sandbox to queue behind.
+	w = serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-podless/exec?queue=true", api.ExecRequest{Command: []string{"true"}})
+	if w.Code != 200 {
+		t.Fatalf("exec: %d %s, want it queued", w.Code, w.Body.String())
+	}
+}
+
+func TestQueuedExecRecordsWaitTime(t *testing.T) {
+	pod := readyPod("sbx-a")
+	pod.Status = corev1.PodStatus{Phase: corev1.PodPending}
+	s := newTestServer(pod)
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, _ remotecommand.StreamOptions) error {
+		if cmd[0] == "cat" {
+			return errors.New("no pid file")
+		}
+		return nil
+	}
+
+	w := serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec?queue=true", api.ExecRequest{Command: []string{"true"}})
+	var resp api.ExecResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := s.execs.get("sbx-a", resp.ExecID); st.QueuedAt == "" || st.StartedAt != "" || st.WaitMs == nil {
+		t.Fatalf("queued exec = %+v, want queued_at and a running wait_ms but no started_at", st)
+	}
+
+	const delay = 400 * time.Millisecond
+	time.Sleep(delay)
+	if _, err := s.client.CoreV1().Pods("sbx-a").UpdateStatus(context.Background(), readyPod("sbx-a"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	st := waitExecStatus(t, s, "sbx-a", resp.ExecID)
+	if st.StartedAt == "" || st.WaitMs == nil {
+		t.Fatalf("finished exec = %+v, want started_at and wait_ms", st)
+	}
+	if *st.WaitMs < delay.Milliseconds() {
+		t.Errorf("wait_ms = %d, want at least %d while the pod wasn't ready", *st.WaitMs, delay.Milliseconds())
+	}
+	queued, _ := time.Parse(time.RFC3339Nano, st.QueuedAt)
+	started, _ := time.Parse(time.RFC3339Nano, st.StartedAt)
+	if got := started.Sub(queued).Milliseconds(); got != *st.WaitMs {
+		t.Errorf("started_at - queued_at = %dms, want wait_ms %d", got, *st.WaitMs)
+	}
+}
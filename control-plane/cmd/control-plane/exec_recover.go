@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sandbox/pkg/api"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// execIDPattern is the form generateExecID produces. Only such ids are looked up in
+// the pod, so a GET for a made-up id doesn't run anything there.
+var execIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// recoverMissTTL is how long an exec id the pod knew nothing about is answered with
+// 404 without asking the pod again.
+const recoverMissTTL = 30 * time.Second
+
+// recoverScript reports what the exec wrapper left behind for one exec: the exit code
+// file written by the sidecar or the plain wrapper, or a live PID file.
+const recoverScript = `exit_file=%s; alt_exit_file=%s; pid_file=%s; alt_pid_file=%s
+for f in "$exit_file" "$alt_exit_file"; do
+  if [ -f "$f" ]; then echo "exit $(cat "$f")"; exit 0; fi
+done
+for f in "$pid_file" "$alt_pid_file"; do
+  if [ -f "$f" ] && kill -0 "$(cat "$f")" 2>/dev/null; then echo "running $(cat "$f")"; exit 0; fi
+done
+echo unknown`
+
+// recoverMissCache remembers exec ids recovery found nothing for, keyed by
+// namespace/exec id.
+type recoverMissCache struct {
+	mu     sync.Mutex
+	misses map[string]time.Time
+}
+
+func (m *recoverMissCache) missed(key string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at, ok := m.misses[key]
+	return ok && now.Sub(at) < recoverMissTTL
+}
+
+func (m *recoverMissCache) add(key string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.misses == nil {
+		m.misses = map[string]time.Time{}
+	}
+	for k, at := range m.misses {
+		if now.Sub(at) >= recoverMissTTL {
+			delete(m.misses, k)
+		}
+	}
+	m.misses[key] = now
+}
+
+// recoverExecStatus rebuilds the status of an exec the registry doesn't know about,
+// typically because the control plane restarted, from the files in the sandbox pod.
+func (s *server) recoverExecStatus(ctx context.Context, ns, execID string) (api.ExecStatusResponse, bool) {
+	eventsDir := streamConfigFromEnv().eventsDir
+	script := fmt.Sprintf(recoverScript,
+		shellQuote(path.Join(eventsDir, execID+".exit")),
+		shellQuote(execExitPath(execID)),
+		shellQuote(execPIDPath(eventsDir, execID)),
+		shellQuote(execPIDPath(execPIDDir, execID)),
+	)
+	stdout, _, err := s.execCommand(ctx, ns, "sandbox", "sandbox", []string{"sh", "-c", script})
+	if err != nil {
+		return api.ExecStatusResponse{}, false
+	}
+	return parseRecoveredStatus(ns, execID, stdout)
+}
+
+// parseRecoveredStatus turns the output of recoverScript into a status.
+func parseRecoveredStatus(ns, execID, out string) (api.ExecStatusResponse, bool) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return api.ExecStatusResponse{}, false
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return api.ExecStatusResponse{}, false
+	}
+	resp := api.ExecStatusResponse{SandboxID: ns, ExecID: execID}
+	switch fields[0] {
+	case "exit":
+		resp.ExitCode = intPtr(n)
+		resp.Status = execStatusCompleted
+		if n != 0 {
+			resp.Status = execStatusFailed
+			resp.Error = fmt.Sprintf("command terminated with exit code %d", n)
+		}
+	case "running":
+		resp.PID = n
+		resp.Status = execStatusRunning
+	default:
+		return api.ExecStatusResponse{}, false
+	}
+	return resp, true
+}
+
+func (s *server) lookupExecStatus(ctx context.Context, ns, execID string) (api.ExecStatusResponse, bool) {
+	if status, ok := s.execs.get(ns, execID); ok {
+		return status, true
+	}
+	if !execIDPattern.MatchString(execID) {
+		return api.ExecStatusResponse{}, false
+	}
+	key := ns + "/" + execID
+	if s.recoverMisses.missed(key, time.Now()) {
+		return api.ExecStatusResponse{}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	status, ok := s.recoverExecStatus(ctx, ns, execID)
+	if !ok {
+		s.recoverMisses.add(key, time.Now())
+	}
+	return status, ok
+}
+
+// removeExecFiles deletes the exit code files the exec wrappers left in the sandbox
+// pod for execs whose status retention has run out, so they don't pile up in a
+// long-lived sandbox and recovery no longer reports them. With
+// SANDBOX_EXEC_SHELL=none no wrapper ran, so there is nothing to remove.
+func (s *server) removeExecFiles(ns string, execIDs []string) {
+	if execShell() == "" {
+		return
+	}
+	eventsDir := streamConfigFromEnv().eventsDir
+	args := []string{"rm", "-f"}
+	for _, execID := range execIDs {
+		args = append(args, path.Join(eventsDir, execID+".exit"), execExitPath(execID))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, stderr, err := s.execCommand(ctx, ns, "sandbox", "sandbox", args); err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("remove exec files namespace=%s: %v %s", ns, err, stderr)
+	}
+}
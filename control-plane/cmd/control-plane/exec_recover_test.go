@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestParseRecoveredStatus(t *testing.T) {
+	tests := []struct {
+		out      string
+		ok       bool
+		status   string
+		exitCode int
+		pid      int
+		hasError bool
+	}{
+		{out: "exit 0\n", ok: true, status: execStatusCompleted, exitCode: 0},
+		{out: "exit 2\n", ok: true, status: execStatusFailed, exitCode: 2, hasError: true},
+		{out: "running 41\n", ok: true, status: execStatusRunning, pid: 41},
+		{out: "unknown\n"},
+		{out: "exit x\n"},
+		{out: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			got, ok := parseRecoveredStatus("sbx-a", "0123456789abcdef", tt.out)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got.Status != tt.status || got.PID != tt.pid {
+				t.Errorf("got status %q pid %d, want %q pid %d", got.Status, got.PID, tt.status, tt.pid)
+			}
+			if tt.status != execStatusRunning && (got.ExitCode == nil || *got.ExitCode != tt.exitCode) {
+				t.Errorf("exit code = %v, want %d", got.ExitCode, tt.exitCode)
+			}
+			if (got.Error != "") != tt.hasError {
+				t.Errorf("error = %q, want set: %v", got.Error, tt.hasError)
+			}
+		})
+	}
+}
+
+func TestLookupExecStatusSkipsPodForUnknownIDs(t *testing.T) {
+	// A server without a client panics if anything is run in the pod.
+	s := &server{execs: newExecRegistry(time.Minute, 0)}
+	if _, ok := s.lookupExecStatus(context.Background(), "sbx-a", "not-an-exec-id"); ok {
+		t.Fatalf("lookup of a malformed id succeeded")
+	}
+	s.recoverMisses.add("sbx-a/0123456789abcdef", time.Now())
+	if _, ok := s.lookupExecStatus(context.Background(), "sbx-a", "0123456789abcdef"); ok {
+		t.Fatalf("lookup of a recent miss succeeded")
+	}
+}
+
+func TestRecoverMissCacheExpires(t *testing.T) {
+	var m recoverMissCache
+	now := time.Now()
+	m.add("sbx-a/x", now)
+	if !m.missed("sbx-a/x", now.Add(recoverMissTTL-time.Second)) {
+		t.Errorf("miss forgotten before the TTL")
+	}
+	if m.missed("sbx-a/x", now.Add(recoverMissTTL)) {
+		t.Errorf("miss remembered past the TTL")
+	}
+	m.add("sbx-a/y", now.Add(recoverMissTTL))
+	if _, ok := m.misses["sbx-a/x"]; ok {
+		t.Errorf("expired miss not pruned")
+	}
+}
+
+// TestWrapCommandWithPIDRecoverable runs the non-sidecar wrapper and the recovery
+// script locally to check that a finished exec can be recovered.
+func TestWrapCommandWithPIDRecoverable(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	execID := generateExecID()
+	t.Cleanup(func() { os.Remove(execExitPath(execID)) })
+
+	t.Setenv("SANDBOX_EXEC_SHELL", "sh")
+	wrapped := wrapCommandWithPID(execID, []string{"sh", "-c", "exit 3"})
+	if err := exec.Command(wrapped[0], wrapped[1:]...).Run(); err == nil {
+		t.Fatalf("wrapped command succeeded, want exit 3")
+	}
+	script := fmt.Sprintf(recoverScript,
+		shellQuote(path.Join(t.TempDir(), execID+".exit")),
+		shellQuote(execExitPath(execID)),
+		shellQuote(path.Join(t.TempDir(), execID+".pid")),
+		shellQuote(execPIDPath(execPIDDir, execID)),
+	)
+	out, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Fatalf("recover script: %v", err)
+	}
+	got, ok := parseRecoveredStatus("sbx-a", execID, string(out))
+	if !ok || got.Status != execStatusFailed || got.ExitCode == nil || *got.ExitCode != 3 {
+		t.Fatalf("recovered %+v (ok %v) from %q, want failed with exit code 3", got, ok, out)
+	}
+}
+
+func TestRemoveExecFilesOnRetentionExpiry(t *testing.T) {
+	s := newTestServer()
+	s.execs = newExecRegistry(time.Millisecond, 0)
+	s.execs.createRunning("sbx-a", "0123456789abcdef", time.Now(), nil, func() {})
+	s.execs.finish("sbx-a", "0123456789abcdef", nil)
+	removed := make(chan []string, 1)
+	s.podExec = func(_ context.Context, ns, _, _ string, cmd []string, _ remotecommand.StreamOptions) error {
+		if ns == "sbx-a" {
+			select {
+			case removed <- cmd:
+			default:
+			}
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.execs.start(ctx, s.removeExecFiles)
+
+	select {
+	case cmd := <-removed:
+		want := []string{"rm", "-f", "/sbx-events/0123456789abcdef.exit", execExitPath("0123456789abcdef")}
+		if strings.Join(cmd, " ") != strings.Join(want, " ") {
+			t.Fatalf("cmd = %q, want %q", cmd, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit files not removed after the status expired")
+	}
+	if _, ok := s.execs.get("sbx-a", "0123456789abcdef"); ok {
+		t.Error("expired status still in the registry")
+	}
+}
+
+func TestLookupExecStatusRecoversFromPod(t *testing.T) {
+	s := newTestServer()
+	var runs int
+	s.podExec = func(_ context.Context, _, _, _ string, _ []string, opts remotecommand.StreamOptions) error {
+		runs++
+		io.WriteString(opts.Stdout, "exit 0\n")
+		return nil
+	}
+	got, ok := s.lookupExecStatus(context.Background(), "sbx-a", "0123456789abcdef")
+	if !ok || got.Status != execStatusCompleted || got.Error != "" {
+		t.Fatalf("recovered %+v (ok %v), want completed without an error", got, ok)
+	}
+	if runs != 1 {
+		t.Errorf("pod exec ran %d times, want 1", runs)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"sandbox/pkg/api"
 
@@ -12,6 +13,7 @@ import (
 )
 
 const (
+	execStatusQueued    = "queued"
 	execStatusRunning   = "running"
 	execStatusCanceling = "canceling"
 	execStatusCompleted = "completed"
@@ -20,49 +22,80 @@ const (
 	execStatusTimedOut  = "timed_out"
 )
 
+const defaultExecOutputTailBytes = 4096
+
 type execRegistry struct {
 	mu        sync.Mutex
 	bySandbox map[string]map[string]*execRecord
 	retention time.Duration
+	// tailBytes bounds the stdout and stderr kept per exec; 0 disables capture.
+	tailBytes int
 }
 
 type execRecord struct {
-	sandboxID       string
-	execID          string
-	status          string
-	timeoutSeconds  *int
+	sandboxID      string
+	execID         string
+	status         string
+	timeoutSeconds *int
+	// queuedAt is when the exec was accepted; startedAt is when its stream to the
+	// pod opened. The gap is time spent waiting for the sandbox.
+	queuedAt        time.Time
 	startedAt       time.Time
 	finishedAt      *time.Time
 	exitCode        *int
 	errMsg          string
 	cancel          context.CancelFunc
 	cancelRequested bool
+	// pid is the in-container PID of the exec's shell, or 0 when unknown.
+	pid int
+	// stdout and stderr hold the last tailBytes of output; truncated is set once
+	// anything has been dropped.
+	stdout    []byte
+	stderr    []byte
+	truncated bool
+	// done is closed when the exec reaches a terminal status.
+	done chan struct{}
 }
 
-func newExecRegistry(retention time.Duration) *execRegistry {
+func newExecRegistry(retention time.Duration, tailBytes int) *execRegistry {
 	if retention <= 0 {
 		retention = 30 * time.Minute
 	}
+	if tailBytes < 0 {
+		tailBytes = 0
+	}
 	return &execRegistry{
 		bySandbox: map[string]map[string]*execRecord{},
 		retention: retention,
+		tailBytes: tailBytes,
 	}
 }
 
-func (r *execRegistry) start(ctx context.Context) {
-	t := time.NewTicker(time.Minute)
+// start periodically drops terminal records older than the retention window and
+// hands each sandbox's dropped exec ids to expired, when it is set. The sweep
+// interval never exceeds the retention so short windows are honored.
+func (r *execRegistry) start(ctx context.Context, expired func(sandboxID string, execIDs []string)) {
+	interval := time.Minute
+	if r.retention < interval {
+		interval = r.retention
+	}
+	t := time.NewTicker(interval)
 	defer t.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-t.C:
-			r.reapExpired(time.Now())
+			for sandboxID, execIDs := range r.reapExpired(time.Now()) {
+				if expired != nil {
+					expired(sandboxID, execIDs)
+				}
+			}
 		}
 	}
 }
 
-func (r *execRegistry) createRunning(sandboxID, execID string, timeoutSeconds *int, cancel context.CancelFunc) {
+func (r *execRegistry) createRunning(sandboxID, execID string, queuedAt time.Time, timeoutSeconds *int, cancel context.CancelFunc) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	byExec := r.bySandbox[sandboxID]
@@ -76,8 +109,40 @@ func (r *execRegistry) createRunning(sandboxID, execID string, timeoutSeconds *i
 		execID:         execID,
 		status:         execStatusRunning,
 		timeoutSeconds: timeoutCopy,
-		startedAt:      time.Now().UTC(),
+		queuedAt:       queuedAt.UTC(),
 		cancel:         cancel,
+		done:           make(chan struct{}),
+	}
+}
+
+// createQueued registers an exec that is waiting for its sandbox to become ready.
+func (r *execRegistry) createQueued(sandboxID, execID string, timeoutSeconds *int, cancel context.CancelFunc) {
+	r.createRunning(sandboxID, execID, time.Now(), timeoutSeconds, cancel)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.getLocked(sandboxID, execID)
+	rec.status = execStatusQueued
+}
+
+// markRunning moves a queued exec to running. It reports false when the exec is no
+// longer queued, e.g. because it was canceled.
+func (r *execRegistry) markRunning(sandboxID, execID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.getLocked(sandboxID, execID)
+	if rec == nil || rec.status != execStatusQueued {
+		return false
+	}
+	rec.status = execStatusRunning
+	return true
+}
+
+// markStarted records that the exec's stream to the pod is open.
+func (r *execRegistry) markStarted(sandboxID, execID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec := r.getLocked(sandboxID, execID); rec != nil && rec.startedAt.IsZero() {
+		rec.startedAt = time.Now().UTC()
 	}
 }
 
@@ -91,7 +156,22 @@ func (r *execRegistry) get(sandboxID, execID string) (api.ExecStatusResponse, bo
 	return rec.toAPI(), true
 }
 
-func (r *execRegistry) requestCancel(sandboxID, execID string) (api.ExecStatusResponse, bool, bool) {
+// doneChan returns a channel that is closed once the exec reaches a terminal
+// status, or false when the registry doesn't know the exec.
+func (r *execRegistry) doneChan(sandboxID, execID string) (<-chan struct{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.getLocked(sandboxID, execID)
+	if rec == nil {
+		return nil, false
+	}
+	return rec.done, true
+}
+
+// requestCancel marks the exec as canceling. When the in-container PID is known and
+// terminate is set, terminate runs first so the process gets a chance to exit cleanly;
+// the exec context is canceled afterwards either way.
+func (r *execRegistry) requestCancel(sandboxID, execID string, terminate func(pid int)) (api.ExecStatusResponse, bool, bool) {
 	r.mu.Lock()
 	rec := r.getLocked(sandboxID, execID)
 	if rec == nil {
@@ -110,15 +190,39 @@ func (r *execRegistry) requestCancel(sandboxID, execID string) (api.ExecStatusRe
 		return snapshot, true, false
 	}
 	rec.cancelRequested = true
-	if rec.status == execStatusRunning {
+	if rec.status == execStatusRunning || rec.status == execStatusQueued {
 		rec.status = execStatusCanceling
 	}
 	snapshot := rec.toAPI()
+	pid := rec.pid
 	r.mu.Unlock()
-	cancel()
+	if pid > 0 && terminate != nil {
+		go func() {
+			terminate(pid)
+			cancel()
+		}()
+	} else {
+		cancel()
+	}
 	return snapshot, true, true
 }
 
+// cancelQueued cancels the execs in sandboxID that haven't started yet, for a
+// sandbox that is going away.
+func (r *execRegistry) cancelQueued(sandboxID string) {
+	r.mu.Lock()
+	var queued []string
+	for execID, rec := range r.bySandbox[sandboxID] {
+		if rec.status == execStatusQueued {
+			queued = append(queued, execID)
+		}
+	}
+	r.mu.Unlock()
+	for _, execID := range queued {
+		r.requestCancel(sandboxID, execID, nil)
+	}
+}
+
 func (r *execRegistry) finish(sandboxID, execID string, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -126,6 +230,14 @@ func (r *execRegistry) finish(sandboxID, execID string, err error) {
 	if rec == nil {
 		return
 	}
+	// Only the call that ends the exec counts its outcome, so a repeated finish
+	// doesn't count it twice.
+	if rec.finishedAt == nil {
+		defer func() {
+			metricExecOutcome.Add(rec.status, 1)
+			close(rec.done)
+		}()
+	}
 	now := time.Now().UTC()
 	rec.finishedAt = &now
 	rec.cancel = nil
@@ -166,9 +278,12 @@ func (r *execRegistry) finish(sandboxID, execID string, err error) {
 	rec.errMsg = err.Error()
 }
 
-func (r *execRegistry) reapExpired(now time.Time) {
+// reapExpired drops terminal records older than the retention window and returns
+// their exec ids by sandbox.
+func (r *execRegistry) reapExpired(now time.Time) map[string][]string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	reaped := map[string][]string{}
 	for sandboxID, byExec := range r.bySandbox {
 		for execID, rec := range byExec {
 			if rec == nil || !isTerminalExecStatus(rec.status) || rec.finishedAt == nil {
@@ -176,12 +291,89 @@ func (r *execRegistry) reapExpired(now time.Time) {
 			}
 			if now.Sub(*rec.finishedAt) > r.retention {
 				delete(byExec, execID)
+				reaped[sandboxID] = append(reaped[sandboxID], execID)
 			}
 		}
 		if len(byExec) == 0 {
 			delete(r.bySandbox, sandboxID)
 		}
 	}
+	return reaped
+}
+
+// setPID records the in-container PID of a running exec.
+func (r *execRegistry) setPID(sandboxID, execID string, pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec := r.getLocked(sandboxID, execID); rec != nil && !isTerminalExecStatus(rec.status) {
+		rec.pid = pid
+	}
+}
+
+// appendOutput adds exec output to the record's bounded tail.
+func (r *execRegistry) appendOutput(sandboxID, execID, stream string, p []byte) {
+	if r.tailBytes == 0 || len(p) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.getLocked(sandboxID, execID)
+	if rec == nil {
+		return
+	}
+	switch stream {
+	case "stdout":
+		rec.stdout = r.appendTail(rec, rec.stdout, p)
+	case "stderr":
+		rec.stderr = r.appendTail(rec, rec.stderr, p)
+	}
+}
+
+func (r *execRegistry) appendTail(rec *execRecord, buf, p []byte) []byte {
+	buf = append(buf, p...)
+	if len(buf) <= r.tailBytes {
+		return buf
+	}
+	rec.truncated = true
+	cut := len(buf) - r.tailBytes
+	// Don't start the tail in the middle of a UTF-8 sequence.
+	for cut < len(buf) && !utf8.RuneStart(buf[cut]) {
+		cut++
+	}
+	return append([]byte(nil), buf[cut:]...)
+}
+
+// output returns the captured stdout and stderr tails.
+func (r *execRegistry) output(sandboxID, execID string) (string, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.getLocked(sandboxID, execID)
+	if rec == nil {
+		return "", "", false
+	}
+	return string(rec.stdout), string(rec.stderr), rec.truncated
+}
+
+// running reports whether the exec is known and not yet in a terminal state.
+func (r *execRegistry) running(sandboxID, execID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.getLocked(sandboxID, execID)
+	return rec != nil && !isTerminalExecStatus(rec.status)
+}
+
+func (r *execRegistry) countRunning() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, byExec := range r.bySandbox {
+		for _, rec := range byExec {
+			if rec != nil && !isTerminalExecStatus(rec.status) {
+				n++
+			}
+		}
+	}
+	return n
 }
 
 func (r *execRegistry) getLocked(sandboxID, execID string) *execRecord {
@@ -199,6 +391,20 @@ func (r *execRecord) toAPI() api.ExecStatusResponse {
 		Status:         r.status,
 		TimeoutSeconds: intPtrCopy(r.timeoutSeconds),
 		Error:          r.errMsg,
+		PID:            r.pid,
+	}
+	if !r.queuedAt.IsZero() {
+		resp.QueuedAt = r.queuedAt.UTC().Format(time.RFC3339Nano)
+		// Until the exec starts, report how long it has waited so far.
+		waitEnd := time.Now()
+		switch {
+		case !r.startedAt.IsZero():
+			waitEnd = r.startedAt
+		case r.finishedAt != nil:
+			waitEnd = *r.finishedAt
+		}
+		waitMs := waitEnd.Sub(r.queuedAt).Milliseconds()
+		resp.WaitMs = &waitMs
 	}
 	if !r.startedAt.IsZero() {
 		resp.StartedAt = r.startedAt.UTC().Format(time.RFC3339Nano)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	utilsexec "k8s.io/client-go/util/exec"
+)
+
+func TestExecRegistryReapExpired(t *testing.T) {
+	r := newExecRegistry(time.Minute, 0)
+	r.createRunning("sbx-a", "done", time.Now(), nil, func() {})
+	r.createRunning("sbx-a", "running", time.Now(), nil, func() {})
+	r.finish("sbx-a", "done", nil)
+
+	// reapExpired takes the current time, so the clock is advanced by hand.
+	clock := time.Now()
+	r.reapExpired(clock.Add(30 * time.Second))
+	if _, ok := r.get("sbx-a", "done"); !ok {
+		t.Fatal("finished exec reaped before the retention window passed")
+	}
+
+	clock = clock.Add(2 * time.Minute)
+	r.reapExpired(clock)
+	if _, ok := r.get("sbx-a", "done"); ok {
+		t.Fatal("finished exec kept past the retention window")
+	}
+	if status, ok := r.get("sbx-a", "running"); !ok || status.Status != execStatusRunning {
+		t.Fatalf("running exec = %+v, %v; want kept as running", status, ok)
+	}
+
+	clock = clock.Add(time.Hour)
+	r.reapExpired(clock)
+	if _, ok := r.get("sbx-a", "running"); !ok {
+		t.Fatal("running exec reaped; only terminal records expire")
+	}
+}
+
+func TestExecRegistryOutputTail(t *testing.T) {
+	r := newExecRegistry(time.Minute, 8)
+	r.createRunning("sbx-a", "e1", time.Now(), nil, func() {})
+	r.appendOutput("sbx-a", "e1", "stdout", []byte("hello "))
+	r.appendOutput("sbx-a", "e1", "stderr", []byte("oops"))
+	if stdout, stderr, truncated := r.output("sbx-a", "e1"); stdout != "hello " || stderr != "oops" || truncated {
+		t.Fatalf("output = %q %q truncated=%t, want untruncated", stdout, stderr, truncated)
+	}
+	// "wörld" pushes the tail past 8 bytes; the cut must not split the ö.
+	r.appendOutput("sbx-a", "e1", "stdout", []byte("wörld"))
+	stdout, _, truncated := r.output("sbx-a", "e1")
+	if stdout != "o wörld" || !truncated {
+		t.Fatalf("stdout = %q truncated=%t, want %q truncated", stdout, truncated, "o wörld")
+	}
+}
+
+func TestExecRegistryFinishCountsOutcomes(t *testing.T) {
+	r := newExecRegistry(time.Minute, 0)
+	tests := []struct {
+		execID string
+		err    error
+		cancel bool
+		want   string
+	}{
+		{execID: "ok", want: execStatusCompleted},
+		{execID: "exit", err: utilsexec.CodeExitError{Err: errExit, Code: 2}, want: execStatusFailed},
+		{execID: "broken", err: errors.New("stream closed"), want: execStatusFailed},
+		{execID: "slow", err: context.DeadlineExceeded, want: execStatusTimedOut},
+		{execID: "stopped", err: context.Canceled, cancel: true, want: execStatusCanceled},
+	}
+	for _, tt := range tests {
+		r.createRunning("sbx-a", tt.execID, time.Now(), nil, func() {})
+		if tt.cancel {
+			r.requestCancel("sbx-a", tt.execID, nil)
+		}
+		before := expvarInt(metricExecOutcome.Get(tt.want))
+		r.finish("sbx-a", tt.execID, tt.err)
+		if got := expvarInt(metricExecOutcome.Get(tt.want)) - before; got != 1 {
+			t.Errorf("%s: %s counted %d times, want 1", tt.execID, tt.want, got)
+		}
+		if status, _ := r.get("sbx-a", tt.execID); status.Status != tt.want {
+			t.Errorf("%s: status = %s, want %s", tt.execID, status.Status, tt.want)
+		}
+	}
+
+	// A repeated finish for the same exec is not counted again.
+	before := expvarInt(metricExecOutcome.Get(execStatusCompleted))
+	r.finish("sbx-a", "ok", nil)
+	if got := expvarInt(metricExecOutcome.Get(execStatusCompleted)) - before; got != 0 {
+		t.Errorf("repeated finish counted %d more completions", got)
+	}
+}
+
+func expvarInt(v expvar.Var) int64 {
+	if i, ok := v.(*expvar.Int); ok {
+		return i.Value()
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	defaultExecShell = "bash"
+	// execShellNone runs exec argv directly, for images without a shell.
+	execShellNone = "none"
+)
+
+// execShell returns SANDBOX_EXEC_SHELL, the shell that runs the exec wrappers and
+// shell execs, or "" when it is none. Invalid values are rejected at startup.
+func execShell() string {
+	shell := getenv("SANDBOX_EXEC_SHELL", defaultExecShell)
+	switch {
+	case shell == execShellNone:
+		return ""
+	case validateExecShell(shell) != nil:
+		return defaultExecShell
+	}
+	return shell
+}
+
+func validateExecShell(shell string) error {
+	if shell != execShellNone && !scriptShellPattern.MatchString(shell) {
+		return fmt.Errorf("SANDBOX_EXEC_SHELL must be a shell name or path, e.g. sh or /bin/ash, or none")
+	}
+	return nil
+}
+
+// shellCommand runs script with the exec shell. bash gets -l so login profiles
+// set up PATH the way an interactive user would see it; other shells get plain -c.
+// SANDBOX_EXEC_PATH_PREPEND goes in front of PATH after the profiles have run.
+func shellCommand(shell, script string) []string {
+	if prepend := execPathPrepend(); prepend != "" {
+		script = "PATH=" + shellQuote(prepend) + `:"$PATH"; export PATH; ` + script
+	}
+	if path.Base(shell) == "bash" {
+		return []string{shell, "-lc", script}
+	}
+	return []string{shell, "-c", script}
+}
+
+// execPathPrepend returns SANDBOX_EXEC_PATH_PREPEND, colon-separated directories
+// searched before the image's PATH by execs that run through the exec shell.
+// Invalid values are rejected at startup.
+func execPathPrepend() string {
+	prepend := getenv("SANDBOX_EXEC_PATH_PREPEND", "")
+	if validateExecPathPrepend(prepend) != nil {
+		return ""
+	}
+	return prepend
+}
+
+func validateExecPathPrepend(prepend string) error {
+	if prepend == "" {
+		return nil
+	}
+	if execShell() == "" {
+		return fmt.Errorf("SANDBOX_EXEC_PATH_PREPEND needs a shell, and SANDBOX_EXEC_SHELL is none")
+	}
+	for _, dir := range strings.Split(prepend, ":") {
+		if !path.IsAbs(dir) {
+			return fmt.Errorf("SANDBOX_EXEC_PATH_PREPEND entries must be absolute paths, got %q", dir)
+		}
+	}
+	return nil
+}
+
+// syncExecCommand runs a sync exec's argv through the exec shell when
+// SANDBOX_EXEC_LOGIN_SHELL or SANDBOX_EXEC_PATH_PREPEND is set, so it finds the
+// same commands as an async exec, whose wrapper always runs in the shell. The
+// argv is passed as positional parameters, so nothing is re-quoted.
+func syncExecCommand(cmd []string) []string {
+	shell := execShell()
+	if shell == "" || (!getenvBool("SANDBOX_EXEC_LOGIN_SHELL", false) && execPathPrepend() == "") {
+		return cmd
+	}
+	return append(shellCommand(shell, `exec "$@"`), append([]string{shell}, cmd...)...)
+}
+
+// explainExecError turns the runtime's "executable file not found" for the exec
+// shell into an error that says what to change, instead of the opaque OCI message.
+func explainExecError(cmd []string, err error) error {
+	if err == nil || len(cmd) == 0 {
+		return err
+	}
+	shell := execShell()
+	if shell == "" || cmd[0] != shell {
+		return err
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "executable file not found") && !strings.Contains(msg, "no such file or directory") {
+		return err
+	}
+	return fmt.Errorf("the sandbox image has no %s; set SANDBOX_EXEC_SHELL to a shell the image has (e.g. sh), or to none for images without a shell: %v", shell, err)
+}
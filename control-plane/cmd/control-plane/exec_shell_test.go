@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+)
+
+func TestExecShellDefaultIsBash(t *testing.T) {
+	cmd := wrapCommandWithPID("e1", []string{"true"})
+	if cmd[0] != "sh" || cmd[1] != "-c" || !strings.Contains(cmd[2], "exec bash -lc ") {
+		t.Errorf("wrapper = %q, want sh -c running the command under bash -lc", cmd)
+	}
+	got, err := execCommandFromRequest(api.ExecRequest{Shell: "echo hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bash", "-lc", "echo hi"}; strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("shell exec = %q, want %q", got, want)
+	}
+}
+
+func TestExecShellSh(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	t.Setenv("SANDBOX_EXEC_SHELL", "sh")
+	got, err := execCommandFromRequest(api.ExecRequest{Shell: "echo hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "sh" || got[1] != "-c" {
+		t.Errorf("shell exec = %q, want sh -c", got)
+	}
+
+	dir := t.TempDir()
+	cmd := wrapCommandForSidecar("e1", []string{"sh", "-c", "echo $$"}, dir)
+	if cmd[0] != "sh" || cmd[1] != "-c" {
+		t.Fatalf("wrapper = %q, want sh -c", cmd[:2])
+	}
+	// The wrapper must not rely on bash-only syntax.
+	if out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+		t.Fatalf("wrapped command under sh: %v %s", err, out)
+	}
+	pidFile, err := os.ReadFile(execPIDPath(dir, "e1"))
+	if err != nil {
+		t.Fatalf("pid file not written: %v", err)
+	}
+	stdout, err := os.ReadFile(filepath.Join(dir, "e1.stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(pidFile)), strings.TrimSpace(string(stdout)); got != want {
+		t.Errorf("pid file = %q, want the command's own PID %q", got, want)
+	}
+}
+
+func TestWrapCommandWithPIDWithoutBash(t *testing.T) {
+	// Only the tools the wrapper itself needs, and no bash.
+	bin := t.TempDir()
+	for _, name := range []string{"sh", "mkdir", "rm"} {
+		p, err := exec.LookPath(name)
+		if err != nil {
+			t.Skip(name + " not available")
+		}
+		if err := os.Symlink(p, filepath.Join(bin, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	execID := generateExecID()
+	t.Cleanup(func() { os.Remove(execExitPath(execID)) })
+
+	cmd := wrapCommandWithPID(execID, []string{"sh", "-c", "exit 3"})
+	run := exec.Command(filepath.Join(bin, "sh"), cmd[1:]...)
+	run.Env = []string{"PATH=" + bin}
+	err := run.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("wrapped command: %v, want exit 3 from the command run without bash", err)
+	}
+	if code, err := os.ReadFile(execExitPath(execID)); err != nil || strings.TrimSpace(string(code)) != "3" {
+		t.Errorf("exit file = %q (%v), want 3", code, err)
+	}
+}
+
+func TestExecShellNone(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_SHELL", "none")
+	t.Setenv("SANDBOX_STREAM_SIDECAR_IMAGE", "busybox")
+	cmd, pidPath := asyncExecCommand("e1", []string{"/app/run", "--flag"})
+	if strings.Join(cmd, " ") != "/app/run --flag" || pidPath != "" {
+		t.Errorf("asyncExecCommand = %q, %q; want the bare command and no PID path", cmd, pidPath)
+	}
+	for _, req := range []api.ExecRequest{{Shell: "echo hi"}, {Script: "echo hi"}} {
+		if _, err := execCommandFromRequest(req); err == nil || !strings.Contains(err.Error(), "SANDBOX_EXEC_SHELL") {
+			t.Errorf("%+v: err = %v, want a SANDBOX_EXEC_SHELL error", req, err)
+		}
+	}
+	if _, err := execCommandFromRequest(api.ExecRequest{Command: []string{"/app/run"}}); err != nil {
+		t.Errorf("command exec: %v", err)
+	}
+}
+
+func TestExplainExecError(t *testing.T) {
+	missing := errors.New(`exec: "bash": executable file not found in $PATH`)
+	err := explainExecError([]string{"bash", "-lc", "true"}, missing)
+	if !strings.Contains(err.Error(), "SANDBOX_EXEC_SHELL") || !strings.Contains(err.Error(), "has no bash") {
+		t.Errorf("err = %v, want a hint about SANDBOX_EXEC_SHELL", err)
+	}
+	if err := explainExecError([]string{"python3", "x.py"}, missing); err != missing {
+		t.Errorf("non-shell command: err = %v, want it unchanged", err)
+	}
+	other := errors.New("command terminated with exit code 1")
+	if err := explainExecError([]string{"bash", "-lc", "false"}, other); err != other {
+		t.Errorf("exit error: err = %v, want it unchanged", err)
+	}
+}
+
+func TestValidateExecShell(t *testing.T) {
+	for _, shell := range []string{"bash", "sh", "/bin/ash", "none"} {
+		if err := validateExecShell(shell); err != nil {
+			t.Errorf("%q: %v", shell, err)
+		}
+	}
+	if err := validateExecShell("sh -x; rm"); err == nil {
+		t.Error("shell with spaces accepted")
+	}
+}
+
+func TestExecPathPrependFindsToolInBothModes(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sbx-custom-tool"), []byte("#!/bin/sh\necho found \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SANDBOX_EXEC_SHELL", "sh")
+	t.Setenv("SANDBOX_EXEC_PATH_PREPEND", dir)
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = localPodExec
+	run := func(async bool) api.ExecResponse {
+		w := serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec",
+			api.ExecRequest{Command: []string{"sbx-custom-tool", "a b"}, Async: &async})
+		var resp api.ExecResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 200 {
+			t.Fatalf("async=%t: status %d %s", async, w.Code, w.Body)
+		}
+		return resp
+	}
+
+	if resp := run(false); resp.Stdout != "found a b\n" {
+		t.Errorf("sync stdout = %q, want the tool's output with its argument intact", resp.Stdout)
+	}
+	resp := run(true)
+	s.waitExecDone(context.Background(), "sbx-a", resp.ExecID, 10*time.Second)
+	status, _ := s.execs.get("sbx-a", resp.ExecID)
+	stdout, _, _ := s.execs.output("sbx-a", resp.ExecID)
+	if status.Status != execStatusCompleted || stdout != "found a b\n" {
+		t.Errorf("async exec %s with stdout %q, want completed with the tool's output", status.Status, stdout)
+	}
+}
+
+func TestSyncExecCommand(t *testing.T) {
+	cmd := []string{"python3", "-V"}
+	if got := syncExecCommand(cmd); len(got) != 2 {
+		t.Errorf("without a prepend or login shell the argv runs as is, got %q", got)
+	}
+	t.Setenv("SANDBOX_EXEC_LOGIN_SHELL", "true")
+	if got := syncExecCommand(cmd); got[0] != "bash" || got[1] != "-lc" || got[len(got)-1] != "-V" {
+		t.Errorf("login shell argv = %q, want it run by bash -lc", got)
+	}
+	t.Setenv("SANDBOX_EXEC_SHELL", "none")
+	if got := syncExecCommand(cmd); len(got) != 2 {
+		t.Errorf("without a shell the argv runs as is, got %q", got)
+	}
+	if err := validateExecPathPrepend("/opt/tools/bin"); err == nil {
+		t.Error("a path prepend without a shell was accepted")
+	}
+	t.Setenv("SANDBOX_EXEC_SHELL", "bash")
+	if err := validateExecPathPrepend("/opt/tools/bin:relative/bin"); err == nil {
+		t.Error("a relative path prepend was accepted")
+	}
+}
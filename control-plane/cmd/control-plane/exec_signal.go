@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+)
+
+// execPIDDir holds PID and exit code files for wrapped execs when no sidecar events
+// dir is mounted.
+const execPIDDir = "/tmp/sbx-exec"
+
+func execPIDPath(dir, execID string) string {
+	if dir == "" {
+		dir = "/sbx-events"
+	}
+	return path.Join(dir, execID+".pid")
+}
+
+// execExitPath is where wrapCommandWithPID records the exit code.
+func execExitPath(execID string) string {
+	return path.Join(execPIDDir, execID+".exit")
+}
+
+// trackExecPID polls the exec's PID file until it appears, the exec finishes, or a
+// few seconds pass, and stores the PID in the registry. Each poll is a pod exec, so
+// the interval doubles from 100ms up to 2s, which is at most eight reads.
+func (s *server) trackExecPID(ns, execID, pidPath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	delay := 100 * time.Millisecond
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, 2*time.Second)
+		if !s.execs.running(ns, execID) {
+			return
+		}
+		stdout, _, err := s.execCommand(ctx, ns, "sandbox", "sandbox", []string{"cat", pidPath})
+		if err != nil {
+			continue
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(stdout)); err == nil && pid > 0 {
+			s.execs.setPID(ns, execID, pid)
+			return
+		}
+	}
+}
+
+// terminateScript sends SIGTERM to the exec's process group (or the process itself
+// when it doesn't lead one), polls for up to ticks*100ms and then sends SIGKILL.
+const terminateScript = `pid=%d; ticks=%d
+kill -TERM -- -$pid 2>/dev/null || kill -TERM $pid 2>/dev/null || exit 0
+i=0
+while kill -0 $pid 2>/dev/null && [ $i -lt $ticks ]; do sleep 0.1; i=$((i+1)); done
+kill -KILL -- -$pid 2>/dev/null || kill -KILL $pid 2>/dev/null
+exit 0`
+
+// terminateExecProcess stops an exec inside the sandbox container: SIGTERM first, then
+// SIGKILL once SANDBOX_EXEC_CANCEL_GRACE has passed.
+func (s *server) terminateExecProcess(ns string, pid int) {
+	grace := getenvDuration("SANDBOX_EXEC_CANCEL_GRACE", 5*time.Second)
+	ticks := int(grace / (100 * time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), grace+10*time.Second)
+	defer cancel()
+	script := fmt.Sprintf(terminateScript, pid, ticks)
+	if _, stderr, err := s.execCommand(ctx, ns, "sandbox", "sandbox", []string{"sh", "-c", script}); err != nil {
+		log.Printf("terminate exec pid=%d namespace=%s: %v %s", pid, ns, err, stderr)
+	}
+}
+
+// allowedSignals are the signals that may be sent to a running exec.
+var allowedSignals = []string{"HUP", "INT", "QUIT", "KILL", "USR1", "USR2", "TERM", "CONT", "STOP"}
+
+// normalizeSignal accepts "SIGINT", "INT" or "int" and returns "INT".
+func normalizeSignal(name string) (string, bool) {
+	sig := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	return sig, containsString(allowedSignals, sig)
+}
+
+func (s *server) signalExec(c *gin.Context) {
+	id := c.Param("id")
+	execID := c.Param("exec_id")
+	var req api.SignalRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	sig, ok := normalizeSignal(req.Signal)
+	if !ok {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "signal must be one of: SIG"+strings.Join(allowedSignals, ", SIG"))
+		return
+	}
+	status, found := s.execs.get(id, execID)
+	if !found {
+		writeErrorCode(c, 404, errCodeExecNotFound, "exec not found")
+		return
+	}
+	if isTerminalExecStatus(status.Status) {
+		writeErrorCode(c, 409, errCodeExecFinished, "exec is already in terminal state")
+		return
+	}
+	if status.PID == 0 {
+		writeErrorCode(c, 409, errCodeExecPIDUnknown, "exec pid is not known yet")
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	script := fmt.Sprintf("kill -%s -- -%d 2>/dev/null || kill -%s %d", sig, status.PID, sig, status.PID)
+	if _, stderr, err := s.execCommand(ctx, id, "sandbox", "sandbox", []string{"sh", "-c", script}); err != nil {
+		writeError(c, 500, strings.TrimSpace(stderr+" "+err.Error()))
+		return
+	}
+	status, _ = s.execs.get(id, execID)
+	writeJSON(c, 200, status)
+}
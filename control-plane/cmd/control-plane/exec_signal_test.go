@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestCancelExecTerminatesKnownPID(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_CANCEL_GRACE", "200ms")
+	s := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.execs.createRunning("sbx-a", "e1", time.Now(), nil, cancel)
+	s.execs.setPID("sbx-a", "e1", 42)
+
+	var mu sync.Mutex
+	var script string
+	var canceledFirst bool
+	s.podExec = func(_ context.Context, ns, pod, container string, cmd []string, _ remotecommand.StreamOptions) error {
+		mu.Lock()
+		defer mu.Unlock()
+		script = strings.Join(cmd, " ")
+		canceledFirst = ctx.Err() != nil
+		return nil
+	}
+
+	w := serve(s.cancelExec, "POST", "/sandboxes/:id/execs/:exec_id/cancel", "/sandboxes/sbx-a/execs/e1/cancel", nil)
+	if w.Code != 200 {
+		t.Fatalf("cancel = %d %s", w.Code, w.Body.String())
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("exec context not canceled after terminate")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(script, "pid=42; ticks=2") || !strings.Contains(script, "kill -TERM -- -$pid") {
+		t.Errorf("terminate script = %q, want SIGTERM to process group 42 with a 2-tick grace", script)
+	}
+	if canceledFirst {
+		t.Error("exec context canceled before the process was signaled")
+	}
+}
+
+func TestCancelExecWithoutPIDCancelsContext(t *testing.T) {
+	s := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.execs.createRunning("sbx-a", "e1", time.Now(), nil, cancel)
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		t.Error("exec into the sandbox without a known PID")
+		return nil
+	}
+	serve(s.cancelExec, "POST", "/sandboxes/:id/execs/:exec_id/cancel", "/sandboxes/sbx-a/execs/e1/cancel", nil)
+	if ctx.Err() == nil {
+		t.Fatal("exec context not canceled")
+	}
+}
+
+// TestTerminateScriptKillsProcessGroup runs the terminate script against a local
+// process group whose leader ignores SIGTERM, so only the SIGKILL fallback stops it.
+func TestTerminateScriptKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	proc := exec.Command("sh", "-c", "trap '' TERM; sleep 30 & wait")
+	proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := proc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- proc.Wait() }()
+	time.Sleep(100 * time.Millisecond)
+
+	script := fmt.Sprintf(terminateScript, proc.Process.Pid, 2)
+	if out, err := exec.Command("sh", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("terminate script: %v %s", err, out)
+	}
+	select {
+	case err := <-done:
+		status, ok := proc.ProcessState.Sys().(syscall.WaitStatus)
+		if !ok || !status.Signaled() || status.Signal() != syscall.SIGKILL {
+			t.Fatalf("process exited with %v, want killed by SIGKILL", err)
+		}
+	case <-time.After(5 * time.Second):
+		proc.Process.Kill()
+		t.Fatal("process still running after terminate script")
+	}
+}
+
+func TestWrapCommandWritesPIDFile(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	dir := t.TempDir()
+	cmd := wrapCommandForSidecar("e1", []string{"sh", "-c", "echo $$"}, dir)
+	// Run the script without -l so the test doesn't depend on login profiles.
+	if out, err := exec.Command("bash", "-c", cmd[2]).CombinedOutput(); err != nil {
+		t.Fatalf("wrapped command: %v %s", err, out)
+	}
+	pidFile, err := os.ReadFile(execPIDPath(dir, "e1"))
+	if err != nil {
+		t.Fatalf("pid file not written: %v", err)
+	}
+	stdout, err := os.ReadFile(filepath.Join(dir, "e1.stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(pidFile)), strings.TrimSpace(string(stdout)); got != want {
+		t.Errorf("pid file = %q, want the command's own PID %q", got, want)
+	}
+}
+
+func TestTrackExecPIDReadsPIDFile(t *testing.T) {
+	s := newTestServer()
+	s.execs.createRunning("sbx-a", "e1", time.Now(), nil, func() {})
+	pidPath := execPIDPath("/sbx-events", "e1")
+	var reads int
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+		if len(cmd) != 2 || cmd[0] != "cat" || cmd[1] != pidPath {
+			t.Errorf("cmd = %v, want cat %s", cmd, pidPath)
+		}
+		reads++
+		if reads == 1 {
+			return errors.New("no such file")
+		}
+		io.WriteString(opts.Stdout, "4242\n")
+		return nil
+	}
+
+	s.trackExecPID("sbx-a", "e1", pidPath)
+	status, _ := s.execs.get("sbx-a", "e1")
+	if status.PID != 4242 {
+		t.Fatalf("status pid = %d, want 4242 once the pid file appears", status.PID)
+	}
+	if reads != 2 {
+		t.Errorf("pid file read %d times, want 2", reads)
+	}
+}
+
+func TestSignalExec(t *testing.T) {
+	s := newTestServer()
+	s.execs.createRunning("sbx-a", "e1", time.Now(), nil, func() {})
+	s.execs.createRunning("sbx-a", "nopid", time.Now(), nil, func() {})
+	s.execs.setPID("sbx-a", "e1", 42)
+	var scripts []string
+	s.podExec = func(_ context.Context, ns, pod, container string, cmd []string, _ remotecommand.StreamOptions) error {
+		scripts = append(scripts, cmd[len(cmd)-1])
+		return nil
+	}
+	const route = "/sandboxes/:id/execs/:exec_id/signal"
+
+	for _, tc := range []struct{ signal, want string }{
+		{"SIGINT", "kill -INT -- -42"},
+		{"hup", "kill -HUP -- -42"},
+	} {
+		scripts = nil
+		w := serve(s.signalExec, "POST", route, "/sandboxes/sbx-a/execs/e1/signal", api.SignalRequest{Signal: tc.signal})
+		if w.Code != 200 {
+			t.Fatalf("%s: status %d %s", tc.signal, w.Code, w.Body.String())
+		}
+		if len(scripts) != 1 || !strings.HasPrefix(scripts[0], tc.want) {
+			t.Errorf("%s: ran %q, want %q", tc.signal, scripts, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		exec, signal string
+		status       int
+		code         string
+	}{
+		{"e1", "SIGSEGV", 400, errCodeInvalidRequest},
+		{"missing", "SIGINT", 404, errCodeExecNotFound},
+		{"nopid", "SIGINT", 409, errCodeExecPIDUnknown},
+	} {
+		scripts = nil
+		w := serve(s.signalExec, "POST", route, "/sandboxes/sbx-a/execs/"+tc.exec+"/signal", api.SignalRequest{Signal: tc.signal})
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.code) {
+			t.Errorf("%s %s: %d %s, want %d %s", tc.exec, tc.signal, w.Code, w.Body.String(), tc.status, tc.code)
+		}
+		if len(scripts) != 0 {
+			t.Errorf("%s %s: exec ran %q", tc.exec, tc.signal, scripts)
+		}
+	}
+}
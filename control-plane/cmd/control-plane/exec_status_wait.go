@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultExecStatusWait = 30 * time.Second
+	maxExecStatusWait     = 5 * time.Minute
+)
+
+// parseExecStatusWait reads ?wait=true and ?timeout= from an exec-status request.
+// It returns 0 when the request doesn't want to wait.
+func parseExecStatusWait(c *gin.Context) (time.Duration, error) {
+	if c.Query("wait") != "true" {
+		return 0, nil
+	}
+	timeout := defaultExecStatusWait
+	if v := c.Query("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("timeout: %v", err)
+		}
+		if d <= 0 || d > maxExecStatusWait {
+			return 0, fmt.Errorf("timeout must be > 0 and at most %s", maxExecStatusWait)
+		}
+		timeout = d
+	}
+	return timeout, nil
+}
+
+// waitExecDone blocks until the exec finishes, timeout elapses or ctx is done.
+// Execs the registry doesn't track, such as ones recovered from the pod after a
+// restart, return at once.
+func (s *server) waitExecDone(ctx context.Context, ns, execID string, timeout time.Duration) {
+	done, ok := s.execs.doneChan(ns, execID)
+	if !ok {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+)
+
+func TestExecStatusWaitReturnsWhenExecFinishes(t *testing.T) {
+	s := newTestServer()
+	s.execs.createRunning("sbx-a", "e1", time.Now(), nil, func() {})
+	status := func(query string) (*httptest.ResponseRecorder, api.ExecStatusResponse) {
+		w := serve(s.getExecStatus, http.MethodGet, "/sandboxes/:id/execs/:exec_id", "/sandboxes/sbx-a/execs/e1"+query, nil)
+		var resp api.ExecStatusResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	type result struct {
+		resp    api.ExecStatusResponse
+		elapsed time.Duration
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		_, resp := status("?wait=true&timeout=30s")
+		done <- result{resp, time.Since(start)}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case r := <-done:
+		t.Fatalf("returned %+v before the exec finished", r.resp)
+	default:
+	}
+	s.execs.finish("sbx-a", "e1", nil)
+	select {
+	case r := <-done:
+		if r.resp.Status != execStatusCompleted {
+			t.Errorf("status = %q, want completed", r.resp.Status)
+		}
+		if r.elapsed > 5*time.Second {
+			t.Errorf("returned after %s, want promptly after the exec finished", r.elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not return after the exec finished")
+	}
+
+	// A finished exec answers at once.
+	start = time.Now()
+	if _, resp := status("?wait=true&timeout=30s"); resp.Status != execStatusCompleted || time.Since(start) > time.Second {
+		t.Errorf("finished exec: status %q after %s, want completed at once", resp.Status, time.Since(start))
+	}
+}
+
+func TestExecStatusWaitTimesOut(t *testing.T) {
+	s := newTestServer()
+	s.execs.createRunning("sbx-a", "e1", time.Now(), nil, func() {})
+	w := serve(s.getExecStatus, http.MethodGet, "/sandboxes/:id/execs/:exec_id", "/sandboxes/sbx-a/execs/e1?wait=true&timeout=50ms", nil)
+	var resp api.ExecStatusResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 200 || resp.Status != execStatusRunning {
+		t.Fatalf("status %d %s, want 200 with the exec still running", w.Code, w.Body)
+	}
+	for _, q := range []string{"?wait=true&timeout=0s", "?wait=true&timeout=10m", "?wait=true&timeout=soon"} {
+		if w := serve(s.getExecStatus, http.MethodGet, "/sandboxes/:id/execs/:exec_id", "/sandboxes/sbx-a/execs/e1"+q, nil); w.Code != 400 {
+			t.Errorf("%s: status %d, want 400", q, w.Code)
+		}
+	}
+}
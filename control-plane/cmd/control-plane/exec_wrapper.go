@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// validateExecWrapper checks a SANDBOX_EXEC_WRAPPER template. An empty template
+// disables wrapping.
+func validateExecWrapper(tmpl string) error {
+	if tmpl != "" && !strings.Contains(tmpl, "{cmd}") {
+		return fmt.Errorf("SANDBOX_EXEC_WRAPPER must contain {cmd}")
+	}
+	return nil
+}
+
+// wrapExecCommand applies SANDBOX_EXEC_WRAPPER to a user exec. The template is split
+// on whitespace into argv. A bare {cmd} word expands to the command's own argv, so
+// no quoting is involved; {cmd} inside a larger word (e.g. within a sh -c script) is
+// replaced by the shell-quoted command. {timeout} is the exec timeout in seconds, or
+// 0 when there is none, which `timeout` treats as no limit.
+func wrapExecCommand(cmd []string, timeoutSeconds *int) []string {
+	tmpl := getenv("SANDBOX_EXEC_WRAPPER", "")
+	if tmpl == "" || validateExecWrapper(tmpl) != nil {
+		return cmd
+	}
+	timeout := "0"
+	if timeoutSeconds != nil {
+		timeout = strconv.Itoa(*timeoutSeconds)
+	}
+	var out []string
+	for _, word := range strings.Fields(tmpl) {
+		if word == "{cmd}" {
+			out = append(out, cmd...)
+			continue
+		}
+		word = strings.ReplaceAll(word, "{timeout}", timeout)
+		word = strings.ReplaceAll(word, "{cmd}", shellJoin(cmd))
+		out = append(out, word)
+	}
+	return out
+}
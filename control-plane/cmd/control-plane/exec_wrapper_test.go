@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestWrapExecCommand(t *testing.T) {
+	timeout := 30
+	cmd := []string{"echo", "it's", "two words"}
+	tests := []struct {
+		tmpl    string
+		timeout *int
+		want    []string
+	}{
+		{tmpl: "", want: cmd},
+		{tmpl: "timeout {timeout}s {cmd}", timeout: &timeout, want: []string{"timeout", "30s", "echo", "it's", "two words"}},
+		{tmpl: "timeout {timeout}s {cmd}", want: []string{"timeout", "0s", "echo", "it's", "two words"}},
+		{tmpl: "nice -n 5 {cmd}", want: []string{"nice", "-n", "5", "echo", "it's", "two words"}},
+	}
+	for _, tt := range tests {
+		t.Setenv("SANDBOX_EXEC_WRAPPER", tt.tmpl)
+		if got := wrapExecCommand(cmd, tt.timeout); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+	if err := validateExecWrapper("timeout 10s"); err == nil {
+		t.Error("template without {cmd} accepted")
+	}
+}
+
+func TestWrappedCommandRuns(t *testing.T) {
+	// {cmd} inside a sh -c word is shell-quoted, so the arguments survive intact.
+	t.Setenv("SANDBOX_EXEC_WRAPPER", "sh -c {cmd};exit")
+	argv := wrapExecCommand([]string{"printf", "%s|", "it's", "two words"}, nil)
+	if len(argv) != 3 {
+		t.Fatalf("argv = %q, want sh -c <script>", argv)
+	}
+	out, err := exec.Command(argv[0], argv[1:]...).Output()
+	if err != nil {
+		t.Fatalf("wrapped command %q: %v", argv, err)
+	}
+	if got := string(out); got != "it's|two words|" {
+		t.Fatalf("output = %q, want the original arguments", got)
+	}
+}
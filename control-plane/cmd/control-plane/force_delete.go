@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultForceDeleteWait = 20 * time.Second
+
+// forceDeleteSandbox handles DELETE /sandboxes/:id?force=true. It deletes the
+// namespace as usual and, if it is still terminating after
+// SANDBOX_FORCE_DELETE_WAIT, clears spec.finalizers through the finalize
+// subresource so Kubernetes drops it. Anything the namespace controller hadn't
+// cleaned up yet is left behind, hence the admin token.
+func (s *server) forceDeleteSandbox(c *gin.Context, ns string) {
+	if !checkAdmin(c) {
+		return
+	}
+	if !strings.HasPrefix(ns, "sbx-") {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "force delete only applies to sandbox namespaces")
+		return
+	}
+	wait := getenvDuration("SANDBOX_FORCE_DELETE_WAIT", defaultForceDeleteWait)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), wait+20*time.Second)
+	defer cancel()
+	namespaces := s.client.CoreV1().Namespaces()
+	// Deleting a namespace that is already terminating is not an error here; that
+	// is the case force is for.
+	if err := namespaces.Delete(ctx, ns, metav1.DeleteOptions{}); err != nil && !apierrors.IsConflict(err) {
+		if apierrors.IsNotFound(err) {
+			writeErrorCode(c, 404, errCodeSandboxNotFound, err.Error())
+			return
+		}
+		writeError(c, 500, err.Error())
+		return
+	}
+	metricDeletes.Add(1)
+	s.execs.cancelQueued(ns)
+	err := s.waitNamespaceGoneFor(ctx, ns, wait)
+	if err == nil {
+		writeJSON(c, 200, map[string]string{"status": "deleted"})
+		return
+	}
+	if !errors.Is(err, errNamespaceTerminating) {
+		writeError(c, 500, err.Error())
+		return
+	}
+	n, err := namespaces.Get(ctx, ns, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		writeJSON(c, 200, map[string]string{"status": "deleted"})
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	log.Printf("WARNING: force delete: namespace %s still terminating after %s; removing finalizers %v (requested by %s)",
+		ns, wait, n.Spec.Finalizers, c.ClientIP())
+	n.Spec.Finalizers = nil
+	if _, err := namespaces.Finalize(ctx, n, metav1.UpdateOptions{}); err != nil {
+		writeError(c, 500, "remove finalizers: "+err.Error())
+		return
+	}
+	writeJSON(c, 200, map[string]string{"status": "deleted", "forced": "true"})
+}
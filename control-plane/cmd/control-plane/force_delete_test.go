@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestForceDeleteRemovesFinalizers(t *testing.T) {
+	t.Setenv("SANDBOX_ADMIN_TOKEN", "hunter2")
+	t.Setenv("SANDBOX_FORCE_DELETE_WAIT", "100ms")
+	s := newTestServer(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "sbx-stuck"},
+		Spec:       corev1.NamespaceSpec{Finalizers: []corev1.FinalizerName{corev1.FinalizerKubernetes}},
+	})
+	markTerminating(t, s, "sbx-stuck")
+	client := s.client.(*fake.Clientset)
+	// The namespace controller never finishes, so deletes leave it terminating.
+	client.PrependReactor("delete", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	var finalized *corev1.Namespace
+	// The fake clientset records finalize as a create on the subresource.
+	client.PrependReactor("*", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "finalize" {
+			return false, nil, nil
+		}
+		finalized = action.(k8stesting.CreateAction).GetObject().(*corev1.Namespace)
+		return true, finalized, nil
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.DELETE("/sandboxes/:id", s.deleteSandbox)
+	del := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := del("/sandboxes/sbx-stuck?force=true", "wrong"); w.Code != 401 {
+		t.Fatalf("force without the admin token: status %d, want 401", w.Code)
+	}
+	if finalized != nil {
+		t.Fatal("finalizers removed without the admin token")
+	}
+	w := del("/sandboxes/sbx-stuck?force=true", "hunter2")
+	if w.Code != 200 {
+		t.Fatalf("force delete: status %d: %s", w.Code, w.Body)
+	}
+	if finalized == nil {
+		t.Fatal("finalize was not called on the stuck namespace")
+	}
+	if len(finalized.Spec.Finalizers) != 0 {
+		t.Errorf("finalizers = %v, want none", finalized.Spec.Finalizers)
+	}
+}
+
+func TestForceDeleteSkipsFinalizeWhenNamespaceGoes(t *testing.T) {
+	t.Setenv("SANDBOX_ADMIN_TOKEN", "hunter2")
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-ok"}})
+	finalized := false
+	s.client.(*fake.Clientset).PrependReactor("*", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		finalized = finalized || action.GetSubresource() == "finalize"
+		return false, nil, nil
+	})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.DELETE("/sandboxes/:id", s.deleteSandbox)
+	req := httptest.NewRequest(http.MethodDelete, "/sandboxes/sbx-ok?force=true", nil)
+	req.Header.Set("Authorization", "Bearer hunter2")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("force delete: status %d: %s", w.Code, w.Body)
+	}
+	if finalized {
+		t.Error("finalizers removed from a namespace that terminated normally")
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-ok", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("namespace still there: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"sandbox/pkg/api"
+)
+
+const (
+	defaultGitImage = "alpine/git:2.45.2"
+	// gitCloneAuthExitCode is how the clone script reports that the remote refused
+	// the credentials, as opposed to any other failure; it matches exit 3 in
+	// gitCloneScript.
+	gitCloneAuthExitCode = 3
+)
+
+// gitCloneScript fetches GIT_REF (the remote HEAD by default) into the workspace
+// and checks it out. It fetches into a fresh repository rather than cloning so a
+// ref can be a commit as well as a branch or tag, and so a restarted init container
+// can start over in a workspace that already has a partial repository.
+// Credentials come from the environment through a helper, so they never land in
+// .git/config.
+const gitCloneScript = `set -u
+cd "$SBX_WORKSPACE"
+git init -q .
+git remote add origin "$GIT_URL" 2>/dev/null || git remote set-url origin "$GIT_URL"
+if [ -n "${GIT_PASSWORD:-}" ]; then
+  git config credential.helper '!f() { echo "username=${GIT_USERNAME:-x-access-token}"; echo "password=$GIT_PASSWORD"; }; f'
+fi
+depth=""
+if [ "$GIT_DEPTH" -gt 0 ]; then depth="--depth=$GIT_DEPTH"; fi
+if ! git fetch -q $depth origin "${GIT_REF:-HEAD}" 2>/tmp/git-fetch.err; then
+  cat /tmp/git-fetch.err >&2
+  if grep -qiE 'authentication failed|could not read username|terminal prompts disabled|returned error: 40[13]' /tmp/git-fetch.err; then
+    echo "authentication failed for $GIT_URL" >/dev/termination-log
+    exit 3
+  fi
+  tail -n 5 /tmp/git-fetch.err >/dev/termination-log
+  exit 1
+fi
+git config --unset credential.helper || true
+git checkout -q FETCH_HEAD
+`
+
+func validateGitRepo(repo *api.GitRepo) error {
+	if repo == nil {
+		return nil
+	}
+	if !strings.HasPrefix(repo.URL, "https://") && !strings.HasPrefix(repo.URL, "http://") {
+		return fmt.Errorf("git_repo url must be an http:// or https:// URL")
+	}
+	// The credential helper would hand the secret to a plain-text remote.
+	if repo.Secret != "" && !strings.HasPrefix(repo.URL, "https://") {
+		return fmt.Errorf("git_repo url must be https:// when a secret is set")
+	}
+	if strings.ContainsAny(repo.URL, " \t\r\n") || strings.ContainsAny(repo.Ref, " \t\r\n") {
+		return fmt.Errorf("git_repo url and ref must not contain whitespace")
+	}
+	if strings.HasPrefix(repo.Ref, "-") {
+		return fmt.Errorf("git_repo ref must not start with -")
+	}
+	if repo.Depth < 0 {
+		return fmt.Errorf("git_repo depth must not be negative")
+	}
+	return nil
+}
+
+// gitCloneContainer is the init container that clones repo into the workspace
+// mounted at workspacePath, or nil without a repo.
+func gitCloneContainer(repo *api.GitRepo, workspacePath string) *corev1.Container {
+	if repo == nil {
+		return nil
+	}
+	env := []corev1.EnvVar{
+		{Name: "SBX_WORKSPACE", Value: workspacePath},
+		{Name: "GIT_URL", Value: repo.URL},
+		{Name: "GIT_REF", Value: repo.Ref},
+		{Name: "GIT_DEPTH", Value: strconv.Itoa(repo.Depth)},
+		// Fail instead of waiting for a password nobody will type.
+		{Name: "GIT_TERMINAL_PROMPT", Value: "0"},
+		{Name: "HOME", Value: "/tmp"},
+	}
+	if repo.Secret != "" {
+		optional := true
+		env = append(env,
+			corev1.EnvVar{Name: "GIT_USERNAME", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: repo.Secret}, Key: "username", Optional: &optional,
+			}}},
+			corev1.EnvVar{Name: "GIT_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: repo.Secret}, Key: "password",
+			}}},
+		)
+	}
+	return &corev1.Container{
+		Name:         "git-clone",
+		Image:        getenv("SANDBOX_GIT_IMAGE", defaultGitImage),
+		Command:      []string{"sh", "-c", gitCloneScript},
+		Env:          env,
+		VolumeMounts: []corev1.VolumeMount{{Name: "workspace", MountPath: workspacePath}},
+	}
+}
+
+// gitCloneStatus reports how the git-clone init container is doing: cloning,
+// cloned, auth_failed or failed, with the failure message. It returns "" for a
+// pod without one.
+func gitCloneStatus(pod *corev1.Pod) (string, string) {
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if cs.Name != "git-clone" {
+			continue
+		}
+		term := cs.State.Terminated
+		if term == nil {
+			// Restarted after a failure; the last attempt says why.
+			term = cs.LastTerminationState.Terminated
+		}
+		switch {
+		case term == nil:
+			return "cloning", ""
+		case term.ExitCode == 0:
+			return "cloned", ""
+		case term.ExitCode == gitCloneAuthExitCode:
+			msg := strings.TrimSpace(term.Message)
+			if msg == "" {
+				msg = "authentication failed"
+			}
+			return "auth_failed", msg
+		default:
+			return "failed", strings.TrimSpace(term.Message)
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"sandbox/pkg/api"
+)
+
+func TestGitCloneInitContainer(t *testing.T) {
+	t.Setenv("SANDBOX_GIT_IMAGE", "git:test")
+	req := api.CreateSandboxRequest{
+		WorkspacePath: "/src",
+		GitRepo:       &api.GitRepo{URL: "https://example.com/org/repo.git", Ref: "v1.2.0", Depth: 1, Secret: "git-creds"},
+	}
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfig{mode: "emptydir"}, nil, podConfigFromRequest(req))
+	if len(spec.InitContainers) != 1 {
+		t.Fatalf("init containers = %d, want 1", len(spec.InitContainers))
+	}
+	ic := spec.InitContainers[0]
+	if ic.Name != "git-clone" || ic.Image != "git:test" {
+		t.Fatalf("init container %s image %s", ic.Name, ic.Image)
+	}
+	if !reflect.DeepEqual(ic.Command, []string{"sh", "-c", gitCloneScript}) {
+		t.Fatalf("command = %q", ic.Command)
+	}
+	if len(ic.VolumeMounts) != 1 || ic.VolumeMounts[0].Name != "workspace" || ic.VolumeMounts[0].MountPath != "/src" {
+		t.Fatalf("mounts = %+v", ic.VolumeMounts)
+	}
+	env := map[string]corev1.EnvVar{}
+	for _, e := range ic.Env {
+		env[e.Name] = e
+	}
+	for k, want := range map[string]string{"SBX_WORKSPACE": "/src", "GIT_URL": req.GitRepo.URL, "GIT_REF": "v1.2.0", "GIT_DEPTH": "1", "GIT_TERMINAL_PROMPT": "0"} {
+		if env[k].Value != want {
+			t.Errorf("%s = %q, want %q", k, env[k].Value, want)
+		}
+	}
+	if ref := env["GIT_PASSWORD"].ValueFrom; ref == nil || ref.SecretKeyRef.Name != "git-creds" || ref.SecretKeyRef.Key != "password" {
+		t.Fatalf("GIT_PASSWORD = %+v", env["GIT_PASSWORD"])
+	}
+	// The sandbox container sees the same workspace the clone wrote to.
+	for _, m := range spec.Containers[0].VolumeMounts {
+		if m.Name == "workspace" && m.MountPath != "/src" {
+			t.Fatalf("sandbox workspace mount = %s", m.MountPath)
+		}
+	}
+
+	if spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfig{mode: "emptydir"}, nil, podConfigFromRequest(api.CreateSandboxRequest{})); len(spec.InitContainers) != 0 {
+		t.Fatalf("init containers without git_repo = %d", len(spec.InitContainers))
+	}
+}
+
+func TestValidateGitRepo(t *testing.T) {
+	for _, repo := range []api.GitRepo{
+		{URL: "git@example.com:org/repo.git"},
+		{URL: "https://example.com/repo.git", Ref: "--upload-pack=x"},
+		{URL: "https://example.com/repo.git", Ref: "a b"},
+		{URL: "https://example.com/repo.git", Depth: -1},
+		{URL: "http://example.com/repo.git", Secret: "git-creds"},
+	} {
+		if err := validateGitRepo(&repo); err == nil {
+			t.Errorf("%+v: expected an error", repo)
+		}
+	}
+	for _, repo := range []api.GitRepo{
+		{URL: "https://example.com/repo.git", Ref: "main", Depth: 1},
+		{URL: "https://example.com/repo.git", Secret: "git-creds"},
+		{URL: "http://example.com/repo.git"},
+	} {
+		if err := validateGitRepo(&repo); err != nil {
+			t.Errorf("%+v: %v", repo, err)
+		}
+	}
+}
+
+func TestGitCloneAuthFailure(t *testing.T) {
+	pod := pendingPod("sbx-git")
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "git-clone",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			ExitCode: gitCloneAuthExitCode, Message: "authentication failed for https://example.com/repo.git\n",
+		}},
+	}}
+	if status, msg := gitCloneStatus(pod); status != "auth_failed" || msg != "authentication failed for https://example.com/repo.git" {
+		t.Fatalf("status %q message %q", status, msg)
+	}
+	done, err := podReadyState(pod)
+	if !done || err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("podReadyState = %t, %v", done, err)
+	}
+
+	s := newTestServer(pod)
+	rec := serve(s.getSandbox, "GET", "/sandboxes/:id", "/sandboxes/sbx-git", nil)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"git_clone":"auth_failed"`) {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+}
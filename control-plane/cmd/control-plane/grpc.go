@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"sandbox/pkg/api"
+	"sandbox/pkg/sandboxpb"
+)
+
+// grpcServer serves the sandboxpb API. Create, Delete and Status replay the call
+// through the HTTP handlers batch ops use, so validation, limits and the audit log
+// behave the same. Exec talks to the pod directly so stdin can stream in and
+// output streams out under gRPC flow control: a client that stops reading slows
+// the command down instead of the control plane buffering its output.
+type grpcServer struct {
+	sandboxpb.UnimplementedSandboxServer
+	s *server
+}
+
+// serveGRPC serves the gRPC API on addr, with the same TLS settings as the HTTP
+// server, until it fails or ctx is done. It then stops taking calls and gives the
+// ones in flight up to shutdownTimeout to finish.
+func (s *server) serveGRPC(ctx context.Context, addr string) error {
+	tlsCfg, err := serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	var opts []grpc.ServerOption
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(opts...)
+	sandboxpb.RegisterSandboxServer(srv, &grpcServer{s: s})
+	log.Printf("grpc listening on %s tls=%t", addr, tlsCfg != nil)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		graceful := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(graceful)
+		}()
+		select {
+		case <-graceful:
+		case <-time.After(shutdownTimeout):
+			// Long execs would hold GracefulStop forever.
+			srv.Stop()
+		}
+	}()
+	if err := srv.Serve(lis); err != nil {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+func (g *grpcServer) Create(ctx context.Context, req *sandboxpb.CreateRequest) (*sandboxpb.CreateResponse, error) {
+	var create api.CreateSandboxRequest
+	if req.OptionsJson != "" {
+		if err := json.Unmarshal([]byte(req.OptionsJson), &create); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "options_json: %v", err)
+		}
+	}
+	if req.Id != "" {
+		create.ID = req.Id
+	}
+	if req.Image != "" {
+		create.Image = req.Image
+	}
+	if len(req.Command) > 0 {
+		create.Command = req.Command
+	}
+	for k, v := range req.Env {
+		if create.Env == nil {
+			create.Env = map[string]string{}
+		}
+		create.Env[k] = v
+	}
+	body, _ := json.Marshal(create)
+	var resp api.CreateSandboxResponse
+	if err := g.s.grpcCall(ctx, http.MethodPost, "/sandboxes", body, &resp); err != nil {
+		return nil, err
+	}
+	return &sandboxpb.CreateResponse{Id: resp.ID, Namespace: resp.Namespace, PodName: resp.PodName, Existing: resp.Existing}, nil
+}
+
+func (g *grpcServer) Delete(ctx context.Context, req *sandboxpb.DeleteRequest) (*sandboxpb.DeleteResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if err := g.s.grpcCall(ctx, http.MethodDelete, "/sandboxes/"+url.PathEscape(req.Id), nil, nil); err != nil {
+		return nil, err
+	}
+	return &sandboxpb.DeleteResponse{}, nil
+}
+
+func (g *grpcServer) Status(ctx context.Context, req *sandboxpb.StatusRequest) (*sandboxpb.StatusResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	var raw json.RawMessage
+	if err := g.s.grpcCall(ctx, http.MethodGet, "/sandboxes/"+url.PathEscape(req.Id), nil, &raw); err != nil {
+		return nil, err
+	}
+	var st struct {
+		ID        string `json:"id"`
+		Namespace string `json:"namespace"`
+		PodName   string `json:"pod_name"`
+		Phase     string `json:"phase"`
+		Ready     string `json:"ready"`
+	}
+	_ = json.Unmarshal(raw, &st)
+	return &sandboxpb.StatusResponse{
+		Id:         st.ID,
+		Namespace:  st.Namespace,
+		PodName:    st.PodName,
+		Phase:      st.Phase,
+		Ready:      st.Ready == "true",
+		StatusJson: string(raw),
+	}, nil
+}
+
+// grpcCall replays a unary call as a request to its HTTP endpoint, carrying the
+// caller's Authorization and X-Request-Id metadata and its peer, and decodes a 200
+// response into out. Other statuses become the matching gRPC error.
+func (s *server) grpcCall(ctx context.Context, method, path string, body []byte, out any) error {
+	s.batchOnce.Do(func() { s.batchRoutes = s.newBatchRoutes() })
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range []string{"authorization", "x-request-id"} {
+		if v := md.Get(key); len(v) > 0 {
+			req.Header.Set(key, v[0])
+		}
+	}
+	req.RemoteAddr, req.TLS = grpcPeer(ctx)
+	rec := httptest.NewRecorder()
+	s.batchRoutes.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &e)
+		if e.Error == "" {
+			e.Error = http.StatusText(rec.Code)
+		}
+		return status.Error(grpcCode(rec.Code), e.Error)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// grpcPeer returns the caller's address and, over TLS, its connection state.
+func grpcPeer(ctx context.Context) (string, *tls.ConnectionState) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		return p.Addr.String(), &info.State
+	}
+	return p.Addr.String(), nil
+}
+
+// httpStatus is the HTTP status grpcCode maps to code.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument:
+		return 400
+	case codes.Unauthenticated:
+		return 401
+	case codes.PermissionDenied:
+		return 403
+	case codes.NotFound:
+		return 404
+	case codes.FailedPrecondition:
+		return 409
+	case codes.ResourceExhausted:
+		return 429
+	case codes.Unimplemented:
+		return 501
+	case codes.Unavailable:
+		return 503
+	case codes.DeadlineExceeded:
+		return 504
+	default:
+		return 500
+	}
+}
+
+// grpcCode maps an HTTP status from the handlers to a gRPC code.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case 400, 422:
+		return codes.InvalidArgument
+	case 401:
+		return codes.Unauthenticated
+	case 403:
+		return codes.PermissionDenied
+	case 404:
+		return codes.NotFound
+	case 409:
+		return codes.FailedPrecondition
+	case 429:
+		return codes.ResourceExhausted
+	case 501:
+		return codes.Unimplemented
+	case 503:
+		return codes.Unavailable
+	case 504:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
+func (g *grpcServer) Exec(stream sandboxpb.Sandbox_ExecServer) (err error) {
+	s := g.s
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := first.GetStart()
+	// Every call that asked for something is audited, refused or not. A refusal
+	// is audited with its error, a command that ran with how it ended.
+	var execErr error
+	defer func() {
+		if err != nil {
+			execErr = err
+		}
+		s.auditGRPCExec(ctx, start.GetSandboxId(), start.GetCommand(), execErr)
+	}()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be a start")
+	}
+	req := api.ExecRequest{Command: start.Command}
+	command, err := execCommandFromRequest(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if start.SandboxId == "" {
+		return status.Error(codes.InvalidArgument, "sandbox_id is required")
+	}
+	var requested *int
+	if start.TimeoutSeconds > 0 {
+		secs := int(start.TimeoutSeconds)
+		requested = &secs
+	}
+	timeoutSeconds, err := resolveExecTimeoutSeconds(requested)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkExecAllowlist(req, command); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	ns, podName := start.SandboxId, "sandbox"
+	readyCtx, cancel := context.WithTimeout(ctx, defaultWaitReady)
+	err = s.waitForPodReady(readyCtx, ns, podName)
+	cancel()
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, "sandbox not ready: "+err.Error())
+	}
+	release, ok := s.execSlots.tryAcquire(ns)
+	if !ok {
+		return status.Error(codes.ResourceExhausted, "the sandbox is running its limit of execs (SANDBOX_MAX_CONCURRENT_EXECS)")
+	}
+	defer release()
+	command = syncExecCommand(wrapExecCommand(command, timeoutSeconds))
+
+	execCtx := ctx
+	execCancel := func() {}
+	if timeoutSeconds != nil {
+		execCtx, execCancel = context.WithTimeout(ctx, time.Duration(*timeoutSeconds)*time.Second)
+	}
+	defer execCancel()
+	var sendMu sync.Mutex
+	send := func(out *sandboxpb.ExecOutput) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(out)
+	}
+	opts := remotecommand.StreamOptions{
+		Stdout: grpcExecWriter(func(p []byte) error {
+			return send(&sandboxpb.ExecOutput{Output: &sandboxpb.ExecOutput_Stdout{Stdout: p}})
+		}),
+		Stderr: grpcExecWriter(func(p []byte) error {
+			return send(&sandboxpb.ExecOutput{Output: &sandboxpb.ExecOutput_Stderr{Stderr: p}})
+		}),
+	}
+	var pw *io.PipeWriter
+	if start.Stdin {
+		pr, w := io.Pipe()
+		defer pr.Close()
+		opts.Stdin, pw = pr, w
+	}
+	var sizes *terminalSizeQueue
+	if start.Tty {
+		// A terminal has one output stream. Its output, resizes and input are
+		// published like an exec's so the session can be recorded.
+		sizes = &terminalSizeQueue{ch: make(chan remotecommand.TerminalSize, 8)}
+		opts.Tty, opts.Stderr, opts.TerminalSizeQueue = true, nil, sizes
+		execID := generateExecID()
+		opts.Stdout = io.MultiWriter(opts.Stdout, &streamEventWriter{server: s, sandboxID: ns, execID: execID, stream: "stdout"})
+		opts = s.recordSession(ns, execID, opts)
+	}
+	if start.Stdin || start.Tty {
+		go pumpExecInput(stream, pw, sizes)
+	}
+	execErr = s.streamPodExec(execCtx, ns, podName, "sandbox", command, opts)
+	_ = s.updateLastExec(context.Background(), ns)
+	metricExecs.Add(1)
+
+	exit := &sandboxpb.ExecExit{}
+	if code, ok := exitCodeFromErr(execErr); ok {
+		exit.Code = int32(code)
+	} else if execErr != nil {
+		exit.Code = -1
+		exit.Error = execErr.Error()
+		if errors.Is(execCtx.Err(), context.DeadlineExceeded) {
+			exit.Error = "exec timed out: " + execErr.Error()
+		}
+	}
+	return send(&sandboxpb.ExecOutput{Output: &sandboxpb.ExecOutput_Exit{Exit: exit}})
+}
+
+// grpcExecWriter sends each write as one output message. Send returns once the
+// message is queued within the stream's flow-control window, so a slow reader
+// holds up the exec rather than filling memory.
+type grpcExecWriter func([]byte) error
+
+func (w grpcExecWriter) Write(p []byte) (int, error) {
+	if err := w(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// pumpExecInput copies stdin messages from the stream into pw, when the exec has
+// stdin, and resize messages into sizes, when it has a tty, until the client
+// closes both or its side of the stream.
+func pumpExecInput(stream sandboxpb.Sandbox_ExecServer, pw *io.PipeWriter, sizes *terminalSizeQueue) {
+	if sizes != nil {
+		defer close(sizes.ch)
+	}
+	closeStdin := func(err error) {
+		if pw != nil {
+			pw.CloseWithError(err)
+			pw = nil
+		}
+	}
+	for pw != nil || sizes != nil {
+		in, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			closeStdin(err)
+			return
+		}
+		switch v := in.Input.(type) {
+		case *sandboxpb.ExecInput_Stdin:
+			if pw != nil {
+				if _, err := pw.Write(v.Stdin); err != nil {
+					pw = nil
+				}
+			}
+		case *sandboxpb.ExecInput_CloseStdin:
+			if v.CloseStdin {
+				closeStdin(nil)
+			}
+		case *sandboxpb.ExecInput_Resize:
+			if sizes != nil {
+				select {
+				case sizes.ch <- remotecommand.TerminalSize{Width: uint16(v.Resize.Cols), Height: uint16(v.Resize.Rows)}:
+				case <-stream.Context().Done():
+					closeStdin(nil)
+					return
+				}
+			}
+		}
+	}
+}
+
+// terminalSizeQueue hands a tty exec the sizes from resize messages.
+type terminalSizeQueue struct {
+	ch chan remotecommand.TerminalSize
+}
+
+func (q *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q.ch
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// auditGRPCExec records an Exec call like the audit middleware records HTTP execs.
+func (s *server) auditGRPCExec(ctx context.Context, id string, command []string, execErr error) {
+	if s.audit == nil {
+		return
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	rec := auditRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID: first("x-request-id"),
+		Action:    "exec",
+		SandboxID: id,
+		Command:   redactEnvAssignments(command),
+		Status:    200,
+		Result:    "ok",
+	}
+	remote, tlsState := grpcPeer(ctx)
+	rec.Remote = remote
+	rec.Principal = principal(tlsState, first("authorization"))
+	if s.audit.redactCommand {
+		rec.Command = []string{redacted}
+	}
+	if code, ok := exitCodeFromErr(execErr); ok {
+		// The command ran and exited nonzero, which the HTTP endpoint answers
+		// with 200 and the exit code.
+		rec.ExitCode = intPtr(code)
+	} else if execErr != nil {
+		rec.Status, rec.Result, rec.Error = 500, "error", execErr.Error()
+		if st, ok := status.FromError(execErr); ok && st.Code() != codes.Unknown {
+			// Refused before it ran; recorded as the HTTP endpoint would have answered.
+			rec.Status, rec.Error = httpStatus(st.Code()), st.Message()
+		}
+	}
+	s.audit.write(rec)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+	utilsexec "k8s.io/client-go/util/exec"
+
+	"sandbox/pkg/sandboxpb"
+)
+
+// newGRPCClient serves s's gRPC API over an in-memory listener.
+func newGRPCClient(t *testing.T, s *server) sandboxpb.SandboxClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	sandboxpb.RegisterSandboxServer(srv, &grpcServer{s: s})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return sandboxpb.NewSandboxClient(conn)
+}
+
+func TestGRPCCreateStatusDelete(t *testing.T) {
+	s := newTestServer(readyPod("sbx-ready"))
+	client := newGRPCClient(t, s)
+	ctx := context.Background()
+
+	created, err := client.Create(ctx, &sandboxpb.CreateRequest{Id: "grpc", Env: map[string]string{"A": "1"}, OptionsJson: `{"idle_ttl": "1h"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id != "sbx-grpc" || created.PodName != "sandbox" {
+		t.Fatalf("create = %+v", created)
+	}
+	ns, err := s.client.CoreV1().Namespaces().Get(ctx, "sbx-grpc", metav1.GetOptions{})
+	if err != nil || ns.Annotations["sbx.idle_ttl"] != "1h0m0s" {
+		t.Fatalf("namespace %v: %v", ns, err)
+	}
+	if _, err := client.Create(ctx, &sandboxpb.CreateRequest{Id: "Not Valid"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid id: %v", err)
+	}
+
+	st, err := client.Status(ctx, &sandboxpb.StatusRequest{Id: "sbx-ready"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Id != "sbx-ready" || !st.Ready || st.Phase != "Running" || !strings.Contains(st.StatusJson, `"ready":"true"`) {
+		t.Fatalf("status = %+v", st)
+	}
+	if _, err := client.Status(ctx, &sandboxpb.StatusRequest{Id: "sbx-missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("missing sandbox: %v", err)
+	}
+
+	if _, err := client.Delete(ctx, &sandboxpb.DeleteRequest{Id: "sbx-grpc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(ctx, "sbx-grpc", metav1.GetOptions{}); err == nil {
+		t.Fatal("delete left the namespace")
+	}
+}
+
+func TestGRPCExecStreams(t *testing.T) {
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = func(_ context.Context, ns, pod, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+		if ns != "sbx-a" || pod != "sandbox" || cmd[0] != "tr" {
+			return errors.New("unexpected exec")
+		}
+		// Upper-cases stdin like tr a-z A-Z, then fails.
+		in, _ := io.ReadAll(opts.Stdin)
+		_, _ = io.WriteString(opts.Stdout, strings.ToUpper(string(in)))
+		_, _ = io.WriteString(opts.Stderr, "done\n")
+		return utilsexec.CodeExitError{Err: errors.New("exit 3"), Code: 3}
+	}
+	client := newGRPCClient(t, s)
+	stream, err := client.Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []*sandboxpb.ExecInput{
+		{Input: &sandboxpb.ExecInput_Start{Start: &sandboxpb.ExecStart{SandboxId: "sbx-a", Command: []string{"tr", "a-z", "A-Z"}, Stdin: true}}},
+		{Input: &sandboxpb.ExecInput_Stdin{Stdin: []byte("hello ")}},
+		{Input: &sandboxpb.ExecInput_Stdin{Stdin: []byte("grpc\n")}},
+		{Input: &sandboxpb.ExecInput_CloseStdin{CloseStdin: true}},
+	} {
+		if err := stream.Send(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stdout, stderr strings.Builder
+	var exit *sandboxpb.ExecExit
+	for exit == nil {
+		out, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch v := out.Output.(type) {
+		case *sandboxpb.ExecOutput_Stdout:
+			stdout.Write(v.Stdout)
+		case *sandboxpb.ExecOutput_Stderr:
+			stderr.Write(v.Stderr)
+		case *sandboxpb.ExecOutput_Exit:
+			exit = v.Exit
+		}
+	}
+	if stdout.String() != "HELLO GRPC\n" || stderr.String() != "done\n" || exit.Code != 3 || exit.Error != "" {
+		t.Fatalf("stdout %q stderr %q exit %+v", stdout.String(), stderr.String(), exit)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("stream after exit: %v", err)
+	}
+
+	// The first message has to say what to run.
+	stream, _ = client.Exec(context.Background())
+	_ = stream.Send(&sandboxpb.ExecInput{Input: &sandboxpb.ExecInput_Stdin{Stdin: []byte("x")}})
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("exec without start: %v", err)
+	}
+}
+
+func TestGRPCExecTTYRecordsSession(t *testing.T) {
+	t.Setenv("SANDBOX_STREAM_RECORD_STDIN", "true")
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = func(_ context.Context, _, _, _ string, _ []string, opts remotecommand.StreamOptions) error {
+		if !opts.Tty || opts.Stderr != nil || opts.TerminalSizeQueue == nil {
+			return errors.New("want a tty exec with one output stream")
+		}
+		if size := opts.TerminalSizeQueue.Next(); size == nil || size.Width != 120 || size.Height != 40 {
+			return errors.New("want a 40x120 terminal")
+		}
+		in, _ := io.ReadAll(opts.Stdin)
+		_, _ = io.WriteString(opts.Stdout, "$ "+string(in))
+		return nil
+	}
+	sub, _ := s.stream.subscribe("sbx-a")
+	defer s.stream.unsubscribe("sbx-a", sub)
+	client := newGRPCClient(t, s)
+	stream, err := client.Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []*sandboxpb.ExecInput{
+		{Input: &sandboxpb.ExecInput_Start{Start: &sandboxpb.ExecStart{SandboxId: "sbx-a", Command: []string{"bash"}, Stdin: true, Tty: true}}},
+		{Input: &sandboxpb.ExecInput_Resize{Resize: &sandboxpb.TerminalSize{Rows: 40, Cols: 120}}},
+		{Input: &sandboxpb.ExecInput_Stdin{Stdin: []byte("l")}},
+		{Input: &sandboxpb.ExecInput_Stdin{Stdin: []byte("s\r")}},
+	} {
+		if err := stream.Send(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var stdout strings.Builder
+	for {
+		out, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := out.Output.(*sandboxpb.ExecOutput_Stdout); ok {
+			stdout.Write(v.Stdout)
+		}
+		if exit := out.GetExit(); exit != nil {
+			if exit.Code != 0 || exit.Error != "" {
+				t.Fatalf("exit %+v", exit)
+			}
+			break
+		}
+	}
+	if stdout.String() != "$ ls\r" {
+		t.Fatalf("stdout %q", stdout.String())
+	}
+
+	got := map[string]string{}
+	for len(got) < 3 {
+		select {
+		case evt := <-sub.ch:
+			switch evt.Type {
+			case "resize":
+				got["resize"] = fmt.Sprintf("%dx%d", evt.Rows, evt.Cols)
+			case "stdin", "output":
+				got[evt.Type] += evt.Data
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events = %v, want resize, stdin and output", got)
+		}
+	}
+	if got["resize"] != "40x120" || got["stdin"] != "ls\r" || got["output"] != "$ ls\r" {
+		t.Fatalf("events = %v", got)
+	}
+}
+
+func TestGRPCExecAuditsRefusedCalls(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestServer()
+	s.audit = &auditLogger{w: &buf}
+	client := newGRPCClient(t, s)
+	stream, err := client.Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.Send(&sandboxpb.ExecInput{Input: &sandboxpb.ExecInput_Start{Start: &sandboxpb.ExecStart{Command: []string{"ls"}}}})
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("exec without a sandbox id: %v", err)
+	}
+	s.audit.mu.Lock()
+	line := buf.String()
+	s.audit.mu.Unlock()
+	var rec auditRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatalf("audit log %q: %v", line, err)
+	}
+	if rec.Action != "exec" || rec.Status != 400 || rec.Result != "error" || rec.Error != "sandbox_id is required" || len(rec.Command) != 1 {
+		t.Fatalf("audit record = %+v", rec)
+	}
+}
+
+func TestGRPCExecAuditsExitCode(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestServer(readyPod("sbx-a"))
+	s.audit = &auditLogger{w: &buf}
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		return utilsexec.CodeExitError{Err: errors.New("exit 2"), Code: 2}
+	}
+	client := newGRPCClient(t, s)
+	stream, err := client.Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.Send(&sandboxpb.ExecInput{Input: &sandboxpb.ExecInput_Start{Start: &sandboxpb.ExecStart{SandboxId: "sbx-a", Command: []string{"false"}}}})
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.audit.mu.Lock()
+	line := buf.String()
+	s.audit.mu.Unlock()
+	var rec auditRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatalf("audit log %q: %v", line, err)
+	}
+	if rec.Status != 200 || rec.Result != "ok" || rec.Error != "" || rec.ExitCode == nil || *rec.ExitCode != 2 {
+		t.Fatalf("audit record = %+v", rec)
+	}
+}
+
+func TestServeGRPCStopsWithContext(t *testing.T) {
+	s := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.serveGRPC(ctx, "127.0.0.1:0") }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("gRPC server still serving after its context was canceled")
+	}
+}
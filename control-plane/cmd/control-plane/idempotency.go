@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"sandbox/pkg/api"
+)
+
+const (
+	idempotencyKeyHeader  = "Idempotency-Key"
+	defaultIdempotencyTTL = 24 * time.Hour
+	maxIdempotencyKeyLen  = 255
+	// maxIdempotencyKeys caps the store; past it the oldest keys are dropped
+	// before their TTL.
+	maxIdempotencyKeys = 10000
+)
+
+// idempotencyStore remembers the result of each create made with an
+// Idempotency-Key so a retried request returns the same sandbox instead of
+// creating another. Entries expire after ttl, and at most max are kept.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]idempotencyEntry
+	// order holds keys oldest first. Every entry has the same ttl, so they also
+	// expire in this order.
+	order []idempotencyKey
+}
+
+type idempotencyKey struct {
+	key     string
+	expires time.Time
+}
+
+type idempotencyEntry struct {
+	// fingerprint is a hash of the request body; reusing a key with a different
+	// body is a client bug, not a retry.
+	fingerprint string
+	resp        api.CreateSandboxResponse
+	expires     time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	return &idempotencyStore{ttl: ttl, max: maxIdempotencyKeys, entries: map[string]idempotencyEntry{}}
+}
+
+// start periodically drops expired entries, so keys that are never replayed
+// don't outlive their TTL.
+func (s *idempotencyStore) start(ctx context.Context) {
+	interval := time.Minute
+	if s.ttl < interval {
+		interval = s.ttl
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.mu.Lock()
+			s.evictLocked(time.Now(), s.max)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// get returns the live entry for key.
+func (s *idempotencyStore) get(key string) (idempotencyEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(s.entries, key)
+		return idempotencyEntry{}, false
+	}
+	return e, true
+}
+
+// put records resp for key, dropping expired entries and, when the store is full,
+// the oldest ones.
+func (s *idempotencyStore) put(key, fingerprint string, resp api.CreateSandboxResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.evictLocked(now, s.max-1)
+	resp.Ready = false
+	e := idempotencyEntry{fingerprint: fingerprint, resp: resp, expires: now.Add(s.ttl)}
+	s.entries[key] = e
+	s.order = append(s.order, idempotencyKey{key: key, expires: e.expires})
+}
+
+// evictLocked drops entries from the front of order until the front one is live
+// and at most keep remain.
+func (s *idempotencyStore) evictLocked(now time.Time, keep int) {
+	for len(s.order) > 0 {
+		front := s.order[0]
+		e, ok := s.entries[front.key]
+		if ok && !e.expires.Equal(front.expires) {
+			// The key was put again later; its newer place in order stands.
+			s.order = s.order[1:]
+			continue
+		}
+		if ok && !now.After(e.expires) && len(s.entries) <= keep {
+			break
+		}
+		delete(s.entries, front.key)
+		s.order = s.order[1:]
+	}
+	if len(s.order) == 0 {
+		s.order = nil
+	}
+}
+
+// requestFingerprint hashes a create request as the client sent it.
+func requestFingerprint(req api.CreateSandboxRequest) string {
+	b, _ := json.Marshal(req)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
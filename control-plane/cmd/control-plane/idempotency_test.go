@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCreateIdempotencyKeyReplays(t *testing.T) {
+	s := newTestServer()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/sandboxes", s.handleSandboxes)
+	create := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sandboxes", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) api.CreateSandboxResponse {
+		t.Helper()
+		if w.Code != 200 {
+			t.Fatalf("create: status %d: %s", w.Code, w.Body)
+		}
+		var resp api.CreateSandboxResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := decode(create("retry-1", `{"env":{"A":"1"}}`))
+	replay := create("retry-1", `{"env":{"A":"1"}}`)
+	if got := decode(replay); got.ID != first.ID {
+		t.Fatalf("replay created %s, want the original %s", got.ID, first.ID)
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay not marked Idempotent-Replayed")
+	}
+	nsList, err := s.client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nsList.Items) != 1 {
+		t.Fatalf("%d namespaces after a replayed create, want 1", len(nsList.Items))
+	}
+
+	if w := create("retry-1", `{"env":{"A":"2"}}`); w.Code != 422 {
+		t.Errorf("same key, different body: status %d, want 422", w.Code)
+	}
+	if got := decode(create("retry-2", `{"env":{"A":"1"}}`)); got.ID == first.ID {
+		t.Error("a new key returned the first sandbox")
+	}
+	if got := decode(create("", `{"env":{"A":"1"}}`)); got.ID == first.ID {
+		t.Error("a create without a key returned the first sandbox")
+	}
+}
+
+func TestIdempotencyStoreBounded(t *testing.T) {
+	s := newIdempotencyStore(time.Hour)
+	s.max = 2
+	for _, key := range []string{"a", "b", "c"} {
+		s.put(key, "fp", api.CreateSandboxResponse{ID: "sbx-" + key})
+	}
+	if _, ok := s.get("a"); ok {
+		t.Error("the oldest key was kept past the cap")
+	}
+	for _, key := range []string{"b", "c"} {
+		if e, ok := s.get(key); !ok || e.resp.ID != "sbx-"+key {
+			t.Errorf("%s: entry = %+v, %v, want it kept", key, e, ok)
+		}
+	}
+
+	s.mu.Lock()
+	s.evictLocked(time.Now().Add(2*time.Hour), s.max)
+	entries, order := len(s.entries), len(s.order)
+	s.mu.Unlock()
+	if entries != 0 || order != 0 {
+		t.Errorf("%d entries and %d keys in order after the TTL, want none", entries, order)
+	}
+}
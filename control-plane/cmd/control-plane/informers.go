@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const informerSyncTimeout = 30 * time.Second
+
+// namespaceCache serves namespace reads from a shared informer so the reaper, the
+// warm pool and list handlers don't each List every namespace on every tick. Until
+// the informer has synced, reads fall through to the API server.
+type namespaceCache struct {
+	client  kubernetes.Interface
+	factory informers.SharedInformerFactory
+	lister  listersv1.NamespaceLister
+	hasSync cache.InformerSynced
+	synced  atomic.Bool
+}
+
+func newNamespaceCache(client kubernetes.Interface) *namespaceCache {
+	factory := informers.NewSharedInformerFactory(client, 0)
+	informer := factory.Core().V1().Namespaces()
+	return &namespaceCache{
+		client:  client,
+		factory: factory,
+		lister:  informer.Lister(),
+		hasSync: informer.Informer().HasSynced,
+	}
+}
+
+func (c *namespaceCache) start(ctx context.Context) {
+	startInformer(ctx, "namespace", c.factory, c.hasSync, &c.synced)
+}
+
+// startInformer runs factory and waits up to informerSyncTimeout for the initial
+// list. If the sync doesn't finish in time, synced is set once it does and callers
+// keep using live reads until then.
+func startInformer(ctx context.Context, name string, factory informers.SharedInformerFactory, hasSync cache.InformerSynced, synced *atomic.Bool) {
+	factory.Start(ctx.Done())
+	syncCtx, cancel := context.WithTimeout(ctx, informerSyncTimeout)
+	defer cancel()
+	if cache.WaitForCacheSync(syncCtx.Done(), hasSync) {
+		synced.Store(true)
+		return
+	}
+	log.Printf("%s informer not synced after %s, using live reads", name, informerSyncTimeout)
+	go func() {
+		if cache.WaitForCacheSync(ctx.Done(), hasSync) {
+			synced.Store(true)
+			log.Printf("%s informer synced", name)
+		}
+	}()
+}
+
+// list returns the namespaces matching selector, sorted by name. The results are
+// copies and safe to modify.
+func (c *namespaceCache) list(ctx context.Context, selector labels.Selector) ([]corev1.Namespace, error) {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	if !c.synced.Load() {
+		return c.listLive(ctx, selector)
+	}
+	cached, err := c.lister.List(selector)
+	if err != nil {
+		return c.listLive(ctx, selector)
+	}
+	out := make([]corev1.Namespace, 0, len(cached))
+	for _, ns := range cached {
+		out = append(out, *ns.DeepCopy())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (c *namespaceCache) listLive(ctx context.Context, selector labels.Selector) ([]corev1.Namespace, error) {
+	nsList, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(nsList.Items, func(i, j int) bool { return nsList.Items[i].Name < nsList.Items[j].Name })
+	return nsList.Items, nil
+}
+
+// warmPodCache watches pods labeled sbx.warm=true so warm-pool readiness checks are
+// cache lookups instead of a Get per namespace per tick.
+type warmPodCache struct {
+	client   kubernetes.Interface
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   listersv1.PodLister
+	synced   atomic.Bool
+}
+
+func newWarmPodCache(client kubernetes.Interface) *warmPodCache {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = "sbx.warm=true"
+		}))
+	informer := factory.Core().V1().Pods()
+	return &warmPodCache{
+		client:   client,
+		factory:  factory,
+		informer: informer.Informer(),
+		lister:   informer.Lister(),
+	}
+}
+
+func (c *warmPodCache) start(ctx context.Context) {
+	startInformer(ctx, "warm pod", c.factory, c.informer.HasSynced, &c.synced)
+}
+
+// onReady calls fn with the namespace of every warm pod that becomes ready.
+func (c *warmPodCache) onReady(fn func(ns string)) {
+	_, _ = c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok && podReady(pod) {
+				fn(pod.Namespace)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok := oldObj.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := newObj.(*corev1.Pod)
+			if ok && !podReady(oldPod) && podReady(newPod) {
+				fn(newPod.Namespace)
+			}
+		},
+	})
+}
+
+// get returns the named pod, from the cache once it has synced. Cache misses fall
+// back to the API server since a just-created pod may not have been observed yet.
+func (c *warmPodCache) get(ctx context.Context, ns, name string) (*corev1.Pod, error) {
+	if c.synced.Load() {
+		if pod, err := c.lister.Pods(ns).Get(name); err == nil {
+			return pod, nil
+		}
+	}
+	return c.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || !podCondition(pod, corev1.PodReady) {
+		return false
+	}
+	// The kubelet folds readiness gates into Ready, but checking them here
+	// doesn't depend on it having caught up with a gate that just changed.
+	for _, gate := range pod.Spec.ReadinessGates {
+		if !podCondition(pod, gate.ConditionType) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceCacheList(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-b", Labels: map[string]string{"sbx.allocated": "false"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a", Labels: map[string]string{"sbx.allocated": "false"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-c", Labels: map[string]string{"sbx.allocated": "true"}}},
+	)
+	c := newNamespaceCache(client)
+	selector := labels.SelectorFromSet(map[string]string{"sbx.allocated": "false"})
+
+	// Before the informer syncs, reads go to the API server.
+	live, err := c.list(context.Background(), selector)
+	if err != nil {
+		t.Fatalf("list before sync: %v", err)
+	}
+	if len(live) != 2 || live[0].Name != "sbx-a" || live[1].Name != "sbx-b" {
+		t.Fatalf("list before sync = %v, want [sbx-a sbx-b]", namespaceNames(live))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.start(ctx)
+	if !c.synced.Load() {
+		t.Fatalf("informer not synced after start")
+	}
+	cached, err := c.list(ctx, selector)
+	if err != nil {
+		t.Fatalf("list after sync: %v", err)
+	}
+	if len(cached) != 2 || cached[0].Name != "sbx-a" || cached[1].Name != "sbx-b" {
+		t.Fatalf("list after sync = %v, want [sbx-a sbx-b]", namespaceNames(cached))
+	}
+	// Results are copies; changing them must not touch the shared cache.
+	cached[0].Labels["sbx.allocated"] = "true"
+	again, _ := c.list(ctx, selector)
+	if len(again) != 2 {
+		t.Fatalf("cache modified through a returned namespace: %v", namespaceNames(again))
+	}
+}
+
+func namespaceNames(items []corev1.Namespace) []string {
+	out := make([]string, 0, len(items))
+	for _, ns := range items {
+		out = append(out, ns.Name)
+	}
+	return out
+}
+
+func TestWarmPodCacheReadyEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := newWarmPodCache(client)
+	readyNS := make(chan string, 4)
+	c.onReady(func(ns string) { readyNS <- ns })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.start(ctx)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "sandbox", Namespace: "sbx-a", Labels: map[string]string{"sbx.warm": "true"}}}
+	if _, err := client.CoreV1().Pods("sbx-a").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod: %v", err)
+	}
+	ready := readyPod("sbx-a")
+	ready.Labels = pod.Labels
+	if _, err := client.CoreV1().Pods("sbx-a").Update(ctx, ready, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update pod: %v", err)
+	}
+	select {
+	case ns := <-readyNS:
+		if ns != "sbx-a" {
+			t.Fatalf("ready event for %q, want sbx-a", ns)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no ready event after the pod became ready")
+	}
+	select {
+	case ns := <-readyNS:
+		t.Fatalf("unexpected second ready event for %q", ns)
+	default:
+	}
+}
+
+func TestWarmPodCacheGetFallsBackToAPI(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := newWarmPodCache(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.start(ctx)
+	// Unlabeled pods are never in the cache, so this Get must go to the API server.
+	if _, err := client.CoreV1().Pods("sbx-a").Create(ctx, readyPod("sbx-a"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod: %v", err)
+	}
+	pod, err := c.get(ctx, "sbx-a", "sandbox")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !podReady(pod) {
+		t.Fatalf("pod from fallback get is not ready")
+	}
+}
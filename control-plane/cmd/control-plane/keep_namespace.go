@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// keptAnnotation marks a sandbox whose pod was deleted with keep_namespace, with
+// the time it was deleted.
+const keptAnnotation = "sbx.kept_at"
+
+// deleteSandboxPod handles DELETE /sandboxes/:id?keep_namespace=true: it deletes
+// only the sandbox pod, so PVCs, events and other objects in the namespace stay
+// for a post-mortem. The namespace stays allocated (sbx.allocated=false would
+// hand it to the warm pool, which trims podless namespaces first) and its idle
+// clock restarts, so the reaper deletes it after SANDBOX_IDLE_TTL, or after
+// SANDBOX_KEPT_TTL however its idle TTL is set.
+func (s *server) deleteSandboxPod(c *gin.Context, ns string) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
+	defer cancel()
+	n, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && !strings.HasPrefix(ns, "sbx-")) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox "+ns+" not found")
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	if n.DeletionTimestamp != nil {
+		writeErrorCode(c, 409, errCodeSandboxTerminating, "sandbox namespace is already terminating")
+		return
+	}
+	now := time.Now()
+	if err := s.annotateNamespace(ctx, ns, map[string]string{
+		keptAnnotation:     now.UTC().Format(time.RFC3339),
+		"sbx.last_exec_at": strconv.FormatInt(now.Unix(), 10),
+	}, true); err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	err = s.client.CoreV1().Pods(ns).Delete(ctx, "sandbox", metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		writeError(c, 500, err.Error())
+		return
+	}
+	metricDeletes.Add(1)
+	s.execCache.forget(ns)
+	s.execs.cancelQueued(ns)
+	writeJSON(c, 200, map[string]string{"status": "deleted", "namespace": "kept"})
+}
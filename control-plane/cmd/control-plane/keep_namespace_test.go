@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeleteKeepNamespace(t *testing.T) {
+	s := newTestServer(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a", Labels: map[string]string{"sbx.allocated": "true"}}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "workspace", Namespace: "sbx-a"}},
+		readyPod("sbx-a"),
+	)
+	w := serve(s.deleteSandbox, http.MethodDelete, "/sandboxes/:id", "/sandboxes/sbx-a?keep_namespace=true", nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"namespace":"kept"`) {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	ctx := context.Background()
+	if _, err := s.client.CoreV1().Pods("sbx-a").Get(ctx, "sandbox", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("pod still there: %v", err)
+	}
+	ns, err := s.client.CoreV1().Namespaces().Get(ctx, "sbx-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("namespace gone: %v", err)
+	}
+	// Still allocated, so the warm pool leaves it alone and the idle reaper owns it.
+	if ns.Labels["sbx.allocated"] != "true" || ns.Annotations[keptAnnotation] == "" || ns.Annotations["sbx.last_exec_at"] == "" {
+		t.Errorf("namespace metadata = %v %v", ns.Labels, ns.Annotations)
+	}
+	if _, err := s.client.CoreV1().PersistentVolumeClaims("sbx-a").Get(ctx, "workspace", metav1.GetOptions{}); err != nil {
+		t.Errorf("pvc gone: %v", err)
+	}
+
+	w = serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/sbx-a", nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"phase":"kept"`) || !strings.Contains(w.Body.String(), "kept_at") {
+		t.Errorf("get = %d %s", w.Code, w.Body)
+	}
+
+	w = serve(s.deleteSandbox, http.MethodDelete, "/sandboxes/:id", "/sandboxes/sbx-missing?keep_namespace=true", nil)
+	if w.Code != 404 {
+		t.Errorf("missing sandbox: status %d", w.Code)
+	}
+}
+
+func TestReapKeptNamespaces(t *testing.T) {
+	// With idle reaping off, only the kept TTL removes them.
+	t.Setenv("SANDBOX_IDLE_TTL", "0")
+	t.Setenv("SANDBOX_KEPT_TTL", "1h")
+	now := time.Now()
+	kept := func(name string, at time.Time) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{
+			keptAnnotation:     at.UTC().Format(time.RFC3339),
+			"sbx.last_exec_at": strconv.FormatInt(at.Unix(), 10),
+			"sbx.idle_ttl":     "0s",
+		}}}
+	}
+	s := newTestServer(kept("sbx-old", now.Add(-2*time.Hour)), kept("sbx-new", now.Add(-time.Minute)))
+	reaped := s.reapOnce(context.Background(), false)
+	if len(reaped) != 1 || reaped[0].ID != "sbx-old" || reaped[0].Reason != "kept" || reaped[0].ForSeconds < 7200 {
+		t.Fatalf("reaped %+v, want sbx-old kept for two hours", reaped)
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-old", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("sbx-old: %v, want it deleted", err)
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-new", metav1.GetOptions{}); err != nil {
+		t.Errorf("sbx-new: %v, want it kept", err)
+	}
+}
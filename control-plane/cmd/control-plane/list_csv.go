@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"sandbox/pkg/api"
+)
+
+const mimeCSV = "text/csv"
+
+// sandboxCSVHeader names the api.SandboxStatus columns after their JSON fields.
+var sandboxCSVHeader = []string{"id", "namespace", "age", "state", "allocated", "last_exec_time", "ready_at", "archived"}
+
+// listFormat picks how listSandboxes answers: ?format=csv|json, else the Accept
+// header, else JSON.
+func listFormat(c *gin.Context) (string, error) {
+	switch f := c.Query("format"); f {
+	case "csv":
+		return mimeCSV, nil
+	case "json":
+		return gin.MIMEJSON, nil
+	case "":
+		if c.NegotiateFormat(gin.MIMEJSON, mimeCSV) == mimeCSV {
+			return mimeCSV, nil
+		}
+		return gin.MIMEJSON, nil
+	default:
+		return "", fmt.Errorf("format must be csv or json")
+	}
+}
+
+// writeSandboxesCSV writes statuses as CSV with a header row. A page's continue
+// token, which has no place in the rows, goes in the Sandbox-Continue header.
+func writeSandboxesCSV(c *gin.Context, statuses []api.SandboxStatus, next string) {
+	if next != "" {
+		c.Header("Sandbox-Continue", next)
+	}
+	c.Header("Content-Type", mimeCSV+"; charset=utf-8")
+	c.Status(200)
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(sandboxCSVHeader)
+	for _, st := range statuses {
+		_ = w.Write([]string{st.ID, st.Namespace, st.Age, st.State, st.Allocated, st.LastExecTime, st.ReadyAt, strconv.FormatBool(st.Archived)})
+	}
+	w.Flush()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestListSandboxesCSV(t *testing.T) {
+	s := newTestServer(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a", Annotations: map[string]string{"sbx.ready_at": "0"}}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-b"}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating}},
+	)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/sandboxes", s.listSandboxes)
+	list := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	rows := func(w *httptest.ResponseRecorder) [][]string {
+		t.Helper()
+		if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+			t.Fatalf("status %d content type %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+		out, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	for _, w := range []*httptest.ResponseRecorder{list("/sandboxes", "text/csv"), list("/sandboxes?format=csv", "")} {
+		got := rows(w)
+		if len(got) != 3 || !reflect.DeepEqual(got[0], sandboxCSVHeader) {
+			t.Fatalf("rows = %q", got)
+		}
+		if got[0][0] != "id" || got[1][0] != "sbx-a" || got[1][3] != "Active" || got[1][6] != "-" || got[2][0] != "sbx-b" || got[2][3] != "Terminating" {
+			t.Fatalf("rows = %q", got)
+		}
+	}
+
+	w := list("/sandboxes?format=csv&limit=1", "")
+	if got := rows(w); len(got) != 2 || got[1][0] != "sbx-a" || w.Header().Get("Sandbox-Continue") != "sbx-a" {
+		t.Fatalf("page rows = %q continue %q", got, w.Header().Get("Sandbox-Continue"))
+	}
+
+	// JSON stays the default, including for browsers' catch-all Accept.
+	for _, accept := range []string{"", "*/*", "application/json"} {
+		if w := list("/sandboxes", accept); !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("Accept %q: content type %q", accept, w.Header().Get("Content-Type"))
+		}
+	}
+	if w := list("/sandboxes?format=xml", ""); w.Code != 400 {
+		t.Errorf("format=xml: status %d", w.Code)
+	}
+}
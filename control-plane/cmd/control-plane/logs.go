@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// sandboxLogs streams the sandbox container's logs as plain text. With follow=true the
+// response stays open until the client disconnects or the container exits.
+func (s *server) sandboxLogs(c *gin.Context) {
+	id := c.Param("id")
+	ns := id
+	opts := &corev1.PodLogOptions{
+		Container: "sandbox",
+		Follow:    c.Query("follow") == "true",
+	}
+	if v := c.Query("tail_lines"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeErrorCode(c, 400, errCodeInvalidRequest, "tail_lines must be a non-negative integer")
+			return
+		}
+		opts.TailLines = &n
+	}
+	podNS, podName := sandboxPod(ns, "sandbox")
+	stream, err := s.client.CoreV1().Pods(podNS).GetLogs(podName, opts).Stream(c.Request.Context())
+	if apierrors.IsNotFound(err) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	defer stream.Close()
+	if opts.Follow {
+		// attach follows the logs; like the exec stream, that counts as use.
+		go s.keepAlive(c.Request.Context(), ns, streamTouchInterval())
+	}
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(200)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
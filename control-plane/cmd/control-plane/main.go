@@ -5,16 +5,23 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"sandbox/control-plane/internal/k8s"
@@ -25,41 +32,131 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
-	defaultImage      = "sandbox-base:dev"
-	defaultVolumeMode = "emptydir"
-	defaultWaitReady  = 20 * time.Second
-	defaultCacheMode  = "emptydir"
+	defaultImage                = "sandbox-base:dev"
+	defaultMaxRequestBytes      = 1 << 20
+	defaultMaxConcurrentCreates = 20
+	defaultK8sQPS               = 50
+	defaultK8sBurst             = 100
+	defaultVolumeMode           = "emptydir"
+	defaultWaitReady            = 20 * time.Second
+	defaultCacheMode            = "emptydir"
 )
 
 var _ = expvar.NewInt
 
 type server struct {
-	client *kubernetes.Clientset
+	client kubernetes.Interface
 	cfg    *rest.Config
 	warm   *warmPool
-	stream *streamHub
-	execs  *execRegistry
+	// namespaces serves namespace reads from the shared informer.
+	namespaces *namespaceCache
+	stream     *streamHub
+	execs      *execRegistry
+	stats      statsCache
+	usage      usageCache
+	// podExec runs commands in sandbox containers. Nil execs through the API
+	// server; tests set it to run handlers without a cluster.
+	podExec execFunc
+	// creates serializes creates for the same explicit sandbox id.
+	creates *keyedMutex
+	// recoverMisses rate-limits status recovery for exec ids the pod doesn't know.
+	recoverMisses recoverMissCache
+	// createSlots bounds concurrent creates across all ids.
+	createSlots *createLimiter
+	// execSlots bounds concurrent execs per sandbox; nil is unlimited.
+	execSlots *execLimiter
+	// idempotency holds create results by Idempotency-Key.
+	idempotency *idempotencyStore
+	// execCache holds sync exec results for ?cache=true.
+	execCache *execResultCache
+	// audit records mutating requests; nil when SANDBOX_AUDIT_LOG is unset.
+	audit *auditLogger
+	// batchRoutes serves the ops of POST /batch; built on first use.
+	batchOnce   sync.Once
+	batchRoutes http.Handler
+	// queuedCreates tracks ?queue=true creates waiting for a create slot.
+	queuedCreates queuedCreates
+	// draining rejects creates; see drain.
+	draining atomic.Bool
 }
 
+// execFunc runs cmd in a container of a pod with opts' streams attached.
+type execFunc func(ctx context.Context, ns, pod, container string, cmd []string, opts remotecommand.StreamOptions) error
+
 func main() {
 	var addr string
 	flag.StringVar(&addr, "addr", ":8080", "listen address")
 	flag.Parse()
 
-	client, cfg, err := k8s.NewClient()
-	if err != nil {
-		log.Fatalf("k8s client: %v", err)
-	}
 	if _, err := getConfig(); err != nil {
 		log.Fatalf("config: %v", err)
 	}
+	if err := validateMountPaths(mountPathsFromEnv()); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := validateExecWrapper(getenv("SANDBOX_EXEC_WRAPPER", "")); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := validateExecShell(getenv("SANDBOX_EXEC_SHELL", defaultExecShell)); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := validateExecPathPrepend(getenv("SANDBOX_EXEC_PATH_PREPEND", "")); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if _, err := parseExecAllowlist(getenv("SANDBOX_EXEC_ALLOWLIST", "")); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if _, err := parseRedactPatterns(getenv("SANDBOX_REDACT_ENV_KEYS", defaultRedactEnvKeys)); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if _, err := parseStdinRedactPatterns(getenv("SANDBOX_STREAM_STDIN_REDACT", "")); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if spread, err := parseTopologySpread(getenv("SANDBOX_TOPOLOGY_SPREAD", "")); err != nil {
+		log.Fatalf("config: %v", err)
+	} else if len(spread) > 0 && !topologySpreadApplies() {
+		log.Printf("WARNING: SANDBOX_TOPOLOGY_SPREAD is ignored without SANDBOX_SINGLE_NAMESPACE; use SANDBOX_SPREAD to spread sandboxes across nodes")
+	}
+	if err := validateSingleNamespaceConfig(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	for key, isLabel := range map[string]bool{"SANDBOX_WARM_LABELS": true, "SANDBOX_WARM_ANNOTATIONS": false, "SANDBOX_METRICS_LABELS": true} {
+		if err := validateWarmMetadata(key, isLabel); err != nil {
+			log.Fatalf("config: %v", err)
+		}
+	}
+	// Autosize defaults max to 10, so a min above that is caught too.
+	if cfg := warmPoolConfigFromEnv(); cfg.autosize {
+		if err := validateWarmPoolBounds(cfg.min, cfg.max); err != nil {
+			log.Fatalf("config: SANDBOX_WARM_POOL_MIN/SANDBOX_WARM_POOL_MAX: %v", err)
+		}
+	}
+	if err := validateDNS(dnsFromEnv()); err != nil {
+		log.Fatalf("config: SANDBOX_DNS_POLICY: %v", err)
+	}
+	for _, w := range streamConfigWarnings() {
+		log.Printf("config warning: %s", w)
+	}
+	// The default image also backs the warm pool, so a violation would fail every
+	// default create.
+	if err := validateImagePolicy(getenv("SANDBOX_IMAGE", defaultImage)); err != nil {
+		log.Fatalf("config: SANDBOX_IMAGE: %v", err)
+	}
+	client, cfg, err := k8s.NewClient(k8sClientOptions())
+	if err != nil {
+		log.Fatalf("k8s client: %v", err)
+	}
+	log.Printf("k8s client qps=%g burst=%d", cfg.QPS, cfg.Burst)
 	if path := os.Getenv("SANDBOX_CONFIG"); path != "" {
 		log.Printf("config loaded: %s", path)
 	} else {
@@ -67,44 +164,113 @@ func main() {
 	}
 
 	s := &server{
-		client: client,
-		cfg:    cfg,
-		warm:   nil,
-		stream: newStreamHub(getenvInt("SANDBOX_STREAM_BUFFER", 200)),
-		execs:  newExecRegistry(getenvDuration("SANDBOX_EXEC_STATUS_RETENTION", 30*time.Minute)),
+		client:      client,
+		cfg:         cfg,
+		warm:        nil,
+		namespaces:  newNamespaceCache(client),
+		stream:      newStreamHub(getenvInt("SANDBOX_STREAM_BUFFER", 200)),
+		execs:       newExecRegistry(getenvDuration("SANDBOX_EXEC_STATUS_RETENTION", 30*time.Minute), getenvInt("SANDBOX_EXEC_OUTPUT_TAIL_BYTES", defaultExecOutputTailBytes)),
+		creates:     newKeyedMutex(),
+		createSlots: newCreateLimiter(getenvInt("SANDBOX_MAX_CONCURRENT_CREATES", defaultMaxConcurrentCreates)),
+		execSlots:   newExecLimiter(getenvInt("SANDBOX_MAX_CONCURRENT_EXECS", 0), getenvInt("SANDBOX_MAX_QUEUED_EXECS", defaultMaxQueuedExecs)),
+		idempotency: newIdempotencyStore(getenvDuration("SANDBOX_IDEMPOTENCY_TTL", defaultIdempotencyTTL)),
+		execCache:   newExecResultCache(),
+	}
+	if s.audit, err = newAuditLoggerFromEnv(); err != nil {
+		log.Fatalf("audit log: %v", err)
 	}
 	metricCacheMode.Set(getenv("SANDBOX_CACHE_MODE", defaultCacheMode))
 	metricStreamBuffer.Set(int64(getenvInt("SANDBOX_STREAM_BUFFER", 200)))
-	s.warm = newWarmPool(client, warmPoolConfigFromEnv(), cacheConfigFromEnv())
+	if scope := singleNamespace(); scope != "" {
+		log.Printf("single namespace mode: sandboxes are pods in namespace %s", scope)
+	} else {
+		s.namespaces.start(context.Background())
+	}
+	s.warm = newWarmPool(client, s.namespaces, newWarmPodCache(client), warmPoolConfigFromEnv(), cacheConfigFromEnv())
+	s.warm.probe = func(ctx context.Context, ns string, cmd []string) error {
+		return s.execCommandTo(ctx, ns, "sandbox", "sandbox", cmd, io.Discard, io.Discard)
+	}
 	log.Printf("warm pool enabled=%t autosize=%t size=%d min=%d max=%d",
 		s.warm.enabled(), s.warm.cfg.autosize, s.warm.cfg.size, s.warm.cfg.min, s.warm.cfg.max)
 	if s.warm.enabled() {
 		if err := s.warm.rebuildFromCluster(context.Background(), getenv("SANDBOX_IMAGE", defaultImage)); err != nil {
 			log.Printf("warm pool rebuild: %v", err)
 		}
-		go s.warm.run(context.Background(), getenv("SANDBOX_IMAGE", defaultImage))
+		s.warm.start(context.Background(), getenv("SANDBOX_IMAGE", defaultImage))
+	} else if singleNamespace() == "" {
+		if err := s.warm.cleanupDisabled(context.Background()); err != nil {
+			log.Printf("warm pool cleanup: %v", err)
+		}
 	}
 	go s.reapIdleSandboxes(context.Background())
-	go s.execs.start(context.Background())
+	go s.idempotency.start(context.Background())
+	go s.execs.start(context.Background(), s.removeExecFiles)
 
 	router := gin.New()
-	router.Use(requestIDMiddleware(), ginLogger())
+	router.Use(requestIDMiddleware(), ginLogger(), maxBodyMiddleware(int64(getenvInt("SANDBOX_MAX_REQUEST_BYTES", defaultMaxRequestBytes))))
 	router.GET("/healthz", s.handleHealth)
+	router.GET("/readyz", s.handleReady)
 	router.GET("/metrics", gin.WrapH(expvar.Handler()))
-	router.POST("/sandboxes", s.handleSandboxes)
+	router.GET("/stats", s.getStats)
+	router.GET("/config", requireAdmin(), s.getEffectiveConfig)
+	router.GET("/warm-pool", s.getWarmPool)
+	router.POST("/warm-pool/resize", requireAdmin(), requireNamespacePerSandbox(), s.resizeWarmPool)
+	router.GET("/warm-pool/namespaces", requireAdmin(), requireNamespacePerSandbox(), s.listWarmNamespaces)
+	router.GET("/admin/orphans", requireAdmin(), requireNamespacePerSandbox(), s.listOrphans)
+	router.POST("/admin/reap", requireAdmin(), s.forceReap)
+	router.POST("/admin/drain", requireAdmin(), s.drain(true))
+	router.POST("/admin/undrain", requireAdmin(), s.drain(false))
+	router.POST("/sandboxes", s.audit.middleware("create"), s.handleSandboxes)
 	router.GET("/sandboxes", s.listSandboxes)
+	router.GET("/sandboxes/count", s.countSandboxes)
 	router.GET("/sandboxes/:id", s.getSandbox)
-	router.POST("/sandboxes/:id/exec", s.execSandbox)
+	router.GET("/sandboxes/:id/usage", s.getSandboxUsage)
+	router.GET("/sandboxes/:id/logs", s.sandboxLogs)
+	router.GET("/sandboxes/:id/create-events", requireNamespacePerSandbox(), s.createEvents)
+	router.GET("/sandboxes/:id/env", s.sandboxEnv)
+	router.GET("/sandboxes/:id/manifest", s.sandboxManifest)
+	router.GET("/sandboxes/:id/describe", requireAdmin(), s.describeSandbox)
+	router.POST("/sandboxes/exec", s.audit.middleware("bulk_exec"), s.bulkExec)
+	router.POST("/batch", s.batch)
+	router.POST("/sandboxes/:id/exec", s.audit.middleware("exec"), s.execSandbox)
+	router.POST("/sandboxes/:id/wait", s.audit.middleware("wait"), s.waitSandbox)
+	router.POST("/sandboxes/:id/touch", s.audit.middleware("touch"), s.touchSandbox)
 	router.GET("/sandboxes/:id/execs/:exec_id", s.getExecStatus)
-	router.POST("/sandboxes/:id/execs/:exec_id/cancel", s.cancelExec)
+	router.GET("/sandboxes/:id/execs/:exec_id/logs", s.getExecLogs)
+	router.POST("/sandboxes/:id/execs/:exec_id/cancel", s.audit.middleware("cancel"), s.cancelExec)
+	router.POST("/sandboxes/:id/execs/:exec_id/signal", s.audit.middleware("signal"), s.signalExec)
 	router.GET("/sandboxes/:id/stream", s.streamSandbox)
 	router.GET("/sandboxes/:id/ingest", s.ingestSandbox)
-	router.DELETE("/sandboxes/:id", s.deleteSandbox)
+	router.POST("/sandboxes/:id/archive", requireNamespacePerSandbox(), s.audit.middleware("archive"), s.archiveSandbox)
+	router.POST("/sandboxes/:id/unarchive", requireNamespacePerSandbox(), s.audit.middleware("unarchive"), s.unarchiveSandbox)
+	router.PATCH("/sandboxes/:id", requireNamespacePerSandbox(), s.audit.middleware("update"), s.patchSandbox)
+	router.DELETE("/sandboxes/:id", s.audit.middleware("delete"), s.deleteSandbox)
+
+	// SIGTERM, as sent ahead of a rollout, stops both servers gracefully.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	grpcDone := make(chan struct{})
+	if grpcAddr := getenv("SANDBOX_GRPC_ADDR", ""); grpcAddr != "" {
+		go func() {
+			defer close(grpcDone)
+			if err := s.serveGRPC(ctx, grpcAddr); err != nil {
+				log.Fatalf("grpc: %v", err)
+			}
+		}()
+	} else {
+		close(grpcDone)
+	}
 
-	log.Printf("control-plane listening on %s", addr)
-	if err := router.Run(addr); err != nil {
+	srv, err := newHTTPServer(addr, router)
+	if err != nil {
+		log.Fatalf("server: %v", err)
+	}
+	log.Printf("control-plane listening on %s tls=%t", addr, srv.TLSConfig != nil)
+	if err := runServer(ctx, srv); err != nil {
 		log.Fatalf("listen: %v", err)
 	}
+	<-grpcDone
+	log.Printf("control-plane stopped")
 }
 
 func (s *server) handleHealth(c *gin.Context) {
@@ -113,44 +279,125 @@ func (s *server) handleHealth(c *gin.Context) {
 
 func (s *server) handleSandboxes(c *gin.Context) {
 	var req api.CreateSandboxRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		writeError(c, 400, err.Error())
+	if !bindJSON(c, &req) {
 		return
 	}
+	idemKey := c.GetHeader(idempotencyKeyHeader)
+	fingerprint := ""
+	if idemKey != "" {
+		fingerprint = requestFingerprint(req)
+	}
+	// The background run of a ?queue=true create comes with the id it was given,
+	// so it is treated as requested and never renamed to a warm namespace.
+	_, replayed := c.Request.Context().Value(queuedCreateKey{}).(bool)
 	requestedID := req.ID
 	if req.ID == "" {
 		req.ID = generateID()
 	}
+	c.Set(auditCommandKey, req.Command)
 	if !validID(req.ID) {
-		writeError(c, 400, "id must be DNS-1123 compatible (lowercase letters, numbers, '-')")
+		writeErrorCode(c, 400, errCodeInvalidRequest, "id must be DNS-1123 compatible (lowercase letters, numbers, '-')")
 		return
 	}
-	image := req.Image
-	if image == "" {
-		image = getenv("SANDBOX_IMAGE", defaultImage)
+	if len(req.ID) > maxIDLength {
+		writeErrorCode(c, 400, errCodeInvalidRequest, fmt.Sprintf("id is %d characters; it may be at most %d so that the namespace %s<id> fits in 63", len(req.ID), maxIDLength, sandboxNamespacePrefix))
+		return
 	}
-	volumeMode := req.VolumeMode
-	if volumeMode == "" {
-		volumeMode = getenv("SANDBOX_VOLUME_MODE", defaultVolumeMode)
+	if err := validateCreateRequest(req); err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
 	}
-	cacheCfg := cacheConfigFromRequest(req)
-	envVars := defaultSandboxEnv()
-	envVars = mergeEnv(envVars, req.Env)
-	allowedHosts, disallowedHosts := normalizeAllowedHosts(req)
-	if len(allowedHosts) > 0 {
-		if _, ok := envVars["SBX_ALLOWED_HOSTS"]; !ok {
-			envVars["SBX_ALLOWED_HOSTS"] = joinCSV(allowedHosts)
+	if c.Query("dry_run") == "true" {
+		s.planCreate(c, req, requestedID)
+		return
+	}
+	if s.draining.Load() {
+		writeErrorCode(c, 503, errCodeDraining, "the control plane is draining and not accepting new sandboxes")
+		return
+	}
+	wait, waitTimeout, err := parseCreateWait(c)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	queue := c.Query("queue") == "true" && !replayed
+	if queue && wait {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "queue and wait can't be combined")
+		return
+	}
+	remember := func(api.CreateSandboxResponse) {}
+	if idemKey != "" {
+		if len(idemKey) > maxIdempotencyKeyLen {
+			writeErrorCode(c, 400, errCodeInvalidRequest, fmt.Sprintf("%s may be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLen))
+			return
+		}
+		// Keys are per caller so clients can't collide or see each other's results.
+		idemKey = auditPrincipal(c) + "/" + idemKey
+		// A retry racing the original waits for it and then replays its result.
+		unlock := sync.OnceFunc(s.creates.lock("idempotency:" + idemKey))
+		defer unlock()
+		if prev, ok := s.idempotency.get(idemKey); ok {
+			if prev.fingerprint != fingerprint {
+				writeErrorCode(c, 422, errCodeInvalidRequest, idempotencyKeyHeader+" was already used with a different request")
+				return
+			}
+			c.Header("Idempotent-Replayed", "true")
+			s.respondCreated(c, prev.resp, wait, waitTimeout, func() {})
+			return
+		}
+		remember = func(resp api.CreateSandboxResponse) {
+			s.idempotency.put(idemKey, fingerprint, resp)
+			unlock()
 		}
 	}
-	if len(disallowedHosts) > 0 {
-		if _, ok := envVars["SBX_DISALLOWED_HOSTS"]; !ok {
-			envVars["SBX_DISALLOWED_HOSTS"] = joinCSV(disallowedHosts)
+	if requestedID != "" {
+		// Concurrent creates for the same id run one at a time; later ones find the
+		// objects the first created and return the same sandbox. The lock is taken
+		// before a create slot so creates queued behind it don't hold slots.
+		unlock := s.creates.lock(sandboxNamespace(req.ID))
+		defer unlock()
+		// A delete followed by a create of the same id finds the old sandbox still
+		// terminating for a while. Wait for it before taking a create slot, so the
+		// wait doesn't hold up unrelated creates.
+		if err := s.waitSandboxGone(c.Request.Context(), sandboxNamespace(req.ID)); errors.Is(err, errPodTerminating) || errors.Is(err, errNamespaceTerminating) {
+			writeErrorCode(c, 409, errCodeSandboxTerminating, err.Error())
+			return
+		} else if err != nil {
+			writeError(c, 500, err.Error())
+			return
 		}
 	}
+	var acquired func()
+	if queue {
+		var ok bool
+		if acquired, ok = s.createSlots.tryAcquire(); !ok {
+			resp, err := s.queueCreate(c, req)
+			if err != nil {
+				writeError(c, 500, err.Error())
+				return
+			}
+			remember(resp)
+			s.respondCreated(c, resp, false, 0, func() {})
+			return
+		}
+	} else if acquired, err = s.createSlots.acquire(c.Request.Context()); err != nil {
+		writeError(c, 503, "create queue: "+err.Error())
+		return
+	}
+	// Released early by respondCreated so ?wait=true doesn't hold a create slot.
+	release := sync.OnceFunc(acquired)
+	defer release()
+	resolved, err := s.resolveCreate(c.Request.Context(), req)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	image, volumeMode, cacheCfg, envVars := resolved.image, resolved.volumeMode, resolved.cacheCfg, resolved.envVars
+	allowedHosts, disallowedHosts := resolved.allowedHosts, resolved.disallowedHosts
 
 	ns := req.ID
 	warmClaimed := false
-	if requestedID == "" && s.warm != nil && s.warm.enabled() {
+	if warmEligible(req, requestedID) && s.warm != nil && s.warm.enabled() {
 		if claimed, ok, err := s.warm.claimWarmNamespace(c.Request.Context()); err == nil && ok {
 			// warm namespace already has a ready pod; reuse it
 			ns = claimed
@@ -163,19 +410,67 @@ func (s *server) handleSandboxes(c *gin.Context) {
 	if !warmClaimed {
 		ns = sandboxNamespace(req.ID)
 	}
+	// Everything below goes in podNS; only in single-namespace mode is that not ns.
+	podNS, podName := sandboxPod(ns, "sandbox")
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
 	defer cancel()
-	nsAnnotations := map[string]string{}
+	nsAnnotations := map[string]string{
+		// Read back by the warm pool autosizer to rebuild create demand after restarts.
+		"sbx.created_at": strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	if req.IdleTTL != "" {
+		// Validated above; stored normalized so it reads back the same way.
+		d, _ := time.ParseDuration(req.IdleTTL)
+		nsAnnotations["sbx.idle_ttl"] = d.String()
+	}
+	if req.ExpiresAt != "" {
+		t, _ := time.Parse(time.RFC3339, req.ExpiresAt)
+		nsAnnotations["sbx.expires_at"] = strconv.FormatInt(t.Unix(), 10)
+	}
 	if len(allowedHosts) > 0 {
 		nsAnnotations["sbx.allowed_hosts"] = joinCSV(allowedHosts)
 	}
 	if len(disallowedHosts) > 0 {
 		nsAnnotations["sbx.disallowed_hosts"] = joinCSV(disallowedHosts)
 	}
-	if err := s.ensureNamespace(ctx, ns, nil, nsAnnotations); err != nil {
+	// What the pod refers to is checked before anything is created, so a 400 leaves
+	// nothing behind.
+	podCfg := podConfigFromRequest(req)
+	if err := ensurePriorityClass(ctx, s.client, podCfg.priorityClassName); err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkEnvFromSources(ctx, s.client, podNS, podCfg.envFrom); err != nil {
+		if apierrors.IsNotFound(err) {
+			writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+			return
+		}
 		writeError(c, 500, err.Error())
 		return
 	}
+	if req.GitRepo != nil && req.GitRepo.Secret != "" {
+		if err := checkSecret(ctx, s.client, podNS, req.GitRepo.Secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				writeErrorCode(c, 400, errCodeInvalidRequest, "git_repo secret: "+err.Error())
+				return
+			}
+			writeError(c, 500, err.Error())
+			return
+		}
+	}
+	if podNS == ns {
+		if err := s.ensureNamespace(ctx, ns, nil, nsAnnotations); errors.Is(err, errSandboxArchived) {
+			writeErrorCode(c, 409, errCodeSandboxArchived, err.Error())
+			return
+		} else if err != nil {
+			writeError(c, 500, err.Error())
+			return
+		}
+		if err := ensureNamespacePolicies(ctx, s.client, ns); err != nil {
+			writeError(c, 500, err.Error())
+			return
+		}
+	}
 
 	var pvcName string
 	if volumeMode == "pvc" {
@@ -185,12 +480,34 @@ func (s *server) handleSandboxes(c *gin.Context) {
 			return
 		}
 	}
-	if err := ensureCachePVC(ctx, s.client, ns, "cache", cacheCfg); err != nil {
+	if err := ensureCachePVC(ctx, s.client, podNS, "cache", cacheCfg); err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	if err := ensureServiceAccount(ctx, s.client, podNS, podCfg.serviceAccountName); err != nil {
+		writeError(c, 500, "service account: "+err.Error())
+		return
+	}
+	if err := ensureVolumeClaims(ctx, s.client, podNS, podCfg.volumes); err != nil {
+		if apierrors.IsNotFound(err) || errors.Is(err, errClaimNotMountable) {
+			writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+			return
+		}
 		writeError(c, 500, err.Error())
 		return
 	}
+	if err := ensureSubdomainService(ctx, s.client, podNS, podCfg.subdomain); err != nil {
+		writeError(c, 500, "subdomain service: "+err.Error())
+		return
+	}
+	if err := ensureMetricsService(ctx, s.client, podNS, podName, req.Metrics); err != nil {
+		writeError(c, 500, "metrics service: "+err.Error())
+		return
+	}
+	if req.Metrics != nil {
+		podCfg.labels = withWarmMetadata(podCfg.labels, metricsLabels(podName))
+	}
 
-	podName := "sandbox"
 	podAnnotations := map[string]string{}
 	if len(allowedHosts) > 0 {
 		podAnnotations["sbx.allowed_hosts"] = joinCSV(allowedHosts)
@@ -198,12 +515,30 @@ func (s *server) handleSandboxes(c *gin.Context) {
 	if len(disallowedHosts) > 0 {
 		podAnnotations["sbx.disallowed_hosts"] = joinCSV(disallowedHosts)
 	}
-	if err := s.ensurePod(ctx, ns, podName, image, req.Command, volumeMode, pvcName, cacheCfg, mapToEnvVars(envVars), podAnnotations); err != nil {
+	if req.Metrics != nil {
+		for k, v := range metricsAnnotations(req.Metrics) {
+			podAnnotations[k] = v
+		}
+	}
+	if podNS != ns {
+		// No namespace to hold the sandbox's metadata; the pod carries it.
+		for k, v := range nsAnnotations {
+			podAnnotations[k] = v
+		}
+	}
+	created, err := s.ensurePod(ctx, podNS, podName, image, req.Command, volumeMode, pvcName, cacheCfg, mapToEnvVars(envVars), podAnnotations, podCfg)
+	if err != nil {
 		writeError(c, 500, err.Error())
 		return
 	}
 
-	resp := api.CreateSandboxResponse{ID: ns, Namespace: ns, PodName: podName}
+	resp := api.CreateSandboxResponse{ID: ns, Namespace: podNS, PodName: podName}
+	if !created && !warmClaimed {
+		resp.Existing = true
+		remember(resp)
+		s.respondCreated(c, resp, wait, waitTimeout, release)
+		return
+	}
 	metricCreates.Add(1)
 	if warmClaimed {
 		metricCreateWarmHit.Add(1)
@@ -213,19 +548,127 @@ func (s *server) handleSandboxes(c *gin.Context) {
 	if s.warm.enabled() {
 		s.warm.recordCreate()
 	}
-	s.trackReadyAsync(ns, podName)
-	writeJSON(c, 200, resp)
+	s.trackReadyAsync(ns, "sandbox")
+	remember(resp)
+	s.respondCreated(c, resp, wait, waitTimeout, release)
+}
+
+// sandboxImage returns the image a create uses and where it came from: request,
+// or the SANDBOX_IMAGE setting's source (config, env or default).
+func sandboxImage(req api.CreateSandboxRequest) (string, string) {
+	if req.Image != "" {
+		return req.Image, "request"
+	}
+	v := resolveSetting(configSetting{"SANDBOX_IMAGE", "string", defaultImage})
+	return v.Value, v.Source
+}
+
+// resolvedCreate is what a create request comes to once the server's defaults
+// and policies are applied.
+type resolvedCreate struct {
+	image, imageSource string
+	volumeMode         string
+	cacheCfg           cacheConfig
+	envVars            map[string]string
+	allowedHosts       []string
+	disallowedHosts    []string
+}
+
+// resolveCreate applies the defaults and policies a create goes through. Both the
+// create and its dry run use it, so a plan is what the create would do; any error
+// is the request's fault.
+func (s *server) resolveCreate(ctx context.Context, req api.CreateSandboxRequest) (resolvedCreate, error) {
+	r := resolvedCreate{volumeMode: req.VolumeMode, cacheCfg: cacheConfigFromRequest(req)}
+	r.image, r.imageSource = sandboxImage(req)
+	if err := validateImagePolicy(r.image); err != nil {
+		return r, err
+	}
+	if r.volumeMode == "" {
+		r.volumeMode = getenv("SANDBOX_VOLUME_MODE", defaultVolumeMode)
+	}
+	if err := validateSingleNamespaceRequest(r.volumeMode, r.cacheCfg); err != nil {
+		return r, err
+	}
+	if r.cacheCfg.mode == "pvc" || r.cacheCfg.mode == "shared-pvc" {
+		// A missing class leaves the PVC Pending forever; fail before creating anything.
+		if err := ensureStorageClass(ctx, s.client, r.cacheCfg.pvcStorageClass); err != nil {
+			return r, err
+		}
+	}
+	r.envVars, r.allowedHosts, r.disallowedHosts = sandboxEnv(req)
+	return r, nil
+}
+
+// sandboxEnv merges the default sandbox env with the request's and adds the host
+// allow and deny lists, which it also returns.
+func sandboxEnv(req api.CreateSandboxRequest) (map[string]string, []string, []string) {
+	envVars := defaultSandboxEnv()
+	envVars = mergeEnv(envVars, req.Env)
+	mirrorProxyOverrides(envVars, req.Env)
+	allowedHosts, disallowedHosts := normalizeAllowedHosts(req)
+	if len(allowedHosts) > 0 {
+		if _, ok := envVars["SBX_ALLOWED_HOSTS"]; !ok {
+			envVars["SBX_ALLOWED_HOSTS"] = joinCSV(allowedHosts)
+		}
+	}
+	if len(disallowedHosts) > 0 {
+		if _, ok := envVars["SBX_DISALLOWED_HOSTS"]; !ok {
+			envVars["SBX_DISALLOWED_HOSTS"] = joinCSV(disallowedHosts)
+		}
+	}
+	// An explicit SBX_DISALLOWED_HOSTS env can't drop the forced denylist either.
+	if v, ok := envVars["SBX_DISALLOWED_HOSTS"]; ok {
+		envVars["SBX_DISALLOWED_HOSTS"] = joinCSV(withForcedHosts(splitCSV(v)))
+	}
+	return envVars, allowedHosts, disallowedHosts
+}
+
+// warmEligible reports whether a create may claim a warm namespace. Warm pods are
+// already running, so they can't take container args, extra volumes, custom mount
+// paths, a DNS identity or DNS settings, different spreading, a shared process
+// namespace, readiness gates, a startup probe, a git repo to clone before start, a
+// deadline (which would count from the warm pod's start), a pod spec overlay,
+// metrics labels, a restart policy, a priority class, tolerations or a service
+// account (a warm pod would keep the pool's, and its token mount).
+func warmEligible(req api.CreateSandboxRequest, requestedID string) bool {
+	spreadOverride := (req.Spread != nil && *req.Spread != getenvBool("SANDBOX_SPREAD", false)) || len(req.TopologySpread) > 0
+	return requestedID == "" && len(req.Args) == 0 && len(req.Volumes) == 0 && req.WorkspacePath == "" && req.CachePath == "" &&
+		req.Hostname == "" && req.Subdomain == "" && req.DNSPolicy == "" && len(req.DNSServers) == 0 && !spreadOverride &&
+		req.ShareProcessNamespace == nil && len(req.ReadinessGates) == 0 && req.StartupProbe == nil && req.GitRepo == nil &&
+		req.ActiveDeadlineSeconds == nil && emptyOverlay(req.PodSpecOverlay) && req.Metrics == nil &&
+		req.RestartPolicy == "" && req.PriorityClassName == "" && len(req.Tolerations) == 0 &&
+		req.ServiceAccountName == "" && req.AutomountServiceAccountToken == nil
+}
+
+// startAsyncExec registers and starts an async exec in a ready pod and returns its id.
+// queuedAt is when the request was accepted, before any wait for the pod, and
+// release frees the exec's slot once it ends.
+func (s *server) startAsyncExec(ns, podName string, command []string, timeoutSeconds *int, queuedAt time.Time, release func()) string {
+	execID := generateExecID()
+	execCtx, execCancel := execContext(timeoutSeconds)
+	s.execs.createRunning(ns, execID, queuedAt, timeoutSeconds, execCancel)
+	cmd, pidPath := asyncExecCommand(execID, command)
+	go func() {
+		defer release()
+		s.execCommandStream(execCtx, ns, podName, "sandbox", execID, cmd)
+	}()
+	if pidPath != "" {
+		go s.trackExecPID(ns, execID, pidPath)
+	}
+	metricExecs.Add(1)
+	return execID
 }
 
 func (s *server) execSandbox(c *gin.Context) {
+	acceptedAt := time.Now()
 	id := c.Param("id")
 	var req api.ExecRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		writeError(c, 400, err.Error())
+	if !bindJSON(c, &req) {
 		return
 	}
-	if len(req.Command) == 0 {
-		writeError(c, 400, "command is required")
+	command, err := execCommandFromRequest(req)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
 		return
 	}
 
@@ -233,35 +676,124 @@ func (s *server) execSandbox(c *gin.Context) {
 	podName := "sandbox"
 	timeoutSeconds, err := resolveExecTimeoutSeconds(req.TimeoutSeconds)
 	if err != nil {
-		writeError(c, 400, err.Error())
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkExecAllowlist(req, command); err != nil {
+		writeErrorCode(c, 403, errCodeCommandNotAllowed, err.Error())
+		return
+	}
+	req.Command = wrapExecCommand(command, timeoutSeconds)
+	c.Set(auditCommandKey, req.Command)
+
+	useAsync := getenvBool("SANDBOX_ASYNC_EXEC", true)
+	if req.Async != nil {
+		useAsync = *req.Async
+	}
+	tailBytes := 0
+	if raw := c.Query("tail_bytes"); raw != "" {
+		tailBytes, err = strconv.Atoi(raw)
+		// Capped like exec logs, since each stream's ring is allocated up front.
+		if err != nil || tailBytes <= 0 || tailBytes > maxExecLogBytes {
+			writeErrorCode(c, 400, errCodeInvalidRequest, fmt.Sprintf("tail_bytes must be between 1 and %d", maxExecLogBytes))
+			return
+		}
+		if useAsync {
+			writeErrorCode(c, 400, errCodeInvalidRequest, "tail_bytes requires a sync exec")
+			return
+		}
+		if c.Query("ordered") == "true" {
+			writeErrorCode(c, 400, errCodeInvalidRequest, "tail_bytes cannot be combined with ordered=true")
+			return
+		}
+	}
+	useCache := c.Query("cache") == "true"
+	if useCache && (useAsync || tailBytes > 0 || c.Query("ordered") == "true") {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "cache=true requires a sync exec without tail_bytes or ordered=true")
+		return
+	}
+	detachAfter, err := parseExecDetachAfter(c)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if detachAfter > 0 && (useAsync || tailBytes > 0 || useCache || c.Query("ordered") == "true" || c.Query("queue") == "true") {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "detach_after requires a sync exec without tail_bytes, ordered, cache or queue")
+		return
+	}
+	if c.Query("queue") == "true" {
+		if !useAsync {
+			writeErrorCode(c, 400, errCodeInvalidRequest, "queue=true requires an async exec")
+			return
+		}
+		// Without this a typo'd id would queue until SANDBOX_EXEC_QUEUE_TIMEOUT.
+		if exists, err := s.sandboxExists(c.Request.Context(), ns); err != nil {
+			writeError(c, 500, err.Error())
+			return
+		} else if !exists {
+			writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox "+ns+" not found")
+			return
+		}
+		ticket, ok := s.execSlots.enqueue(ns)
+		if !ok {
+			writeErrorCode(c, 429, errCodeExecLimit, fmt.Sprintf("the exec queue for this sandbox is full (SANDBOX_MAX_QUEUED_EXECS=%d)", s.execSlots.maxQueue))
+			return
+		}
+		execID := s.queueExec(ns, podName, req.Command, timeoutSeconds, ticket)
+		c.Set(auditExecKey, execID)
+		writeJSON(c, 200, api.ExecResponse{ExecID: execID, Status: execStatusQueued})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), defaultWaitReady)
 	defer cancel()
 	if err := s.waitForPodReady(ctx, ns, podName); err != nil {
-		writeError(c, 409, "sandbox not ready: "+err.Error())
+		writeErrorCode(c, 409, errCodeSandboxNotReady, "sandbox not ready: "+err.Error())
 		return
 	}
-	streamCfg := streamConfigFromEnv()
-	useAsync := getenvBool("SANDBOX_ASYNC_EXEC", true)
-	if req.Async != nil {
-		useAsync = *req.Async
+	// acquire takes one of the sandbox's exec slots, answering 429 when none is free.
+	acquire := func() (func(), bool) {
+		release, ok := s.execSlots.tryAcquire(ns)
+		if !ok {
+			writeErrorCode(c, 429, errCodeExecLimit, fmt.Sprintf("the sandbox is running its limit of %d execs (SANDBOX_MAX_CONCURRENT_EXECS); retry later, or pass queue=true with an async exec to wait for a slot", s.execSlots.max))
+		}
+		return release, ok
 	}
 	if useAsync {
-		execID := generateExecID()
-		execCtx, execCancel := execContext(timeoutSeconds)
-		s.execs.createRunning(ns, execID, timeoutSeconds, execCancel)
-		if streamCfg.sidecarImage != "" {
-			cmd := wrapCommandForSidecar(execID, req.Command, streamCfg.eventsDir)
-			go s.execCommandStream(execCtx, ns, podName, "sandbox", execID, cmd)
-		} else {
-			go s.execCommandStream(execCtx, ns, podName, "sandbox", execID, req.Command)
+		release, ok := acquire()
+		if !ok {
+			return
 		}
-		metricExecs.Add(1)
+		execID := s.startAsyncExec(ns, podName, req.Command, timeoutSeconds, acceptedAt, release)
+		c.Set(auditExecKey, execID)
 		writeJSON(c, 200, api.ExecResponse{ExecID: execID, Status: "running"})
 		return
 	}
+	req.Command = syncExecCommand(req.Command)
+
+	if detachAfter > 0 {
+		release, ok := acquire()
+		if !ok {
+			return
+		}
+		s.execDetachable(c, ns, podName, req.Command, timeoutSeconds, acceptedAt, detachAfter, release)
+		return
+	}
+
+	cacheKey := execCacheKey(ns, req.Command)
+	if useCache {
+		if hit, ok := s.execCache.get(cacheKey); ok {
+			_ = s.updateLastExec(c.Request.Context(), ns)
+			metricExecCacheHits.Add(1)
+			writeJSON(c, 200, api.ExecResponse{Stdout: hit.stdout, Stderr: hit.stderr, Status: "completed", CacheHit: true})
+			return
+		}
+	}
+	release, ok := acquire()
+	if !ok {
+		return
+	}
+	defer release()
 
 	execCtx := c.Request.Context()
 	execCancel := func() {}
@@ -269,11 +801,46 @@ func (s *server) execSandbox(c *gin.Context) {
 		execCtx, execCancel = context.WithTimeout(execCtx, time.Duration(*timeoutSeconds)*time.Second)
 	}
 	defer execCancel()
+	if c.Query("ordered") == "true" {
+		var out orderedOutput
+		err := s.execCommandTo(execCtx, ns, podName, "sandbox", req.Command, out.writer("stdout"), out.writer("stderr"))
+		if err != nil {
+			writeError(c, 500, err.Error())
+			return
+		}
+		_ = s.updateLastExec(c.Request.Context(), ns)
+		metricExecs.Add(1)
+		writeJSON(c, 200, api.ExecResponse{Chunks: out.snapshot(), Status: "completed"})
+		return
+	}
+	if tailBytes > 0 {
+		stdout, stderr := newTailWriter(tailBytes), newTailWriter(tailBytes)
+		err := s.execCommandTo(execCtx, ns, podName, "sandbox", req.Command, stdout, stderr)
+		if err != nil {
+			writeError(c, 500, err.Error())
+			return
+		}
+		_ = s.updateLastExec(c.Request.Context(), ns)
+		metricExecs.Add(1)
+		writeJSON(c, 200, api.ExecResponse{
+			Stdout:      stdout.String(),
+			Stderr:      stderr.String(),
+			StdoutBytes: stdout.total,
+			StderrBytes: stderr.total,
+			Truncated:   stdout.truncated() || stderr.truncated(),
+			Status:      "completed",
+		})
+		return
+	}
 	stdout, stderr, err := s.execCommand(execCtx, ns, podName, "sandbox", req.Command)
 	if err != nil {
 		writeError(c, 500, err.Error())
 		return
 	}
+	// Only clean exits are cached; a failure may not repeat.
+	if useCache {
+		s.execCache.put(cacheKey, stdout, stderr)
+	}
 	_ = s.updateLastExec(c.Request.Context(), ns)
 	metricExecs.Add(1)
 	writeJSON(c, 200, api.ExecResponse{Stdout: stdout, Stderr: stderr, Status: "completed"})
@@ -282,32 +849,48 @@ func (s *server) execSandbox(c *gin.Context) {
 func (s *server) getExecStatus(c *gin.Context) {
 	id := c.Param("id")
 	execID := c.Param("exec_id")
-	status, ok := s.execs.get(id, execID)
+	wait, err := parseExecStatusWait(c)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	status, ok := s.lookupExecStatus(c.Request.Context(), id, execID)
 	if !ok {
-		writeError(c, 404, "exec not found")
+		writeErrorCode(c, 404, errCodeExecNotFound, "exec not found")
 		return
 	}
+	if wait > 0 && !isTerminalExecStatus(status.Status) {
+		s.waitExecDone(c.Request.Context(), id, execID, wait)
+		if latest, ok := s.execs.get(id, execID); ok {
+			status = latest
+		}
+	}
+	if c.Query("output") == "true" {
+		status.Stdout, status.Stderr, status.OutputTruncated = s.execs.output(id, execID)
+	}
 	writeJSON(c, 200, status)
 }
 
 func (s *server) cancelExec(c *gin.Context) {
 	id := c.Param("id")
 	execID := c.Param("exec_id")
-	status, found, canceled := s.execs.requestCancel(id, execID)
+	status, found, canceled := s.execs.requestCancel(id, execID, func(pid int) {
+		s.terminateExecProcess(id, pid)
+	})
 	if !found {
-		writeError(c, 404, "exec not found")
+		writeErrorCode(c, 404, errCodeExecNotFound, "exec not found")
 		return
 	}
 	if !canceled && isTerminalExecStatus(status.Status) {
-		writeError(c, 409, "exec is already in terminal state")
+		writeErrorCode(c, 409, errCodeExecFinished, "exec is already in terminal state")
 		return
 	}
 	if !canceled && status.Status == execStatusCanceling {
-		writeError(c, 409, "exec cancel is already in progress")
+		writeErrorCode(c, 409, errCodeExecNotCancelable, "exec cancel is already in progress")
 		return
 	}
 	if !canceled {
-		writeError(c, 409, "exec cannot be canceled")
+		writeErrorCode(c, 409, errCodeExecNotCancelable, "exec cannot be canceled")
 		return
 	}
 	writeJSON(c, 200, status)
@@ -316,13 +899,30 @@ func (s *server) cancelExec(c *gin.Context) {
 func (s *server) deleteSandbox(c *gin.Context) {
 	id := c.Param("id")
 	ns := id
+	if c.Query("force") == "true" {
+		if singleNamespace() != "" {
+			writeError(c, 501, "force delete is not supported with SANDBOX_SINGLE_NAMESPACE")
+			return
+		}
+		s.forceDeleteSandbox(c, ns)
+		return
+	}
+	if c.Query("keep_namespace") == "true" {
+		if singleNamespace() != "" {
+			writeError(c, 501, "keep_namespace is not supported with SANDBOX_SINGLE_NAMESPACE")
+			return
+		}
+		s.deleteSandboxPod(c, ns)
+		return
+	}
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
 	defer cancel()
-	if err := s.client.CoreV1().Namespaces().Delete(ctx, ns, metav1.DeleteOptions{}); err != nil {
+	if err := s.deleteSandboxObjects(ctx, ns); err != nil {
 		writeError(c, 500, err.Error())
 		return
 	}
 	metricDeletes.Add(1)
+	s.execCache.forget(ns)
 	writeJSON(c, 200, map[string]string{"status": "deleted"})
 }
 
@@ -331,63 +931,274 @@ func (s *server) getSandbox(c *gin.Context) {
 	ns := id
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
-	pod, err := s.client.CoreV1().Pods(ns).Get(ctx, "sandbox", metav1.GetOptions{})
+	podNS, podName := sandboxPod(ns, "sandbox")
+	pod, err := s.client.CoreV1().Pods(podNS).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.getPodlessSandbox(ctx, c, ns, err)
+		return
+	}
 	if err != nil {
-		writeError(c, 404, err.Error())
+		writeError(c, 500, err.Error())
 		return
 	}
-	writeJSON(c, 200, map[string]string{
+	resp := map[string]any{
 		"id":        id,
-		"namespace": ns,
+		"namespace": podNS,
 		"pod_name":  pod.Name,
 		"phase":     string(pod.Status.Phase),
-	})
+	}
+	if code, ok := sandboxExitCode(pod); ok {
+		resp["exit_code"] = strconv.Itoa(int(code))
+	}
+	if pod.Status.Reason != "" {
+		// e.g. DeadlineExceeded once active_deadline_seconds has passed.
+		resp["reason"] = pod.Status.Reason
+	}
+	resp["ready"] = strconv.FormatBool(podReady(pod))
+	var pending []string
+	for _, gate := range pod.Spec.ReadinessGates {
+		if !podCondition(pod, gate.ConditionType) {
+			pending = append(pending, string(gate.ConditionType))
+		}
+	}
+	if len(pending) > 0 {
+		resp["readiness_gates_pending"] = strings.Join(pending, ",")
+	}
+	if sandboxStarting(pod) {
+		resp["starting"] = "true"
+	}
+	if status, msg := gitCloneStatus(pod); status != "" {
+		resp["git_clone"] = status
+		if msg != "" {
+			resp["git_clone_message"] = msg
+		}
+	}
+	// Sandbox metadata lives on the namespace, or on the pod in single-namespace
+	// mode.
+	var annotations map[string]string
+	found := false
+	if singleNamespace() != "" {
+		annotations, found = pod.Annotations, true
+	} else if n, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{}); err == nil {
+		annotations, found = n.Annotations, true
+	}
+	if found {
+		resp["ready_at"] = annotationTime(annotations, "sbx.ready_at")
+		if _, ok := annotations["sbx.expires_at"]; ok {
+			resp["expires_at"] = annotationTime(annotations, "sbx.expires_at")
+		}
+		// The hosts resolved at create time, which for a warm-claimed sandbox can
+		// differ from what the request asked for.
+		resp["allowed_hosts"] = hostsAnnotation(annotations, "sbx.allowed_hosts")
+		resp["disallowed_hosts"] = hostsAnnotation(annotations, "sbx.disallowed_hosts")
+	}
+	writeJSON(c, 200, resp)
+}
+
+// hostsAnnotation returns a comma-separated host list annotation as a non-nil
+// slice, so an empty list is sent as [] rather than null.
+func hostsAnnotation(annotations map[string]string, key string) []string {
+	hosts := splitCSV(annotations[key])
+	if hosts == nil {
+		hosts = []string{}
+	}
+	return hosts
+}
+
+// getPodlessSandbox reports a sandbox whose namespace exists without a pod, e.g. a
+// create that failed part way and is being retried, or an archived sandbox.
+func (s *server) getPodlessSandbox(ctx context.Context, c *gin.Context, ns string, podErr error) {
+	if errMsg, ok := s.queuedCreates.get(ns); ok {
+		// A ?queue=true create still waiting for a slot, or one that failed.
+		resp := map[string]string{"id": ns, "namespace": ns, "phase": "queued"}
+		if errMsg != "" {
+			resp["phase"], resp["error"] = "failed", errMsg
+		}
+		writeJSON(c, 200, resp)
+		return
+	}
+	if singleNamespace() != "" {
+		// Without a namespace of its own the pod is the sandbox.
+		writeErrorCode(c, 404, errCodeSandboxNotFound, podErr.Error())
+		return
+	}
+	n, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || !strings.HasPrefix(ns, "sbx-") {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, podErr.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	phase := "provisioning"
+	switch {
+	case n.DeletionTimestamp != nil:
+		phase = "terminating"
+	case isArchived(n):
+		phase = "archived"
+	case n.Annotations[keptAnnotation] != "":
+		phase = "kept"
+	}
+	resp := map[string]string{
+		"id":        ns,
+		"namespace": ns,
+		"phase":     phase,
+	}
+	if kept := n.Annotations[keptAnnotation]; kept != "" {
+		resp["kept_at"] = kept
+	}
+	writeJSON(c, 200, resp)
+}
+
+// namespaceStatus is the GET /sandboxes row for a sandbox namespace.
+func namespaceStatus(ns *corev1.Namespace, now time.Time) api.SandboxStatus {
+	allocated := "true"
+	if ns.Labels != nil && ns.Labels["sbx.allocated"] != "" {
+		allocated = ns.Labels["sbx.allocated"]
+	}
+	age := now.Sub(ns.CreationTimestamp.Time)
+	if age < 0 {
+		age = 0
+	}
+	podNS, _ := sandboxPod(ns.Name, "sandbox")
+	return api.SandboxStatus{
+		ID:           ns.Name,
+		Namespace:    podNS,
+		Age:          formatAge(age),
+		State:        string(ns.Status.Phase),
+		Allocated:    allocated,
+		LastExecTime: annotationTime(ns.Annotations, "sbx.last_exec_at"),
+		ReadyAt:      annotationTime(ns.Annotations, "sbx.ready_at"),
+		Archived:     isArchived(ns),
+	}
 }
 
+// listSandboxes lists sandboxes sorted by id, optionally filtered by a ?selector=
+// on namespace labels and a ?state= namespace phase. With ?limit=N it returns one
+// page as an api.SandboxList; its continue token, passed back as ?continue=, picks
+// up after the last id of the page. ?format=csv or Accept: text/csv returns the
+// same rows as CSV instead.
 func (s *server) listSandboxes(c *gin.Context) {
+	selector, err := labels.Parse(c.Query("selector"))
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "invalid selector: "+err.Error())
+		return
+	}
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			writeErrorCode(c, 400, errCodeInvalidRequest, "limit must be a non-negative integer")
+			return
+		}
+	}
+	after := c.Query("continue")
+	state := c.Query("state")
+	format, err := listFormat(c)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
-	nsList, err := s.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	namespaces, err := s.sandboxNamespaces(ctx, selector)
 	if err != nil {
 		writeError(c, 500, err.Error())
 		return
 	}
+	includeArchived := c.Query("archived") == "true"
 	now := time.Now()
-	statuses := make([]api.SandboxStatus, 0, len(nsList.Items))
-	for _, ns := range nsList.Items {
-		if !strings.HasPrefix(ns.Name, "sbx-") {
+	statuses := make([]api.SandboxStatus, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if !listedSandbox(&ns, state, includeArchived) {
 			continue
 		}
-		allocated := "true"
-		if ns.Labels != nil && ns.Labels["sbx.allocated"] != "" {
-			allocated = ns.Labels["sbx.allocated"]
-		}
-		lastExec := "-"
-		if ts := ns.Annotations["sbx.last_exec_at"]; ts != "" && ts != "0" {
-			if unix, err := strconv.ParseInt(ts, 10, 64); err == nil {
-				lastExec = time.Unix(unix, 0).UTC().Format(time.RFC3339)
-			}
+		if after != "" && ns.Name <= after {
+			continue
 		}
-		age := now.Sub(ns.CreationTimestamp.Time)
-		if age < 0 {
-			age = 0
-		}
-		statuses = append(statuses, api.SandboxStatus{
-			ID:           ns.Name,
-			Namespace:    ns.Name,
-			Age:          formatAge(age),
-			State:        string(ns.Status.Phase),
-			Allocated:    allocated,
-			LastExecTime: lastExec,
-		})
+		statuses = append(statuses, namespaceStatus(&ns, now))
 	}
 	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
-	writeJSON(c, 200, statuses)
+	if format == mimeCSV {
+		next := ""
+		if limit > 0 && len(statuses) > limit {
+			statuses, next = statuses[:limit], statuses[limit-1].ID
+		}
+		writeSandboxesCSV(c, statuses, next)
+		return
+	}
+	if limit == 0 && after == "" {
+		writeJSON(c, 200, statuses)
+		return
+	}
+	page := api.SandboxList{Items: statuses}
+	if limit > 0 && len(statuses) > limit {
+		page.Items = statuses[:limit]
+		page.Continue = statuses[limit-1].ID
+	}
+	writeJSON(c, 200, page)
+}
+
+// listedSandbox reports whether GET /sandboxes lists ns: a sandbox namespace in the
+// requested phase, if any, and not archived unless archived ones were asked for.
+func listedSandbox(ns *corev1.Namespace, state string, includeArchived bool) bool {
+	if !strings.HasPrefix(ns.Name, "sbx-") {
+		return false
+	}
+	if isArchived(ns) && !includeArchived {
+		return false
+	}
+	return state == "" || strings.EqualFold(string(ns.Status.Phase), state)
+}
+
+// countSandboxes counts what listSandboxes would return, from the namespace cache
+// alone, so monitoring can poll it cheaply.
+func (s *server) countSandboxes(c *gin.Context) {
+	selector, err := labels.Parse(c.Query("selector"))
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "invalid selector: "+err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	namespaces, err := s.sandboxNamespaces(ctx, selector)
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	state := c.Query("state")
+	includeArchived := c.Query("archived") == "true"
+	resp := api.SandboxCount{ByState: map[string]int{}}
+	for _, ns := range namespaces {
+		if !listedSandbox(&ns, state, includeArchived) {
+			continue
+		}
+		resp.Total++
+		resp.ByState[string(ns.Status.Phase)]++
+		if isArchived(&ns) {
+			resp.Archived++
+		}
+	}
+	writeJSON(c, 200, resp)
+}
+
+// writeOnceAnnotations keep their first value when a create names an existing sandbox.
+var writeOnceAnnotations = map[string]bool{
+	"sbx.created_at": true,
 }
 
+// errSandboxArchived is returned by ensureNamespace for an archived sandbox's
+// namespace. A create reusing it would keep the archive labels, so the new sandbox
+// would be hidden and then reaped with the archive.
+var errSandboxArchived = errors.New("a sandbox with this id is archived; unarchive or delete it first")
+
 func (s *server) ensureNamespace(ctx context.Context, name string, labels, annotations map[string]string) error {
 	ns, err := s.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
 	if err == nil {
+		if isArchived(ns) {
+			return errSandboxArchived
+		}
 		updated := false
 		if len(labels) > 0 {
 			if ns.Labels == nil {
@@ -405,6 +1216,9 @@ func (s *server) ensureNamespace(ctx context.Context, name string, labels, annot
 				ns.Annotations = map[string]string{}
 			}
 			for k, v := range annotations {
+				if _, ok := ns.Annotations[k]; ok && writeOnceAnnotations[k] {
+					continue
+				}
 				if ns.Annotations[k] != v {
 					ns.Annotations[k] = v
 					updated = true
@@ -455,75 +1269,160 @@ func (s *server) ensurePVC(ctx context.Context, ns, name string) error {
 	return err
 }
 
-func (s *server) ensurePod(ctx context.Context, ns, name, image string, cmd []string, volumeMode, pvcName string, cacheCfg cacheConfig, envVars []corev1.EnvVar, annotations map[string]string) error {
+// ensurePod creates the sandbox pod unless it already exists, reporting whether it
+// created one.
+func (s *server) ensurePod(ctx context.Context, ns, name, image string, cmd []string, volumeMode, pvcName string, cacheCfg cacheConfig, envVars []corev1.EnvVar, annotations map[string]string, podCfg podConfig) (bool, error) {
 	_, err := s.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
 	if err == nil {
-		return nil
+		return false, nil
 	}
 	if !apierrors.IsNotFound(err) {
-		return err
+		return false, err
 	}
 
+	spec, err := applyPodSpecOverlay(sandboxPodSpec(image, cmd, volumeMode, pvcName, cacheCfg, envVars, podCfg), podCfg.overlay)
+	if err != nil {
+		return false, err
+	}
+	podLabels := map[string]string{sandboxPodLabel: "true"}
+	for k, v := range podCfg.labels {
+		podLabels[k] = v
+	}
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        name,
+			Labels:      podLabels,
 			Annotations: annotations,
 		},
-		Spec: sandboxPodSpec(image, cmd, volumeMode, pvcName, cacheCfg, envVars),
+		Spec: spec,
 	}
 	_, err = s.client.CoreV1().Pods(ns).Create(ctx, pod, metav1.CreateOptions{})
-	return err
+	if apierrors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-func (s *server) waitForPodReady(ctx context.Context, ns, name string) error {
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ticker.C:
-			pod, err := s.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
-			if err != nil {
-				return err
-			}
-			if pod.Status.Phase == corev1.PodRunning {
-				for _, cond := range pod.Status.Conditions {
-					if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
-						return nil
-					}
-				}
-			}
+// sandboxExitCode reports the exit code of the sandbox container once the pod has
+// completed. Restarting pods report no exit code.
+func sandboxExitCode(pod *corev1.Pod) (int32, bool) {
+	if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		return 0, false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == "sandbox" && cs.State.Terminated != nil {
+			return cs.State.Terminated.ExitCode, true
 		}
 	}
+	return 0, false
 }
 
 func (s *server) trackReadyAsync(ns, podName string) {
 	go func() {
 		start := time.Now()
-		ctx, cancel := context.WithTimeout(context.Background(), getenvDuration("SANDBOX_CREATE_READY_TIMEOUT", 60*time.Second))
+		timeout := getenvDuration("SANDBOX_CREATE_READY_TIMEOUT", 60*time.Second)
+		// A slow-booting image gets as long as its startup probe allows.
+		getCtx, getCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		podNS, name := sandboxPod(ns, podName)
+		if pod, err := s.client.CoreV1().Pods(podNS).Get(getCtx, name, metav1.GetOptions{}); err == nil && startupProbeBudget(pod) > timeout {
+			timeout = startupProbeBudget(pod)
+		}
+		getCancel()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 		if err := s.waitForPodReady(ctx, ns, podName); err != nil {
 			return
 		}
 		recordCreateReady(time.Since(start).Milliseconds())
+		_ = s.annotateNamespace(ctx, ns, map[string]string{
+			"sbx.ready_at": strconv.FormatInt(time.Now().Unix(), 10),
+		}, false)
 	}()
 }
 
 func (s *server) updateLastExec(ctx context.Context, ns string) error {
-	n, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
-	if err != nil {
+	return s.annotateNamespace(ctx, ns, map[string]string{
+		"sbx.last_exec_at": strconv.FormatInt(time.Now().Unix(), 10),
+	}, true)
+}
+
+// annotateNamespace sets annotations on ns, retrying on update conflicts. Existing
+// values are only replaced when overwrite is true. In single-namespace mode they go
+// on the sandbox pod instead.
+func (s *server) annotateNamespace(ctx context.Context, ns string, annotations map[string]string, overwrite bool) error {
+	if scope := singleNamespace(); scope != "" {
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			pod, err := s.client.CoreV1().Pods(scope).Get(ctx, ns, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if !mergeAnnotations(&pod.Annotations, annotations, overwrite) {
+				return nil
+			}
+			_, err = s.client.CoreV1().Pods(scope).Update(ctx, pod, metav1.UpdateOptions{})
+			return err
+		})
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		n, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !mergeAnnotations(&n.Annotations, annotations, overwrite) {
+			return nil
+		}
+		_, err = s.client.CoreV1().Namespaces().Update(ctx, n, metav1.UpdateOptions{})
 		return err
+	})
+}
+
+// mergeAnnotations sets annotations in *dst, reporting whether anything changed.
+func mergeAnnotations(dst *map[string]string, annotations map[string]string, overwrite bool) bool {
+	if *dst == nil {
+		*dst = map[string]string{}
 	}
-	if n.Annotations == nil {
-		n.Annotations = map[string]string{}
+	changed := false
+	for k, v := range annotations {
+		if cur, ok := (*dst)[k]; ok && (!overwrite || cur == v) {
+			continue
+		}
+		(*dst)[k] = v
+		changed = true
 	}
-	n.Annotations["sbx.last_exec_at"] = strconv.FormatInt(time.Now().Unix(), 10)
-	_, err = s.client.CoreV1().Namespaces().Update(ctx, n, metav1.UpdateOptions{})
-	return err
+	return changed
+}
+
+// annotationTime formats a unix-seconds annotation as RFC3339, or "-" when unset.
+func annotationTime(annotations map[string]string, key string) string {
+	ts := annotations[key]
+	if ts == "" || ts == "0" {
+		return "-"
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "-"
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
 }
 
 func (s *server) execCommand(ctx context.Context, ns, pod, container string, cmd []string) (string, string, error) {
+	var stdout, stderr strings.Builder
+	err := s.execCommandTo(ctx, ns, pod, container, cmd, &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+func (s *server) execCommandTo(ctx context.Context, ns, pod, container string, cmd []string, stdout, stderr io.Writer) error {
+	return s.streamPodExec(ctx, ns, pod, container, cmd, remotecommand.StreamOptions{
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
+func (s *server) streamPodExec(ctx context.Context, ns, pod, container string, cmd []string, opts remotecommand.StreamOptions) error {
+	ns, pod = sandboxPod(ns, pod)
+	if s.podExec != nil {
+		return explainExecError(cmd, s.podExec(ctx, ns, pod, container, cmd, opts))
+	}
 	req := s.client.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(pod).
@@ -532,23 +1431,13 @@ func (s *server) execCommand(ctx context.Context, ns, pod, container string, cmd
 		VersionedParams(&corev1.PodExecOptions{
 			Container: container,
 			Command:   cmd,
-			Stdout:    true,
-			Stderr:    true,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil,
+			TTY:       opts.Tty,
 		}, scheme.ParameterCodec)
 
-	exec, err := remotecommand.NewSPDYExecutor(s.cfg, "POST", req.URL())
-	if err != nil {
-		return "", "", err
-	}
-	var stdout, stderr strings.Builder
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdout: &stdout,
-		Stderr: &stderr,
-	})
-	if err != nil {
-		return stdout.String(), stderr.String(), err
-	}
-	return stdout.String(), stderr.String(), nil
+	return explainExecError(cmd, s.streamExec(ctx, req.URL(), opts))
 }
 
 type streamEventWriter struct {
@@ -562,6 +1451,7 @@ func (w *streamEventWriter) Write(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
+	w.server.execs.appendOutput(w.sandboxID, w.execID, w.stream, p)
 	w.server.stream.publish(execEvent{
 		SandboxID: w.sandboxID,
 		ExecID:    w.execID,
@@ -605,43 +1495,25 @@ func (s *server) execCommandStream(ctx context.Context, ns, pod, container, exec
 	defer func() {
 		_ = s.updateLastExec(context.Background(), ns)
 	}()
-	streamCfg := streamConfigFromEnv()
-
-	req := s.client.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Name(pod).
-		Namespace(ns).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Container: container,
-			Command:   cmd,
-			Stdout:    true,
-			Stderr:    true,
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(s.cfg, "POST", req.URL())
-	if err != nil {
-		s.execs.finish(ns, execID, err)
-		// If exec cannot even start, emit a terminal event so clients don't hang.
-		s.publishExecExit(ns, execID, err)
-		return
-	}
+	// Without a shell the sidecar wrapper can't run, so output is captured here.
+	sidecar := streamConfigFromEnv().sidecarImage != "" && execShell() != ""
 
 	stdoutWriter := io.Discard
 	stderrWriter := io.Discard
-	if streamCfg.sidecarImage == "" {
+	if !sidecar {
 		stdoutWriter = &streamEventWriter{server: s, sandboxID: ns, execID: execID, stream: "stdout"}
 		stderrWriter = &streamEventWriter{server: s, sandboxID: ns, execID: execID, stream: "stderr"}
 	}
 
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+	s.execs.markStarted(ns, execID)
+	err := s.streamPodExec(ctx, ns, pod, container, cmd, remotecommand.StreamOptions{
 		Stdout: stdoutWriter,
 		Stderr: stderrWriter,
 	})
 	s.execs.finish(ns, execID, err)
 	// Sidecar mode publishes output/exit from event files; avoid racing a direct exit
 	// event that can close client streams before sidecar stdout arrives.
-	if streamCfg.sidecarImage == "" {
+	if !sidecar {
 		s.publishExecExit(ns, execID, err)
 	}
 }
@@ -650,37 +1522,101 @@ func (s *server) streamSandbox(c *gin.Context) {
 	id := c.Param("id")
 	ns := id
 	execID := c.Query("exec_id")
+	var grouper *outputGrouper
+	if c.Query("group") == "true" {
+		grouper = newOutputGrouper()
+	}
 	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
-
-	ch, snapshot := s.stream.subscribe(ns)
-	defer s.stream.unsubscribe(ns, ch)
-	for _, evt := range snapshot {
+	// A client watching a sandbox is using it even if it isn't exec-ing.
+	touchCtx, stopTouch := context.WithCancel(context.Background())
+	defer stopTouch()
+	go s.keepAlive(touchCtx, ns, streamTouchInterval())
+
+	var sub *subscriber
+	var snapshot []execEvent
+	if c.Query("reliable") == "true" {
+		sub, snapshot = s.stream.subscribeReliable(ns, getenvDuration("SANDBOX_STREAM_RELIABLE_TIMEOUT", defaultReliableTimeout))
+	} else {
+		sub, snapshot = s.stream.subscribe(ns)
+	}
+	defer s.stream.unsubscribe(ns, sub)
+	send := func(evt execEvent) error {
 		if execID != "" && evt.ExecID != execID {
-			continue
+			return nil
 		}
-		outEvt := evt
 		if id != "" {
-			outEvt.SandboxID = id
+			evt.SandboxID = id
 		}
-		if err := writeEventJSON(conn, outEvt); err != nil {
-			return
+		if grouper != nil {
+			evt = grouper.apply(evt)
 		}
+		return writeEventJSON(conn, evt)
+	}
+	feed := newStreamFeed(sub, snapshot)
+	defer feed.stop()
+
+	cfg := streamConfigFromEnv()
+	throttle := newByteThrottle(cfg.rateBytes)
+	if cfg.statsInterval <= 0 {
+		cfg.statsInterval = 10 * time.Second
+	}
+	stats := time.NewTicker(cfg.statsInterval)
+	defer stats.Stop()
+	gaps := gapReporter{dropped: &sub.dropped}
+	sendGap := func() error {
+		n, ok := gaps.next()
+		if !ok {
+			return nil
+		}
+		return writeEventJSON(conn, execEvent{SandboxID: id, Type: "gap", Dropped: n, Time: nowTS()})
 	}
-	for evt := range ch {
-		if execID != "" && evt.ExecID != execID {
-			continue
+	// stats repeats the total of the gaps sent so far, first reporting any new one,
+	// so it never counts drops the client hasn't seen a gap for.
+	sendStats := func() error {
+		if err := sendGap(); err != nil {
+			return err
 		}
-		outEvt := evt
-		if id != "" {
-			outEvt.SandboxID = id
+		if gaps.total == 0 {
+			return nil
+		}
+		return writeEventJSON(conn, execEvent{SandboxID: id, Type: "stats", Dropped: gaps.total, Time: nowTS()})
+	}
+	for {
+		if err := sendGap(); err != nil {
+			return
+		}
+		evt, ok, closed := feed.next()
+		if !ok {
+			if closed {
+				if sub.wasEvicted() {
+					writeEvictedClose(conn)
+				}
+				return
+			}
+			select {
+			case <-feed.queue.notify:
+			case <-stats.C:
+				if err := sendStats(); err != nil {
+					return
+				}
+			}
+			continue
 		}
-		if err := writeEventJSON(conn, outEvt); err != nil {
+		throttle.wait(len(evt.Data))
+		if err := send(evt); err != nil {
 			return
 		}
+		select {
+		case <-stats.C:
+			if err := sendStats(); err != nil {
+				return
+			}
+		default:
+		}
 	}
 }
 
@@ -701,17 +1637,31 @@ func (s *server) ingestSandbox(c *gin.Context) {
 		if err := json.Unmarshal(msg, &evt); err != nil {
 			continue
 		}
+		// Every exec event is attributed; one without an exec id can't be.
+		if evt.ExecID == "" {
+			log.Printf("ingest %s: dropping %s event without exec_id", ns, evt.Type)
+			continue
+		}
 		evt.SandboxID = ns
 		evt.Seq = s.stream.nextSeq()
 		if evt.Time == "" {
 			evt.Time = nowTS()
 		}
+		if evt.Type == "output" {
+			s.execs.appendOutput(ns, evt.ExecID, evt.Stream, []byte(evt.Data))
+		}
 		s.stream.publish(evt)
 	}
 }
 
+const sandboxNamespacePrefix = "sbx-"
+
+// maxIDLength keeps sbx-<id> within the 63 characters Kubernetes allows in a
+// namespace name.
+const maxIDLength = validation.DNS1123LabelMaxLength - len(sandboxNamespacePrefix)
+
 func sandboxNamespace(id string) string {
-	return "sbx-" + id
+	return sandboxNamespacePrefix + id
 }
 
 func validID(id string) bool {
@@ -723,10 +1673,57 @@ func writeJSON(c *gin.Context, status int, v any) {
 	c.JSON(status, v)
 }
 
+// Machine-readable error codes, sent as "code" next to "error" so clients can tell
+// causes that share a status apart, e.g. a sandbox that doesn't exist from one
+// whose exec doesn't.
+const (
+	errCodeInvalidRequest     = "invalid_request"
+	errCodeSandboxNotFound    = "sandbox_not_found"
+	errCodeSandboxNotReady    = "sandbox_not_ready"
+	errCodeExecNotFound       = "exec_not_found"
+	errCodeExecFinished       = "exec_finished"
+	errCodeExecNotCancelable  = "exec_not_cancelable"
+	errCodeMetricsUnavailable = "metrics_unavailable"
+	errCodeSandboxNotArchived = "sandbox_not_archived"
+	errCodeExecPIDUnknown     = "exec_pid_unknown"
+	errCodeRequestTooLarge    = "request_too_large"
+	errCodeCommandNotAllowed  = "command_not_allowed"
+	errCodeSandboxTerminating = "sandbox_terminating"
+	errCodeDraining           = "draining"
+	errCodeExecLimit          = "exec_limit"
+	errCodeSandboxArchived    = "sandbox_archived"
+)
+
+// writeError writes an error without a code, for failures with no cause a client
+// could act on, such as Kubernetes API errors.
 func writeError(c *gin.Context, status int, msg string) {
+	// Recorded for the audit log.
+	_ = c.Error(errors.New(msg))
 	writeJSON(c, status, map[string]string{"error": msg})
 }
 
+// writeErrorCode writes an error with one of the errCode constants.
+func writeErrorCode(c *gin.Context, status int, code, msg string) {
+	_ = c.Error(errors.New(msg))
+	writeJSON(c, status, map[string]string{"error": msg, "code": code})
+}
+
+// bindJSON decodes the request body into v, writing 413 when the body exceeds the
+// request size limit and 400 for any other decode error.
+func bindJSON(c *gin.Context, v any) bool {
+	err := c.ShouldBindJSON(v)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeErrorCode(c, http.StatusRequestEntityTooLarge, errCodeRequestTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+		return false
+	}
+	writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+	return false
+}
+
 func getenv(key, fallback string) string {
 	if v, ok := configString(key); ok {
 		return v
@@ -797,6 +1794,19 @@ func resolveExecTimeoutSeconds(requested *int) (*int, error) {
 	return timeout, nil
 }
 
+// k8sClientOptions raises client-go's 5 QPS / 10 burst defaults, which throttle the
+// control plane under load.
+func k8sClientOptions() k8s.Options {
+	qps, err := strconv.ParseFloat(getenv("SANDBOX_K8S_QPS", ""), 32)
+	if err != nil || qps <= 0 {
+		qps = defaultK8sQPS
+	}
+	return k8s.Options{
+		QPS:   float32(qps),
+		Burst: getenvInt("SANDBOX_K8S_BURST", defaultK8sBurst),
+	}
+}
+
 func execContext(timeoutSeconds *int) (context.Context, context.CancelFunc) {
 	if timeoutSeconds != nil {
 		return context.WithTimeout(context.Background(), time.Duration(*timeoutSeconds)*time.Second)
@@ -816,6 +1826,24 @@ func requestIDMiddleware() gin.HandlerFunc {
 	}
 }
 
+// maxBodyMiddleware caps request bodies at limit bytes. Declared oversized bodies are
+// rejected up front; others fail with 413 when a handler reads past the limit.
+func maxBodyMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			writeErrorCode(c, http.StatusRequestEntityTooLarge, errCodeRequestTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
 func ginLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -865,12 +1893,15 @@ func formatAge(d time.Duration) string {
 	return fmt.Sprintf("%dd", days)
 }
 
+// shellQuote quotes arg as one word for sh. A word made only of letters, digits
+// and -_./:=@%+, is left bare; anything else, including glob characters, | ; & ( )
+// < > ~ and #, is single-quoted, with embedded single quotes written as '"'"'.
 func shellQuote(arg string) string {
 	if arg == "" {
 		return "''"
 	}
 	if strings.IndexFunc(arg, func(r rune) bool {
-		return r == '\'' || r == '"' || r == '\\' || r == '$' || r == '`' || r == ' ' || r == '\t' || r == '\n'
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
 	}) == -1 {
 		return arg
 	}
@@ -888,23 +1919,56 @@ func shellJoin(args []string) string {
 	return strings.Join(parts, " ")
 }
 
+// runWithPID starts the command in its own process group (set -m) and records the
+// group leader's PID so it can be signaled later. Job control is switched off again
+// before waiting so the shell doesn't print job notices.
+const runWithPID = "set -m; (%s)%s & pid=$!; set +m; echo $pid > %s; wait $pid; code=$?"
+
 func wrapCommandForSidecar(execID string, cmd []string, eventsDir string) []string {
 	escaped := shellJoin(cmd)
 	if eventsDir == "" {
 		eventsDir = "/sbx-events"
 	}
 	script := fmt.Sprintf(
-		"mkdir -p %s; out=%s/%s.stdout; err=%s/%s.stderr; (%s) >$out 2>$err; code=$?; echo $code > %s/%s.exit; exit $code",
+		"mkdir -p %s; out=%s/%s.stdout; err=%s/%s.stderr; "+runWithPID+"; echo $code > %s/%s.exit; exit $code",
 		shellQuote(eventsDir),
 		shellQuote(eventsDir),
 		execID,
 		shellQuote(eventsDir),
 		execID,
 		escaped,
+		" >$out 2>$err",
+		shellQuote(execPIDPath(eventsDir, execID)),
 		shellQuote(eventsDir),
 		execID,
 	)
-	return []string{"bash", "-lc", script}
+	return shellCommand(execShell(), script)
+}
+
+// wrapCommandWithPID is the non-sidecar counterpart of wrapCommandForSidecar: output
+// still goes to the exec streams, only the PID file and the exit code file are
+// written, so the status can be recovered after a control-plane restart. The
+// wrapper is plain POSIX and runs under sh, so images without bash can run async
+// execs; with bash as the exec shell the command itself still runs in a bash login
+// shell when the image has one.
+func wrapCommandWithPID(execID string, cmd []string) []string {
+	pidPath := execPIDPath(execPIDDir, execID)
+	shell, run := execShell(), shellJoin(cmd)
+	if path.Base(shell) == "bash" {
+		login := shellJoin(append(shellCommand(shell, `exec "$@"`), shell)) + " " + run
+		run = fmt.Sprintf("if command -v %s >/dev/null 2>&1; then exec %s; else exec %s; fi", shellQuote(shell), login, run)
+		shell = "sh"
+	}
+	script := fmt.Sprintf(
+		"mkdir -p %s; "+runWithPID+"; echo $code > %s; rm -f %s; exit $code",
+		shellQuote(execPIDDir),
+		run,
+		"",
+		shellQuote(pidPath),
+		shellQuote(execExitPath(execID)),
+		shellQuote(pidPath),
+	)
+	return shellCommand(shell, script)
 }
 
 func sandboxResources() corev1.ResourceRequirements {
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// errExit is the error a pod exec returns for a command that exits non-zero.
+var errExit = errors.New("command terminated with non-zero exit code")
+
+// newTestServer returns a server backed by a fake clientset holding objs.
+func newTestServer(objs ...runtime.Object) *server {
+	client := fake.NewSimpleClientset(objs...)
+	return &server{
+		client:      client,
+		namespaces:  newNamespaceCache(client),
+		stream:      newStreamHub(100),
+		execs:       newExecRegistry(time.Minute, defaultExecOutputTailBytes),
+		creates:     newKeyedMutex(),
+		createSlots: newCreateLimiter(0),
+		idempotency: newIdempotencyStore(time.Hour),
+		execCache:   newExecResultCache(),
+	}
+}
+
+// readyPod is a sandbox pod in ns that is Running and Ready.
+func readyPod(ns string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sandbox", Namespace: ns},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// serve sends a request with body encoded as JSON to h, mounted at route.
+func serve(h gin.HandlerFunc, method, route, path string, body any) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, route, h)
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, &buf))
+	return w
+}
+
+func TestShellQuote(t *testing.T) {
+	for arg, want := range map[string]string{
+		"":                "''",
+		"ls":              "ls",
+		"--out=/tmp/a.b":  "--out=/tmp/a.b",
+		"user@host:1,2+%": "user@host:1,2+%",
+		"a b":             "'a b'",
+		"*.go":            "'*.go'",
+		"a|b":             "'a|b'",
+		"a;b":             "'a;b'",
+		"a&b":             "'a&b'",
+		"~/x":             "'~/x'",
+		"$HOME":           "'$HOME'",
+		"it's":            `'it'"'"'s'`,
+	} {
+		if got := shellQuote(arg); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", arg, got, want)
+		}
+	}
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	args := []string{"", "a b", "*", "a|b;c&d", "$(id)", "`id`", "it's", "~", "#x", "tab\there"}
+	out, err := exec.Command("sh", "-c", "printf '%s\\n' "+shellJoin(args)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); !reflect.DeepEqual(got, args) {
+		t.Errorf("sh saw %q, want %q", got, args)
+	}
+}
+
+func TestWriteErrorCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name  string
+		write func(*gin.Context)
+		want  map[string]string
+	}{
+		{"with code", func(c *gin.Context) { writeErrorCode(c, 409, errCodeSandboxNotReady, "boom") },
+			map[string]string{"error": "boom", "code": "sandbox_not_ready"}},
+		{"without code", func(c *gin.Context) { writeError(c, 500, "boom") },
+			map[string]string{"error": "boom"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		tt.write(c)
+		var body map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: body %q: %v", tt.name, w.Body.String(), err)
+		}
+		if len(body) != len(tt.want) || body["error"] != tt.want["error"] || body["code"] != tt.want["code"] {
+			t.Errorf("%s: body = %v, want %v", tt.name, body, tt.want)
+		}
+	}
+}
+
+func TestTrackReadyAsyncSetsReadyAt(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "demo"}}, readyPod("demo"))
+	s.trackReadyAsync("demo", "sandbox")
+	ctx := context.Background()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ns, err := s.client.CoreV1().Namespaces().Get(ctx, "demo", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts := ns.Annotations["sbx.ready_at"]; ts != "" {
+			if annotationTime(ns.Annotations, "sbx.ready_at") == "-" {
+				t.Fatalf("sbx.ready_at = %q, want unix seconds", ts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sbx.ready_at not set after the pod became ready")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The first ready time is kept.
+	if err := s.annotateNamespace(ctx, "demo", map[string]string{"sbx.ready_at": "1"}, false); err != nil {
+		t.Fatal(err)
+	}
+	ns, _ := s.client.CoreV1().Namespaces().Get(ctx, "demo", metav1.GetOptions{})
+	if ns.Annotations["sbx.ready_at"] == "1" {
+		t.Fatal("sbx.ready_at overwritten")
+	}
+}
+
+func TestEnsureNamespaceKeepsCreatedAt(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "demo",
+		Annotations: map[string]string{"sbx.created_at": "100", "sbx.allowed_hosts": "a.com"},
+	}})
+	ctx := context.Background()
+	err := s.ensureNamespace(ctx, "demo", nil, map[string]string{"sbx.created_at": "200", "sbx.allowed_hosts": "b.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, _ := s.client.CoreV1().Namespaces().Get(ctx, "demo", metav1.GetOptions{})
+	if got := ns.Annotations["sbx.created_at"]; got != "100" {
+		t.Errorf("sbx.created_at = %q, want the original 100", got)
+	}
+	if got := ns.Annotations["sbx.allowed_hosts"]; got != "b.com" {
+		t.Errorf("sbx.allowed_hosts = %q, want it updated to b.com", got)
+	}
+}
+
+func TestCreateRejectsArchivedID(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "sbx-old",
+		Labels:      map[string]string{"sbx.archived": "true"},
+		Annotations: map[string]string{"sbx.archived_at": "100"},
+	}})
+	w := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: "old"})
+	if w.Code != 409 || !strings.Contains(w.Body.String(), errCodeSandboxArchived) {
+		t.Fatalf("create = %d %s, want 409 %s", w.Code, w.Body, errCodeSandboxArchived)
+	}
+	ns, _ := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-old", metav1.GetOptions{})
+	if !isArchived(ns) || ns.Annotations["sbx.archived_at"] != "100" {
+		t.Errorf("namespace = %+v, want the archive left alone", ns.ObjectMeta)
+	}
+	if _, err := s.client.CoreV1().Pods("sbx-old").Get(context.Background(), "sandbox", metav1.GetOptions{}); err == nil {
+		t.Error("pod created in the archived namespace")
+	}
+}
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(maxBodyMiddleware(64))
+	router.POST("/sandboxes", func(c *gin.Context) {
+		var req api.CreateSandboxRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		c.Status(200)
+	})
+	oversized := `{"image": "` + strings.Repeat("x", 128) + `"}`
+
+	for _, tc := range []struct {
+		name    string
+		body    io.Reader
+		status  int
+		errCode string
+	}{
+		{"small", strings.NewReader(`{"image": "busybox"}`), 200, ""},
+		{"declared length", strings.NewReader(oversized), 413, errCodeRequestTooLarge},
+		// A reader of unknown length is sent chunked, so only MaxBytesReader catches it.
+		{"chunked", io.MultiReader(strings.NewReader(oversized)), 413, errCodeRequestTooLarge},
+		{"malformed", strings.NewReader(`{"image":`), 400, errCodeInvalidRequest},
+	} {
+		req := httptest.NewRequest("POST", "/sandboxes", tc.body)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.errCode) {
+			t.Errorf("%s: %d %s, want %d %s", tc.name, w.Code, w.Body.String(), tc.status, tc.errCode)
+		}
+	}
+}
+
+func TestK8sClientOptions(t *testing.T) {
+	if opts := k8sClientOptions(); opts.QPS != defaultK8sQPS || opts.Burst != defaultK8sBurst {
+		t.Errorf("defaults = %+v, want %d/%d", opts, defaultK8sQPS, defaultK8sBurst)
+	}
+	t.Setenv("SANDBOX_K8S_QPS", "80.5")
+	t.Setenv("SANDBOX_K8S_BURST", "160")
+	if opts := k8sClientOptions(); opts.QPS != 80.5 || opts.Burst != 160 {
+		t.Errorf("configured = %+v, want 80.5/160", opts)
+	}
+	t.Setenv("SANDBOX_K8S_QPS", "fast")
+	if opts := k8sClientOptions(); opts.QPS != defaultK8sQPS {
+		t.Errorf("invalid QPS = %g, want the default", opts.QPS)
+	}
+}
+
+func TestExecStatusIncludesOutput(t *testing.T) {
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+		if cmd[0] == "cat" {
+			return errors.New("no pid file")
+		}
+		_, _ = io.WriteString(opts.Stdout, "result: 42\n")
+		_, _ = io.WriteString(opts.Stderr, "warning\n")
+		return nil
+	}
+	w := serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec", api.ExecRequest{Command: []string{"echo"}})
+	if w.Code != 200 {
+		t.Fatalf("exec: %d %s", w.Code, w.Body.String())
+	}
+	var resp api.ExecResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	waitExecStatus(t, s, "sbx-a", resp.ExecID)
+
+	path := "/sandboxes/sbx-a/execs/" + resp.ExecID
+	var st api.ExecStatusResponse
+	w = serve(s.getExecStatus, "GET", "/sandboxes/:id/execs/:exec_id", path, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Stdout != "" || st.Stderr != "" {
+		t.Fatalf("output returned without ?output=true: %+v", st)
+	}
+	w = serve(s.getExecStatus, "GET", "/sandboxes/:id/execs/:exec_id", path+"?output=true", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != execStatusCompleted || st.Stdout != "result: 42\n" || st.Stderr != "warning\n" || st.OutputTruncated {
+		t.Fatalf("status = %+v, want completed with captured output", st)
+	}
+}
+
+func TestGetSandboxWithoutPod(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}})
+	w := serve(s.getSandbox, "GET", "/sandboxes/:id", "/sandboxes/sbx-a", nil)
+	if w.Code != 200 {
+		t.Fatalf("namespace without pod: status = %d body %s, want 200", w.Code, w.Body)
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["phase"] != "provisioning" {
+		t.Errorf("phase = %q, want provisioning", resp["phase"])
+	}
+
+	w = serve(s.getSandbox, "GET", "/sandboxes/:id", "/sandboxes/sbx-missing", nil)
+	if w.Code != 404 || !strings.Contains(w.Body.String(), errCodeSandboxNotFound) {
+		t.Errorf("missing sandbox: status = %d body %s, want 404 %s", w.Code, w.Body, errCodeSandboxNotFound)
+	}
+}
+
+func TestListSandboxesPaged(t *testing.T) {
+	var objs []runtime.Object
+	for _, name := range []string{"sbx-a", "sbx-b", "sbx-c", "sbx-d"} {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive}}
+		if name == "sbx-c" {
+			ns.Labels = map[string]string{"team": "ci"}
+			ns.Status.Phase = corev1.NamespaceTerminating
+		}
+		objs = append(objs, ns)
+	}
+	s := newTestServer(objs...)
+	list := func(query string) api.SandboxList {
+		t.Helper()
+		w := serve(s.listSandboxes, "GET", "/sandboxes", "/sandboxes?"+query, nil)
+		if w.Code != 200 {
+			t.Fatalf("%s: status %d: %s", query, w.Code, w.Body)
+		}
+		var page api.SandboxList
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return page
+	}
+	ids := func(page api.SandboxList) string {
+		var out []string
+		for _, st := range page.Items {
+			out = append(out, st.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	page := list("limit=3")
+	if ids(page) != "sbx-a,sbx-b,sbx-c" || page.Continue != "sbx-c" {
+		t.Errorf("first page = %s (continue %q)", ids(page), page.Continue)
+	}
+	page = list("limit=3&continue=" + page.Continue)
+	if ids(page) != "sbx-d" || page.Continue != "" {
+		t.Errorf("last page = %s (continue %q)", ids(page), page.Continue)
+	}
+	if page := list("limit=10&selector=team%3Dci"); ids(page) != "sbx-c" {
+		t.Errorf("selector page = %s, want sbx-c", ids(page))
+	}
+	if page := list("limit=10&state=active"); ids(page) != "sbx-a,sbx-b,sbx-d" {
+		t.Errorf("state page = %s, want the active sandboxes", ids(page))
+	}
+
+	// Without a limit the response stays a bare array.
+	w := serve(s.listSandboxes, "GET", "/sandboxes", "/sandboxes", nil)
+	var all []api.SandboxStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil || len(all) != 4 {
+		t.Errorf("unpaged list = %s (%v), want an array of 4", w.Body, err)
+	}
+	if w := serve(s.listSandboxes, "GET", "/sandboxes", "/sandboxes?limit=-1", nil); w.Code != 400 {
+		t.Errorf("limit=-1: status %d, want 400", w.Code)
+	}
+}
+
+func TestCountSandboxes(t *testing.T) {
+	ns := func(name string, phase corev1.NamespacePhase, labels map[string]string) runtime.Object {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}, Status: corev1.NamespaceStatus{Phase: phase}}
+	}
+	s := newTestServer(
+		ns("sbx-a", corev1.NamespaceActive, nil),
+		ns("sbx-b", corev1.NamespaceActive, map[string]string{"team": "ci"}),
+		ns("sbx-c", corev1.NamespaceTerminating, map[string]string{"team": "ci"}),
+		ns("sbx-d", corev1.NamespaceActive, map[string]string{"sbx.archived": "true"}),
+		// Not a sandbox.
+		ns("kube-system", corev1.NamespaceActive, nil),
+	)
+	count := func(query string) api.SandboxCount {
+		t.Helper()
+		w := serve(s.countSandboxes, "GET", "/sandboxes/count", "/sandboxes/count?"+query, nil)
+		if w.Code != 200 {
+			t.Fatalf("%s: status %d: %s", query, w.Code, w.Body)
+		}
+		var resp api.SandboxCount
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return resp
+	}
+	tests := []struct {
+		query   string
+		want    api.SandboxCount
+		listLen int
+	}{
+		{"", api.SandboxCount{Total: 3, ByState: map[string]int{"Active": 2, "Terminating": 1}}, 3},
+		{"selector=team%3Dci", api.SandboxCount{Total: 2, ByState: map[string]int{"Active": 1, "Terminating": 1}}, 2},
+		{"state=active", api.SandboxCount{Total: 2, ByState: map[string]int{"Active": 2}}, 2},
+		{"archived=true", api.SandboxCount{Total: 4, ByState: map[string]int{"Active": 3, "Terminating": 1}, Archived: 1}, 4},
+	}
+	for _, tt := range tests {
+		if got := count(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: count = %+v, want %+v", tt.query, got, tt.want)
+		}
+		// The count agrees with the list it stands in for.
+		var all []api.SandboxStatus
+		w := serve(s.listSandboxes, "GET", "/sandboxes", "/sandboxes?"+tt.query, nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil || len(all) != tt.listLen {
+			t.Errorf("%q: list = %s (%v), want %d items", tt.query, w.Body, err, tt.listLen)
+		}
+	}
+	if w := serve(s.countSandboxes, "GET", "/sandboxes/count", "/sandboxes/count?selector=%3D%3D", nil); w.Code != 400 {
+		t.Errorf("bad selector: status %d, want 400", w.Code)
+	}
+}
+
+func TestCreateIDLengthLimit(t *testing.T) {
+	s := newTestServer()
+	create := func(id string) *httptest.ResponseRecorder {
+		return serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: id})
+	}
+	longest := strings.Repeat("a", maxIDLength)
+	if w := create(longest); w.Code != 200 {
+		t.Fatalf("%d-char id: status %d: %s", len(longest), w.Code, w.Body)
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), sandboxNamespace(longest), metav1.GetOptions{}); err != nil {
+		t.Fatalf("namespace for the longest id: %v", err)
+	}
+	if got := len(sandboxNamespace(longest)); got != 63 {
+		t.Errorf("namespace length = %d, want 63", got)
+	}
+	w := create(longest + "a")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "at most 59") {
+		t.Errorf("%d-char id: status %d: %s, want 400 naming the limit", len(longest)+1, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sandboxManifest returns a create request that recreates the sandbox: its
+// image, command, env, mounts and pod settings as read back from the live pod.
+// Secret-looking env values are redacted and env from valueFrom references is
+// left out, as are the SANDBOX_DEFAULT_TOLERATIONS every pod gets and settings the
+// pod doesn't record (git_repo, topology spread, pod_spec_overlay, expires_at).
+// ?format=yaml returns YAML instead of JSON.
+func (s *server) sandboxManifest(c *gin.Context) {
+	id := c.Param("id")
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "yaml" {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "format must be json or yaml")
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	podNS, podName := sandboxPod(id, "sandbox")
+	pod, err := s.client.CoreV1().Pods(podNS).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	// The sandbox's sbx.* settings are on its namespace, or on the pod in
+	// single-namespace mode.
+	annotations := pod.Annotations
+	if podNS == id {
+		ns, err := s.client.CoreV1().Namespaces().Get(ctx, id, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			writeError(c, 500, err.Error())
+			return
+		}
+		if err == nil {
+			annotations = ns.Annotations
+		}
+	}
+	req, err := s.manifestFromPod(ctx, id, pod, annotations)
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	if format == "json" {
+		writeJSON(c, 200, req)
+		return
+	}
+	out, err := manifestYAML(req)
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	c.Data(200, "application/yaml", out)
+}
+
+func (s *server) manifestFromPod(ctx context.Context, id string, pod *corev1.Pod, annotations map[string]string) (api.CreateSandboxRequest, error) {
+	req := api.CreateSandboxRequest{ID: strings.TrimPrefix(id, sandboxNamespacePrefix)}
+	spec := pod.Spec
+	var ctr *corev1.Container
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == "sandbox" {
+			ctr = &spec.Containers[i]
+		}
+	}
+	if ctr == nil {
+		return req, fmt.Errorf("pod %s/%s has no sandbox container", pod.Namespace, pod.Name)
+	}
+	req.Image = ctr.Image
+	if !reflect.DeepEqual(ctr.Command, []string{"sleep", "infinity"}) || len(ctr.Args) > 0 {
+		req.Command = ctr.Command
+	}
+	req.Args = ctr.Args
+	req.StartupProbe = requestStartupProbe(ctr.StartupProbe)
+
+	// Hosts come back from the annotations; the env copies are derived from them.
+	for _, ev := range ctr.Env {
+		switch {
+		case ev.ValueFrom != nil, ev.Name == "SBX_ALLOWED_HOSTS", ev.Name == "SBX_DISALLOWED_HOSTS":
+			continue
+		}
+		if req.Env == nil {
+			req.Env = map[string]string{}
+		}
+		if isSecretKey(ev.Name) && ev.Value != "" {
+			req.Env[ev.Name] = redacted
+		} else {
+			req.Env[ev.Name] = ev.Value
+		}
+	}
+	for _, src := range ctr.EnvFrom {
+		switch {
+		case src.ConfigMapRef != nil:
+			req.EnvFromConfigMap = append(req.EnvFromConfigMap, src.ConfigMapRef.Name)
+		case src.SecretRef != nil:
+			req.EnvFromSecret = append(req.EnvFromSecret, src.SecretRef.Name)
+		}
+	}
+	req.AllowedHosts = splitCSV(annotations["sbx.allowed_hosts"])
+	// The forced denylist is added again on create.
+	forced := splitCSV(getenv("SANDBOX_FORCE_DISALLOWED_HOSTS", ""))
+	for _, h := range splitCSV(annotations["sbx.disallowed_hosts"]) {
+		if !containsFold(forced, h) {
+			req.DisallowedHosts = append(req.DisallowedHosts, h)
+		}
+	}
+	req.IdleTTL = annotations["sbx.idle_ttl"]
+
+	if err := s.manifestVolumes(ctx, pod, ctr, &req); err != nil {
+		return req, err
+	}
+
+	req.ServiceAccountName = spec.ServiceAccountName
+	req.AutomountServiceAccountToken = spec.AutomountServiceAccountToken
+	req.PriorityClassName = spec.PriorityClassName
+	req.RestartPolicy = string(spec.RestartPolicy)
+	req.Hostname = spec.Hostname
+	req.Subdomain = spec.Subdomain
+	req.ShareProcessNamespace = spec.ShareProcessNamespace
+	req.ActiveDeadlineSeconds = spec.ActiveDeadlineSeconds
+	req.Tolerations = requestTolerations(spec.Tolerations)
+	for _, gate := range spec.ReadinessGates {
+		req.ReadinessGates = append(req.ReadinessGates, string(gate.ConditionType))
+	}
+	req.DNSPolicy = string(spec.DNSPolicy)
+	if spec.DNSConfig != nil {
+		req.DNSServers = spec.DNSConfig.Nameservers
+	}
+	if port, err := strconv.Atoi(pod.Annotations["prometheus.io/port"]); err == nil && pod.Labels[metricsLabel] != "" {
+		req.Metrics = &api.Metrics{Port: int32(port), Path: pod.Annotations["prometheus.io/path"]}
+	}
+	return req, nil
+}
+
+// requestTolerations returns the pod's tolerations a create asked for, leaving out
+// the SANDBOX_DEFAULT_TOLERATIONS that are added again on create.
+func requestTolerations(tolerations []corev1.Toleration) []api.Toleration {
+	var defaults []corev1.Toleration
+	if getenvBool("SANDBOX_DEFAULT_TOLERATIONS", true) {
+		defaults = defaultTolerations()
+	}
+	var out []api.Toleration
+	for _, t := range tolerations {
+		if slices.ContainsFunc(defaults, func(d corev1.Toleration) bool { return d.MatchToleration(&t) }) {
+			continue
+		}
+		out = append(out, api.Toleration{
+			Key:      t.Key,
+			Operator: string(t.Operator),
+			Value:    t.Value,
+			Effect:   string(t.Effect),
+		})
+	}
+	return out
+}
+
+// manifestVolumes fills in the volume and cache modes, mount paths and extra
+// volumes of req from the sandbox container's mounts.
+func (s *server) manifestVolumes(ctx context.Context, pod *corev1.Pod, ctr *corev1.Container, req *api.CreateSandboxRequest) error {
+	volumes := map[string]corev1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+	for _, m := range ctr.VolumeMounts {
+		v, ok := volumes[m.Name]
+		if !ok {
+			continue
+		}
+		switch m.Name {
+		case "workspace":
+			req.VolumeMode = "emptydir"
+			if v.PersistentVolumeClaim != nil {
+				req.VolumeMode = "pvc"
+			}
+			if m.MountPath != defaultWorkspacePath {
+				req.WorkspacePath = m.MountPath
+			}
+		case "cache":
+			if err := s.manifestCache(ctx, pod.Namespace, v, req); err != nil {
+				return err
+			}
+			if m.MountPath != defaultCachePath {
+				req.CachePath = m.MountPath
+			}
+		case "sbx-events":
+			// The stream sidecar's, added by the control plane.
+		default:
+			spec := api.VolumeSpec{Name: m.Name, MountPath: m.MountPath, ReadOnly: m.ReadOnly}
+			switch {
+			case v.PersistentVolumeClaim != nil:
+				spec.Type, spec.ClaimName = "pvc", v.PersistentVolumeClaim.ClaimName
+			case v.EmptyDir != nil:
+				spec.Type = "emptydir"
+				if v.EmptyDir.SizeLimit != nil {
+					spec.SizeLimit = v.EmptyDir.SizeLimit.String()
+				}
+			default:
+				// Not something a create request can ask for, e.g. from an overlay.
+				continue
+			}
+			req.Volumes = append(req.Volumes, spec)
+		}
+	}
+	return nil
+}
+
+// manifestCache sets the cache mode of req, and for a cache claim of its own the
+// claim's size, class and access mode.
+func (s *server) manifestCache(ctx context.Context, ns string, v corev1.Volume, req *api.CreateSandboxRequest) error {
+	switch {
+	case v.HostPath != nil:
+		req.CacheMode = "hostpath"
+		return nil
+	case v.PersistentVolumeClaim == nil:
+		req.CacheMode = "emptydir"
+		return nil
+	}
+	pvc, err := s.client.CoreV1().PersistentVolumeClaims(ns).Get(ctx, v.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		req.CacheMode = "pvc"
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pvc.Spec.VolumeName == sharedCacheVolumeName(ns) {
+		req.CacheMode = "shared-pvc"
+		return nil
+	}
+	req.CacheMode = "pvc"
+	if size, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		req.CachePVCSize = size.String()
+	}
+	if pvc.Spec.StorageClassName != nil {
+		req.CachePVCStorageClass = *pvc.Spec.StorageClassName
+	}
+	if len(pvc.Spec.AccessModes) > 0 {
+		req.CachePVCAccessMode = string(pvc.Spec.AccessModes[0])
+	}
+	return nil
+}
+
+// manifestYAML renders req as YAML with the same field names as its JSON.
+func manifestYAML(req api.CreateSandboxRequest) ([]byte, error) {
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
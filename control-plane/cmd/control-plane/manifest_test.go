@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSandboxManifestRoundTrip(t *testing.T) {
+	s := newTestServer()
+	orig := api.CreateSandboxRequest{
+		ID:            "a",
+		Image:         "python:3.12",
+		Command:       []string{"python", "-m", "http.server"},
+		Env:           map[string]string{"API_TOKEN": "s3cret", "LOG_LEVEL": "debug"},
+		AllowedHosts:  []string{"pypi.org"},
+		IdleTTL:       "2h",
+		WorkspacePath: "/src",
+		Volumes:       []api.VolumeSpec{{Name: "scratch", Type: "emptydir", MountPath: "/scratch", SizeLimit: "1Gi"}},
+		StartupProbe:  &api.StartupProbe{HTTPPath: "/healthz", Port: 8000, PeriodSeconds: 10},
+		Tolerations:   []api.Toleration{{Key: "gpu", Operator: "Exists", Effect: "NoSchedule"}},
+	}
+	if w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", orig); w.Code != 200 {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+
+	w := serve(s.sandboxManifest, http.MethodGet, "/sandboxes/:id/manifest", "/sandboxes/sbx-a/manifest", nil)
+	if w.Code != 200 {
+		t.Fatalf("manifest: status %d: %s", w.Code, w.Body)
+	}
+	var got api.CreateSandboxRequest
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "a" || got.Image != orig.Image || !reflect.DeepEqual(got.Command, orig.Command) {
+		t.Errorf("id, image, command = %q, %q, %v", got.ID, got.Image, got.Command)
+	}
+	if got.Env["API_TOKEN"] != redacted || got.Env["LOG_LEVEL"] != "debug" {
+		t.Errorf("env = %v, want API_TOKEN redacted and LOG_LEVEL kept", got.Env)
+	}
+	if !reflect.DeepEqual(got.AllowedHosts, orig.AllowedHosts) || got.IdleTTL != "2h0m0s" || got.WorkspacePath != "/src" {
+		t.Errorf("hosts, idle ttl, workspace = %v, %q, %q", got.AllowedHosts, got.IdleTTL, got.WorkspacePath)
+	}
+	if !reflect.DeepEqual(got.Volumes, orig.Volumes) {
+		t.Errorf("volumes = %+v, want %+v", got.Volumes, orig.Volumes)
+	}
+	if !reflect.DeepEqual(got.StartupProbe, orig.StartupProbe) {
+		t.Errorf("startup probe = %+v, want %+v", got.StartupProbe, orig.StartupProbe)
+	}
+	// The default tolerations are on the pod too, but come back on any create.
+	if !reflect.DeepEqual(got.Tolerations, orig.Tolerations) {
+		t.Errorf("tolerations = %+v, want %+v", got.Tolerations, orig.Tolerations)
+	}
+
+	// Recreated under another id with the secret filled back in, the sandbox
+	// container comes out the same.
+	got.ID = "b"
+	got.Env["API_TOKEN"] = "s3cret"
+	if w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", got); w.Code != 200 {
+		t.Fatalf("create from manifest: status %d: %s", w.Code, w.Body)
+	}
+	pod := func(ns string) *corev1.Pod {
+		pod, err := s.client.CoreV1().Pods(ns).Get(context.Background(), "sandbox", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pod
+	}
+	container := func(ns string) corev1.Container {
+		for _, ctr := range pod(ns).Spec.Containers {
+			if ctr.Name == "sandbox" {
+				return ctr
+			}
+		}
+		t.Fatalf("pod in %s has no sandbox container", ns)
+		return corev1.Container{}
+	}
+	if a, b := container("sbx-a"), container("sbx-b"); !sameJSON(t, &a, &b) {
+		t.Errorf("recreated container differs:\ngot  %s\nwant %s", mustJSON(t, &b), mustJSON(t, &a))
+	}
+	if a, b := pod("sbx-a").Spec.Tolerations, pod("sbx-b").Spec.Tolerations; !reflect.DeepEqual(a, b) {
+		t.Errorf("recreated tolerations = %+v, want %+v", b, a)
+	}
+
+	w = serve(s.sandboxManifest, http.MethodGet, "/sandboxes/:id/manifest", "/sandboxes/sbx-a/manifest?format=yaml", nil)
+	if w.Code != 200 {
+		t.Fatalf("yaml manifest: status %d: %s", w.Code, w.Body)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["image"] != "python:3.12" || doc["idle_ttl"] != "2h0m0s" {
+		t.Errorf("yaml manifest = %v", doc)
+	}
+}
+
+func TestSandboxManifestErrors(t *testing.T) {
+	s := newTestServer()
+	w := serve(s.sandboxManifest, http.MethodGet, "/sandboxes/:id/manifest", "/sandboxes/sbx-missing/manifest", nil)
+	if w.Code != 404 || !strings.Contains(w.Body.String(), errCodeSandboxNotFound) {
+		t.Errorf("missing sandbox: status %d: %s", w.Code, w.Body)
+	}
+	w = serve(s.sandboxManifest, http.MethodGet, "/sandboxes/:id/manifest", "/sandboxes/sbx-a/manifest?format=toml", nil)
+	if w.Code != 400 {
+		t.Errorf("bad format: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// patchSandbox sets or removes labels and annotations on a sandbox namespace, e.g. to
+// tag it with a PR number discovered after create. A null value removes the key.
+// Keys under sbx. are managed by the control plane and can't be changed here. The
+// response is the sandbox's status as GET /sandboxes lists it, with the labels and
+// annotations after the change.
+func (s *server) patchSandbox(c *gin.Context) {
+	id := c.Param("id")
+	if !isSandboxNamespace(id) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox not found")
+		return
+	}
+	var req api.UpdateSandboxRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if len(req.Labels) == 0 && len(req.Annotations) == 0 {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "labels or annotations is required")
+		return
+	}
+	if err := validateMetadataPatch(req); err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	var ns *corev1.Namespace
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		ns, err = s.client.CoreV1().Namespaces().Get(ctx, id, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		// Unclaimed warm namespaces are not sandboxes yet.
+		if ns.Labels["sbx.allocated"] == "false" {
+			return apierrors.NewNotFound(corev1.Resource("namespaces"), id)
+		}
+		changed := applyMetadataPatch(&ns.Labels, req.Labels)
+		if applyMetadataPatch(&ns.Annotations, req.Annotations) {
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		ns, err = s.client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox not found")
+		return
+	}
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	writeJSON(c, 200, api.UpdateSandboxResponse{
+		SandboxStatus: namespaceStatus(ns, time.Now()),
+		Labels:        ns.Labels,
+		Annotations:   ns.Annotations,
+	})
+}
+
+func isSandboxNamespace(name string) bool {
+	return strings.HasPrefix(name, "sbx-")
+}
+
+func validateMetadataPatch(req api.UpdateSandboxRequest) error {
+	for kind, patch := range map[string]map[string]*string{"label": req.Labels, "annotation": req.Annotations} {
+		for k, v := range patch {
+			if strings.HasPrefix(k, "sbx.") {
+				return fmt.Errorf("%s %q is reserved for the control plane", kind, k)
+			}
+			if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+				return fmt.Errorf("%s key %q is invalid: %s", kind, k, strings.Join(errs, "; "))
+			}
+			if kind == "label" && v != nil {
+				if errs := validation.IsValidLabelValue(*v); len(errs) > 0 {
+					return fmt.Errorf("label %q value is invalid: %s", k, strings.Join(errs, "; "))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyMetadataPatch applies patch to *m, removing keys with a nil value, and
+// reports whether anything changed.
+func applyMetadataPatch(m *map[string]string, patch map[string]*string) bool {
+	changed := false
+	for k, v := range patch {
+		old, ok := (*m)[k]
+		if v == nil {
+			if ok {
+				delete(*m, k)
+				changed = true
+			}
+			continue
+		}
+		if !ok || old != *v {
+			if *m == nil {
+				*m = map[string]string{}
+			}
+			(*m)[k] = *v
+			changed = true
+		}
+	}
+	return changed
+}
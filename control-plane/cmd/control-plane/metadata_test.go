@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPatchSandboxLabels(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "sbx-a",
+			Labels:      map[string]string{"sbx.allocated": "true", "stale": "yes"},
+			Annotations: map[string]string{"sbx.ready_at": "2026-01-02T03:04:05Z"},
+		},
+		Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+	})
+	pr := "1234"
+	w := serve(s.patchSandbox, "PATCH", "/sandboxes/:id", "/sandboxes/sbx-a", api.UpdateSandboxRequest{
+		Labels:      map[string]*string{"pr": &pr, "stale": nil},
+		Annotations: map[string]*string{"example.com/owner": &pr},
+	})
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var resp api.UpdateSandboxResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "sbx-a" || resp.Namespace != "sbx-a" || resp.State != "Active" || resp.Allocated != "true" || resp.ReadyAt == "" {
+		t.Errorf("status = %+v, want the sandbox's status fields", resp.SandboxStatus)
+	}
+	if resp.Labels["pr"] != "1234" || resp.Annotations["example.com/owner"] != "1234" {
+		t.Errorf("response = %+v, want the new label and annotation", resp)
+	}
+	ns, _ := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-a", metav1.GetOptions{})
+	if _, ok := ns.Labels["stale"]; ok || ns.Labels["pr"] != "1234" || ns.Labels["sbx.allocated"] != "true" {
+		t.Errorf("namespace labels = %v, want pr set, stale removed and sbx.allocated kept", ns.Labels)
+	}
+}
+
+func TestPatchSandboxProtectsInternalKeys(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "sbx-a",
+		Labels: map[string]string{"sbx.allocated": "true"},
+	}})
+	f := "false"
+	for _, req := range []api.UpdateSandboxRequest{
+		{Labels: map[string]*string{"sbx.allocated": &f}},
+		{Labels: map[string]*string{"sbx.allocated": nil}},
+		{Annotations: map[string]*string{"sbx.claimed-at": &f}},
+	} {
+		w := serve(s.patchSandbox, "PATCH", "/sandboxes/:id", "/sandboxes/sbx-a", req)
+		if w.Code != 400 || !strings.Contains(w.Body.String(), "reserved") {
+			t.Errorf("%+v: status = %d body %s, want 400 reserved", req, w.Code, w.Body)
+		}
+	}
+	ns, _ := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-a", metav1.GetOptions{})
+	if ns.Labels["sbx.allocated"] != "true" {
+		t.Errorf("sbx.allocated = %q, want it untouched", ns.Labels["sbx.allocated"])
+	}
+}
+
+func TestPatchSandboxNotFound(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "sbx-warm",
+		Labels: map[string]string{"sbx.allocated": "false"},
+	}})
+	v := "x"
+	for _, id := range []string{"sbx-missing", "sbx-warm", "kube-system"} {
+		w := serve(s.patchSandbox, "PATCH", "/sandboxes/:id", "/sandboxes/"+id, api.UpdateSandboxRequest{Labels: map[string]*string{"k": &v}})
+		if w.Code != 404 || !strings.Contains(w.Body.String(), errCodeSandboxNotFound) {
+			t.Errorf("%s: status = %d body %s, want 404 %s", id, w.Code, w.Body, errCodeSandboxNotFound)
+		}
+	}
+}
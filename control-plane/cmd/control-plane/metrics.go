@@ -6,21 +6,36 @@ import (
 )
 
 var (
-	metricCreates         = expvar.NewInt("sandbox_create_total")
-	metricCreateWarmHit   = expvar.NewInt("sandbox_create_warm_hit_total")
-	metricCreateCold      = expvar.NewInt("sandbox_create_cold_total")
-	metricExecs           = expvar.NewInt("sandbox_exec_total")
-	metricDeletes         = expvar.NewInt("sandbox_delete_total")
-	metricWarmPoolDesired = expvar.NewInt("warm_pool_desired")
-	metricWarmPoolReady   = expvar.NewInt("warm_pool_ready")
-	metricCacheMode       = expvar.NewString("sandbox_cache_mode")
-	metricStreamBuffer    = expvar.NewInt("sandbox_stream_buffer")
-	createReadyTotalMs    int64
-	createReadyCount      int64
-	createReadyLastMs     int64
+	metricCreates              = expvar.NewInt("sandbox_create_total")
+	metricCreateWarmHit        = expvar.NewInt("sandbox_create_warm_hit_total")
+	metricCreateCold           = expvar.NewInt("sandbox_create_cold_total")
+	metricExecs                = expvar.NewInt("sandbox_exec_total")
+	metricExecCacheHits        = expvar.NewInt("sandbox_exec_cache_hit_total")
+	metricDeletes              = expvar.NewInt("sandbox_delete_total")
+	metricWarmPoolDesired      = expvar.NewInt("warm_pool_desired")
+	metricWarmPoolReady        = expvar.NewInt("warm_pool_ready")
+	metricWarmPoolCreateErrors = expvar.NewInt("warm_pool_create_errors_total")
+	metricWarmVerifyFailures   = expvar.NewInt("warm_pool_verify_failures_total")
+	metricWarmPoolHealthy      = expvar.NewInt("warm_pool_healthy")
+	metricCacheMode            = expvar.NewString("sandbox_cache_mode")
+	metricStreamBuffer         = expvar.NewInt("sandbox_stream_buffer")
+	metricStreamDropped        = expvar.NewInt("sandbox_stream_dropped_total")
+	metricDraining             = expvar.NewInt("sandbox_draining")
+	metricStreamEvicted        = expvar.NewInt("sandbox_stream_reliable_evicted_total")
+	metricCreateQueueDepth     = expvar.NewInt("sandbox_create_queue_depth")
+	metricExecQueueDepth       = expvar.NewInt("sandbox_exec_queue_depth")
+	metricOrphansReaped        = expvar.NewInt("sandbox_orphans_reaped_total")
+	metricExecOutcome          = expvar.NewMap("sandbox_exec_outcome_total")
+	createReadyTotalMs         int64
+	createReadyCount           int64
+	createReadyLastMs          int64
 )
 
 func init() {
+	// Publish every outcome from the start so rates don't begin at a missing series.
+	for _, status := range []string{execStatusCompleted, execStatusFailed, execStatusCanceled, execStatusTimedOut} {
+		metricExecOutcome.Add(status, 0)
+	}
 	expvar.Publish("sandbox_create_ready_ms_avg", expvar.Func(func() any {
 		cnt := atomic.LoadInt64(&createReadyCount)
 		if cnt == 0 {
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// metricsLabel marks a sandbox pod that serves app metrics, and its Service.
+	// The value is the pod name, so in single-namespace mode each Service selects
+	// only its own sandbox.
+	metricsLabel       = "sbx.metrics"
+	defaultMetricsPath = "/metrics"
+)
+
+func validateMetrics(m *api.Metrics) error {
+	if m == nil {
+		return nil
+	}
+	if m.Port < 1 || m.Port > 65535 {
+		return fmt.Errorf("metrics port must be between 1 and 65535")
+	}
+	if m.Path != "" && !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("metrics path must start with /")
+	}
+	return nil
+}
+
+func metricsPath(m *api.Metrics) string {
+	if m.Path == "" {
+		return defaultMetricsPath
+	}
+	return m.Path
+}
+
+// metricsServiceName is the Service in front of a sandbox's metrics: sandbox-metrics
+// in its own namespace, <id>-metrics in single-namespace mode.
+func metricsServiceName(podName string) string {
+	return podName + "-metrics"
+}
+
+// metricsLabels are the labels a sandbox serving metrics puts on its pod and
+// Service: sbx.metrics plus SANDBOX_METRICS_LABELS, for a ServiceMonitor or
+// PodMonitor to select.
+func metricsLabels(podName string) map[string]string {
+	return withWarmMetadata(map[string]string{metricsLabel: podName}, warmMetadata("SANDBOX_METRICS_LABELS"))
+}
+
+// metricsAnnotations are the prometheus.io annotations understood by annotation-
+// based scrape configs.
+func metricsAnnotations(m *api.Metrics) map[string]string {
+	return map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   strconv.Itoa(int(m.Port)),
+		"prometheus.io/path":   metricsPath(m),
+	}
+}
+
+// ensureMetricsService creates the Service a ServiceMonitor scrapes for a sandbox
+// whose create asked for metrics. Its one port is named metrics.
+func ensureMetricsService(ctx context.Context, client kubernetes.Interface, ns, podName string, m *api.Metrics) error {
+	if m == nil {
+		return nil
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        metricsServiceName(podName),
+			Labels:      metricsLabels(podName),
+			Annotations: metricsAnnotations(m),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{metricsLabel: podName},
+			Ports: []corev1.ServicePort{{
+				Name:       "metrics",
+				Port:       m.Port,
+				TargetPort: intstr.FromInt32(m.Port),
+			}},
+		},
+	}
+	_, err := client.CoreV1().Services(ns).Create(ctx, svc, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteMetricsService removes a sandbox's metrics Service in single-namespace
+// mode, where there's no namespace delete to take it along.
+func deleteMetricsService(ctx context.Context, client kubernetes.Interface, ns, podName string) error {
+	err := client.CoreV1().Services(ns).Delete(ctx, metricsServiceName(podName), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sandbox/pkg/api"
+)
+
+func TestCreateWithMetricsAddsServiceAndLabels(t *testing.T) {
+	t.Setenv("SANDBOX_METRICS_LABELS", "monitoring=sandboxes, sbx.allocated=true")
+	s := newTestServer()
+	ctx := context.Background()
+	req := api.CreateSandboxRequest{ID: "a", Metrics: &api.Metrics{Port: 9090, Path: "/stats"}}
+	if w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", req); w.Code != 200 {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+
+	pod, err := s.client.CoreV1().Pods("sbx-a").Get(ctx, "sandbox", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Labels[metricsLabel] != "sandbox" || pod.Labels["monitoring"] != "sandboxes" || pod.Labels[sandboxPodLabel] != "true" {
+		t.Errorf("pod labels = %v", pod.Labels)
+	}
+	if _, ok := pod.Labels["sbx.allocated"]; ok {
+		t.Errorf("reserved sbx.* label taken from SANDBOX_METRICS_LABELS: %v", pod.Labels)
+	}
+	if pod.Annotations["prometheus.io/scrape"] != "true" || pod.Annotations["prometheus.io/port"] != "9090" || pod.Annotations["prometheus.io/path"] != "/stats" {
+		t.Errorf("pod annotations = %v", pod.Annotations)
+	}
+
+	svc, err := s.client.CoreV1().Services("sbx-a").Get(ctx, "sandbox-metrics", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Labels[metricsLabel] != "sandbox" || svc.Labels["monitoring"] != "sandboxes" {
+		t.Errorf("service labels = %v", svc.Labels)
+	}
+	for k, v := range svc.Spec.Selector {
+		if pod.Labels[k] != v {
+			t.Errorf("service selector %v doesn't match the pod", svc.Spec.Selector)
+		}
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Name != "metrics" || svc.Spec.Ports[0].Port != 9090 || svc.Spec.Ports[0].TargetPort.IntVal != 9090 {
+		t.Errorf("service ports = %+v", svc.Spec.Ports)
+	}
+	if svc.Annotations["prometheus.io/path"] != "/stats" {
+		t.Errorf("service annotations = %v", svc.Annotations)
+	}
+}
+
+func TestCreateWithoutMetricsHasNoService(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	if w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: "a"}); w.Code != 200 {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	svcs, err := s.client.CoreV1().Services("sbx-a").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svcs.Items) != 0 {
+		t.Fatalf("services = %v, want none", svcs.Items)
+	}
+	pod, err := s.client.CoreV1().Pods("sbx-a").Get(ctx, "sandbox", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pod.Labels[metricsLabel]; ok {
+		t.Errorf("pod labels = %v", pod.Labels)
+	}
+}
+
+func TestSingleNamespaceDeleteRemovesMetricsService(t *testing.T) {
+	t.Setenv("SANDBOX_SINGLE_NAMESPACE", "sandboxes")
+	s := newTestServer()
+	ctx := context.Background()
+	req := api.CreateSandboxRequest{ID: "one", Metrics: &api.Metrics{Port: 9090}}
+	if w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", req); w.Code != 200 {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	svc, err := s.client.CoreV1().Services("sandboxes").Get(ctx, "sbx-one-metrics", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Selector[metricsLabel] != "sbx-one" {
+		t.Fatalf("selector = %v, want only sbx-one", svc.Spec.Selector)
+	}
+	if err := s.deleteSandboxObjects(ctx, "sbx-one"); err != nil {
+		t.Fatal(err)
+	}
+	svcs, _ := s.client.CoreV1().Services("sandboxes").List(ctx, metav1.ListOptions{})
+	if len(svcs.Items) != 0 {
+		t.Fatalf("services left after delete: %v", svcs.Items)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ensureNamespacePolicies creates the optional per-namespace guard rails for a
+// sandbox namespace. Existing objects are left as they are.
+func ensureNamespacePolicies(ctx context.Context, client kubernetes.Interface, ns string) error {
+	if getenvBool("SANDBOX_APPLY_RESOURCE_QUOTA", false) {
+		if err := ensureResourceQuota(ctx, client, ns); err != nil {
+			return fmt.Errorf("resource quota: %w", err)
+		}
+	}
+	if getenvBool("SANDBOX_APPLY_LIMIT_RANGE", false) {
+		if err := ensureLimitRange(ctx, client, ns); err != nil {
+			return fmt.Errorf("limit range: %w", err)
+		}
+	}
+	return nil
+}
+
+// sandboxResourceQuota bounds what a sandbox namespace can hold in aggregate. CPU and
+// memory are only capped when configured, since a quota on limits rejects any container
+// that doesn't declare one.
+func sandboxResourceQuota() (corev1.ResourceQuota, error) {
+	hard := corev1.ResourceList{}
+	for _, q := range []struct {
+		key  string
+		name corev1.ResourceName
+		def  string
+	}{
+		{"SANDBOX_QUOTA_PODS", corev1.ResourcePods, "5"},
+		{"SANDBOX_QUOTA_CPU", corev1.ResourceLimitsCPU, ""},
+		{"SANDBOX_QUOTA_MEMORY", corev1.ResourceLimitsMemory, ""},
+	} {
+		v := getenv(q.key, q.def)
+		if v == "" {
+			continue
+		}
+		qty, err := resource.ParseQuantity(v)
+		if err != nil {
+			return corev1.ResourceQuota{}, fmt.Errorf("%s: %w", q.key, err)
+		}
+		hard[q.name] = qty
+	}
+	return corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "sandbox-quota"},
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+	}, nil
+}
+
+func ensureResourceQuota(ctx context.Context, client kubernetes.Interface, ns string) error {
+	quota, err := sandboxResourceQuota()
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().ResourceQuotas(ns).Create(ctx, &quota, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// sandboxLimitRange gives every container in the namespace the same default requests
+// and limits as the sandbox container, so pods created outside the control plane (or
+// containers without resources, like the stream sidecar) are still bounded.
+func sandboxLimitRange() corev1.LimitRange {
+	res := sandboxResources()
+	return corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "sandbox-limits"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				{
+					Type:           corev1.LimitTypeContainer,
+					Default:        res.Limits,
+					DefaultRequest: res.Requests,
+				},
+			},
+		},
+	}
+}
+
+func ensureLimitRange(ctx context.Context, client kubernetes.Interface, ns string) error {
+	limits := sandboxLimitRange()
+	item := limits.Spec.Limits[0]
+	if len(item.Default) == 0 && len(item.DefaultRequest) == 0 {
+		// Nothing configured to default to.
+		return nil
+	}
+	_, err := client.CoreV1().LimitRanges(ns).Create(ctx, &limits, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEnsureNamespacePoliciesCreatesQuota(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	if err := ensureNamespacePolicies(ctx, client, "sbx-a"); err != nil {
+		t.Fatalf("ensureNamespacePolicies: %v", err)
+	}
+	if quotas, _ := client.CoreV1().ResourceQuotas("sbx-a").List(ctx, metav1.ListOptions{}); len(quotas.Items) != 0 {
+		t.Fatalf("quota created without SANDBOX_APPLY_RESOURCE_QUOTA: %+v", quotas.Items)
+	}
+
+	t.Setenv("SANDBOX_APPLY_RESOURCE_QUOTA", "true")
+	t.Setenv("SANDBOX_QUOTA_CPU", "2")
+	for i := 0; i < 2; i++ {
+		if err := ensureNamespacePolicies(ctx, client, "sbx-a"); err != nil {
+			t.Fatalf("ensureNamespacePolicies #%d: %v", i+1, err)
+		}
+	}
+	quota, err := client.CoreV1().ResourceQuotas("sbx-a").Get(ctx, "sandbox-quota", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("quota not created: %v", err)
+	}
+	hard := quota.Spec.Hard
+	if pods := hard[corev1.ResourcePods]; pods.Value() != 5 {
+		t.Errorf("pods = %s, want the default 5", pods.String())
+	}
+	if cpu := hard[corev1.ResourceLimitsCPU]; cpu.String() != "2" {
+		t.Errorf("limits.cpu = %s, want 2", cpu.String())
+	}
+	if _, ok := hard[corev1.ResourceLimitsMemory]; ok {
+		t.Error("limits.memory set without SANDBOX_QUOTA_MEMORY")
+	}
+}
+
+func TestSandboxResourceQuotaRejectsBadQuantity(t *testing.T) {
+	t.Setenv("SANDBOX_QUOTA_MEMORY", "lots")
+	if _, err := sandboxResourceQuota(); err == nil {
+		t.Fatal("invalid SANDBOX_QUOTA_MEMORY accepted")
+	}
+}
+
+func TestEnsureNamespacePoliciesCreatesLimitRange(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	t.Setenv("SANDBOX_APPLY_LIMIT_RANGE", "1")
+
+	if err := ensureNamespacePolicies(ctx, client, "sbx-a"); err != nil {
+		t.Fatalf("ensureNamespacePolicies: %v", err)
+	}
+	if ranges, _ := client.CoreV1().LimitRanges("sbx-a").List(ctx, metav1.ListOptions{}); len(ranges.Items) != 0 {
+		t.Fatalf("limit range created with no resources configured: %+v", ranges.Items)
+	}
+
+	t.Setenv("SANDBOX_CPU_REQUEST", "250m")
+	t.Setenv("SANDBOX_MEM_LIMIT", "1Gi")
+	if err := ensureNamespacePolicies(ctx, client, "sbx-a"); err != nil {
+		t.Fatalf("ensureNamespacePolicies: %v", err)
+	}
+	lr, err := client.CoreV1().LimitRanges("sbx-a").Get(ctx, "sandbox-limits", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("limit range not created: %v", err)
+	}
+	item := lr.Spec.Limits[0]
+	if item.Type != corev1.LimitTypeContainer {
+		t.Errorf("Type = %s, want Container", item.Type)
+	}
+	if cpu := item.DefaultRequest[corev1.ResourceCPU]; cpu.String() != "250m" {
+		t.Errorf("default request cpu = %s, want 250m", cpu.String())
+	}
+	if mem := item.Default[corev1.ResourceMemory]; mem.String() != "1Gi" {
+		t.Errorf("default limit memory = %s, want 1Gi", mem.String())
+	}
+}
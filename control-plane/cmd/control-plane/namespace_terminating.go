@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultTerminatingWait  = 60 * time.Second
+	terminatingPollInterval = 250 * time.Millisecond
+)
+
+// errNamespaceTerminating is returned when a deleted sandbox namespace is still being
+// torn down after SANDBOX_TERMINATING_WAIT.
+var errNamespaceTerminating = errors.New("sandbox namespace is still terminating from a previous delete; retry shortly")
+
+func namespaceTerminating(ns *corev1.Namespace) bool {
+	return ns.DeletionTimestamp != nil || ns.Status.Phase == corev1.NamespaceTerminating
+}
+
+// waitNamespaceGone lets a create for an id that was just deleted go ahead once the
+// old namespace is gone, rather than failing on objects Kubernetes refuses to create
+// in a terminating namespace. It returns at once when the namespace is missing or
+// live, and errNamespaceTerminating if it is still terminating after
+// SANDBOX_TERMINATING_WAIT.
+func (s *server) waitNamespaceGone(parent context.Context, name string) error {
+	return s.waitNamespaceGoneFor(parent, name, getenvDuration("SANDBOX_TERMINATING_WAIT", defaultTerminatingWait))
+}
+
+// waitSandboxGone waits out a previous delete of sandbox namespace ns: its pod in
+// single-namespace mode, the namespace itself otherwise.
+func (s *server) waitSandboxGone(parent context.Context, ns string) error {
+	if podNS, podName := sandboxPod(ns, "sandbox"); podNS != ns {
+		return s.waitSandboxPodGone(parent, podNS, podName)
+	}
+	return s.waitNamespaceGone(parent, ns)
+}
+
+func (s *server) waitNamespaceGoneFor(parent context.Context, name string, wait time.Duration) error {
+	ctx, cancel := context.WithTimeout(parent, wait)
+	defer cancel()
+	ticker := time.NewTicker(terminatingPollInterval)
+	defer ticker.Stop()
+	for {
+		ns, err := s.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil && parent.Err() == nil {
+				return errNamespaceTerminating
+			}
+			return err
+		}
+		if !namespaceTerminating(ns) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				return parent.Err()
+			}
+			return errNamespaceTerminating
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// markTerminating puts ns in the state a delete leaves it in until its contents are gone.
+func markTerminating(t *testing.T, s *server, name string) {
+	t.Helper()
+	ctx := context.Background()
+	ns, err := s.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := metav1.Now()
+	ns.DeletionTimestamp = &now
+	if _, err := s.client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecreateAfterDeleteWaitsForNamespace(t *testing.T) {
+	s := newTestServer()
+	create := func() int {
+		return serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: "again"}).Code
+	}
+	if code := create(); code != 200 {
+		t.Fatalf("first create: status %d", code)
+	}
+	markTerminating(t, s, "sbx-again")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = s.client.CoreV1().Namespaces().Delete(context.Background(), "sbx-again", metav1.DeleteOptions{})
+	}()
+
+	start := time.Now()
+	if code := create(); code != 200 {
+		t.Fatalf("recreate: status %d", code)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("recreate returned before the old namespace was gone")
+	}
+	ns, err := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-again", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.DeletionTimestamp != nil {
+		t.Error("recreated sandbox reused the terminating namespace")
+	}
+}
+
+func TestRecreateWhileTerminatingTimesOut(t *testing.T) {
+	t.Setenv("SANDBOX_TERMINATING_WAIT", "50ms")
+	s := newTestServer()
+	req := api.CreateSandboxRequest{ID: "stuck"}
+	if w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", req); w.Code != 200 {
+		t.Fatalf("first create: status %d", w.Code)
+	}
+	markTerminating(t, s, "sbx-stuck")
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", req)
+	if w.Code != 409 || !strings.Contains(w.Body.String(), errCodeSandboxTerminating) {
+		t.Fatalf("status = %d body %s, want 409 %s", w.Code, w.Body, errCodeSandboxTerminating)
+	}
+}
+
+func TestRecreateWaitsWithoutHoldingCreateSlot(t *testing.T) {
+	t.Setenv("SANDBOX_TERMINATING_WAIT", "2s")
+	s := newTestServer()
+	s.createSlots = newCreateLimiter(1)
+	create := func(id string) int {
+		return serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: id}).Code
+	}
+	if code := create("again"); code != 200 {
+		t.Fatalf("first create: status %d", code)
+	}
+	markTerminating(t, s, "sbx-again")
+	recreated := make(chan int, 1)
+	go func() { recreated <- create("again") }()
+	time.Sleep(50 * time.Millisecond)
+
+	// The recreate is still waiting; the one slot must be free for other creates.
+	other := make(chan int, 1)
+	go func() { other <- create("other") }()
+	select {
+	case code := <-other:
+		if code != 200 {
+			t.Fatalf("other create: status %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("an unrelated create waited behind a terminating recreate")
+	}
+	_ = s.client.CoreV1().Namespaces().Delete(context.Background(), "sbx-again", metav1.DeleteOptions{})
+	if code := <-recreated; code != 200 {
+		t.Fatalf("recreate: status %d", code)
+	}
+}
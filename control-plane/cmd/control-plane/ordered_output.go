@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"sandbox/pkg/api"
+)
+
+// orderedOutput records stdout and stderr writes as chunks in arrival order. The
+// SPDY executor copies each stream on its own goroutine, so the mutex is what
+// establishes the order.
+type orderedOutput struct {
+	mu     sync.Mutex
+	chunks []api.OutputChunk
+}
+
+func (o *orderedOutput) writer(stream string) *orderedStreamWriter {
+	return &orderedStreamWriter{out: o, stream: stream}
+}
+
+func (o *orderedOutput) snapshot() []api.OutputChunk {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]api.OutputChunk(nil), o.chunks...)
+}
+
+type orderedStreamWriter struct {
+	out    *orderedOutput
+	stream string
+}
+
+func (w *orderedStreamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.out.mu.Lock()
+	w.out.chunks = append(w.out.chunks, api.OutputChunk{
+		Stream: w.stream,
+		Data:   string(p),
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	w.out.mu.Unlock()
+	return len(p), nil
+}
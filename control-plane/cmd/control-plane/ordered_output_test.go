@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOrderedOutputKeepsArrivalOrder(t *testing.T) {
+	var out orderedOutput
+	stdout, stderr := out.writer("stdout"), out.writer("stderr")
+	stdout.Write([]byte("a"))
+	stderr.Write([]byte("b"))
+	stderr.Write(nil)
+	stdout.Write([]byte("c"))
+
+	chunks := out.snapshot()
+	want := []string{"stdout:a", "stderr:b", "stdout:c"}
+	if len(chunks) != len(want) {
+		t.Fatalf("chunks = %+v, want %v", chunks, want)
+	}
+	for i, c := range chunks {
+		if got := c.Stream + ":" + c.Data; got != want[i] {
+			t.Errorf("chunk %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
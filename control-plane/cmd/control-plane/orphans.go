@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultOrphanGrace = 10 * time.Minute
+
+// findOrphans returns sandbox volumes left behind by namespaces that are gone or
+// have been stuck terminating for longer than SANDBOX_ORPHAN_GRACE: PVCs still
+// present in such namespaces, and Released PVs (retained storage classes) whose
+// claim lived in one.
+func (s *server) findOrphans(ctx context.Context) ([]api.OrphanVolume, error) {
+	grace := getenvDuration("SANDBOX_ORPHAN_GRACE", defaultOrphanGrace)
+	namespaces, err := s.namespaces.list(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*corev1.Namespace, len(namespaces))
+	for i := range namespaces {
+		byName[namespaces[i].Name] = &namespaces[i]
+	}
+	now := time.Now()
+	missing := map[string]bool{}
+	// orphanReason reports why volumes in ns are orphaned, or "" if ns is live.
+	orphanReason := func(ns string) string {
+		if !strings.HasPrefix(ns, "sbx-") {
+			return ""
+		}
+		if missing[ns] {
+			return "namespace missing"
+		}
+		n, ok := byName[ns]
+		if !ok {
+			// The cache may not have seen a just-created namespace yet.
+			live, err := s.client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				missing[ns] = true
+				return "namespace missing"
+			}
+			if err != nil {
+				return ""
+			}
+			n = live
+			byName[ns] = n
+		}
+		if n.DeletionTimestamp != nil && now.Sub(n.DeletionTimestamp.Time) > grace {
+			return "namespace terminating"
+		}
+		return ""
+	}
+
+	var out []api.OrphanVolume
+	pvcs, err := s.client.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pvc := range pvcs.Items {
+		reason := orphanReason(pvc.Namespace)
+		if reason == "" {
+			continue
+		}
+		out = append(out, api.OrphanVolume{
+			Kind:         "PersistentVolumeClaim",
+			Name:         pvc.Name,
+			Namespace:    pvc.Namespace,
+			Reason:       reason,
+			StorageClass: storageClassName(pvc.Spec.StorageClassName),
+			Capacity:     storageQuantity(pvc.Status.Capacity),
+			CreatedAt:    pvc.CreationTimestamp.UTC().Format(time.RFC3339),
+			Deleting:     pvc.DeletionTimestamp != nil,
+		})
+	}
+	pvs, err := s.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pv := range pvs.Items {
+		if pv.Status.Phase != corev1.VolumeReleased || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		reason := orphanReason(pv.Spec.ClaimRef.Namespace)
+		if reason == "" {
+			continue
+		}
+		out = append(out, api.OrphanVolume{
+			Kind:         "PersistentVolume",
+			Name:         pv.Name,
+			Namespace:    pv.Spec.ClaimRef.Namespace,
+			Reason:       reason,
+			StorageClass: pv.Spec.StorageClassName,
+			Capacity:     storageQuantity(pv.Spec.Capacity),
+			CreatedAt:    pv.CreationTimestamp.UTC().Format(time.RFC3339),
+			Deleting:     pv.DeletionTimestamp != nil,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Namespace != out[j].Namespace {
+			return out[i].Namespace < out[j].Namespace
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// reapOrphans deletes the volumes findOrphans reports when SANDBOX_REAP_ORPHANS is
+// set. Deleting a retained PV removes the API object only; the backing disk is left
+// for the storage admin.
+func (s *server) reapOrphans(ctx context.Context) {
+	if !getenvBool("SANDBOX_REAP_ORPHANS", false) {
+		return
+	}
+	orphans, err := s.findOrphans(ctx)
+	if err != nil {
+		log.Printf("orphan scan failed: %v", err)
+		return
+	}
+	for _, o := range orphans {
+		if o.Deleting {
+			continue
+		}
+		var err error
+		switch o.Kind {
+		case "PersistentVolumeClaim":
+			err = s.client.CoreV1().PersistentVolumeClaims(o.Namespace).Delete(ctx, o.Name, metav1.DeleteOptions{})
+		case "PersistentVolume":
+			err = s.client.CoreV1().PersistentVolumes().Delete(ctx, o.Name, metav1.DeleteOptions{})
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("reap orphan %s %s/%s failed: %v", o.Kind, o.Namespace, o.Name, err)
+			continue
+		}
+		metricOrphansReaped.Add(1)
+		log.Printf("reaped orphan %s %s/%s reason=%q", o.Kind, o.Namespace, o.Name, o.Reason)
+	}
+}
+
+func (s *server) listOrphans(c *gin.Context) {
+	orphans, err := s.findOrphans(c.Request.Context())
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	if orphans == nil {
+		orphans = []api.OrphanVolume{}
+	}
+	writeJSON(c, 200, api.OrphansResponse{
+		Orphans:     orphans,
+		ReapEnabled: getenvBool("SANDBOX_REAP_ORPHANS", false),
+	})
+}
+
+func storageClassName(name *string) string {
+	if name == nil {
+		return ""
+	}
+	return *name
+}
+
+func storageQuantity(list corev1.ResourceList) string {
+	if q, ok := list[corev1.ResourceStorage]; ok {
+		return q.String()
+	}
+	return ""
+}
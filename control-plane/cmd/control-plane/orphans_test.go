@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReapOrphans(t *testing.T) {
+	longAgo := metav1.NewTime(time.Now().Add(-time.Hour))
+	s := newTestServer(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-live"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-stuck", DeletionTimestamp: &longAgo, Finalizers: []string{"kubernetes"}}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "workspace", Namespace: "sbx-live"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "workspace", Namespace: "sbx-gone"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "sbx-stuck"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "other-gone"}},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-gone"},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: &corev1.ObjectReference{Namespace: "sbx-gone", Name: "cache"}},
+			Status:     corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-live"},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: &corev1.ObjectReference{Namespace: "sbx-live", Name: "cache"}},
+			Status:     corev1.PersistentVolumeStatus{Phase: corev1.VolumeBound},
+		},
+	)
+	ctx := context.Background()
+	orphans, err := s.findOrphans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"PersistentVolumeClaim sbx-gone/workspace": "namespace missing",
+		"PersistentVolume sbx-gone/pv-gone":        "namespace missing",
+		"PersistentVolumeClaim sbx-stuck/cache":    "namespace terminating",
+	}
+	if len(orphans) != len(want) {
+		t.Fatalf("orphans = %+v, want %d", orphans, len(want))
+	}
+	for _, o := range orphans {
+		key := o.Kind + " " + o.Namespace + "/" + o.Name
+		if want[key] != o.Reason {
+			t.Errorf("orphan %s reason %q, want %q", key, o.Reason, want[key])
+		}
+	}
+
+	// Deletion is off by default.
+	s.reapOrphans(ctx)
+	if _, err := s.client.CoreV1().PersistentVolumeClaims("sbx-gone").Get(ctx, "workspace", metav1.GetOptions{}); err != nil {
+		t.Fatalf("orphan deleted without SANDBOX_REAP_ORPHANS: %v", err)
+	}
+
+	t.Setenv("SANDBOX_REAP_ORPHANS", "true")
+	s.reapOrphans(ctx)
+	if _, err := s.client.CoreV1().PersistentVolumeClaims("sbx-gone").Get(ctx, "workspace", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("orphaned PVC kept: %v", err)
+	}
+	if _, err := s.client.CoreV1().PersistentVolumes().Get(ctx, "pv-gone", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("orphaned PV kept: %v", err)
+	}
+	for _, key := range [][2]string{{"sbx-live", "workspace"}, {"other-gone", "data"}} {
+		if _, err := s.client.CoreV1().PersistentVolumeClaims(key[0]).Get(ctx, key[1], metav1.GetOptions{}); err != nil {
+			t.Errorf("PVC %s/%s deleted: %v", key[0], key[1], err)
+		}
+	}
+	if _, err := s.client.CoreV1().PersistentVolumes().Get(ctx, "pv-live", metav1.GetOptions{}); err != nil {
+		t.Errorf("bound PV deleted: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// applyPodSpecOverlay strategic-merge-patches overlay onto spec, the way kubectl
+// patch would: containers merge by name, so {"containers": [{"name": "sandbox",
+// ...}]} amends the sandbox container instead of replacing the list. The result
+// must still run the sandbox container.
+func applyPodSpecOverlay(spec corev1.PodSpec, overlay json.RawMessage) (corev1.PodSpec, error) {
+	if emptyOverlay(overlay) {
+		return spec, nil
+	}
+	original, err := json.Marshal(spec)
+	if err != nil {
+		return spec, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, overlay, corev1.PodSpec{})
+	if err != nil {
+		return spec, fmt.Errorf("pod_spec_overlay: %v", err)
+	}
+	var out corev1.PodSpec
+	if err := json.Unmarshal(patched, &out); err != nil {
+		return spec, fmt.Errorf("pod_spec_overlay: %v", err)
+	}
+	for _, c := range out.Containers {
+		if c.Name == "sandbox" {
+			return out, nil
+		}
+	}
+	return spec, fmt.Errorf("pod_spec_overlay must not remove the sandbox container")
+}
+
+// validatePodSpecOverlay checks that overlay is allowed and applies cleanly to a
+// minimal spec, so a bad overlay fails before any cluster objects are created.
+func validatePodSpecOverlay(overlay json.RawMessage) error {
+	if emptyOverlay(overlay) {
+		return nil
+	}
+	if !getenvBool("SANDBOX_ALLOW_POD_OVERLAY", false) {
+		return fmt.Errorf("pod_spec_overlay is disabled; set SANDBOX_ALLOW_POD_OVERLAY=true to allow it")
+	}
+	_, err := applyPodSpecOverlay(corev1.PodSpec{Containers: []corev1.Container{{Name: "sandbox"}}}, overlay)
+	return err
+}
+
+func emptyOverlay(overlay json.RawMessage) bool {
+	return len(overlay) == 0 || string(overlay) == "null"
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+)
+
+func TestApplyPodSpecOverlay(t *testing.T) {
+	base := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	overlay := json.RawMessage(`{"shareProcessNamespace": true, "containers": [{"name": "sandbox", "workingDir": "/srv"}]}`)
+	spec, err := applyPodSpecOverlay(base, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.ShareProcessNamespace == nil || !*spec.ShareProcessNamespace {
+		t.Error("shareProcessNamespace not applied")
+	}
+	if len(spec.Containers) != len(base.Containers) {
+		t.Fatalf("containers = %d, want the overlay merged into the existing %d", len(spec.Containers), len(base.Containers))
+	}
+	sandbox := spec.Containers[0]
+	if sandbox.WorkingDir != "/srv" || sandbox.Image != "img" {
+		t.Errorf("sandbox container = %+v, want workingDir /srv and the original image", sandbox)
+	}
+
+	drop := json.RawMessage(`{"containers": [{"name": "sandbox", "$patch": "delete"}]}`)
+	if _, err := applyPodSpecOverlay(base, drop); err == nil || !strings.Contains(err.Error(), "sandbox container") {
+		t.Errorf("overlay removing the sandbox container: err = %v", err)
+	}
+}
+
+func TestValidatePodSpecOverlayRequiresOptIn(t *testing.T) {
+	overlay := json.RawMessage(`{"hostIPC": true}`)
+	t.Setenv("SANDBOX_ALLOW_POD_OVERLAY", "false")
+	if err := validatePodSpecOverlay(overlay); err == nil || !strings.Contains(err.Error(), "SANDBOX_ALLOW_POD_OVERLAY") {
+		t.Fatalf("overlay accepted while disabled: %v", err)
+	}
+	t.Setenv("SANDBOX_ALLOW_POD_OVERLAY", "true")
+	if err := validatePodSpecOverlay(overlay); err != nil {
+		t.Fatalf("overlay rejected while enabled: %v", err)
+	}
+	if err := validatePodSpecOverlay(json.RawMessage(`{"containers": "nope"}`)); err == nil {
+		t.Fatal("malformed overlay accepted")
+	}
+}
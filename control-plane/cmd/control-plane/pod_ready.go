@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const defaultReadyPollInterval = 500 * time.Millisecond
+
+// waitForPodReady blocks until the pod is Ready, has completed, or ctx is done. If
+// ctx ends while the sandbox container is still in its startup probe, the error
+// says so, since the pod is booting rather than stuck.
+func (s *server) waitForPodReady(ctx context.Context, ns, name string) error {
+	starting := false
+	err := s.watchPod(ctx, ns, name, func(pod *corev1.Pod) (bool, error) {
+		starting = sandboxStarting(pod)
+		return podReadyState(pod)
+	})
+	if err != nil && starting && ctx.Err() != nil {
+		return fmt.Errorf("%w: sandbox container is still starting (startup probe has not passed yet)", err)
+	}
+	return err
+}
+
+// watchPod calls visit with each version of the pod until visit reports done, the
+// pod is deleted or ctx ends, and returns visit's error. It watches the pod so
+// changes are seen as soon as the kubelet reports them, and falls back to polling
+// every SANDBOX_READY_POLL_INTERVAL if the watch can't be opened or ends early.
+func (s *server) watchPod(ctx context.Context, ns, name string, visit func(*corev1.Pod) (bool, error)) error {
+	ns, name = sandboxPod(ns, name)
+	pod, err := s.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if done, err := visit(pod); done {
+		return err
+	}
+	w, err := s.client.CoreV1().Pods(ns).Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+		ResourceVersion: pod.ResourceVersion,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("watch pod %s/%s: %v; polling instead", ns, name, err)
+		return s.pollPod(ctx, ns, name, visit)
+	}
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case evt, ok := <-w.ResultChan():
+			if !ok || evt.Type == watch.Error {
+				// Watches are closed by the server from time to time; finish by polling.
+				return s.pollPod(ctx, ns, name, visit)
+			}
+			if evt.Type == watch.Deleted {
+				return fmt.Errorf("pod was deleted")
+			}
+			if pod, ok := evt.Object.(*corev1.Pod); ok {
+				if done, err := visit(pod); done {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func readyPollInterval() time.Duration {
+	interval := getenvDuration("SANDBOX_READY_POLL_INTERVAL", defaultReadyPollInterval)
+	if interval <= 0 {
+		interval = defaultReadyPollInterval
+	}
+	return interval
+}
+
+func (s *server) pollPod(ctx context.Context, ns, name string, visit func(*corev1.Pod) (bool, error)) error {
+	ticker := time.NewTicker(readyPollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			pod, err := s.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if done, err := visit(pod); done {
+				return err
+			}
+		}
+	}
+}
+
+// podReadyState reports whether waiting on pod is over: with a nil error once it is
+// Ready, or with an error once it has completed or its git clone was refused and it
+// never will be.
+func podReadyState(pod *corev1.Pod) (bool, error) {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return true, fmt.Errorf("pod has completed with phase %s", pod.Status.Phase)
+	}
+	// Retrying the clone won't fix the credentials.
+	if status, msg := gitCloneStatus(pod); status == "auth_failed" {
+		return true, fmt.Errorf("git clone: %s", msg)
+	}
+	return podReady(pod), nil
+}
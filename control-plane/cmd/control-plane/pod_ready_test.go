@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func pendingPod(ns string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sandbox", Namespace: ns},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	}
+}
+
+// watchedServer returns a server whose pod watches are fed by the returned watcher.
+func watchedServer(t *testing.T) (*server, *watch.FakeWatcher) {
+	s := newTestServer(pendingPod("sbx-a"))
+	fw := watch.NewFake()
+	s.client.(*fake.Clientset).PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(fw, nil))
+	// Polling would also see readiness eventually; keep it out of the way.
+	t.Setenv("SANDBOX_READY_POLL_INTERVAL", "1h")
+	return s, fw
+}
+
+func TestWaitForPodReadyViaWatch(t *testing.T) {
+	s, fw := watchedServer(t)
+	done := make(chan error, 1)
+	go func() { done <- s.waitForPodReady(context.Background(), "sbx-a", "sandbox") }()
+	fw.Modify(pendingPod("sbx-a"))
+	fw.Modify(readyPod("sbx-a"))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readiness from the watch was not detected")
+	}
+}
+
+func TestWaitForPodReadyDeleted(t *testing.T) {
+	s, fw := watchedServer(t)
+	done := make(chan error, 1)
+	go func() { done <- s.waitForPodReady(context.Background(), "sbx-a", "sandbox") }()
+	fw.Delete(pendingPod("sbx-a"))
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "deleted") {
+			t.Fatalf("wait = %v, want a deleted error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pod deletion did not end the wait")
+	}
+}
+
+func TestWaitForPodReadyPollsWhenWatchFails(t *testing.T) {
+	s := newTestServer(pendingPod("sbx-a"))
+	client := s.client.(*fake.Clientset)
+	client.PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, errors.New("watch not allowed")
+	})
+	t.Setenv("SANDBOX_READY_POLL_INTERVAL", "10ms")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = client.CoreV1().Pods("sbx-a").UpdateStatus(context.Background(), readyPod("sbx-a"), metav1.UpdateOptions{})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.waitForPodReady(ctx, "sbx-a", "sandbox"); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+}
+
+func TestWaitForPodReadyWaitsForReadinessGate(t *testing.T) {
+	s, fw := watchedServer(t)
+	const gate = corev1.PodConditionType("example.com/app-ready")
+	gated := func(status corev1.ConditionStatus) *corev1.Pod {
+		pod := readyPod("sbx-a")
+		pod.Spec.ReadinessGates = []corev1.PodReadinessGate{{ConditionType: gate}}
+		pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: gate, Status: status})
+		return pod
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.waitForPodReady(context.Background(), "sbx-a", "sandbox") }()
+
+	// Containers are up but the app hasn't flipped its condition yet.
+	fw.Modify(gated(corev1.ConditionFalse))
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned %v before the readiness gate was True", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	fw.Modify(gated(corev1.ConditionTrue))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the readiness gate turning True was not detected")
+	}
+}
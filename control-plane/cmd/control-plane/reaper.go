@@ -2,14 +2,76 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sandbox/pkg/api"
+)
+
+const (
+	defaultArchiveTTL = 7 * 24 * time.Hour
+	defaultKeptTTL    = 24 * time.Hour
+	// minIdleTTL is the shortest idle_ttl a create may set. Open streams touch their
+	// sandbox at most once a minute, so anything shorter could reap a watched one.
+	minIdleTTL = 3 * maxStreamTouchInterval
 )
 
+func validateExpiresAt(v string, now time.Time) error {
+	if v == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return fmt.Errorf("expires_at must be an RFC 3339 time, e.g. 2026-01-02T17:00:00Z")
+	}
+	if !t.After(now) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+// expiresAt is when ns is to be deleted from its sbx.expires_at annotation, or
+// zero without a valid one.
+func expiresAt(ns *corev1.Namespace) time.Time {
+	ts, err := strconv.ParseInt(ns.Annotations["sbx.expires_at"], 10, 64)
+	if err != nil || ts <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
+
+func validateIdleTTL(v string) error {
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("idle_ttl: %v", err)
+	}
+	if d != 0 && d < minIdleTTL {
+		return fmt.Errorf("idle_ttl must be 0 or at least %s", minIdleTTL)
+	}
+	return nil
+}
+
+// idleTTL is how long ns may go without an exec: its sbx.idle_ttl annotation if
+// it has a valid one, else def. Zero means never.
+func idleTTL(ns *corev1.Namespace, def time.Duration) time.Duration {
+	if v, ok := ns.Annotations["sbx.idle_ttl"]; ok {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return def
+}
+
 func (s *server) reapIdleSandboxes(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -18,22 +80,29 @@ func (s *server) reapIdleSandboxes(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			s.reapOnce(ctx)
+			s.reapOnce(ctx, false)
+			s.reapOrphans(ctx)
 		}
 	}
 }
 
-func (s *server) reapOnce(ctx context.Context) {
+// reapOnce deletes sandboxes past their expires_at, sandboxes idle longer than
+// their idle TTL (SANDBOX_IDLE_TTL unless the create set idle_ttl) once they are
+// older than SANDBOX_MIN_AGE_BEFORE_REAP, and archives
+// past SANDBOX_ARCHIVE_TTL and namespaces kept on delete past SANDBOX_KEPT_TTL,
+// returning those it deleted. With dryRun it deletes
+// nothing and returns what it would have deleted. SANDBOX_IDLE_TTL=0 turns off
+// only idle reaping.
+func (s *server) reapOnce(ctx context.Context, dryRun bool) []api.ReapedSandbox {
 	ttl := getenvDuration("SANDBOX_IDLE_TTL", defaultIdleTTL)
-	if ttl <= 0 {
-		return
-	}
-	nsList, err := s.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	minAge := getenvDuration("SANDBOX_MIN_AGE_BEFORE_REAP", 0)
+	namespaces, err := s.sandboxNamespaces(ctx, nil)
 	if err != nil {
-		return
+		return nil
 	}
 	now := time.Now()
-	for _, ns := range nsList.Items {
+	var reaped []api.ReapedSandbox
+	for _, ns := range namespaces {
 		name := ns.Name
 		if !strings.HasPrefix(name, "sbx-") {
 			continue
@@ -42,6 +111,31 @@ func (s *server) reapOnce(ctx context.Context) {
 		if labels != nil && labels["sbx.allocated"] == "false" {
 			continue
 		}
+		if exp := expiresAt(&ns); !exp.IsZero() && !now.Before(exp) {
+			r := api.ReapedSandbox{ID: name, Reason: "expired", ForSeconds: int64(now.Sub(exp).Seconds())}
+			if dryRun {
+				reaped = append(reaped, r)
+				continue
+			}
+			if err := s.deleteSandboxObjects(ctx, name); err == nil {
+				log.Printf("reaped sandbox namespace=%s expired_at=%s", name, exp.UTC().Format(time.RFC3339))
+				reaped = append(reaped, r)
+			}
+			continue
+		}
+		if isArchived(&ns) {
+			if r, ok := s.reapArchived(ctx, &ns, now, dryRun); ok {
+				reaped = append(reaped, r)
+			}
+			continue
+		}
+		if r, ok := s.reapKept(ctx, &ns, now, dryRun); ok {
+			reaped = append(reaped, r)
+			continue
+		}
+		if ttl <= 0 {
+			continue
+		}
 		last := ns.Annotations["sbx.last_exec_at"]
 		var lastTime time.Time
 		if last != "" && last != "0" {
@@ -52,10 +146,80 @@ func (s *server) reapOnce(ctx context.Context) {
 		if lastTime.IsZero() {
 			lastTime = ns.CreationTimestamp.Time
 		}
-		if now.Sub(lastTime) > ttl {
-			if err := s.client.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{}); err == nil {
+		if now.Sub(ns.CreationTimestamp.Time) < minAge {
+			// Still provisioning or setting up, however long that takes.
+			continue
+		}
+		if ttl := idleTTL(&ns, ttl); ttl > 0 && now.Sub(lastTime) > ttl {
+			r := api.ReapedSandbox{ID: name, Reason: "idle", ForSeconds: int64(now.Sub(lastTime).Seconds())}
+			if dryRun {
+				reaped = append(reaped, r)
+				continue
+			}
+			if err := s.deleteSandboxObjects(ctx, name); err == nil {
 				log.Printf("reaped sandbox namespace=%s idle=%s", name, now.Sub(lastTime))
+				reaped = append(reaped, r)
 			}
 		}
 	}
+	return reaped
+}
+
+// reapArchived deletes an archived sandbox once it has been archived longer than
+// SANDBOX_ARCHIVE_TTL. A zero TTL keeps archives until they are deleted explicitly.
+func (s *server) reapArchived(ctx context.Context, ns *corev1.Namespace, now time.Time, dryRun bool) (api.ReapedSandbox, bool) {
+	ttl := getenvDuration("SANDBOX_ARCHIVE_TTL", defaultArchiveTTL)
+	if ttl <= 0 {
+		return api.ReapedSandbox{}, false
+	}
+	ts, err := strconv.ParseInt(ns.Annotations["sbx.archived_at"], 10, 64)
+	if err != nil {
+		return api.ReapedSandbox{}, false
+	}
+	archivedAt := time.Unix(ts, 0)
+	if now.Sub(archivedAt) <= ttl {
+		return api.ReapedSandbox{}, false
+	}
+	r := api.ReapedSandbox{ID: ns.Name, Reason: "archived", ForSeconds: int64(now.Sub(archivedAt).Seconds())}
+	if dryRun {
+		return r, true
+	}
+	if err := s.client.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{}); err != nil {
+		return api.ReapedSandbox{}, false
+	}
+	log.Printf("reaped archived sandbox namespace=%s archived=%s", ns.Name, now.Sub(archivedAt))
+	return r, true
+}
+
+// reapKept deletes a sandbox kept on delete once it has been kept longer than
+// SANDBOX_KEPT_TTL, whatever its idle TTL. A zero TTL leaves it to the idle reaper.
+func (s *server) reapKept(ctx context.Context, ns *corev1.Namespace, now time.Time, dryRun bool) (api.ReapedSandbox, bool) {
+	ttl := getenvDuration("SANDBOX_KEPT_TTL", defaultKeptTTL)
+	if ttl <= 0 {
+		return api.ReapedSandbox{}, false
+	}
+	keptAt, err := time.Parse(time.RFC3339, ns.Annotations[keptAnnotation])
+	if err != nil || now.Sub(keptAt) <= ttl {
+		return api.ReapedSandbox{}, false
+	}
+	r := api.ReapedSandbox{ID: ns.Name, Reason: "kept", ForSeconds: int64(now.Sub(keptAt).Seconds())}
+	if dryRun {
+		return r, true
+	}
+	if err := s.deleteSandboxObjects(ctx, ns.Name); err != nil {
+		return api.ReapedSandbox{}, false
+	}
+	log.Printf("reaped kept sandbox namespace=%s kept=%s", ns.Name, now.Sub(keptAt))
+	return r, true
+}
+
+// forceReap runs a reaper sweep now instead of waiting for the next tick.
+// ?dry_run=true reports what the sweep would delete without deleting it.
+func (s *server) forceReap(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	reaped := s.reapOnce(c.Request.Context(), dryRun)
+	if reaped == nil {
+		reaped = []api.ReapedSandbox{}
+	}
+	writeJSON(c, 200, api.ReapResponse{Reaped: reaped, DryRun: dryRun})
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sandbox/pkg/api"
+)
+
+func TestForceReapDeletesIdleSandboxes(t *testing.T) {
+	t.Setenv("SANDBOX_IDLE_TTL", "10m")
+	idleSince := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	activeSince := strconv.FormatInt(time.Now().Unix(), 10)
+	s := newTestServer(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-idle", Annotations: map[string]string{"sbx.last_exec_at": idleSince}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-active", Annotations: map[string]string{"sbx.last_exec_at": activeSince}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-warm", Labels: map[string]string{"sbx.allocated": "false"}, Annotations: map[string]string{"sbx.last_exec_at": idleSince}}},
+	)
+	reap := func(path string) api.ReapResponse {
+		w := serve(s.forceReap, http.MethodPost, "/admin/reap", path, nil)
+		var resp api.ReapResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 200 {
+			t.Fatalf("%s: status %d %s", path, w.Code, w.Body)
+		}
+		return resp
+	}
+	exists := func(name string) bool {
+		_, err := s.client.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+		return err == nil
+	}
+
+	resp := reap("/admin/reap?dry_run=true")
+	if !resp.DryRun || len(resp.Reaped) != 1 || resp.Reaped[0].ID != "sbx-idle" || resp.Reaped[0].Reason != "idle" {
+		t.Fatalf("dry run = %+v, want only sbx-idle", resp)
+	}
+	if !exists("sbx-idle") {
+		t.Fatal("dry run deleted sbx-idle")
+	}
+
+	resp = reap("/admin/reap")
+	if resp.DryRun || len(resp.Reaped) != 1 || resp.Reaped[0].ID != "sbx-idle" || resp.Reaped[0].ForSeconds < 3600 {
+		t.Fatalf("reap = %+v, want sbx-idle, idle for an hour", resp)
+	}
+	if exists("sbx-idle") {
+		t.Error("sbx-idle survived the forced reap")
+	}
+	if !exists("sbx-active") || !exists("sbx-warm") {
+		t.Error("reap deleted an active or warm sandbox")
+	}
+}
+
+func TestReapHonorsPerSandboxIdleTTL(t *testing.T) {
+	t.Setenv("SANDBOX_IDLE_TTL", "10m")
+	// All of them have been idle for an hour.
+	idleSince := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	ns := func(name, ttl string) *corev1.Namespace {
+		annotations := map[string]string{"sbx.last_exec_at": idleSince}
+		if ttl != "" {
+			annotations["sbx.idle_ttl"] = ttl
+		}
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+	}
+	s := newTestServer(
+		ns("sbx-short", "30m0s"),
+		ns("sbx-long", "4h0m0s"),
+		ns("sbx-forever", "0s"),
+		// An unreadable annotation falls back to SANDBOX_IDLE_TTL.
+		ns("sbx-default", "soon"),
+	)
+	reaped := map[string]bool{}
+	for _, r := range s.reapOnce(context.Background(), false) {
+		reaped[r.ID] = true
+	}
+	if !reaped["sbx-short"] || !reaped["sbx-default"] || reaped["sbx-long"] || reaped["sbx-forever"] || len(reaped) != 2 {
+		t.Fatalf("reaped %v, want sbx-short and sbx-default", reaped)
+	}
+}
+
+func TestValidateIdleTTL(t *testing.T) {
+	for _, v := range []string{"", "0", "3m", "24h"} {
+		if err := validateIdleTTL(v); err != nil {
+			t.Errorf("%q: %v", v, err)
+		}
+	}
+	for _, v := range []string{"soon", "-1h", "30s"} {
+		if err := validateIdleTTL(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestReapExpiredSandboxes(t *testing.T) {
+	t.Setenv("SANDBOX_IDLE_TTL", "0")
+	now := time.Now()
+	ns := func(name string, expires time.Time) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{
+			// In use just now, so only the expiry can reap them.
+			"sbx.last_exec_at": strconv.FormatInt(now.Unix(), 10),
+			"sbx.expires_at":   strconv.FormatInt(expires.Unix(), 10),
+		}}}
+	}
+	s := newTestServer(ns("sbx-past", now.Add(-time.Minute)), ns("sbx-future", now.Add(time.Hour)))
+	reaped := s.reapOnce(context.Background(), false)
+	if len(reaped) != 1 || reaped[0].ID != "sbx-past" || reaped[0].Reason != "expired" || reaped[0].ForSeconds < 60 {
+		t.Fatalf("reaped %+v, want sbx-past expired a minute ago", reaped)
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-future", metav1.GetOptions{}); err != nil {
+		t.Fatalf("sbx-future: %v", err)
+	}
+}
+
+func TestReapArchivesWithoutIdleTTL(t *testing.T) {
+	t.Setenv("SANDBOX_IDLE_TTL", "0")
+	t.Setenv("SANDBOX_ARCHIVE_TTL", "1h")
+	archived := func(name string, at time.Time) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{"sbx.archived": "true"},
+			Annotations: map[string]string{"sbx.archived_at": strconv.FormatInt(at.Unix(), 10)},
+		}}
+	}
+	now := time.Now()
+	s := newTestServer(archived("sbx-old", now.Add(-2*time.Hour)), archived("sbx-new", now.Add(-time.Minute)))
+	reaped := s.reapOnce(context.Background(), false)
+	if len(reaped) != 1 || reaped[0].ID != "sbx-old" || reaped[0].Reason != "archived" {
+		t.Fatalf("reaped %+v, want sbx-old archived past the archive TTL", reaped)
+	}
+}
+
+func TestValidateExpiresAt(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	for _, v := range []string{"", "2026-01-02T17:00:00Z", "2026-01-02T15:00:00+02:00"} {
+		if err := validateExpiresAt(v, now); err != nil {
+			t.Errorf("%q: %v", v, err)
+		}
+	}
+	for _, v := range []string{"5pm", "2026-01-02", "2026-01-02T12:00:00Z", "2026-01-01T17:00:00Z"} {
+		if err := validateExpiresAt(v, now); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestReapSkipsYoungSandboxes(t *testing.T) {
+	t.Setenv("SANDBOX_IDLE_TTL", "10m")
+	t.Setenv("SANDBOX_MIN_AGE_BEFORE_REAP", "1h")
+	// Neither has run an exec, so both count as idle since creation.
+	ns := func(name string, age time.Duration) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(time.Now().Add(-age))}}
+	}
+	s := newTestServer(ns("sbx-young", 30*time.Minute), ns("sbx-old", 2*time.Hour))
+	reaped := s.reapOnce(context.Background(), false)
+	if len(reaped) != 1 || reaped[0].ID != "sbx-old" {
+		t.Fatalf("reaped %+v, want only sbx-old", reaped)
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-young", metav1.GetOptions{}); err != nil {
+		t.Fatalf("sbx-young: %v", err)
+	}
+}
+
+func TestReapSparesSharedCacheNamespace(t *testing.T) {
+	t.Setenv("SANDBOX_IDLE_TTL", "10m")
+	old := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	for _, shared := range []string{"", "sbx-cache-home"} {
+		if shared != "" {
+			t.Setenv("SANDBOX_CACHE_SHARED_NAMESPACE", shared)
+		}
+		s := newTestServer(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: sharedCacheNamespace(), CreationTimestamp: old}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-idle", CreationTimestamp: old}},
+		)
+		reaped := s.reapOnce(context.Background(), false)
+		if len(reaped) != 1 || reaped[0].ID != "sbx-idle" {
+			t.Fatalf("shared namespace %q: reaped %+v, want only sbx-idle", sharedCacheNamespace(), reaped)
+		}
+		if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), sharedCacheNamespace(), metav1.GetOptions{}); err != nil {
+			t.Fatalf("shared namespace %q: %v", sharedCacheNamespace(), err)
+		}
+	}
+}
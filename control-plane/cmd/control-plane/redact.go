@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+const redacted = "<redacted>"
+
+// defaultRedactEnvKeys are the SANDBOX_REDACT_ENV_KEYS patterns used when it is
+// unset.
+const defaultRedactEnvKeys = "TOKEN,SECRET,PASSWORD,KEY,CREDENTIAL"
+
+// parseRedactPatterns compiles SANDBOX_REDACT_ENV_KEYS, a comma-separated list of
+// regular expressions matched case-insensitively anywhere in an env name, so a
+// plain word such as TOKEN is a substring match and ^AWS_ anchors a prefix.
+func parseRedactPatterns(raw string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, entry := range splitCSV(raw) {
+		re, err := regexp.Compile("(?i)" + entry)
+		if err != nil {
+			return nil, fmt.Errorf("SANDBOX_REDACT_ENV_KEYS entry %q: %v", entry, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+var defaultRedactPatterns, _ = parseRedactPatterns(defaultRedactEnvKeys)
+
+// compiledRedactPatterns holds the patterns compiled from the last
+// SANDBOX_REDACT_ENV_KEYS value seen, so every env listing and audited command
+// doesn't compile them again.
+var compiledRedactPatterns struct {
+	mu       sync.Mutex
+	raw      string
+	patterns []*regexp.Regexp
+}
+
+// redactPatterns returns the configured patterns. An invalid list, which stops
+// the control plane at startup, falls back to the defaults rather than showing
+// everything.
+func redactPatterns() []*regexp.Regexp {
+	raw := getenv("SANDBOX_REDACT_ENV_KEYS", defaultRedactEnvKeys)
+	c := &compiledRedactPatterns
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.patterns == nil || c.raw != raw {
+		out, err := parseRedactPatterns(raw)
+		if err != nil {
+			out = defaultRedactPatterns
+		}
+		c.raw, c.patterns = raw, out
+	}
+	return c.patterns
+}
+
+// isSecretKey reports whether the value of env var key should be redacted
+// wherever env is returned or logged.
+func isSecretKey(key string) bool {
+	return matchesAny(redactPatterns(), key)
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// envAssignment matches NAME=value. The value runs to the next unquoted space or
+// ; & |, and takes in quoted parts whole, so NAME="a b" and NAME='a b'c are one
+// value; an unterminated quote runs to the end.
+var envAssignment = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)=((?:"(?:[^"\\]|\\.)*(?:"|$)|'[^']*(?:'|$)|[^\s;&|"'])+)`)
+
+// redactEnvAssignments redacts NAME=value words with secret-looking names in a
+// command, e.g. `env API_TOKEN=abc make` or `bash -lc "GH_TOKEN='a b' gh pr list"`,
+// leaving the rest of the command readable.
+func redactEnvAssignments(cmd []string) []string {
+	patterns := redactPatterns()
+	var out []string
+	for i, arg := range cmd {
+		replaced := envAssignment.ReplaceAllStringFunc(arg, func(m string) string {
+			name := envAssignment.FindStringSubmatch(m)[1]
+			if !matchesAny(patterns, name) {
+				return m
+			}
+			return name + "=" + redacted
+		})
+		if replaced != arg && out == nil {
+			out = append([]string{}, cmd...)
+		}
+		if out != nil {
+			out[i] = replaced
+		}
+	}
+	if out == nil {
+		return cmd
+	}
+	return out
+}
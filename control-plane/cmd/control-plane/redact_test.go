@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsSecretKey(t *testing.T) {
+	t.Setenv("SANDBOX_REDACT_ENV_KEYS", "")
+	for key, want := range map[string]bool{
+		"GITHUB_TOKEN":     true,
+		"db_password":      true,
+		"AWS_SECRET":       true,
+		"OPENAI_API_KEY":   true,
+		"GCP_CREDENTIALS":  true,
+		"LOG_LEVEL":        false,
+		"PATH":             false,
+		"AWS_REGION":       false,
+		"SBX_ALLOWED_HOST": false,
+	} {
+		if got := isSecretKey(key); got != want {
+			t.Errorf("default patterns: isSecretKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	t.Setenv("SANDBOX_REDACT_ENV_KEYS", "^AWS_,PASS(WORD|PHRASE)")
+	for key, want := range map[string]bool{
+		"AWS_REGION":     true,
+		"gpg_passphrase": true,
+		"GITHUB_TOKEN":   false,
+		"MY_AWS_THING":   false,
+	} {
+		if got := isSecretKey(key); got != want {
+			t.Errorf("custom patterns: isSecretKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	if _, err := parseRedactPatterns("TOKEN,(unclosed"); err == nil {
+		t.Error("an invalid pattern was accepted")
+	}
+	t.Setenv("SANDBOX_REDACT_ENV_KEYS", "(unclosed")
+	if !isSecretKey("GITHUB_TOKEN") {
+		t.Error("an invalid pattern list did not fall back to the defaults")
+	}
+}
+
+func TestRedactEnvAssignments(t *testing.T) {
+	t.Setenv("SANDBOX_REDACT_ENV_KEYS", "")
+	cmd := []string{"bash", "-lc", "GH_TOKEN=abc LOG_LEVEL=debug gh pr list"}
+	got := redactEnvAssignments(cmd)
+	want := []string{"bash", "-lc", "GH_TOKEN=" + redacted + " LOG_LEVEL=debug gh pr list"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactEnvAssignments = %q, want %q", got, want)
+	}
+	if cmd[2] != "GH_TOKEN=abc LOG_LEVEL=debug gh pr list" {
+		t.Error("the caller's command was modified")
+	}
+	if got := redactEnvAssignments([]string{"ls", "-la"}); !reflect.DeepEqual(got, []string{"ls", "-la"}) {
+		t.Errorf("a command without secrets changed: %q", got)
+	}
+}
+
+func TestRedactEnvAssignmentsQuotedValues(t *testing.T) {
+	t.Setenv("SANDBOX_REDACT_ENV_KEYS", "")
+	for in, want := range map[string]string{
+		`TOKEN="a b" make`:              "TOKEN=" + redacted + " make",
+		`TOKEN='a b' make`:              "TOKEN=" + redacted + " make",
+		`TOKEN="a \"b\" c"; make`:       "TOKEN=" + redacted + "; make",
+		`TOKEN=ab"c d"e'f g' make`:      "TOKEN=" + redacted + " make",
+		`TOKEN="unterminated secret`:    "TOKEN=" + redacted,
+		`LOG_LEVEL="a b" TOKEN=x make`:  `LOG_LEVEL="a b" TOKEN=` + redacted + " make",
+		`export API_KEY='x y'&& deploy`: "export API_KEY=" + redacted + "&& deploy",
+	} {
+		if got := redactEnvAssignments([]string{"sh", "-c", in})[2]; got != want {
+			t.Errorf("redact %s = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestRedactPatternsFollowSetting(t *testing.T) {
+	t.Setenv("SANDBOX_REDACT_ENV_KEYS", "^AWS_")
+	first := redactPatterns()
+	if again := redactPatterns(); &again[0] != &first[0] {
+		t.Error("patterns compiled again for an unchanged setting")
+	}
+	t.Setenv("SANDBOX_REDACT_ENV_KEYS", "PASSWORD")
+	if isSecretKey("AWS_REGION") || !isSecretKey("DB_PASSWORD") {
+		t.Error("a changed setting wasn't picked up")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// sourceAnnotation is set on objects made from another namespace's object, such as
+// the shared cache PV, naming the namespace/name they came from.
+const sourceAnnotation = "sbx.source"
+
+// volumeCopyLabel is set on PVs made by bindVolumeCopy to the sandbox namespace
+// they were made for, so they can be deleted with it.
+const volumeCopyLabel = "sbx.volume_copy_for"
+
+// errClaimNotMountable is returned for claims that can't be mounted by more than
+// one pod.
+var errClaimNotMountable = errors.New("cannot be mounted by sandboxes")
+
+// errNotInNamespace explains a referenced object that isn't in the sandbox
+// namespace. It wraps err, so IsNotFound still holds.
+func errNotInNamespace(kind, name, ns string, err error) error {
+	return fmt.Errorf("%s %q not found in %s; a new sandbox namespace starts empty, so create it in the namespace before creating the sandbox with that id: %w", kind, name, ns, err)
+}
+
+// checkSecret makes sure Secret name, referenced by a create request, exists in ns.
+// Objects are never copied in from other namespaces.
+func checkSecret(ctx context.Context, client kubernetes.Interface, ns, name string) error {
+	_, err := client.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return errNotInNamespace("secret", name, ns, err)
+	}
+	return err
+}
+
+// checkConfigMap is checkSecret for ConfigMaps.
+func checkConfigMap(ctx context.Context, client kubernetes.Interface, ns, name string) error {
+	_, err := client.CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return errNotInNamespace("configmap", name, ns, err)
+	}
+	return err
+}
+
+// checkVolumeClaim makes sure PVC name, referenced by an extra volume, exists in
+// ns and can be mounted there: ReadWriteMany, or ReadOnlyMany for a read-only
+// mount. Claims are never shared from other namespaces, since that would need a
+// copy of the other claim's PV and would let a create request mount any volume
+// the control plane can see; the operator provisions the claim in the sandbox
+// namespace instead.
+func checkVolumeClaim(ctx context.Context, client kubernetes.Interface, ns, name string, readOnly bool) error {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("pvc %q not found in %s; claims aren't shared from other namespaces, so create it in the namespace before creating the sandbox with that id: %w", name, ns, err)
+	}
+	if err != nil {
+		return err
+	}
+	if !hasAccessMode(pvc.Spec.AccessModes, corev1.ReadWriteMany) && !(readOnly && hasAccessMode(pvc.Spec.AccessModes, corev1.ReadOnlyMany)) {
+		return fmt.Errorf("pvc %s/%s %w: it must be ReadWriteMany, or ReadOnlyMany for a read_only volume", ns, name, errClaimNotMountable)
+	}
+	return nil
+}
+
+// bindVolumeCopy gives namespace ns a claim name bound to a new PV, described by
+// meta, with the same volume source as src. The PV is pre-bound to the claim and
+// retained, so deleting it with the sandbox (see deleteVolumeCopies) never
+// deletes the data; a Released PV is reused by a later sandbox with the same id.
+func bindVolumeCopy(ctx context.Context, client kubernetes.Interface, src *corev1.PersistentVolume, meta metav1.ObjectMeta, ns, name string, accessMode corev1.PersistentVolumeAccessMode) error {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels[volumeCopyLabel] = ns
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: meta,
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      src.Spec.Capacity,
+			PersistentVolumeSource:        src.Spec.PersistentVolumeSource,
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{accessMode},
+			ClaimRef:                      &corev1.ObjectReference{Namespace: ns, Name: name},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			MountOptions:                  src.Spec.MountOptions,
+			VolumeMode:                    src.Spec.VolumeMode,
+			NodeAffinity:                  src.Spec.NodeAffinity,
+		},
+	}
+	existing, err := client.CoreV1().PersistentVolumes().Get(ctx, pv.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if _, err := client.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("pv: %w", err)
+		}
+	case err != nil:
+		return err
+	case existing.Status.Phase == corev1.VolumeReleased:
+		// Left over from an earlier sandbox with the same id; point it at the new
+		// claim so it can bind again.
+		existing.Spec.ClaimRef = pv.Spec.ClaimRef
+		if _, err := client.CoreV1().PersistentVolumes().Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("pv: %w", err)
+		}
+	}
+
+	noClass := ""
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{accessMode},
+			StorageClassName: &noClass,
+			VolumeName:       pv.Name,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: src.Spec.Capacity[corev1.ResourceStorage]},
+			},
+		},
+	}
+	_, err = client.CoreV1().PersistentVolumeClaims(ns).Create(ctx, pvc, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("pvc: %w", err)
+	}
+	return nil
+}
+
+// deleteVolumeCopies deletes the PVs bindVolumeCopy made for namespace ns. They
+// are retained, so the data stays; the PV objects would otherwise be left behind
+// by every deleted sandbox.
+func deleteVolumeCopies(ctx context.Context, client kubernetes.Interface, ns string) error {
+	pvs, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{LabelSelector: volumeCopyLabel + "=" + ns})
+	if err != nil {
+		return err
+	}
+	for _, pv := range pvs.Items {
+		if err := client.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasAccessMode(modes []corev1.PersistentVolumeAccessMode, want corev1.PersistentVolumeAccessMode) bool {
+	for _, m := range modes {
+		if m == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckEnvFromSources(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "api-keys", Namespace: "sbx-a"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "sbx-a"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "controller-token", Namespace: "shared"}},
+	)
+	cfg := podConfigFromRequest(api.CreateSandboxRequest{EnvFromSecret: []string{"api-keys"}, EnvFromConfigMap: []string{"settings"}})
+	if err := checkEnvFromSources(context.Background(), client, "sbx-a", cfg.envFrom); err != nil {
+		t.Fatalf("checkEnvFromSources: %v", err)
+	}
+
+	// Objects in other namespaces are neither used nor copied in.
+	for _, req := range []api.CreateSandboxRequest{
+		{EnvFromSecret: []string{"controller-token"}},
+		{EnvFromConfigMap: []string{"nope"}},
+	} {
+		err := checkEnvFromSources(context.Background(), client, "sbx-a", podConfigFromRequest(req).envFrom)
+		if !apierrors.IsNotFound(err) || !strings.Contains(err.Error(), "not found in sbx-a") {
+			t.Fatalf("%+v: err = %v, want a NotFound naming sbx-a", req, err)
+		}
+	}
+	if _, err := client.CoreV1().Secrets("sbx-a").Get(context.Background(), "controller-token", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("secret copied into the sandbox namespace (err = %v)", err)
+	}
+}
+
+func TestCreateWithMissingEnvFromSource(t *testing.T) {
+	s := newTestServer()
+	w := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: "a", EnvFromSecret: []string{"api-keys"}})
+	if w.Code != 400 || !strings.Contains(w.Body.String(), `secret \"api-keys\" not found`) {
+		t.Fatalf("create: %d %s, want 400 naming the secret", w.Code, w.Body.String())
+	}
+	if _, err := s.client.CoreV1().Namespaces().Get(context.Background(), "sbx-a", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("namespace after a refused create: %v, want none created", err)
+	}
+}
+
+func TestSandboxPodSpecEnvFrom(t *testing.T) {
+	req := api.CreateSandboxRequest{EnvFromSecret: []string{"creds"}, EnvFromConfigMap: []string{"settings"}, Env: map[string]string{"A": "1"}}
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), mapToEnvVars(req.Env), podConfigFromRequest(req))
+	got := spec.Containers[0].EnvFrom
+	if len(got) != 2 || got[0].ConfigMapRef == nil || got[0].ConfigMapRef.Name != "settings" || got[1].SecretRef == nil || got[1].SecretRef.Name != "creds" {
+		t.Fatalf("EnvFrom = %+v, want configmap settings then secret creds", got)
+	}
+	if len(spec.Containers[0].Env) != 1 {
+		t.Errorf("Env = %+v, want the inline env kept alongside envFrom", spec.Containers[0].Env)
+	}
+}
+
+func claimIn(ns string, mode corev1.PersistentVolumeAccessMode) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "dataset", Namespace: ns},
+		Spec:       corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{mode}},
+	}
+}
+
+func TestEnsureVolumeClaimsUsesSandboxNamespaceClaim(t *testing.T) {
+	client := fake.NewSimpleClientset(claimIn("sbx-a", corev1.ReadOnlyMany))
+	req := api.CreateSandboxRequest{Volumes: []api.VolumeSpec{{Name: "data", Type: "pvc", ClaimName: "dataset", MountPath: "/data", ReadOnly: true}}}
+	cfg := podConfigFromRequest(req)
+	if err := ensureVolumeClaims(context.Background(), client, "sbx-a", cfg.volumes); err != nil {
+		t.Fatalf("ensureVolumeClaims: %v", err)
+	}
+	pvs, err := client.CoreV1().PersistentVolumes().List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(pvs.Items) != 0 {
+		t.Errorf("pvs = %v, %v; want none created", pvs, err)
+	}
+
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, cfg)
+	mounts := spec.Containers[0].VolumeMounts
+	if m := mounts[len(mounts)-1]; m.Name != "data" || m.MountPath != "/data" || !m.ReadOnly {
+		t.Errorf("last mount = %+v, want data at /data read-only", m)
+	}
+	vols := spec.Volumes
+	if v := vols[len(vols)-1]; v.PersistentVolumeClaim == nil || v.PersistentVolumeClaim.ClaimName != "dataset" {
+		t.Errorf("last volume = %+v, want claim dataset", v)
+	}
+}
+
+func TestEnsureVolumeClaimsRejectsUnusableClaims(t *testing.T) {
+	vols := podConfigFromRequest(api.CreateSandboxRequest{Volumes: []api.VolumeSpec{{Name: "data", Type: "pvc", ClaimName: "dataset", MountPath: "/data"}}}).volumes
+	for _, tt := range []struct {
+		name    string
+		claim   *corev1.PersistentVolumeClaim
+		wantErr string
+	}{
+		{"read write once", claimIn("sbx-a", corev1.ReadWriteOnce), "ReadWriteMany"},
+		{"read only for a writable mount", claimIn("sbx-a", corev1.ReadOnlyMany), "ReadWriteMany"},
+		// Claims in other namespaces are not shared.
+		{"only in another namespace", claimIn("shared", corev1.ReadWriteMany), "not found in sbx-a"},
+	} {
+		client := fake.NewSimpleClientset(tt.claim)
+		err := ensureVolumeClaims(context.Background(), client, "sbx-a", vols)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want one mentioning %q", tt.name, err, tt.wantErr)
+		}
+		if pvs, _ := client.CoreV1().PersistentVolumes().List(context.Background(), metav1.ListOptions{}); len(pvs.Items) != 0 {
+			t.Errorf("%s: pv created: %v", tt.name, pvs.Items)
+		}
+	}
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"sandbox/pkg/api"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -20,12 +25,53 @@ type cacheConfig struct {
 	pvcSize         string
 	pvcStorageClass string
 	pvcAccessMode   string
+	// sharedNamespace and sharedClaim locate the shared-pvc cache claim; with
+	// sharedReadOnly sandboxes mount it read-only.
+	sharedNamespace string
+	sharedClaim     string
+	sharedReadOnly  bool
+}
+
+type podConfig struct {
+	serviceAccountName string
+	// args are the sandbox container's args; the command is passed separately.
+	args []string
+	// automountToken is nil to leave the choice to the service account.
+	automountToken    *bool
+	envFrom           []corev1.EnvFromSource
+	tolerations       []corev1.Toleration
+	restartPolicy     corev1.RestartPolicy
+	priorityClassName string
+	workspacePath     string
+	cachePath         string
+	hostname          string
+	subdomain         string
+	// labels are set on the sandbox pod.
+	labels map[string]string
+	// spread adds soft anti-affinity against other sandbox pods.
+	spread         bool
+	topologySpread []corev1.TopologySpreadConstraint
+
+	shareProcessNamespace *bool
+	activeDeadlineSeconds *int64
+	readinessGates        []corev1.PodReadinessGate
+	startupProbe          *corev1.Probe
+	initContainers        []corev1.Container
+	dnsPolicy             corev1.DNSPolicy
+	dnsServers            []string
+	// overlay is the request's pod_spec_overlay, applied by ensurePod.
+	overlay json.RawMessage
+	// volumes and mounts are extra volumes requested at create time.
+	volumes []corev1.Volume
+	mounts  []corev1.VolumeMount
 }
 
 type streamConfig struct {
-	sidecarImage string
-	endpoint     string
-	eventsDir    string
+	sidecarImage  string
+	endpoint      string
+	eventsDir     string
+	rateBytes     int
+	statsInterval time.Duration
 }
 
 func cacheConfigFromEnv() cacheConfig {
@@ -35,15 +81,318 @@ func cacheConfigFromEnv() cacheConfig {
 		pvcSize:         getenv("SANDBOX_CACHE_PVC_SIZE", "5Gi"),
 		pvcStorageClass: getenv("SANDBOX_CACHE_PVC_STORAGE_CLASS", ""),
 		pvcAccessMode:   getenv("SANDBOX_CACHE_PVC_ACCESS_MODE", "ReadWriteOnce"),
+		sharedNamespace: sharedCacheNamespace(),
+		sharedClaim:     getenv("SANDBOX_CACHE_SHARED_PVC", defaultSharedCacheClaim),
+		sharedReadOnly:  getenvBool("SANDBOX_CACHE_SHARED_READ_ONLY", false),
+	}
+}
+
+func podConfigFromEnv() podConfig {
+	cfg := podConfig{
+		serviceAccountName: getenv("SANDBOX_SERVICE_ACCOUNT", ""),
+		automountToken:     automountTokenFromEnv(),
+		priorityClassName:  getenv("SANDBOX_PRIORITY_CLASS", ""),
+		spread:             getenvBool("SANDBOX_SPREAD", false),
+	}
+	cfg.workspacePath, cfg.cachePath = mountPathsFromEnv()
+	if topologySpreadApplies() {
+		// Checked at startup.
+		spread, _ := parseTopologySpread(getenv("SANDBOX_TOPOLOGY_SPREAD", ""))
+		cfg.topologySpread = topologySpreadConstraints(spread)
+	}
+	if getenvBool("SANDBOX_DEFAULT_TOLERATIONS", true) {
+		cfg.tolerations = defaultTolerations()
+	}
+	if getenvBool("SANDBOX_USE_INIT", false) {
+		// With a shared PID namespace the pause container is PID 1 and reaps the
+		// zombies a sandbox command leaves behind.
+		on := true
+		cfg.shareProcessNamespace = &on
+	}
+	dnsPolicy, dnsServers := dnsFromEnv()
+	cfg.dnsPolicy, cfg.dnsServers = corev1.DNSPolicy(dnsPolicy), dnsServers
+	return cfg
+}
+
+const (
+	defaultWorkspacePath = "/workspace"
+	defaultCachePath     = "/cache"
+)
+
+func mountPathsFromEnv() (workspace, cache string) {
+	return path.Clean(getenv("SANDBOX_WORKSPACE_PATH", defaultWorkspacePath)), path.Clean(getenv("SANDBOX_CACHE_PATH", defaultCachePath))
+}
+
+// mountPathsFromRequest returns the workspace and cache mount paths for req, falling
+// back to the configured paths.
+func mountPathsFromRequest(req api.CreateSandboxRequest) (workspace, cache string) {
+	workspace, cache = mountPathsFromEnv()
+	if req.WorkspacePath != "" {
+		workspace = path.Clean(req.WorkspacePath)
+	}
+	if req.CachePath != "" {
+		cache = path.Clean(req.CachePath)
+	}
+	return workspace, cache
+}
+
+// sandboxPodLabel is set on every sandbox pod, warm or not, so scheduling rules can
+// select sandboxes across namespaces.
+const sandboxPodLabel = "sbx.sandbox"
+
+// spreadAffinity prefers nodes that are not already running a sandbox pod. It is
+// soft, so a busy cluster still schedules. Sandboxes live in their own namespaces,
+// hence the empty namespace selector.
+func spreadAffinity() *corev1.Affinity {
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{sandboxPodLabel: "true"},
+					},
+					NamespaceSelector: &metav1.LabelSelector{},
+					TopologyKey:       corev1.LabelHostname,
+				},
+			}},
+		},
+	}
+}
+
+func defaultTolerations() []corev1.Toleration {
+	return []corev1.Toleration{
+		{
+			Key:      "node-role.kubernetes.io/control-plane",
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffectNoSchedule,
+		},
+		{
+			Key:      "node.kubernetes.io/not-ready",
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffectNoSchedule,
+		},
+	}
+}
+
+// automountTokenFromEnv returns the default automountServiceAccountToken for sandbox
+// pods: SANDBOX_AUTOMOUNT_SERVICE_ACCOUNT_TOKEN when it is set, off under
+// SANDBOX_HARDENED, and otherwise unset, so the service account decides as it does
+// for any pod.
+func automountTokenFromEnv() *bool {
+	const key = "SANDBOX_AUTOMOUNT_SERVICE_ACCOUNT_TOKEN"
+	if _, set := configBool(key); set || os.Getenv(key) != "" {
+		v := getenvBool(key, false)
+		return &v
+	}
+	if getenvBool("SANDBOX_HARDENED", false) {
+		off := false
+		return &off
+	}
+	return nil
+}
+
+func podConfigFromRequest(req api.CreateSandboxRequest) podConfig {
+	cfg := podConfigFromEnv()
+	if req.ServiceAccountName != "" {
+		cfg.serviceAccountName = req.ServiceAccountName
+	}
+	if req.PriorityClassName != "" {
+		cfg.priorityClassName = req.PriorityClassName
+	}
+	if req.AutomountServiceAccountToken != nil {
+		cfg.automountToken = req.AutomountServiceAccountToken
+	}
+	cfg.workspacePath, cfg.cachePath = mountPathsFromRequest(req)
+	cfg.hostname = req.Hostname
+	cfg.subdomain = req.Subdomain
+	if req.Subdomain != "" {
+		// Selected by the headless Service for the subdomain.
+		cfg.labels = map[string]string{"sbx.subdomain": req.Subdomain}
+	}
+	if req.Spread != nil {
+		cfg.spread = *req.Spread
+	}
+	if len(req.TopologySpread) > 0 {
+		cfg.topologySpread = topologySpreadConstraints(req.TopologySpread)
+	}
+	if req.ShareProcessNamespace != nil {
+		cfg.shareProcessNamespace = req.ShareProcessNamespace
+	}
+	cfg.activeDeadlineSeconds = req.ActiveDeadlineSeconds
+	for _, gate := range req.ReadinessGates {
+		cfg.readinessGates = append(cfg.readinessGates, corev1.PodReadinessGate{ConditionType: corev1.PodConditionType(gate)})
+	}
+	dnsPolicy, dnsServers := dnsFromRequest(req.DNSPolicy, req.DNSServers)
+	cfg.dnsPolicy, cfg.dnsServers = corev1.DNSPolicy(dnsPolicy), dnsServers
+	cfg.startupProbe = startupProbe(req.StartupProbe)
+	if clone := gitCloneContainer(req.GitRepo, cfg.workspacePath); clone != nil {
+		cfg.initContainers = append(cfg.initContainers, *clone)
+	}
+	cfg.overlay = req.PodSpecOverlay
+	cfg.args = req.Args
+	for _, t := range req.Tolerations {
+		cfg.tolerations = append(cfg.tolerations, corev1.Toleration{
+			Key:      t.Key,
+			Operator: corev1.TolerationOperator(t.Operator),
+			Value:    t.Value,
+			Effect:   corev1.TaintEffect(t.Effect),
+		})
+	}
+	// One-shot commands should be able to finish; the default sleep keeps running.
+	switch {
+	case req.RestartPolicy != "":
+		cfg.restartPolicy = corev1.RestartPolicy(req.RestartPolicy)
+	case len(req.Command) > 0 || len(req.Args) > 0:
+		cfg.restartPolicy = corev1.RestartPolicyOnFailure
+	default:
+		cfg.restartPolicy = corev1.RestartPolicyAlways
+	}
+	// Explicit container env always takes precedence over envFrom sources.
+	for _, name := range req.EnvFromConfigMap {
+		cfg.envFrom = append(cfg.envFrom, corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}},
+		})
+	}
+	for _, name := range req.EnvFromSecret {
+		cfg.envFrom = append(cfg.envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}},
+		})
+	}
+	for _, v := range req.Volumes {
+		vol := corev1.Volume{Name: v.Name}
+		if v.Type == "pvc" {
+			vol.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: v.ClaimName, ReadOnly: v.ReadOnly}
+		} else {
+			vol.EmptyDir = &corev1.EmptyDirVolumeSource{}
+			if v.SizeLimit != "" {
+				if q, err := resource.ParseQuantity(v.SizeLimit); err == nil {
+					vol.EmptyDir.SizeLimit = &q
+				}
+			}
+		}
+		cfg.volumes = append(cfg.volumes, vol)
+		cfg.mounts = append(cfg.mounts, corev1.VolumeMount{Name: v.Name, MountPath: path.Clean(v.MountPath), ReadOnly: v.ReadOnly})
+	}
+	return cfg
+}
+
+// ensureVolumeClaims checks every PVC referenced by an extra volume with
+// checkVolumeClaim.
+func ensureVolumeClaims(ctx context.Context, client kubernetes.Interface, ns string, vols []corev1.Volume) error {
+	for _, v := range vols {
+		if v.PersistentVolumeClaim == nil {
+			continue
+		}
+		if err := checkVolumeClaim(ctx, client, ns, v.PersistentVolumeClaim.ClaimName, v.PersistentVolumeClaim.ReadOnly); err != nil {
+			return fmt.Errorf("volume %q: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// ensurePriorityClass reports an error when name does not exist. Other lookup failures
+// (e.g. missing RBAC for priorityclasses) are ignored and left to pod admission.
+func ensurePriorityClass(ctx context.Context, client kubernetes.Interface, name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := client.SchedulingV1().PriorityClasses().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("priority class %q: %w", name, err)
+	}
+	return nil
+}
+
+// ensureSubdomainService creates the headless Service that gives a pod with
+// spec.subdomain its DNS records. Not-ready addresses are published so the name
+// resolves while the sandbox is still starting.
+func ensureSubdomainService(ctx context.Context, client kubernetes.Interface, ns, subdomain string) error {
+	if subdomain == "" {
+		return nil
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: subdomain},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 map[string]string{"sbx.subdomain": subdomain},
+			PublishNotReadyAddresses: true,
+		},
+	}
+	_, err := client.CoreV1().Services(ns).Create(ctx, svc, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
 	}
+	return err
+}
+
+// ensureStorageClass reports an error listing the available classes when name does
+// not exist. Like ensurePriorityClass, other lookup failures are left to the
+// provisioner.
+func ensureStorageClass(ctx context.Context, client kubernetes.Interface, name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := client.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return nil
+	}
+	list, listErr := client.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if listErr != nil {
+		return fmt.Errorf("storage class %q not found", name)
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, sc := range list.Items {
+		names = append(names, sc.Name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("storage class %q not found (available: %s)", name, strings.Join(names, ", "))
+}
+
+// checkEnvFromSources makes sure every referenced ConfigMap and Secret exists in ns.
+func checkEnvFromSources(ctx context.Context, client kubernetes.Interface, ns string, sources []corev1.EnvFromSource) error {
+	for _, src := range sources {
+		var err error
+		switch {
+		case src.ConfigMapRef != nil:
+			err = checkConfigMap(ctx, client, ns, src.ConfigMapRef.Name)
+		case src.SecretRef != nil:
+			err = checkSecret(ctx, client, ns, src.SecretRef.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("env_from: %w", err)
+		}
+	}
+	return nil
 }
 
 func streamConfigFromEnv() streamConfig {
-	return streamConfig{
-		sidecarImage: getenv("SANDBOX_STREAM_SIDECAR_IMAGE", ""),
-		endpoint:     getenv("SANDBOX_STREAM_ENDPOINT", ""),
-		eventsDir:    getenv("SANDBOX_STREAM_EVENTS_DIR", "/sbx-events"),
+	cfg := streamConfig{
+		sidecarImage:  getenv("SANDBOX_STREAM_SIDECAR_IMAGE", ""),
+		endpoint:      getenv("SANDBOX_STREAM_ENDPOINT", ""),
+		eventsDir:     getenv("SANDBOX_STREAM_EVENTS_DIR", "/sbx-events"),
+		rateBytes:     getenvInt("SANDBOX_STREAM_RATE_BYTES", 0),
+		statsInterval: getenvDuration("SANDBOX_STREAM_STATS_INTERVAL", 10*time.Second),
 	}
+	// The sidecar exits without an endpoint, so its events would never arrive (and
+	// the pod would never be Ready). Stream from the control plane instead; main
+	// warns about this at startup.
+	if cfg.endpoint == "" {
+		cfg.sidecarImage = ""
+	}
+	return cfg
+}
+
+// streamConfigWarnings describes stream settings that can't take effect.
+func streamConfigWarnings() []string {
+	var out []string
+	if getenv("SANDBOX_STREAM_SIDECAR_IMAGE", "") != "" && getenv("SANDBOX_STREAM_ENDPOINT", "") == "" {
+		out = append(out, "SANDBOX_STREAM_SIDECAR_IMAGE is set but SANDBOX_STREAM_ENDPOINT is empty; the sidecar is not added and exec output streams from the control plane")
+	}
+	if os.Getenv("SANDBOX_STREAM_MODE") != "" {
+		out = append(out, "SANDBOX_STREAM_MODE is not a setting; sidecar streaming is enabled by setting SANDBOX_STREAM_SIDECAR_IMAGE and SANDBOX_STREAM_ENDPOINT")
+	}
+	return out
 }
 
 func cacheConfigFromRequest(req api.CreateSandboxRequest) cacheConfig {
@@ -64,7 +413,7 @@ func cacheConfigFromRequest(req api.CreateSandboxRequest) cacheConfig {
 }
 
 func defaultSandboxEnv() map[string]string {
-	envs := map[string]string{}
+	envs := proxyEnv()
 	cfgEnv := configEnv()
 	for k, v := range cfgEnv {
 		if k == "" {
@@ -89,6 +438,52 @@ func defaultSandboxEnv() map[string]string {
 	return envs
 }
 
+// clusterNoProxy keeps in-cluster traffic off the egress proxy.
+var clusterNoProxy = []string{"localhost", "127.0.0.1", ".svc", ".svc.cluster.local", ".cluster.local"}
+
+// proxyEnv returns the configured egress proxy variables in both the upper- and
+// lowercase spellings tools look for. NO_PROXY always includes cluster-internal
+// names and the API server address. Explicit sandbox env wins over these.
+func proxyEnv() map[string]string {
+	envs := map[string]string{}
+	httpProxy := getenv("SANDBOX_HTTP_PROXY", "")
+	httpsProxy := getenv("SANDBOX_HTTPS_PROXY", "")
+	if httpProxy == "" && httpsProxy == "" {
+		return envs
+	}
+	noProxy := append([]string{}, clusterNoProxy...)
+	if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
+		noProxy = append(noProxy, host)
+	}
+	noProxy = append(noProxy, splitCSV(getenv("SANDBOX_NO_PROXY", ""))...)
+	set := func(name, value string) {
+		if value != "" {
+			envs[name] = value
+			envs[strings.ToLower(name)] = value
+		}
+	}
+	set("HTTP_PROXY", httpProxy)
+	set("HTTPS_PROXY", httpsProxy)
+	set("NO_PROXY", joinCSV(noProxy))
+	return envs
+}
+
+// mirrorProxyOverrides copies a per-request proxy variable to its other spelling so
+// the upper- and lowercase forms never disagree.
+func mirrorProxyOverrides(env, overrides map[string]string) {
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		lower := strings.ToLower(name)
+		upperVal, hasUpper := overrides[name]
+		lowerVal, hasLower := overrides[lower]
+		switch {
+		case hasUpper && !hasLower:
+			env[lower] = upperVal
+		case hasLower && !hasUpper:
+			env[name] = lowerVal
+		}
+	}
+}
+
 func mergeEnv(base map[string]string, overlay map[string]string) map[string]string {
 	if base == nil {
 		base = map[string]string{}
@@ -156,10 +551,45 @@ func normalizeAllowedHosts(req api.CreateSandboxRequest) (allowed []string, disa
 			disallowed = splitCSV(getenv("SANDBOX_DISALLOWED_HOSTS", ""))
 		}
 	}
-	return allowed, disallowed
+	return allowed, withForcedHosts(disallowed)
+}
+
+// withForcedHosts appends SANDBOX_FORCE_DISALLOWED_HOSTS to disallowed. Disallowed
+// entries take precedence over allowed ones, so requests can't re-enable these hosts.
+func withForcedHosts(disallowed []string) []string {
+	forced := splitCSV(getenv("SANDBOX_FORCE_DISALLOWED_HOSTS", ""))
+	if len(forced) == 0 {
+		return disallowed
+	}
+	out := append([]string{}, disallowed...)
+	for _, h := range forced {
+		if !containsFold(out, h) {
+			out = append(out, h)
+		}
+	}
+	return out
 }
 
-func ensureCachePVC(ctx context.Context, client *kubernetes.Clientset, ns, name string, cfg cacheConfig) error {
+// ensureServiceAccount creates the service account name in ns when it doesn't exist.
+// Sandbox namespaces start out with only "default", so pod admission would reject a
+// pod naming any other account. The account it creates has no permissions of its
+// own; bind roles to it in the sandbox namespace to grant them.
+func ensureServiceAccount(ctx context.Context, client kubernetes.Interface, ns, name string) error {
+	if name == "" || name == "default" {
+		return nil
+	}
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	_, err := client.CoreV1().ServiceAccounts(ns).Create(ctx, sa, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+func ensureCachePVC(ctx context.Context, client kubernetes.Interface, ns, name string, cfg cacheConfig) error {
+	if cfg.mode == "shared-pvc" {
+		return ensureSharedCacheClaim(ctx, client, ns, cfg)
+	}
 	if cfg.mode != "pvc" {
 		return nil
 	}
@@ -207,11 +637,11 @@ func sandboxCacheVolume(cfg cacheConfig) corev1.Volume {
 				HostPath: &corev1.HostPathVolumeSource{Path: cfg.hostPath},
 			},
 		}
-	case "pvc":
+	case "pvc", "shared-pvc":
 		return corev1.Volume{
 			Name: "cache",
 			VolumeSource: corev1.VolumeSource{
-				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "cache"},
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "cache", ReadOnly: cfg.mode == "shared-pvc" && cfg.sharedReadOnly},
 			},
 		}
 	default:
@@ -222,15 +652,64 @@ func sandboxCacheVolume(cfg cacheConfig) corev1.Volume {
 	}
 }
 
-func sandboxPodSpec(image string, cmd []string, volumeMode, pvcName string, cacheCfg cacheConfig, envVars []corev1.EnvVar) corev1.PodSpec {
+// expandCommand substitutes ${VAR} references in cmd with literal values from envVars.
+// References to unknown variables are left untouched so the container can resolve them
+// at runtime; "$${VAR}" escapes the reference and yields a literal "${VAR}".
+func expandCommand(cmd []string, envVars []corev1.EnvVar) []string {
 	if len(cmd) == 0 {
+		return cmd
+	}
+	values := map[string]string{}
+	for _, ev := range envVars {
+		if ev.ValueFrom == nil {
+			values[ev.Name] = ev.Value
+		}
+	}
+	out := make([]string, 0, len(cmd))
+	for _, arg := range cmd {
+		out = append(out, expandVars(arg, values))
+	}
+	return out
+}
+
+func expandVars(s string, values map[string]string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], "$${") {
+			b.WriteString("${")
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(s[i:], "${") {
+			if end := strings.IndexByte(s[i+2:], '}'); end > 0 {
+				name := s[i+2 : i+2+end]
+				if val, ok := values[name]; ok {
+					b.WriteString(val)
+					i += end + 2
+					continue
+				}
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func sandboxPodSpec(image string, cmd []string, volumeMode, pvcName string, cacheCfg cacheConfig, envVars []corev1.EnvVar, podCfg podConfig) corev1.PodSpec {
+	// Args alone keep the image's ENTRYPOINT; with neither, the sandbox just sleeps.
+	if len(cmd) == 0 && len(podCfg.args) == 0 {
 		cmd = []string{"sleep", "infinity"}
 	}
+	cmd = expandCommand(cmd, envVars)
+	args := expandCommand(podCfg.args, envVars)
 	vols := []corev1.Volume{
 		sandboxCacheVolume(cacheCfg),
 	}
 	mounts := []corev1.VolumeMount{
-		{Name: "cache", MountPath: "/cache"},
+		{Name: "cache", MountPath: podCfg.cachePath, ReadOnly: cacheCfg.mode == "shared-pvc" && cacheCfg.sharedReadOnly},
 	}
 	if volumeMode == "pvc" {
 		vols = append(vols, corev1.Volume{
@@ -245,7 +724,7 @@ func sandboxPodSpec(image string, cmd []string, volumeMode, pvcName string, cach
 			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
 		})
 	}
-	mounts = append(mounts, corev1.VolumeMount{Name: "workspace", MountPath: "/workspace"})
+	mounts = append(mounts, corev1.VolumeMount{Name: "workspace", MountPath: podCfg.workspacePath})
 
 	streamCfg := streamConfigFromEnv()
 	if streamCfg.sidecarImage != "" {
@@ -255,15 +734,20 @@ func sandboxPodSpec(image string, cmd []string, volumeMode, pvcName string, cach
 		})
 		mounts = append(mounts, corev1.VolumeMount{Name: "sbx-events", MountPath: streamCfg.eventsDir})
 	}
+	vols = append(vols, podCfg.volumes...)
+	mounts = append(mounts, podCfg.mounts...)
 
 	containers := []corev1.Container{
 		{
 			Name:         "sandbox",
 			Image:        image,
 			Command:      cmd,
+			Args:         args,
 			VolumeMounts: mounts,
 			Resources:    sandboxResources(),
 			Env:          envVars,
+			EnvFrom:      podCfg.envFrom,
+			StartupProbe: podCfg.startupProbe,
 		},
 	}
 	if streamCfg.sidecarImage != "" {
@@ -287,20 +771,31 @@ func sandboxPodSpec(image string, cmd []string, volumeMode, pvcName string, cach
 		})
 	}
 
+	var affinity *corev1.Affinity
+	if podCfg.spread {
+		affinity = spreadAffinity()
+	}
+	var dnsConfig *corev1.PodDNSConfig
+	if len(podCfg.dnsServers) > 0 {
+		dnsConfig = &corev1.PodDNSConfig{Nameservers: podCfg.dnsServers}
+	}
 	return corev1.PodSpec{
-		Tolerations: []corev1.Toleration{
-			{
-				Key:      "node-role.kubernetes.io/control-plane",
-				Operator: corev1.TolerationOpExists,
-				Effect:   corev1.TaintEffectNoSchedule,
-			},
-			{
-				Key:      "node.kubernetes.io/not-ready",
-				Operator: corev1.TolerationOpExists,
-				Effect:   corev1.TaintEffectNoSchedule,
-			},
-		},
-		Containers: containers,
-		Volumes:    vols,
+		Affinity:                     affinity,
+		TopologySpreadConstraints:    podCfg.topologySpread,
+		Tolerations:                  podCfg.tolerations,
+		InitContainers:               podCfg.initContainers,
+		Containers:                   containers,
+		Volumes:                      vols,
+		ServiceAccountName:           podCfg.serviceAccountName,
+		AutomountServiceAccountToken: podCfg.automountToken,
+		RestartPolicy:                podCfg.restartPolicy,
+		PriorityClassName:            podCfg.priorityClassName,
+		Hostname:                     podCfg.hostname,
+		Subdomain:                    podCfg.subdomain,
+		ShareProcessNamespace:        podCfg.shareProcessNamespace,
+		ActiveDeadlineSeconds:        podCfg.activeDeadlineSeconds,
+		ReadinessGates:               podCfg.readinessGates,
+		DNSPolicy:                    podCfg.dnsPolicy,
+		DNSConfig:                    dnsConfig,
 	}
 }
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExpandVars(t *testing.T) {
+	values := map[string]string{"HOME": "/home/agent", "EMPTY": ""}
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"${HOME}/bin", "/home/agent/bin"},
+		{"${HOME}:${HOME}", "/home/agent:/home/agent"},
+		{"x${EMPTY}y", "xy"},
+		{"${UNSET}", "${UNSET}"},
+		{"$${HOME}", "${HOME}"},
+		{"$HOME", "$HOME"},
+		{"${HOME", "${HOME"},
+		{"${}", "${}"},
+	}
+	for _, tt := range tests {
+		if got := expandVars(tt.in, values); got != tt.want {
+			t.Errorf("expandVars(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSandboxPodSpecExpandsCommand(t *testing.T) {
+	env := []corev1.EnvVar{
+		{Name: "TASK", Value: "build"},
+		{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}}},
+	}
+	spec := sandboxPodSpec("img", []string{"run.sh", "${TASK}", "${TOKEN}", "$${TASK}"}, "emptydir", "", cacheConfig{mode: "emptydir"}, env, podConfig{})
+	want := []string{"run.sh", "build", "${TOKEN}", "${TASK}"}
+	if got := spec.Containers[0].Command; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+}
+
+func TestSandboxPodSpecCommandAndArgs(t *testing.T) {
+	env := []corev1.EnvVar{{Name: "PORT", Value: "8080"}}
+	tests := []struct {
+		name        string
+		req         api.CreateSandboxRequest
+		wantCommand []string
+		wantArgs    []string
+	}{
+		{name: "neither sleeps", wantCommand: []string{"sleep", "infinity"}},
+		{name: "command only", req: api.CreateSandboxRequest{Command: []string{"make", "test"}}, wantCommand: []string{"make", "test"}},
+		// The image's ENTRYPOINT is kept and gets the args.
+		{name: "args only", req: api.CreateSandboxRequest{Args: []string{"--port", "${PORT}"}}, wantArgs: []string{"--port", "8080"}},
+		{
+			name:        "both",
+			req:         api.CreateSandboxRequest{Command: []string{"python", "-m"}, Args: []string{"http.server", "${PORT}"}},
+			wantCommand: []string{"python", "-m"},
+			wantArgs:    []string{"http.server", "8080"},
+		},
+	}
+	for _, tt := range tests {
+		spec := sandboxPodSpec("img", tt.req.Command, "emptydir", "", cacheConfig{mode: "emptydir"}, env, podConfigFromRequest(tt.req))
+		c := spec.Containers[0]
+		if !reflect.DeepEqual(c.Command, tt.wantCommand) || !reflect.DeepEqual(c.Args, tt.wantArgs) {
+			t.Errorf("%s: command %q args %q, want %q and %q", tt.name, c.Command, c.Args, tt.wantCommand, tt.wantArgs)
+		}
+	}
+}
+
+func TestAutomountTokenFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		hardened  string
+		automount string
+		want      *bool
+	}{
+		{name: "unset leaves it to the service account"},
+		{name: "hardened turns it off", hardened: "true", want: boolPtr(false)},
+		{name: "explicit on wins over hardened", hardened: "true", automount: "1", want: boolPtr(true)},
+		{name: "explicit off", automount: "0", want: boolPtr(false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SANDBOX_HARDENED", tt.hardened)
+			t.Setenv("SANDBOX_AUTOMOUNT_SERVICE_ACCOUNT_TOKEN", tt.automount)
+			got := automountTokenFromEnv()
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("automountTokenFromEnv() = %v, want %v", fmtBoolPtr(got), fmtBoolPtr(tt.want))
+			}
+		})
+	}
+}
+
+func TestSandboxPodSpecHonorsAutomountFalse(t *testing.T) {
+	t.Setenv("SANDBOX_AUTOMOUNT_SERVICE_ACCOUNT_TOKEN", "1")
+	req := api.CreateSandboxRequest{ServiceAccountName: "agent", AutomountServiceAccountToken: boolPtr(false)}
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(req))
+	if spec.ServiceAccountName != "agent" {
+		t.Errorf("ServiceAccountName = %q, want agent", spec.ServiceAccountName)
+	}
+	if a := spec.AutomountServiceAccountToken; a == nil || *a {
+		t.Errorf("AutomountServiceAccountToken = %v, want false", fmtBoolPtr(a))
+	}
+}
+
+func TestEnsureServiceAccount(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := ensureServiceAccount(ctx, client, "sbx-a", "agent"); err != nil {
+			t.Fatalf("ensureServiceAccount #%d: %v", i+1, err)
+		}
+	}
+	if _, err := client.CoreV1().ServiceAccounts("sbx-a").Get(ctx, "agent", metav1.GetOptions{}); err != nil {
+		t.Fatalf("service account not created: %v", err)
+	}
+	if err := ensureServiceAccount(ctx, client, "sbx-a", "default"); err != nil {
+		t.Fatalf("ensureServiceAccount default: %v", err)
+	}
+	if _, err := client.CoreV1().ServiceAccounts("sbx-a").Get(ctx, "default", metav1.GetOptions{}); err == nil {
+		t.Fatal("default service account created; Kubernetes provides it")
+	}
+}
+
+func boolPtr(v bool) *bool { return &v }
+
+func fmtBoolPtr(v *bool) string {
+	if v == nil {
+		return "nil"
+	}
+	if *v {
+		return "true"
+	}
+	return "false"
+}
+
+func TestSandboxPodSpecTolerations(t *testing.T) {
+	t.Setenv("SANDBOX_DEFAULT_TOLERATIONS", "false")
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if len(spec.Tolerations) != 0 {
+		t.Fatalf("Tolerations = %+v, want none with defaults disabled and none requested", spec.Tolerations)
+	}
+
+	req := api.CreateSandboxRequest{Tolerations: []api.Toleration{{Key: "gpu", Operator: "Exists", Effect: "NoSchedule"}}}
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(req))
+	if len(spec.Tolerations) != 1 || spec.Tolerations[0].Key != "gpu" {
+		t.Fatalf("Tolerations = %+v, want only the requested gpu toleration", spec.Tolerations)
+	}
+
+	t.Setenv("SANDBOX_DEFAULT_TOLERATIONS", "")
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(req))
+	if len(spec.Tolerations) != 3 {
+		t.Fatalf("Tolerations = %+v, want the two defaults plus the requested one", spec.Tolerations)
+	}
+}
+
+func TestSandboxPodSpecPriorityClass(t *testing.T) {
+	t.Setenv("SANDBOX_PRIORITY_CLASS", "sandbox-low")
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if spec.PriorityClassName != "sandbox-low" {
+		t.Errorf("PriorityClassName = %q, want the SANDBOX_PRIORITY_CLASS default", spec.PriorityClassName)
+	}
+	req := api.CreateSandboxRequest{PriorityClassName: "batch"}
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(req))
+	if spec.PriorityClassName != "batch" {
+		t.Errorf("PriorityClassName = %q, want the requested batch", spec.PriorityClassName)
+	}
+}
+
+func TestEnsurePriorityClass(t *testing.T) {
+	client := fake.NewSimpleClientset(&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "sandbox-low"}, Value: -10})
+	ctx := context.Background()
+	if err := ensurePriorityClass(ctx, client, "sandbox-low"); err != nil {
+		t.Errorf("existing class: %v", err)
+	}
+	if err := ensurePriorityClass(ctx, client, ""); err != nil {
+		t.Errorf("no class: %v", err)
+	}
+	if err := ensurePriorityClass(ctx, client, "missing"); err == nil {
+		t.Error("missing class accepted")
+	}
+}
+
+func TestSandboxPodSpecProxyEnv(t *testing.T) {
+	t.Setenv("SANDBOX_HTTP_PROXY", "http://proxy:3128")
+	t.Setenv("SANDBOX_HTTPS_PROXY", "http://proxy:3128")
+	t.Setenv("SANDBOX_NO_PROXY", "internal.example.com")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+
+	env := mergeEnv(defaultSandboxEnv(), map[string]string{"https_proxy": "http://other:8080"})
+	mirrorProxyOverrides(env, map[string]string{"https_proxy": "http://other:8080"})
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfig{mode: "emptydir"}, mapToEnvVars(env), podConfig{})
+	got := map[string]string{}
+	for _, e := range spec.Containers[0].Env {
+		got[e.Name] = e.Value
+	}
+	for _, name := range []string{"HTTP_PROXY", "http_proxy"} {
+		if got[name] != "http://proxy:3128" {
+			t.Errorf("%s = %q, want the configured proxy", name, got[name])
+		}
+	}
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy"} {
+		if got[name] != "http://other:8080" {
+			t.Errorf("%s = %q, want the request override in both spellings", name, got[name])
+		}
+	}
+	for _, name := range []string{"NO_PROXY", "no_proxy"} {
+		for _, want := range []string{".svc", ".cluster.local", "10.0.0.1", "internal.example.com"} {
+			if !strings.Contains(got[name], want) {
+				t.Errorf("%s = %q, want it to include %s", name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestProxyEnvUnset(t *testing.T) {
+	t.Setenv("SANDBOX_HTTP_PROXY", "")
+	t.Setenv("SANDBOX_HTTPS_PROXY", "")
+	if env := proxyEnv(); len(env) != 0 {
+		t.Errorf("proxyEnv() = %v, want nothing without a configured proxy", env)
+	}
+}
+
+func TestNormalizeAllowedHostsForcesDenied(t *testing.T) {
+	tests := []struct {
+		name           string
+		forced         string
+		req            api.CreateSandboxRequest
+		wantAllowed    []string
+		wantDisallowed []string
+	}{
+		{
+			name:        "request lists are kept",
+			req:         api.CreateSandboxRequest{AllowedHosts: []string{"github.com"}, DisallowedHosts: []string{"evil.com"}},
+			wantAllowed: []string{"github.com"}, wantDisallowed: []string{"evil.com"},
+		},
+		{
+			name:        "forced host is denied even when the request allows it",
+			forced:      "169.254.169.254",
+			req:         api.CreateSandboxRequest{AllowedHosts: []string{"169.254.169.254", "github.com"}},
+			wantAllowed: []string{"169.254.169.254", "github.com"}, wantDisallowed: []string{"169.254.169.254"},
+		},
+		{
+			name:        "forced hosts are added once, case-insensitively",
+			forced:      "Metadata.Internal,evil.com",
+			req:         api.CreateSandboxRequest{DisallowedHosts: []string{"metadata.internal"}},
+			wantAllowed: nil, wantDisallowed: []string{"metadata.internal", "evil.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SANDBOX_ALLOWED_HOSTS", "")
+			t.Setenv("SANDBOX_DISALLOWED_HOSTS", "")
+			t.Setenv("SANDBOX_FORCE_DISALLOWED_HOSTS", tt.forced)
+			allowed, disallowed := normalizeAllowedHosts(tt.req)
+			if strings.Join(allowed, ",") != strings.Join(tt.wantAllowed, ",") {
+				t.Errorf("allowed = %v, want %v", allowed, tt.wantAllowed)
+			}
+			if strings.Join(disallowed, ",") != strings.Join(tt.wantDisallowed, ",") {
+				t.Errorf("disallowed = %v, want %v", disallowed, tt.wantDisallowed)
+			}
+		})
+	}
+}
+
+func TestEnsureStorageClass(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "standard"}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}},
+	)
+	ctx := context.Background()
+	for _, name := range []string{"", "fast"} {
+		if err := ensureStorageClass(ctx, client, name); err != nil {
+			t.Errorf("ensureStorageClass(%q) = %v, want nil", name, err)
+		}
+	}
+	err := ensureStorageClass(ctx, client, "ssd")
+	if err == nil || !strings.Contains(err.Error(), `"ssd" not found (available: fast, standard)`) {
+		t.Fatalf("err = %v, want not found listing the available classes", err)
+	}
+}
+
+func TestCreateRejectsMissingCacheStorageClass(t *testing.T) {
+	s := newTestServer(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "standard"}})
+	w := serve(s.handleSandboxes, "POST", "/sandboxes", "/sandboxes", api.CreateSandboxRequest{
+		CacheMode: "pvc", CachePVCStorageClass: "missing",
+	})
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "available: standard") {
+		t.Fatalf("status = %d body %s, want 400 listing standard", w.Code, w.Body)
+	}
+	nsList, err := s.client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nsList.Items) != 0 {
+		t.Errorf("created %d namespaces for a rejected request", len(nsList.Items))
+	}
+}
+
+func TestSandboxPodSpecMountPaths(t *testing.T) {
+	t.Setenv("SANDBOX_WORKSPACE_PATH", "/app/")
+	t.Setenv("SANDBOX_CACHE_PATH", "/home/user/.cache")
+	mountPaths := func(spec corev1.PodSpec) map[string]string {
+		out := map[string]string{}
+		for _, m := range spec.Containers[0].VolumeMounts {
+			out[m.Name] = m.MountPath
+		}
+		return out
+	}
+
+	got := mountPaths(sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromEnv()))
+	if got["workspace"] != "/app" || got["cache"] != "/home/user/.cache" {
+		t.Errorf("configured mounts = %v, want workspace at /app and cache at /home/user/.cache", got)
+	}
+
+	req := api.CreateSandboxRequest{WorkspacePath: "/home/user/code"}
+	got = mountPaths(sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(req)))
+	if got["workspace"] != "/home/user/code" || got["cache"] != "/home/user/.cache" {
+		t.Errorf("request mounts = %v, want the workspace override and the configured cache path", got)
+	}
+}
+
+func TestSandboxPodSpecHostnameAndSubdomain(t *testing.T) {
+	cfg := podConfigFromRequest(api.CreateSandboxRequest{Hostname: "worker-0", Subdomain: "workers"})
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, cfg)
+	if spec.Hostname != "worker-0" || spec.Subdomain != "workers" {
+		t.Errorf("hostname/subdomain = %q/%q, want worker-0/workers", spec.Hostname, spec.Subdomain)
+	}
+	if cfg.labels["sbx.subdomain"] != "workers" {
+		t.Errorf("pod labels = %v, want sbx.subdomain=workers", cfg.labels)
+	}
+}
+
+func TestEnsureSubdomainService(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	if err := ensureSubdomainService(ctx, client, "sbx-a", ""); err != nil {
+		t.Fatalf("no subdomain: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ensureSubdomainService(ctx, client, "sbx-a", "workers"); err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+	}
+	svc, err := client.CoreV1().Services("sbx-a").Get(ctx, "workers", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone || !svc.Spec.PublishNotReadyAddresses {
+		t.Errorf("service spec = %+v, want headless and publishing not-ready addresses", svc.Spec)
+	}
+	if !reflect.DeepEqual(svc.Spec.Selector, map[string]string{"sbx.subdomain": "workers"}) {
+		t.Errorf("selector = %v, want sbx.subdomain=workers", svc.Spec.Selector)
+	}
+}
+
+func TestSandboxPodSpecSpread(t *testing.T) {
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if spec.Affinity != nil {
+		t.Fatalf("affinity set with SANDBOX_SPREAD off: %+v", spec.Affinity)
+	}
+
+	t.Setenv("SANDBOX_SPREAD", "true")
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if spec.Affinity == nil || spec.Affinity.PodAntiAffinity == nil {
+		t.Fatal("no pod anti-affinity with SANDBOX_SPREAD on")
+	}
+	if len(spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 0 {
+		t.Error("anti-affinity is required, want preferred only")
+	}
+	terms := spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 1 {
+		t.Fatalf("preferred terms = %d, want 1", len(terms))
+	}
+	term := terms[0].PodAffinityTerm
+	if term.TopologyKey != corev1.LabelHostname || term.LabelSelector.MatchLabels[sandboxPodLabel] != "true" || term.NamespaceSelector == nil {
+		t.Errorf("term = %+v, want sandbox pods on the same node across namespaces", term)
+	}
+
+	off := false
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{Spread: &off}))
+	if spec.Affinity != nil {
+		t.Error("affinity set although the request turned spread off")
+	}
+}
+
+func TestSandboxPodSpecShareProcessNamespace(t *testing.T) {
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if spec.ShareProcessNamespace != nil {
+		t.Errorf("shareProcessNamespace = %v by default, want unset", *spec.ShareProcessNamespace)
+	}
+	on := true
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{ShareProcessNamespace: &on}))
+	if spec.ShareProcessNamespace == nil || !*spec.ShareProcessNamespace {
+		t.Error("shareProcessNamespace not applied")
+	}
+}
+
+func TestSandboxPodSpecUseInit(t *testing.T) {
+	t.Setenv("SANDBOX_USE_INIT", "true")
+	// Warm pods are built from the env config alone.
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromEnv())
+	if spec.ShareProcessNamespace == nil || !*spec.ShareProcessNamespace {
+		t.Fatal("SANDBOX_USE_INIT did not share the process namespace, so nothing reaps zombies")
+	}
+	off := false
+	req := api.CreateSandboxRequest{ShareProcessNamespace: &off, PodSpecOverlay: json.RawMessage(`{"hostPID": true}`)}
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(req))
+	if spec.ShareProcessNamespace == nil || *spec.ShareProcessNamespace {
+		t.Error("a request's share_process_namespace=false did not override SANDBOX_USE_INIT")
+	}
+
+	t.Setenv("SANDBOX_ALLOW_POD_OVERLAY", "true")
+	if err := validateCreateRequest(req); err != nil {
+		t.Errorf("hostPID with the namespace opted out: %v", err)
+	}
+	req.ShareProcessNamespace = nil
+	if err := validateCreateRequest(req); err == nil {
+		t.Error("hostPID accepted although SANDBOX_USE_INIT shares the process namespace")
+	}
+}
+
+func TestSandboxPodSpecDNSPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		envPolicy  string
+		envServers string
+		req        api.CreateSandboxRequest
+		wantPolicy corev1.DNSPolicy
+		wantNS     []string
+	}{
+		{name: "unset", wantPolicy: ""},
+		{name: "Default from env", envPolicy: "Default", wantPolicy: corev1.DNSDefault},
+		{name: "ClusterFirst from env", envPolicy: "ClusterFirst", wantPolicy: corev1.DNSClusterFirst},
+		{name: "None from env", envPolicy: "None", envServers: "1.1.1.1, 8.8.8.8", wantPolicy: corev1.DNSNone, wantNS: []string{"1.1.1.1", "8.8.8.8"}},
+		{name: "request sets policy and servers without env",
+			req: api.CreateSandboxRequest{DNSPolicy: "None", DNSServers: []string{"9.9.9.9"}}, wantPolicy: corev1.DNSNone, wantNS: []string{"9.9.9.9"}},
+		{name: "request narrows env servers", envPolicy: "None", envServers: "1.1.1.1,8.8.8.8",
+			req: api.CreateSandboxRequest{DNSPolicy: "None", DNSServers: []string{"8.8.8.8"}}, wantPolicy: corev1.DNSNone, wantNS: []string{"8.8.8.8"}},
+		{name: "request None uses env servers", envServers: "1.1.1.1",
+			req: api.CreateSandboxRequest{DNSPolicy: "None"}, wantPolicy: corev1.DNSNone, wantNS: []string{"1.1.1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SANDBOX_DNS_POLICY", tt.envPolicy)
+			t.Setenv("SANDBOX_DNS_SERVERS", tt.envServers)
+			if err := validateDNS(dnsFromEnv()); err != nil {
+				t.Fatalf("env config rejected: %v", err)
+			}
+			if err := validateCreateRequest(tt.req); err != nil {
+				t.Fatalf("request rejected: %v", err)
+			}
+			spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(tt.req))
+			if spec.DNSPolicy != tt.wantPolicy {
+				t.Errorf("DNSPolicy = %q, want %q", spec.DNSPolicy, tt.wantPolicy)
+			}
+			var got []string
+			if spec.DNSConfig != nil {
+				got = spec.DNSConfig.Nameservers
+			}
+			if !reflect.DeepEqual(got, tt.wantNS) {
+				t.Errorf("nameservers = %v, want %v", got, tt.wantNS)
+			}
+		})
+	}
+}
+
+func TestValidateDNS(t *testing.T) {
+	t.Setenv("SANDBOX_DNS_POLICY", "")
+	t.Setenv("SANDBOX_DNS_SERVERS", "")
+	for _, req := range []api.CreateSandboxRequest{
+		{DNSPolicy: "None"},
+		{DNSPolicy: "ClusterFirstWithHostNet"},
+		{DNSServers: []string{"dns.example.com"}},
+		{DNSServers: []string{"1.1.1.1", "1.0.0.1", "8.8.8.8", "8.8.4.4"}},
+	} {
+		if err := validateCreateRequest(req); err == nil {
+			t.Errorf("%+v was accepted", req)
+		}
+	}
+	t.Setenv("SANDBOX_DNS_POLICY", "None")
+	if err := validateDNS(dnsFromEnv()); err == nil {
+		t.Error("SANDBOX_DNS_POLICY=None without SANDBOX_DNS_SERVERS was accepted")
+	}
+
+	// Requests can't loosen what the operator set.
+	t.Setenv("SANDBOX_DNS_SERVERS", "10.0.0.53")
+	for _, req := range []api.CreateSandboxRequest{
+		{DNSPolicy: "ClusterFirst"},
+		{DNSPolicy: "Default"},
+		{DNSPolicy: "None", DNSServers: []string{"8.8.8.8"}},
+		{DNSServers: []string{"10.0.0.53", "8.8.8.8"}},
+	} {
+		if err := validateCreateRequest(req); err == nil || !strings.Contains(err.Error(), "SANDBOX_DNS_") {
+			t.Errorf("%+v: err = %v, want the operator setting kept", req, err)
+		}
+	}
+	if err := validateCreateRequest(api.CreateSandboxRequest{DNSPolicy: "None", DNSServers: []string{"10.0.0.53"}}); err != nil {
+		t.Errorf("request repeating the operator settings: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestOneShotSandboxSucceeds(t *testing.T) {
+	s := newTestServer()
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes",
+		api.CreateSandboxRequest{ID: "job", Command: []string{"make", "test"}})
+	if w.Code != 200 {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	var created api.CreateSandboxResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	pods := s.client.CoreV1().Pods(created.Namespace)
+	pod, err := pods.Get(ctx, created.PodName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Spec.RestartPolicy != corev1.RestartPolicyOnFailure {
+		t.Fatalf("RestartPolicy = %q, want OnFailure for a custom command", pod.Spec.RestartPolicy)
+	}
+
+	// The kubelet reports the one-shot command finishing.
+	pod.Status.Phase = corev1.PodSucceeded
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "sandbox",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+	}}
+	if _, err := pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/"+created.ID, nil)
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["phase"] != "Succeeded" || got["exit_code"] != "0" {
+		t.Fatalf("sandbox = %v, want phase Succeeded with exit_code 0", got)
+	}
+	if err := s.waitForPodReady(ctx, created.Namespace, created.PodName); err == nil {
+		t.Fatal("waitForPodReady succeeded on a completed pod, want it to fail fast")
+	}
+}
+
+func TestActiveDeadlineSeconds(t *testing.T) {
+	s := newTestServer()
+	deadline := int64(600)
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes",
+		api.CreateSandboxRequest{ID: "batch", Command: []string{"make"}, ActiveDeadlineSeconds: &deadline})
+	if w.Code != 200 {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	ctx := context.Background()
+	pods := s.client.CoreV1().Pods("sbx-batch")
+	pod, err := pods.Get(ctx, "sandbox", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Spec.ActiveDeadlineSeconds == nil || *pod.Spec.ActiveDeadlineSeconds != deadline {
+		t.Fatalf("ActiveDeadlineSeconds = %v, want %d", pod.Spec.ActiveDeadlineSeconds, deadline)
+	}
+
+	// The kubelet kills the pod once the deadline passes.
+	pod.Status.Phase = corev1.PodFailed
+	pod.Status.Reason = "DeadlineExceeded"
+	if _, err := pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/sbx-batch", nil)
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["phase"] != "Failed" || got["reason"] != "DeadlineExceeded" {
+		t.Fatalf("sandbox = %v, want phase Failed with reason DeadlineExceeded", got)
+	}
+
+	zero := int64(0)
+	if err := validateCreateRequest(api.CreateSandboxRequest{ActiveDeadlineSeconds: &zero}); err == nil {
+		t.Error("active_deadline_seconds 0 was accepted")
+	}
+}
+
+func TestRestartPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		req  api.CreateSandboxRequest
+		want corev1.RestartPolicy
+	}{
+		{api.CreateSandboxRequest{}, corev1.RestartPolicyAlways},
+		{api.CreateSandboxRequest{Command: []string{"make"}}, corev1.RestartPolicyOnFailure},
+		{api.CreateSandboxRequest{Command: []string{"make"}, RestartPolicy: "Never"}, corev1.RestartPolicyNever},
+		{api.CreateSandboxRequest{Args: []string{"serve"}}, corev1.RestartPolicyOnFailure},
+	}
+	for _, tt := range tests {
+		if got := podConfigFromRequest(tt.req).restartPolicy; got != tt.want {
+			t.Errorf("%+v: restartPolicy = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrentCreatesForOneID(t *testing.T) {
+	s := newTestServer()
+	client := s.client.(*fake.Clientset)
+	// Slow pod creates down and record how many run at once for the same namespace.
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	client.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return false, nil, nil
+	})
+
+	const n = 8
+	codes := make([]int, n)
+	existing := make([]bool, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: "dup"})
+			codes[i] = w.Code
+			var resp api.CreateSandboxResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			existing[i] = resp.Existing
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i := 0; i < n; i++ {
+		if codes[i] != 200 {
+			t.Errorf("create #%d: status %d", i, codes[i])
+		}
+		if !existing[i] {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d creates reported a new sandbox, want 1 with the rest marked existing", created)
+	}
+	if maxInFlight != 1 {
+		t.Errorf("%d pod creates ran at once for one id, want them serialized", maxInFlight)
+	}
+	pods, _ := client.CoreV1().Pods(sandboxNamespace("dup")).List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 1 {
+		t.Errorf("%d pods, want 1", len(pods.Items))
+	}
+}
+
+func TestReadinessGateStatus(t *testing.T) {
+	s := newTestServer()
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes",
+		api.CreateSandboxRequest{ID: "web", ReadinessGates: []string{"example.com/app-ready"}})
+	if w.Code != 200 {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	ctx := context.Background()
+	pods := s.client.CoreV1().Pods("sbx-web")
+	pod, err := pods.Get(ctx, "sandbox", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Spec.ReadinessGates) != 1 || pod.Spec.ReadinessGates[0].ConditionType != "example.com/app-ready" {
+		t.Fatalf("ReadinessGates = %v", pod.Spec.ReadinessGates)
+	}
+
+	status := func() map[string]any {
+		w := serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/sbx-web", nil)
+		var got map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	pod.Status = readyPod("sbx-web").Status
+	if pod, err = pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got["ready"] != "false" || got["readiness_gates_pending"] != "example.com/app-ready" {
+		t.Errorf("sandbox = %v, want not ready with the gate pending", got)
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: "example.com/app-ready", Status: corev1.ConditionTrue})
+	if _, err := pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got["ready"] != "true" || got["readiness_gates_pending"] != nil {
+		t.Errorf("sandbox = %v, want ready", got)
+	}
+
+	if err := validateCreateRequest(api.CreateSandboxRequest{ReadinessGates: []string{"not a condition"}}); err == nil {
+		t.Error("an invalid readiness gate was accepted")
+	}
+}
+
+func TestSandboxStatusHosts(t *testing.T) {
+	t.Setenv("SANDBOX_FORCE_DISALLOWED_HOSTS", "169.254.169.254")
+	s := newTestServer()
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes",
+		api.CreateSandboxRequest{ID: "net", AllowedHosts: []string{"github.com", "pypi.org"}})
+	if w.Code != 200 {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	var got struct {
+		AllowedHosts    []string `json:"allowed_hosts"`
+		DisallowedHosts []string `json:"disallowed_hosts"`
+	}
+	w = serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/sbx-net", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got.AllowedHosts, ",") != "github.com,pypi.org" || strings.Join(got.DisallowedHosts, ",") != "169.254.169.254" {
+		t.Errorf("hosts = %+v, want the request's allowlist and the forced denylist", got)
+	}
+
+	// A warm-claimed sandbox reports what its namespace was annotated with.
+	ctx := context.Background()
+	ns, err := s.client.CoreV1().Namespaces().Get(ctx, "sbx-net", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Annotations["sbx.allowed_hosts"] = "example.com"
+	delete(ns.Annotations, "sbx.disallowed_hosts")
+	if _, err := s.client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/sbx-net", nil)
+	if !strings.Contains(w.Body.String(), `"allowed_hosts":["example.com"]`) || !strings.Contains(w.Body.String(), `"disallowed_hosts":[]`) {
+		t.Errorf("status = %s, want allowed_hosts [example.com] and an empty disallowed_hosts", w.Body)
+	}
+}
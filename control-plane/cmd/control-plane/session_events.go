@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// recordSession wraps the terminal size queue and stdin of an interactive exec so
+// the session can be reconstructed from the sandbox's event stream: each resize
+// is published as a "resize" event with rows and cols, and, with
+// SANDBOX_STREAM_RECORD_STDIN, each line of stdin as a "stdin" event with
+// SANDBOX_STREAM_STDIN_REDACT matches and secret-looking NAME=value words
+// redacted. Output is published by the usual streamEventWriter. gRPC Exec with tty
+// is the interactive exec that records its sessions.
+func (s *server) recordSession(sandboxID, execID string, opts remotecommand.StreamOptions) remotecommand.StreamOptions {
+	if opts.TerminalSizeQueue != nil {
+		opts.TerminalSizeQueue = &resizeRecorder{server: s, sandboxID: sandboxID, execID: execID, queue: opts.TerminalSizeQueue}
+	}
+	if opts.Stdin != nil && getenvBool("SANDBOX_STREAM_RECORD_STDIN", false) {
+		opts.Stdin = &stdinRecorder{server: s, sandboxID: sandboxID, execID: execID, r: opts.Stdin, redact: stdinRedactPatterns()}
+	}
+	return opts
+}
+
+type resizeRecorder struct {
+	server    *server
+	sandboxID string
+	execID    string
+	queue     remotecommand.TerminalSizeQueue
+}
+
+func (r *resizeRecorder) Next() *remotecommand.TerminalSize {
+	size := r.queue.Next()
+	if size == nil {
+		return nil
+	}
+	r.server.stream.publish(execEvent{
+		SandboxID: r.sandboxID,
+		ExecID:    r.execID,
+		Seq:       r.server.stream.nextSeq(),
+		Type:      "resize",
+		Rows:      size.Height,
+		Cols:      size.Width,
+		Time:      nowTS(),
+	})
+	return size
+}
+
+// maxStdinLine is how much input the stdin recorder holds back waiting for the end
+// of a line before it publishes what it has.
+const maxStdinLine = 4096
+
+// stdinRecorder publishes the input passing through it a line at a time. A
+// terminal sends keystrokes about one byte per read, so redacting each read would
+// never see a whole NAME=value word.
+type stdinRecorder struct {
+	server    *server
+	sandboxID string
+	execID    string
+	r         io.Reader
+	redact    []*regexp.Regexp
+	line      []byte
+}
+
+func (r *stdinRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for _, b := range p[:n] {
+		r.line = append(r.line, b)
+		// A terminal ends a line with \r, a pipe with \n.
+		if b == '\n' || b == '\r' || len(r.line) >= maxStdinLine {
+			r.flush()
+		}
+	}
+	if err != nil {
+		r.flush()
+	}
+	return n, err
+}
+
+func (r *stdinRecorder) flush() {
+	if len(r.line) == 0 {
+		return
+	}
+	r.server.stream.publish(execEvent{
+		SandboxID: r.sandboxID,
+		ExecID:    r.execID,
+		Seq:       r.server.stream.nextSeq(),
+		Type:      "stdin",
+		Stream:    "stdin",
+		Data:      redactStdin(string(r.line), r.redact),
+		Time:      nowTS(),
+	})
+	r.line = r.line[:0]
+}
+
+// redactStdin hides what the patterns match in recorded input, then any
+// NAME=value word with a secret-looking name, as in audited commands.
+func redactStdin(data string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		data = re.ReplaceAllString(data, redacted)
+	}
+	return redactEnvAssignments([]string{data})[0]
+}
+
+// parseStdinRedactPatterns compiles SANDBOX_STREAM_STDIN_REDACT, a comma-separated
+// list of regular expressions.
+func parseStdinRedactPatterns(raw string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, entry := range splitCSV(raw) {
+		re, err := regexp.Compile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("SANDBOX_STREAM_STDIN_REDACT entry %q: %v", entry, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// stdinRedactPatterns returns the configured patterns, which are checked at
+// startup.
+func stdinRedactPatterns() []*regexp.Regexp {
+	out, _ := parseStdinRedactPatterns(getenv("SANDBOX_STREAM_STDIN_REDACT", ""))
+	return out
+}
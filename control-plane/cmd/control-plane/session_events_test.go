@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+type sizeList []remotecommand.TerminalSize
+
+func (l *sizeList) Next() *remotecommand.TerminalSize {
+	if len(*l) == 0 {
+		return nil
+	}
+	size := (*l)[0]
+	*l = (*l)[1:]
+	return &size
+}
+
+func TestRecordSessionPublishesResizes(t *testing.T) {
+	s := newTestServer()
+	sub, _ := s.stream.subscribe("sbx-a")
+	defer s.stream.unsubscribe("sbx-a", sub)
+	sizes := sizeList{{Width: 80, Height: 24}, {Width: 120, Height: 40}}
+	opts := s.recordSession("sbx-a", "e1", remotecommand.StreamOptions{TerminalSizeQueue: &sizes})
+
+	for _, want := range []remotecommand.TerminalSize{{Width: 80, Height: 24}, {Width: 120, Height: 40}} {
+		got := opts.TerminalSizeQueue.Next()
+		if got == nil || *got != want {
+			t.Fatalf("Next() = %v, want %v", got, want)
+		}
+		evt := <-sub.ch
+		if evt.Type != "resize" || evt.ExecID != "e1" || evt.Rows != want.Height || evt.Cols != want.Width {
+			t.Fatalf("event = %+v, want a %dx%d resize", evt, want.Height, want.Width)
+		}
+	}
+	if opts.TerminalSizeQueue.Next() != nil {
+		t.Fatal("Next() after the queue ended should be nil")
+	}
+	select {
+	case evt := <-sub.ch:
+		t.Fatalf("unexpected event %+v", evt)
+	default:
+	}
+}
+
+func TestRecordSessionStdinIsOptInAndRedacted(t *testing.T) {
+	s := newTestServer()
+	sub, _ := s.stream.subscribe("sbx-a")
+	defer s.stream.unsubscribe("sbx-a", sub)
+
+	opts := s.recordSession("sbx-a", "e1", remotecommand.StreamOptions{Stdin: strings.NewReader("ls\n")})
+	if _, err := io.ReadAll(opts.Stdin); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-sub.ch:
+		t.Fatalf("stdin recorded without SANDBOX_STREAM_RECORD_STDIN: %+v", evt)
+	default:
+	}
+
+	t.Setenv("SANDBOX_STREAM_RECORD_STDIN", "true")
+	t.Setenv("SANDBOX_STREAM_STDIN_REDACT", `hunter\d`)
+	opts = s.recordSession("sbx-a", "e1", remotecommand.StreamOptions{Stdin: strings.NewReader("export API_TOKEN=abc; login hunter2\n")})
+	data, err := io.ReadAll(opts.Stdin)
+	if err != nil || string(data) != "export API_TOKEN=abc; login hunter2\n" {
+		t.Fatalf("the command got %q, %v; recording must not change stdin", data, err)
+	}
+	evt := <-sub.ch
+	if evt.Type != "stdin" || evt.Data != "export API_TOKEN=<redacted>; login <redacted>\n" {
+		t.Fatalf("event = %+v", evt)
+	}
+}
+
+func TestRecordSessionStdinRedactsTypedLines(t *testing.T) {
+	t.Setenv("SANDBOX_STREAM_RECORD_STDIN", "true")
+	s := newTestServer()
+	sub, _ := s.stream.subscribe("sbx-a")
+	defer s.stream.unsubscribe("sbx-a", sub)
+
+	// A terminal delivers typed input a byte at a time and ends lines with \r.
+	typed := "export API_TOKEN=abc\rls"
+	opts := s.recordSession("sbx-a", "e1", remotecommand.StreamOptions{Stdin: iotest.OneByteReader(strings.NewReader(typed))})
+	if data, err := io.ReadAll(opts.Stdin); err != nil || string(data) != typed {
+		t.Fatalf("the command got %q, %v", data, err)
+	}
+	for _, want := range []string{"export API_TOKEN=<redacted>\r", "ls"} {
+		if evt := <-sub.ch; evt.Type != "stdin" || evt.Data != want {
+			t.Fatalf("event = %+v, want stdin %q", evt, want)
+		}
+	}
+	select {
+	case evt := <-sub.ch:
+		t.Fatalf("unexpected event %+v", evt)
+	default:
+	}
+}
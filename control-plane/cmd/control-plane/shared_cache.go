@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The shared namespace must not look like a sandbox's, or the reaper would
+	// take it, and the cache every sandbox mounts with it.
+	defaultSharedCacheNamespace = "sandbox-shared"
+	defaultSharedCacheClaim     = "sbx-cache"
+)
+
+// ensureSharedCacheClaim makes sandbox namespace ns's "cache" claim point at the
+// shared cache: one ReadWriteMany claim in SANDBOX_CACHE_SHARED_NAMESPACE,
+// created on first use. A pod can't mount a claim from another namespace, so ns
+// gets a claim bound to a retained copy of the shared claim's PV. Unlike a pvc
+// volume, the claim is the operator's choice rather than the request's, so this
+// is the one place volumes are copied. Every sandbox then mounts the same storage,
+// which is why it must be ReadWriteMany; package managers that write to it from
+// several sandboxes at once must tolerate that, as they do on a shared NFS home.
+func ensureSharedCacheClaim(ctx context.Context, client kubernetes.Interface, ns string, cfg cacheConfig) error {
+	_, err := client.CoreV1().PersistentVolumeClaims(ns).Get(ctx, "cache", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	shared, err := ensureSharedCacheSource(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+	if shared.Status.Phase != corev1.ClaimBound || shared.Spec.VolumeName == "" {
+		// Nothing in the shared namespace mounts the claim, so a storage class that
+		// waits for the first consumer never binds it.
+		return fmt.Errorf("shared cache pvc %s/%s is not bound yet (phase %s); use a storage class with Immediate volume binding", cfg.sharedNamespace, cfg.sharedClaim, shared.Status.Phase)
+	}
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, shared.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("shared cache pvc %s/%s: pv %s: %w", cfg.sharedNamespace, cfg.sharedClaim, shared.Spec.VolumeName, err)
+	}
+	meta := metav1.ObjectMeta{
+		Name:        sharedCacheVolumeName(ns),
+		Annotations: map[string]string{sourceAnnotation: cfg.sharedNamespace + "/" + cfg.sharedClaim},
+	}
+	if err := bindVolumeCopy(ctx, client, pv, meta, ns, "cache", corev1.ReadWriteMany); err != nil {
+		return fmt.Errorf("sharing cache pvc %s/%s: %w", cfg.sharedNamespace, cfg.sharedClaim, err)
+	}
+	return nil
+}
+
+// ensureSharedCacheSource returns the shared cache claim, creating it and its
+// namespace if needed. Concurrent creates race on the same names and all end up
+// with the one claim that won.
+func ensureSharedCacheSource(ctx context.Context, client kubernetes.Interface, cfg cacheConfig) (*corev1.PersistentVolumeClaim, error) {
+	claims := client.CoreV1().PersistentVolumeClaims(cfg.sharedNamespace)
+	pvc, err := claims.Get(ctx, cfg.sharedClaim, metav1.GetOptions{})
+	if err == nil {
+		if !hasAccessMode(pvc.Spec.AccessModes, corev1.ReadWriteMany) {
+			return nil, fmt.Errorf("shared cache pvc %s/%s %w: it must be ReadWriteMany", cfg.sharedNamespace, cfg.sharedClaim, errClaimNotMountable)
+		}
+		return pvc, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	_, err = client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: cfg.sharedNamespace}}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("shared cache namespace: %w", err)
+	}
+	size, err := resource.ParseQuantity(cfg.pvcSize)
+	if err != nil {
+		return nil, fmt.Errorf("cache pvc size: %w", err)
+	}
+	pvc = &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: cfg.sharedClaim, Namespace: cfg.sharedNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+	if cfg.pvcStorageClass != "" {
+		pvc.Spec.StorageClassName = &cfg.pvcStorageClass
+	}
+	created, err := claims.Create(ctx, pvc, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return claims.Get(ctx, cfg.sharedClaim, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("shared cache pvc: %w", err)
+	}
+	return created, nil
+}
+
+// sharedCacheVolumeName is the PV that binds ns's cache claim to the shared cache.
+func sharedCacheVolumeName(ns string) string {
+	return "sbx-cache-" + ns
+}
+
+// sharedCacheNamespace returns SANDBOX_CACHE_SHARED_NAMESPACE.
+func sharedCacheNamespace() string {
+	return getenv("SANDBOX_CACHE_SHARED_NAMESPACE", defaultSharedCacheNamespace)
+}
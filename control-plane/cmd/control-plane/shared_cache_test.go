@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSharedCacheClaimIsReferenced(t *testing.T) {
+	t.Setenv("SANDBOX_CACHE_MODE", "shared-pvc")
+	t.Setenv("SANDBOX_CACHE_SHARED_READ_ONLY", "true")
+	client := fake.NewSimpleClientset(
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "sbx-cache", Namespace: "sandbox-shared"},
+			Spec:       corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, VolumeName: "pv-cache"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-cache"},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity:               corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("50Gi")},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{NFS: &corev1.NFSVolumeSource{Server: "nfs", Path: "/cache"}},
+			},
+		},
+	)
+	ctx := context.Background()
+	cfg := cacheConfigFromEnv()
+	for _, ns := range []string{"sbx-a", "sbx-b"} {
+		if err := ensureCachePVC(ctx, client, ns, "cache", cfg); err != nil {
+			t.Fatalf("%s: %v", ns, err)
+		}
+		pvc, err := client.CoreV1().PersistentVolumeClaims(ns).Get(ctx, "cache", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: claim not created: %v", ns, err)
+		}
+		pv, err := client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: pv %q: %v", ns, pvc.Spec.VolumeName, err)
+		}
+		if pv.Spec.NFS == nil || pv.Spec.NFS.Path != "/cache" || pv.Annotations[sourceAnnotation] != "sandbox-shared/sbx-cache" {
+			t.Errorf("%s: pv = %+v, want a copy of the shared cache volume", ns, pv)
+		}
+		if !hasAccessMode(pv.Spec.AccessModes, corev1.ReadWriteMany) || pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+			t.Errorf("%s: pv access %v reclaim %s", ns, pv.Spec.AccessModes, pv.Spec.PersistentVolumeReclaimPolicy)
+		}
+	}
+
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cfg, nil, podConfigFromEnv())
+	if v := spec.Volumes[0]; v.PersistentVolumeClaim == nil || v.PersistentVolumeClaim.ClaimName != "cache" || !v.PersistentVolumeClaim.ReadOnly {
+		t.Errorf("cache volume = %+v", v)
+	}
+	if m := spec.Containers[0].VolumeMounts[0]; m.Name != "cache" || !m.ReadOnly {
+		t.Errorf("cache mount = %+v", m)
+	}
+}
+
+func TestSharedCacheClaimCreatedOnce(t *testing.T) {
+	t.Setenv("SANDBOX_CACHE_MODE", "shared-pvc")
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	cfg := cacheConfigFromEnv()
+	// The fake never binds the claim, so the sandbox claim can't be made yet.
+	for i := 0; i < 2; i++ {
+		if err := ensureCachePVC(ctx, client, "sbx-a", "cache", cfg); err == nil || !strings.Contains(err.Error(), "not bound") {
+			t.Fatalf("err = %v, want not bound", err)
+		}
+	}
+	pvc, err := client.CoreV1().PersistentVolumeClaims("sandbox-shared").Get(ctx, "sbx-cache", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasAccessMode(pvc.Spec.AccessModes, corev1.ReadWriteMany) {
+		t.Errorf("access modes = %v, want ReadWriteMany", pvc.Spec.AccessModes)
+	}
+
+	// An existing claim that can't be shared is refused rather than used.
+	pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	if _, err := client.CoreV1().PersistentVolumeClaims("sandbox-shared").Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureCachePVC(ctx, client, "sbx-a", "cache", cfg); err == nil || !strings.Contains(err.Error(), "ReadWriteMany") {
+		t.Fatalf("err = %v, want ReadWriteMany", err)
+	}
+}
+
+func TestDeleteSandboxRemovesSharedCacheVolume(t *testing.T) {
+	t.Setenv("SANDBOX_CACHE_MODE", "shared-pvc")
+	s := newTestServer(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "sbx-cache", Namespace: "sandbox-shared"},
+			Spec:       corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, VolumeName: "pv-cache"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		},
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-cache"}},
+	)
+	ctx := context.Background()
+	if err := ensureCachePVC(ctx, s.client, "sbx-a", "cache", cacheConfigFromEnv()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.deleteSandboxObjects(ctx, "sbx-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.client.CoreV1().PersistentVolumes().Get(ctx, sharedCacheVolumeName("sbx-a"), metav1.GetOptions{}); err == nil {
+		t.Error("per-sandbox cache pv survived the sandbox")
+	}
+	if _, err := s.client.CoreV1().PersistentVolumes().Get(ctx, "pv-cache", metav1.GetOptions{}); err != nil {
+		t.Errorf("shared cache pv: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// singleNamespace is SANDBOX_SINGLE_NAMESPACE. When it is set every sandbox is a
+// pod in that namespace, named by the sandbox id, instead of a namespace of its
+// own, so the control plane only needs namespace-scoped RBAC there.
+func singleNamespace() string {
+	return getenv("SANDBOX_SINGLE_NAMESPACE", "")
+}
+
+// validateSingleNamespaceConfig rejects settings that need a namespace per
+// sandbox.
+func validateSingleNamespaceConfig() error {
+	if singleNamespace() == "" {
+		return nil
+	}
+	if warmPoolConfigFromEnv().size > 0 || getenvBool("SANDBOX_WARM_POOL_AUTOSIZE", false) {
+		return fmt.Errorf("the warm pool is not supported with SANDBOX_SINGLE_NAMESPACE")
+	}
+	if getenvBool("SANDBOX_REAP_ORPHANS", false) {
+		return fmt.Errorf("SANDBOX_REAP_ORPHANS is not supported with SANDBOX_SINGLE_NAMESPACE")
+	}
+	return nil
+}
+
+// validateSingleNamespaceRequest rejects create options that need a namespace per
+// sandbox: the workspace and cache PVCs are named per namespace.
+func validateSingleNamespaceRequest(volumeMode string, cacheCfg cacheConfig) error {
+	if singleNamespace() == "" {
+		return nil
+	}
+	if volumeMode == "pvc" {
+		return fmt.Errorf("volume_mode pvc is not supported with SANDBOX_SINGLE_NAMESPACE")
+	}
+	if cacheCfg.mode == "pvc" || cacheCfg.mode == "shared-pvc" {
+		return fmt.Errorf("cache_mode %s is not supported with SANDBOX_SINGLE_NAMESPACE", cacheCfg.mode)
+	}
+	return nil
+}
+
+// sandboxPod maps a pod as handlers address it, the sandbox pod in namespace id,
+// to where it lives. That is the same place unless SANDBOX_SINGLE_NAMESPACE is
+// set, when it is the pod named id in the shared namespace. Any other pod name is
+// taken to be a real location already.
+func sandboxPod(ns, name string) (string, string) {
+	scope := singleNamespace()
+	if scope == "" || name != "sandbox" {
+		return ns, name
+	}
+	return scope, ns
+}
+
+// sandboxNamespaces lists sandboxes as namespaces, matching selector. In
+// single-namespace mode each sandbox pod stands in for its namespace: same name
+// (the sandbox id), labels, annotations and creation time, and Terminating once
+// the pod is being deleted. Callers can then list, count and reap either way.
+func (s *server) sandboxNamespaces(ctx context.Context, selector labels.Selector) ([]corev1.Namespace, error) {
+	scope := singleNamespace()
+	if scope == "" {
+		all, err := s.namespaces.list(ctx, selector)
+		if err != nil {
+			return nil, err
+		}
+		// An operator may have put the shared cache in an sbx- namespace.
+		shared := sharedCacheNamespace()
+		out := all[:0]
+		for _, ns := range all {
+			if ns.Name != shared {
+				out = append(out, ns)
+			}
+		}
+		return out, nil
+	}
+	reqs, _ := labels.SelectorFromSet(map[string]string{sandboxPodLabel: "true"}).Requirements()
+	if selector != nil {
+		extra, _ := selector.Requirements()
+		reqs = append(reqs, extra...)
+	}
+	pods, err := s.client.CoreV1().Pods(scope).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.NewSelector().Add(reqs...).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]corev1.Namespace, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		ns := corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              pod.Name,
+				Labels:            pod.Labels,
+				Annotations:       pod.Annotations,
+				CreationTimestamp: pod.CreationTimestamp,
+				DeletionTimestamp: pod.DeletionTimestamp,
+			},
+			Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+		}
+		if pod.DeletionTimestamp != nil {
+			ns.Status.Phase = corev1.NamespaceTerminating
+		}
+		out = append(out, ns)
+	}
+	return out, nil
+}
+
+// deleteSandboxObjects deletes sandbox id: its namespace, or in single-namespace
+// mode its pod.
+func (s *server) deleteSandboxObjects(ctx context.Context, id string) error {
+	var err error
+	if scope := singleNamespace(); scope != "" {
+		err = s.client.CoreV1().Pods(scope).Delete(ctx, id, metav1.DeleteOptions{})
+		if err == nil {
+			if err := deleteMetricsService(ctx, s.client, scope, id); err != nil {
+				log.Printf("delete metrics service namespace=%s sandbox=%s: %v", scope, id, err)
+			}
+		}
+	} else {
+		err = s.client.CoreV1().Namespaces().Delete(ctx, id, metav1.DeleteOptions{})
+		if err == nil {
+			if err := deleteVolumeCopies(ctx, s.client, id); err != nil {
+				log.Printf("delete volume copies namespace=%s: %v", id, err)
+			}
+		}
+	}
+	if err == nil {
+		// Queued execs would only wait for a pod that isn't coming back.
+		s.execs.cancelQueued(id)
+		s.usage.forget(id)
+	}
+	return err
+}
+
+// requireNamespacePerSandbox answers 501 in single-namespace mode, for routes that
+// work on a sandbox's namespace.
+func requireNamespacePerSandbox() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if singleNamespace() != "" {
+			writeError(c, 501, "not supported with SANDBOX_SINGLE_NAMESPACE")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+var errPodTerminating = errors.New("sandbox pod is still terminating from a previous delete; retry shortly")
+
+// waitSandboxPodGone is waitNamespaceGone for single-namespace mode, where a
+// deleted sandbox's pod lingers for its termination grace period.
+func (s *server) waitSandboxPodGone(parent context.Context, ns, name string) error {
+	ctx, cancel := context.WithTimeout(parent, getenvDuration("SANDBOX_TERMINATING_WAIT", defaultTerminatingWait))
+	defer cancel()
+	ticker := time.NewTicker(terminatingPollInterval)
+	defer ticker.Stop()
+	for {
+		pod, err := s.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil && parent.Err() == nil {
+				return errPodTerminating
+			}
+			return err
+		}
+		if pod.DeletionTimestamp == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				return parent.Err()
+			}
+			return errPodTerminating
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"sandbox/pkg/api"
+)
+
+func TestSingleNamespaceLifecycle(t *testing.T) {
+	t.Setenv("SANDBOX_SINGLE_NAMESPACE", "sandboxes")
+	s := newTestServer()
+	ctx := context.Background()
+
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", api.CreateSandboxRequest{ID: "one", IdleTTL: "1h"})
+	if w.Code != 200 {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var created api.CreateSandboxResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != "sbx-one" || created.Namespace != "sandboxes" || created.PodName != "sbx-one" {
+		t.Fatalf("create = %+v, want pod sbx-one in sandboxes", created)
+	}
+	if nss, _ := s.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{}); len(nss.Items) != 0 {
+		t.Fatalf("created %d namespaces", len(nss.Items))
+	}
+	pod, err := s.client.CoreV1().Pods("sandboxes").Get(ctx, "sbx-one", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Labels[sandboxPodLabel] != "true" || pod.Annotations["sbx.idle_ttl"] != "1h0m0s" || pod.Annotations["sbx.created_at"] == "" {
+		t.Fatalf("pod labels %v annotations %v", pod.Labels, pod.Annotations)
+	}
+
+	pod.Status = readyPod("sandboxes").Status
+	if _, err := s.client.CoreV1().Pods("sandboxes").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/sbx-one", nil)
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != 200 {
+		t.Fatalf("get: %d %s", w.Code, w.Body)
+	}
+	if got["namespace"] != "sandboxes" || got["pod_name"] != "sbx-one" || got["ready"] != "true" {
+		t.Fatalf("get = %v", got)
+	}
+
+	w = serve(s.listSandboxes, http.MethodGet, "/sandboxes", "/sandboxes", nil)
+	var list []api.SandboxStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != 200 {
+		t.Fatalf("list: %d %s", w.Code, w.Body)
+	}
+	if len(list) != 1 || list[0].ID != "sbx-one" || list[0].Namespace != "sandboxes" || list[0].State != "Active" {
+		t.Fatalf("list = %+v", list)
+	}
+
+	var execNS, execPod string
+	s.podExec = func(_ context.Context, ns, pod, _ string, _ []string, opts remotecommand.StreamOptions) error {
+		execNS, execPod = ns, pod
+		_, _ = io.WriteString(opts.Stdout, "hi\n")
+		return nil
+	}
+	async := false
+	w = serve(s.execSandbox, http.MethodPost, "/sandboxes/:id/exec", "/sandboxes/sbx-one/exec", api.ExecRequest{Command: []string{"echo", "hi"}, Async: &async})
+	if w.Code != 200 || execNS != "sandboxes" || execPod != "sbx-one" {
+		t.Fatalf("exec ran in %s/%s: %d %s", execNS, execPod, w.Code, w.Body)
+	}
+	pod, _ = s.client.CoreV1().Pods("sandboxes").Get(ctx, "sbx-one", metav1.GetOptions{})
+	if pod.Annotations["sbx.last_exec_at"] == "" {
+		t.Fatal("exec did not record sbx.last_exec_at on the pod")
+	}
+
+	w = serve(s.deleteSandbox, http.MethodDelete, "/sandboxes/:id", "/sandboxes/sbx-one", nil)
+	if w.Code != 200 {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if _, err := s.client.CoreV1().Pods("sandboxes").Get(ctx, "sbx-one", metav1.GetOptions{}); err == nil {
+		t.Fatal("delete left the pod")
+	}
+	if w := serve(s.getSandbox, http.MethodGet, "/sandboxes/:id", "/sandboxes/sbx-one", nil); w.Code != 404 {
+		t.Fatalf("get after delete: %d", w.Code)
+	}
+}
+
+func TestSingleNamespaceReap(t *testing.T) {
+	t.Setenv("SANDBOX_SINGLE_NAMESPACE", "sandboxes")
+	t.Setenv("SANDBOX_IDLE_TTL", "10m")
+	pod := func(name string, since time.Duration) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "sandboxes",
+			Labels:      map[string]string{sandboxPodLabel: "true"},
+			Annotations: map[string]string{"sbx.last_exec_at": strconv.FormatInt(time.Now().Add(-since).Unix(), 10)},
+		}}
+	}
+	// Pods in the namespace that aren't sandboxes are left alone.
+	other := pod("sbx-other", time.Hour)
+	other.Labels = nil
+	s := newTestServer(pod("sbx-idle", time.Hour), pod("sbx-active", 0), other)
+	reaped := s.reapOnce(context.Background(), false)
+	if len(reaped) != 1 || reaped[0].ID != "sbx-idle" {
+		t.Fatalf("reaped %+v, want sbx-idle", reaped)
+	}
+	pods, _ := s.client.CoreV1().Pods("sandboxes").List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 2 {
+		t.Fatalf("%d pods left, want sbx-active and sbx-other", len(pods.Items))
+	}
+}
+
+func TestSingleNamespaceRejects(t *testing.T) {
+	t.Setenv("SANDBOX_SINGLE_NAMESPACE", "sandboxes")
+	s := newTestServer()
+	w := serve(s.handleSandboxes, http.MethodPost, "/sandboxes", "/sandboxes", api.CreateSandboxRequest{VolumeMode: "pvc"})
+	if w.Code != 400 {
+		t.Fatalf("pvc create: %d %s", w.Code, w.Body)
+	}
+	w = serve(requireNamespacePerSandbox(), http.MethodPost, "/sandboxes/:id/archive", "/sandboxes/sbx-a/archive", nil)
+	if w.Code != 501 {
+		t.Fatalf("archive: %d", w.Code)
+	}
+	t.Setenv("SANDBOX_WARM_POOL_SIZE", "2")
+	if err := validateSingleNamespaceConfig(); err == nil {
+		t.Fatal("expected the warm pool to be rejected")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"sandbox/pkg/api"
+)
+
+const (
+	// The defaults give a slow image five minutes to boot before the kubelet
+	// restarts it.
+	defaultStartupProbePeriod           = 5
+	defaultStartupProbeFailureThreshold = 60
+)
+
+func validateStartupProbe(p *api.StartupProbe) error {
+	if p == nil {
+		return nil
+	}
+	if (len(p.Command) > 0) == (p.Port != 0) {
+		return fmt.Errorf("startup_probe needs exactly one of command and port")
+	}
+	if p.HTTPPath != "" && !strings.HasPrefix(p.HTTPPath, "/") {
+		return fmt.Errorf("startup_probe http_path must start with /")
+	}
+	if p.HTTPPath != "" && p.Port == 0 {
+		return fmt.Errorf("startup_probe http_path requires port")
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		return fmt.Errorf("startup_probe port must be between 1 and 65535")
+	}
+	if p.InitialDelaySeconds < 0 || p.PeriodSeconds < 0 || p.TimeoutSeconds < 0 || p.FailureThreshold < 0 {
+		return fmt.Errorf("startup_probe durations and failure_threshold must not be negative")
+	}
+	return nil
+}
+
+// startupProbe converts a request's startup probe to the container's, filling in
+// the defaults. It returns nil when the request has none.
+func startupProbe(p *api.StartupProbe) *corev1.Probe {
+	if p == nil {
+		return nil
+	}
+	probe := &corev1.Probe{
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		FailureThreshold:    p.FailureThreshold,
+	}
+	if probe.PeriodSeconds == 0 {
+		probe.PeriodSeconds = defaultStartupProbePeriod
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = defaultStartupProbeFailureThreshold
+	}
+	switch {
+	case len(p.Command) > 0:
+		probe.Exec = &corev1.ExecAction{Command: p.Command}
+	case p.HTTPPath != "":
+		probe.HTTPGet = &corev1.HTTPGetAction{Path: p.HTTPPath, Port: intstr.FromInt32(p.Port)}
+	default:
+		probe.TCPSocket = &corev1.TCPSocketAction{Port: intstr.FromInt32(p.Port)}
+	}
+	return probe
+}
+
+// requestStartupProbe converts the container's startup probe back to a request's,
+// leaving out the defaults startupProbe fills in. It returns nil without one.
+func requestStartupProbe(probe *corev1.Probe) *api.StartupProbe {
+	if probe == nil {
+		return nil
+	}
+	p := &api.StartupProbe{
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+		FailureThreshold:    probe.FailureThreshold,
+	}
+	if p.PeriodSeconds == defaultStartupProbePeriod {
+		p.PeriodSeconds = 0
+	}
+	if p.FailureThreshold == defaultStartupProbeFailureThreshold {
+		p.FailureThreshold = 0
+	}
+	switch {
+	case probe.Exec != nil:
+		p.Command = probe.Exec.Command
+	case probe.HTTPGet != nil:
+		p.HTTPPath, p.Port = probe.HTTPGet.Path, probe.HTTPGet.Port.IntVal
+	case probe.TCPSocket != nil:
+		p.Port = probe.TCPSocket.Port.IntVal
+	default:
+		return nil
+	}
+	return p
+}
+
+// startupProbeBudget is how long the kubelet gives the sandbox container to pass
+// its startup probe before restarting it, or 0 without one.
+func startupProbeBudget(pod *corev1.Pod) time.Duration {
+	for _, c := range pod.Spec.Containers {
+		if c.Name != "sandbox" || c.StartupProbe == nil {
+			continue
+		}
+		p := c.StartupProbe
+		return time.Duration(p.InitialDelaySeconds)*time.Second + time.Duration(p.PeriodSeconds)*time.Duration(p.FailureThreshold)*time.Second
+	}
+	return 0
+}
+
+// sandboxStarting reports whether the sandbox container is running but hasn't
+// passed its startup probe yet.
+func sandboxStarting(pod *corev1.Pod) bool {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == "sandbox" {
+			return cs.State.Running != nil && cs.Started != nil && !*cs.Started
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sandbox/pkg/api"
+)
+
+func TestSandboxPodSpecStartupProbe(t *testing.T) {
+	spec := func(req api.CreateSandboxRequest) corev1.Container {
+		return sandboxPodSpec("img", nil, "emptydir", "", cacheConfig{mode: "emptydir"}, nil, podConfigFromRequest(req)).Containers[0]
+	}
+	if probe := spec(api.CreateSandboxRequest{}).StartupProbe; probe != nil {
+		t.Fatalf("startup probe %+v without one configured", probe)
+	}
+
+	probe := spec(api.CreateSandboxRequest{StartupProbe: &api.StartupProbe{Command: []string{"test", "-f", "/tmp/booted"}}}).StartupProbe
+	if probe == nil || probe.Exec == nil || strings.Join(probe.Exec.Command, " ") != "test -f /tmp/booted" {
+		t.Fatalf("startup probe = %+v, want an exec probe", probe)
+	}
+	if probe.PeriodSeconds != defaultStartupProbePeriod || probe.FailureThreshold != defaultStartupProbeFailureThreshold {
+		t.Errorf("period %d, failure threshold %d, want the defaults", probe.PeriodSeconds, probe.FailureThreshold)
+	}
+
+	probe = spec(api.CreateSandboxRequest{StartupProbe: &api.StartupProbe{HTTPPath: "/healthz", Port: 8080, FailureThreshold: 120}}).StartupProbe
+	if probe == nil || probe.HTTPGet == nil || probe.HTTPGet.Path != "/healthz" || probe.HTTPGet.Port.IntValue() != 8080 || probe.FailureThreshold != 120 {
+		t.Errorf("startup probe = %+v, want an HTTP probe of :8080/healthz with 120 failures", probe)
+	}
+	probe = spec(api.CreateSandboxRequest{StartupProbe: &api.StartupProbe{Port: 5432}}).StartupProbe
+	if probe == nil || probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != 5432 {
+		t.Errorf("startup probe = %+v, want a TCP probe of port 5432", probe)
+	}
+}
+
+func TestValidateStartupProbe(t *testing.T) {
+	for _, p := range []api.StartupProbe{
+		{},
+		{Command: []string{"true"}, Port: 80},
+		{HTTPPath: "/healthz"},
+		{HTTPPath: "healthz", Port: 80},
+		{Port: 70000},
+		{Port: 80, FailureThreshold: -1},
+	} {
+		if err := validateCreateRequest(api.CreateSandboxRequest{StartupProbe: &p}); err == nil {
+			t.Errorf("startup probe %+v was accepted", p)
+		}
+	}
+	if err := validateCreateRequest(api.CreateSandboxRequest{StartupProbe: &api.StartupProbe{HTTPPath: "/healthz", Port: 80}}); err != nil {
+		t.Errorf("valid startup probe rejected: %v", err)
+	}
+}
+
+func TestWaitForPodReadyReportsStartup(t *testing.T) {
+	started := false
+	pod := pendingPod("sbx-a")
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:    "sandbox",
+		State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()}},
+		Started: &started,
+	}}
+	s := newTestServer(pod)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := s.waitForPodReady(ctx, "sbx-a", "sandbox")
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "still starting") {
+		t.Fatalf("err = %v, want a deadline error saying the container is still starting", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const statsCacheTTL = 5 * time.Second
+
+var processStart = time.Now()
+
+type statsCache struct {
+	mu   sync.Mutex
+	at   time.Time
+	resp api.StatsResponse
+}
+
+func (s *server) getStats(c *gin.Context) {
+	s.stats.mu.Lock()
+	if !s.stats.at.IsZero() && time.Since(s.stats.at) < statsCacheTTL {
+		resp := s.stats.resp
+		s.stats.mu.Unlock()
+		writeJSON(c, 200, resp)
+		return
+	}
+	s.stats.mu.Unlock()
+	// Computed without the lock: the List calls can take seconds, and concurrent
+	// misses at worst compute the same answer twice.
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	resp, err := s.computeStats(ctx)
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	s.stats.mu.Lock()
+	s.stats.at = time.Now()
+	s.stats.resp = resp
+	s.stats.mu.Unlock()
+	writeJSON(c, 200, resp)
+}
+
+func (s *server) computeStats(ctx context.Context) (api.StatsResponse, error) {
+	namespaces, err := s.sandboxNamespaces(ctx, nil)
+	if err != nil {
+		return api.StatsResponse{}, err
+	}
+	now := time.Now()
+	resp := api.StatsResponse{
+		ByState:      map[string]int{},
+		ExecsRunning: s.execs.countRunning(),
+		CreatesTotal: metricCreates.Value(),
+		ExecsTotal:   metricExecs.Value(),
+		DeletesTotal: metricDeletes.Value(),
+		GeneratedAt:  now.UTC().Format(time.RFC3339),
+	}
+	unallocated := map[string]bool{}
+	for _, ns := range namespaces {
+		if !strings.HasPrefix(ns.Name, "sbx-") {
+			continue
+		}
+		if ns.Labels["sbx.allocated"] == "false" {
+			unallocated[ns.Name] = true
+			continue
+		}
+		resp.Sandboxes++
+		resp.ByState[string(ns.Status.Phase)]++
+	}
+	if len(unallocated) > 0 {
+		// Only warm pods that are Ready can be claimed; the rest are still starting.
+		pods, err := s.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{LabelSelector: "sbx.warm=true"})
+		if err != nil {
+			return api.StatsResponse{}, err
+		}
+		for i := range pods.Items {
+			if unallocated[pods.Items[i].Namespace] && podReady(&pods.Items[i]) {
+				resp.WarmPool.Ready++
+			}
+		}
+	}
+	if s.warm.enabled() {
+		resp.WarmPool.Enabled = true
+		resp.WarmPool.Desired = s.warm.desiredSize()
+	}
+	// Rates are averaged over the lifetime of this control-plane process.
+	uptime := now.Sub(processStart)
+	resp.UptimeSeconds = int64(uptime.Seconds())
+	if mins := uptime.Minutes(); mins > 0 {
+		resp.CreatesPerMin = float64(resp.CreatesTotal) / mins
+		resp.ExecsPerMin = float64(resp.ExecsTotal) / mins
+		resp.DeletesPerMin = float64(resp.DeletesTotal) / mins
+	}
+	return resp, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestComputeStats(t *testing.T) {
+	ns := func(name, allocated string, phase corev1.NamespacePhase) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"sbx.allocated": allocated}},
+			Status:     corev1.NamespaceStatus{Phase: phase},
+		}
+	}
+	warmPod := func(ns string, ready bool) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "sandbox", Namespace: ns, Labels: map[string]string{"sbx.warm": "true"}}}
+		if ready {
+			pod.Status.Phase = corev1.PodRunning
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		}
+		return pod
+	}
+	client := fake.NewSimpleClientset(
+		ns("sbx-a", "true", corev1.NamespaceActive),
+		ns("sbx-b", "true", corev1.NamespaceTerminating),
+		ns("sbx-w1", "false", corev1.NamespaceActive),
+		ns("sbx-w2", "false", corev1.NamespaceActive),
+		ns("kube-system", "", corev1.NamespaceActive),
+		warmPod("sbx-w1", true),
+		warmPod("sbx-w2", false),
+		// Claimed warm pods keep the label and are sandboxes, not pool capacity.
+		warmPod("sbx-a", true),
+	)
+	s := &server{client: client, namespaces: newNamespaceCache(client), execs: newExecRegistry(time.Minute, 0)}
+	resp, err := s.computeStats(context.Background())
+	if err != nil {
+		t.Fatalf("computeStats: %v", err)
+	}
+	if resp.Sandboxes != 2 || resp.ByState["Active"] != 1 || resp.ByState["Terminating"] != 1 {
+		t.Errorf("sandboxes = %d by state %v, want 2 (1 Active, 1 Terminating)", resp.Sandboxes, resp.ByState)
+	}
+	if resp.WarmPool.Ready != 1 {
+		t.Errorf("warm ready = %d, want 1: the unready warm pod doesn't count", resp.WarmPool.Ready)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,7 +19,11 @@ type execEvent struct {
 	Stream    string `json:"stream,omitempty"`
 	Data      string `json:"data,omitempty"`
 	ExitCode  int    `json:"exit_code,omitempty"`
-	Time      string `json:"time"`
+	Dropped   int64  `json:"dropped,omitempty"`
+	// Rows and Cols are the terminal size of a resize event.
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Time string `json:"time"`
 }
 
 type streamHub struct {
@@ -30,10 +36,32 @@ type streamHub struct {
 type streamBuffer struct {
 	mu     sync.Mutex
 	events []execEvent
-	subs   map[chan execEvent]struct{}
+	subs   map[chan execEvent]*subscriber
 	limit  int
 }
 
+type subscriber struct {
+	ch      chan execEvent
+	dropped int64 // events not delivered to ch; accessed atomically
+	// reliable subscribers make publish wait for room in ch rather than drop
+	// events. One that has kept publish waiting for timeout in all since it last
+	// had room is evicted: ch is closed and evicted set, so a stuck or slow
+	// client can't hold up publish for long.
+	reliable bool
+	timeout  time.Duration
+	evicted  int32 // accessed atomically
+	// done is closed on unsubscribe to wake a publish waiting on ch.
+	done     chan struct{}
+	doneOnce sync.Once
+	// sendMu serializes sends to a reliable ch with closing it; publish waits
+	// on it without holding the buffer lock.
+	sendMu       sync.Mutex
+	closed       bool
+	stalledSince time.Time
+}
+
+const defaultReliableTimeout = 10 * time.Second
+
 func newStreamHub(limit int) *streamHub {
 	if limit <= 0 {
 		limit = 200
@@ -58,7 +86,7 @@ func (h *streamHub) bufferFor(sandboxID string) *streamBuffer {
 	if !ok {
 		buf = &streamBuffer{
 			events: make([]execEvent, 0, h.limit),
-			subs:   map[chan execEvent]struct{}{},
+			subs:   map[chan execEvent]*subscriber{},
 			limit:  h.limit,
 		}
 		h.buffers[sandboxID] = buf
@@ -66,6 +94,10 @@ func (h *streamHub) bufferFor(sandboxID string) *streamBuffer {
 	return buf
 }
 
+// publish buffers evt and hands it to the sandbox's subscribers. Lossy ones get
+// it if they have room; reliable ones are waited on after the buffer lock is
+// released, so a slow one holds up only this publish, not subscribes,
+// unsubscribes or other sandboxes' streams.
 func (h *streamHub) publish(evt execEvent) {
 	buf := h.bufferFor(evt.SandboxID)
 	buf.mu.Lock()
@@ -75,32 +107,341 @@ func (h *streamHub) publish(evt execEvent) {
 	} else {
 		buf.events = append(buf.events, evt)
 	}
-	for ch := range buf.subs {
+	// Lossy subscribers first, so they never wait behind a reliable one.
+	for ch, sub := range buf.subs {
+		if sub.reliable {
+			continue
+		}
 		select {
 		case ch <- evt:
 		default:
+			atomic.AddInt64(&sub.dropped, 1)
+			metricStreamDropped.Add(1)
+		}
+	}
+	var reliable []*subscriber
+	for _, sub := range buf.subs {
+		if sub.reliable {
+			reliable = append(reliable, sub)
 		}
 	}
 	buf.mu.Unlock()
+	for _, sub := range reliable {
+		h.sendReliable(buf, sub, evt)
+	}
+}
+
+// sendReliable waits for room for evt in sub's channel. The wait counts towards
+// sub's stall, which only ends when a send finds room at once; sub is evicted
+// once the stall reaches its timeout, so a client that reads just often enough
+// to take one event per wait still can't slow publish down indefinitely.
+func (h *streamHub) sendReliable(buf *streamBuffer, sub *subscriber, evt execEvent) {
+	sub.sendMu.Lock()
+	defer sub.sendMu.Unlock()
+	if sub.closed {
+		return
+	}
+	select {
+	case sub.ch <- evt:
+		sub.stalledSince = time.Time{}
+		return
+	default:
+	}
+	if sub.stalledSince.IsZero() {
+		sub.stalledSince = time.Now()
+	}
+	timer := time.NewTimer(sub.timeout - time.Since(sub.stalledSince))
+	defer timer.Stop()
+	select {
+	case sub.ch <- evt:
+	case <-sub.done:
+	case <-timer.C:
+		buf.mu.Lock()
+		delete(buf.subs, sub.ch)
+		buf.mu.Unlock()
+		atomic.StoreInt32(&sub.evicted, 1)
+		sub.closed = true
+		close(sub.ch)
+		metricStreamEvicted.Add(1)
+	}
+}
+
+func (h *streamHub) subscribe(sandboxID string) (*subscriber, []execEvent) {
+	return h.add(sandboxID, &subscriber{ch: make(chan execEvent, 128)})
 }
 
-func (h *streamHub) subscribe(sandboxID string) (chan execEvent, []execEvent) {
+// subscribeReliable is subscribe for a client that needs every event: publish
+// blocks for up to timeout on it instead of dropping.
+func (h *streamHub) subscribeReliable(sandboxID string, timeout time.Duration) (*subscriber, []execEvent) {
+	return h.add(sandboxID, &subscriber{ch: make(chan execEvent, 128), reliable: true, timeout: timeout, done: make(chan struct{})})
+}
+
+func (h *streamHub) add(sandboxID string, sub *subscriber) (*subscriber, []execEvent) {
 	buf := h.bufferFor(sandboxID)
-	ch := make(chan execEvent, 128)
 	buf.mu.Lock()
-	buf.subs[ch] = struct{}{}
+	buf.subs[sub.ch] = sub
 	snapshot := make([]execEvent, len(buf.events))
 	copy(snapshot, buf.events)
 	buf.mu.Unlock()
-	return ch, snapshot
+	return sub, snapshot
+}
+
+func (sub *subscriber) wasEvicted() bool {
+	return atomic.LoadInt32(&sub.evicted) == 1
+}
+
+// writeEvictedClose tells an evicted reliable client why its stream ended, with
+// 1013 (try again later) so it knows to reconnect and replay.
+func writeEvictedClose(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "reliable subscriber fell behind")
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
 }
 
-func (h *streamHub) unsubscribe(sandboxID string, ch chan execEvent) {
+func (h *streamHub) unsubscribe(sandboxID string, sub *subscriber) {
 	buf := h.bufferFor(sandboxID)
 	buf.mu.Lock()
-	delete(buf.subs, ch)
-	close(ch)
+	_, ok := buf.subs[sub.ch]
+	delete(buf.subs, sub.ch)
+	if ok && !sub.reliable {
+		// Lossy sends happen under buf.mu.
+		close(sub.ch)
+	}
 	buf.mu.Unlock()
+	if !sub.reliable {
+		return
+	}
+	// Wake a publish waiting on ch, then close ch once it is done with it. An
+	// evicted subscriber's channel is already closed.
+	sub.doneOnce.Do(func() { close(sub.done) })
+	sub.sendMu.Lock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+	sub.sendMu.Unlock()
+}
+
+const (
+	// maxCoalesceBytes bounds the data merged into a single output event.
+	maxCoalesceBytes = 64 * 1024
+	// maxPendingBytes bounds output buffered for a throttled subscriber.
+	maxPendingBytes = 1024 * 1024
+)
+
+// eventQueue buffers events between a hub subscription and a (possibly throttled)
+// WebSocket writer. Adjacent output events from the same exec and stream are merged,
+// and when buffered output exceeds maxPendingBytes the oldest output is dropped,
+// unless the queue is reliable: then push waits for the writer to make room,
+// backing up the subscription into publish.
+type eventQueue struct {
+	mu       sync.Mutex
+	events   []execEvent
+	bytes    int
+	closed   bool
+	notify   chan struct{}
+	dropped  *int64
+	reliable bool
+	stopped  bool
+	space    chan struct{}
+}
+
+func newEventQueue(dropped *int64) *eventQueue {
+	return &eventQueue{notify: make(chan struct{}, 1), dropped: dropped, space: make(chan struct{}, 1)}
+}
+
+func (q *eventQueue) push(evt execEvent) {
+	q.mu.Lock()
+	for q.reliable && !q.stopped && len(q.events) > 0 && q.bytes+len(evt.Data) > maxPendingBytes {
+		q.mu.Unlock()
+		<-q.space
+		q.mu.Lock()
+	}
+	if n := len(q.events); n > 0 && evt.Type == "output" {
+		last := &q.events[n-1]
+		if last.Type == "output" && last.ExecID == evt.ExecID && last.Stream == evt.Stream && len(last.Data)+len(evt.Data) <= maxCoalesceBytes {
+			last.Data += evt.Data
+			last.Time = evt.Time
+			q.bytes += len(evt.Data)
+			q.trimLocked()
+			q.mu.Unlock()
+			q.signal()
+			return
+		}
+	}
+	q.events = append(q.events, evt)
+	q.bytes += len(evt.Data)
+	q.trimLocked()
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) trimLocked() {
+	if q.reliable && !q.stopped {
+		return
+	}
+	for i := 0; q.bytes > maxPendingBytes && i < len(q.events); {
+		if q.events[i].Type != "output" {
+			i++
+			continue
+		}
+		q.bytes -= len(q.events[i].Data)
+		q.events = append(q.events[:i], q.events[i+1:]...)
+		atomic.AddInt64(q.dropped, 1)
+		metricStreamDropped.Add(1)
+	}
+}
+
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+// stop tells a reliable queue that nothing reads it any more, so push stops
+// waiting for room.
+func (q *eventQueue) stop() {
+	q.mu.Lock()
+	q.stopped = true
+	q.mu.Unlock()
+	q.signalSpace()
+}
+
+func (q *eventQueue) signalSpace() {
+	select {
+	case q.space <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next event; ok is false when the queue is empty and closed is true
+// once the source subscription has ended.
+func (q *eventQueue) pop() (evt execEvent, ok bool, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.events) == 0 {
+		return execEvent{}, false, q.closed
+	}
+	evt = q.events[0]
+	q.events = q.events[1:]
+	q.bytes -= len(evt.Data)
+	q.signalSpace()
+	return evt, true, false
+}
+
+// peekSeq returns the seq of the next event without removing it.
+func (q *eventQueue) peekSeq() (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.events) == 0 {
+		return 0, false
+	}
+	return q.events[0].Seq, true
+}
+
+// streamFeed yields a subscription's snapshot and its live events merged in seq
+// order. Live events are drained into a queue from the moment the feed exists, so
+// a slow client working through a full snapshot doesn't back up the subscription
+// channel until publish starts dropping events.
+type streamFeed struct {
+	snapshot   []execEvent
+	inSnapshot map[int64]bool
+	queue      *eventQueue
+}
+
+func newStreamFeed(sub *subscriber, snapshot []execEvent) *streamFeed {
+	f := &streamFeed{
+		snapshot:   snapshot,
+		inSnapshot: make(map[int64]bool, len(snapshot)),
+		queue:      newEventQueue(&sub.dropped),
+	}
+	f.queue.reliable = sub.reliable
+	for _, evt := range snapshot {
+		f.inSnapshot[evt.Seq] = true
+	}
+	go func() {
+		for evt := range sub.ch {
+			f.queue.push(evt)
+		}
+		f.queue.close()
+	}()
+	return f
+}
+
+// stop releases the feed once the client is gone.
+func (f *streamFeed) stop() {
+	f.queue.stop()
+}
+
+// next returns the lowest-seq event of the snapshot head and the live queue head.
+// Seqs are assigned before publish, so a live event can precede the end of the
+// snapshot. ok is false when nothing is ready; closed is true once the
+// subscription has ended and everything has been returned.
+func (f *streamFeed) next() (evt execEvent, ok bool, closed bool) {
+	if len(f.snapshot) > 0 {
+		if seq, live := f.queue.peekSeq(); !live || seq >= f.snapshot[0].Seq {
+			evt, f.snapshot = f.snapshot[0], f.snapshot[1:]
+			return evt, true, false
+		}
+	}
+	for {
+		evt, ok, closed = f.queue.pop()
+		if ok && f.inSnapshot[evt.Seq] {
+			continue
+		}
+		return evt, ok, closed
+	}
+}
+
+// gapReporter turns a subscriber's drop counter into gap events, each carrying the
+// drops since the previous one. total is the sum of the gaps reported so far.
+type gapReporter struct {
+	dropped *int64
+	total   int64
+}
+
+func (g *gapReporter) next() (int64, bool) {
+	dropped := atomic.LoadInt64(g.dropped)
+	if dropped <= g.total {
+		return 0, false
+	}
+	n := dropped - g.total
+	g.total = dropped
+	return n, true
+}
+
+// byteThrottle is a token bucket over output bytes with a one-second burst.
+type byteThrottle struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteThrottle(bytesPerSec int) *byteThrottle {
+	return &byteThrottle{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+func (t *byteThrottle) wait(n int) {
+	if t == nil || t.rate <= 0 || n == 0 {
+		return
+	}
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.rate {
+		t.tokens = t.rate
+	}
+	t.last = now
+	t.tokens -= float64(n)
+	if t.tokens < 0 {
+		time.Sleep(time.Duration(-t.tokens / t.rate * float64(time.Second)))
+	}
 }
 
 var wsUpgrader = websocket.Upgrader{
@@ -122,3 +463,42 @@ func writeEventJSON(conn *websocket.Conn, evt execEvent) error {
 func nowTS() string {
 	return time.Now().UTC().Format(time.RFC3339Nano)
 }
+
+// outputGrouper prefixes each line of output events with "[<exec_id>] " so raw
+// consumers of a sandbox-wide stream can tell concurrent execs apart. It tracks,
+// per exec, whether the last chunk ended mid-line, so lines split across events
+// are prefixed once.
+type outputGrouper struct {
+	midLine map[string]bool
+}
+
+func newOutputGrouper() *outputGrouper {
+	return &outputGrouper{midLine: map[string]bool{}}
+}
+
+func (g *outputGrouper) apply(evt execEvent) execEvent {
+	if evt.Type == "exit" {
+		delete(g.midLine, evt.ExecID+"/stdout")
+		delete(g.midLine, evt.ExecID+"/stderr")
+		return evt
+	}
+	if evt.Type != "output" || evt.Data == "" {
+		return evt
+	}
+	key := evt.ExecID + "/" + evt.Stream
+	prefix := "[" + evt.ExecID + "] "
+	var b strings.Builder
+	lines := strings.SplitAfter(evt.Data, "\n")
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if !g.midLine[key] {
+			b.WriteString(prefix)
+		}
+		b.WriteString(line)
+		g.midLine[key] = !strings.HasSuffix(line, "\n")
+	}
+	evt.Data = b.String()
+	return evt
+}
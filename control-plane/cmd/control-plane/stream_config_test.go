@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+)
+
+func TestStreamConfigWithoutEndpointFallsBack(t *testing.T) {
+	t.Setenv("SANDBOX_STREAM_SIDECAR_IMAGE", "streamer:1")
+	t.Setenv("SANDBOX_STREAM_ENDPOINT", "")
+	cfg := streamConfigFromEnv()
+	if cfg.sidecarImage != "" {
+		t.Fatalf("sidecar image = %q, want it dropped without an endpoint", cfg.sidecarImage)
+	}
+	warnings := streamConfigWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "SANDBOX_STREAM_ENDPOINT is empty") {
+		t.Fatalf("warnings = %q, want one about the missing endpoint", warnings)
+	}
+
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if len(spec.Containers) != 1 {
+		t.Errorf("containers = %d, want only the sandbox container", len(spec.Containers))
+	}
+	for _, v := range spec.Volumes {
+		if v.Name == "sbx-events" {
+			t.Error("events volume added without a sidecar")
+		}
+	}
+	if _, pidFile := asyncExecCommand("0123456789abcdef", []string{"echo", "hi"}); strings.HasPrefix(pidFile, cfg.eventsDir) {
+		t.Errorf("async exec writes its PID to %s, want the control-plane wrapper instead of the sidecar one", pidFile)
+	}
+}
+
+func TestStreamConfigWarnsAboutStreamMode(t *testing.T) {
+	t.Setenv("SANDBOX_STREAM_MODE", "sidecar")
+	warnings := streamConfigWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "SANDBOX_STREAM_MODE is not a setting") {
+		t.Fatalf("warnings = %q, want one about SANDBOX_STREAM_MODE", warnings)
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func drain(q *eventQueue) []execEvent {
+	var out []execEvent
+	for {
+		evt, ok, _ := q.pop()
+		if !ok {
+			return out
+		}
+		out = append(out, evt)
+	}
+}
+
+func TestEventQueueCoalesces(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []execEvent
+		want []string
+	}{
+		{
+			name: "same exec and stream merge",
+			in:   []execEvent{{ExecID: "a", Type: "output", Stream: "stdout", Data: "x"}, {ExecID: "a", Type: "output", Stream: "stdout", Data: "y"}},
+			want: []string{"output:a:stdout:xy"},
+		},
+		{
+			name: "different streams stay apart",
+			in:   []execEvent{{ExecID: "a", Type: "output", Stream: "stdout", Data: "x"}, {ExecID: "a", Type: "output", Stream: "stderr", Data: "y"}},
+			want: []string{"output:a:stdout:x", "output:a:stderr:y"},
+		},
+		{
+			name: "different execs stay apart",
+			in:   []execEvent{{ExecID: "a", Type: "output", Stream: "stdout", Data: "x"}, {ExecID: "b", Type: "output", Stream: "stdout", Data: "y"}},
+			want: []string{"output:a:stdout:x", "output:b:stdout:y"},
+		},
+		{
+			name: "exit events break a run",
+			in: []execEvent{
+				{ExecID: "a", Type: "output", Stream: "stdout", Data: "x"},
+				{ExecID: "a", Type: "exit"},
+				{ExecID: "a", Type: "output", Stream: "stdout", Data: "y"},
+			},
+			want: []string{"output:a:stdout:x", "exit:a::", "output:a:stdout:y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dropped int64
+			q := newEventQueue(&dropped)
+			for _, evt := range tt.in {
+				q.push(evt)
+			}
+			var got []string
+			for _, evt := range drain(q) {
+				got = append(got, strings.Join([]string{evt.Type, evt.ExecID, evt.Stream, evt.Data}, ":"))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventQueueDropsOldestOutput(t *testing.T) {
+	var dropped int64
+	q := newEventQueue(&dropped)
+	chunk := strings.Repeat("x", maxCoalesceBytes)
+	q.push(execEvent{ExecID: "a", Type: "exit"})
+	for i := 0; i < maxPendingBytes/maxCoalesceBytes+2; i++ {
+		q.push(execEvent{ExecID: "a", Type: "output", Stream: "stdout", Data: chunk})
+	}
+	if dropped != 2 {
+		t.Errorf("dropped = %d, want 2", dropped)
+	}
+	events := drain(q)
+	if events[0].Type != "exit" {
+		t.Errorf("first event = %s, want the exit event to be kept", events[0].Type)
+	}
+	var bytes int
+	for _, evt := range events {
+		bytes += len(evt.Data)
+	}
+	if bytes > maxPendingBytes {
+		t.Errorf("%d bytes buffered, want at most %d", bytes, maxPendingBytes)
+	}
+}
+
+func TestEventQueueClose(t *testing.T) {
+	var dropped int64
+	q := newEventQueue(&dropped)
+	q.push(execEvent{ExecID: "a", Type: "exit"})
+	q.close()
+	if _, ok, closed := q.pop(); !ok || closed {
+		t.Fatalf("pop before drained: ok %v closed %v, want the queued event", ok, closed)
+	}
+	if _, ok, closed := q.pop(); ok || !closed {
+		t.Fatalf("pop after drained: ok %v closed %v, want closed", ok, closed)
+	}
+}
+
+func TestStreamHubCountsDroppedEvents(t *testing.T) {
+	h := newStreamHub(10)
+	sub, _ := h.subscribe("sbx-a")
+	defer h.unsubscribe("sbx-a", sub)
+	n := cap(sub.ch) + 5
+	for i := 0; i < n; i++ {
+		h.publish(execEvent{SandboxID: "sbx-a", ExecID: "a", Type: "output", Data: "x"})
+	}
+	if sub.dropped != 5 {
+		t.Errorf("dropped = %d, want 5 for a subscriber that never reads", sub.dropped)
+	}
+}
+
+func TestGapReportsOverflow(t *testing.T) {
+	h := newStreamHub(10)
+	sub, _ := h.subscribe("sbx-a")
+	defer h.unsubscribe("sbx-a", sub)
+	gaps := gapReporter{dropped: &sub.dropped}
+	if _, ok := gaps.next(); ok {
+		t.Fatal("gap reported before anything was dropped")
+	}
+
+	// Nothing reads sub.ch, so everything past its buffer is dropped.
+	for i := 0; i < cap(sub.ch)+3; i++ {
+		h.publish(execEvent{SandboxID: "sbx-a", ExecID: "a", Type: "output", Data: "x"})
+	}
+	if n, ok := gaps.next(); !ok || n != 3 {
+		t.Fatalf("gap = %d, %v; want 3 dropped", n, ok)
+	}
+	if _, ok := gaps.next(); ok {
+		t.Fatal("the same drops were reported twice")
+	}
+
+	for i := 0; i < 2; i++ {
+		h.publish(execEvent{SandboxID: "sbx-a", ExecID: "a", Type: "output", Data: "x"})
+	}
+	if n, ok := gaps.next(); !ok || n != 2 {
+		t.Fatalf("second gap = %d, %v; want the 2 new drops", n, ok)
+	}
+	if gaps.total != 5 {
+		t.Errorf("total = %d, want 5, the sum of the gaps", gaps.total)
+	}
+}
+
+func TestOutputGrouperPrefixesLines(t *testing.T) {
+	g := newOutputGrouper()
+	var got []string
+	for _, evt := range []execEvent{
+		{Type: "output", ExecID: "a", Stream: "stdout", Data: "one\ntw"},
+		{Type: "output", ExecID: "b", Stream: "stdout", Data: "x\n"},
+		{Type: "output", ExecID: "a", Stream: "stdout", Data: "o\nthree\n"},
+		{Type: "exit", ExecID: "a"},
+	} {
+		got = append(got, g.apply(evt).Data)
+	}
+	want := []string{"[a] one\n[a] tw", "[b] x\n", "o\n[a] three\n", ""}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if len(g.midLine) != 1 {
+		t.Errorf("grouper state = %v, want only exec b left after a exited", g.midLine)
+	}
+}
+
+func TestConcurrentExecEventsAreAttributable(t *testing.T) {
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, opts remotecommand.StreamOptions) error {
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(opts.Stdout, "%s %d\n", cmd[0], i)
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, execID := range []string{"aaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbb"} {
+		s.execs.createRunning("sbx-a", execID, time.Now(), nil, func() {})
+		wg.Add(1)
+		go func(execID string) {
+			defer wg.Done()
+			s.execCommandStream(context.Background(), "sbx-a", "sandbox", "sandbox", execID, []string{execID})
+		}(execID)
+	}
+	wg.Wait()
+
+	sub, snapshot := s.stream.subscribe("sbx-a")
+	defer s.stream.unsubscribe("sbx-a", sub)
+	g := newOutputGrouper()
+	exits := map[string]bool{}
+	for _, evt := range snapshot {
+		if evt.ExecID == "" {
+			t.Fatalf("event without exec_id: %+v", evt)
+		}
+		if evt.Type == "exit" {
+			exits[evt.ExecID] = true
+			continue
+		}
+		// Each exec only prints its own id, so grouped lines must match their prefix.
+		for _, line := range strings.SplitAfter(g.apply(evt).Data, "\n") {
+			if line != "" && !strings.HasPrefix(line, "["+evt.ExecID+"] "+evt.ExecID+" ") {
+				t.Fatalf("line %q attributed to %s", line, evt.ExecID)
+			}
+		}
+	}
+	if len(exits) != 2 {
+		t.Fatalf("exit events for %v, want both execs", exits)
+	}
+}
+
+func TestStreamFeedFullSnapshotWithConcurrentPublishes(t *testing.T) {
+	h := newStreamHub(200)
+	publish := func(i int) {
+		// Distinct exec ids keep the queue from coalescing events.
+		h.publish(execEvent{SandboxID: "sbx-a", ExecID: fmt.Sprintf("e%d", i), Seq: h.nextSeq(), Type: "output", Data: "x"})
+	}
+	for i := 0; i < 200; i++ {
+		publish(i)
+	}
+	sub, snapshot := h.subscribe("sbx-a")
+	if len(snapshot) != 200 {
+		t.Fatalf("snapshot has %d events, want a full buffer of 200", len(snapshot))
+	}
+	feed := newStreamFeed(sub, snapshot)
+
+	// More live events than the subscription channel holds arrive while the
+	// client is still slowly working through the snapshot.
+	const live = 400
+	published := make(chan struct{})
+	go func() {
+		for i := 200; i < 200+live; i++ {
+			publish(i)
+			// Let the feed drain the channel between bursts; only the client is slow.
+			for i%64 == 0 && len(sub.ch) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		close(published)
+	}()
+	var got []execEvent
+	for len(got) < 200+live {
+		evt, ok, _ := feed.next()
+		if !ok {
+			select {
+			case <-feed.queue.notify:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("stalled after %d events", len(got))
+			}
+			continue
+		}
+		if len(got) < 50 {
+			time.Sleep(time.Millisecond)
+		}
+		got = append(got, evt)
+	}
+	<-published
+	h.unsubscribe("sbx-a", sub)
+
+	if sub.dropped != 0 {
+		t.Errorf("%d events dropped, want none", sub.dropped)
+	}
+	for i, evt := range got {
+		if want := int64(i + 1); evt.Seq != want {
+			t.Fatalf("event %d has seq %d, want %d: events lost, repeated or out of order", i, evt.Seq, want)
+		}
+	}
+	if evt, ok, _ := feed.next(); ok {
+		t.Errorf("extra event after all were delivered: %+v", evt)
+	}
+}
+
+func TestStreamFeedMergesBySeq(t *testing.T) {
+	h := newStreamHub(10)
+	// seq 2 was assigned first but published after seq 3 made it into the buffer.
+	h.publish(execEvent{SandboxID: "sbx-a", Seq: 1, Type: "output"})
+	h.publish(execEvent{SandboxID: "sbx-a", Seq: 3, Type: "output"})
+	sub, snapshot := h.subscribe("sbx-a")
+	defer h.unsubscribe("sbx-a", sub)
+	feed := newStreamFeed(sub, snapshot)
+	h.publish(execEvent{SandboxID: "sbx-a", Seq: 2, Type: "exit"})
+	h.publish(execEvent{SandboxID: "sbx-a", Seq: 4, Type: "exit"})
+	var seqs []int64
+	deadline := time.Now().Add(5 * time.Second)
+	for len(seqs) < 4 {
+		// Wait for both live events so the merge sees them alongside the snapshot.
+		feed.queue.mu.Lock()
+		queued := len(feed.queue.events)
+		feed.queue.mu.Unlock()
+		if len(seqs) == 0 && queued < 2 {
+			if time.Now().After(deadline) {
+				t.Fatal("live events never reached the queue")
+			}
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		evt, ok, _ := feed.next()
+		if !ok {
+			t.Fatalf("feed ran dry after seqs %v", seqs)
+		}
+		seqs = append(seqs, evt.Seq)
+	}
+	if fmt.Sprint(seqs) != "[1 2 3 4]" {
+		t.Errorf("seqs = %v, want [1 2 3 4]", seqs)
+	}
+}
+
+func TestExecStreamPublishesOutputWithoutSidecar(t *testing.T) {
+	t.Setenv("SANDBOX_STREAM_SIDECAR_IMAGE", "")
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = func(_ context.Context, _, _, _ string, _ []string, opts remotecommand.StreamOptions) error {
+		fmt.Fprint(opts.Stdout, "out\n")
+		fmt.Fprint(opts.Stderr, "err\n")
+		return nil
+	}
+	s.execs.createRunning("sbx-a", "cccccccccccccccc", time.Now(), nil, func() {})
+	s.execCommandStream(context.Background(), "sbx-a", "sandbox", "sandbox", "cccccccccccccccc", []string{"x"})
+
+	sub, snapshot := s.stream.subscribe("sbx-a")
+	defer s.stream.unsubscribe("sbx-a", sub)
+	var got []string
+	var lastSeq int64
+	for _, evt := range snapshot {
+		if evt.Seq <= lastSeq || evt.Time == "" {
+			t.Fatalf("event %+v after seq %d", evt, lastSeq)
+		}
+		lastSeq = evt.Seq
+		got = append(got, evt.Type+":"+evt.Stream+":"+evt.Data)
+	}
+	want := "output:stdout:out\n,output:stderr:err\n,exit:stderr:"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+}
+
+func TestReliableSubscriberGetsEveryEvent(t *testing.T) {
+	h := newStreamHub(10)
+	lossy, _ := h.subscribe("sbx-a")
+	reliable, _ := h.subscribeReliable("sbx-a", 5*time.Second)
+	const n = 1000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			h.publish(execEvent{SandboxID: "sbx-a", Seq: h.nextSeq(), Type: "output", Data: "x"})
+		}
+	}()
+	// A slow reader still sees every event, in order.
+	var last int64
+	for i := 0; i < n; i++ {
+		evt := <-reliable.ch
+		if evt.Seq != last+1 {
+			t.Fatalf("event %d: seq %d after %d", i, evt.Seq, last)
+		}
+		last = evt.Seq
+		if i%100 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	<-done
+	if reliable.dropped != 0 || reliable.wasEvicted() {
+		t.Errorf("reliable dropped %d evicted %t", reliable.dropped, reliable.wasEvicted())
+	}
+	// The lossy subscriber was never read, so it kept its buffer and lost the rest.
+	if got := atomic.LoadInt64(&lossy.dropped); got != n-int64(cap(lossy.ch)) {
+		t.Errorf("lossy dropped %d, want %d", got, n-cap(lossy.ch))
+	}
+	h.unsubscribe("sbx-a", lossy)
+	h.unsubscribe("sbx-a", reliable)
+}
+
+func TestStuckReliableSubscriberIsEvicted(t *testing.T) {
+	h := newStreamHub(10)
+	stuck, _ := h.subscribeReliable("sbx-a", 20*time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 500; i++ {
+		h.publish(execEvent{SandboxID: "sbx-a", Seq: h.nextSeq(), Type: "output", Data: "x"})
+	}
+	// Only the event that found the channel full waited, once.
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("publish took %s", d)
+	}
+	if !stuck.wasEvicted() {
+		t.Fatal("stuck subscriber not evicted")
+	}
+	n := 0
+	for range stuck.ch {
+		n++
+	}
+	if n != cap(stuck.ch) {
+		t.Errorf("evicted subscriber got %d events, want its buffer of %d", n, cap(stuck.ch))
+	}
+	// Unsubscribing after eviction must not close the channel again.
+	h.unsubscribe("sbx-a", stuck)
+}
+
+func TestReliableQueueWaitsInsteadOfDropping(t *testing.T) {
+	var dropped int64
+	q := newEventQueue(&dropped)
+	q.reliable = true
+	big := strings.Repeat("x", maxPendingBytes/2+1)
+	q.push(execEvent{Type: "output", ExecID: "a", Stream: "stdout", Data: big})
+	pushed := make(chan struct{})
+	go func() {
+		q.push(execEvent{Type: "output", ExecID: "b", Stream: "stdout", Data: big})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push did not wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, ok, _ := q.pop(); !ok {
+		t.Fatal("queue empty")
+	}
+	<-pushed
+	if evt, ok, _ := q.pop(); !ok || evt.ExecID != "b" || dropped != 0 {
+		t.Fatalf("pop = %+v %t, dropped %d", evt, ok, dropped)
+	}
+}
+
+func TestWaitingPublishDoesNotBlockSubscribers(t *testing.T) {
+	h := newStreamHub(10)
+	stuck, _ := h.subscribeReliable("sbx-a", 5*time.Second)
+	for i := 0; i < cap(stuck.ch); i++ {
+		h.publish(execEvent{SandboxID: "sbx-a", Seq: h.nextSeq(), Type: "output", Data: "x"})
+	}
+	published := make(chan struct{})
+	go func() {
+		h.publish(execEvent{SandboxID: "sbx-a", Seq: h.nextSeq(), Type: "output", Data: "x"})
+		close(published)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The publish waiting on the stuck subscriber holds no lock others need.
+	subscribed := make(chan struct{})
+	go func() {
+		sub, _ := h.subscribe("sbx-a")
+		h.unsubscribe("sbx-a", sub)
+		close(subscribed)
+	}()
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("subscribe waited behind a publish to a stuck subscriber")
+	}
+	// Unsubscribing the stuck one releases the publish at once.
+	h.unsubscribe("sbx-a", stuck)
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish still waiting after its subscriber left")
+	}
+	if stuck.wasEvicted() {
+		t.Error("unsubscribed subscriber reported as evicted")
+	}
+}
+
+func TestSlowReliableSubscriberIsEvictedOnTotalStall(t *testing.T) {
+	h := newStreamHub(10)
+	slow, _ := h.subscribeReliable("sbx-a", 100*time.Millisecond)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		// Takes one event every 30ms, well within the timeout for each wait.
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(30 * time.Millisecond):
+			}
+			if _, ok := <-slow.ch; !ok {
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	for i := 0; i < 1000 && !slow.wasEvicted(); i++ {
+		h.publish(execEvent{SandboxID: "sbx-a", Seq: h.nextSeq(), Type: "output", Data: "x"})
+	}
+	if !slow.wasEvicted() {
+		t.Fatal("a subscriber that kept publish waiting was not evicted")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("eviction took %s", d)
+	}
+}
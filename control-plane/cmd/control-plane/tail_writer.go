@@ -0,0 +1,63 @@
+package main
+
+import (
+	"unicode/utf8"
+)
+
+// tailWriter keeps the last max bytes written to it in a fixed ring buffer and
+// counts everything it has seen, so a sync exec with ?tail_bytes=N holds at most
+// N bytes per stream however much the command prints. Each stream has its own
+// writer and the executor writes to it from a single goroutine.
+type tailWriter struct {
+	buf   []byte
+	start int
+	size  int
+	total int64
+}
+
+func newTailWriter(max int) *tailWriter {
+	return &tailWriter{buf: make([]byte, max)}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	w.total += int64(n)
+	max := len(w.buf)
+	if n >= max {
+		copy(w.buf, p[n-max:])
+		w.start, w.size = 0, max
+		return n, nil
+	}
+	end := (w.start + w.size) % max
+	copied := copy(w.buf[end:], p)
+	copy(w.buf, p[copied:])
+	w.size += n
+	if w.size > max {
+		w.start = (w.start + w.size - max) % max
+		w.size = max
+	}
+	return n, nil
+}
+
+// truncated reports whether output was dropped from the front of the stream.
+func (w *tailWriter) truncated() bool {
+	return w.total > int64(w.size)
+}
+
+// String returns the retained tail. When output was dropped, a leading partial
+// UTF-8 sequence is skipped so the tail doesn't start mid-character.
+func (w *tailWriter) String() string {
+	out := make([]byte, 0, w.size)
+	out = append(out, w.buf[w.start:min(w.start+w.size, len(w.buf))]...)
+	if rest := w.size - len(out); rest > 0 {
+		out = append(out, w.buf[:rest]...)
+	}
+	if w.truncated() {
+		cut := 0
+		for cut < len(out) && cut < utf8.UTFMax && !utf8.RuneStart(out[cut]) {
+			cut++
+		}
+		out = out[cut:]
+	}
+	return string(out)
+}
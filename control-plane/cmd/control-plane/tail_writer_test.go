@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestTailWriterKeepsLastBytes(t *testing.T) {
+	w := newTailWriter(8)
+	for _, chunk := range []string{"abc", "defgh", "ij", "klmnopqrstu", "vw"} {
+		_, _ = w.Write([]byte(chunk))
+	}
+	if got := w.String(); got != "pqrstuvw" {
+		t.Fatalf("tail = %q, want %q", got, "pqrstuvw")
+	}
+	if w.total != 23 || !w.truncated() {
+		t.Fatalf("total = %d truncated = %v, want 23 and true", w.total, w.truncated())
+	}
+
+	short := newTailWriter(8)
+	_, _ = short.Write([]byte("hi"))
+	if short.String() != "hi" || short.truncated() {
+		t.Fatalf("short output = %q truncated = %v", short.String(), short.truncated())
+	}
+
+	// A multi-byte rune cut by the ring is dropped rather than returned broken.
+	utf := newTailWriter(3)
+	_, _ = utf.Write([]byte("éé"))
+	if got := utf.String(); got != "é" {
+		t.Fatalf("utf-8 tail = %q, want a whole rune", got)
+	}
+}
+
+func TestSyncExecTailBytes(t *testing.T) {
+	t.Setenv("SANDBOX_ASYNC_EXEC", "false")
+	s := newTestServer(readyPod("sbx-a"))
+	s.podExec = func(_ context.Context, _, _, _ string, _ []string, opts remotecommand.StreamOptions) error {
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(opts.Stdout, "line %04d\n", i)
+		}
+		fmt.Fprint(opts.Stderr, "warn\n")
+		return nil
+	}
+	w := serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec?tail_bytes=20", api.ExecRequest{Command: []string{"seq"}})
+	if w.Code != 200 {
+		t.Fatalf("status = %d body %s", w.Code, w.Body)
+	}
+	var resp api.ExecResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stdout != "line 0998\nline 0999\n" || resp.Stderr != "warn\n" {
+		t.Errorf("output = %q / %q, want the last 20 bytes of stdout and all of stderr", resp.Stdout, resp.Stderr)
+	}
+	if !resp.Truncated || resp.StdoutBytes != 10000 || resp.StderrBytes != 5 {
+		t.Errorf("truncated = %v, bytes = %d/%d; want true, 10000/5", resp.Truncated, resp.StdoutBytes, resp.StderrBytes)
+	}
+
+	for _, q := range []string{"tail_bytes=0", "tail_bytes=lots", "tail_bytes=20&ordered=true", fmt.Sprintf("tail_bytes=%d", maxExecLogBytes+1)} {
+		w := serve(s.execSandbox, "POST", "/sandboxes/:id/exec", "/sandboxes/sbx-a/exec?"+q, api.ExecRequest{Command: []string{"seq"}})
+		if w.Code != 400 || !strings.Contains(w.Body.String(), errCodeInvalidRequest) {
+			t.Errorf("%s: status = %d body %s, want 400", q, w.Code, w.Body)
+		}
+	}
+}
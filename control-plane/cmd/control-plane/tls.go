@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// shutdownTimeout is how long in-flight requests and gRPC calls get to finish once
+// the control plane is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// newHTTPServer builds the control-plane server. When SANDBOX_TLS_CERT and
+// SANDBOX_TLS_KEY are set the returned server is configured for TLS, and with
+// SANDBOX_TLS_CLIENT_CA it also requires client certificates signed by that CA.
+// Certificates are loaded up front so misconfiguration fails at startup.
+func newHTTPServer(addr string, handler http.Handler) (*http.Server, error) {
+	tlsCfg, err := serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsCfg}, nil
+}
+
+// serverTLSConfig loads the SANDBOX_TLS_* settings newHTTPServer describes. It
+// returns nil when TLS is off.
+func serverTLSConfig() (*tls.Config, error) {
+	certFile := getenv("SANDBOX_TLS_CERT", "")
+	keyFile := getenv("SANDBOX_TLS_KEY", "")
+	clientCAFile := getenv("SANDBOX_TLS_CLIENT_CA", "")
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("SANDBOX_TLS_CLIENT_CA requires SANDBOX_TLS_CERT and SANDBOX_TLS_KEY")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("SANDBOX_TLS_CERT and SANDBOX_TLS_KEY must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls key pair: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client ca %s: no certificates found", clientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
+
+// runServer runs srv, using TLS when newHTTPServer configured it, until ctx is
+// done. It then shuts srv down, waiting up to shutdownTimeout for requests in
+// flight.
+func runServer(ctx context.Context, srv *http.Server) error {
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		stopped <- srv.Shutdown(shutdownCtx)
+	}()
+	var err error
+	if srv.TLSConfig != nil {
+		// The key pair is already in TLSConfig.
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-stopped
+}
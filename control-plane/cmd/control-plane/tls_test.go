@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a throwaway certificate and key under dir and returns
+// their paths.
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sbx-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name                string
+		cert, key, clientCA string
+		wantErr             string
+		wantTLS             bool
+		wantClientAuth      tls.ClientAuthType
+	}{
+		{name: "plaintext"},
+		{name: "client ca alone", clientCA: certFile, wantErr: "requires SANDBOX_TLS_CERT"},
+		{name: "cert without key", cert: certFile, wantErr: "must be set together"},
+		{name: "missing files", cert: filepath.Join(dir, "nope.crt"), key: filepath.Join(dir, "nope.key"), wantErr: "load tls key pair"},
+		{name: "key mismatch", cert: certFile, key: notPEM, wantErr: "load tls key pair"},
+		{name: "tls", cert: certFile, key: keyFile, wantTLS: true, wantClientAuth: tls.NoClientCert},
+		{name: "missing client ca", cert: certFile, key: keyFile, clientCA: filepath.Join(dir, "nope.pem"), wantErr: "read client ca"},
+		{name: "client ca without certs", cert: certFile, key: keyFile, clientCA: notPEM, wantErr: "no certificates found"},
+		{name: "mtls", cert: certFile, key: keyFile, clientCA: certFile, wantTLS: true, wantClientAuth: tls.RequireAndVerifyClientCert},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SANDBOX_TLS_CERT", tc.cert)
+			t.Setenv("SANDBOX_TLS_KEY", tc.key)
+			t.Setenv("SANDBOX_TLS_CLIENT_CA", tc.clientCA)
+			cfg, err := serverTLSConfig()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				if _, err := newHTTPServer(":0", nil); err == nil {
+					t.Fatal("newHTTPServer accepted the configuration")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("serverTLSConfig: %v", err)
+			}
+			if (cfg != nil) != tc.wantTLS {
+				t.Fatalf("tls config = %v, want TLS %t", cfg, tc.wantTLS)
+			}
+			if cfg == nil {
+				return
+			}
+			if len(cfg.Certificates) != 1 || cfg.MinVersion != tls.VersionTLS12 {
+				t.Errorf("tls config = %+v, want one certificate and TLS 1.2 minimum", cfg)
+			}
+			if cfg.ClientAuth != tc.wantClientAuth {
+				t.Errorf("ClientAuth = %v, want %v", cfg.ClientAuth, tc.wantClientAuth)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// parseTopologySpread parses SANDBOX_TOPOLOGY_SPREAD, a comma-separated list of
+// topologyKey:maxSkew[:whenUnsatisfiable] entries such as
+// "topology.kubernetes.io/zone:1,kubernetes.io/hostname:2:DoNotSchedule".
+func parseTopologySpread(raw string) ([]api.TopologySpread, error) {
+	var out []api.TopologySpread
+	for _, entry := range splitCSV(raw) {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("SANDBOX_TOPOLOGY_SPREAD entry %q must be topologyKey:maxSkew[:whenUnsatisfiable]", entry)
+		}
+		skew, err := strconv.ParseInt(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("SANDBOX_TOPOLOGY_SPREAD entry %q: max skew must be a number", entry)
+		}
+		ts := api.TopologySpread{TopologyKey: parts[0], MaxSkew: int32(skew)}
+		if len(parts) == 3 {
+			ts.WhenUnsatisfiable = parts[2]
+		}
+		out = append(out, ts)
+	}
+	if err := validateTopologySpread(out); err != nil {
+		return nil, fmt.Errorf("SANDBOX_TOPOLOGY_SPREAD: %v", err)
+	}
+	return out, nil
+}
+
+func validateTopologySpread(list []api.TopologySpread) error {
+	seen := map[string]bool{}
+	for _, ts := range list {
+		if ts.TopologyKey == "" {
+			return fmt.Errorf("topology_spread topology_key is required")
+		}
+		if errs := validation.IsQualifiedName(ts.TopologyKey); len(errs) > 0 {
+			return fmt.Errorf("topology_spread topology_key %q is invalid: %s", ts.TopologyKey, strings.Join(errs, "; "))
+		}
+		if seen[ts.TopologyKey] {
+			return fmt.Errorf("topology_spread topology_key %q is repeated", ts.TopologyKey)
+		}
+		seen[ts.TopologyKey] = true
+		if ts.MaxSkew < 1 {
+			return fmt.Errorf("topology_spread max_skew must be at least 1")
+		}
+		if ts.WhenUnsatisfiable != "" && !containsString([]string{"ScheduleAnyway", "DoNotSchedule"}, ts.WhenUnsatisfiable) {
+			return fmt.Errorf("topology_spread when_unsatisfiable must be one of: ScheduleAnyway, DoNotSchedule")
+		}
+	}
+	return nil
+}
+
+// topologySpreadApplies reports whether topology spread constraints can do
+// anything. Kubernetes only counts pods in the incoming pod's namespace, so with a
+// namespace per sandbox each sandbox pod is alone and spreads trivially; only in
+// single-namespace mode do sandboxes see each other. SANDBOX_SPREAD's
+// anti-affinity works across namespaces instead.
+func topologySpreadApplies() bool {
+	return singleNamespace() != ""
+}
+
+// topologySpreadConstraints builds constraints over sandbox pods from validated
+// entries. whenUnsatisfiable defaults to ScheduleAnyway, so a constraint never
+// leaves a sandbox pending unless asked to.
+func topologySpreadConstraints(list []api.TopologySpread) []corev1.TopologySpreadConstraint {
+	var out []corev1.TopologySpreadConstraint
+	for _, ts := range list {
+		when := corev1.ScheduleAnyway
+		if ts.WhenUnsatisfiable != "" {
+			when = corev1.UnsatisfiableConstraintAction(ts.WhenUnsatisfiable)
+		}
+		out = append(out, corev1.TopologySpreadConstraint{
+			MaxSkew:           ts.MaxSkew,
+			TopologyKey:       ts.TopologyKey,
+			WhenUnsatisfiable: when,
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{sandboxPodLabel: "true"},
+			},
+		})
+	}
+	return out
+}
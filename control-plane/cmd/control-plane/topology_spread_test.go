@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseTopologySpread(t *testing.T) {
+	list, err := parseTopologySpread("topology.kubernetes.io/zone:1, kubernetes.io/hostname:2:DoNotSchedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []api.TopologySpread{
+		{TopologyKey: "topology.kubernetes.io/zone", MaxSkew: 1},
+		{TopologyKey: "kubernetes.io/hostname", MaxSkew: 2, WhenUnsatisfiable: "DoNotSchedule"},
+	}
+	if len(list) != len(want) || list[0] != want[0] || list[1] != want[1] {
+		t.Fatalf("parsed %+v, want %+v", list, want)
+	}
+	for raw, wantErr := range map[string]string{
+		"zone":                              "topologyKey:maxSkew",
+		"zone:x":                            "max skew must be a number",
+		"zone:0":                            "max_skew must be at least 1",
+		"zone:1,zone:2":                     "is repeated",
+		"bad key!:1":                        "is invalid",
+		"zone:1:Sometimes":                  "when_unsatisfiable",
+		"topology.kubernetes.io/zone:1:a:b": "topologyKey:maxSkew",
+	} {
+		if _, err := parseTopologySpread(raw); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%q: error = %v, want it to mention %q", raw, err, wantErr)
+		}
+	}
+}
+
+func TestSandboxPodSpecTopologySpread(t *testing.T) {
+	t.Setenv("SANDBOX_TOPOLOGY_SPREAD", "topology.kubernetes.io/zone:1")
+	t.Setenv("SANDBOX_SINGLE_NAMESPACE", "sandboxes")
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if len(spec.TopologySpreadConstraints) != 1 {
+		t.Fatalf("constraints = %+v, want the configured one", spec.TopologySpreadConstraints)
+	}
+	c := spec.TopologySpreadConstraints[0]
+	if c.TopologyKey != "topology.kubernetes.io/zone" || c.MaxSkew != 1 || c.WhenUnsatisfiable != corev1.ScheduleAnyway {
+		t.Errorf("constraint = %+v, want zone skew 1 ScheduleAnyway", c)
+	}
+	if c.LabelSelector == nil || c.LabelSelector.MatchLabels[sandboxPodLabel] != "true" {
+		t.Errorf("selector = %+v, want sandbox pods", c.LabelSelector)
+	}
+
+	req := api.CreateSandboxRequest{TopologySpread: []api.TopologySpread{{TopologyKey: corev1.LabelHostname, MaxSkew: 3, WhenUnsatisfiable: "DoNotSchedule"}}}
+	spec = sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(req))
+	if len(spec.TopologySpreadConstraints) != 1 || spec.TopologySpreadConstraints[0].TopologyKey != corev1.LabelHostname ||
+		spec.TopologySpreadConstraints[0].WhenUnsatisfiable != corev1.DoNotSchedule {
+		t.Errorf("constraints = %+v, want the request's list in place of the configured one", spec.TopologySpreadConstraints)
+	}
+}
+
+func TestTopologySpreadNeedsSingleNamespace(t *testing.T) {
+	// Each sandbox pod is alone in its namespace, so the constraints would be moot.
+	t.Setenv("SANDBOX_TOPOLOGY_SPREAD", "topology.kubernetes.io/zone:1")
+	spec := sandboxPodSpec("img", nil, "emptydir", "", cacheConfigFromEnv(), nil, podConfigFromRequest(api.CreateSandboxRequest{}))
+	if len(spec.TopologySpreadConstraints) != 0 {
+		t.Fatalf("constraints = %+v, want none with a namespace per sandbox", spec.TopologySpreadConstraints)
+	}
+	req := api.CreateSandboxRequest{TopologySpread: []api.TopologySpread{{TopologyKey: corev1.LabelHostname, MaxSkew: 1}}}
+	if err := validateCreateRequest(req); err == nil || !strings.Contains(err.Error(), "SANDBOX_SINGLE_NAMESPACE") {
+		t.Fatalf("validate = %v, want topology_spread refused", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// maxStreamTouchInterval bounds how often an open stream resets the idle timer.
+const maxStreamTouchInterval = time.Minute
+
+// touchSandbox resets a sandbox's idle timer (sbx.last_exec_at) without running
+// anything, for sessions that are in use but not exec-ing.
+func (s *server) touchSandbox(c *gin.Context) {
+	ns := c.Param("id")
+	if !strings.HasPrefix(ns, sandboxNamespacePrefix) {
+		writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox not found")
+		return
+	}
+	now := time.Now()
+	if err := s.updateLastExec(c.Request.Context(), ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			writeErrorCode(c, 404, errCodeSandboxNotFound, "sandbox not found")
+			return
+		}
+		writeError(c, 500, err.Error())
+		return
+	}
+	writeJSON(c, 200, map[string]string{"status": "touched", "last_exec_at": strconv.FormatInt(now.Unix(), 10)})
+}
+
+// streamTouchInterval is how often an open stream touches its sandbox: a third of
+// SANDBOX_IDLE_TTL, so a stream outlives a missed touch or two, capped at a minute.
+func streamTouchInterval() time.Duration {
+	ttl := getenvDuration("SANDBOX_IDLE_TTL", defaultIdleTTL)
+	if ttl <= 0 {
+		return 0
+	}
+	interval := ttl / 3
+	if interval > maxStreamTouchInterval {
+		interval = maxStreamTouchInterval
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// keepAlive touches ns every interval until ctx is done, so the reaper leaves a
+// sandbox alone while a client is watching it. A zero interval does nothing.
+func (s *server) keepAlive(ctx context.Context, ns string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = s.updateLastExec(ctx, ns)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func lastExecAt(t *testing.T, s *server, ns string) int64 {
+	t.Helper()
+	n, err := s.client.CoreV1().Namespaces().Get(context.Background(), ns, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := strconv.ParseInt(n.Annotations["sbx.last_exec_at"], 10, 64)
+	if err != nil {
+		t.Fatalf("sbx.last_exec_at: %v", err)
+	}
+	return ts
+}
+
+func idleNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Annotations: map[string]string{"sbx.last_exec_at": "1000"},
+	}}
+}
+
+func TestTouchSandboxAdvancesLastExec(t *testing.T) {
+	s := newTestServer(idleNamespace("sbx-demo"))
+	w := serve(s.touchSandbox, http.MethodPost, "/sandboxes/:id/touch", "/sandboxes/sbx-demo/touch", nil)
+	if w.Code != 200 {
+		t.Fatalf("touch: status %d: %s", w.Code, w.Body)
+	}
+	if got := lastExecAt(t, s, "sbx-demo"); got < time.Now().Add(-time.Minute).Unix() {
+		t.Errorf("sbx.last_exec_at = %d, want about now", got)
+	}
+
+	for _, path := range []string{"/sandboxes/sbx-missing/touch", "/sandboxes/kube-system/touch"} {
+		if w := serve(s.touchSandbox, http.MethodPost, "/sandboxes/:id/touch", path, nil); w.Code != 404 {
+			t.Errorf("%s: status %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestKeepAliveTouchesUntilCanceled(t *testing.T) {
+	s := newTestServer(idleNamespace("sbx-demo"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.keepAlive(ctx, "sbx-demo", 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for lastExecAt(t, s, "sbx-demo") == 1000 {
+		if time.Now().After(deadline) {
+			t.Fatal("keepAlive never touched the sandbox")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepAlive did not stop after cancel")
+	}
+}
+
+func TestStreamTouchInterval(t *testing.T) {
+	for ttl, want := range map[string]time.Duration{"15m": time.Minute, "90s": 30 * time.Second, "1s": time.Second, "0": 0} {
+		t.Setenv("SANDBOX_IDLE_TTL", ttl)
+		if got := streamTouchInterval(); got != want {
+			t.Errorf("SANDBOX_IDLE_TTL=%s: interval %s, want %s", ttl, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	usageCacheTTL       = 5 * time.Second
+	metricsGroupVersion = "metrics.k8s.io/v1beta1"
+)
+
+var errMetricsUnavailable = errors.New("metrics API (metrics.k8s.io) is not available; install metrics-server")
+
+// podMetrics mirrors the subset of metrics.k8s.io/v1beta1 PodMetrics we need.
+type podMetrics struct {
+	Timestamp  metav1.Time     `json:"timestamp"`
+	Window     metav1.Duration `json:"window"`
+	Containers []struct {
+		Name  string `json:"name"`
+		Usage struct {
+			CPU    resource.Quantity `json:"cpu"`
+			Memory resource.Quantity `json:"memory"`
+		} `json:"usage"`
+	} `json:"containers"`
+}
+
+type usageCache struct {
+	mu      sync.Mutex
+	entries map[string]usageEntry
+}
+
+type usageEntry struct {
+	at   time.Time
+	resp api.SandboxUsage
+}
+
+// get returns the cached usage for ns if it is still fresh.
+func (u *usageCache) get(ns string) (api.SandboxUsage, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	e, ok := u.entries[ns]
+	if !ok || time.Since(e.at) >= usageCacheTTL {
+		return api.SandboxUsage{}, false
+	}
+	return e.resp, true
+}
+
+// put caches resp for ns, dropping expired entries so sandboxes that are no
+// longer polled don't stay in the map.
+func (u *usageCache) put(ns string, resp api.SandboxUsage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	for k, e := range u.entries {
+		if now.Sub(e.at) >= usageCacheTTL {
+			delete(u.entries, k)
+		}
+	}
+	if u.entries == nil {
+		u.entries = map[string]usageEntry{}
+	}
+	u.entries[ns] = usageEntry{at: now, resp: resp}
+}
+
+// forget drops the cached usage for a deleted sandbox.
+func (u *usageCache) forget(ns string) {
+	u.mu.Lock()
+	delete(u.entries, ns)
+	u.mu.Unlock()
+}
+
+func (s *server) getSandboxUsage(c *gin.Context) {
+	id := c.Param("id")
+	ns := id
+	if resp, ok := s.usage.get(ns); ok {
+		writeJSON(c, 200, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	podNS, podName := sandboxPod(ns, "sandbox")
+	resp, err := s.fetchPodUsage(ctx, podNS, podName)
+	if err != nil {
+		switch {
+		case errors.Is(err, errMetricsUnavailable):
+			writeErrorCode(c, 503, errCodeMetricsUnavailable, err.Error())
+		case apierrors.IsNotFound(err):
+			writeError(c, 404, "no metrics for sandbox yet: "+err.Error())
+		default:
+			writeError(c, 500, err.Error())
+		}
+		return
+	}
+	resp.ID = id
+	s.usage.put(ns, resp)
+	writeJSON(c, 200, resp)
+}
+
+func (s *server) fetchPodUsage(ctx context.Context, ns, pod string) (api.SandboxUsage, error) {
+	if _, err := s.client.Discovery().ServerResourcesForGroupVersion(metricsGroupVersion); err != nil {
+		if apierrors.IsNotFound(err) {
+			return api.SandboxUsage{}, errMetricsUnavailable
+		}
+		return api.SandboxUsage{}, err
+	}
+	raw, err := s.client.Discovery().RESTClient().Get().
+		AbsPath("/apis", metricsGroupVersion, "namespaces", ns, "pods", pod).
+		DoRaw(ctx)
+	if err != nil {
+		return api.SandboxUsage{}, err
+	}
+	var pm podMetrics
+	if err := json.Unmarshal(raw, &pm); err != nil {
+		return api.SandboxUsage{}, err
+	}
+	out := api.SandboxUsage{
+		Timestamp:  pm.Timestamp.UTC().Format(time.RFC3339),
+		Window:     pm.Window.Duration.String(),
+		Containers: make([]api.ContainerUsage, 0, len(pm.Containers)),
+	}
+	for _, ctr := range pm.Containers {
+		out.Containers = append(out.Containers, api.ContainerUsage{
+			Name:        ctr.Name,
+			CPU:         ctr.Usage.CPU.String(),
+			Memory:      ctr.Usage.Memory.String(),
+			CPUMillis:   ctr.Usage.CPU.MilliValue(),
+			MemoryBytes: ctr.Usage.Memory.Value(),
+		})
+	}
+	return out, nil
+}
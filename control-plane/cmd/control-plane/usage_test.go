@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUsageCacheDropsStaleEntries(t *testing.T) {
+	s := newTestServer(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-a"}})
+	s.usage.entries = map[string]usageEntry{
+		"sbx-gone": {at: time.Now().Add(-time.Minute), resp: api.SandboxUsage{ID: "sbx-gone"}},
+	}
+	s.usage.put("sbx-a", api.SandboxUsage{ID: "sbx-a"})
+	if _, ok := s.usage.entries["sbx-gone"]; ok {
+		t.Error("expired entry kept after put")
+	}
+	if resp, ok := s.usage.get("sbx-a"); !ok || resp.ID != "sbx-a" {
+		t.Fatalf("get sbx-a = %+v, %v", resp, ok)
+	}
+
+	if err := s.deleteSandboxObjects(context.Background(), "sbx-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.usage.entries["sbx-a"]; ok {
+		t.Error("deleted sandbox still cached")
+	}
+}
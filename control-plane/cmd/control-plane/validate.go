@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	allowedVolumeModes = []string{"emptydir", "pvc"}
+	allowedCacheModes  = []string{"emptydir", "hostpath", "pvc", "shared-pvc"}
+	allowedAccessModes = []string{"ReadWriteOnce", "ReadWriteMany", "ReadOnlyMany", "rwo", "rwx", "rox"}
+	// allowedVolumeTypes are the extra volume sources a create request may ask for.
+	// Anything that exposes the node, such as hostPath, is deliberately absent.
+	allowedVolumeTypes = []string{"emptydir", "pvc"}
+)
+
+const (
+	// Env lives in the pod object, which etcd caps at about 1.5MiB, so an oversized
+	// env fails pod creation with an error that doesn't name the variable.
+	defaultMaxEnvValueBytes = 128 << 10
+	defaultMaxEnvBytes      = 512 << 10
+)
+
+// validateCreateRequest checks the enum and quantity fields of a create request
+// before any cluster objects are touched. Empty fields fall back to defaults and are valid.
+func validateCreateRequest(req api.CreateSandboxRequest) error {
+	if req.Image != "" && strings.TrimSpace(req.Image) != req.Image {
+		return fmt.Errorf("image must not contain leading or trailing whitespace")
+	}
+	if req.VolumeMode != "" && !containsString(allowedVolumeModes, req.VolumeMode) {
+		return fmt.Errorf("volume_mode must be one of: %s", strings.Join(allowedVolumeModes, ", "))
+	}
+	if req.CacheMode != "" && !containsString(allowedCacheModes, req.CacheMode) {
+		return fmt.Errorf("cache_mode must be one of: %s", strings.Join(allowedCacheModes, ", "))
+	}
+	if req.CachePVCAccessMode != "" && !containsFold(allowedAccessModes, strings.TrimSpace(req.CachePVCAccessMode)) {
+		return fmt.Errorf("cache_pvc_access_mode must be one of: %s", strings.Join(allowedAccessModes, ", "))
+	}
+	if req.CachePVCSize != "" {
+		if _, err := resource.ParseQuantity(req.CachePVCSize); err != nil {
+			return fmt.Errorf("cache_pvc_size is not a valid quantity: %v", err)
+		}
+	}
+	if req.ServiceAccountName != "" {
+		if errs := validation.IsDNS1123Subdomain(req.ServiceAccountName); len(errs) > 0 {
+			return fmt.Errorf("service_account_name is invalid: %s", strings.Join(errs, "; "))
+		}
+	}
+	if req.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(req.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("priority_class_name is invalid: %s", strings.Join(errs, "; "))
+		}
+	}
+	if err := validateEnvSize(req.Env); err != nil {
+		return err
+	}
+	for _, name := range append(append([]string{}, req.EnvFromSecret...), req.EnvFromConfigMap...) {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return fmt.Errorf("env_from reference %q is invalid: %s", name, strings.Join(errs, "; "))
+		}
+	}
+	for _, f := range []struct{ name, value string }{{"hostname", req.Hostname}, {"subdomain", req.Subdomain}} {
+		if f.value == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(f.value); len(errs) > 0 {
+			return fmt.Errorf("%s is invalid: %s", f.name, strings.Join(errs, "; "))
+		}
+	}
+	for _, gate := range req.ReadinessGates {
+		if errs := validation.IsQualifiedName(gate); len(errs) > 0 {
+			return fmt.Errorf("readiness_gates entry %q is invalid: %s", gate, strings.Join(errs, "; "))
+		}
+	}
+	if err := validateStartupProbe(req.StartupProbe); err != nil {
+		return err
+	}
+	if err := validateMetrics(req.Metrics); err != nil {
+		return err
+	}
+	if err := validateGitRepo(req.GitRepo); err != nil {
+		return err
+	}
+	if err := validateIdleTTL(req.IdleTTL); err != nil {
+		return err
+	}
+	if err := validateExpiresAt(req.ExpiresAt, time.Now()); err != nil {
+		return err
+	}
+	if req.ActiveDeadlineSeconds != nil && *req.ActiveDeadlineSeconds <= 0 {
+		return fmt.Errorf("active_deadline_seconds must be positive")
+	}
+	if req.RestartPolicy != "" && !containsString([]string{"Always", "OnFailure", "Never"}, req.RestartPolicy) {
+		return fmt.Errorf("restart_policy must be one of: Always, OnFailure, Never")
+	}
+	for _, t := range req.Tolerations {
+		if t.Operator != "" && t.Operator != "Exists" && t.Operator != "Equal" {
+			return fmt.Errorf("toleration operator must be one of: Exists, Equal")
+		}
+		if t.Operator == "Exists" && t.Value != "" {
+			return fmt.Errorf("toleration value must be empty when operator is Exists")
+		}
+		if t.Effect != "" && !containsString([]string{"NoSchedule", "PreferNoSchedule", "NoExecute"}, t.Effect) {
+			return fmt.Errorf("toleration effect must be one of: NoSchedule, PreferNoSchedule, NoExecute")
+		}
+	}
+	if err := validateDNSOverride(req.DNSPolicy, req.DNSServers); err != nil {
+		return err
+	}
+	if err := validateDNS(dnsFromRequest(req.DNSPolicy, req.DNSServers)); err != nil {
+		return err
+	}
+	if err := validateTopologySpread(req.TopologySpread); err != nil {
+		return err
+	}
+	if len(req.TopologySpread) > 0 && !topologySpreadApplies() {
+		return fmt.Errorf("topology_spread only applies with SANDBOX_SINGLE_NAMESPACE, where sandbox pods share a namespace; use spread instead")
+	}
+	if err := validatePodSpecOverlay(req.PodSpecOverlay); err != nil {
+		return err
+	}
+	shareProcessNamespace := getenvBool("SANDBOX_USE_INIT", false)
+	if req.ShareProcessNamespace != nil {
+		shareProcessNamespace = *req.ShareProcessNamespace
+	}
+	if shareProcessNamespace {
+		// Kubernetes rejects the pod if both are set; fail before creating anything.
+		var overlay struct {
+			HostPID bool `json:"hostPID"`
+		}
+		if !emptyOverlay(req.PodSpecOverlay) && json.Unmarshal(req.PodSpecOverlay, &overlay) == nil && overlay.HostPID {
+			return fmt.Errorf("share_process_namespace (on by default with SANDBOX_USE_INIT) cannot be combined with hostPID in pod_spec_overlay")
+		}
+	}
+	workspacePath, cachePath := mountPathsFromRequest(req)
+	if err := validateMountPaths(workspacePath, cachePath); err != nil {
+		return err
+	}
+	return validateVolumes(req.Volumes, workspacePath, cachePath)
+}
+
+// validateMountPaths checks the built-in workspace and cache mount paths, which may
+// not overlap each other or the sidecar events dir.
+func validateMountPaths(workspacePath, cachePath string) error {
+	builtin := []struct{ name, path string }{
+		{"workspace_path", workspacePath},
+		{"cache_path", cachePath},
+	}
+	for _, m := range builtin {
+		if !path.IsAbs(m.path) || path.Clean(m.path) == "/" {
+			return fmt.Errorf("%s must be an absolute path below /", m.name)
+		}
+	}
+	if pathsOverlap(workspacePath, cachePath) {
+		return fmt.Errorf("workspace_path %s overlaps cache_path %s", workspacePath, cachePath)
+	}
+	if streamCfg := streamConfigFromEnv(); streamCfg.sidecarImage != "" {
+		for _, m := range builtin {
+			if pathsOverlap(m.path, streamCfg.eventsDir) {
+				return fmt.Errorf("%s %s overlaps the events dir %s", m.name, m.path, streamCfg.eventsDir)
+			}
+		}
+	}
+	return nil
+}
+
+// validateImagePolicy applies SANDBOX_ALLOWED_REGISTRIES, SANDBOX_REQUIRE_DIGEST and
+// SANDBOX_REJECT_LATEST to image. Untagged references count as :latest since that is
+// what the runtime pulls.
+func validateImagePolicy(image string) error {
+	if allowed := splitCSV(getenv("SANDBOX_ALLOWED_REGISTRIES", "")); len(allowed) > 0 && !imageFromRegistries(image, allowed) {
+		return fmt.Errorf("image %q is not from an allowed registry (%s); SANDBOX_ALLOWED_REGISTRIES is set", image, strings.Join(allowed, ", "))
+	}
+	name, digest, _ := strings.Cut(image, "@")
+	pinned := digest != ""
+	if getenvBool("SANDBOX_REQUIRE_DIGEST", false) && !pinned {
+		return fmt.Errorf("image %q must be pinned by digest (name@sha256:...); SANDBOX_REQUIRE_DIGEST is set", image)
+	}
+	if pinned || !getenvBool("SANDBOX_REJECT_LATEST", false) {
+		return nil
+	}
+	// The tag follows the last ':' after the last '/', so registry ports don't count.
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		tag = name[i+1:]
+	}
+	if tag == "" || tag == "latest" {
+		return fmt.Errorf("image %q must use an explicit tag other than latest, or a digest; SANDBOX_REJECT_LATEST is set", image)
+	}
+	return nil
+}
+
+// imageRepository returns image's repository with the registry host made explicit,
+// the way the runtime resolves it: a first component without '.' or ':' (other than
+// localhost) is a Docker Hub path, and single-name images live under library/.
+func imageRepository(image string) string {
+	name, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	host, rest, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, rest = "docker.io", name
+	}
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		host = "docker.io"
+	}
+	if host == "docker.io" && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	return host + "/" + rest
+}
+
+// imageFromRegistries reports whether image's repository is under one of prefixes.
+// A prefix is a registry host, optionally with a path ("registry.internal/team/");
+// it matches whole path components only.
+func imageFromRegistries(image string, prefixes []string) bool {
+	repo := imageRepository(image)
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if repo == prefix || strings.HasPrefix(repo, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedVolumeNames are the pod volumes the control plane adds itself.
+var reservedVolumeNames = []string{"cache", "workspace", "sbx-events"}
+
+func validateVolumes(vols []api.VolumeSpec, workspacePath, cachePath string) error {
+	reservedPaths := []string{cachePath, workspacePath, streamConfigFromEnv().eventsDir}
+	names := map[string]bool{}
+	var paths []string
+	for _, v := range vols {
+		if errs := validation.IsDNS1123Label(v.Name); len(errs) > 0 {
+			return fmt.Errorf("volume name %q is invalid: %s", v.Name, strings.Join(errs, "; "))
+		}
+		if containsString(reservedVolumeNames, v.Name) || names[v.Name] {
+			return fmt.Errorf("volume name %q is already in use", v.Name)
+		}
+		names[v.Name] = true
+		if !path.IsAbs(v.MountPath) || path.Clean(v.MountPath) == "/" {
+			return fmt.Errorf("volume %q: mount_path must be an absolute path below /", v.Name)
+		}
+		mountPath := path.Clean(v.MountPath)
+		for _, p := range reservedPaths {
+			if pathsOverlap(mountPath, p) {
+				return fmt.Errorf("volume %q: mount_path %s overlaps built-in mount %s", v.Name, mountPath, p)
+			}
+		}
+		for _, p := range paths {
+			if pathsOverlap(mountPath, p) {
+				return fmt.Errorf("volume %q: mount_path %s overlaps %s", v.Name, mountPath, p)
+			}
+		}
+		paths = append(paths, mountPath)
+		if strings.EqualFold(v.Type, "hostpath") {
+			return fmt.Errorf("volume %q: hostPath volumes are not allowed; type must be one of: %s", v.Name, strings.Join(allowedVolumeTypes, ", "))
+		}
+		switch v.Type {
+		case "emptydir":
+			if v.ClaimName != "" {
+				return fmt.Errorf("volume %q: claim_name is only valid for pvc volumes", v.Name)
+			}
+			if v.SizeLimit != "" {
+				if _, err := resource.ParseQuantity(v.SizeLimit); err != nil {
+					return fmt.Errorf("volume %q: size_limit is not a valid quantity: %v", v.Name, err)
+				}
+			}
+		case "pvc":
+			if errs := validation.IsDNS1123Subdomain(v.ClaimName); len(errs) > 0 {
+				return fmt.Errorf("volume %q: claim_name is invalid: %s", v.Name, strings.Join(errs, "; "))
+			}
+			if v.SizeLimit != "" {
+				return fmt.Errorf("volume %q: size_limit is only valid for emptydir volumes", v.Name)
+			}
+		default:
+			return fmt.Errorf("volume %q: type must be one of: %s", v.Name, strings.Join(allowedVolumeTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// pathsOverlap reports whether a and b are the same directory or one contains the other.
+func pathsOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// maxScriptBytes keeps the encoded script under the kernel's per-argument limit.
+const maxScriptBytes = 64 << 10
+
+var scriptShellPattern = regexp.MustCompile(`^[A-Za-z0-9_./+-]+$`)
+
+// execCommandFromRequest returns the argv to run for req, turning Shell into a bash
+// invocation and Script into a temp file run by ScriptShell.
+func execCommandFromRequest(req api.ExecRequest) ([]string, error) {
+	set := 0
+	for _, ok := range []bool{len(req.Command) > 0, req.Shell != "", req.Script != ""} {
+		if ok {
+			set++
+		}
+	}
+	switch {
+	case set > 1:
+		return nil, fmt.Errorf("command, shell and script are mutually exclusive")
+	case req.ScriptShell != "" && req.Script == "":
+		return nil, fmt.Errorf("script_shell requires script")
+	case (req.Shell != "" || req.Script != "") && execShell() == "":
+		return nil, fmt.Errorf("shell and script execs need a shell, and SANDBOX_EXEC_SHELL is none; pass command instead")
+	case req.Script != "":
+		return scriptCommand(req.Script, req.ScriptShell)
+	case req.Shell != "":
+		return shellCommand(execShell(), req.Shell), nil
+	case len(req.Command) > 0:
+		return req.Command, nil
+	default:
+		return nil, fmt.Errorf("command, shell or script is required")
+	}
+}
+
+// scriptCommand writes script to a temp file inside the sandbox and runs it with
+// shell, exiting with the script's exit code. The script travels base64-encoded so
+// its contents never need quoting.
+func scriptCommand(script, shell string) ([]string, error) {
+	if shell == "" {
+		shell = execShell()
+	}
+	if !scriptShellPattern.MatchString(shell) {
+		return nil, fmt.Errorf("script_shell must be an interpreter name or path, e.g. bash or /usr/bin/python3")
+	}
+	if len(script) > maxScriptBytes {
+		return nil, fmt.Errorf("script exceeds %d bytes", maxScriptBytes)
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(script))
+	return []string{"sh", "-c", fmt.Sprintf(
+		`f=$(mktemp) || exit 1; printf %%s %s | base64 -d > "$f" && %s "$f"; code=$?; rm -f "$f"; exit $code`,
+		encoded, shell)}, nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEnvSize checks each env value against SANDBOX_MAX_ENV_VALUE_BYTES and the
+// keys plus values together against SANDBOX_MAX_ENV_BYTES. Either limit is off at 0.
+func validateEnvSize(env map[string]string) error {
+	maxValue := getenvInt("SANDBOX_MAX_ENV_VALUE_BYTES", defaultMaxEnvValueBytes)
+	maxTotal := getenvInt("SANDBOX_MAX_ENV_BYTES", defaultMaxEnvBytes)
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	total := 0
+	largest := ""
+	for _, k := range keys {
+		v := env[k]
+		if maxValue > 0 && len(v) > maxValue {
+			return fmt.Errorf("env %s is %d bytes, over the %d byte limit for one value", k, len(v), maxValue)
+		}
+		total += len(k) + len(v)
+		if largest == "" || len(v) > len(env[largest]) {
+			largest = k
+		}
+	}
+	if maxTotal > 0 && total > maxTotal {
+		return fmt.Errorf("env is %d bytes in total, over the %d byte limit; the largest value is %s (%d bytes)", total, maxTotal, largest, len(env[largest]))
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+)
+
+func TestValidateCreateRequest(t *testing.T) {
+	t.Setenv("SANDBOX_ALLOW_POD_OVERLAY", "true")
+	shareOn := true
+	tests := []struct {
+		name    string
+		req     api.CreateSandboxRequest
+		wantErr string
+	}{
+		{name: "empty request uses defaults"},
+		{name: "valid fields", req: api.CreateSandboxRequest{
+			VolumeMode: "pvc", CacheMode: "pvc", CachePVCAccessMode: "rwx", CachePVCSize: "5Gi",
+		}},
+		{name: "image whitespace", req: api.CreateSandboxRequest{Image: " alpine"}, wantErr: "whitespace"},
+		{name: "unknown volume mode", req: api.CreateSandboxRequest{VolumeMode: "nfs"}, wantErr: "volume_mode"},
+		{name: "unknown cache mode", req: api.CreateSandboxRequest{CacheMode: "tmpfs"}, wantErr: "cache_mode"},
+		{name: "bad access mode", req: api.CreateSandboxRequest{CachePVCAccessMode: "rw"}, wantErr: "cache_pvc_access_mode"},
+		{name: "bad size", req: api.CreateSandboxRequest{CachePVCSize: "lots"}, wantErr: "cache_pvc_size"},
+		{name: "custom mount paths", req: api.CreateSandboxRequest{WorkspacePath: "/app", CachePath: "/home/user/.cache"}},
+		{name: "relative workspace path", req: api.CreateSandboxRequest{WorkspacePath: "app"}, wantErr: "workspace_path must be an absolute path"},
+		{name: "root cache path", req: api.CreateSandboxRequest{CachePath: "/"}, wantErr: "cache_path must be an absolute path"},
+		{name: "overlapping mount paths", req: api.CreateSandboxRequest{WorkspacePath: "/app", CachePath: "/app/cache"}, wantErr: "overlaps cache_path"},
+		{name: "volume under custom workspace", req: api.CreateSandboxRequest{
+			WorkspacePath: "/app",
+			Volumes:       []api.VolumeSpec{{Name: "data", Type: "emptydir", MountPath: "/app/data"}},
+		}, wantErr: "built-in mount /app"},
+		{name: "hostname and subdomain", req: api.CreateSandboxRequest{Hostname: "worker-0", Subdomain: "workers"}},
+		{name: "bad hostname", req: api.CreateSandboxRequest{Hostname: "Worker_0"}, wantErr: "hostname is invalid"},
+		{name: "bad subdomain", req: api.CreateSandboxRequest{Subdomain: "a.b"}, wantErr: "subdomain is invalid"},
+		{name: "share process namespace", req: api.CreateSandboxRequest{ShareProcessNamespace: &shareOn}},
+		{name: "metrics", req: api.CreateSandboxRequest{Metrics: &api.Metrics{Port: 9090}}},
+		{name: "metrics without port", req: api.CreateSandboxRequest{Metrics: &api.Metrics{Path: "/metrics"}}, wantErr: "metrics port"},
+		{name: "metrics port too high", req: api.CreateSandboxRequest{Metrics: &api.Metrics{Port: 70000}}, wantErr: "metrics port"},
+		{name: "relative metrics path", req: api.CreateSandboxRequest{Metrics: &api.Metrics{Port: 9090, Path: "metrics"}}, wantErr: "metrics path"},
+		{name: "share process namespace with hostPID", req: api.CreateSandboxRequest{
+			ShareProcessNamespace: &shareOn,
+			PodSpecOverlay:        json.RawMessage(`{"hostPID": true}`),
+		}, wantErr: "hostPID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCreateRequest(tt.req)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateEnvSize(t *testing.T) {
+	t.Setenv("SANDBOX_MAX_ENV_VALUE_BYTES", "100")
+	t.Setenv("SANDBOX_MAX_ENV_BYTES", "250")
+	small := strings.Repeat("x", 60)
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "within limits", env: map[string]string{"A": small, "B": small}},
+		{name: "oversized value", env: map[string]string{"A": small, "BLOB": strings.Repeat("x", 101)}, wantErr: "env BLOB is 101 bytes"},
+		{name: "oversized total", env: map[string]string{"A": small, "B": small, "C": small, "DD": strings.Repeat("x", 90)}, wantErr: "largest value is DD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCreateRequest(api.CreateSandboxRequest{Env: tt.env})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Setenv("SANDBOX_MAX_ENV_VALUE_BYTES", "0")
+	t.Setenv("SANDBOX_MAX_ENV_BYTES", "0")
+	if err := validateEnvSize(map[string]string{"BLOB": strings.Repeat("x", 1<<20)}); err != nil {
+		t.Fatalf("limits of 0 should be off: %v", err)
+	}
+}
+
+func TestValidateVolumes(t *testing.T) {
+	tests := []struct {
+		name    string
+		vols    []api.VolumeSpec
+		wantErr string
+	}{
+		{name: "emptydir and pvc", vols: []api.VolumeSpec{
+			{Name: "scratch", Type: "emptydir", MountPath: "/scratch", SizeLimit: "1Gi"},
+			{Name: "data", Type: "pvc", ClaimName: "dataset", MountPath: "/data/", ReadOnly: true},
+		}},
+		{name: "hostPath", vols: []api.VolumeSpec{{Name: "host", Type: "hostPath", MountPath: "/host"}}, wantErr: "hostPath volumes are not allowed"},
+		{name: "unknown type", vols: []api.VolumeSpec{{Name: "nfs", Type: "nfs", MountPath: "/nfs"}}, wantErr: "emptydir, pvc"},
+		{name: "reserved name", vols: []api.VolumeSpec{{Name: "cache", Type: "emptydir", MountPath: "/other"}}, wantErr: "already in use"},
+		{name: "duplicate name", vols: []api.VolumeSpec{
+			{Name: "a", Type: "emptydir", MountPath: "/a"},
+			{Name: "a", Type: "emptydir", MountPath: "/b"},
+		}, wantErr: "already in use"},
+		{name: "relative path", vols: []api.VolumeSpec{{Name: "a", Type: "emptydir", MountPath: "data"}}, wantErr: "absolute"},
+		{name: "root path", vols: []api.VolumeSpec{{Name: "a", Type: "emptydir", MountPath: "/"}}, wantErr: "absolute"},
+		{name: "under built-in mount", vols: []api.VolumeSpec{{Name: "a", Type: "emptydir", MountPath: "/workspace/data"}}, wantErr: "built-in mount /workspace"},
+		{name: "overlapping paths", vols: []api.VolumeSpec{
+			{Name: "a", Type: "emptydir", MountPath: "/data"},
+			{Name: "b", Type: "emptydir", MountPath: "/data/sub"},
+		}, wantErr: "overlaps /data"},
+		{name: "pvc without claim", vols: []api.VolumeSpec{{Name: "a", Type: "pvc", MountPath: "/a"}}, wantErr: "claim_name"},
+		{name: "claim on emptydir", vols: []api.VolumeSpec{{Name: "a", Type: "emptydir", ClaimName: "x", MountPath: "/a"}}, wantErr: "claim_name"},
+		{name: "bad size limit", vols: []api.VolumeSpec{{Name: "a", Type: "emptydir", SizeLimit: "big", MountPath: "/a"}}, wantErr: "size_limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCreateRequest(api.CreateSandboxRequest{Volumes: tt.vols})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecCommandFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     api.ExecRequest
+		want    []string
+		wantErr string
+	}{
+		{name: "argv", req: api.ExecRequest{Command: []string{"ls", "-la"}}, want: []string{"ls", "-la"}},
+		{name: "shell", req: api.ExecRequest{Shell: "ls | grep foo"}, want: []string{"bash", "-lc", "ls | grep foo"}},
+		{name: "both", req: api.ExecRequest{Command: []string{"ls"}, Shell: "ls"}, wantErr: "mutually exclusive"},
+		{name: "neither", req: api.ExecRequest{}, wantErr: "required"},
+		{name: "script and command", req: api.ExecRequest{Command: []string{"ls"}, Script: "ls"}, wantErr: "mutually exclusive"},
+		{name: "script shell without script", req: api.ExecRequest{Command: []string{"ls"}, ScriptShell: "python3"}, wantErr: "script_shell requires script"},
+		{name: "bad script shell", req: api.ExecRequest{Script: "ls", ScriptShell: "bash; rm -rf /"}, wantErr: "script_shell"},
+		{name: "script too large", req: api.ExecRequest{Script: strings.Repeat("x", maxScriptBytes+1)}, wantErr: "exceeds"},
+	}
+	for _, tt := range tests {
+		got, err := execCommandFromRequest(tt.req)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, "\x00") != strings.Join(tt.want, "\x00") {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestScriptCommandRunsScript(t *testing.T) {
+	// The generated command is plain sh, so it runs the same here as in a sandbox.
+	script := "set -e\necho 'first \"quoted\" $HOME'\nfalse\necho unreachable\n"
+	argv, err := execCommandFromRequest(api.ExecRequest{Script: script, ScriptShell: "bash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(argv[0], argv[1:]...).Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("err = %v, want exit status 1 from the failing line", err)
+	}
+	if got := string(out); got != "first \"quoted\" $HOME\n" {
+		t.Fatalf("output = %q, want only the first line, unexpanded", got)
+	}
+}
+
+func TestValidateImagePolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		requireDigest bool
+		rejectLatest  bool
+		image         string
+		wantErr       string
+	}{
+		{name: "policies off", image: "busybox"},
+		{name: "tagged", rejectLatest: true, image: "busybox:1.36"},
+		{name: "registry port with tag", rejectLatest: true, image: "registry.local:5000/tools/busybox:1.36"},
+		{name: "digest skips tag check", rejectLatest: true, image: "busybox@sha256:abc"},
+		{name: "untagged", rejectLatest: true, image: "busybox", wantErr: "SANDBOX_REJECT_LATEST"},
+		{name: "latest", rejectLatest: true, image: "busybox:latest", wantErr: "SANDBOX_REJECT_LATEST"},
+		{name: "registry port without tag", rejectLatest: true, image: "registry.local:5000/busybox", wantErr: "SANDBOX_REJECT_LATEST"},
+		{name: "digest required", requireDigest: true, image: "busybox:1.36", wantErr: "SANDBOX_REQUIRE_DIGEST"},
+		{name: "digest present", requireDigest: true, image: "busybox:1.36@sha256:abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SANDBOX_REQUIRE_DIGEST", strconv.FormatBool(tt.requireDigest))
+			t.Setenv("SANDBOX_REJECT_LATEST", strconv.FormatBool(tt.rejectLatest))
+			err := validateImagePolicy(tt.image)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	for image, want := range map[string]string{
+		"busybox":                         "docker.io/library/busybox",
+		"busybox:1.36":                    "docker.io/library/busybox",
+		"acme/tool@sha256:abc":            "docker.io/acme/tool",
+		"index.docker.io/library/busybox": "docker.io/library/busybox",
+		"registry.internal/team/app:1":    "registry.internal/team/app",
+		"registry.internal:5000/app":      "registry.internal:5000/app",
+		"localhost/app:dev":               "localhost/app",
+		"ghcr.io/acme/app:v1@sha256:abc":  "ghcr.io/acme/app",
+	} {
+		if got := imageRepository(image); got != want {
+			t.Errorf("imageRepository(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestValidateImagePolicyAllowedRegistries(t *testing.T) {
+	tests := []struct {
+		allowed string
+		image   string
+		ok      bool
+	}{
+		{allowed: "registry.internal/", image: "registry.internal/team/app:1", ok: true},
+		{allowed: "registry.internal/", image: "registry.internal.evil.com/app:1"},
+		{allowed: "registry.internal/", image: "busybox:1.36"},
+		{allowed: "registry.internal/,docker.io", image: "busybox:1.36", ok: true},
+		{allowed: "docker.io/library/", image: "acme/tool:1"},
+		{allowed: "ghcr.io/acme/", image: "ghcr.io/acme/app:v1", ok: true},
+		{allowed: "ghcr.io/acme/", image: "ghcr.io/acmecorp/app:v1"},
+	}
+	for _, tt := range tests {
+		t.Setenv("SANDBOX_ALLOWED_REGISTRIES", tt.allowed)
+		err := validateImagePolicy(tt.image)
+		if tt.ok && err != nil {
+			t.Errorf("%s with %s: %v", tt.image, tt.allowed, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), "SANDBOX_ALLOWED_REGISTRIES")) {
+			t.Errorf("%s with %s: err = %v, want a registry rejection", tt.image, tt.allowed, err)
+		}
+	}
+}
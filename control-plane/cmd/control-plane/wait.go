@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultWaitInterval = time.Second
+	defaultWaitTimeout  = 60 * time.Second
+	minWaitInterval     = 100 * time.Millisecond
+)
+
+// waitSandbox runs a probe command repeatedly until it exits 0 or the timeout elapses.
+// The response reports the last attempt's output; ready=false means the probe never passed.
+func (s *server) waitSandbox(c *gin.Context) {
+	id := c.Param("id")
+	var req api.WaitRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if len(req.Command) == 0 {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "command is required")
+		return
+	}
+	interval, timeout, err := parseWaitDurations(req.Interval, req.Timeout)
+	if err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkExecAllowlist(api.ExecRequest{Command: req.Command}, req.Command); err != nil {
+		writeErrorCode(c, 403, errCodeCommandNotAllowed, err.Error())
+		return
+	}
+	// The whole wait stands in for {timeout}; each attempt is cut short by it.
+	timeoutSeconds := int(math.Ceil(timeout.Seconds()))
+	probe := wrapExecCommand(req.Command, &timeoutSeconds)
+	c.Set(auditCommandKey, probe)
+
+	ns := id
+	podName := "sandbox"
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	if err := s.waitForPodReady(ctx, ns, podName); err != nil {
+		writeErrorCode(c, 409, errCodeSandboxNotReady, "sandbox not ready: "+err.Error())
+		return
+	}
+
+	command := syncExecCommand(probe)
+	var resp api.WaitResponse
+	for {
+		resp.Attempts++
+		resp.Stdout, resp.Stderr = "", ""
+		resp.ExitCode, resp.Error = 0, ""
+		// Each attempt takes an exec slot like any other exec. With the sandbox at
+		// SANDBOX_MAX_CONCURRENT_EXECS the attempt fails and is retried later.
+		var err error
+		if release, ok := s.execSlots.tryAcquire(ns); ok {
+			resp.Stdout, resp.Stderr, err = s.execCommand(ctx, ns, podName, "sandbox", command)
+			release()
+		} else {
+			err = fmt.Errorf("the sandbox is running its limit of %d execs (SANDBOX_MAX_CONCURRENT_EXECS)", s.execSlots.max)
+		}
+		if err == nil {
+			resp.Ready = true
+			break
+		}
+		if code, ok := exitCodeFromErr(err); ok {
+			resp.ExitCode = code
+		} else {
+			resp.ExitCode = -1
+			resp.Error = err.Error()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+		}
+		t.Stop()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if !resp.Ready && resp.Error == "" {
+		resp.Error = fmt.Sprintf("probe did not succeed within %s", timeout)
+	}
+	_ = s.updateLastExec(context.Background(), ns)
+	metricExecs.Add(int64(resp.Attempts))
+	writeJSON(c, 200, resp)
+}
+
+func parseWaitDurations(intervalStr, timeoutStr string) (time.Duration, time.Duration, error) {
+	interval := defaultWaitInterval
+	if intervalStr != "" {
+		d, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("interval: %v", err)
+		}
+		interval = d
+	}
+	if interval < minWaitInterval {
+		return 0, 0, fmt.Errorf("interval must be >= %s", minWaitInterval)
+	}
+	timeout := defaultWaitTimeout
+	if timeoutStr != "" {
+		d, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("timeout: %v", err)
+		}
+		timeout = d
+	}
+	if timeout <= 0 {
+		return 0, 0, fmt.Errorf("timeout must be > 0")
+	}
+	if maxTimeout := getenvDuration("SANDBOX_EXEC_MAX_TIMEOUT", 6*time.Hour); maxTimeout > 0 && timeout > maxTimeout {
+		return 0, 0, fmt.Errorf("timeout must be <= %s", maxTimeout)
+	}
+	return interval, timeout, nil
+}
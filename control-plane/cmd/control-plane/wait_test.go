@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sandbox/pkg/api"
+
+	"k8s.io/client-go/tools/remotecommand"
+	utilsexec "k8s.io/client-go/util/exec"
+)
+
+func TestWaitSandboxProbeSucceedsOnThirdAttempt(t *testing.T) {
+	s := newTestServer(readyPod("demo"))
+	calls := 0
+	s.podExec = func(_ context.Context, ns, pod, container string, cmd []string, opts remotecommand.StreamOptions) error {
+		calls++
+		if calls < 3 {
+			return utilsexec.CodeExitError{Err: errExit, Code: 7}
+		}
+		_, _ = opts.Stdout.Write([]byte("up\n"))
+		return nil
+	}
+	w := serve(s.waitSandbox, http.MethodPost, "/sandboxes/:id/wait", "/sandboxes/demo/wait",
+		api.WaitRequest{Command: []string{"curl", "-sf", "localhost:8080"}, Interval: "100ms", Timeout: "10s"})
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var resp api.WaitResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Ready || resp.Attempts != 3 || resp.Stdout != "up\n" || resp.ExitCode != 0 {
+		t.Fatalf("resp = %+v, want ready on attempt 3 with the last attempt's output", resp)
+	}
+}
+
+func TestWaitSandboxTimesOut(t *testing.T) {
+	s := newTestServer(readyPod("demo"))
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		return utilsexec.CodeExitError{Err: errExit, Code: 1}
+	}
+	w := serve(s.waitSandbox, http.MethodPost, "/sandboxes/:id/wait", "/sandboxes/demo/wait",
+		api.WaitRequest{Command: []string{"false"}, Interval: "100ms", Timeout: "1s"})
+	var resp api.WaitResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Ready || resp.ExitCode != 1 || resp.Error == "" {
+		t.Fatalf("resp = %+v, want not ready with the probe's exit code and an error", resp)
+	}
+}
+
+func TestWaitSandboxChecksAllowlist(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_ALLOWLIST", "curl")
+	s := newTestServer(readyPod("demo"))
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		t.Fatal("a refused probe was run")
+		return nil
+	}
+	w := serve(s.waitSandbox, http.MethodPost, "/sandboxes/:id/wait", "/sandboxes/demo/wait",
+		api.WaitRequest{Command: []string{"rm", "-rf", "/"}, Timeout: "1s"})
+	if w.Code != 403 || !strings.Contains(w.Body.String(), errCodeCommandNotAllowed) {
+		t.Fatalf("status %d: %s, want 403 %s", w.Code, w.Body, errCodeCommandNotAllowed)
+	}
+}
+
+func TestWaitSandboxAppliesExecWrapper(t *testing.T) {
+	t.Setenv("SANDBOX_EXEC_WRAPPER", "timeout {timeout}s {cmd}")
+	s := newTestServer(readyPod("demo"))
+	var ran []string
+	s.podExec = func(_ context.Context, _, _, _ string, cmd []string, _ remotecommand.StreamOptions) error {
+		ran = cmd
+		return nil
+	}
+	w := serve(s.waitSandbox, http.MethodPost, "/sandboxes/:id/wait", "/sandboxes/demo/wait",
+		api.WaitRequest{Command: []string{"true"}, Timeout: "90s"})
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if got := strings.Join(ran, " "); !strings.Contains(got, "timeout 90s true") {
+		t.Fatalf("ran %q, want the probe wrapped by SANDBOX_EXEC_WRAPPER", got)
+	}
+}
+
+func TestWaitSandboxTakesExecSlot(t *testing.T) {
+	s := newTestServer(readyPod("demo"))
+	s.execSlots = newExecLimiter(1, 0)
+	s.podExec = func(context.Context, string, string, string, []string, remotecommand.StreamOptions) error {
+		return nil
+	}
+	release, _ := s.execSlots.tryAcquire("demo")
+	w := serve(s.waitSandbox, http.MethodPost, "/sandboxes/:id/wait", "/sandboxes/demo/wait",
+		api.WaitRequest{Command: []string{"true"}, Interval: "100ms", Timeout: "300ms"})
+	var resp api.WaitResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Ready || !strings.Contains(resp.Error, "SANDBOX_MAX_CONCURRENT_EXECS") {
+		t.Fatalf("resp = %+v, want attempts refused while the sandbox is at its exec limit", resp)
+	}
+
+	release()
+	w = serve(s.waitSandbox, http.MethodPost, "/sandboxes/:id/wait", "/sandboxes/demo/wait",
+		api.WaitRequest{Command: []string{"true"}, Timeout: "1s"})
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Ready {
+		t.Fatalf("resp = %+v, want ready once a slot is free", resp)
+	}
+	if again, ok := s.execSlots.tryAcquire("demo"); !ok {
+		t.Error("the probe's slot wasn't released")
+	} else {
+		again()
+	}
+}
@@ -2,20 +2,45 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"sandbox/pkg/api"
+
+	"github.com/gin-gonic/gin"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
-	warmWindow     = 60 * time.Second
-	defaultIdleTTL = 15 * time.Minute
+	defaultWarmWindow     = 60 * time.Second
+	defaultIdleTTL        = 15 * time.Minute
+	warmCreateBaseBackoff = 5 * time.Second
+	warmCreateMaxBackoff  = 5 * time.Minute
+
+	defaultWarmVerifyTimeout = 5 * time.Second
+	defaultWarmSetupTimeout  = 10 * time.Minute
+
+	// warmSetupAnnotation is pending on a warm namespace until SANDBOX_WARM_SETUP
+	// has run in its pod, and done afterwards.
+	warmSetupAnnotation = "sbx.warm_setup"
+
+	defaultWarmUnhealthyAfter  = 5 * time.Minute
+	defaultWarmMaxCreateErrors = 5
+	// warmCreateErrorWindow is the span over which create errors count toward
+	// SANDBOX_WARM_MAX_CREATE_ERRORS.
+	warmCreateErrorWindow = 10 * time.Minute
 )
 
 type warmPoolConfig struct {
@@ -24,36 +49,187 @@ type warmPoolConfig struct {
 	max      int
 	autosize bool
 	idleTTL  time.Duration
+	window   time.Duration
+	// labels and annotations are added to warm namespaces and pods and removed again
+	// when a sandbox is claimed.
+	labels      map[string]string
+	annotations map[string]string
+	// verify runs a no-op exec in a candidate before claiming it, bounded by
+	// verifyTimeout.
+	verify        bool
+	verifyTimeout time.Duration
+	// setup is a shell command run in each new warm pod once it is Ready; the
+	// namespace can't be claimed until it has succeeded.
+	setup        string
+	setupTimeout time.Duration
+	// The pool reports unhealthy once ready has been below desired for longer than
+	// unhealthyAfter, or when more than maxCreateErrors creates failed within
+	// warmCreateErrorWindow.
+	unhealthyAfter  time.Duration
+	maxCreateErrors int
 }
 
 type warmPool struct {
-	client *kubernetes.Clientset
-	cfg    warmPoolConfig
-	cache  cacheConfig
+	client     kubernetes.Interface
+	namespaces *namespaceCache
+	pods       *warmPodCache
+	// kick wakes the reconcile loop before the next tick.
+	kick chan struct{}
+	// probe runs a command in a warm pod; used when cfg.verify or cfg.setup is set.
+	probe func(ctx context.Context, ns string, cmd []string) error
+	cfg   warmPoolConfig
+	cache cacheConfig
+	// mu guards recent and the size, min and max fields of cfg, which can be
+	// overridden at runtime by resize.
 	mu     sync.Mutex
 	next   int
 	recent []time.Time
+
+	startOnce sync.Once
+	// settingUp holds the namespaces whose setup is running, guarded by mu.
+	settingUp map[string]bool
+
+	createFailures int
+	// setupFailures counts consecutive SANDBOX_WARM_SETUP failures; like failed
+	// creates they push nextCreate back, so a setup that always fails doesn't
+	// delete and recreate namespaces on every tick.
+	setupFailures int
+	nextCreate    time.Time
+
+	// Health inputs, guarded by mu: the last observed ready and desired counts,
+	// when ready first dropped below desired, and recent create error times.
+	ready        int
+	desired      int
+	belowSince   time.Time
+	createErrors []time.Time
 }
 
 func warmPoolConfigFromEnv() warmPoolConfig {
 	cfg := warmPoolConfig{
-		size:     getenvInt("SANDBOX_WARM_POOL_SIZE", 0),
-		min:      getenvInt("SANDBOX_WARM_POOL_MIN", 0),
-		max:      getenvInt("SANDBOX_WARM_POOL_MAX", 0),
-		autosize: getenvBool("SANDBOX_WARM_POOL_AUTOSIZE", false),
-		idleTTL:  getenvDuration("SANDBOX_IDLE_TTL", defaultIdleTTL),
+		size:          getenvInt("SANDBOX_WARM_POOL_SIZE", 0),
+		min:           getenvInt("SANDBOX_WARM_POOL_MIN", 0),
+		max:           getenvInt("SANDBOX_WARM_POOL_MAX", 0),
+		autosize:      getenvBool("SANDBOX_WARM_POOL_AUTOSIZE", false),
+		idleTTL:       getenvDuration("SANDBOX_IDLE_TTL", defaultIdleTTL),
+		window:        getenvDuration("SANDBOX_WARM_WINDOW", defaultWarmWindow),
+		labels:        warmMetadata("SANDBOX_WARM_LABELS"),
+		annotations:   warmMetadata("SANDBOX_WARM_ANNOTATIONS"),
+		verify:        getenvBool("SANDBOX_WARM_VERIFY", false),
+		verifyTimeout: getenvDuration("SANDBOX_WARM_VERIFY_TIMEOUT", defaultWarmVerifyTimeout),
+		setup:         getenv("SANDBOX_WARM_SETUP", ""),
+		setupTimeout:  getenvDuration("SANDBOX_WARM_SETUP_TIMEOUT", defaultWarmSetupTimeout),
+
+		unhealthyAfter:  getenvDuration("SANDBOX_WARM_UNHEALTHY_AFTER", defaultWarmUnhealthyAfter),
+		maxCreateErrors: getenvInt("SANDBOX_WARM_MAX_CREATE_ERRORS", defaultWarmMaxCreateErrors),
+	}
+	if cfg.unhealthyAfter <= 0 {
+		cfg.unhealthyAfter = defaultWarmUnhealthyAfter
+	}
+	if cfg.maxCreateErrors <= 0 {
+		cfg.maxCreateErrors = defaultWarmMaxCreateErrors
+	}
+	if cfg.verifyTimeout <= 0 {
+		cfg.verifyTimeout = defaultWarmVerifyTimeout
+	}
+	if cfg.setupTimeout <= 0 {
+		cfg.setupTimeout = defaultWarmSetupTimeout
 	}
 	if cfg.autosize && cfg.max == 0 {
 		cfg.max = 10
 	}
+	if cfg.window <= 0 {
+		log.Printf("SANDBOX_WARM_WINDOW must be positive, using %s", defaultWarmWindow)
+		cfg.window = defaultWarmWindow
+	}
 	return cfg
 }
 
-func newWarmPool(client *kubernetes.Clientset, cfg warmPoolConfig, cacheCfg cacheConfig) *warmPool {
-	return &warmPool{
-		client: client,
-		cfg:    cfg,
-		cache:  cacheCfg,
+// warmMetadata parses a "key=value,key=value" setting. Keys under the sbx. prefix are
+// reserved for the control plane and dropped.
+func warmMetadata(key string) map[string]string {
+	out := map[string]string{}
+	for _, pair := range splitCSV(getenv(key, "")) {
+		k, v, _ := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if k == "" || strings.HasPrefix(k, "sbx.") {
+			log.Printf("%s: ignoring entry %q", key, pair)
+			continue
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out
+}
+
+// validateWarmMetadata checks a warmMetadata setting at startup, so a bad entry
+// doesn't fail every namespace or pod create later. Keys must be qualified names;
+// label values must also be valid label values.
+func validateWarmMetadata(key string, isLabel bool) error {
+	for k, v := range warmMetadata(key) {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("%s: key %q: %s", key, k, strings.Join(errs, "; "))
+		}
+		if !isLabel {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("%s: value %q of %s: %s", key, v, k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// withWarmMetadata returns base plus extra; base wins on conflicts.
+func withWarmMetadata(base, extra map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range extra {
+		out[k] = v
+	}
+	for k, v := range base {
+		out[k] = v
+	}
+	return out
+}
+
+// stripWarmMetadata removes the configured warm labels and annotations from meta and
+// reports whether anything changed.
+func (w *warmPool) stripWarmMetadata(meta *metav1.ObjectMeta) bool {
+	changed := false
+	for k := range w.cfg.labels {
+		if _, ok := meta.Labels[k]; ok {
+			delete(meta.Labels, k)
+			changed = true
+		}
+	}
+	for k := range w.cfg.annotations {
+		if _, ok := meta.Annotations[k]; ok {
+			delete(meta.Annotations, k)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func newWarmPool(client kubernetes.Interface, namespaces *namespaceCache, pods *warmPodCache, cfg warmPoolConfig, cacheCfg cacheConfig) *warmPool {
+	w := &warmPool{
+		client:     client,
+		namespaces: namespaces,
+		pods:       pods,
+		kick:       make(chan struct{}, 1),
+		cfg:        cfg,
+		settingUp:  map[string]bool{},
+		cache:      cacheCfg,
+	}
+	// Refresh the ready count as soon as a warm pod comes up rather than on the next tick.
+	pods.onReady(func(string) { w.reconcileSoon() })
+	metricWarmPoolHealthy.Set(1)
+	return w
+}
+
+// reconcileSoon asks the run loop to reconcile now. Requests coalesce.
+func (w *warmPool) reconcileSoon() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
 	}
 }
 
@@ -61,6 +237,8 @@ func (w *warmPool) enabled() bool {
 	if w == nil {
 		return false
 	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if w.cfg.autosize {
 		return w.cfg.max > 0 || w.cfg.min > 0
 	}
@@ -81,11 +259,11 @@ func (w *warmPool) desiredSize() int {
 	if w == nil {
 		return 0
 	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if !w.cfg.autosize {
 		return w.cfg.size
 	}
-	w.mu.Lock()
-	defer w.mu.Unlock()
 	now := time.Now()
 	w.pruneLocked(now)
 	desired := len(w.recent)
@@ -99,7 +277,7 @@ func (w *warmPool) desiredSize() int {
 }
 
 func (w *warmPool) pruneLocked(now time.Time) {
-	cut := now.Add(-warmWindow)
+	cut := now.Add(-w.cfg.window)
 	idx := 0
 	for _, t := range w.recent {
 		if t.After(cut) {
@@ -110,7 +288,15 @@ func (w *warmPool) pruneLocked(now time.Time) {
 	w.recent = w.recent[:idx]
 }
 
+// start launches the reconcile loop unless it is already running.
+func (w *warmPool) start(ctx context.Context, image string) {
+	w.startOnce.Do(func() {
+		go w.run(ctx, image)
+	})
+}
+
 func (w *warmPool) run(ctx context.Context, image string) {
+	w.pods.start(ctx)
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 	for {
@@ -120,6 +306,8 @@ func (w *warmPool) run(ctx context.Context, image string) {
 		case <-ticker.C:
 			_ = w.ensureWarmNamespaces(ctx, image)
 			_ = w.reapIdle(ctx)
+		case <-w.kick:
+			_ = w.ensureWarmNamespaces(ctx, image)
 		}
 	}
 }
@@ -128,13 +316,18 @@ func (w *warmPool) rebuildFromCluster(ctx context.Context, image string) error {
 	if w == nil {
 		return nil
 	}
-	if err := w.ensureWarmNamespaces(ctx, image); err != nil {
-		return err
-	}
-	if !w.cfg.autosize {
-		return nil
+	if w.cfg.autosize {
+		if err := w.rebuildRecent(ctx); err != nil {
+			return err
+		}
 	}
-	nsList, err := w.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	return w.ensureWarmNamespaces(ctx, image)
+}
+
+// rebuildRecent restores the autosize demand window from the sbx.created_at
+// annotations written on every create, including warm claims.
+func (w *warmPool) rebuildRecent(ctx context.Context) error {
+	namespaces, err := w.namespaces.list(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -142,17 +335,17 @@ func (w *warmPool) rebuildFromCluster(ctx context.Context, image string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	w.recent = w.recent[:0]
-	for _, ns := range nsList.Items {
-		last := ns.Annotations["sbx.last_exec_at"]
-		if last == "" {
+	for _, ns := range namespaces {
+		created := ns.Annotations["sbx.created_at"]
+		if created == "" {
 			continue
 		}
-		lastUnix, err := strconv.ParseInt(last, 10, 64)
+		createdUnix, err := strconv.ParseInt(created, 10, 64)
 		if err != nil {
 			continue
 		}
-		t := time.Unix(lastUnix, 0)
-		if now.Sub(t) <= warmWindow {
+		t := time.Unix(createdUnix, 0)
+		if now.Sub(t) <= w.cfg.window {
 			w.recent = append(w.recent, t)
 		}
 	}
@@ -164,17 +357,15 @@ func (w *warmPool) claimWarmNamespace(ctx context.Context) (string, bool, error)
 	selector := labels.SelectorFromSet(map[string]string{
 		"sbx.allocated": "false",
 	})
-	nsList, err := w.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	candidates, err := w.namespaces.list(ctx, selector)
 	if err != nil {
 		return "", false, err
 	}
-	if len(nsList.Items) == 0 {
-		return "", false, nil
-	}
-	sort.Slice(nsList.Items, func(i, j int) bool { return nsList.Items[i].Name < nsList.Items[j].Name })
-	for _, candidate := range nsList.Items {
-		ready, err := w.isPodReady(ctx, candidate.Name, "sandbox")
-		if err != nil || !ready {
+	for _, candidate := range candidates {
+		if !w.warmReady(ctx, &candidate) {
+			continue
+		}
+		if !w.verifyCandidate(ctx, &candidate) {
 			continue
 		}
 		if candidate.Labels == nil {
@@ -185,86 +376,403 @@ func (w *warmPool) claimWarmNamespace(ctx context.Context) (string, bool, error)
 		}
 		candidate.Labels["sbx.allocated"] = "true"
 		candidate.Annotations["sbx.last_exec_at"] = strconv.FormatInt(time.Now().Unix(), 10)
+		// Claimed sandboxes are user workloads, not warm overhead.
+		w.stripWarmMetadata(&candidate.ObjectMeta)
 		_, err = w.client.CoreV1().Namespaces().Update(ctx, &candidate, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			// Claimed by a concurrent create or the cached copy was stale.
+			continue
+		}
 		if err != nil {
 			return "", false, err
 		}
+		w.stripWarmPodMetadata(ctx, candidate.Name)
 		return candidate.Name, true, nil
 	}
 	return "", false, nil
 }
 
-func (w *warmPool) ensureWarmNamespaces(ctx context.Context, image string) error {
-	desired := w.desiredSize()
-	readySelector := labels.SelectorFromSet(map[string]string{
+// readyWarmNamespace returns an unclaimed warm namespace whose pod is ready, the one
+// a claim would most likely take, without claiming it.
+func (w *warmPool) readyWarmNamespace(ctx context.Context) (string, bool, error) {
+	selector := labels.SelectorFromSet(map[string]string{
 		"sbx.allocated": "false",
 	})
-	readyList, err := w.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: readySelector.String()})
+	candidates, err := w.namespaces.list(ctx, selector)
+	if err != nil {
+		return "", false, err
+	}
+	for _, candidate := range candidates {
+		if w.warmReady(ctx, &candidate) {
+			return candidate.Name, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// verifyCandidate checks that a ready warm pod can actually run a command. A
+// candidate that fails is deleted so the pool replaces it.
+func (w *warmPool) verifyCandidate(ctx context.Context, ns *corev1.Namespace) bool {
+	if !w.cfg.verify || w.probe == nil {
+		return true
+	}
+	verifyCtx, cancel := context.WithTimeout(ctx, w.cfg.verifyTimeout)
+	defer cancel()
+	err := w.probe(verifyCtx, ns.Name, []string{"true"})
 	if err == nil {
-		metricWarmPoolReady.Set(int64(len(readyList.Items)))
+		return true
+	}
+	if ctx.Err() != nil {
+		// The caller gave up; the candidate may be fine.
+		return false
 	}
+	log.Printf("warm pool verify failed namespace=%s: %v", ns.Name, err)
+	metricWarmVerifyFailures.Add(1)
+	// Only delete if nobody has claimed it since we listed it.
+	err = w.client.CoreV1().Namespaces().Delete(context.Background(), ns.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &ns.UID, ResourceVersion: &ns.ResourceVersion},
+	})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		log.Printf("warm pool delete %s: %v", ns.Name, err)
+	}
+	w.reconcileSoon()
+	return false
+}
+
+// stripWarmPodMetadata clears the configured warm labels and annotations from a
+// claimed pod. Failures are logged; the pod keeps working either way.
+func (w *warmPool) stripWarmPodMetadata(ctx context.Context, ns string) {
+	if len(w.cfg.labels) == 0 && len(w.cfg.annotations) == 0 {
+		return
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := w.client.CoreV1().Pods(ns).Get(ctx, "sandbox", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !w.stripWarmMetadata(&pod.ObjectMeta) {
+			return nil
+		}
+		_, err = w.client.CoreV1().Pods(ns).Update(ctx, pod, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log.Printf("warm pool: clear warm metadata on %s: %v", ns, err)
+	}
+}
+
+func (w *warmPool) ensureWarmNamespaces(ctx context.Context, image string) error {
+	desired := w.desiredSize()
 	metricWarmPoolDesired.Set(int64(desired))
 	selector := labels.SelectorFromSet(map[string]string{"sbx.allocated": "false"})
-	nsList, err := w.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	namespaces, err := w.namespaces.list(ctx, selector)
 	if err != nil {
 		return err
 	}
-	if len(nsList.Items) >= desired {
+	// Namespaces already being deleted are neither pool capacity nor excess; counting
+	// them would trim live namespaces again on every tick until they are gone.
+	live := liveNamespaces(namespaces)
+	ready := 0
+	for _, ns := range live {
+		if w.warmReady(ctx, &ns) {
+			ready++
+		} else if ns.Annotations[warmSetupAnnotation] == "pending" {
+			if ok, _ := w.isPodReady(ctx, ns.Name, "sandbox"); ok {
+				w.startSetup(ns)
+			}
+		}
+	}
+	metricWarmPoolReady.Set(int64(ready))
+	w.observeReady(time.Now(), ready, desired)
+	if len(live) > desired {
+		return w.trimExcess(ctx, live, desired)
+	}
+	now := time.Now()
+	if !w.createAllowed(now) {
 		return nil
 	}
-	for i := len(nsList.Items); i < desired; i++ {
+	if len(live) < desired {
+		// The cache may not have seen the previous tick's creates yet; confirm the
+		// shortfall against the API server before creating more.
+		namespaces, err = w.namespaces.listLive(ctx, selector)
+		if err != nil {
+			return err
+		}
+		live = liveNamespaces(namespaces)
+	}
+	var errs []error
+	// Repair slots whose pod create failed on an earlier tick.
+	for _, ns := range live {
+		_, err := w.pods.get(ctx, ns.Name, "sandbox")
+		if apierrors.IsNotFound(err) {
+			err = w.createWarmPod(ctx, ns.Name, image)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", ns.Name, err))
+		}
+	}
+	for i := len(live); i < desired; i++ {
 		name := sandboxNamespace(generateID())
+		annotations := map[string]string{"sbx.last_exec_at": "0"}
+		if w.cfg.setup != "" {
+			annotations[warmSetupAnnotation] = "pending"
+		}
 		ns := &corev1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: name,
-				Labels: map[string]string{
+				Labels: withWarmMetadata(map[string]string{
 					"sbx.allocated": "false",
-				},
-				Annotations: map[string]string{
-					"sbx.last_exec_at": "0",
-				},
+				}, w.cfg.labels),
+				Annotations: withWarmMetadata(annotations, w.cfg.annotations),
 			},
 		}
-		_, _ = w.client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
-		_ = ensureCachePVC(ctx, w.client, name, "cache", w.cache)
-		envVars := defaultSandboxEnv()
-		allowed, disallowed := configAllowedHosts()
-		if len(allowed) == 0 {
-			allowed = splitCSV(getenv("SANDBOX_ALLOWED_HOSTS", ""))
+		if _, err := w.client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", name, err))
+			continue
 		}
-		if len(disallowed) == 0 {
-			disallowed = splitCSV(getenv("SANDBOX_DISALLOWED_HOSTS", ""))
+		if err := ensureNamespacePolicies(ctx, w.client, name); err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", name, err))
+			continue
 		}
-		if len(allowed) > 0 {
-			envVars["SBX_ALLOWED_HOSTS"] = joinCSV(allowed)
+		if err := w.createWarmPod(ctx, name, image); err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", name, err))
 		}
-		if len(disallowed) > 0 {
-			envVars["SBX_DISALLOWED_HOSTS"] = joinCSV(disallowed)
+	}
+	err = errors.Join(errs...)
+	w.recordCreateResult(now, len(errs), err)
+	return err
+}
+
+func (w *warmPool) createWarmPod(ctx context.Context, ns, image string) error {
+	if err := ensureCachePVC(ctx, w.client, ns, "cache", w.cache); err != nil {
+		return fmt.Errorf("cache pvc: %w", err)
+	}
+	podCfg := podConfigFromEnv()
+	if err := ensureServiceAccount(ctx, w.client, ns, podCfg.serviceAccountName); err != nil {
+		return fmt.Errorf("service account: %w", err)
+	}
+	envVars := defaultSandboxEnv()
+	allowed, disallowed := normalizeAllowedHosts(api.CreateSandboxRequest{})
+	if len(allowed) > 0 {
+		envVars["SBX_ALLOWED_HOSTS"] = joinCSV(allowed)
+	}
+	if len(disallowed) > 0 {
+		envVars["SBX_DISALLOWED_HOSTS"] = joinCSV(disallowed)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "sandbox",
+			Labels: withWarmMetadata(map[string]string{
+				"sbx.warm":      "true",
+				sandboxPodLabel: "true",
+			}, w.cfg.labels),
+			Annotations: withWarmMetadata(nil, w.cfg.annotations),
+		},
+		Spec: sandboxPodSpec(image, []string{"sleep", "infinity"}, "emptydir", "", w.cache, mapToEnvVars(envVars), podCfg),
+	}
+	_, err := w.client.CoreV1().Pods(ns).Create(ctx, pod, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("pod: %w", err)
+	}
+	return nil
+}
+
+// createAllowed reports whether the backoff after failed warm creates has elapsed.
+func (w *warmPool) createAllowed(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !now.Before(w.nextCreate)
+}
+
+// recordCreateResult updates the create backoff: each consecutive failing tick doubles
+// the delay before the next attempt, up to warmCreateMaxBackoff.
+func (w *warmPool) recordCreateResult(now time.Time, failed int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil {
+		w.createFailures = 0
+		w.nextCreate = time.Time{}
+		return
+	}
+	metricWarmPoolCreateErrors.Add(int64(failed))
+	for i := 0; i < failed; i++ {
+		w.createErrors = append(w.createErrors, now)
+	}
+	w.updateHealthMetricLocked(now)
+	w.createFailures++
+	backoff := warmCreateBackoff(w.createFailures)
+	w.nextCreate = now.Add(backoff)
+	log.Printf("warm pool create failed attempt=%d retry_in=%s err=%v", w.createFailures, backoff, err)
+}
+
+// recordSetupResult updates the create backoff after a warm setup run. Failures
+// count as create errors for the pool's health, and each consecutive one doubles
+// the delay before the replacement namespace is created.
+func (w *warmPool) recordSetupResult(now time.Time, ns string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil {
+		w.setupFailures = 0
+		return
+	}
+	metricWarmPoolCreateErrors.Add(1)
+	w.createErrors = append(w.createErrors, now)
+	w.updateHealthMetricLocked(now)
+	w.setupFailures++
+	backoff := warmCreateBackoff(w.setupFailures)
+	if next := now.Add(backoff); next.After(w.nextCreate) {
+		w.nextCreate = next
+	}
+	log.Printf("warm pool setup failed namespace=%s attempt=%d retry_in=%s: %v", ns, w.setupFailures, backoff, err)
+}
+
+// warmCreateBackoff is the delay after the given number of consecutive failures:
+// warmCreateBaseBackoff doubled each time, up to warmCreateMaxBackoff.
+func warmCreateBackoff(failures int) time.Duration {
+	backoff := warmCreateBaseBackoff << (failures - 1)
+	if backoff > warmCreateMaxBackoff || backoff <= 0 {
+		backoff = warmCreateMaxBackoff
+	}
+	return backoff
+}
+
+// observeReady records the ready and desired counts from a reconcile pass and
+// refreshes the warm_pool_healthy metric.
+func (w *warmPool) observeReady(now time.Time, ready, desired int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ready, w.desired = ready, desired
+	if ready >= desired {
+		w.belowSince = time.Time{}
+	} else if w.belowSince.IsZero() {
+		w.belowSince = now
+	}
+	w.updateHealthMetricLocked(now)
+}
+
+// health reports whether the pool is keeping up and, if not, why. A disabled pool
+// is healthy.
+func (w *warmPool) health(now time.Time) (bool, string) {
+	if w == nil {
+		return true, ""
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.healthLocked(now)
+}
+
+func (w *warmPool) healthLocked(now time.Time) (bool, string) {
+	cut := now.Add(-warmCreateErrorWindow)
+	idx := 0
+	for _, t := range w.createErrors {
+		if t.After(cut) {
+			w.createErrors[idx] = t
+			idx++
 		}
-		pod := &corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: "sandbox",
-				Labels: map[string]string{
-					"sbx.warm": "true",
-				},
-			},
-			Spec: sandboxPodSpec(image, []string{"sleep", "infinity"}, "emptydir", "", w.cache, mapToEnvVars(envVars)),
+	}
+	w.createErrors = w.createErrors[:idx]
+	if n := len(w.createErrors); n > w.cfg.maxCreateErrors {
+		return false, fmt.Sprintf("%d create errors in the last %s (limit %d)", n, warmCreateErrorWindow, w.cfg.maxCreateErrors)
+	}
+	if !w.belowSince.IsZero() {
+		if below := now.Sub(w.belowSince); below > w.cfg.unhealthyAfter {
+			return false, fmt.Sprintf("ready %d below desired %d for %s", w.ready, w.desired, below.Truncate(time.Second))
 		}
-		_, _ = w.client.CoreV1().Pods(name).Create(ctx, pod, metav1.CreateOptions{})
 	}
-	return nil
+	return true, ""
+}
+
+func (w *warmPool) updateHealthMetricLocked(now time.Time) {
+	healthy, _ := w.healthLocked(now)
+	if healthy {
+		metricWarmPoolHealthy.Set(1)
+	} else {
+		metricWarmPoolHealthy.Set(0)
+	}
+}
+
+// trimExcess deletes unclaimed warm namespaces beyond desired, preferring ones whose
+// pod is not ready yet. Claimed namespaces are never selected (they carry
+// sbx.allocated=true), and each delete is preconditioned on the listed resource version
+// so a namespace claimed since the list is left alone.
+func (w *warmPool) trimExcess(ctx context.Context, unclaimed []corev1.Namespace, desired int) error {
+	type candidate struct {
+		ns    corev1.Namespace
+		ready bool
+	}
+	candidates := make([]candidate, 0, len(unclaimed))
+	for _, ns := range unclaimed {
+		if ns.Labels["sbx.allocated"] != "false" || ns.DeletionTimestamp != nil {
+			continue
+		}
+		ready, _ := w.isPodReady(ctx, ns.Name, "sandbox")
+		candidates = append(candidates, candidate{ns: ns, ready: ready})
+	}
+	excess := len(candidates) - desired
+	if excess <= 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].ready != candidates[j].ready {
+			return !candidates[i].ready
+		}
+		return candidates[i].ns.Name > candidates[j].ns.Name
+	})
+	var firstErr error
+	for _, cand := range candidates {
+		if excess == 0 {
+			break
+		}
+		rv := cand.ns.ResourceVersion
+		err := w.client.CoreV1().Namespaces().Delete(ctx, cand.ns.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &rv},
+		})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("warm pool trimmed namespace=%s desired=%d", cand.ns.Name, desired)
+		excess--
+	}
+	return firstErr
+}
+
+// liveNamespaces returns the namespaces that aren't being deleted.
+func liveNamespaces(items []corev1.Namespace) []corev1.Namespace {
+	live := make([]corev1.Namespace, 0, len(items))
+	for _, ns := range items {
+		if ns.DeletionTimestamp == nil {
+			live = append(live, ns)
+		}
+	}
+	return live
+}
+
+// cleanupDisabled removes leftover unclaimed warm namespaces when the pool is disabled.
+func (w *warmPool) cleanupDisabled(ctx context.Context) error {
+	selector := labels.SelectorFromSet(map[string]string{"sbx.allocated": "false"})
+	namespaces, err := w.namespaces.list(ctx, selector)
+	if err != nil {
+		return err
+	}
+	return w.trimExcess(ctx, namespaces, 0)
 }
 
 func (w *warmPool) reapIdle(ctx context.Context) error {
 	selector := labels.SelectorFromSet(map[string]string{
 		"sbx.allocated": "true",
 	})
-	nsList, err := w.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	namespaces, err := w.namespaces.list(ctx, selector)
 	if err != nil {
 		return err
 	}
 	now := time.Now()
-	for _, ns := range nsList.Items {
+	for _, ns := range namespaces {
 		last := ns.Annotations["sbx.last_exec_at"]
 		if last == "" {
 			continue
@@ -283,18 +791,220 @@ func (w *warmPool) reapIdle(ctx context.Context) error {
 	return nil
 }
 
+// warmReady reports whether a warm namespace can be claimed: its pod is Ready and
+// SANDBOX_WARM_SETUP, if it was configured when the namespace was created, has
+// finished.
+func (w *warmPool) warmReady(ctx context.Context, ns *corev1.Namespace) bool {
+	if ns.Annotations[warmSetupAnnotation] == "pending" {
+		return false
+	}
+	ready, err := w.isPodReady(ctx, ns.Name, "sandbox")
+	return err == nil && ready
+}
+
+// startSetup runs SANDBOX_WARM_SETUP in the ready pod of ns unless it is already
+// running there.
+func (w *warmPool) startSetup(ns corev1.Namespace) {
+	if w.probe == nil || w.cfg.setup == "" {
+		return
+	}
+	w.mu.Lock()
+	if w.settingUp[ns.Name] {
+		w.mu.Unlock()
+		return
+	}
+	w.settingUp[ns.Name] = true
+	w.mu.Unlock()
+	go w.runSetup(ns)
+}
+
+// runSetup runs the setup command and marks the namespace done, so the next
+// reconcile counts it as ready. A namespace whose setup fails is deleted and
+// replaced once the create backoff allows.
+func (w *warmPool) runSetup(ns corev1.Namespace) {
+	defer func() {
+		w.mu.Lock()
+		delete(w.settingUp, ns.Name)
+		w.mu.Unlock()
+		w.reconcileSoon()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.setupTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := w.probe(ctx, ns.Name, shellCommand(execShell(), w.cfg.setup)); err != nil {
+		w.recordSetupResult(time.Now(), ns.Name, err)
+		err = w.client.CoreV1().Namespaces().Delete(context.Background(), ns.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &ns.UID},
+		})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			log.Printf("warm pool delete %s: %v", ns.Name, err)
+		}
+		return
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := w.client.CoreV1().Namespaces().Get(context.Background(), ns.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.Annotations == nil {
+			current.Annotations = map[string]string{}
+		}
+		current.Annotations[warmSetupAnnotation] = "done"
+		_, err = w.client.CoreV1().Namespaces().Update(context.Background(), current, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log.Printf("warm pool setup namespace=%s: mark done: %v", ns.Name, err)
+		return
+	}
+	w.recordSetupResult(time.Now(), ns.Name, nil)
+	log.Printf("warm pool setup done namespace=%s duration=%s", ns.Name, time.Since(start).Truncate(time.Millisecond))
+}
+
 func (w *warmPool) isPodReady(ctx context.Context, ns, name string) (bool, error) {
-	pod, err := w.client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	pod, err := w.pods.get(ctx, ns, name)
 	if err != nil {
 		return false, err
 	}
-	if pod.Status.Phase != corev1.PodRunning {
-		return false, nil
+	return podReady(pod), nil
+}
+
+// resize overrides the configured pool size bounds until the process restarts.
+// Nil fields keep their current value. Bounds that would put min above max are
+// rejected and nothing changes.
+func (w *warmPool) resize(size, min, max *int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	newMin, newMax := w.cfg.min, w.cfg.max
+	if min != nil {
+		newMin = *min
+	}
+	if max != nil {
+		newMax = *max
+	}
+	if err := validateWarmPoolBounds(newMin, newMax); err != nil {
+		return err
+	}
+	if size != nil {
+		w.cfg.size = *size
+	}
+	w.cfg.min, w.cfg.max = newMin, newMax
+	log.Printf("warm pool resized autosize=%t size=%d min=%d max=%d", w.cfg.autosize, w.cfg.size, w.cfg.min, w.cfg.max)
+	return nil
+}
+
+// validateWarmPoolBounds rejects an autosize min above max; a max of 0 means no
+// upper bound.
+func validateWarmPoolBounds(min, max int) error {
+	if max > 0 && min > max {
+		return fmt.Errorf("warm pool min %d is above max %d", min, max)
 	}
-	for _, cond := range pod.Status.Conditions {
-		if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
-			return true, nil
+	return nil
+}
+
+func (s *server) getWarmPool(c *gin.Context) {
+	resp := api.WarmPoolStatus{
+		Enabled: s.warm.enabled(),
+		Healthy: true,
+	}
+	if s.warm != nil {
+		now := time.Now()
+		resp.Desired = s.warm.desiredSize()
+		resp.Healthy, resp.Reason = s.warm.health(now)
+		s.warm.mu.Lock()
+		resp.Ready = s.warm.ready
+		resp.Autosize = s.warm.cfg.autosize
+		if !s.warm.belowSince.IsZero() {
+			resp.BelowDesiredSince = s.warm.belowSince.UTC().Format(time.RFC3339)
+		}
+		resp.RecentCreateErrors = len(s.warm.createErrors)
+		s.warm.mu.Unlock()
+	}
+	writeJSON(c, 200, resp)
+}
+
+func (s *server) resizeWarmPool(c *gin.Context) {
+	var req api.WarmPoolResizeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Size == nil && req.Min == nil && req.Max == nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, "one of size, min or max is required")
+		return
+	}
+	for _, v := range []*int{req.Size, req.Min, req.Max} {
+		if v != nil && *v < 0 {
+			writeErrorCode(c, 400, errCodeInvalidRequest, "size, min and max must not be negative")
+			return
+		}
+	}
+	if err := s.warm.resize(req.Size, req.Min, req.Max); err != nil {
+		writeErrorCode(c, 400, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if s.warm.enabled() {
+		s.warm.start(context.Background(), getenv("SANDBOX_IMAGE", defaultImage))
+	}
+	s.warm.reconcileSoon()
+	s.warm.mu.Lock()
+	cfg := s.warm.cfg
+	s.warm.mu.Unlock()
+	writeJSON(c, 200, api.WarmPoolResizeResponse{
+		Desired:  s.warm.desiredSize(),
+		Autosize: cfg.autosize,
+		Size:     cfg.size,
+		Min:      cfg.min,
+		Max:      cfg.max,
+	})
+}
+
+// listWarmNamespaces lists the unclaimed warm namespaces, oldest first, with each
+// one's pod phase and readiness. It reads the cluster rather than the pool, so it
+// also shows leftovers after the pool was disabled.
+func (s *server) listWarmNamespaces(c *gin.Context) {
+	ctx := c.Request.Context()
+	selector := labels.SelectorFromSet(map[string]string{"sbx.allocated": "false"})
+	namespaces, err := s.namespaces.list(ctx, selector)
+	if err != nil {
+		writeError(c, 500, err.Error())
+		return
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		a, b := namespaces[i].CreationTimestamp, namespaces[j].CreationTimestamp
+		if !a.Equal(&b) {
+			return a.Before(&b)
+		}
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	now := time.Now()
+	out := make([]api.WarmNamespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		item := api.WarmNamespace{
+			Name:       ns.Name,
+			State:      "creating",
+			CreatedAt:  ns.CreationTimestamp.UTC().Format(time.RFC3339),
+			AgeSeconds: int64(now.Sub(ns.CreationTimestamp.Time).Seconds()),
+		}
+		pod, err := s.client.CoreV1().Pods(ns.Name).Get(ctx, "sandbox", metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			writeError(c, 500, err.Error())
+			return
+		}
+		if err == nil {
+			item.PodPhase = string(pod.Status.Phase)
+			item.PodReady = podReady(pod)
+		}
+		switch {
+		case ns.DeletionTimestamp != nil:
+			item.State = "terminating"
+		case item.PodPhase == string(corev1.PodFailed):
+			item.State = "failed"
+		case item.PodReady && ns.Annotations[warmSetupAnnotation] == "pending":
+			item.State = "setting_up"
+		case item.PodReady:
+			item.State = "ready"
 		}
+		out = append(out, item)
 	}
-	return false, nil
+	writeJSON(c, 200, out)
 }
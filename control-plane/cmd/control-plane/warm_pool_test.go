@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// terminatingDeletes makes namespace deletes behave like a real API server with
+// finalizers: the namespace stays, marked Terminating, instead of disappearing.
+func terminatingDeletes(client *fake.Clientset, deleted *[]string) {
+	client.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.DeleteAction).GetName()
+		obj, err := client.Tracker().Get(corev1.SchemeGroupVersion.WithResource("namespaces"), "", name)
+		if err != nil {
+			return true, nil, err
+		}
+		ns := obj.(*corev1.Namespace).DeepCopy()
+		now := metav1.Now()
+		ns.DeletionTimestamp = &now
+		ns.Status.Phase = corev1.NamespaceTerminating
+		*deleted = append(*deleted, name)
+		return true, nil, client.Tracker().Update(corev1.SchemeGroupVersion.WithResource("namespaces"), ns, "")
+	})
+}
+
+func TestEnsureWarmNamespacesShrinkIgnoresTerminating(t *testing.T) {
+	var objs []runtime.Object
+	for i := 0; i < 4; i++ {
+		objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("sbx-warm%d", i),
+			Labels: map[string]string{"sbx.allocated": "false"},
+		}})
+	}
+	client := fake.NewSimpleClientset(objs...)
+	var deleted []string
+	terminatingDeletes(client, &deleted)
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{size: 1}, cacheConfig{mode: "emptydir"})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := w.ensureWarmNamespaces(ctx, "img"); err != nil {
+			t.Fatalf("reconcile %d: %v", i+1, err)
+		}
+	}
+	if len(deleted) != 3 {
+		t.Fatalf("deleted %v over two reconciles, want 3 namespaces deleted once each", deleted)
+	}
+	nsList, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live := liveNamespaces(nsList.Items); len(live) != 1 || len(nsList.Items) != 4 {
+		t.Fatalf("%d namespaces, %d live; want 4 with 1 live and nothing recreated", len(nsList.Items), len(live))
+	}
+}
+
+func TestEnsureWarmNamespacesKeepsClaimed(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-claimed", Labels: map[string]string{"sbx.allocated": "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-warm", Labels: map[string]string{"sbx.allocated": "false"}}},
+	)
+	var deleted []string
+	terminatingDeletes(client, &deleted)
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{}, cacheConfig{mode: "emptydir"})
+	if err := w.cleanupDisabled(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "sbx-warm" {
+		t.Fatalf("deleted %v, want only sbx-warm", deleted)
+	}
+}
+
+func TestEnsureWarmNamespacesRetriesFailedCreates(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	podCreates := 0
+	failing := true
+	client.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		podCreates++
+		if failing {
+			return true, nil, errors.New("etcdserver: request timed out")
+		}
+		return false, nil, nil
+	})
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{size: 2}, cacheConfig{mode: "emptydir"})
+	ctx := context.Background()
+	before := metricWarmPoolCreateErrors.Value()
+
+	if err := w.ensureWarmNamespaces(ctx, "img"); err == nil {
+		t.Fatal("reconcile with failing pod creates returned nil")
+	}
+	if got := metricWarmPoolCreateErrors.Value() - before; got != 2 {
+		t.Fatalf("create errors counted %d, want 2", got)
+	}
+	if w.createAllowed(time.Now()) {
+		t.Fatal("creates allowed right after a failing tick, want backoff")
+	}
+	if err := w.ensureWarmNamespaces(ctx, "img"); err != nil || podCreates != 2 {
+		t.Fatalf("reconcile during backoff: err=%v, %d pod creates; want no new attempts", err, podCreates)
+	}
+
+	// Once the API server recovers and the backoff has elapsed, the next tick
+	// fills the existing namespaces instead of adding new ones.
+	failing = false
+	w.mu.Lock()
+	w.nextCreate = time.Time{}
+	w.mu.Unlock()
+	if err := w.ensureWarmNamespaces(ctx, "img"); err != nil {
+		t.Fatalf("reconcile after recovery: %v", err)
+	}
+	pods, _ := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	nsList, _ := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if len(pods.Items) != 2 || len(nsList.Items) != 2 {
+		t.Fatalf("%d pods in %d namespaces, want 2 in 2", len(pods.Items), len(nsList.Items))
+	}
+	if !w.createAllowed(time.Now()) {
+		t.Fatal("backoff kept after a successful tick")
+	}
+}
+
+func TestRebuildRecentFromCreatedAt(t *testing.T) {
+	now := time.Now()
+	ns := func(name string, annotations map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+	}
+	unix := func(d time.Duration) string { return fmt.Sprint(now.Add(-d).Unix()) }
+	client := fake.NewSimpleClientset(
+		ns("sbx-a", map[string]string{"sbx.created_at": unix(5 * time.Second)}),
+		ns("sbx-b", map[string]string{"sbx.created_at": unix(10 * time.Second)}),
+		ns("sbx-c", map[string]string{"sbx.created_at": unix(20 * time.Second), "sbx.last_exec_at": unix(2 * time.Minute)}),
+		// Old creates and exec activity alone don't count as demand.
+		ns("sbx-d", map[string]string{"sbx.created_at": unix(2 * time.Minute)}),
+		ns("sbx-e", map[string]string{"sbx.last_exec_at": unix(5 * time.Second)}),
+	)
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{autosize: true, max: 10, window: time.Minute}, cacheConfig{mode: "emptydir"})
+	if err := w.rebuildRecent(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.desiredSize(); got != 3 {
+		t.Fatalf("desiredSize() = %d, want 3 from the creates within the window", got)
+	}
+}
+
+func TestWarmWindowRetainsSamples(t *testing.T) {
+	now := time.Now()
+	samples := []time.Duration{10 * time.Second, 90 * time.Second, 2 * time.Minute, 4 * time.Minute}
+	for _, tt := range []struct {
+		window time.Duration
+		want   int
+	}{
+		{time.Minute, 1},
+		{5 * time.Minute, 4},
+	} {
+		client := fake.NewSimpleClientset()
+		w := newWarmPool(client, nil, newWarmPodCache(client), warmPoolConfig{autosize: true, max: 10, window: tt.window}, cacheConfig{})
+		for _, ago := range samples {
+			w.recent = append(w.recent, now.Add(-ago))
+		}
+		if got := w.desiredSize(); got != tt.want {
+			t.Errorf("window %s: desiredSize() = %d, want %d", tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestWarmPoolConfigWindow(t *testing.T) {
+	t.Setenv("SANDBOX_WARM_WINDOW", "5m")
+	if got := warmPoolConfigFromEnv().window; got != 5*time.Minute {
+		t.Errorf("window = %s, want 5m", got)
+	}
+	t.Setenv("SANDBOX_WARM_WINDOW", "-1s")
+	if got := warmPoolConfigFromEnv().window; got != defaultWarmWindow {
+		t.Errorf("window = %s, want the default for a negative value", got)
+	}
+}
+
+func TestValidateWarmMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		key, value string
+		isLabel    bool
+		wantErr    string
+	}{
+		{"SANDBOX_WARM_LABELS", "team=infra,example.com/cost-center=ml", true, ""},
+		{"SANDBOX_WARM_LABELS", "bad key=x", true, `key "bad key"`},
+		{"SANDBOX_WARM_LABELS", "team=has space", true, `value "has space"`},
+		{"SANDBOX_METRICS_LABELS", "release=" + strings.Repeat("x", 64), true, "value"},
+		// Annotation values are free-form; only the keys are checked.
+		{"SANDBOX_WARM_ANNOTATIONS", "owner=Infra Team", false, ""},
+		{"SANDBOX_WARM_ANNOTATIONS", "-owner=x", false, `key "-owner"`},
+	} {
+		t.Setenv(tc.key, tc.value)
+		err := validateWarmMetadata(tc.key, tc.isLabel)
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%s=%q: %v", tc.key, tc.value, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s=%q: err = %v, want one mentioning %s", tc.key, tc.value, err, tc.wantErr)
+		}
+	}
+}
+
+func TestWarmMetadataAppliedAndClearedOnClaim(t *testing.T) {
+	t.Setenv("SANDBOX_WARM_LABELS", "team=infra, sbx.allocated=true")
+	t.Setenv("SANDBOX_WARM_ANNOTATIONS", "cost-center=42")
+	cfg := warmPoolConfigFromEnv()
+	cfg.size = 1
+	client := fake.NewSimpleClientset()
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), cfg, cacheConfig{mode: "emptydir"})
+	ctx := context.Background()
+	if err := w.ensureWarmNamespaces(ctx, "img"); err != nil {
+		t.Fatal(err)
+	}
+
+	nsList, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil || len(nsList.Items) != 1 {
+		t.Fatalf("namespaces = %v, %v; want one warm namespace", nsList, err)
+	}
+	ns := nsList.Items[0]
+	pod, err := client.CoreV1().Pods(ns.Name).Get(ctx, "sandbox", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, meta := range []metav1.ObjectMeta{ns.ObjectMeta, pod.ObjectMeta} {
+		if meta.Labels["team"] != "infra" || meta.Annotations["cost-center"] != "42" {
+			t.Errorf("%s %s: labels %v annotations %v, want the configured warm metadata", meta.Namespace, meta.Name, meta.Labels, meta.Annotations)
+		}
+	}
+	if ns.Labels["sbx.allocated"] != "false" {
+		t.Errorf("sbx.allocated = %q; reserved sbx. keys must not be overridden", ns.Labels["sbx.allocated"])
+	}
+
+	pod.Status = readyPod(ns.Name).Status
+	if _, err := client.CoreV1().Pods(ns.Name).UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	claimed, ok, err := w.claimWarmNamespace(ctx)
+	if err != nil || !ok || claimed != ns.Name {
+		t.Fatalf("claim = %q, %v, %v; want %s", claimed, ok, err, ns.Name)
+	}
+	gotNS, _ := client.CoreV1().Namespaces().Get(ctx, ns.Name, metav1.GetOptions{})
+	gotPod, _ := client.CoreV1().Pods(ns.Name).Get(ctx, "sandbox", metav1.GetOptions{})
+	for _, meta := range []metav1.ObjectMeta{gotNS.ObjectMeta, gotPod.ObjectMeta} {
+		if _, ok := meta.Labels["team"]; ok {
+			t.Errorf("%s %s keeps warm label after claim: %v", meta.Namespace, meta.Name, meta.Labels)
+		}
+		if _, ok := meta.Annotations["cost-center"]; ok {
+			t.Errorf("%s %s keeps warm annotation after claim: %v", meta.Namespace, meta.Name, meta.Annotations)
+		}
+	}
+}
+
+func TestResizeWarmPool(t *testing.T) {
+	s := newTestServer()
+	s.warm = newWarmPool(s.client, s.namespaces, newWarmPodCache(s.client), warmPoolConfig{size: 2}, cacheConfig{mode: "emptydir"})
+	s.warm.startOnce.Do(func() {}) // keep the reconcile loop out of the test
+
+	ten := 10
+	w := serve(s.resizeWarmPool, "POST", "/warm-pool/resize", "/warm-pool/resize", api.WarmPoolResizeRequest{Size: &ten})
+	if w.Code != 200 {
+		t.Fatalf("resize status = %d body %s", w.Code, w.Body)
+	}
+	var resp api.WarmPoolResizeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Desired != 10 || resp.Size != 10 {
+		t.Fatalf("resize response = %+v, want desired and size 10", resp)
+	}
+	select {
+	case <-s.warm.kick:
+	default:
+		t.Fatalf("resize did not request an immediate reconcile")
+	}
+
+	negative, eleven := -1, 11
+	s.warm.cfg.max = 10
+	for _, body := range []api.WarmPoolResizeRequest{{}, {Min: &negative}, {Min: &eleven}, {Size: &eleven, Min: &ten, Max: &negative}} {
+		w := serve(s.resizeWarmPool, "POST", "/warm-pool/resize", "/warm-pool/resize", body)
+		if w.Code != 400 || !strings.Contains(w.Body.String(), errCodeInvalidRequest) {
+			t.Errorf("resize %+v: status = %d body %s, want 400 %s", body, w.Code, w.Body, errCodeInvalidRequest)
+		}
+	}
+	if got := s.warm.desiredSize(); got != 10 {
+		t.Errorf("desiredSize after rejected resizes = %d, want 10", got)
+	}
+}
+
+func TestValidateWarmPoolBounds(t *testing.T) {
+	for _, tc := range []struct {
+		min, max int
+		ok       bool
+	}{{0, 0, true}, {2, 10, true}, {10, 10, true}, {5, 0, true}, {11, 10, false}} {
+		if err := validateWarmPoolBounds(tc.min, tc.max); (err == nil) != tc.ok {
+			t.Errorf("min %d max %d: err = %v, want ok %t", tc.min, tc.max, err, tc.ok)
+		}
+	}
+}
+
+func TestClaimWarmNamespaceSkipsFailedVerify(t *testing.T) {
+	var objs []runtime.Object
+	for _, name := range []string{"sbx-warm0", "sbx-warm1"} {
+		objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"sbx.allocated": "false"},
+		}}, readyPod(name))
+	}
+	client := fake.NewSimpleClientset(objs...)
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{size: 2, verify: true, verifyTimeout: time.Second}, cacheConfig{mode: "emptydir"})
+	var probed []string
+	w.probe = func(_ context.Context, ns string, cmd []string) error {
+		probed = append(probed, ns)
+		if ns == "sbx-warm0" {
+			return errors.New("exec failed")
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	claimed, ok, err := w.claimWarmNamespace(ctx)
+	if err != nil || !ok {
+		t.Fatalf("claim = %q, %t, %v; want a claim", claimed, ok, err)
+	}
+	if claimed != "sbx-warm1" {
+		t.Fatalf("claimed %q, want sbx-warm1 after sbx-warm0 failed verification", claimed)
+	}
+	if len(probed) != 2 {
+		t.Fatalf("probed %v, want both candidates", probed)
+	}
+	if _, err := client.CoreV1().Namespaces().Get(ctx, "sbx-warm0", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("failed candidate not reaped: %v", err)
+	}
+}
+
+func TestWarmPoolUnhealthyWhenPodsNeverReady(t *testing.T) {
+	s := newTestServer()
+	// The fake client never runs pods, so warm pods stay Pending.
+	s.warm = newWarmPool(s.client, s.namespaces, newWarmPodCache(s.client), warmPoolConfig{size: 2, unhealthyAfter: time.Minute, maxCreateErrors: 5}, cacheConfig{mode: "emptydir"})
+	s.warm.startOnce.Do(func() {})
+	ctx := context.Background()
+	if err := s.warm.ensureWarmNamespaces(ctx, "img"); err != nil {
+		t.Fatal(err)
+	}
+	if healthy, reason := s.warm.health(time.Now()); !healthy {
+		t.Fatalf("unhealthy right after the first tick: %s", reason)
+	}
+
+	// Still not ready past SANDBOX_WARM_UNHEALTHY_AFTER.
+	s.warm.mu.Lock()
+	s.warm.belowSince = time.Now().Add(-2 * time.Minute)
+	s.warm.mu.Unlock()
+	if err := s.warm.ensureWarmNamespaces(ctx, "img"); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(s.getWarmPool, "GET", "/warm-pool", "/warm-pool", nil)
+	var resp api.WarmPoolStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Healthy || resp.Ready != 0 || resp.Desired != 2 || !strings.Contains(resp.Reason, "below desired") {
+		t.Fatalf("status = %+v, want unhealthy with ready 0 below desired 2", resp)
+	}
+	if got := metricWarmPoolHealthy.Value(); got != 0 {
+		t.Fatalf("warm_pool_healthy = %d, want 0", got)
+	}
+}
+
+func TestWarmPoolUnhealthyOnCreateErrors(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{size: 2, unhealthyAfter: time.Hour, maxCreateErrors: 1}, cacheConfig{mode: "emptydir"})
+	_ = w.ensureWarmNamespaces(context.Background(), "img")
+	healthy, reason := w.health(time.Now())
+	if healthy || !strings.Contains(reason, "create errors") {
+		t.Fatalf("health = %v %q, want unhealthy from create errors", healthy, reason)
+	}
+	// Errors age out of the window.
+	if healthy, _ := w.health(time.Now().Add(warmCreateErrorWindow + time.Second)); !healthy {
+		t.Fatal("still unhealthy after the create errors left the window")
+	}
+}
+
+func TestListWarmNamespaces(t *testing.T) {
+	base := time.Now().Add(-10 * time.Minute)
+	warmNS := func(name string, age int) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            map[string]string{"sbx.allocated": "false"},
+			CreationTimestamp: metav1.NewTime(base.Add(time.Duration(age) * time.Minute)),
+		}}
+	}
+	terminating := warmNS("sbx-gone", 4)
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	failed := pendingPod("sbx-failed")
+	failed.Status.Phase = corev1.PodFailed
+	s := newTestServer(
+		warmNS("sbx-ready", 0), readyPod("sbx-ready"),
+		warmNS("sbx-pending", 1), pendingPod("sbx-pending"),
+		warmNS("sbx-nopod", 2),
+		warmNS("sbx-failed", 3), failed,
+		terminating,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sbx-claimed", Labels: map[string]string{"sbx.allocated": "true"}}},
+	)
+
+	w := serve(s.listWarmNamespaces, "GET", "/warm-pool/namespaces", "/warm-pool/namespaces", nil)
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var got []api.WarmNamespace
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name, state, phase string
+		ready              bool
+	}{
+		{"sbx-ready", "ready", "Running", true},
+		{"sbx-pending", "creating", "Pending", false},
+		{"sbx-nopod", "creating", "", false},
+		{"sbx-failed", "failed", "Failed", false},
+		{"sbx-gone", "terminating", "", false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d namespaces, want %d: %+v", len(got), len(want), got)
+	}
+	for i, ns := range got {
+		wn := want[i]
+		if ns.Name != wn.name || ns.State != wn.state || ns.PodPhase != wn.phase || ns.PodReady != wn.ready {
+			t.Errorf("namespace %d = %+v, want %+v", i, ns, wn)
+		}
+	}
+	if got[0].AgeSeconds < 590 {
+		t.Errorf("age of the oldest namespace = %ds, want about 600", got[0].AgeSeconds)
+	}
+}
+
+func TestWarmSetupGatesReady(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "sbx-warm",
+			Labels:      map[string]string{"sbx.allocated": "false"},
+			Annotations: map[string]string{warmSetupAnnotation: "pending"},
+		}},
+		readyPod("sbx-warm"),
+	)
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{size: 1, setup: "fill-cache", setupTimeout: time.Minute}, cacheConfig{mode: "emptydir"})
+	release := make(chan struct{})
+	ran := make(chan []string, 1)
+	w.probe = func(_ context.Context, ns string, cmd []string) error {
+		ran <- cmd
+		<-release
+		return nil
+	}
+
+	ctx := context.Background()
+	if err := w.ensureWarmNamespaces(ctx, "img"); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-ran; !strings.Contains(strings.Join(cmd, " "), "fill-cache") {
+		t.Fatalf("setup ran %q", cmd)
+	}
+	// The pod is Ready but setup hasn't finished, so the namespace isn't yet.
+	if w.ready != 0 {
+		t.Fatalf("ready = %d during setup, want 0", w.ready)
+	}
+	if name, ok, err := w.claimWarmNamespace(ctx); ok || err != nil {
+		t.Fatalf("claimed %q (%v) during setup", name, err)
+	}
+	// A second reconcile doesn't start the setup again.
+	if err := w.ensureWarmNamespaces(ctx, "img"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cmd := <-ran:
+		t.Fatalf("setup started twice: %q", cmd)
+	default:
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ns, err := client.CoreV1().Namespaces().Get(ctx, "sbx-warm", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns.Annotations[warmSetupAnnotation] == "done" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("setup never marked done: %v", ns.Annotations)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if name, ok, err := w.claimWarmNamespace(ctx); !ok || err != nil || name != "sbx-warm" {
+		t.Fatalf("claim after setup = %q, %t, %v", name, ok, err)
+	}
+}
+
+func TestWarmSetupFailureDeletesNamespace(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "sbx-warm",
+		Labels:      map[string]string{"sbx.allocated": "false"},
+		Annotations: map[string]string{warmSetupAnnotation: "pending"},
+	}}
+	client := fake.NewSimpleClientset(ns, readyPod("sbx-warm"))
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{size: 1, setup: "false", setupTimeout: time.Minute}, cacheConfig{mode: "emptydir"})
+	w.probe = func(context.Context, string, []string) error { return errors.New("exit code 1") }
+	w.runSetup(*ns)
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "sbx-warm", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("namespace with failed setup not deleted: %v", err)
+	}
+}
+
+func TestWarmSetupFailuresBackOff(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	w := newWarmPool(client, newNamespaceCache(client), newWarmPodCache(client), warmPoolConfig{size: 1, setup: "false", setupTimeout: time.Minute}, cacheConfig{mode: "emptydir"})
+	w.probe = func(context.Context, string, []string) error { return errors.New("exit code 1") }
+	now := time.Now()
+	var delays []time.Duration
+	for i := 0; i < 3; i++ {
+		ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sbx-warm%d", i)}}
+		w.runSetup(ns)
+		if w.createAllowed(now) {
+			t.Fatalf("failure %d: replacement allowed at once", i+1)
+		}
+		delays = append(delays, w.nextCreate.Sub(now))
+	}
+	if !(delays[0] < delays[1] && delays[1] < delays[2]) {
+		t.Errorf("delays = %v, want each consecutive setup failure to back off further", delays)
+	}
+	if len(w.createErrors) != 3 {
+		t.Errorf("create errors = %d, want setup failures counted", len(w.createErrors))
+	}
+
+	w.recordSetupResult(time.Now(), "sbx-warm", nil)
+	if w.setupFailures != 0 {
+		t.Errorf("setup failures = %d after a success, want 0", w.setupFailures)
+	}
+}
+
+func TestWarmEligibleSkipsPodSettings(t *testing.T) {
+	if !warmEligible(api.CreateSandboxRequest{}, "") {
+		t.Fatal("a plain create should claim a warm pod")
+	}
+	for name, req := range map[string]api.CreateSandboxRequest{
+		"restart_policy":                  {RestartPolicy: "Never"},
+		"priority_class_name":             {PriorityClassName: "preemptible"},
+		"tolerations":                     {Tolerations: []api.Toleration{{Key: "gpu", Operator: "Exists"}}},
+		"service_account_name":            {ServiceAccountName: "builder"},
+		"automount_service_account_token": {AutomountServiceAccountToken: new(bool)},
+	} {
+		if warmEligible(req, "") {
+			t.Errorf("%s: claimed a warm pod, which would drop the setting", name)
+		}
+	}
+}
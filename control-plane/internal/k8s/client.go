@@ -10,11 +10,24 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-func NewClient() (*kubernetes.Clientset, *rest.Config, error) {
+// Options tunes the client-go rate limiter. Zero values keep the client-go defaults
+// (5 QPS, burst 10).
+type Options struct {
+	QPS   float32
+	Burst int
+}
+
+func NewClient(opts Options) (*kubernetes.Clientset, *rest.Config, error) {
 	cfg, err := buildConfig()
 	if err != nil {
 		return nil, nil, err
 	}
+	if opts.QPS > 0 {
+		cfg.QPS = opts.QPS
+	}
+	if opts.Burst > 0 {
+		cfg.Burst = opts.Burst
+	}
 	client, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,52 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: abc
+`
+
+func TestNewClientAppliesRateLimits(t *testing.T) {
+	if inCluster() {
+		t.Skip("running in a cluster; the kubeconfig is ignored")
+	}
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", path)
+
+	_, cfg, err := NewClient(Options{QPS: 50, Burst: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QPS != 50 || cfg.Burst != 100 {
+		t.Errorf("QPS/Burst = %g/%d, want 50/100", cfg.QPS, cfg.Burst)
+	}
+
+	_, cfg, err = NewClient(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QPS != 0 || cfg.Burst != 0 {
+		t.Errorf("QPS/Burst = %g/%d, want unset so client-go applies its defaults", cfg.QPS, cfg.Burst)
+	}
+}
@@ -1,48 +1,274 @@
 package api
 
+import "encoding/json"
+
 type CreateSandboxRequest struct {
-	ID                   string            `json:"id"`
-	Image                string            `json:"image"`
-	Command              []string          `json:"command"`
-	VolumeMode           string            `json:"volume_mode"` // emptydir|pvc
-	CacheMode            string            `json:"cache_mode"`  // emptydir|hostpath|pvc
-	CachePVCSize         string            `json:"cache_pvc_size"`
-	CachePVCStorageClass string            `json:"cache_pvc_storage_class"`
-	CachePVCAccessMode   string            `json:"cache_pvc_access_mode"`
-	Env                  map[string]string `json:"env,omitempty"`
-	AllowedHosts         []string          `json:"allowed_hosts,omitempty"`
-	DisallowedHosts      []string          `json:"disallowed_hosts,omitempty"`
+	ID                           string            `json:"id"`
+	Image                        string            `json:"image"`
+	Command                      []string          `json:"command"`        // replaces the image ENTRYPOINT
+	Args                         []string          `json:"args,omitempty"` // replaces the image CMD
+	VolumeMode                   string            `json:"volume_mode"`    // emptydir|pvc
+	CacheMode                    string            `json:"cache_mode"`     // emptydir|hostpath|pvc|shared-pvc
+	CachePVCSize                 string            `json:"cache_pvc_size"`
+	CachePVCStorageClass         string            `json:"cache_pvc_storage_class"`
+	CachePVCAccessMode           string            `json:"cache_pvc_access_mode"`
+	Env                          map[string]string `json:"env,omitempty"`
+	AllowedHosts                 []string          `json:"allowed_hosts,omitempty"`
+	DisallowedHosts              []string          `json:"disallowed_hosts,omitempty"`
+	ServiceAccountName           string            `json:"service_account_name,omitempty"`
+	AutomountServiceAccountToken *bool             `json:"automount_service_account_token,omitempty"`
+	EnvFromSecret                []string          `json:"env_from_secret,omitempty"`
+	EnvFromConfigMap             []string          `json:"env_from_configmap,omitempty"`
+	Tolerations                  []Toleration      `json:"tolerations,omitempty"`
+	RestartPolicy                string            `json:"restart_policy,omitempty"`
+	PriorityClassName            string            `json:"priority_class_name,omitempty"`
+	Volumes                      []VolumeSpec      `json:"volumes,omitempty"`
+	WorkspacePath                string            `json:"workspace_path,omitempty"`
+	CachePath                    string            `json:"cache_path,omitempty"`
+	// Hostname and Subdomain set the pod's DNS identity. A subdomain also gets a
+	// headless Service of that name, making the pod resolvable as
+	// <hostname>.<subdomain>.<namespace>.svc.
+	Hostname  string `json:"hostname,omitempty"`
+	Subdomain string `json:"subdomain,omitempty"`
+	// Spread overrides SANDBOX_SPREAD: soft anti-affinity against other sandbox
+	// pods so the scheduler prefers nodes without one.
+	Spread *bool `json:"spread,omitempty"`
+	// ShareProcessNamespace puts all containers in the pod in one PID namespace, so a
+	// debug container can see the sandbox's processes.
+	ShareProcessNamespace *bool `json:"share_process_namespace,omitempty"`
+	// ActiveDeadlineSeconds caps how long the pod may run. The kubelet kills it once
+	// the deadline passes and the pod ends up Failed with reason DeadlineExceeded.
+	ActiveDeadlineSeconds *int64 `json:"active_deadline_seconds,omitempty"`
+	// DNSPolicy overrides SANDBOX_DNS_POLICY: Default, ClusterFirst or None. None
+	// needs DNSServers, or SANDBOX_DNS_SERVERS, to resolve anything.
+	DNSPolicy string `json:"dns_policy,omitempty"`
+	// DNSServers replaces SANDBOX_DNS_SERVERS, the nameserver IPs in the pod's
+	// dnsConfig.
+	DNSServers []string `json:"dns_servers,omitempty"`
+	// ReadinessGates are custom pod condition types, e.g. "example.com/app-ready",
+	// that must be True before the sandbox counts as ready. Something in or around
+	// the sandbox sets them on the pod status once the app is serving.
+	ReadinessGates []string `json:"readiness_gates,omitempty"`
+	// StartupProbe is checked until the sandbox container has finished starting,
+	// for images that take a long time to boot. Until it passes the pod isn't Ready.
+	StartupProbe *StartupProbe `json:"startup_probe,omitempty"`
+	// IdleTTL overrides SANDBOX_IDLE_TTL for this sandbox, e.g. "4h" for an
+	// interactive session or "5m" for a throwaway one. "0" keeps it from being
+	// reaped for idleness.
+	IdleTTL string `json:"idle_ttl,omitempty"`
+	// ExpiresAt is an RFC 3339 time after which the sandbox is deleted, whether or
+	// not it is in use.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// GitRepo is cloned into the workspace by an init container before the sandbox
+	// container starts.
+	GitRepo *GitRepo `json:"git_repo,omitempty"`
+	// TopologySpread replaces the SANDBOX_TOPOLOGY_SPREAD constraints. It is only
+	// accepted with SANDBOX_SINGLE_NAMESPACE.
+	TopologySpread []TopologySpread `json:"topology_spread,omitempty"`
+	// PodSpecOverlay is a strategic merge patch applied to the generated pod spec,
+	// for fields the request has no option for. Requires SANDBOX_ALLOW_POD_OVERLAY.
+	PodSpecOverlay json.RawMessage `json:"pod_spec_overlay,omitempty"`
+	// Metrics says the sandbox serves app metrics, getting it a Service and labels
+	// for Prometheus to discover it by.
+	Metrics *Metrics `json:"metrics,omitempty"`
+}
+
+// Metrics is where a sandbox serves Prometheus metrics: Path (default /metrics) on
+// Port.
+type Metrics struct {
+	Port int32  `json:"port"`
+	Path string `json:"path,omitempty"`
+}
+
+// StartupProbe checks whether the sandbox container has started, by running Command
+// in it, by an HTTP GET of HTTPPath on Port, or, with Port alone, by opening a TCP
+// connection. Exactly one of Command and Port must be set. The container is
+// restarted if the probe hasn't passed after FailureThreshold attempts
+// PeriodSeconds apart (defaults 60 and 5, i.e. five minutes).
+type StartupProbe struct {
+	Command             []string `json:"command,omitempty"`
+	HTTPPath            string   `json:"http_path,omitempty"`
+	Port                int32    `json:"port,omitempty"`
+	InitialDelaySeconds int32    `json:"initial_delay_seconds,omitempty"`
+	PeriodSeconds       int32    `json:"period_seconds,omitempty"`
+	TimeoutSeconds      int32    `json:"timeout_seconds,omitempty"`
+	FailureThreshold    int32    `json:"failure_threshold,omitempty"`
+}
+
+// GitRepo is an http(s) repository to clone into the workspace. Ref is a branch,
+// tag or commit and defaults to the remote HEAD; Depth > 0 makes a shallow clone.
+// Secret names a Secret with a password (or token) key and an optional username
+// key, in the sandbox namespace like env_from secrets; it requires an https URL.
+type GitRepo struct {
+	URL    string `json:"url"`
+	Ref    string `json:"ref,omitempty"`
+	Depth  int    `json:"depth,omitempty"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// TopologySpread is a topology spread constraint over sandbox pods.
+// WhenUnsatisfiable is ScheduleAnyway (the default) or DoNotSchedule.
+type TopologySpread struct {
+	TopologyKey       string `json:"topology_key"`
+	MaxSkew           int32  `json:"max_skew"`
+	WhenUnsatisfiable string `json:"when_unsatisfiable,omitempty"`
+}
+
+// VolumeSpec mounts an extra volume into the sandbox container. Type is emptydir or
+// pvc; pvc mounts an existing claim in the sandbox namespace.
+type VolumeSpec struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mount_path"`
+	Type      string `json:"type"` // emptydir|pvc
+	ClaimName string `json:"claim_name,omitempty"`
+	SizeLimit string `json:"size_limit,omitempty"` // emptydir only
+	ReadOnly  bool   `json:"read_only,omitempty"`
+}
+
+type Toleration struct {
+	Key      string `json:"key,omitempty"`
+	Operator string `json:"operator,omitempty"` // Exists|Equal
+	Value    string `json:"value,omitempty"`
+	Effect   string `json:"effect,omitempty"` // NoSchedule|PreferNoSchedule|NoExecute
 }
 
 type CreateSandboxResponse struct {
 	ID        string `json:"id"`
 	Namespace string `json:"namespace"`
 	PodName   string `json:"pod_name"`
+	// Existing is set when a sandbox with the requested id was already running.
+	Existing bool `json:"existing,omitempty"`
+	// Ready is set when the create was made with ?wait=true and the pod became ready.
+	Ready bool `json:"ready,omitempty"`
+	// Status is "queued" when the create was made with ?queue=true and every create
+	// slot was busy; it runs in the background once one frees up.
+	Status string `json:"status,omitempty"`
+}
+
+// CreatePlan is what POST /sandboxes?dry_run=true returns: the sandbox a create
+// would make, with nothing created. ImageSource is request, config, env or
+// default. WarmClaim says whether a ready warm namespace would be claimed, and
+// WarmReason why not when it wouldn't.
+type CreatePlan struct {
+	ID              string            `json:"id"`
+	Namespace       string            `json:"namespace"`
+	Image           string            `json:"image"`
+	ImageSource     string            `json:"image_source"`
+	VolumeMode      string            `json:"volume_mode"`
+	CacheMode       string            `json:"cache_mode"`
+	EnvKeys         []string          `json:"env_keys"`
+	AllowedHosts    []string          `json:"allowed_hosts,omitempty"`
+	DisallowedHosts []string          `json:"disallowed_hosts,omitempty"`
+	WarmClaim       bool              `json:"warm_claim"`
+	WarmReason      string            `json:"warm_reason,omitempty"`
+	Requests        map[string]string `json:"requests,omitempty"`
+	Limits          map[string]string `json:"limits,omitempty"`
+	PodSpec         json.RawMessage   `json:"pod_spec"`
+}
+
+// CreateEvent is one step of a sandbox coming up, sent by
+// GET /sandboxes/:id/create-events. Phase is NamespaceCreated, PodScheduled,
+// Pulling, ContainersReady, Ready or Failed; Failed carries the cause in Message.
+type CreateEvent struct {
+	Phase   string `json:"phase"`
+	Time    string `json:"time"`
+	Message string `json:"message,omitempty"`
 }
 
 type ExecRequest struct {
-	Command        []string `json:"command"`
-	Async          *bool    `json:"async"`
-	TimeoutSeconds *int     `json:"timeout_seconds,omitempty"`
+	Command []string `json:"command"`
+	// Shell runs as `bash -lc <shell>`. Script is written to a temp file in the sandbox
+	// and run with ScriptShell (default bash). Exactly one of Command, Shell and Script
+	// must be set.
+	Shell          string `json:"shell,omitempty"`
+	Script         string `json:"script,omitempty"`
+	ScriptShell    string `json:"script_shell,omitempty"`
+	Async          *bool  `json:"async"`
+	TimeoutSeconds *int   `json:"timeout_seconds,omitempty"`
 }
 
-type ExecResponse struct {
+// BulkExecResult is one sandbox's outcome in a bulk exec. Async runs set ExecID;
+// sync runs set the output and exit code.
+type BulkExecResult struct {
+	ID       string `json:"id"`
+	ExecID   string `json:"exec_id,omitempty"`
+	Status   string `json:"status"`
 	Stdout   string `json:"stdout,omitempty"`
 	Stderr   string `json:"stderr,omitempty"`
-	ExecID   string `json:"exec_id,omitempty"`
-	Status   string `json:"status,omitempty"`
-	ExitCode int    `json:"exit_code,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type BulkExecResponse struct {
+	Selector string           `json:"selector"`
+	Results  []BulkExecResult `json:"results"`
+}
+
+type ExecResponse struct {
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Chunks   []OutputChunk `json:"chunks,omitempty"`
+	ExecID   string        `json:"exec_id,omitempty"`
+	Status   string        `json:"status,omitempty"`
+	ExitCode int           `json:"exit_code,omitempty"`
+
+	// Set for sync execs with ?tail_bytes=N: the total bytes each stream
+	// produced, and whether Stdout or Stderr was cut to the last N bytes.
+	StdoutBytes int64 `json:"stdout_bytes,omitempty"`
+	StderrBytes int64 `json:"stderr_bytes,omitempty"`
+	Truncated   bool  `json:"truncated,omitempty"`
+
+	// CacheHit is set when a ?cache=true exec was answered from an earlier
+	// identical run instead of being executed.
+	CacheHit bool `json:"cache_hit,omitempty"`
+
+	// Detached is set when a sync exec with ?detach_after outlived the threshold
+	// and kept running as an async exec; follow it by ExecID.
+	Detached bool `json:"detached,omitempty"`
+}
+
+// OutputChunk is one write from a sync exec when ?ordered=true is set. Chunks are
+// returned in arrival order across stdout and stderr.
+type OutputChunk struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+	Time   string `json:"time"`
+}
+
+type SignalRequest struct {
+	Signal string `json:"signal"`
+}
+
+// ExecLogsResponse is the captured output of one exec. Source is "pod" when it was
+// read from the files the sidecar wrapper writes, or "registry" when only the
+// control plane's in-memory tail was available.
+type ExecLogsResponse struct {
+	SandboxID string `json:"sandbox_id"`
+	ExecID    string `json:"exec_id"`
+	Source    string `json:"source"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
 }
 
 type ExecStatusResponse struct {
-	SandboxID      string `json:"sandbox_id"`
-	ExecID         string `json:"exec_id"`
-	Status         string `json:"status"`
-	ExitCode       *int   `json:"exit_code,omitempty"`
+	SandboxID string `json:"sandbox_id"`
+	ExecID    string `json:"exec_id"`
+	PID       int    `json:"pid,omitempty"`
+	Status    string `json:"status"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	// QueuedAt is when the exec was accepted and StartedAt when it began running in
+	// the pod; WaitMs is the time between them (so far, if it hasn't started).
+	QueuedAt       string `json:"queued_at,omitempty"`
+	WaitMs         *int64 `json:"wait_ms,omitempty"`
 	StartedAt      string `json:"started_at,omitempty"`
 	FinishedAt     string `json:"finished_at,omitempty"`
 	TimeoutSeconds *int   `json:"timeout_seconds,omitempty"`
 	Error          string `json:"error,omitempty"`
+	// Stdout and Stderr are the last bytes of output, returned with ?output=true.
+	Stdout          string `json:"stdout,omitempty"`
+	Stderr          string `json:"stderr,omitempty"`
+	OutputTruncated bool   `json:"output_truncated,omitempty"`
 }
 
 type SandboxStatus struct {
@@ -52,4 +278,218 @@ type SandboxStatus struct {
 	State        string `json:"state"`
 	Allocated    string `json:"allocated"`
 	LastExecTime string `json:"last_exec_time"`
+	ReadyAt      string `json:"ready_at"`
+	Archived     bool   `json:"archived,omitempty"`
+}
+
+// SandboxList is one page of GET /sandboxes?limit=N. Continue is empty on the
+// last page.
+type SandboxList struct {
+	Items    []SandboxStatus `json:"items"`
+	Continue string          `json:"continue,omitempty"`
+}
+
+// SandboxCount is GET /sandboxes/count: how many sandboxes GET /sandboxes would
+// list with the same filters, by namespace phase. Archived counts the archived
+// ones, which are only included with ?archived=true.
+type SandboxCount struct {
+	Total    int            `json:"total"`
+	ByState  map[string]int `json:"by_state"`
+	Archived int            `json:"archived,omitempty"`
+}
+
+type WarmPoolStats struct {
+	Enabled bool `json:"enabled"`
+	Desired int  `json:"desired"`
+	Ready   int  `json:"ready"`
+}
+
+type StatsResponse struct {
+	Sandboxes     int            `json:"sandboxes"`
+	ByState       map[string]int `json:"by_state"`
+	ExecsRunning  int            `json:"execs_running"`
+	WarmPool      WarmPoolStats  `json:"warm_pool"`
+	CreatesTotal  int64          `json:"creates_total"`
+	ExecsTotal    int64          `json:"execs_total"`
+	DeletesTotal  int64          `json:"deletes_total"`
+	CreatesPerMin float64        `json:"creates_per_min"`
+	ExecsPerMin   float64        `json:"execs_per_min"`
+	DeletesPerMin float64        `json:"deletes_per_min"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	GeneratedAt   string         `json:"generated_at"`
+}
+
+type ContainerUsage struct {
+	Name        string `json:"name"`
+	CPU         string `json:"cpu"`
+	Memory      string `json:"memory"`
+	CPUMillis   int64  `json:"cpu_millis"`
+	MemoryBytes int64  `json:"memory_bytes"`
+}
+
+type SandboxUsage struct {
+	ID         string           `json:"id"`
+	Timestamp  string           `json:"timestamp,omitempty"`
+	Window     string           `json:"window,omitempty"`
+	Containers []ContainerUsage `json:"containers"`
+}
+
+type WaitRequest struct {
+	Command  []string `json:"command"`
+	Interval string   `json:"interval,omitempty"` // Go duration, default 1s
+	Timeout  string   `json:"timeout,omitempty"`  // Go duration, default 60s
+}
+
+type WaitResponse struct {
+	Ready    bool   `json:"ready"`
+	Attempts int    `json:"attempts"`
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ConfigValue is one resolved control-plane setting. Source is "config", "env" or
+// "default"; secret values are redacted.
+type ConfigValue struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+type ConfigResponse struct {
+	ConfigPath  string        `json:"config_path,omitempty"`
+	ConfigError string        `json:"config_error,omitempty"`
+	Settings    []ConfigValue `json:"settings"`
+}
+
+// OrphanVolume is a PVC, or a Released PV, whose sandbox namespace is gone or stuck
+// terminating.
+type OrphanVolume struct {
+	Kind         string `json:"kind"`
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	Reason       string `json:"reason"`
+	StorageClass string `json:"storage_class,omitempty"`
+	Capacity     string `json:"capacity,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	Deleting     bool   `json:"deleting,omitempty"`
+}
+
+type OrphansResponse struct {
+	Orphans     []OrphanVolume `json:"orphans"`
+	ReapEnabled bool           `json:"reap_enabled"`
+}
+
+// ReapedSandbox is a sandbox deleted by a reaper sweep, or that a dry run would
+// delete. Reason is idle, archived, expired or kept; ForSeconds is how long it
+// had been so.
+type ReapedSandbox struct {
+	ID         string `json:"id"`
+	Reason     string `json:"reason"`
+	ForSeconds int64  `json:"for_seconds"`
+}
+
+type ReapResponse struct {
+	Reaped []ReapedSandbox `json:"reaped"`
+	DryRun bool            `json:"dry_run,omitempty"`
+}
+
+// DrainResponse reports whether the control plane is draining after POST
+// /admin/drain or /admin/undrain.
+type DrainResponse struct {
+	Draining bool `json:"draining"`
+}
+
+// WarmPoolResizeRequest overrides the warm pool bounds until the control plane
+// restarts. Omitted fields are left unchanged.
+type WarmPoolResizeRequest struct {
+	Size *int `json:"size,omitempty"`
+	Min  *int `json:"min,omitempty"`
+	Max  *int `json:"max,omitempty"`
+}
+
+// WarmPoolStatus reports the warm pool's size and health. Healthy is false when
+// ready has been below desired for longer than SANDBOX_WARM_UNHEALTHY_AFTER or
+// warm creates keep failing; Reason says which.
+type WarmPoolStatus struct {
+	Enabled            bool   `json:"enabled"`
+	Autosize           bool   `json:"autosize"`
+	Desired            int    `json:"desired"`
+	Ready              int    `json:"ready"`
+	Healthy            bool   `json:"healthy"`
+	Reason             string `json:"reason,omitempty"`
+	BelowDesiredSince  string `json:"below_desired_since,omitempty"`
+	RecentCreateErrors int    `json:"recent_create_errors"`
+}
+
+type WarmPoolResizeResponse struct {
+	Desired  int  `json:"desired"`
+	Autosize bool `json:"autosize"`
+	Size     int  `json:"size"`
+	Min      int  `json:"min"`
+	Max      int  `json:"max"`
+}
+
+// WarmNamespace is one unclaimed warm namespace. State is creating until the pod
+// is Ready, setting_up while SANDBOX_WARM_SETUP runs in it, then ready; failed when
+// the pod failed, and terminating once the namespace is being deleted.
+type WarmNamespace struct {
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	PodPhase   string `json:"pod_phase,omitempty"`
+	PodReady   bool   `json:"pod_ready"`
+	CreatedAt  string `json:"created_at"`
+	AgeSeconds int64  `json:"age_seconds"`
+}
+
+// UpdateSandboxRequest sets labels and annotations on a sandbox. A null value
+// removes the key; sbx.* keys are reserved.
+type UpdateSandboxRequest struct {
+	Labels      map[string]*string `json:"labels,omitempty"`
+	Annotations map[string]*string `json:"annotations,omitempty"`
+}
+
+// BatchOp is one operation in a POST /batch body. Op is create, exec, delete or
+// status. Params is the body the op's own endpoint takes; exec, delete and status
+// name the sandbox with an "id" field, e.g. {"id": "sbx-abc", "command": ["ls"]}.
+type BatchOp struct {
+	Op     string          `json:"op"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// BatchResult is one op's outcome: the HTTP status and JSON body its own endpoint
+// would have returned.
+type BatchResult struct {
+	Op     string          `json:"op"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// BatchStreamResult is a "result" event of POST /batch?stream=true. Index is the
+// op's position in the request; results arrive in completion order.
+type BatchStreamResult struct {
+	Index int `json:"index"`
+	BatchResult
+}
+
+// BatchResponse holds one result per op, in request order.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// UpdateSandboxResponse is the sandbox's status after PATCH /sandboxes/:id, with
+// its labels and annotations.
+type UpdateSandboxResponse struct {
+	SandboxStatus
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// SandboxEnvResponse is the env declared on the sandbox container. Secret-looking
+// values are redacted and valueFrom references are described, not resolved.
+type SandboxEnvResponse struct {
+	ID      string            `json:"id"`
+	Env     map[string]string `json:"env"`
+	EnvFrom []string          `json:"env_from,omitempty"`
 }
@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+type Project struct {
+	Path string   `json:"path"`
+	Lang Language `json:"lang"`
+}
+
+var skipDirs = map[string]bool{
+	".git":         true,
+	"vendor":       true,
+	"node_modules": true,
+}
+
+// DetectProjects walks repoPath up to maxDepth directories deep and returns every
+// directory that DetectRepoLang recognizes. Paths are relative to repoPath, with the
+// root reported as ".". A negative maxDepth means no limit.
+func DetectProjects(repoPath string, maxDepth int) []Project {
+	var out []Project
+	_ = filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && path != repoPath {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(repoPath, path)
+		if err != nil {
+			return nil
+		}
+		if rel != "." {
+			if skipDirs[d.Name()] {
+				return fs.SkipDir
+			}
+			if maxDepth >= 0 && strings.Count(rel, string(filepath.Separator))+1 > maxDepth {
+				return fs.SkipDir
+			}
+		}
+		if lang := DetectRepoLang(path); lang != LangUnknown {
+			out = append(out, Project{Path: filepath.ToSlash(rel), Lang: lang})
+		}
+		return nil
+	})
+	return out
+}
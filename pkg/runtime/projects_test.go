@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDetectProjects(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{
+		"go.mod",
+		"web/package.json",
+		"services/api/pyproject.toml",
+		"services/api/deep/nested/Cargo.toml",
+		"web/node_modules/dep/package.json",
+		"vendor/lib/go.mod",
+		".git/hooks/pom.xml",
+		"docs/README.md",
+	} {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     []Project
+	}{
+		{name: "root only", maxDepth: 0, want: []Project{{".", LangGo}}},
+		{name: "one level", maxDepth: 1, want: []Project{{".", LangGo}, {"web", LangNode}}},
+		{name: "two levels", maxDepth: 2, want: []Project{{".", LangGo}, {"services/api", LangPython}, {"web", LangNode}}},
+		{name: "no limit", maxDepth: -1, want: []Project{
+			{".", LangGo},
+			{"services/api", LangPython},
+			{"services/api/deep/nested", LangRust},
+			{"web", LangNode},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectProjects(root, tt.maxDepth); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DetectProjects(%d) = %v, want %v", tt.maxDepth, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectProjectsMissingRoot(t *testing.T) {
+	if got := DetectProjects(filepath.Join(t.TempDir(), "missing"), -1); len(got) != 0 {
+		t.Errorf("DetectProjects on a missing dir = %v, want none", got)
+	}
+}
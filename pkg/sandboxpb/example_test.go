@@ -0,0 +1,62 @@
+package sandboxpb_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"sandbox/pkg/sandboxpb"
+)
+
+// Creates a sandbox, pipes a line through a command in it and prints the output.
+func Example() {
+	conn, err := grpc.NewClient("localhost:9090", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := sandboxpb.NewSandboxClient(conn)
+	ctx := context.Background()
+
+	sbx, err := client.Create(ctx, &sandboxpb.CreateRequest{Image: "python:3.12-slim"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Delete(ctx, &sandboxpb.DeleteRequest{Id: sbx.Id})
+
+	stream, err := client.Exec(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, in := range []*sandboxpb.ExecInput{
+		{Input: &sandboxpb.ExecInput_Start{Start: &sandboxpb.ExecStart{SandboxId: sbx.Id, Command: []string{"tr", "a-z", "A-Z"}, Stdin: true}}},
+		{Input: &sandboxpb.ExecInput_Stdin{Stdin: []byte("hello from grpc\n")}},
+		{Input: &sandboxpb.ExecInput_CloseStdin{CloseStdin: true}},
+	} {
+		if err := stream.Send(in); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for {
+		out, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch v := out.Output.(type) {
+		case *sandboxpb.ExecOutput_Stdout:
+			os.Stdout.Write(v.Stdout)
+		case *sandboxpb.ExecOutput_Stderr:
+			os.Stderr.Write(v.Stderr)
+		case *sandboxpb.ExecOutput_Exit:
+			fmt.Println("exit code", v.Exit.Code, v.Exit.Error)
+		}
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,15 +17,126 @@ import (
 )
 
 type Client struct {
-	baseURL string
-	client  *http.Client
+	baseURL   string
+	client    *http.Client
+	token     string
+	userAgent string
+	// timeout is applied by New after all options, so it holds whichever HTTP
+	// client WithHTTPClient supplied.
+	timeout *time.Duration
+	// pool tunes the default transport; unused when WithHTTPClient is given.
+	pool       poolConfig
+	customHTTP bool
 }
 
-func New(baseURL string) *Client {
-	return &Client{
+// poolConfig holds the connection reuse settings of the default transport. Go's
+// stock transport keeps only 2 idle connections per host, so bursts of concurrent
+// requests to the one control plane keep dialing and closing connections.
+type poolConfig struct {
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	keepAlive           time.Duration
+}
+
+const (
+	defaultMaxIdleConnsPerHost = 64
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+)
+
+func (p poolConfig) transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: p.keepAlive}).DialContext
+	t.MaxIdleConnsPerHost = p.maxIdleConnsPerHost
+	if t.MaxIdleConns < p.maxIdleConnsPerHost {
+		t.MaxIdleConns = p.maxIdleConnsPerHost
+	}
+	t.IdleConnTimeout = p.idleConnTimeout
+	return t
+}
+
+// Option configures a Client created by New.
+type Option func(*Client)
+
+// WithHTTPClient replaces the underlying HTTP client, e.g. to supply custom TLS or a proxy.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.client = hc
+			c.customHTTP = true
+		}
+	}
+}
+
+// WithMaxIdleConnsPerHost sets how many idle connections to the control plane are
+// kept for reuse (default 64). Raise it for workloads with more requests in flight.
+// Ignored when WithHTTPClient is given.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.pool.maxIdleConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept before it is closed
+// (default 90s, 0 = no limit). Ignored when WithHTTPClient is given.
+func WithIdleConnTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.pool.idleConnTimeout = d
+	}
+}
+
+// WithKeepAlive sets the TCP keep-alive period of new connections (default 30s,
+// negative = off). Ignored when WithHTTPClient is given.
+func WithKeepAlive(d time.Duration) Option {
+	return func(c *Client) {
+		c.pool.keepAlive = d
+	}
+}
+
+// WithTimeout sets the per-request timeout of the underlying HTTP client, whether
+// it is the default one or one given to WithHTTPClient, in any order.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = &d
+	}
+}
+
+// WithToken sends the token as a bearer Authorization header on every request.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithUserAgent overrides the User-Agent header.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
 		baseURL: strings.TrimRight(baseURL, "/"),
-		client:  &http.Client{Timeout: 30 * time.Second},
+		pool: poolConfig{
+			maxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+			idleConnTimeout:     defaultIdleConnTimeout,
+			keepAlive:           defaultKeepAlive,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	if !c.customHTTP {
+		c.client = &http.Client{Timeout: 30 * time.Second, Transport: c.pool.transport()}
+	}
+	if c.timeout != nil {
+		// Copy so a client passed to WithHTTPClient isn't changed under its owner.
+		hc := *c.client
+		hc.Timeout = *c.timeout
+		c.client = &hc
+	}
+	return c
 }
 
 func (c *Client) Create(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error) {
@@ -33,6 +147,37 @@ func (c *Client) Create(ctx context.Context, req api.CreateSandboxRequest) (*api
 	return &resp, nil
 }
 
+// Plan returns what creating req would do without creating anything.
+func (c *Client) Plan(ctx context.Context, req api.CreateSandboxRequest) (*api.CreatePlan, error) {
+	var resp api.CreatePlan
+	if err := c.do(ctx, http.MethodPost, "/sandboxes?dry_run=true", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateWait creates a sandbox and blocks until its pod is ready or timeout elapses,
+// in which case the control plane answers 504 and the sandbox keeps provisioning.
+func (c *Client) CreateWait(ctx context.Context, req api.CreateSandboxRequest, timeout time.Duration) (*api.CreateSandboxResponse, error) {
+	var resp api.CreateSandboxResponse
+	path := "/sandboxes?wait=true&wait_timeout=" + url.QueryEscape(timeout.String())
+	if err := c.outlasting(timeout).do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateQueued creates a sandbox, or when every create slot on the control plane is
+// busy returns its id at once with Status "queued" and creates it in the
+// background. GET /sandboxes/:id reports phase "queued" until then.
+func (c *Client) CreateQueued(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error) {
+	var resp api.CreateSandboxResponse
+	if err := c.do(ctx, http.MethodPost, "/sandboxes?queue=true", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (c *Client) Exec(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
 	var resp api.ExecResponse
 	path := fmt.Sprintf("/sandboxes/%s/exec", id)
@@ -42,6 +187,66 @@ func (c *Client) Exec(ctx context.Context, id string, req api.ExecRequest) (*api
 	return &resp, nil
 }
 
+// ExecQueued starts an async exec that waits for the sandbox to become ready instead
+// of failing with 409.
+func (c *Client) ExecQueued(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	var resp api.ExecResponse
+	path := fmt.Sprintf("/sandboxes/%s/exec?queue=true", id)
+	if err := c.do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExecOrdered runs a sync exec and returns its output as chunks in arrival order
+// instead of separate stdout and stderr blobs.
+func (c *Client) ExecOrdered(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	var resp api.ExecResponse
+	path := fmt.Sprintf("/sandboxes/%s/exec?ordered=true", id)
+	if err := c.do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExecCached runs a sync exec that may be answered from an identical earlier run;
+// CacheHit is set on the response when it was.
+func (c *Client) ExecCached(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	var resp api.ExecResponse
+	path := fmt.Sprintf("/sandboxes/%s/exec?cache=true", id)
+	if err := c.do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExecDetachable runs a sync exec that turns into an async one if it is still
+// running after detachAfter. Detached is then set on the response, with ExecID
+// to follow it by.
+func (c *Client) ExecDetachable(ctx context.Context, id string, req api.ExecRequest, detachAfter time.Duration) (*api.ExecResponse, error) {
+	var resp api.ExecResponse
+	path := fmt.Sprintf("/sandboxes/%s/exec?detach_after=%s", id, url.QueryEscape(detachAfter.String()))
+	if err := c.outlasting(detachAfter).do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Wait runs req.Command in the sandbox until it exits 0 or req.Timeout elapses.
+func (c *Client) Wait(ctx context.Context, id string, req api.WaitRequest) (*api.WaitResponse, error) {
+	var resp api.WaitResponse
+	path := fmt.Sprintf("/sandboxes/%s/wait", id)
+	// The control plane holds the request for up to the timeout, 60s by default.
+	timeout := 60 * time.Second
+	if d, err := time.ParseDuration(req.Timeout); err == nil {
+		timeout = d
+	}
+	if err := c.outlasting(timeout).do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (c *Client) ExecStatus(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error) {
 	var resp api.ExecStatusResponse
 	path := fmt.Sprintf("/sandboxes/%s/execs/%s", id, execID)
@@ -51,6 +256,63 @@ func (c *Client) ExecStatus(ctx context.Context, id, execID string) (*api.ExecSt
 	return &resp, nil
 }
 
+// BulkExec runs req in every sandbox matching the label selector.
+func (c *Client) BulkExec(ctx context.Context, selector string, req api.ExecRequest) (*api.BulkExecResponse, error) {
+	var resp api.BulkExecResponse
+	path := "/sandboxes/exec?selector=" + url.QueryEscape(selector)
+	if err := c.do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Batch runs ops in one request, in order or concurrently when parallel is set.
+// Each result carries the status and body of that op alone; failed ops don't
+// make Batch return an error.
+func (c *Client) Batch(ctx context.Context, ops []api.BatchOp, parallel bool) (*api.BatchResponse, error) {
+	var resp api.BatchResponse
+	path := "/batch"
+	if parallel {
+		path += "?parallel=true"
+	}
+	if err := c.do(ctx, http.MethodPost, path, ops, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExecOutput returns the exec status along with the tail of its stdout and stderr.
+func (c *Client) ExecOutput(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error) {
+	var resp api.ExecStatusResponse
+	path := fmt.Sprintf("/sandboxes/%s/execs/%s?output=true", id, execID)
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExecStatusWait long-polls the exec's status: it returns once the exec reaches a
+// terminal state or timeout elapses, whichever comes first.
+func (c *Client) ExecStatusWait(ctx context.Context, id, execID string, timeout time.Duration) (*api.ExecStatusResponse, error) {
+	var resp api.ExecStatusResponse
+	path := fmt.Sprintf("/sandboxes/%s/execs/%s?wait=true&timeout=%s", id, execID, url.QueryEscape(timeout.String()))
+	if err := c.outlasting(timeout).do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExecLogs returns the full captured output of an exec, read from the sandbox pod in
+// sidecar mode.
+func (c *Client) ExecLogs(ctx context.Context, id, execID string) (*api.ExecLogsResponse, error) {
+	var resp api.ExecLogsResponse
+	path := fmt.Sprintf("/sandboxes/%s/execs/%s/logs", id, execID)
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (c *Client) CancelExec(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error) {
 	var resp api.ExecStatusResponse
 	path := fmt.Sprintf("/sandboxes/%s/execs/%s/cancel", id, execID)
@@ -60,28 +322,352 @@ func (c *Client) CancelExec(ctx context.Context, id, execID string) (*api.ExecSt
 	return &resp, nil
 }
 
+// SignalExec sends a signal (e.g. "SIGINT") to a running async exec.
+func (c *Client) SignalExec(ctx context.Context, id, execID, signal string) (*api.ExecStatusResponse, error) {
+	var resp api.ExecStatusResponse
+	path := fmt.Sprintf("/sandboxes/%s/execs/%s/signal", id, execID)
+	if err := c.do(ctx, http.MethodPost, path, api.SignalRequest{Signal: signal}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (c *Client) Delete(ctx context.Context, id string) error {
 	path := fmt.Sprintf("/sandboxes/%s", id)
 	return c.do(ctx, http.MethodDelete, path, nil, nil)
 }
 
+// ForceDelete deletes a sandbox and, if its namespace is stuck terminating,
+// removes the namespace finalizers. It needs a client created WithToken holding
+// the admin token.
+func (c *Client) ForceDelete(ctx context.Context, id string) error {
+	path := fmt.Sprintf("/sandboxes/%s?force=true", id)
+	// The control plane first waits SANDBOX_FORCE_DELETE_WAIT for the namespace to
+	// go on its own, which can outlast the client timeout; ctx bounds the call.
+	wait := 2 * time.Minute
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = time.Until(deadline)
+	}
+	return c.outlasting(wait).do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// DeleteKeepNamespace deletes only the sandbox pod, keeping its namespace, PVCs
+// and events for a post-mortem until the reaper removes it.
+func (c *Client) DeleteKeepNamespace(ctx context.Context, id string) error {
+	path := fmt.Sprintf("/sandboxes/%s?keep_namespace=true", id)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// Update sets or removes labels and annotations on a sandbox.
+func (c *Client) Update(ctx context.Context, id string, req api.UpdateSandboxRequest) (*api.UpdateSandboxResponse, error) {
+	var resp api.UpdateSandboxResponse
+	path := fmt.Sprintf("/sandboxes/%s", id)
+	if err := c.do(ctx, http.MethodPatch, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Archive deletes the sandbox pod but keeps its namespace and PVCs.
+func (c *Client) Archive(ctx context.Context, id string) error {
+	path := fmt.Sprintf("/sandboxes/%s/archive", id)
+	return c.do(ctx, http.MethodPost, path, map[string]string{}, nil)
+}
+
+// Touch resets the sandbox's idle timer without running anything.
+func (c *Client) Touch(ctx context.Context, id string) error {
+	path := fmt.Sprintf("/sandboxes/%s/touch", id)
+	return c.do(ctx, http.MethodPost, path, map[string]string{}, nil)
+}
+
+// Unarchive recreates the pod of an archived sandbox.
+func (c *Client) Unarchive(ctx context.Context, id string) error {
+	path := fmt.Sprintf("/sandboxes/%s/unarchive", id)
+	return c.do(ctx, http.MethodPost, path, map[string]string{}, nil)
+}
+
+// Status returns a sandbox's status fields. List fields such as allowed_hosts are
+// joined with commas.
 func (c *Client) Status(ctx context.Context, id string) (map[string]string, error) {
 	path := fmt.Sprintf("/sandboxes/%s", id)
-	var resp map[string]string
+	var raw map[string]any
+	if err := c.do(ctx, http.MethodGet, path, nil, &raw); err != nil {
+		return nil, err
+	}
+	resp := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			resp[k] = v
+		case []any:
+			parts := make([]string, 0, len(v))
+			for _, item := range v {
+				parts = append(parts, fmt.Sprint(item))
+			}
+			resp[k] = strings.Join(parts, ",")
+		default:
+			resp[k] = fmt.Sprint(v)
+		}
+	}
+	return resp, nil
+}
+
+// ListOptions filters and pages ListSandboxes. Selector is a label selector on
+// the sandbox namespace and State a namespace phase such as Active. Limit caps
+// the page size (0 = everything in one page) and Continue is the token from the
+// previous page.
+type ListOptions struct {
+	Limit    int
+	Selector string
+	State    string
+	Continue string
+}
+
+// ListSandboxes returns one page of sandboxes. Without options, or without a
+// Limit, the page holds every sandbox and has no continue token.
+func (c *Client) ListSandboxes(ctx context.Context, opts ...ListOptions) (*api.SandboxList, error) {
+	var o ListOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	q := url.Values{}
+	if o.Selector != "" {
+		q.Set("selector", o.Selector)
+	}
+	if o.State != "" {
+		q.Set("state", o.State)
+	}
+	path := "/sandboxes"
+	if o.Limit <= 0 && o.Continue == "" {
+		// Unpaged lists come back as a bare array.
+		if len(q) > 0 {
+			path += "?" + q.Encode()
+		}
+		var items []api.SandboxStatus
+		if err := c.do(ctx, http.MethodGet, path, nil, &items); err != nil {
+			return nil, err
+		}
+		return &api.SandboxList{Items: items}, nil
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Continue != "" {
+		q.Set("continue", o.Continue)
+	}
+	var resp api.SandboxList
+	if err := c.do(ctx, http.MethodGet, path+"?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CountSandboxes returns how many sandboxes match opts' Selector and State,
+// without fetching them. Limit and Continue are ignored.
+func (c *Client) CountSandboxes(ctx context.Context, opts ListOptions) (*api.SandboxCount, error) {
+	q := url.Values{}
+	if opts.Selector != "" {
+		q.Set("selector", opts.Selector)
+	}
+	if opts.State != "" {
+		q.Set("state", opts.State)
+	}
+	path := "/sandboxes/count"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var resp api.SandboxCount
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListAllSandboxes lists every sandbox matching opts, following continue tokens
+// with pages of opts.Limit.
+func (c *Client) ListAllSandboxes(ctx context.Context, opts ListOptions) ([]api.SandboxStatus, error) {
+	var all []api.SandboxStatus
+	for {
+		page, err := c.ListSandboxes(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Items...)
+		if page.Continue == "" {
+			return all, nil
+		}
+		opts.Continue = page.Continue
+	}
+}
+
+func (c *Client) Usage(ctx context.Context, id string) (*api.SandboxUsage, error) {
+	var resp api.SandboxUsage
+	path := fmt.Sprintf("/sandboxes/%s/usage", id)
 	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
 		return nil, err
 	}
+	return &resp, nil
+}
+
+func (c *Client) Stats(ctx context.Context) (*api.StatsResponse, error) {
+	var resp api.StatsResponse
+	if err := c.do(ctx, http.MethodGet, "/stats", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Metrics returns the control plane's expvar variables from /metrics, keyed by
+// name. Values are left raw since they may be numbers, strings or maps.
+func (c *Client) Metrics(ctx context.Context) (map[string]json.RawMessage, error) {
+	var resp map[string]json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/metrics", nil, &resp); err != nil {
+		return nil, err
+	}
 	return resp, nil
 }
 
-func (c *Client) ListSandboxes(ctx context.Context) ([]api.SandboxStatus, error) {
-	var resp []api.SandboxStatus
-	if err := c.do(ctx, http.MethodGet, "/sandboxes", nil, &resp); err != nil {
+// Env returns the environment declared on the sandbox container.
+func (c *Client) Env(ctx context.Context, id string) (*api.SandboxEnvResponse, error) {
+	var resp api.SandboxEnvResponse
+	path := fmt.Sprintf("/sandboxes/%s/env", id)
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Manifest returns a create request that recreates the sandbox. Secret-looking
+// env values come back as "<redacted>".
+func (c *Client) Manifest(ctx context.Context, id string) (*api.CreateSandboxRequest, error) {
+	var req api.CreateSandboxRequest
+	path := fmt.Sprintf("/sandboxes/%s/manifest", id)
+	if err := c.do(ctx, http.MethodGet, path, nil, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// Config returns the control plane's effective configuration. It requires the admin token.
+func (c *Client) Config(ctx context.Context) (*api.ConfigResponse, error) {
+	var resp api.ConfigResponse
+	if err := c.do(ctx, http.MethodGet, "/config", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Orphans lists volumes left behind by deleted sandboxes. Requires the admin token.
+func (c *Client) Orphans(ctx context.Context) (*api.OrphansResponse, error) {
+	var resp api.OrphansResponse
+	if err := c.do(ctx, http.MethodGet, "/admin/orphans", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Reap runs a reaper sweep now and returns the sandboxes it deleted, or with
+// dryRun would delete. Requires the admin token.
+func (c *Client) Reap(ctx context.Context, dryRun bool) (*api.ReapResponse, error) {
+	var resp api.ReapResponse
+	path := "/admin/reap"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	if err := c.do(ctx, http.MethodPost, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Drain makes the control plane reject creates (on) or accept them again (off).
+// Requires the admin token.
+func (c *Client) Drain(ctx context.Context, on bool) (*api.DrainResponse, error) {
+	var resp api.DrainResponse
+	path := "/admin/undrain"
+	if on {
+		path = "/admin/drain"
+	}
+	if err := c.do(ctx, http.MethodPost, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WarmPool returns the warm pool's size and health.
+func (c *Client) WarmPool(ctx context.Context) (*api.WarmPoolStatus, error) {
+	var resp api.WarmPoolStatus
+	if err := c.do(ctx, http.MethodGet, "/warm-pool", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WarmNamespaces lists the unclaimed warm namespaces with their pod state.
+// Requires the admin token.
+func (c *Client) WarmNamespaces(ctx context.Context) ([]api.WarmNamespace, error) {
+	var resp []api.WarmNamespace
+	if err := c.do(ctx, http.MethodGet, "/warm-pool/namespaces", nil, &resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
+// ResizeWarmPool overrides the warm pool bounds. Requires the admin token.
+func (c *Client) ResizeWarmPool(ctx context.Context, req api.WarmPoolResizeRequest) (*api.WarmPoolResizeResponse, error) {
+	var resp api.WarmPoolResizeResponse
+	if err := c.do(ctx, http.MethodPost, "/warm-pool/resize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Logs returns the sandbox container's log stream. With follow set the stream stays open
+// until ctx is canceled or the container exits; the client timeout does not apply.
+func (c *Client) Logs(ctx context.Context, id string, follow bool) (io.ReadCloser, error) {
+	path := fmt.Sprintf("/sandboxes/%s/logs?follow=%t", id, follow)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req)
+	hc := *c.client
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return nil, newAPIError(resp.StatusCode, b)
+	}
+	return resp.Body, nil
+}
+
+// outlasting returns c, or a copy of it with a longer client timeout, so that a
+// request the control plane may hold for up to d isn't cut off first. The margin
+// leaves room for the work around the wait.
+func (c *Client) outlasting(d time.Duration) *Client {
+	d += 30 * time.Second
+	if c.client.Timeout <= 0 || c.client.Timeout >= d {
+		return c
+	}
+	cc, hc := *c, *c.client
+	hc.Timeout = d
+	cc.client = &hc
+	return &cc
+}
+
+func (c *Client) setHeaders(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+}
+
 func (c *Client) do(ctx context.Context, method, path string, reqBody any, out any) error {
 	var body io.Reader
 	if reqBody != nil {
@@ -98,6 +684,7 @@ func (c *Client) do(ctx context.Context, method, path string, reqBody any, out a
 	if reqBody != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	c.setHeaders(req)
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
@@ -105,7 +692,7 @@ func (c *Client) do(ctx context.Context, method, path string, reqBody any, out a
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
 		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+		return newAPIError(resp.StatusCode, b)
 	}
 	if out == nil {
 		return nil
@@ -0,0 +1,231 @@
+package sbxclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sandbox/pkg/api"
+)
+
+func TestWithTimeoutIgnoresOptionOrder(t *testing.T) {
+	custom := &http.Client{Timeout: time.Minute}
+	for name, opts := range map[string][]Option{
+		"timeout first": {WithTimeout(5 * time.Second), WithHTTPClient(custom)},
+		"timeout last":  {WithHTTPClient(custom), WithTimeout(5 * time.Second)},
+	} {
+		c := New("http://example", opts...)
+		if c.client.Timeout != 5*time.Second {
+			t.Errorf("%s: timeout = %s, want 5s", name, c.client.Timeout)
+		}
+	}
+	if custom.Timeout != time.Minute {
+		t.Errorf("caller's client was modified: timeout = %s", custom.Timeout)
+	}
+	if c := New("http://example"); c.client.Timeout != 30*time.Second {
+		t.Errorf("default timeout = %s, want 30s", c.client.Timeout)
+	}
+}
+
+func TestExecDetachableOutlastsClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server holds a sync exec for up to detach_after.
+		time.Sleep(150 * time.Millisecond)
+		w.Write([]byte(`{"exit_code":0}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithTimeout(50*time.Millisecond))
+	if _, err := c.ExecDetachable(context.Background(), "sbx-a", api.ExecRequest{Command: []string{"true"}}, 100*time.Millisecond); err != nil {
+		t.Fatalf("ExecDetachable: %v", err)
+	}
+	if c.client.Timeout != 50*time.Millisecond {
+		t.Errorf("client timeout changed to %s", c.client.Timeout)
+	}
+}
+
+func TestWaitOutlastsClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		w.Write([]byte(`{"ready":true,"attempts":3}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithTimeout(50*time.Millisecond))
+	resp, err := c.Wait(context.Background(), "sbx-a", api.WaitRequest{Command: []string{"true"}, Timeout: "100ms"})
+	if err != nil || !resp.Ready {
+		t.Fatalf("Wait = %+v, %v", resp, err)
+	}
+}
+
+func TestForceDeleteOutlastsClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server waits for the namespace before removing finalizers.
+		time.Sleep(150 * time.Millisecond)
+		w.Write([]byte(`{"status":"deleted","forced":"true"}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithTimeout(50*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.ForceDelete(ctx, "sbx-a"); err != nil {
+		t.Fatalf("ForceDelete: %v", err)
+	}
+}
+
+func TestOptionsSetHeaders(t *testing.T) {
+	var auth, ua string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, ua = r.Header.Get("Authorization"), r.Header.Get("User-Agent")
+		w.Write([]byte(`{"status":"deleted"}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL+"/", WithToken("secret"), WithUserAgent("ci/1.0"))
+	if err := c.Delete(context.Background(), "sbx-a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if auth != "Bearer secret" || ua != "ci/1.0" {
+		t.Errorf("headers = %q, %q", auth, ua)
+	}
+}
+
+func TestPoolOptions(t *testing.T) {
+	c := New("http://example", WithMaxIdleConnsPerHost(200), WithIdleConnTimeout(time.Minute), WithKeepAlive(-1))
+	tr, ok := c.client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("transport = %T, want *http.Transport", c.client.Transport)
+	}
+	if tr.MaxIdleConnsPerHost != 200 || tr.MaxIdleConns < 200 || tr.IdleConnTimeout != time.Minute {
+		t.Errorf("transport pool = %d/%d/%s, want 200 per host and 1m idle timeout", tr.MaxIdleConnsPerHost, tr.MaxIdleConns, tr.IdleConnTimeout)
+	}
+	if tr := New("http://example").client.Transport.(*http.Transport); tr.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+		t.Errorf("default MaxIdleConnsPerHost = %d, want %d", tr.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	}
+}
+
+// BenchmarkConcurrentRequests compares new connections per request between Go's
+// stock transport and the default pooled one, with 32 requests in flight.
+func BenchmarkConcurrentRequests(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"stock", []Option{WithHTTPClient(&http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()})}},
+		{"pooled", nil},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			var dials atomic.Int64
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"status":"deleted"}`))
+			}))
+			srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					dials.Add(1)
+				}
+			}
+			srv.Start()
+			defer srv.Close()
+			c := New(srv.URL, tc.opts...)
+			b.SetParallelism(32 / runtime.GOMAXPROCS(0))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := c.Delete(context.Background(), "sbx-a"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(dials.Load())/float64(b.N), "dials/op")
+		})
+	}
+}
+
+// paginatingServer serves n sandboxes, sbx-00 to sbx-<n-1>, the way the control
+// plane does: a bare array without ?limit, pages with continue tokens with it.
+func paginatingServer(t *testing.T, n int, queries *[]string) *httptest.Server {
+	var all []api.SandboxStatus
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("sbx-%02d", i)
+		all = append(all, api.SandboxStatus{ID: id, Namespace: id, State: "Active"})
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*queries = append(*queries, r.URL.RawQuery)
+		q := r.URL.Query()
+		if q.Get("limit") == "" && q.Get("continue") == "" {
+			json.NewEncoder(w).Encode(all)
+			return
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		var items []api.SandboxStatus
+		for _, s := range all {
+			if s.ID > q.Get("continue") {
+				items = append(items, s)
+			}
+		}
+		page := api.SandboxList{Items: items}
+		if limit > 0 && len(items) > limit {
+			page.Items, page.Continue = items[:limit], items[limit-1].ID
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestListSandboxesPages(t *testing.T) {
+	var queries []string
+	c := New(paginatingServer(t, 5, &queries).URL)
+	ctx := context.Background()
+
+	page, err := c.ListSandboxes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 5 || page.Continue != "" {
+		t.Errorf("unpaged list = %d items, continue %q; want 5 and none", len(page.Items), page.Continue)
+	}
+
+	page, err = c.ListSandboxes(ctx, ListOptions{Limit: 2, Selector: "team=ci", State: "Active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.Continue != "sbx-01" {
+		t.Errorf("first page = %d items, continue %q; want 2 and sbx-01", len(page.Items), page.Continue)
+	}
+	if got, want := queries[len(queries)-1], "limit=2&selector=team%3Dci&state=Active"; got != want {
+		t.Errorf("query = %q, want %q", got, want)
+	}
+
+	queries = nil
+	all, err := c.ListAllSandboxes(ctx, ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 || all[0].ID != "sbx-00" || all[4].ID != "sbx-04" {
+		t.Errorf("ListAllSandboxes = %+v, want sbx-00..sbx-04", all)
+	}
+	if len(queries) != 3 {
+		t.Errorf("ListAllSandboxes made %d requests, want 3 pages: %v", len(queries), queries)
+	}
+}
+
+func TestStatusJoinsLists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"phase":"Running","allowed_hosts":["github.com","pypi.org"],"disallowed_hosts":[]}`))
+	}))
+	defer srv.Close()
+	st, err := New(srv.URL).Status(context.Background(), "sbx-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st["phase"] != "Running" || st["allowed_hosts"] != "github.com,pypi.org" || st["disallowed_hosts"] != "" {
+		t.Errorf("status = %v", st)
+	}
+}
@@ -0,0 +1,69 @@
+package sbxclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIError is returned for any response with a status code >= 400.
+type APIError struct {
+	StatusCode int
+	// Code is the control plane's machine-readable cause, e.g. "sandbox_not_ready"
+	// or "exec_not_found". It is empty for errors without a specific cause.
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		return fmt.Sprintf("%s: %s: %s", status, e.Code, e.Message)
+	}
+	if e.Message == "" {
+		return status
+	}
+	return fmt.Sprintf("%s: %s", status, e.Message)
+}
+
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode}
+	var parsed struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil && (parsed.Error != "" || parsed.Code != "") {
+		apiErr.Code = parsed.Code
+		apiErr.Message = parsed.Error
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(body))
+	return apiErr
+}
+
+// StatusCode returns the HTTP status of an APIError, or 0 if err is not one.
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+func IsConflict(err error) bool {
+	return StatusCode(err) == http.StatusConflict
+}
+
+func IsBadRequest(err error) bool {
+	return StatusCode(err) == http.StatusBadRequest
+}
+
+func IsTooManyRequests(err error) bool {
+	return StatusCode(err) == http.StatusTooManyRequests
+}
@@ -0,0 +1,79 @@
+package sbxclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantCode    string
+		wantMessage string
+		wantError   string
+	}{
+		{
+			name:   "code and message",
+			status: http.StatusNotFound, body: `{"error":"exec not found","code":"exec_not_found"}`,
+			wantCode: "exec_not_found", wantMessage: "exec not found",
+			wantError: "404 Not Found: exec_not_found: exec not found",
+		},
+		{
+			name:   "message only",
+			status: http.StatusBadRequest, body: `{"error":"bad id"}`,
+			wantMessage: "bad id", wantError: "400 Bad Request: bad id",
+		},
+		{
+			name:   "plain text body",
+			status: http.StatusBadGateway, body: "upstream down\n",
+			wantMessage: "upstream down", wantError: "502 Bad Gateway: upstream down",
+		},
+		{
+			name:      "empty body",
+			status:    http.StatusServiceUnavailable,
+			wantError: "503 Service Unavailable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newAPIError(tt.status, []byte(tt.body))
+			if err.StatusCode != tt.status || err.Code != tt.wantCode || err.Message != tt.wantMessage {
+				t.Errorf("got %+v, want status %d code %q message %q", err, tt.status, tt.wantCode, tt.wantMessage)
+			}
+			if err.Error() != tt.wantError {
+				t.Errorf("Error() = %q, want %q", err.Error(), tt.wantError)
+			}
+		})
+	}
+}
+
+func TestStatusPredicates(t *testing.T) {
+	wrapped := func(status int) error {
+		return fmt.Errorf("get sandbox: %w", &APIError{StatusCode: status})
+	}
+	tests := []struct {
+		name string
+		is   func(error) bool
+		err  error
+		want bool
+	}{
+		{"not found", IsNotFound, wrapped(http.StatusNotFound), true},
+		{"not found on conflict", IsNotFound, wrapped(http.StatusConflict), false},
+		{"conflict", IsConflict, wrapped(http.StatusConflict), true},
+		{"bad request", IsBadRequest, wrapped(http.StatusBadRequest), true},
+		{"too many requests", IsTooManyRequests, wrapped(http.StatusTooManyRequests), true},
+		{"other error", IsNotFound, errors.New("connection refused"), false},
+		{"nil", IsNotFound, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.is(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package sbxclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"sandbox/pkg/api"
+)
+
+// SandboxClient is the method set of Client. Code that takes a SandboxClient
+// instead of a *Client can be tested against sbxclienttest.Fake.
+type SandboxClient interface {
+	Create(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error)
+	Plan(ctx context.Context, req api.CreateSandboxRequest) (*api.CreatePlan, error)
+	CreateWait(ctx context.Context, req api.CreateSandboxRequest, timeout time.Duration) (*api.CreateSandboxResponse, error)
+	CreateQueued(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error)
+	Exec(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecQueued(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecOrdered(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecCached(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecDetachable(ctx context.Context, id string, req api.ExecRequest, detachAfter time.Duration) (*api.ExecResponse, error)
+	Wait(ctx context.Context, id string, req api.WaitRequest) (*api.WaitResponse, error)
+	ExecStatus(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error)
+	BulkExec(ctx context.Context, selector string, req api.ExecRequest) (*api.BulkExecResponse, error)
+	Batch(ctx context.Context, ops []api.BatchOp, parallel bool) (*api.BatchResponse, error)
+	ExecOutput(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error)
+	ExecStatusWait(ctx context.Context, id, execID string, timeout time.Duration) (*api.ExecStatusResponse, error)
+	ExecLogs(ctx context.Context, id, execID string) (*api.ExecLogsResponse, error)
+	CancelExec(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error)
+	SignalExec(ctx context.Context, id, execID, signal string) (*api.ExecStatusResponse, error)
+	Delete(ctx context.Context, id string) error
+	ForceDelete(ctx context.Context, id string) error
+	DeleteKeepNamespace(ctx context.Context, id string) error
+	Update(ctx context.Context, id string, req api.UpdateSandboxRequest) (*api.UpdateSandboxResponse, error)
+	Archive(ctx context.Context, id string) error
+	Touch(ctx context.Context, id string) error
+	Unarchive(ctx context.Context, id string) error
+	Status(ctx context.Context, id string) (map[string]string, error)
+	ListSandboxes(ctx context.Context, opts ...ListOptions) (*api.SandboxList, error)
+	CountSandboxes(ctx context.Context, opts ListOptions) (*api.SandboxCount, error)
+	ListAllSandboxes(ctx context.Context, opts ListOptions) ([]api.SandboxStatus, error)
+	Usage(ctx context.Context, id string) (*api.SandboxUsage, error)
+	Stats(ctx context.Context) (*api.StatsResponse, error)
+	Metrics(ctx context.Context) (map[string]json.RawMessage, error)
+	Env(ctx context.Context, id string) (*api.SandboxEnvResponse, error)
+	Manifest(ctx context.Context, id string) (*api.CreateSandboxRequest, error)
+	Config(ctx context.Context) (*api.ConfigResponse, error)
+	Orphans(ctx context.Context) (*api.OrphansResponse, error)
+	Reap(ctx context.Context, dryRun bool) (*api.ReapResponse, error)
+	Drain(ctx context.Context, on bool) (*api.DrainResponse, error)
+	WarmPool(ctx context.Context) (*api.WarmPoolStatus, error)
+	WarmNamespaces(ctx context.Context) ([]api.WarmNamespace, error)
+	ResizeWarmPool(ctx context.Context, req api.WarmPoolResizeRequest) (*api.WarmPoolResizeResponse, error)
+	Logs(ctx context.Context, id string, follow bool) (io.ReadCloser, error)
+}
+
+var _ SandboxClient = (*Client)(nil)
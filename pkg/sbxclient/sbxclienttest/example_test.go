@@ -0,0 +1,81 @@
+package sbxclienttest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sandbox/pkg/api"
+	"sandbox/pkg/sbxclient"
+	"sandbox/pkg/sbxclient/sbxclienttest"
+)
+
+// runTests is code under test: it takes the interface, so it works with both the
+// real client and the fake.
+func runTests(ctx context.Context, c sbxclient.SandboxClient) (int, error) {
+	sb, err := c.Create(ctx, api.CreateSandboxRequest{ID: "ci", Image: "golang:1.22"})
+	if err != nil {
+		return 0, err
+	}
+	defer c.Delete(ctx, sb.ID)
+	sync := false
+	res, err := c.Exec(ctx, sb.ID, api.ExecRequest{Command: []string{"go", "test", "./..."}, Async: &sync})
+	if err != nil {
+		return 0, err
+	}
+	return res.ExitCode, nil
+}
+
+func Example() {
+	fake := sbxclienttest.New()
+	fake.ExecFunc = func(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+		return &api.ExecResponse{Status: "failed", ExitCode: 1, Stdout: "FAIL"}, nil
+	}
+
+	code, err := runTests(context.Background(), fake)
+	fmt.Println(code, err)
+	for _, call := range fake.Calls() {
+		fmt.Println(call.Method)
+	}
+	fmt.Println(fake.Sandboxes())
+	// Output:
+	// 1 <nil>
+	// Create
+	// Exec
+	// Delete
+	// []
+}
+
+func ExampleFake_CallsTo() {
+	fake := sbxclienttest.New()
+	fake.AddSandbox(api.SandboxStatus{ID: "sbx-a"})
+	ctx := context.Background()
+	_, _ = fake.Exec(ctx, "sbx-a", api.ExecRequest{Command: []string{"make", "build"}})
+	_, _ = fake.Exec(ctx, "sbx-a", api.ExecRequest{Command: []string{"make", "test"}})
+
+	for _, call := range fake.CallsTo("Exec") {
+		req := call.Args[1].(api.ExecRequest)
+		fmt.Println(call.Args[0], req.Command)
+	}
+	// Output:
+	// sbx-a [make build]
+	// sbx-a [make test]
+}
+
+func ExampleFake_errors() {
+	fake := sbxclienttest.New()
+	ctx := context.Background()
+
+	_, err := fake.Exec(ctx, "sbx-missing", api.ExecRequest{Command: []string{"true"}})
+	fmt.Println(sbxclient.IsNotFound(err))
+
+	fake.CreateFunc = func(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error) {
+		return nil, &sbxclient.APIError{StatusCode: 503, Code: "draining", Message: "control plane is draining"}
+	}
+	_, err = fake.Create(ctx, api.CreateSandboxRequest{})
+	var apiErr *sbxclient.APIError
+	fmt.Println(errors.As(err, &apiErr), apiErr.Code)
+	// Output:
+	// true
+	// true draining
+}
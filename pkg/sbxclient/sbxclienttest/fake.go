@@ -0,0 +1,803 @@
+// Package sbxclienttest provides Fake, an in-memory sbxclient.SandboxClient for
+// testing code built on the client without a control plane.
+//
+// Out of the box the fake keeps sandboxes and async execs in memory: Create adds
+// a sandbox, Exec runs nothing and reports a completed exec with exit code 0, and
+// calls naming a sandbox or exec it doesn't have fail with a 404 *sbxclient.APIError,
+// so sbxclient.IsNotFound works on them. Setting a method's Func field, e.g.
+// ExecFunc, replaces that behavior. Every call is recorded either way.
+package sbxclienttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sandbox/pkg/api"
+	"sandbox/pkg/sbxclient"
+)
+
+// Call is one recorded method call: the method name and its arguments after ctx.
+type Call struct {
+	Method string
+	Args   []any
+}
+
+// String describes the call, e.g. Exec(sbx-a, {...}), for test failure messages.
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = fmt.Sprintf("%+v", a)
+	}
+	return c.Method + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Fake is an in-memory sbxclient.SandboxClient. The zero value is ready to use,
+// and it is safe for concurrent use. Func fields must be set before the fake is
+// shared between goroutines.
+type Fake struct {
+	CreateFunc              func(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error)
+	PlanFunc                func(ctx context.Context, req api.CreateSandboxRequest) (*api.CreatePlan, error)
+	CreateWaitFunc          func(ctx context.Context, req api.CreateSandboxRequest, timeout time.Duration) (*api.CreateSandboxResponse, error)
+	CreateQueuedFunc        func(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error)
+	ExecFunc                func(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecQueuedFunc          func(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecOrderedFunc         func(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecCachedFunc          func(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error)
+	ExecDetachableFunc      func(ctx context.Context, id string, req api.ExecRequest, detachAfter time.Duration) (*api.ExecResponse, error)
+	WaitFunc                func(ctx context.Context, id string, req api.WaitRequest) (*api.WaitResponse, error)
+	ExecStatusFunc          func(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error)
+	BulkExecFunc            func(ctx context.Context, selector string, req api.ExecRequest) (*api.BulkExecResponse, error)
+	BatchFunc               func(ctx context.Context, ops []api.BatchOp, parallel bool) (*api.BatchResponse, error)
+	ExecOutputFunc          func(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error)
+	ExecStatusWaitFunc      func(ctx context.Context, id, execID string, timeout time.Duration) (*api.ExecStatusResponse, error)
+	ExecLogsFunc            func(ctx context.Context, id, execID string) (*api.ExecLogsResponse, error)
+	CancelExecFunc          func(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error)
+	SignalExecFunc          func(ctx context.Context, id, execID, signal string) (*api.ExecStatusResponse, error)
+	DeleteFunc              func(ctx context.Context, id string) error
+	ForceDeleteFunc         func(ctx context.Context, id string) error
+	DeleteKeepNamespaceFunc func(ctx context.Context, id string) error
+	UpdateFunc              func(ctx context.Context, id string, req api.UpdateSandboxRequest) (*api.UpdateSandboxResponse, error)
+	ArchiveFunc             func(ctx context.Context, id string) error
+	TouchFunc               func(ctx context.Context, id string) error
+	UnarchiveFunc           func(ctx context.Context, id string) error
+	StatusFunc              func(ctx context.Context, id string) (map[string]string, error)
+	ListSandboxesFunc       func(ctx context.Context, opts ...sbxclient.ListOptions) (*api.SandboxList, error)
+	CountSandboxesFunc      func(ctx context.Context, opts sbxclient.ListOptions) (*api.SandboxCount, error)
+	ListAllSandboxesFunc    func(ctx context.Context, opts sbxclient.ListOptions) ([]api.SandboxStatus, error)
+	UsageFunc               func(ctx context.Context, id string) (*api.SandboxUsage, error)
+	StatsFunc               func(ctx context.Context) (*api.StatsResponse, error)
+	MetricsFunc             func(ctx context.Context) (map[string]json.RawMessage, error)
+	EnvFunc                 func(ctx context.Context, id string) (*api.SandboxEnvResponse, error)
+	ManifestFunc            func(ctx context.Context, id string) (*api.CreateSandboxRequest, error)
+	ConfigFunc              func(ctx context.Context) (*api.ConfigResponse, error)
+	OrphansFunc             func(ctx context.Context) (*api.OrphansResponse, error)
+	ReapFunc                func(ctx context.Context, dryRun bool) (*api.ReapResponse, error)
+	DrainFunc               func(ctx context.Context, on bool) (*api.DrainResponse, error)
+	WarmPoolFunc            func(ctx context.Context) (*api.WarmPoolStatus, error)
+	WarmNamespacesFunc      func(ctx context.Context) ([]api.WarmNamespace, error)
+	ResizeWarmPoolFunc      func(ctx context.Context, req api.WarmPoolResizeRequest) (*api.WarmPoolResizeResponse, error)
+	LogsFunc                func(ctx context.Context, id string, follow bool) (io.ReadCloser, error)
+
+	mu        sync.Mutex
+	calls     []Call
+	sandboxes map[string]*sandbox
+	execs     map[string]*api.ExecStatusResponse
+	nextID    int
+}
+
+type sandbox struct {
+	status      api.SandboxStatus
+	labels      map[string]string
+	annotations map[string]string
+	// req is the create request the sandbox came from, returned by Manifest.
+	req api.CreateSandboxRequest
+}
+
+var _ sbxclient.SandboxClient = (*Fake)(nil)
+
+// New returns an empty Fake.
+func New() *Fake {
+	return &Fake{}
+}
+
+// AddSandbox adds a sandbox as if it had been created. Namespace defaults to ID
+// and State to Active.
+func (f *Fake) AddSandbox(s api.SandboxStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addLocked(s)
+}
+
+// Sandboxes returns the IDs of the sandboxes the fake holds, sorted.
+func (f *Fake) Sandboxes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.sandboxes))
+	for id := range f.sandboxes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Calls returns every call made so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls made to method, in order.
+func (f *Fake) CallsTo(method string) []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []Call
+	for _, c := range f.calls {
+		if c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Reset forgets the recorded calls, sandboxes and execs. Func fields are kept.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+	f.sandboxes = nil
+	f.execs = nil
+	f.nextID = 0
+}
+
+func (f *Fake) record(method string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+}
+
+func (f *Fake) addLocked(s api.SandboxStatus) *sandbox {
+	if f.sandboxes == nil {
+		f.sandboxes = map[string]*sandbox{}
+	}
+	if s.Namespace == "" {
+		s.Namespace = s.ID
+	}
+	if s.State == "" {
+		s.State = "Active"
+	}
+	if s.Allocated == "" {
+		s.Allocated = "true"
+	}
+	sb := &sandbox{status: s, labels: map[string]string{}, annotations: map[string]string{}}
+	f.sandboxes[s.ID] = sb
+	return sb
+}
+
+func (f *Fake) getLocked(id string) (*sandbox, error) {
+	if sb, ok := f.sandboxes[id]; ok {
+		return sb, nil
+	}
+	return nil, &sbxclient.APIError{StatusCode: http.StatusNotFound, Code: "sandbox_not_found", Message: "sandbox not found"}
+}
+
+func (f *Fake) exists(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.getLocked(id)
+	return err
+}
+
+func execKey(id, execID string) string {
+	return id + "/" + execID
+}
+
+func (f *Fake) create(req api.CreateSandboxRequest, ready bool) (*api.CreateSandboxResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := req.ID
+	if id == "" {
+		f.nextID++
+		id = "fake-" + strconv.Itoa(f.nextID)
+	}
+	id = "sbx-" + id
+	if sb, ok := f.sandboxes[id]; ok {
+		return &api.CreateSandboxResponse{ID: id, Namespace: sb.status.Namespace, PodName: "sandbox", Existing: true, Ready: ready}, nil
+	}
+	sb := f.addLocked(api.SandboxStatus{ID: id, Age: "0s"})
+	sb.req = req
+	sb.req.ID = strings.TrimPrefix(id, "sbx-")
+	return &api.CreateSandboxResponse{ID: id, Namespace: sb.status.Namespace, PodName: "sandbox", Ready: ready}, nil
+}
+
+// exec answers an exec in the sandbox: a completed run with exit code 0, recorded
+// under a new exec id when it is async. Like the control plane's default, execs are
+// async unless the request sets async to false.
+func (f *Fake) exec(id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.getLocked(id); err != nil {
+		return nil, err
+	}
+	if req.Async != nil && !*req.Async {
+		return &api.ExecResponse{Status: "completed"}, nil
+	}
+	f.nextID++
+	execID := "exec-" + strconv.Itoa(f.nextID)
+	now := time.Now().UTC().Format(time.RFC3339)
+	exitCode := 0
+	if f.execs == nil {
+		f.execs = map[string]*api.ExecStatusResponse{}
+	}
+	f.execs[execKey(id, execID)] = &api.ExecStatusResponse{
+		SandboxID:      id,
+		ExecID:         execID,
+		Status:         "completed",
+		ExitCode:       &exitCode,
+		QueuedAt:       now,
+		StartedAt:      now,
+		FinishedAt:     now,
+		TimeoutSeconds: req.TimeoutSeconds,
+	}
+	return &api.ExecResponse{ExecID: execID, Status: "running"}, nil
+}
+
+func (f *Fake) execStatus(id, execID string) (*api.ExecStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.getLocked(id); err != nil {
+		return nil, err
+	}
+	st, ok := f.execs[execKey(id, execID)]
+	if !ok {
+		return nil, &sbxclient.APIError{StatusCode: http.StatusNotFound, Code: "exec_not_found", Message: "exec not found"}
+	}
+	cp := *st
+	return &cp, nil
+}
+
+func (f *Fake) delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.getLocked(id); err != nil {
+		return err
+	}
+	delete(f.sandboxes, id)
+	for k := range f.execs {
+		if strings.HasPrefix(k, id+"/") {
+			delete(f.execs, k)
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Create(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error) {
+	f.record("Create", req)
+	if f.CreateFunc != nil {
+		return f.CreateFunc(ctx, req)
+	}
+	return f.create(req, false)
+}
+
+func (f *Fake) Plan(ctx context.Context, req api.CreateSandboxRequest) (*api.CreatePlan, error) {
+	f.record("Plan", req)
+	if f.PlanFunc != nil {
+		return f.PlanFunc(ctx, req)
+	}
+	id := "sbx-" + req.ID
+	return &api.CreatePlan{ID: id, Namespace: id, Image: req.Image, ImageSource: "request", VolumeMode: req.VolumeMode, CacheMode: req.CacheMode}, nil
+}
+
+func (f *Fake) CreateWait(ctx context.Context, req api.CreateSandboxRequest, timeout time.Duration) (*api.CreateSandboxResponse, error) {
+	f.record("CreateWait", req, timeout)
+	if f.CreateWaitFunc != nil {
+		return f.CreateWaitFunc(ctx, req, timeout)
+	}
+	return f.create(req, true)
+}
+
+// CreateQueued never queues: the fake always has a free create slot.
+func (f *Fake) CreateQueued(ctx context.Context, req api.CreateSandboxRequest) (*api.CreateSandboxResponse, error) {
+	f.record("CreateQueued", req)
+	if f.CreateQueuedFunc != nil {
+		return f.CreateQueuedFunc(ctx, req)
+	}
+	return f.create(req, false)
+}
+
+func (f *Fake) Exec(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	f.record("Exec", id, req)
+	if f.ExecFunc != nil {
+		return f.ExecFunc(ctx, id, req)
+	}
+	return f.exec(id, req)
+}
+
+func (f *Fake) ExecQueued(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	f.record("ExecQueued", id, req)
+	if f.ExecQueuedFunc != nil {
+		return f.ExecQueuedFunc(ctx, id, req)
+	}
+	async := true
+	req.Async = &async
+	resp, err := f.exec(id, req)
+	if err == nil {
+		resp.Status = "queued"
+	}
+	return resp, err
+}
+
+func (f *Fake) ExecOrdered(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	f.record("ExecOrdered", id, req)
+	if f.ExecOrderedFunc != nil {
+		return f.ExecOrderedFunc(ctx, id, req)
+	}
+	return f.exec(id, req)
+}
+
+func (f *Fake) ExecCached(ctx context.Context, id string, req api.ExecRequest) (*api.ExecResponse, error) {
+	f.record("ExecCached", id, req)
+	if f.ExecCachedFunc != nil {
+		return f.ExecCachedFunc(ctx, id, req)
+	}
+	return f.exec(id, req)
+}
+
+func (f *Fake) ExecDetachable(ctx context.Context, id string, req api.ExecRequest, detachAfter time.Duration) (*api.ExecResponse, error) {
+	f.record("ExecDetachable", id, req, detachAfter)
+	if f.ExecDetachableFunc != nil {
+		return f.ExecDetachableFunc(ctx, id, req, detachAfter)
+	}
+	return f.exec(id, req)
+}
+
+func (f *Fake) Wait(ctx context.Context, id string, req api.WaitRequest) (*api.WaitResponse, error) {
+	f.record("Wait", id, req)
+	if f.WaitFunc != nil {
+		return f.WaitFunc(ctx, id, req)
+	}
+	if err := f.exists(id); err != nil {
+		return nil, err
+	}
+	return &api.WaitResponse{Ready: true, Attempts: 1}, nil
+}
+
+func (f *Fake) ExecStatus(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error) {
+	f.record("ExecStatus", id, execID)
+	if f.ExecStatusFunc != nil {
+		return f.ExecStatusFunc(ctx, id, execID)
+	}
+	return f.execStatus(id, execID)
+}
+
+func (f *Fake) BulkExec(ctx context.Context, selector string, req api.ExecRequest) (*api.BulkExecResponse, error) {
+	f.record("BulkExec", selector, req)
+	if f.BulkExecFunc != nil {
+		return f.BulkExecFunc(ctx, selector, req)
+	}
+	resp := &api.BulkExecResponse{Selector: selector, Results: []api.BulkExecResult{}}
+	for _, id := range f.matching(sbxclient.ListOptions{Selector: selector}) {
+		res, err := f.exec(id, req)
+		if err != nil {
+			continue
+		}
+		resp.Results = append(resp.Results, api.BulkExecResult{ID: id, ExecID: res.ExecID, Status: res.Status})
+	}
+	return resp, nil
+}
+
+func (f *Fake) Batch(ctx context.Context, ops []api.BatchOp, parallel bool) (*api.BatchResponse, error) {
+	f.record("Batch", ops, parallel)
+	if f.BatchFunc != nil {
+		return f.BatchFunc(ctx, ops, parallel)
+	}
+	return &api.BatchResponse{}, nil
+}
+
+func (f *Fake) ExecOutput(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error) {
+	f.record("ExecOutput", id, execID)
+	if f.ExecOutputFunc != nil {
+		return f.ExecOutputFunc(ctx, id, execID)
+	}
+	return f.execStatus(id, execID)
+}
+
+func (f *Fake) ExecStatusWait(ctx context.Context, id, execID string, timeout time.Duration) (*api.ExecStatusResponse, error) {
+	f.record("ExecStatusWait", id, execID, timeout)
+	if f.ExecStatusWaitFunc != nil {
+		return f.ExecStatusWaitFunc(ctx, id, execID, timeout)
+	}
+	return f.execStatus(id, execID)
+}
+
+func (f *Fake) ExecLogs(ctx context.Context, id, execID string) (*api.ExecLogsResponse, error) {
+	f.record("ExecLogs", id, execID)
+	if f.ExecLogsFunc != nil {
+		return f.ExecLogsFunc(ctx, id, execID)
+	}
+	st, err := f.execStatus(id, execID)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ExecLogsResponse{SandboxID: id, ExecID: execID, Source: "registry", Stdout: st.Stdout, Stderr: st.Stderr}, nil
+}
+
+func (f *Fake) CancelExec(ctx context.Context, id, execID string) (*api.ExecStatusResponse, error) {
+	f.record("CancelExec", id, execID)
+	if f.CancelExecFunc != nil {
+		return f.CancelExecFunc(ctx, id, execID)
+	}
+	return f.finishedExec(id, execID)
+}
+
+func (f *Fake) SignalExec(ctx context.Context, id, execID, signal string) (*api.ExecStatusResponse, error) {
+	f.record("SignalExec", id, execID, signal)
+	if f.SignalExecFunc != nil {
+		return f.SignalExecFunc(ctx, id, execID, signal)
+	}
+	return f.finishedExec(id, execID)
+}
+
+// finishedExec answers a cancel or signal the way the control plane does for an
+// exec that already ended, which the fake's always have.
+func (f *Fake) finishedExec(id, execID string) (*api.ExecStatusResponse, error) {
+	if _, err := f.execStatus(id, execID); err != nil {
+		return nil, err
+	}
+	return nil, &sbxclient.APIError{StatusCode: http.StatusConflict, Code: "exec_finished", Message: "exec already finished"}
+}
+
+func (f *Fake) Delete(ctx context.Context, id string) error {
+	f.record("Delete", id)
+	if f.DeleteFunc != nil {
+		return f.DeleteFunc(ctx, id)
+	}
+	return f.delete(id)
+}
+
+func (f *Fake) ForceDelete(ctx context.Context, id string) error {
+	f.record("ForceDelete", id)
+	if f.ForceDeleteFunc != nil {
+		return f.ForceDeleteFunc(ctx, id)
+	}
+	return f.delete(id)
+}
+
+func (f *Fake) DeleteKeepNamespace(ctx context.Context, id string) error {
+	f.record("DeleteKeepNamespace", id)
+	if f.DeleteKeepNamespaceFunc != nil {
+		return f.DeleteKeepNamespaceFunc(ctx, id)
+	}
+	return f.delete(id)
+}
+
+func (f *Fake) Update(ctx context.Context, id string, req api.UpdateSandboxRequest) (*api.UpdateSandboxResponse, error) {
+	f.record("Update", id, req)
+	if f.UpdateFunc != nil {
+		return f.UpdateFunc(ctx, id, req)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, err := f.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	apply := func(dst map[string]string, changes map[string]*string) {
+		for k, v := range changes {
+			if v == nil {
+				delete(dst, k)
+			} else {
+				dst[k] = *v
+			}
+		}
+	}
+	apply(sb.labels, req.Labels)
+	apply(sb.annotations, req.Annotations)
+	return &api.UpdateSandboxResponse{SandboxStatus: sb.status, Labels: copyMap(sb.labels), Annotations: copyMap(sb.annotations)}, nil
+}
+
+func (f *Fake) Archive(ctx context.Context, id string) error {
+	f.record("Archive", id)
+	if f.ArchiveFunc != nil {
+		return f.ArchiveFunc(ctx, id)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, err := f.getLocked(id)
+	if err != nil {
+		return err
+	}
+	sb.status.Archived = true
+	return nil
+}
+
+func (f *Fake) Touch(ctx context.Context, id string) error {
+	f.record("Touch", id)
+	if f.TouchFunc != nil {
+		return f.TouchFunc(ctx, id)
+	}
+	return f.exists(id)
+}
+
+func (f *Fake) Unarchive(ctx context.Context, id string) error {
+	f.record("Unarchive", id)
+	if f.UnarchiveFunc != nil {
+		return f.UnarchiveFunc(ctx, id)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, err := f.getLocked(id)
+	if err != nil {
+		return err
+	}
+	if !sb.status.Archived {
+		return &sbxclient.APIError{StatusCode: http.StatusConflict, Code: "sandbox_not_archived", Message: "sandbox is not archived"}
+	}
+	sb.status.Archived = false
+	return nil
+}
+
+func (f *Fake) Status(ctx context.Context, id string) (map[string]string, error) {
+	f.record("Status", id)
+	if f.StatusFunc != nil {
+		return f.StatusFunc(ctx, id)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, err := f.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	phase, ready := "Running", "true"
+	if sb.status.Archived {
+		phase, ready = "archived", "false"
+	}
+	return map[string]string{"id": id, "namespace": sb.status.Namespace, "pod_name": "sandbox", "phase": phase, "ready": ready}, nil
+}
+
+// matching returns the IDs of the unarchived sandboxes matching opts, sorted. The
+// selector may only hold key=value terms.
+func (f *Fake) matching(opts sbxclient.ListOptions) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	want := map[string]string{}
+	for _, term := range strings.Split(opts.Selector, ",") {
+		if k, v, ok := strings.Cut(term, "="); ok {
+			want[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	var ids []string
+	for id, sb := range f.sandboxes {
+		if sb.status.Archived || (opts.State != "" && sb.status.State != opts.State) {
+			continue
+		}
+		matches := true
+		for k, v := range want {
+			if sb.labels[k] != v {
+				matches = false
+			}
+		}
+		if matches {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *Fake) ListSandboxes(ctx context.Context, opts ...sbxclient.ListOptions) (*api.SandboxList, error) {
+	args := make([]any, len(opts))
+	for i, o := range opts {
+		args[i] = o
+	}
+	f.record("ListSandboxes", args...)
+	if f.ListSandboxesFunc != nil {
+		return f.ListSandboxesFunc(ctx, opts...)
+	}
+	var o sbxclient.ListOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	return f.list(o)
+}
+
+// list pages the matching sandboxes; the continue token is the index of the next one.
+func (f *Fake) list(o sbxclient.ListOptions) (*api.SandboxList, error) {
+	ids := f.matching(o)
+	start := 0
+	if o.Continue != "" {
+		n, err := strconv.Atoi(o.Continue)
+		if err != nil || n < 0 || n > len(ids) {
+			return nil, &sbxclient.APIError{StatusCode: http.StatusBadRequest, Code: "invalid_request", Message: "invalid continue token"}
+		}
+		start = n
+	}
+	end := len(ids)
+	if o.Limit > 0 && start+o.Limit < end {
+		end = start + o.Limit
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := &api.SandboxList{Items: []api.SandboxStatus{}}
+	for _, id := range ids[start:end] {
+		if sb, ok := f.sandboxes[id]; ok {
+			list.Items = append(list.Items, sb.status)
+		}
+	}
+	if end < len(ids) {
+		list.Continue = strconv.Itoa(end)
+	}
+	return list, nil
+}
+
+func (f *Fake) CountSandboxes(ctx context.Context, opts sbxclient.ListOptions) (*api.SandboxCount, error) {
+	f.record("CountSandboxes", opts)
+	if f.CountSandboxesFunc != nil {
+		return f.CountSandboxesFunc(ctx, opts)
+	}
+	ids := f.matching(sbxclient.ListOptions{Selector: opts.Selector, State: opts.State})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := &api.SandboxCount{Total: len(ids), ByState: map[string]int{}}
+	for _, id := range ids {
+		count.ByState[f.sandboxes[id].status.State]++
+	}
+	for _, sb := range f.sandboxes {
+		if sb.status.Archived {
+			count.Archived++
+		}
+	}
+	return count, nil
+}
+
+func (f *Fake) ListAllSandboxes(ctx context.Context, opts sbxclient.ListOptions) ([]api.SandboxStatus, error) {
+	f.record("ListAllSandboxes", opts)
+	if f.ListAllSandboxesFunc != nil {
+		return f.ListAllSandboxesFunc(ctx, opts)
+	}
+	opts.Limit, opts.Continue = 0, ""
+	list, err := f.list(opts)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (f *Fake) Usage(ctx context.Context, id string) (*api.SandboxUsage, error) {
+	f.record("Usage", id)
+	if f.UsageFunc != nil {
+		return f.UsageFunc(ctx, id)
+	}
+	if err := f.exists(id); err != nil {
+		return nil, err
+	}
+	return &api.SandboxUsage{}, nil
+}
+
+func (f *Fake) Stats(ctx context.Context) (*api.StatsResponse, error) {
+	f.record("Stats")
+	if f.StatsFunc != nil {
+		return f.StatsFunc(ctx)
+	}
+	return &api.StatsResponse{}, nil
+}
+
+func (f *Fake) Metrics(ctx context.Context) (map[string]json.RawMessage, error) {
+	f.record("Metrics")
+	if f.MetricsFunc != nil {
+		return f.MetricsFunc(ctx)
+	}
+	return map[string]json.RawMessage{}, nil
+}
+
+func (f *Fake) Env(ctx context.Context, id string) (*api.SandboxEnvResponse, error) {
+	f.record("Env", id)
+	if f.EnvFunc != nil {
+		return f.EnvFunc(ctx, id)
+	}
+	if err := f.exists(id); err != nil {
+		return nil, err
+	}
+	return &api.SandboxEnvResponse{}, nil
+}
+
+func (f *Fake) Manifest(ctx context.Context, id string) (*api.CreateSandboxRequest, error) {
+	f.record("Manifest", id)
+	if f.ManifestFunc != nil {
+		return f.ManifestFunc(ctx, id)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, err := f.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	req := sb.req
+	if req.ID == "" {
+		req.ID = strings.TrimPrefix(sb.status.ID, "sbx-")
+	}
+	return &req, nil
+}
+
+func (f *Fake) Config(ctx context.Context) (*api.ConfigResponse, error) {
+	f.record("Config")
+	if f.ConfigFunc != nil {
+		return f.ConfigFunc(ctx)
+	}
+	return &api.ConfigResponse{}, nil
+}
+
+func (f *Fake) Orphans(ctx context.Context) (*api.OrphansResponse, error) {
+	f.record("Orphans")
+	if f.OrphansFunc != nil {
+		return f.OrphansFunc(ctx)
+	}
+	return &api.OrphansResponse{}, nil
+}
+
+func (f *Fake) Reap(ctx context.Context, dryRun bool) (*api.ReapResponse, error) {
+	f.record("Reap", dryRun)
+	if f.ReapFunc != nil {
+		return f.ReapFunc(ctx, dryRun)
+	}
+	return &api.ReapResponse{Reaped: []api.ReapedSandbox{}, DryRun: dryRun}, nil
+}
+
+func (f *Fake) Drain(ctx context.Context, on bool) (*api.DrainResponse, error) {
+	f.record("Drain", on)
+	if f.DrainFunc != nil {
+		return f.DrainFunc(ctx, on)
+	}
+	return &api.DrainResponse{Draining: on}, nil
+}
+
+func (f *Fake) WarmPool(ctx context.Context) (*api.WarmPoolStatus, error) {
+	f.record("WarmPool")
+	if f.WarmPoolFunc != nil {
+		return f.WarmPoolFunc(ctx)
+	}
+	return &api.WarmPoolStatus{}, nil
+}
+
+func (f *Fake) WarmNamespaces(ctx context.Context) ([]api.WarmNamespace, error) {
+	f.record("WarmNamespaces")
+	if f.WarmNamespacesFunc != nil {
+		return f.WarmNamespacesFunc(ctx)
+	}
+	return []api.WarmNamespace{}, nil
+}
+
+func (f *Fake) ResizeWarmPool(ctx context.Context, req api.WarmPoolResizeRequest) (*api.WarmPoolResizeResponse, error) {
+	f.record("ResizeWarmPool", req)
+	if f.ResizeWarmPoolFunc != nil {
+		return f.ResizeWarmPoolFunc(ctx, req)
+	}
+	return &api.WarmPoolResizeResponse{}, nil
+}
+
+func (f *Fake) Logs(ctx context.Context, id string, follow bool) (io.ReadCloser, error) {
+	f.record("Logs", id, follow)
+	if f.LogsFunc != nil {
+		return f.LogsFunc(ctx, id, follow)
+	}
+	if err := f.exists(id); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func copyMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package sbxclienttest
+
+import (
+	"context"
+	"testing"
+
+	"sandbox/pkg/api"
+	"sandbox/pkg/sbxclient"
+)
+
+func TestFakeAsyncExecIsRecorded(t *testing.T) {
+	f := New()
+	ctx := context.Background()
+	created, err := f.Create(ctx, api.CreateSandboxRequest{ID: "a"})
+	if err != nil || created.ID != "sbx-a" {
+		t.Fatalf("create = %+v, %v", created, err)
+	}
+	if again, _ := f.Create(ctx, api.CreateSandboxRequest{ID: "a"}); !again.Existing {
+		t.Errorf("second create = %+v, want existing", again)
+	}
+	res, err := f.Exec(ctx, "sbx-a", api.ExecRequest{Command: []string{"true"}})
+	if err != nil || res.ExecID == "" {
+		t.Fatalf("exec = %+v, %v", res, err)
+	}
+	st, err := f.ExecStatus(ctx, "sbx-a", res.ExecID)
+	if err != nil || st.Status != "completed" || st.ExitCode == nil || *st.ExitCode != 0 {
+		t.Fatalf("exec status = %+v, %v", st, err)
+	}
+	if _, err := f.CancelExec(ctx, "sbx-a", res.ExecID); sbxclient.StatusCode(err) != 409 {
+		t.Errorf("cancel of a finished exec = %v, want 409", err)
+	}
+	if _, err := f.ExecStatus(ctx, "sbx-a", "exec-nope"); !sbxclient.IsNotFound(err) {
+		t.Errorf("unknown exec = %v, want not found", err)
+	}
+	if err := f.Delete(ctx, "sbx-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ExecStatus(ctx, "sbx-a", res.ExecID); !sbxclient.IsNotFound(err) {
+		t.Errorf("exec of a deleted sandbox = %v, want not found", err)
+	}
+}
+
+func TestFakeListSelectsAndPages(t *testing.T) {
+	f := New()
+	ctx := context.Background()
+	for _, id := range []string{"sbx-a", "sbx-b", "sbx-c"} {
+		f.AddSandbox(api.SandboxStatus{ID: id})
+	}
+	team := "ci"
+	for _, id := range []string{"sbx-a", "sbx-c"} {
+		if _, err := f.Update(ctx, id, api.UpdateSandboxRequest{Labels: map[string]*string{"team": &team}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Archive(ctx, "sbx-b"); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := f.ListSandboxes(ctx, sbxclient.ListOptions{Limit: 1, Selector: "team=ci"})
+	if err != nil || len(page.Items) != 1 || page.Items[0].ID != "sbx-a" || page.Continue == "" {
+		t.Fatalf("first page = %+v, %v", page, err)
+	}
+	all, err := f.ListAllSandboxes(ctx, sbxclient.ListOptions{Selector: "team=ci"})
+	if err != nil || len(all) != 2 || all[1].ID != "sbx-c" {
+		t.Fatalf("all = %+v, %v", all, err)
+	}
+	count, err := f.CountSandboxes(ctx, sbxclient.ListOptions{})
+	if err != nil || count.Total != 2 || count.Archived != 1 {
+		t.Fatalf("count = %+v, %v", count, err)
+	}
+	if got := len(f.CallsTo("Update")); got != 2 {
+		t.Errorf("recorded %d Update calls, want 2", got)
+	}
+}